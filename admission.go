@@ -0,0 +1,213 @@
+package aomt
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+/**
+ * Admission control for the leaves clients insert via POST /v1/leaves (see Server): a request's
+ * leaves are not inserted right away, but queued, and the server inserts the queued leaves in the
+ * background every ServerOptions::EpochInterval, at most ServerOptions::MaxLeavesPerEpoch of them
+ * per epoch, so no client can make an epoch (and thus its append-only proof) arbitrarily large.
+ *
+ * The queue holds at most ServerOptions::MaxQueuedLeaves leaves across clients, and each API key
+ * can queue at most ServerOptions::LeavesPerSecond leaves per second (after a burst of
+ * ServerOptions::LeavesBurst leaves), so neither a flood of inserts nor one misbehaving client
+ * can grow the server's memory: requests whose leaves do not fit get a 429 with a Retry-After
+ * header, and are not queued at all, so clients can retry them as they are.
+ *
+ * NOTE: Queued leaves are only in memory, so they are lost if the server stops before inserting
+ * them. Leaves that cannot be inserted (e.g., because they are already in the tree) are recorded
+ * as rejections (see Epoch::Insert()), which clients can read via GET /v1/rejections.
+ */
+
+const defaultMaxLeavesPerEpoch = 10000
+const defaultEpochInterval = time.Second
+const defaultLeavesPerSecond = 1000
+const defaultLeavesBurst = 1000
+
+type insertLeafJSON struct {
+    Leaf     string `json:"leaf"`
+    DataHash string `json:"dataHash"`
+}
+
+type insertRequestJSON struct {
+    Leaves []insertLeafJSON `json:"leaves"`
+}
+
+type insertResponseJSON struct {
+    NumQueued    int `json:"numQueued"`    // the # of leaves of the request, all of which were queued
+    QueuedLeaves int `json:"queuedLeaves"` // the # of leaves in the queue, including the request's
+}
+
+/**
+ * The queue of leaves waiting to be inserted, and the loop that inserts them.
+ */
+type insertQueue struct {
+    tree              *ConcurrentTree
+    maxLeaves         int
+    maxLeavesPerEpoch int
+    interval          time.Duration
+
+    mu     sync.Mutex
+    leaves []LeafData
+
+    stop chan struct{}
+    done chan struct{}
+}
+
+/**
+ * Starts inserting the leaves queued via push() into 'tree', at most 'maxLeavesPerEpoch' every
+ * 'interval', each batch as its own epoch.
+ */
+func startInsertQueue(tree *ConcurrentTree, maxLeaves int, maxLeavesPerEpoch int, interval time.Duration) *insertQueue {
+    q := &insertQueue{
+        tree:              tree,
+        maxLeaves:         maxLeaves,
+        maxLeavesPerEpoch: maxLeavesPerEpoch,
+        interval:          interval,
+        stop:              make(chan struct{}),
+        done:              make(chan struct{}),
+    }
+    go q._run()
+    return q
+}
+
+func (q *insertQueue) _run() {
+    defer close(q.done)
+
+    ticker := time.NewTicker(q.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-q.stop:
+            return
+        case <-ticker.C:
+            q.insertBatch()
+        }
+    }
+}
+
+/**
+ * Inserts the next (at most maxLeavesPerEpoch) queued leaves as a new epoch. Returns the epoch, or
+ * -1 if no leaf was inserted, and why each leaf of the batch was rejected, if it was (see
+ * ConcurrentTree::TryInsertEpoch()).
+ */
+func (q *insertQueue) insertBatch() (int, []error) {
+    q.mu.Lock()
+    n := minInt(len(q.leaves), q.maxLeavesPerEpoch)
+    batch := q.leaves[:n]
+    q.leaves = q.leaves[n:]
+    if len(q.leaves) == 0 {
+        q.leaves = nil
+    }
+    q.mu.Unlock()
+
+    if len(batch) == 0 {
+        return -1, nil
+    }
+    return q.tree.TryInsertEpoch(batch)
+}
+
+/**
+ * Queues all the leaves, or none of them if they do not all fit. Returns the # of queued leaves
+ * afterwards and true if they were queued.
+ */
+func (q *insertQueue) push(leaves []LeafData) (int, bool) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if len(q.leaves)+len(leaves) > q.maxLeaves {
+        return len(q.leaves), false
+    }
+    q.leaves = append(q.leaves, leaves...)
+    return len(q.leaves), true
+}
+
+/**
+ * Returns the # of queued leaves.
+ */
+func (q *insertQueue) len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    return len(q.leaves)
+}
+
+/**
+ * Stops inserting queued leaves, waiting for the batch being inserted, if any.
+ */
+func (q *insertQueue) Stop() {
+    close(q.stop)
+    <-q.done
+}
+
+func (srv *Server) _handleInsert(w http.ResponseWriter, r *http.Request) {
+    if srv.inserts == nil {
+        _httpError(w, http.StatusNotFound, "this server does not accept inserts")
+        return
+    }
+    if r.Method != http.MethodPost {
+        _httpError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+        return
+    }
+
+    // A request can have at most as many leaves as an epoch, and about 150 bytes of JSON per leaf
+    maxLeaves := minInt(srv.opts.MaxLeavesPerEpoch, srv.opts.LeavesBurst)
+    var req insertRequestJSON
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(maxLeaves)*256+1024)).Decode(&req); err != nil {
+        _httpError(w, http.StatusBadRequest, "invalid leaves: "+err.Error())
+        return
+    }
+    if len(req.Leaves) == 0 {
+        _httpError(w, http.StatusBadRequest, "no leaves")
+        return
+    }
+    if len(req.Leaves) > maxLeaves {
+        _httpError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d leaves can be inserted per request", maxLeaves))
+        return
+    }
+
+    leaves := make([]LeafData, len(req.Leaves))
+    for i, leaf := range req.Leaves {
+        var err error
+        if leaves[i].LeafNo, err = _parseHashHex(leaf.Leaf); err != nil {
+            _httpError(w, http.StatusBadRequest, fmt.Sprintf("leaf %d: %v", i, err))
+            return
+        }
+        if leaves[i].DataHash, err = _parseHashHex(leaf.DataHash); err != nil {
+            _httpError(w, http.StatusBadRequest, fmt.Sprintf("leaf %d: %v", i, err))
+            return
+        }
+    }
+
+    if !srv.insertBuckets[_apiKey(r)].takeN(float64(len(leaves))) {
+        _retryAfter(w, time.Duration(float64(len(leaves))/srv.opts.LeavesPerSecond*float64(time.Second)))
+        _httpError(w, http.StatusTooManyRequests, "leaf rate limit exceeded")
+        return
+    }
+    numQueued, ok := srv.inserts.push(leaves)
+    if !ok {
+        srv.insertBuckets[_apiKey(r)].refund(float64(len(leaves)))
+        _retryAfter(w, srv.opts.EpochInterval)
+        _httpError(w, http.StatusTooManyRequests, fmt.Sprintf("the insert queue is full (%d leaves)", numQueued))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(insertResponseJSON{NumQueued: len(leaves), QueuedLeaves: numQueued})
+}
+
+/**
+ * Tells the client to retry after 'd', in whole seconds.
+ */
+func _retryAfter(w http.ResponseWriter, d time.Duration) {
+    w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds())))))
+}
@@ -0,0 +1,58 @@
+package aomt
+
+import (
+    "testing"
+    "time"
+)
+
+/**
+ * Queued epochs must get append-only proofs like any other committed epoch, and rejections must be
+ * reported for the rejected leaves only.
+ */
+func TestTryInsertEpoch(t *testing.T) {
+    tree := NewTree(257)
+    dummy := _testLeaf(-1)
+    tree.Insert(dummy.LeafNo, dummy.DataHash, nil)
+    tree.RecordEpoch()
+    archive, err := OpenProofArchive(t.TempDir(), ProofArchiveOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tree.SetProofArchive(archive)
+    ct := WrapTree(tree)
+
+    first, errs := ct.TryInsertEpoch([]LeafData{_testLeaf(0), _testLeaf(1)})
+    if first != 1 || errs[0] != nil || errs[1] != nil {
+        t.Fatalf("first epoch is %d with errors %v, expected epoch 1 without errors", first, errs)
+    }
+
+    // The duplicate in the batch and the leaf from the first epoch are rejected
+    second, errs := ct.TryInsertEpoch([]LeafData{_testLeaf(2), _testLeaf(1), _testLeaf(3), _testLeaf(3)})
+    if second != 2 {
+        t.Fatalf("second epoch is %d, expected 2", second)
+    }
+    for i, isRejected := range []bool{false, true, false, true} {
+        if (errs[i] != nil) != isRejected {
+            t.Errorf("leaf %d: got error %v, expected a rejection: %v", i, errs[i], isRejected)
+        }
+    }
+    if n := len(tree.Rejections(time.Time{})); n != 2 {
+        t.Errorf("the tree recorded %d rejections, expected 2", n)
+    }
+
+    env, ok, err := archive.Get(first, second)
+    if err != nil || !ok {
+        t.Fatalf("the second epoch's proof was not archived: %v", err)
+    }
+    if err := env.Verify(tree.NewEmptyTree()); err != nil {
+        t.Fatalf("the second epoch's proof does not verify: %v", err)
+    }
+
+    // No epoch is committed if every leaf is rejected, and another one can begin afterwards
+    if epoch, errs := ct.TryInsertEpoch([]LeafData{_testLeaf(0)}); epoch != -1 || errs[0] == nil {
+        t.Fatalf("got epoch %d with errors %v, expected no epoch and a rejection", epoch, errs)
+    }
+    if epoch, _ := ct.TryInsertEpoch([]LeafData{_testLeaf(4)}); epoch != 3 {
+        t.Fatalf("got epoch %d, expected 3", epoch)
+    }
+}
@@ -0,0 +1,132 @@
+package aomt
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+/**
+ * A reference to an external system that vouches for an epoch's root hash (e.g., a blockchain
+ * transaction that includes it), which verifiers can require before trusting the epoch.
+ * Anchors pin the epoch's root hash rather than just its number, so they cannot be moved to
+ * another root. A list of gossip attestations is stored as one anchor per attestation.
+ */
+type EpochAnchor struct {
+    Epoch   int       `json:"epoch"`
+    Root    string    `json:"root"` // the hex-encoded root hash of the epoch
+    Kind    string    `json:"kind"`
+    Ref     string    `json:"ref"` // e.g., a txid, a base64-encoded timestamp token or an attestation
+    AddedAt time.Time `json:"addedAt"`
+}
+
+// The kinds of anchors
+const (
+    AnchorBlockchainTx      = "blockchain-tx"      // Ref is the txid, prefixed by the chain (e.g., 'btc:<txid>')
+    AnchorTimestampToken    = "timestamp-token"    // Ref is a base64-encoded RFC 3161 timestamp token
+    AnchorGossipAttestation = "gossip-attestation" // Ref is an attestation from a gossip peer
+)
+
+func _isAnchorKind(kind string) bool {
+    return kind == AnchorBlockchainTx || kind == AnchorTimestampToken || kind == AnchorGossipAttestation
+}
+
+/**
+ * Stores the anchors of each epoch durably, in an append-only file with one JSON-encoded anchor
+ * per line. Anchors can only be added, never removed.
+ */
+type AnchorStore struct {
+    mu      sync.Mutex
+    file    *os.File
+    byEpoch map[int][]EpochAnchor
+}
+
+/**
+ * Opens the anchor store at 'path', creating it if it does not exist, and loads its anchors.
+ */
+func OpenAnchorStore(path string) (*AnchorStore, error) {
+    f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+
+    store := &AnchorStore{file: f, byEpoch: make(map[int][]EpochAnchor)}
+    scanner := bufio.NewScanner(f)
+    for lineNo := 1; scanner.Scan(); lineNo++ {
+        var anchor EpochAnchor
+        if err := json.Unmarshal(scanner.Bytes(), &anchor); err != nil {
+            f.Close()
+            return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+        }
+        store.byEpoch[anchor.Epoch] = append(store.byEpoch[anchor.Epoch], anchor)
+    }
+    if err := scanner.Err(); err != nil {
+        f.Close()
+        return nil, err
+    }
+
+    return store, nil
+}
+
+/**
+ * Durably adds an anchor for the epoch with the specified root hash, returning the stored anchor.
+ * Adding an anchor that was already added (i.e., same root, kind and ref) returns the existing one.
+ *
+ * NOTE: The caller must check that 'root' is indeed the root hash of the epoch.
+ */
+func (store *AnchorStore) Add(epoch int, root [32]byte, kind string, ref string) (EpochAnchor, error) {
+    if !_isAnchorKind(kind) {
+        return EpochAnchor{}, fmt.Errorf("unknown anchor kind '%s'", kind)
+    }
+    if ref == "" {
+        return EpochAnchor{}, fmt.Errorf("empty anchor ref")
+    }
+
+    store.mu.Lock()
+    defer store.mu.Unlock()
+
+    for _, anchor := range store.byEpoch[epoch] {
+        if anchor.Root == hashStr(root) && anchor.Kind == kind && anchor.Ref == ref {
+            return anchor, nil
+        }
+    }
+
+    anchor := EpochAnchor{Epoch: epoch, Root: hashStr(root), Kind: kind, Ref: ref, AddedAt: time.Now().UTC()}
+    line, err := json.Marshal(anchor)
+    if err != nil {
+        return EpochAnchor{}, err
+    }
+    if _, err := store.file.Write(append(line, '\n')); err != nil {
+        return EpochAnchor{}, err
+    }
+    if err := store.file.Sync(); err != nil {
+        return EpochAnchor{}, err
+    }
+
+    store.byEpoch[epoch] = append(store.byEpoch[epoch], anchor)
+    return anchor, nil
+}
+
+/**
+ * Returns the anchors of the epoch with the specified root hash, in the order they were added.
+ * Anchors for other roots (e.g., left over from a tree that was rebuilt differently) are skipped.
+ */
+func (store *AnchorStore) Get(epoch int, root [32]byte) []EpochAnchor {
+    store.mu.Lock()
+    defer store.mu.Unlock()
+
+    anchors := []EpochAnchor{}
+    for _, anchor := range store.byEpoch[epoch] {
+        if anchor.Root == hashStr(root) {
+            anchors = append(anchors, anchor)
+        }
+    }
+    return anchors
+}
+
+func (store *AnchorStore) Close() error {
+    return store.file.Close()
+}
@@ -0,0 +1,345 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+)
+
+/**
+ * Stores append-only proofs on disk, indexed by (fromEpoch, toEpoch), so that a server can serve
+ * historical proofs without replaying the tree (see Tree::ProveAppendOnly()). A tree archives the
+ * proof of every epoch it commits (see Tree::SetProofArchive()), and past epochs can be archived
+ * via ProofArchive::Backfill().
+ *
+ * Every proof is stored in its own file, '<fromEpoch>-<toEpoch>.proof', encoded as:
+ *
+ *   magic         ("AOMTARCH", 8 bytes)
+ *   version       (1 byte, currently 1)
+ *   paramsDigest  (32 bytes, see Tree::ParamsDigest())
+ *   fromEpoch     (8 bytes)
+ *   toEpoch       (8 bytes)
+ *   oldRoot       (32 bytes)
+ *   newRoot       (32 bytes)
+ *   proofLen      (8 bytes), followed by the proof (proofLen bytes, see AppendOnlyProof::MarshalAdaptive())
+ *   checksum      (32 bytes, the SHA256 hash of everything above)
+ *
+ * i.e., as a ProofEnvelope, but with the proof in its smallest form rather than as a flat list of
+ * nodes. Once the archive is over its size limit, the proofs that end at the oldest epochs are
+ * deleted first, since auditors that far behind are the rarest.
+ */
+type ProofArchive struct {
+    mu         sync.Mutex
+    dir        string
+    opts       ProofArchiveOptions
+    sizes      map[ProofArchiveKey]int64 // the size of every archived proof's file
+    totalBytes int64
+    numDropped int64 // the # of proofs deleted or not archived because of the size limits
+    lastErr    error // the last error archiving a committed epoch's proof, if any
+}
+
+type ProofArchiveOptions struct {
+    MaxBytes      int64 // the most bytes of proofs to keep, or 0 for no limit
+    MaxProofBytes int64 // the largest proof to archive, or 0 for no limit
+}
+
+type ProofArchiveKey struct {
+    From int `json:"from"`
+    To   int `json:"to"`
+}
+
+type ProofArchiveStats struct {
+    NumProofs  int    `json:"numProofs"`
+    TotalBytes int64  `json:"totalBytes"`
+    NumDropped int64  `json:"numDropped"`
+    LastError  string `json:"lastError,omitempty"`
+}
+
+const proofArchiveMagic = "AOMTARCH"
+const proofArchiveVersion = 1
+const proofArchiveSuffix = ".proof"
+
+/**
+ * Opens the proof archive in directory 'dir', creating it if it does not exist, and indexes the
+ * proofs already in it. Applies the size limits right away, in case they were lowered.
+ */
+func OpenProofArchive(dir string, opts ProofArchiveOptions) (*ProofArchive, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    entries, err := ioutil.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    archive := &ProofArchive{dir: dir, opts: opts, sizes: make(map[ProofArchiveKey]int64)}
+    for _, entry := range entries {
+        var key ProofArchiveKey
+        name := entry.Name()
+        if entry.IsDir() || !strings.HasSuffix(name, proofArchiveSuffix) {
+            continue
+        }
+        if _, err := fmt.Sscanf(strings.TrimSuffix(name, proofArchiveSuffix), "%d-%d", &key.From, &key.To); err != nil {
+            return nil, fmt.Errorf("unexpected file %s in proof archive %s", name, dir)
+        }
+        archive.sizes[key] = entry.Size()
+        archive.totalBytes += entry.Size()
+    }
+
+    if err := archive._enforceLimits(); err != nil {
+        return nil, err
+    }
+    return archive, nil
+}
+
+func (archive *ProofArchive) _path(key ProofArchiveKey) string {
+    return filepath.Join(archive.dir, fmt.Sprintf("%d-%d%s", key.From, key.To, proofArchiveSuffix))
+}
+
+/**
+ * Archives the proof in the envelope, replacing the archived proof between the same epochs, if
+ * any. Proofs over ProofArchiveOptions::MaxProofBytes are dropped rather than archived.
+ */
+func (archive *ProofArchive) Put(env *ProofEnvelope) error {
+    data, err := _marshalArchivedProof(env)
+    if err != nil {
+        return err
+    }
+
+    archive.mu.Lock()
+    defer archive.mu.Unlock()
+
+    if archive.opts.MaxProofBytes > 0 && int64(len(data)) > archive.opts.MaxProofBytes {
+        archive.numDropped++
+        return nil
+    }
+
+    key := ProofArchiveKey{env.FromEpoch, env.ToEpoch}
+    err = _writeFileDurably(archive._path(key), func(w io.Writer) error {
+        _, err := w.Write(data)
+        return err
+    })
+    if err != nil {
+        return err
+    }
+    archive.totalBytes += int64(len(data)) - archive.sizes[key]
+    archive.sizes[key] = int64(len(data))
+    return archive._enforceLimits()
+}
+
+/**
+ * Returns the archived proof between the two epochs, and false if there is none.
+ */
+func (archive *ProofArchive) Get(fromEpoch int, toEpoch int) (*ProofEnvelope, bool, error) {
+    key := ProofArchiveKey{fromEpoch, toEpoch}
+    archive.mu.Lock()
+    _, ok := archive.sizes[key]
+    archive.mu.Unlock()
+    if !ok {
+        return nil, false, nil
+    }
+
+    // The proof may be deleted by the retention policy in the meantime, which is not an error
+    data, err := ioutil.ReadFile(archive._path(key))
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, false, nil
+    }
+    if err != nil {
+        return nil, false, err
+    }
+
+    env, err := _unmarshalArchivedProof(data)
+    if err != nil {
+        return nil, false, fmt.Errorf("archived proof from epoch %d to %d: %v", fromEpoch, toEpoch, err)
+    }
+    if env.FromEpoch != fromEpoch || env.ToEpoch != toEpoch {
+        return nil, false, fmt.Errorf("archived proof from epoch %d to %d is for epochs %d to %d",
+            fromEpoch, toEpoch, env.FromEpoch, env.ToEpoch)
+    }
+    return env, true, nil
+}
+
+/**
+ * Returns the epochs of the archived proofs, sorted by 'To' and then by 'From'.
+ */
+func (archive *ProofArchive) Keys() []ProofArchiveKey {
+    archive.mu.Lock()
+    defer archive.mu.Unlock()
+    return archive._sortedKeys()
+}
+
+func (archive *ProofArchive) _sortedKeys() []ProofArchiveKey {
+    keys := make([]ProofArchiveKey, 0, len(archive.sizes))
+    for key := range archive.sizes {
+        keys = append(keys, key)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].To != keys[j].To {
+            return keys[i].To < keys[j].To
+        }
+        return keys[i].From < keys[j].From
+    })
+    return keys
+}
+
+func (archive *ProofArchive) Stats() ProofArchiveStats {
+    archive.mu.Lock()
+    defer archive.mu.Unlock()
+
+    stats := ProofArchiveStats{NumProofs: len(archive.sizes), TotalBytes: archive.totalBytes, NumDropped: archive.numDropped}
+    if archive.lastErr != nil {
+        stats.LastError = archive.lastErr.Error()
+    }
+    return stats
+}
+
+/**
+ * Deletes the proofs that end at the oldest epochs until the archive fits in
+ * ProofArchiveOptions::MaxBytes. Must be called with the lock held.
+ */
+func (archive *ProofArchive) _enforceLimits() error {
+    if archive.opts.MaxBytes <= 0 || archive.totalBytes <= archive.opts.MaxBytes {
+        return nil
+    }
+    for _, key := range archive._sortedKeys() {
+        if archive.totalBytes <= archive.opts.MaxBytes {
+            break
+        }
+        if err := os.Remove(archive._path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+            return err
+        }
+        archive.totalBytes -= archive.sizes[key]
+        delete(archive.sizes, key)
+        archive.numDropped++
+    }
+    return nil
+}
+
+/**
+ * Archives the proof of every epoch of the tree that is not archived yet, i.e., the proof from
+ * epoch e - 1 to epoch e, for every e > 0. Replays the tree's epochs once. Returns the # of
+ * archived proofs.
+ */
+func (archive *ProofArchive) Backfill(tree *Tree) (int, error) {
+    if tree.NumEpochs() < 2 {
+        return 0, nil
+    }
+
+    numArchived := 0
+    replay := tree.TreeAtEpoch(0)
+    for e := 1; e < tree.NumEpochs(); e++ {
+        archive.mu.Lock()
+        _, ok := archive.sizes[ProofArchiveKey{e - 1, e}]
+        archive.mu.Unlock()
+        // The nodes of the previous epoch must not count as new in this one
+        replay._clearNewNodes()
+        if ok {
+            replay._replayEpoch(tree.epochs[e], nil)
+            continue
+        }
+
+        proofTree := tree.NewEmptyTree()
+        replay._replayEpoch(tree.epochs[e], proofTree)
+        proofTree._compressProofTree()
+        if err := archive.Put(NewProofEnvelope(tree, e-1, e, NewAppendOnlyProof(proofTree))); err != nil {
+            return numArchived, err
+        }
+        numArchived++
+    }
+    return numArchived, nil
+}
+
+/**
+ * Makes the tree archive the append-only proof of every epoch committed via Epoch::Commit() in
+ * 'archive' (or stop archiving them, if 'archive' is nil). Since commits cannot fail, errors are
+ * only reported via ProofArchive::Stats().
+ */
+func (tree *Tree) SetProofArchive(archive *ProofArchive) {
+    tree.archive = archive
+}
+
+/**
+ * Archives the proof of the just-committed epoch, if the tree has an archive.
+ */
+func (tree *Tree) _archiveProof(epoch int, proof *AppendOnlyProof) {
+    if tree.archive == nil || epoch == 0 {
+        return
+    }
+    if err := tree.archive.Put(NewProofEnvelope(tree, epoch-1, epoch, proof)); err != nil {
+        tree.archive.mu.Lock()
+        tree.archive.lastErr = fmt.Errorf("archiving the proof of epoch %d: %v", epoch, err)
+        tree.archive.mu.Unlock()
+    }
+}
+
+func _marshalArchivedProof(env *ProofEnvelope) ([]byte, error) {
+    proofBytes, err := env.Proof.MarshalAdaptive()
+    if err != nil {
+        return nil, err
+    }
+
+    buf := bytes.NewBuffer(make([]byte, 0, proofEnvelopeOverhead+len(proofBytes)))
+    buf.WriteString(proofArchiveMagic)
+    buf.WriteByte(proofArchiveVersion)
+    buf.Write(env.ParamsDigest[:])
+    binary.Write(buf, binary.BigEndian, uint64(env.FromEpoch))
+    binary.Write(buf, binary.BigEndian, uint64(env.ToEpoch))
+    buf.Write(env.OldRoot[:])
+    buf.Write(env.NewRoot[:])
+    binary.Write(buf, binary.BigEndian, uint64(len(proofBytes)))
+    buf.Write(proofBytes)
+
+    checksum := sha256.Sum256(buf.Bytes())
+    buf.Write(checksum[:])
+    return buf.Bytes(), nil
+}
+
+func _unmarshalArchivedProof(data []byte) (*ProofEnvelope, error) {
+    if len(data) < len(proofArchiveMagic) || string(data[:len(proofArchiveMagic)]) != proofArchiveMagic {
+        return nil, errors.New("not an archived proof")
+    }
+    if len(data) < proofEnvelopeOverhead {
+        return nil, fmt.Errorf("truncated archived proof: got %d bytes, but the header alone takes %d", len(data), proofEnvelopeOverhead)
+    }
+    if version := data[8]; version != proofArchiveVersion {
+        return nil, fmt.Errorf("unsupported archived proof version %d (expected %d)", version, proofArchiveVersion)
+    }
+
+    env := &ProofEnvelope{}
+    r := bytes.NewReader(data[9:])
+    var fromEpoch, toEpoch, proofLen uint64
+    r.Read(env.ParamsDigest[:])
+    binary.Read(r, binary.BigEndian, &fromEpoch)
+    binary.Read(r, binary.BigEndian, &toEpoch)
+    r.Read(env.OldRoot[:])
+    r.Read(env.NewRoot[:])
+    binary.Read(r, binary.BigEndian, &proofLen)
+
+    if expected := uint64(proofEnvelopeOverhead) + proofLen; proofLen > uint64(len(data)) || uint64(len(data)) != expected {
+        return nil, fmt.Errorf("truncated or padded archived proof: got %d bytes, expected %d", len(data), expected)
+    }
+
+    body := data[:len(data)-32]
+    var checksum [32]byte
+    copy(checksum[:], data[len(data)-32:])
+    if sha256.Sum256(body) != checksum {
+        return nil, errors.New("archived proof checksum mismatch: the proof is corrupted")
+    }
+
+    env.FromEpoch = int(fromEpoch)
+    env.ToEpoch = int(toEpoch)
+    env.Proof = &AppendOnlyProof{}
+    if err := env.Proof.UnmarshalAdaptive(body[len(body)-int(proofLen):]); err != nil {
+        return nil, fmt.Errorf("decoding proof: %v", err)
+    }
+    return env, nil
+}
@@ -0,0 +1,149 @@
+package aomt
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+)
+
+/**
+ * A directory holding all the files we produce (benchmark CSVs, serialized proofs, snapshots, ...),
+ * named consistently after their kind and the epoch(s) they refer to:
+ *
+ *   <kind>-epoch-<epoch>.<ext>                    e.g., snapshot-epoch-000042.bin
+ *   <kind>-epoch-<fromEpoch>-<toEpoch>.<ext>      e.g., proof-epoch-000041-000042.bin
+ *   <kind>-<name>.<ext>                           e.g., bench-seed-1.csv (not tied to an epoch)
+ */
+type ArtifactDir struct {
+    path string
+}
+
+// The kinds of artifacts
+const (
+    ArtifactBench    = "bench"
+    ArtifactProof    = "proof"
+    ArtifactSnapshot = "snapshot"
+    ArtifactWAL      = "wal"
+    ArtifactRoot     = "root"
+    ArtifactExport   = "export"
+    ArtifactAnchor   = "anchor"
+    ArtifactHeap     = "heap"
+)
+
+type Artifact struct {
+    Kind      string
+    FromEpoch int // -1 if the artifact is not tied to an epoch
+    ToEpoch   int // same as FromEpoch, unless the artifact spans several epochs
+    Name      string
+    Path      string
+    Size      int64
+}
+
+var artifactEpochsRegexp = regexp.MustCompile(`^([a-z]+)-epoch-([0-9]+)(?:-([0-9]+))?\.[a-z]+$`)
+var artifactNamedRegexp = regexp.MustCompile(`^([a-z]+)-(.+)\.[a-z]+$`)
+
+/**
+ * Opens the artifacts directory at 'path', creating it if it does not exist.
+ */
+func OpenArtifactDir(path string) (*ArtifactDir, error) {
+    if err := os.MkdirAll(path, 0755); err != nil {
+        return nil, err
+    }
+    return &ArtifactDir{path: path}, nil
+}
+
+/**
+ * Returns the path of the artifact of the specified kind for the specified epoch.
+ */
+func (dir *ArtifactDir) EpochPath(kind string, epoch int, ext string) string {
+    return filepath.Join(dir.path, fmt.Sprintf("%s-epoch-%06d.%s", kind, epoch, ext))
+}
+
+/**
+ * Returns the path of the artifact of the specified kind spanning epochs 'fromEpoch' to 'toEpoch'.
+ */
+func (dir *ArtifactDir) EpochRangePath(kind string, fromEpoch int, toEpoch int, ext string) string {
+    return filepath.Join(dir.path, fmt.Sprintf("%s-epoch-%06d-%06d.%s", kind, fromEpoch, toEpoch, ext))
+}
+
+/**
+ * Returns the path of an artifact of the specified kind that is not tied to an epoch.
+ */
+func (dir *ArtifactDir) NamedPath(kind string, name string, ext string) string {
+    return filepath.Join(dir.path, fmt.Sprintf("%s-%s.%s", kind, name, ext))
+}
+
+/**
+ * Lists the artifacts in the directory, sorted by kind and then by epoch. Files not following the
+ * naming scheme are skipped.
+ */
+func (dir *ArtifactDir) List() ([]Artifact, error) {
+    files, err := ioutil.ReadDir(dir.path)
+    if err != nil {
+        return nil, err
+    }
+
+    var artifacts []Artifact
+    for _, f := range files {
+        if f.IsDir() {
+            continue
+        }
+
+        a := Artifact{FromEpoch: -1, ToEpoch: -1, Path: filepath.Join(dir.path, f.Name()), Size: f.Size()}
+        if m := artifactEpochsRegexp.FindStringSubmatch(f.Name()); m != nil {
+            a.Kind = m[1]
+            a.FromEpoch, _ = strconv.Atoi(m[2])
+            a.ToEpoch = a.FromEpoch
+            if m[3] != "" {
+                a.ToEpoch, _ = strconv.Atoi(m[3])
+            }
+        } else if m := artifactNamedRegexp.FindStringSubmatch(f.Name()); m != nil {
+            a.Kind = m[1]
+            a.Name = m[2]
+        } else {
+            continue
+        }
+        artifacts = append(artifacts, a)
+    }
+
+    sort.Slice(artifacts, func(i, j int) bool {
+        if artifacts[i].Kind != artifacts[j].Kind {
+            return artifacts[i].Kind < artifacts[j].Kind
+        }
+        if artifacts[i].FromEpoch != artifacts[j].FromEpoch {
+            return artifacts[i].FromEpoch < artifacts[j].FromEpoch
+        }
+        if artifacts[i].ToEpoch != artifacts[j].ToEpoch {
+            return artifacts[i].ToEpoch < artifacts[j].ToEpoch
+        }
+        return artifacts[i].Name < artifacts[j].Name
+    })
+    return artifacts, nil
+}
+
+/**
+ * Prints the artifacts in the directory, for the 'ls-artifacts' command.
+ */
+func (dir *ArtifactDir) Print() error {
+    artifacts, err := dir.List()
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("%-10s %-15s %12s  %s\n", "KIND", "EPOCHS", "BYTES", "PATH")
+    for _, a := range artifacts {
+        epochs := a.Name
+        if a.FromEpoch >= 0 {
+            epochs = strconv.Itoa(a.FromEpoch)
+            if a.ToEpoch != a.FromEpoch {
+                epochs += "-" + strconv.Itoa(a.ToEpoch)
+            }
+        }
+        fmt.Printf("%-10s %-15s %12d  %s\n", a.Kind, epochs, a.Size, a.Path)
+    }
+    return nil
+}
@@ -0,0 +1,243 @@
+package aomt
+
+import (
+    "crypto/rand"
+    "fmt"
+    "sync"
+    "time"
+)
+
+/**
+ * A self-auditing safety net for long-running servers: a background goroutine that periodically
+ * checks the tree against itself (see Tree::SelfAudit()), so that memory corruption, bugs in
+ * incremental updates or a tampered proof archive are caught by the operator rather than by a
+ * client. Every audit is counted in the server's metrics (see Metrics), and the last audit, along
+ * with why it failed if it did, is reported by SelfAuditor::Last() and /v1/info.
+ */
+
+/**
+ * The outcome of one audit.
+ */
+type AuditResult struct {
+    Time       time.Time     `json:"time"`
+    Took       time.Duration `json:"took"`       // in nanoseconds
+    Epoch      int           `json:"epoch"`      // the latest epoch when the audit ran, -1 if none
+    NumSampled int           `json:"numSampled"` // the # of leaves whose paths were rehashed
+    Error      string        `json:"error,omitempty"`
+}
+
+func (result *AuditResult) Failed() bool {
+    return result.Error != ""
+}
+
+/**
+ * Checks the tree against itself:
+ *
+ *  - that the live root is the latest epoch's root, if there are no pending changes
+ *  - that the append-only proof of the latest epoch verifies between the last two epochs' roots
+ *    (the archived proof, if the tree has a proof archive, see SetProofArchive(), and otherwise one
+ *    computed by replaying the epochs, which rehashes the whole tree)
+ *  - that the paths of 'numSamples' random leaves hash up to the live root, i.e., that every node
+ *    on them is the hash of its children
+ *
+ * Returns the latest epoch (-1 if none), the # of leaves sampled and the first problem found, if
+ * any. The tree must not change while it is audited (see ConcurrentTree::SelfAudit()).
+ */
+func (tree *Tree) SelfAudit(numSamples int) (epoch int, numSampled int, err error) {
+    epoch = len(tree.epochs) - 1
+    // Proving panics if the replayed epochs do not match their recorded roots
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+
+    opts := tree.Options()
+    if epoch >= 0 && len(tree.pending) == 0 && tree.GetRootHash() != tree.GetEpochRoot(epoch) {
+        return epoch, 0, fmt.Errorf("the root %s is not the root %s of epoch %d",
+            hashStr(tree.GetRootHash()), hashStr(tree.GetEpochRoot(epoch)), epoch)
+    }
+
+    if epoch >= 1 {
+        oldRoot, newRoot := tree.GetEpochRoot(epoch-1), tree.GetEpochRoot(epoch)
+        var proof *AppendOnlyProof
+        if tree.archive != nil {
+            env, found, err := tree.archive.Get(epoch-1, epoch)
+            if err != nil {
+                return epoch, 0, fmt.Errorf("reading the archived proof of epoch %d: %v", epoch, err)
+            }
+            if found {
+                if env.OldRoot != oldRoot || env.NewRoot != newRoot {
+                    return epoch, 0, fmt.Errorf("the archived proof of epoch %d is between other roots", epoch)
+                }
+                proof = env.Proof
+            }
+        }
+        if proof == nil {
+            proof = NewAppendOnlyProof(tree.ProveAppendOnly(epoch-1, epoch))
+        }
+        if err := proof.VerifyWithOptions(oldRoot, newRoot, opts); err != nil {
+            return epoch, 0, fmt.Errorf("the append-only proof of epoch %d does not verify: %v", epoch, err)
+        }
+    }
+
+    for i := 0; i < numSamples; i++ {
+        leafNo, ok := tree._randomLeaf()
+        if !ok {
+            break
+        }
+        if err := tree._auditPath(leafNo); err != nil {
+            return epoch, numSampled, err
+        }
+        numSampled++
+    }
+    return epoch, numSampled, nil
+}
+
+/**
+ * Rehashes the path of an existing leaf from the leaf up, checking every node on it against the
+ * hash of its children.
+ */
+func (tree *Tree) _auditPath(leafNo [32]byte) error {
+    proof := tree.ProveMembership(leafNo)
+
+    nodeNo, nodeHash := leafNo, proof.LeafHash
+    for i, siblingHash := range proof.Siblings {
+        isLeft := bitOf(nodeNo, 0) == 0
+        nodeNo = parentOf(nodeNo)
+
+        level := tree.numLevels - 2 - i
+        if isLeft {
+            nodeHash = tree._nodeHashAt(level, nodeNo, nodeHash, siblingHash)
+        } else {
+            nodeHash = tree._nodeHashAt(level, nodeNo, siblingHash, nodeHash)
+        }
+        node := tree.getNode(tree.lvl[level], nodeNo)
+        if node == nil || node.Hash != nodeHash {
+            return fmt.Errorf("on the path of leaf %s, node %s on level %d is not the hash of its children",
+                hashStr(leafNo), hashStr(nodeNo), level)
+        }
+    }
+    return nil
+}
+
+/**
+ * Returns the first leaf at or after a random leaf no (wrapping around), or false if the tree has
+ * no leaves.
+ */
+func (tree *Tree) _randomLeaf() ([32]byte, bool) {
+    var start [32]byte
+    if _, err := rand.Read(start[:]); err != nil {
+        panic("Error generating random leaf no: " + err.Error())
+    }
+    start = rshBytes(start, uint(maxNumLevels-tree.numLevels))
+
+    it := tree._newLeafIterator(start, [32]byte{}, false)
+    if !it.Next() {
+        it = tree.Leaves()
+        if !it.Next() {
+            return [32]byte{}, false
+        }
+    }
+    leafNo, _ := it.Leaf()
+    return leafNo, true
+}
+
+/**
+ * Audits the tree while holding the read lock, or the write lock if the tree is pruned, since the
+ * pruned subtrees on the sampled leaves' paths are rebuilt (see Tree::SelfAudit()).
+ */
+func (ct *ConcurrentTree) SelfAudit(numSamples int) (int, int, error) {
+    defer ct._lockForProofs()()
+
+    return ct.tree.SelfAudit(numSamples)
+}
+
+/**
+ * Audits a tree every 'interval' in a background goroutine, until stopped.
+ */
+type SelfAuditor struct {
+    tree       *ConcurrentTree
+    interval   time.Duration
+    numSamples int
+    metrics    *Metrics // nil if audits are not counted
+
+    mu   sync.Mutex
+    last *AuditResult // nil until the first audit is done
+
+    stop chan struct{}
+    done chan struct{}
+}
+
+/**
+ * Starts auditing the tree every 'interval', sampling 'numSamples' leaves per audit and counting
+ * the audits in 'metrics' (which can be nil).
+ */
+func StartSelfAuditor(tree *ConcurrentTree, interval time.Duration, numSamples int, metrics *Metrics) *SelfAuditor {
+    auditor := &SelfAuditor{
+        tree:       tree,
+        interval:   interval,
+        numSamples: numSamples,
+        metrics:    metrics,
+        stop:       make(chan struct{}),
+        done:       make(chan struct{}),
+    }
+    go auditor._run()
+    return auditor
+}
+
+func (auditor *SelfAuditor) _run() {
+    defer close(auditor.done)
+
+    ticker := time.NewTicker(auditor.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-auditor.stop:
+            return
+        case <-ticker.C:
+            auditor.RunOnce()
+        }
+    }
+}
+
+/**
+ * Audits the tree now, records the result as the last one and returns it. Failures are not printed
+ * (this runs on the auditor's goroutine), but recorded in the result's Error.
+ */
+func (auditor *SelfAuditor) RunOnce() AuditResult {
+    start := time.Now()
+    epoch, numSampled, err := auditor.tree.SelfAudit(auditor.numSamples)
+    result := AuditResult{Time: start, Took: time.Since(start), Epoch: epoch, NumSampled: numSampled}
+    if err != nil {
+        result.Error = err.Error()
+    }
+    auditor.metrics._observeAudit(result.Failed())
+
+    auditor.mu.Lock()
+    auditor.last = &result
+    auditor.mu.Unlock()
+    return result
+}
+
+/**
+ * Returns the result of the last audit, or nil if none was done yet.
+ */
+func (auditor *SelfAuditor) Last() *AuditResult {
+    auditor.mu.Lock()
+    defer auditor.mu.Unlock()
+
+    if auditor.last == nil {
+        return nil
+    }
+    result := *auditor.last
+    return &result
+}
+
+/**
+ * Stops auditing, waiting for the audit in progress (if any) to finish.
+ */
+func (auditor *SelfAuditor) Stop() {
+    close(auditor.stop)
+    <-auditor.done
+}
@@ -0,0 +1,172 @@
+package aomt
+
+import (
+    "errors"
+    "fmt"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/**
+ * The epochs and root hashes an append-only proof is checked against in VerifyBatch().
+ */
+type RootPair struct {
+    FromEpoch int
+    ToEpoch   int
+    OldRoot   [32]byte
+    NewRoot   [32]byte
+}
+
+type BatchVerifyOptions struct {
+    Tree TreeOptions // the options of the tree the proofs are about, see VerifyWithOptions()
+
+    // The # of goroutines verifying proofs, or 0 for one per CPU
+    Workers int
+
+    // If true, the proofs after one that failed are not verified, so a monitor that would stop
+    // at the first bad epoch anyway does not wait for the rest. The proofs before it still are.
+    StopOnFailure bool
+}
+
+/**
+ * The outcome of VerifyBatch(): which proofs failed and why.
+ */
+type BatchVerifyReport struct {
+    NumProofs   int
+    NumVerified int                  // less than NumProofs if the batch stopped early (see StopOnFailure)
+    Failures    []BatchVerifyFailure // sorted by index
+    Elapsed     time.Duration
+}
+
+type BatchVerifyFailure struct {
+    Index int // the index of the proof in the batch
+    Pair  RootPair
+    Err   error
+}
+
+/**
+ * Returns true if every proof was verified and none failed.
+ */
+func (report *BatchVerifyReport) OK() bool {
+    return report.NumVerified == report.NumProofs && len(report.Failures) == 0
+}
+
+/**
+ * Returns nil if the report is OK, or an error listing the failures.
+ */
+func (report *BatchVerifyReport) Err() error {
+    if report.OK() {
+        return nil
+    }
+
+    var lines []string
+    for _, failure := range report.Failures {
+        lines = append(lines, fmt.Sprintf("epoch %d to %d: %v", failure.Pair.FromEpoch, failure.Pair.ToEpoch, failure.Err))
+    }
+    if skipped := report.NumProofs - report.NumVerified; skipped > 0 {
+        lines = append(lines, fmt.Sprintf("%d proofs were not verified", skipped))
+    }
+    return fmt.Errorf("%d of %d append-only proofs failed: %s", len(report.Failures), report.NumProofs, strings.Join(lines, "; "))
+}
+
+/**
+ * Verifies the append-only proofs of a tree that uses SHA256 (i.e., created via NewTree()), each
+ * against its RootPair in 'roots', e.g., for a monitor catching up on many epochs. See
+ * VerifyBatchWithOptions().
+ */
+func VerifyBatch(proofs []*AppendOnlyProof, roots []RootPair) (*BatchVerifyReport, error) {
+    return VerifyBatchWithOptions(proofs, roots, BatchVerifyOptions{})
+}
+
+/**
+ * Verifies the append-only proofs in parallel, each against its RootPair in 'roots', and reports
+ * every proof that failed. Consecutive pairs (i.e., where a pair starts at the epoch the previous
+ * one ends at) must also link, i.e., start at the root the previous one ends at, so that a server
+ * cannot prove each step of a forked history. Returns an error, rather than a report, only if the
+ * batch itself is invalid.
+ */
+func VerifyBatchWithOptions(proofs []*AppendOnlyProof, roots []RootPair, opts BatchVerifyOptions) (*BatchVerifyReport, error) {
+    if len(proofs) != len(roots) {
+        return nil, fmt.Errorf("got %d proofs but %d root pairs", len(proofs), len(roots))
+    }
+    if _, err := opts.Tree._numLevels(); err != nil {
+        return nil, err
+    }
+    workers := opts.Workers
+    if workers <= 0 {
+        workers = runtime.NumCPU()
+    }
+
+    startTime := time.Now()
+    report := &BatchVerifyReport{NumProofs: len(proofs)}
+
+    // With StopOnFailure, the proofs after 'firstFailed' are skipped
+    firstFailed := int64(len(proofs))
+    var mu sync.Mutex
+    fail := func(i int, err error) {
+        mu.Lock()
+        defer mu.Unlock()
+        report.Failures = append(report.Failures, BatchVerifyFailure{Index: i, Pair: roots[i], Err: err})
+        if opts.StopOnFailure && int64(i) < atomic.LoadInt64(&firstFailed) {
+            atomic.StoreInt64(&firstFailed, int64(i))
+        }
+    }
+
+    // The links between pairs are cheap to check, so they are checked first and count as failures
+    // of the later pair
+    for i := 1; i < len(roots); i++ {
+        prev, pair := roots[i-1], roots[i]
+        if pair.FromEpoch == prev.ToEpoch && !hashEqual(pair.OldRoot, prev.NewRoot) {
+            fail(i, fmt.Errorf("starts at a different root than the one epoch %d ended at", prev.ToEpoch))
+        }
+    }
+
+    // Hand out the proofs in order, so that the ones after a failure are likely not started yet
+    work := make(chan int, len(proofs))
+    for i := range proofs {
+        work <- i
+    }
+    close(work)
+
+    var numVerified int64
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range work {
+                if int64(i) > atomic.LoadInt64(&firstFailed) {
+                    continue
+                }
+
+                err := _verifyBatchProof(proofs[i], roots[i], opts.Tree)
+                atomic.AddInt64(&numVerified, 1)
+                if err != nil {
+                    fail(i, err)
+                }
+            }
+        }()
+    }
+    wg.Wait()
+
+    report.NumVerified = int(numVerified)
+    sort.SliceStable(report.Failures, func(i, j int) bool {
+        return report.Failures[i].Index < report.Failures[j].Index
+    })
+    report.Elapsed = time.Since(startTime)
+    return report, nil
+}
+
+func _verifyBatchProof(proof *AppendOnlyProof, pair RootPair, opts TreeOptions) error {
+    if proof == nil {
+        return errors.New("missing proof")
+    }
+    if pair.FromEpoch < 0 || pair.ToEpoch <= pair.FromEpoch {
+        return fmt.Errorf("invalid epochs %d to %d", pair.FromEpoch, pair.ToEpoch)
+    }
+    return proof.VerifyWithOptions(pair.OldRoot, pair.NewRoot, opts)
+}
@@ -0,0 +1,322 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "io/ioutil"
+    "math/big"
+    "os"
+    "path/filepath"
+    "runtime/pprof"
+    "strings"
+)
+
+/**
+ * Options for the hashsparse() and hashcompact() benchmarks.
+ */
+type BenchOptions struct {
+    Append bool // append rows to the CSV file instead of truncating it
+    Resume bool // skip the sizes already in the CSV file and rebuild the tree up to the last one (implies Append)
+
+    // If not nil, the CSV file is stored here and every batch's append-only proof is saved here too
+    Artifacts *ArtifactDir
+
+    // How many times to measure proof building, compression and verification for every batch, so
+    // the CSV file has the mean and standard deviation of each (at least once)
+    Repeat int
+
+    // If true, a pprof heap profile is saved to the artifacts directory after every batch
+    HeapProfiles bool
+
+    // The # of top levels of the tree to cache, see TreeOptions::CachedLevels
+    CachedLevels int
+
+    // The # of nodes per slab, see TreeOptions::SlabSize
+    SlabSize int
+
+    // The most nodes an epoch's proof tree has before it is compressed, see
+    // TreeOptions::ProofTreeMaxNodes
+    ProofTreeMaxNodes int
+
+    // The leaves to insert, see leafSource (the uniform ones if empty), and whether their keys are
+    // used as leaf no's rather than hashed
+    Workload string
+    RawKeys  bool
+
+    // If not nil, the tree counts its inserts and proofs here and the proofs' verification times are
+    // reported here too (see Metrics)
+    Metrics *Metrics
+
+    // Skip parts of hashsparse(), so that the CSV file isolates the cost of the rest: NoProofs
+    // inserts the leaves without building append-only proofs (so there is nothing to compress or
+    // verify either), NoVerify does not verify the proofs and NoCompress does not compress them
+    // (which implies NoVerify, since uncompressed proofs do not verify). The skipped measurements
+    // are left empty in the CSV file.
+    NoProofs   bool
+    NoVerify   bool
+    NoCompress bool
+
+    // If DotFile is not empty, the proof tree of batch # DotBatch (numbered from 1, as the sizes
+    // are) is written there in DOT format (see Tree::ExportDOT()), after it is compressed
+    DotFile  string
+    DotBatch int
+
+    // If true, every batch is also inserted in a reference tree (see referenceTree), whose root
+    // hash is recomputed from scratch and checked against the tree's after every epoch, as are the
+    // batch's append-only proofs. This is slow, so the CSV file's timings are not representative.
+    Paranoid bool
+
+    // The format of the results, OutputCSV (the default, if empty) or OutputJSON (see CsvWriter)
+    OutputFormat string
+
+    // If true, hashsparse() does not panic when a batch fails a check (e.g., its proof does not
+    // verify), but records the failed checks in the batch's row (see benchChecks) and goes on with
+    // the next batch, so that overnight sweeps are not lost to the first anomaly
+    KeepGoing bool
+}
+
+func (opts *BenchOptions) _numRepeats() int {
+    if opts.Repeat < 1 {
+        return 1
+    }
+    return opts.Repeat
+}
+
+func (opts *BenchOptions) _outputFormat() string {
+    if opts.OutputFormat == "" {
+        return OutputCSV
+    }
+    return opts.OutputFormat
+}
+
+func (opts *BenchOptions) _skipVerify() bool {
+    return opts.NoVerify || opts.NoCompress || opts.NoProofs
+}
+
+/**
+ * The checks of one benchmark batch that failed, by name (e.g., 'verify' or 'reference-root'),
+ * which is how they are recorded in the 'validationFailed' column. Panics on the first failure
+ * instead, unless BenchOptions::KeepGoing is set.
+ */
+type benchChecks struct {
+    keepGoing bool
+    failed    []string
+}
+
+/**
+ * Records the failure of check 'name' if 'err' is not nil. Returns true if the check passed.
+ */
+func (checks *benchChecks) check(name string, err error) bool {
+    if err == nil {
+        return true
+    }
+    if !checks.keepGoing {
+        panic(err.Error())
+    }
+
+    fmt.Printf("\nCHECK FAILED (%s): %v\n", name, err)
+    checks.failed = append(checks.failed, name)
+    return false
+}
+
+/**
+ * Returns 'err' with 'prefix' prepended to its message, or nil if 'err' is nil.
+ */
+func _prefixErr(prefix string, err error) error {
+    if err == nil {
+        return nil
+    }
+    return fmt.Errorf("%s%v", prefix, err)
+}
+
+/**
+ * Returns the names of the failed checks as one CSV cell, whose names are separated by ';'.
+ */
+func (checks *benchChecks) cell() string {
+    return strings.Join(checks.failed, ";")
+}
+
+/**
+ * Returns the path of the benchmark's CSV file, which is in the artifacts directory, if any.
+ */
+func (opts *BenchOptions) _csvPath(csvFile string) string {
+    if opts.Artifacts == nil {
+        return csvFile
+    }
+    ext := "csv"
+    if opts._outputFormat() == OutputJSON {
+        ext = "jsonl"
+    }
+    return opts.Artifacts.NamedPath(ArtifactBench, strings.TrimSuffix(filepath.Base(csvFile), filepath.Ext(csvFile)), ext)
+}
+
+/**
+ * Saves the append-only proof between the two epochs to the artifacts directory, if any.
+ */
+func (opts *BenchOptions) _saveProof(tree *Tree, proof *AppendOnlyProof, fromEpoch int, toEpoch int) {
+    if opts.Artifacts == nil {
+        return
+    }
+
+    data, err := NewProofEnvelope(tree, fromEpoch, toEpoch, proof).MarshalBinary()
+    if err == nil {
+        err = ioutil.WriteFile(opts.Artifacts.EpochRangePath(ArtifactProof, fromEpoch, toEpoch, "bin"), data, 0644)
+    }
+    if err != nil {
+        panic("Error saving proof: " + err.Error())
+    }
+}
+
+/**
+ * Writes a batch's proof tree to BenchOptions::DotFile, see Tree::ExportDOT().
+ */
+func (opts *BenchOptions) _saveProofDOT(proofTree *Tree) {
+    f, err := os.Create(opts.DotFile)
+    if err != nil {
+        panic("Error saving proof tree: " + err.Error())
+    }
+    defer f.Close()
+
+    if err := proofTree.ExportDOT(f, true); err != nil {
+        panic("Error saving proof tree: " + err.Error())
+    }
+    fmt.Printf("Saved proof tree to %s\n", opts.DotFile)
+}
+
+/**
+ * Saves a heap profile (see 'go tool pprof') as of the end of the specified epoch to the artifacts
+ * directory, if enabled. The profile reflects the heap as of the last GC.
+ */
+func (opts *BenchOptions) _saveHeapProfile(epoch int) {
+    if !opts.HeapProfiles || opts.Artifacts == nil {
+        return
+    }
+
+    f, err := os.Create(opts.Artifacts.EpochPath(ArtifactHeap, epoch, "pprof"))
+    if err == nil {
+        err = pprof.WriteHeapProfile(f)
+        if closeErr := f.Close(); err == nil {
+            err = closeErr
+        }
+    }
+    if err != nil {
+        panic("Error saving heap profile: " + err.Error())
+    }
+}
+
+/**
+ * Saves a snapshot of the tree as of the specified epoch to the artifacts directory, if any, so a
+ * resumed benchmark can load it instead of rebuilding the tree.
+ */
+func (opts *BenchOptions) _saveSnapshot(tree *Tree, epoch int) {
+    if opts.Artifacts == nil {
+        return
+    }
+
+    f, err := os.Create(opts.Artifacts.EpochPath(ArtifactSnapshot, epoch, "bin"))
+    if err == nil {
+        err = tree.Snapshot(f)
+        if closeErr := f.Close(); err == nil {
+            err = closeErr
+        }
+    }
+    if err != nil {
+        panic("Error saving snapshot: " + err.Error())
+    }
+}
+
+/**
+ * Loads the snapshot of the tree as of the specified epoch from the artifacts directory, or
+ * returns nil if there is no such snapshot.
+ */
+func (opts *BenchOptions) _loadSnapshot(epoch int) *Tree {
+    if opts.Artifacts == nil {
+        return nil
+    }
+
+    f, err := os.Open(opts.Artifacts.EpochPath(ArtifactSnapshot, epoch, "bin"))
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        panic("Error loading snapshot: " + err.Error())
+    }
+    defer f.Close()
+
+    tree, err := LoadSnapshot(f)
+    if err != nil {
+        panic("Error loading snapshot: " + err.Error())
+    }
+    if tree.NumEpochs() != epoch+1 || tree.NumPendingChanges() != 0 {
+        panic(fmt.Sprintf("Snapshot of epoch %d has %d epochs and %d pending changes", epoch, tree.NumEpochs(), tree.NumPendingChanges()))
+    }
+    return tree
+}
+
+/**
+ * Opens the benchmark's CSV (or JSON) file, truncating it unless we are appending or resuming.
+ */
+func (opts *BenchOptions) _openCsv(csvFile string, header []string) *CsvWriter {
+    // fsync after every batch, so we don't lose results if we run out of memory mid-benchmark
+    if opts.Append || opts.Resume {
+        return AppendResultWriter(opts._csvPath(csvFile), opts._outputFormat(), header, 1)
+    }
+    return NewResultWriter(opts._csvPath(csvFile), opts._outputFormat(), header, 1)
+}
+
+/**
+ * Returns the # of sizes that were already benchmarked, according to the CSV file, if resuming.
+ */
+func (opts *BenchOptions) _numCompletedSizes(csvFile string, sizes []int) int {
+    if !opts.Resume {
+        return 0
+    }
+
+    lastSize, err := LastDictSize(opts._csvPath(csvFile), opts._outputFormat())
+    if err != nil {
+        panic("Error reading CSV file: " + err.Error())
+    }
+    if lastSize == 0 {
+        return 0
+    }
+
+    for i, size := range sizes {
+        if size == lastSize {
+            return i + 1
+        }
+    }
+    panic(fmt.Sprintf("Cannot resume: last size in CSV file (%d) is not one of the sizes %v", lastSize, sizes))
+}
+
+/**
+ * Returns the source of the leaves inserted by the benchmark, see newLeafSource().
+ */
+func (opts *BenchOptions) _newLeafSource(seed int64) leafSource {
+    gen, err := newLeafSource(opts.Workload, opts.RawKeys, seed)
+    if err != nil {
+        panic("Error creating workload: " + err.Error())
+    }
+    return gen
+}
+
+/**
+ * Deterministically generates the leaves inserted by the benchmarks from a PRNG seed, so that
+ * runs can be compared and resumed.
+ */
+type leafGenerator struct {
+    randKey [32]byte
+}
+
+func newLeafGenerator(seed int64) *leafGenerator {
+    // Initialize some bytes that we'll hash repeatedly to obtain leaf no's
+    return &leafGenerator{randKey: bigIntTo32Bytes(big.NewInt(seed))}
+}
+
+/**
+ * Returns the next leaf no and its data hash.
+ */
+func (gen *leafGenerator) next() ([32]byte, [32]byte) {
+    gen.randKey = sha256.Sum256(gen.randKey[:])
+    dataHash := sha256.Sum256([]byte(fmt.Sprintf("Data for leaf %v", hashStr(gen.randKey))))
+    return gen.randKey, dataHash
+}
@@ -0,0 +1,188 @@
+package aomt
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+)
+
+/**
+ * Building a tree of n leaves via Tree::Insert() rehashes the whole path of every leaf, i.e., costs
+ * O(n * numLevels) hashes and map lookups, most of them for nodes that are rehashed again by the
+ * next inserts. BuildFromLeaves() instead builds the tree bottom-up from the leaves sorted by leaf
+ * no, one level at a time: since the nodes of a level are then sorted too, every node is created
+ * and hashed exactly once, from its two (or one) children, and the nodes of a level are hashed in
+ * one batch (see Tree::_merkleHashPairs()).
+ *
+ * Leaf files, e.g., for the 'build' command, are sequences of 64-byte records, one per leaf, made
+ * of the leaf no (32 bytes) followed by the leaf's data hash (32 bytes), sorted by leaf no.
+ */
+
+/**
+ * A stream of leaves, e.g., a tree's LeafIterator or a LeafFileReader.
+ */
+type LeafStream interface {
+    // Advances to the next leaf. Returns false if there are no more leaves (or on an error).
+    Next() bool
+    // Returns the current leaf's leaf no and data hash
+    Leaf() ([32]byte, [32]byte)
+}
+
+// The size of a leaf's record in a leaf file
+const leafRecordSize = 64
+
+/**
+ * Creates a tree with the specified # of levels and options from the leaves of 'leaves', which
+ * must be sorted by leaf no and have no duplicates. The leaves are pending changes of the tree, so
+ * the next epoch recorded via RecordEpoch() consists of them, as if they were inserted one by one.
+ * Returns an error if the leaves are not sorted, do not fit in the tree or cannot be read (for
+ * streams that have an 'Err() error' method, like LeafFileReader), or if the options require leaf
+ * extensions, which leaf streams do not have.
+ */
+func BuildFromLeaves(numLevels int, opts TreeOptions, leaves LeafStream) (*Tree, error) {
+    if opts.LeafExtensions {
+        return nil, fmt.Errorf("cannot build a tree whose leaves must have extensions from a leaf stream")
+    }
+    tree, err := NewTreeWithOptions(numLevels, opts)
+    if err != nil {
+        return nil, err
+    }
+    lastLevel := numLevels - 1
+
+    // The leaves, and then the nodes of each level, as we go up the tree
+    var nodeNos [][32]byte
+    leafLvl := tree.lvl[lastLevel]
+    for leaves.Next() {
+        leafNo, dataHash := leaves.Leaf()
+        if !_fitsInLevel(leafNo, lastLevel) {
+            return nil, fmt.Errorf("leaf no %s is too large for a tree with %d levels", hashStr(leafNo), numLevels)
+        }
+        if n := len(nodeNos); n > 0 && bytes.Compare(nodeNos[n-1][:], leafNo[:]) >= 0 {
+            return nil, fmt.Errorf("leaves are not sorted: leaf %s comes after leaf %s", hashStr(leafNo), hashStr(nodeNos[n-1]))
+        }
+
+        leaf := leafLvl.newNode()
+        leaf.Hash = tree._leafHash(dataHash)
+        tree._putNode(leafLvl, leafNo, leaf)
+        nodeNos = append(nodeNos, leafNo)
+        tree.pending = append(tree.pending, epochLeaf{leafNo: leafNo, dataHash: dataHash})
+    }
+    if withErr, ok := leaves.(interface{ Err() error }); ok && withErr.Err() != nil {
+        return nil, withErr.Err()
+    }
+    if len(nodeNos) == 0 {
+        return tree, nil
+    }
+
+    var pairs []hashPair
+    var hashes [][32]byte
+    for level := lastLevel - 1; level >= 0; level-- {
+        childLvl := tree.lvl[level+1]
+        lvl := tree.lvl[level]
+
+        // Children are sorted, so siblings are next to each other, and parents come out sorted
+        parentNos := nodeNos[:0]
+        pairs = pairs[:0]
+        for i := 0; i < len(nodeNos); i++ {
+            parentNo := parentOf(nodeNos[i])
+            pair := hashPair{tree.EmptyHash, tree.EmptyHash}
+            if nodeNos[i][31]&1 == 0 {
+                pair.left = childLvl.node[nodeNos[i]].Hash
+                if i+1 < len(nodeNos) && parentOf(nodeNos[i+1]) == parentNo {
+                    i++
+                    pair.right = childLvl.node[nodeNos[i]].Hash
+                }
+            } else {
+                pair.right = childLvl.node[nodeNos[i]].Hash
+            }
+            parentNos = append(parentNos, parentNo)
+            pairs = append(pairs, pair)
+        }
+
+        if cap(hashes) < len(pairs) {
+            hashes = make([][32]byte, len(pairs))
+        }
+        hashes = hashes[:len(pairs)]
+        tree._merkleHashPairs(level, parentNos, pairs, hashes)
+
+        for i, nodeNo := range parentNos {
+            node := lvl.newNode()
+            node.Hash = hashes[i]
+            lvl.node[nodeNo] = node
+        }
+        nodeNos = parentNos
+    }
+
+    if tree.versions != nil {
+        tree._seedVersions()
+    }
+    return tree, nil
+}
+
+/**
+ * Reads the leaves of a leaf file (see BuildFromLeaves()) one by one.
+ */
+type LeafFileReader struct {
+    r        *bufio.Reader
+    leafNo   [32]byte
+    dataHash [32]byte
+    err      error
+}
+
+func NewLeafFileReader(r io.Reader) *LeafFileReader {
+    return &LeafFileReader{r: bufio.NewReader(r)}
+}
+
+func (lr *LeafFileReader) Next() bool {
+    if lr.err != nil {
+        return false
+    }
+
+    var record [leafRecordSize]byte
+    n, err := io.ReadFull(lr.r, record[:])
+    if err == io.EOF {
+        return false
+    }
+    if err != nil {
+        lr.err = fmt.Errorf("truncated leaf file: the last record has %d of %d bytes", n, leafRecordSize)
+        if !errors.Is(err, io.ErrUnexpectedEOF) {
+            lr.err = err
+        }
+        return false
+    }
+
+    copy(lr.leafNo[:], record[:32])
+    copy(lr.dataHash[:], record[32:])
+    return true
+}
+
+func (lr *LeafFileReader) Leaf() ([32]byte, [32]byte) {
+    return lr.leafNo, lr.dataHash
+}
+
+/**
+ * Returns the error that stopped Next(), if any.
+ */
+func (lr *LeafFileReader) Err() error {
+    return lr.err
+}
+
+/**
+ * Writes the specified leaves as a leaf file, sorting them by leaf no first.
+ */
+func WriteLeafFile(w io.Writer, leaves []LeafData) error {
+    sorted := append([]LeafData(nil), leaves...)
+    sort.Slice(sorted, func(i, j int) bool {
+        return bytes.Compare(sorted[i].LeafNo[:], sorted[j].LeafNo[:]) < 0
+    })
+
+    bw := bufio.NewWriter(w)
+    for _, leaf := range sorted {
+        bw.Write(leaf.LeafNo[:])
+        bw.Write(leaf.DataHash[:])
+    }
+    return bw.Flush()
+}
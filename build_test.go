@@ -0,0 +1,67 @@
+package aomt
+
+import (
+    "bytes"
+    "testing"
+)
+
+/**
+ * A tree built bottom-up from a leaf file must be the tree the leaves were inserted into, for
+ * every # of levels, with the leaves as the next epoch, while unsorted, truncated and too large
+ * leaves must be rejected.
+ */
+func TestBuildFromLeavesMatchesInserts(t *testing.T) {
+    for _, numLevels := range []int{257, 16} {
+        var leaves []LeafData
+        reference := NewTree(numLevels)
+        for i := 0; i < 100; i++ {
+            leaf := _testLeaf(i)
+            for bit := 0; bit < maxNumLevels-numLevels; bit++ {
+                leaf.LeafNo[bit/8] &^= 0x80 >> uint(bit%8)
+            }
+            leaves = append(leaves, leaf)
+            reference.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        }
+        var file bytes.Buffer
+        if err := WriteLeafFile(&file, leaves); err != nil {
+            t.Fatal(err)
+        }
+
+        tree, err := BuildFromLeaves(numLevels, TreeOptions{}, NewLeafFileReader(bytes.NewReader(file.Bytes())))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if tree.GetRootHash() != reference.GetRootHash() {
+            t.Fatalf("%d levels: built tree has root %s, expected %s", numLevels, hashStr(tree.GetRootHash()), hashStr(reference.GetRootHash()))
+        }
+        if tree.GetNumNodes() != reference.GetNumNodes() {
+            t.Errorf("%d levels: built tree has %d nodes, expected %d", numLevels, tree.GetNumNodes(), reference.GetNumNodes())
+        }
+        if tree.NumPendingChanges() != len(leaves) {
+            t.Errorf("%d levels: built tree has %d pending changes, expected %d", numLevels, tree.NumPendingChanges(), len(leaves))
+        }
+        opts := TreeOptions{NumLevels: numLevels}
+        for _, leaf := range leaves[:10] {
+            if !VerifyMembershipProofWithOptions(tree.ProveMembership(leaf.LeafNo), reference.GetRootHash(), opts) {
+                t.Errorf("%d levels: proof of leaf %s does not verify", numLevels, hashStr(leaf.LeafNo))
+            }
+        }
+
+        data := file.Bytes()
+        unsorted := append(append([]byte{}, data[leafRecordSize:2*leafRecordSize]...), data...)
+        malformed := map[string][]byte{
+            "unsorted":  unsorted,
+            "truncated": data[:len(data)-1],
+        }
+        if numLevels < maxNumLevels {
+            tooLarge := append([]byte{}, data...)
+            tooLarge[len(data)-leafRecordSize] |= 0x80
+            malformed["too large"] = tooLarge
+        }
+        for name, bad := range malformed {
+            if _, err := BuildFromLeaves(numLevels, TreeOptions{}, NewLeafFileReader(bytes.NewReader(bad))); err == nil {
+                t.Errorf("%d levels: %s leaf file builds", numLevels, name)
+            }
+        }
+    }
+}
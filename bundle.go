@@ -0,0 +1,115 @@
+package aomt
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+)
+
+/**
+ * Static proof bundles: a directory with the head of one epoch and the membership proofs of (some
+ * of) its leaves, laid out like the HTTP server's endpoints (see server.go), so that any static file
+ * server or CDN can serve it in place of a live server, and clients can verify leaves offline:
+ *
+ *   <dir>/v1/root             the epoch's head, as GET /v1/root?epoch=<epoch> returns it
+ *   <dir>/v1/sth              the epoch's signed head (as sent by /v1/roots/stream), if signed
+ *   <dir>/v1/leaf/<leaf no>   the leaf's proof, as GET /v1/leaf/<leaf no>?epoch=<epoch> returns it
+ *   <dir>/manifest.json       what the bundle has, see bundleManifestJSON
+ *
+ * NOTE: Every leaf gets its own file, so bundles of all the leaves of large trees are better
+ * served from object stores than from file systems.
+ */
+
+type BundleOptions struct {
+    // The leaves whose proofs are exported, which need not be in the tree (their proofs are then
+    // non-membership proofs). All the epoch's leaves if nil.
+    LeafNos [][32]byte
+
+    // Signs the epoch's head, which is then written to v1/sth. No signed head if nil.
+    SignHead func(head TreeHead) []byte
+}
+
+type bundleManifestJSON struct {
+    Epoch        int    `json:"epoch"`
+    Root         string `json:"root"`
+    ParamsDigest string `json:"paramsDigest"` // see Tree::ParamsDigest()
+    NumLeaves    int    `json:"numLeaves"`    // the # of leaf files
+    Signed       bool   `json:"signed"`       // true if there is a v1/sth
+}
+
+/**
+ * Writes the bundle of epoch 'epoch' to 'dir', which is created if it does not exist. Returns the
+ * # of leaf files written.
+ *
+ * NOTE: Proofs as of past epochs are computed on a replay of the tree (see Tree::TreeAtEpoch()),
+ * which has no openings, values nor extensions, so only bundles of the latest epoch have those.
+ */
+func ExportBundle(tree *Tree, epoch int, dir string, opts BundleOptions) (int, error) {
+    if epoch < 0 || epoch >= tree.NumEpochs() {
+        return 0, fmt.Errorf("epoch %d was never recorded (have %d epochs)", epoch, tree.NumEpochs())
+    }
+
+    atEpoch := tree
+    if epoch != tree.NumEpochs()-1 || tree.NumPendingChanges() > 0 {
+        atEpoch = tree.TreeAtEpoch(epoch)
+    }
+    root := tree.GetEpochRoot(epoch)
+
+    leafDir := filepath.Join(dir, "v1", "leaf")
+    if err := os.MkdirAll(leafDir, 0755); err != nil {
+        return 0, err
+    }
+
+    head := rootResponseJSON{Epoch: epoch, Root: hashStr(root), Header: hashStr(tree.GetEpochHeader(epoch).Hash())}
+    if err := _writeBundleJSON(filepath.Join(dir, "v1", "root"), head); err != nil {
+        return 0, err
+    }
+    if opts.SignHead != nil {
+        sth := tree.GetTreeHead(epoch)
+        signed := rootEventJSON{Epoch: epoch, Root: hashStr(root), Signature: hex.EncodeToString(opts.SignHead(sth))}
+        if err := _writeBundleJSON(filepath.Join(dir, "v1", "sth"), signed); err != nil {
+            return 0, err
+        }
+    }
+
+    leafNos := opts.LeafNos
+    if leafNos == nil {
+        for it := atEpoch.Leaves(); it.Next(); {
+            leafNo, _ := it.Leaf()
+            leafNos = append(leafNos, leafNo)
+        }
+    }
+
+    numLeaves := 0
+    for _, leafNo := range _sortedDistinct(leafNos) {
+        resp := _newLeafResponse(epoch, root, leafNo, atEpoch.ProveMembership(leafNo))
+        if err := _writeBundleJSON(filepath.Join(leafDir, hashStr(leafNo)), resp); err != nil {
+            return numLeaves, err
+        }
+        numLeaves++
+    }
+
+    // The manifest is written last, so that a bundle with one is complete
+    manifest := bundleManifestJSON{
+        Epoch:        epoch,
+        Root:         hashStr(root),
+        ParamsDigest: hashStr(tree.ParamsDigest()),
+        NumLeaves:    numLeaves,
+        Signed:       opts.SignHead != nil,
+    }
+    return numLeaves, _writeBundleJSON(filepath.Join(dir, "manifest.json"), manifest)
+}
+
+/**
+ * Writes 'v' as JSON to 'path', as the server would send it.
+ */
+func _writeBundleJSON(path string, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,184 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "fmt"
+    "time"
+)
+
+/**
+ * The top levels of the tree are on the path of every leaf, so every insert and proof looks up
+ * their nodes. Since these levels fill up quickly, a tree can cache the nodes of its top
+ * TreeOptions::CachedLevels levels in dense arrays indexed by LN, which are cheaper to index than
+ * the per-level maps are to look up (no hashing of 32-byte keys). Level L has 2^L nodes, so
+ * caching the top k levels takes 2^k pointers.
+ *
+ * The per-level maps are still the source of truth: the cache is filled when nodes are added and
+ * on the lookups of inserts, and nodes are never removed from the maps of a tree with cached
+ * levels (proof trees, whose nodes are removed by _compressProofTree(), never have cached levels),
+ * except by Tree::Prune(), which clears the caches. Since filling the cache writes to the level,
+ * only writers (e.g., inserts, which hold ConcurrentTree's write lock) look up nodes via get(),
+ * while proofs and the other readers use peek() (see Tree::getNode()).
+ */
+const maxCachedLevels = 24
+
+/**
+ * Returns the node with the specified LN on this level, or nil if there is no such node, filling
+ * the cache on misses. Must not be called concurrently with any other lookup (see peek()).
+ */
+func (lvl *TreeLevel) get(nodeNo [32]byte) *Node {
+    if lvl.cache == nil {
+        return lvl.node[nodeNo]
+    }
+
+    // The LNs of cached levels fit in 64 bits, but callers also look up the root's "sibling"
+    idx := binary.BigEndian.Uint64(nodeNo[24:])
+    if idx >= uint64(len(lvl.cache)) || nodeNo[23] != 0 {
+        return lvl.node[nodeNo]
+    }
+    if node := lvl.cache[idx]; node != nil {
+        return node
+    }
+
+    node := lvl.node[nodeNo]
+    lvl.cache[idx] = node
+    return node
+}
+
+/**
+ * Like get(), but does not fill the cache on misses, so it can be called by concurrent readers.
+ */
+func (lvl *TreeLevel) peek(nodeNo [32]byte) *Node {
+    if lvl.cache != nil && nodeNo[23] == 0 {
+        if idx := binary.BigEndian.Uint64(nodeNo[24:]); idx < uint64(len(lvl.cache)) && lvl.cache[idx] != nil {
+            return lvl.cache[idx]
+        }
+    }
+    return lvl.node[nodeNo]
+}
+
+/**
+ * Adds 'node' to the cache, if its LN is cached on this level.
+ */
+func (lvl *TreeLevel) _cacheNode(nodeNo [32]byte, node *Node) {
+    if lvl.cache != nil && nodeNo[23] == 0 {
+        if idx := binary.BigEndian.Uint64(nodeNo[24:]); idx < uint64(len(lvl.cache)) {
+            lvl.cache[idx] = node
+        }
+    }
+}
+
+/**
+ * Allocates the caches of the top 'numLevels' levels.
+ */
+func (tree *Tree) _cacheLevels(numLevels int) {
+    for level := 0; level < numLevels; level++ {
+        tree.lvl[level].cache = make([]*Node, 1<<uint(level))
+    }
+}
+
+/**
+ * Returns the # of cached levels, see TreeOptions::CachedLevels.
+ */
+func (tree *Tree) _numCachedLevels() int {
+    numLevels := 0
+    for numLevels < tree.numLevels && tree.lvl[numLevels].cache != nil {
+        numLevels++
+    }
+    return numLevels
+}
+
+/**
+ * Compares inserting the same batches (with append-only proofs) and proving the membership of the
+ * batches' leaves in a tree without cached levels and in a tree with 'cachedLevels' cached levels.
+ */
+func hashcached(sizes []int, seed int64, csvFile string, opts BenchOptions, cachedLevels int) {
+    memGc()
+
+    // Same leaves as in hashsparse(), so the CSVs can be compared
+    gen := opts._newLeafSource(seed)
+
+    plain := NewTree(257)
+    cached, err := NewTreeWithOptions(257, TreeOptions{CachedLevels: cachedLevels})
+    if err != nil {
+        panic(err.Error())
+    }
+    trees := []*Tree{plain, cached}
+    for _, tree := range trees {
+        tree.Insert(tree.RootNo, sha256.Sum256([]byte("Dummy leaf")), nil)
+        tree.RecordEpoch()
+    }
+
+    prevSize := 1
+    numCompleted := opts._numCompletedSizes(csvFile, sizes)
+    for i := 0; i < numCompleted; i++ {
+        fmt.Printf("Rebuilding completed batch of size %v ...\n", sizes[i]-prevSize)
+        for j := 0; j < sizes[i]-prevSize; j++ {
+            leafNo, dataHash := gen.next()
+            for _, tree := range trees {
+                tree.Insert(leafNo, dataHash, nil)
+            }
+        }
+        for _, tree := range trees {
+            tree.RecordEpoch()
+        }
+        prevSize = sizes[i]
+    }
+
+    csv := opts._openCsv(csvFile, []string{"dictSize", "cachedLevels", "insertUsec", "cachedInsertUsec", "proveUsec", "cachedProveUsec"})
+
+    for i := numCompleted; i < len(sizes); i++ {
+        newSize := sizes[i]
+        fmt.Printf("\nAppending new batch of size %v ...\n", newSize-prevSize)
+
+        var leaves []LeafData
+        for j := 0; j < newSize-prevSize; j++ {
+            leafNo, dataHash := gen.next()
+            leaves = append(leaves, LeafData{leafNo, dataHash})
+        }
+
+        var insertTimes, proveTimes [2]time.Duration
+        for t, tree := range trees {
+            startTime := time.Now()
+            batch := tree.BeginEpoch()
+            for _, leaf := range leaves {
+                if err := batch.Insert(leaf.LeafNo, leaf.DataHash); err != nil {
+                    panic(err)
+                }
+            }
+            batch.Commit()
+            insertTimes[t] = time.Since(startTime)
+
+            startTime = time.Now()
+            for _, leaf := range leaves {
+                tree.ProveMembership(leaf.LeafNo)
+            }
+            proveTimes[t] = time.Since(startTime)
+        }
+
+        if plain.GetRootHash() != cached.GetRootHash() {
+            panic("Something's off: the tree with cached levels has a different root hash")
+        }
+
+        fmt.Printf(
+            "# kv's: %v, "+
+                "insert time: %s (%s with %d cached levels), "+
+                "prove time: %s (%s with %d cached levels)\n",
+            newSize,
+            insertTimes[0], insertTimes[1], cachedLevels,
+            proveTimes[0], proveTimes[1], cachedLevels)
+
+        csv.Write(newSize, cachedLevels,
+            int64(insertTimes[0]/time.Microsecond), int64(insertTimes[1]/time.Microsecond),
+            int64(proveTimes[0]/time.Microsecond), int64(proveTimes[1]/time.Microsecond))
+        prevSize = newSize
+    }
+
+    if err := csv.Close(); err != nil {
+        panic("Error writing CSV file: " + err.Error())
+    }
+
+    fmt.Printf("\n")
+    memGc()
+}
@@ -0,0 +1,96 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "runtime"
+    "time"
+)
+
+/**
+ * Measurements of how fast this machine hashes and accesses the per-level node maps, used to pick
+ * sane defaults for worker counts and request limits on heterogeneous hardware.
+ */
+type Calibration struct {
+    NumCPU        int     `json:"numCpu"`
+    HashesPerSec  float64 `json:"hashesPerSec"`  // single-threaded _merkleHash() calls per second
+    NodeReadNsec  float64 `json:"nodeReadNsec"`  // average time to look up a node in a level's map
+    NodeWriteNsec float64 `json:"nodeWriteNsec"` // average time to add a node to a level's map
+    TookMsec      int64   `json:"tookMsec"`
+}
+
+/**
+ * Runs the calibration, which takes roughly 'budget' time: half of it hashing and half of it
+ * accessing nodes.
+ */
+func Calibrate(budget time.Duration) *Calibration {
+    start := time.Now()
+    cal := &Calibration{NumCPU: runtime.NumCPU()}
+
+    // Hash throughput
+    var h [32]byte
+    numHashes := 0
+    hashStart := time.Now()
+    for time.Since(hashStart) < budget/2 {
+        for i := 0; i < 1000; i++ {
+            h = _merkleHash(h, h)
+        }
+        numHashes += 1000
+    }
+    cal.HashesPerSec = float64(numHashes) / time.Since(hashStart).Seconds()
+
+    // Node map latency: we write as many random nodes as we can in a quarter of the budget and
+    // then read them back
+    lvl := _newTreeLevel(256)
+    var keys [][32]byte
+    key := sha256.Sum256([]byte("calibration"))
+    writeStart := time.Now()
+    for time.Since(writeStart) < budget/4 {
+        for i := 0; i < 1000; i++ {
+            key = sha256.Sum256(key[:])
+            lvl.node[key] = &Node{Hash: key}
+            keys = append(keys, key)
+        }
+    }
+    // NOTE: This includes the time to compute the keys, so it overestimates the write latency a bit
+    cal.NodeWriteNsec = float64(time.Since(writeStart).Nanoseconds()) / float64(len(keys))
+
+    readStart := time.Now()
+    numReads := 0
+    for time.Since(readStart) < budget/4 {
+        for _, k := range keys {
+            _ = lvl.node[k]
+        }
+        numReads += len(keys)
+    }
+    cal.NodeReadNsec = float64(time.Since(readStart).Nanoseconds()) / float64(numReads)
+
+    cal.TookMsec = int64(time.Since(start) / time.Millisecond)
+    return cal
+}
+
+/**
+ * Returns the # of workers to use for InsertBatchParallel().
+ */
+func (cal *Calibration) SuggestedWorkers() int {
+    return cal.NumCPU
+}
+
+/**
+ * Returns the max # of leaves to insert in a batch so that inserting it takes at most 'maxTime'
+ * on one core, since each insert computes about 256 hashes.
+ */
+func (cal *Calibration) SuggestedMaxBatchSize(maxTime time.Duration) int {
+    return _clampInt(int(cal.HashesPerSec*maxTime.Seconds()/256), 1, 1<<24)
+}
+
+/**
+ * Returns the max # of nodes a client can fetch in one request so that looking them up takes at
+ * most 'maxTime'.
+ */
+func (cal *Calibration) SuggestedMaxNodesPerRequest(maxTime time.Duration) int {
+    return _clampInt(int(float64(maxTime.Nanoseconds())/cal.NodeReadNsec), 64, 1<<16)
+}
+
+func _clampInt(n int, min int, max int) int {
+    return maxInt(min, minInt(n, max))
+}
@@ -0,0 +1,41 @@
+package aomt
+
+import (
+    "context"
+    "fmt"
+)
+
+/**
+ * Long operations (e.g., inserting a batch of hundreds of thousands of leaves) have *Context()
+ * variants that stop early when their context is canceled, e.g., by a server whose client went
+ * away or by a CLI on SIGINT. They then return a ProgressError that says how far they got.
+ */
+type ProgressError struct {
+    Op    string // e.g., "inserting batch"
+    Unit  string // what Done and Total count, e.g., "leaves"
+    Done  int
+    Total int
+    Err   error // the context's error, i.e., context.Canceled or context.DeadlineExceeded
+}
+
+func (e *ProgressError) Error() string {
+    return fmt.Sprintf("%s stopped after %d of %d %s: %v", e.Op, e.Done, e.Total, e.Unit, e.Err)
+}
+
+func (e *ProgressError) Unwrap() error {
+    return e.Err
+}
+
+// How many items long operations process between checks of their context, since checking it
+// takes a lock
+const cancelCheckInterval = 256
+
+/**
+ * Returns a ProgressError if the context is done, and nil otherwise.
+ */
+func _checkCanceled(ctx context.Context, op string, unit string, done int, total int) error {
+    if err := ctx.Err(); err != nil {
+        return &ProgressError{Op: op, Unit: unit, Done: done, Total: total, Err: err}
+    }
+    return nil
+}
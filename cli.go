@@ -0,0 +1,1143 @@
+package aomt
+
+import (
+    "context"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "time"
+)
+
+/**
+ * The subcommands that use the tree as a tool rather than benchmark it. The tree is kept in a
+ * snapshot file (see Tree::Snapshot()), given via --db, which is rewritten after every insert.
+ * Every new epoch is first logged to a WAL next to it (i.e., '<db>.wal'), so that a crash while
+ * rewriting the snapshot does not lose the epoch.
+ * Keys and values are arbitrary strings: a key's leaf no is SHA256(key) and a value's data hash
+ * is SHA256(value).
+ *
+ * Private deployments can key the tree's hashes with an operator secret (see TreeOptions::HMACKey)
+ * via the environment, rather than via flags, so the key is not in shell histories nor in process
+ * listings:
+ *
+ *   AOMT_HMAC_KEY_FILE   a file with the hex-encoded key
+ *   AOMT_HMAC            what the key keys: "leaf-nos", "leaf-hashes" or "leaf-nos,leaf-hashes" (the
+ *                        default), i.e., key leaf nos are HMAC(key, key) rather than SHA256(key),
+ *                        and/or leaf hashes are HMAC(key, data hash)
+ *
+ * The key is never written to snapshots nor to WALs, so a tree must always be opened with the
+ * same key.
+ *
+ * The shards of a sharded storage (see ShardedStorage) are directories, or the URLs of buckets of
+ * S3-compatible object stores (see ObjectShardStore), which are accessed with the usual
+ * AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION variables (requests
+ * are not signed if there is no access key), and which cache their subtrees in a subdirectory of
+ * AOMT_SHARD_CACHE_DIR, if set. Either can be prefixed with page transforms, e.g.,
+ * "delta+deflate:/mnt/disk1" (see TransformedShardStore).
+ */
+
+// The environment variables with the operator's HMAC key settings, see above
+const hmacKeyFileEnv = "AOMT_HMAC_KEY_FILE"
+const hmacModeEnv = "AOMT_HMAC"
+
+// The options every --db tree is created and loaded with, set by main() via _loadDbOptions()
+var dbOptions TreeOptions
+
+// The environment variable with the directory object store shards cache their subtrees in
+const shardCacheDirEnv = "AOMT_SHARD_CACHE_DIR"
+
+// The exit code of a subcommand called with invalid arguments, after which the usage is printed
+const exitUsage = 2
+
+/**
+ * Lists the artifacts in a directory, see ArtifactDir::Print().
+ */
+func lsArtifactsCmd(name string, args []string) int {
+    if len(args) != 1 {
+        return exitUsage
+    }
+
+    dir, err := OpenArtifactDir(args[0])
+    if err == nil {
+        err = dir.Print()
+    }
+    if err != nil {
+        fmt.Printf("Error listing artifacts: %v\n", err)
+        return 1
+    }
+    return 0
+}
+
+/**
+ * Prints the leaves that differ between two trees' snapshot files (see Diff()), e.g., so that a
+ * mirror can check it holds the same dictionary as the server it mirrors.
+ */
+func diffCmd(name string, args []string) int {
+    if len(args) != 2 {
+        return exitUsage
+    }
+
+    var trees [2]*Tree
+    for i, path := range args {
+        // _openDb() starts from an empty tree if the file does not exist, which would make every
+        // leaf differ
+        _, err := os.Stat(path)
+        if err == nil {
+            trees[i], err = _openDb(path)
+        }
+        if err != nil {
+            fmt.Printf("Error opening tree: %v\n", err)
+            return 1
+        }
+    }
+    if trees[0].ParamsDigest() != trees[1].ParamsDigest() {
+        fmt.Printf("Error: the trees have different parameters\n")
+        return 1
+    }
+
+    changes := Diff(trees[0], trees[1])
+    for _, change := range changes {
+        switch change.Kind {
+        case LeafAdded:
+            fmt.Printf("+ %s %s\n", hashStr(change.LeafNo), hashStr(change.NewHash))
+        case LeafRemoved:
+            fmt.Printf("- %s %s\n", hashStr(change.LeafNo), hashStr(change.OldHash))
+        default:
+            fmt.Printf("~ %s %s -> %s\n", hashStr(change.LeafNo), hashStr(change.OldHash), hashStr(change.NewHash))
+        }
+    }
+    fmt.Printf("%d leaves differ\n", len(changes))
+    return 0
+}
+
+/**
+ * Inserts (or updates) the specified key-value pairs in a new epoch and, if the tree already had
+ * epochs, writes the append-only proof from the previous epoch to the --proof file as a
+ * ProofEnvelope.
+ */
+func insertCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dbFile := flags.String("db", "", "the tree's snapshot file, created if it does not exist")
+    proofFile := flags.String("proof", "", "the file where the append-only proof from the previous epoch is written")
+    flags.Parse(args)
+
+    if *dbFile == "" || flags.NArg() == 0 {
+        return exitUsage
+    }
+
+    var leaves []LeafData
+    for _, pair := range flags.Args() {
+        kv := strings.SplitN(pair, "=", 2)
+        if len(kv) != 2 {
+            fmt.Printf("Error: '%s' is not of the form <key>=<value>\n", pair)
+            return exitUsage
+        }
+        leaves = append(leaves, LeafData{_keyLeafNo(kv[0]), sha256.Sum256([]byte(kv[1]))})
+    }
+
+    tree, err := _openDb(*dbFile)
+    if err != nil {
+        fmt.Printf("Error opening tree: %v\n", err)
+        return 1
+    }
+    wal, err := OpenWAL(*dbFile + walSuffix)
+    if err != nil {
+        fmt.Printf("Error opening WAL: %v\n", err)
+        return 1
+    }
+    defer wal.Close()
+
+    // The first epoch has no previous epoch to be append-only with respect to
+    if tree.NumEpochs() == 0 {
+        if *proofFile != "" {
+            fmt.Printf("Error: the first epoch has no append-only proof\n")
+            return 1
+        }
+        for _, leaf := range leaves {
+            if _, ok := tree.Get(leaf.LeafNo); ok {
+                tree.Update(leaf.LeafNo, leaf.DataHash, nil)
+            } else {
+                tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+            }
+        }
+        tree.RecordEpoch()
+    } else {
+        epoch := tree.BeginEpoch()
+        for _, leaf := range leaves {
+            if _, ok := tree.Get(leaf.LeafNo); ok {
+                err = epoch.Update(leaf.LeafNo, leaf.DataHash)
+            } else {
+                err = epoch.Insert(leaf.LeafNo, leaf.DataHash)
+            }
+            if err != nil {
+                fmt.Printf("Error changing leaf %s: %v\n", hashStr(leaf.LeafNo), err)
+                return 1
+            }
+        }
+        proof, _ := epoch.Commit()
+
+        if *proofFile != "" {
+            last := tree.NumEpochs() - 1
+            data, err := NewProofEnvelope(tree, last-1, last, proof).MarshalBinary()
+            if err == nil {
+                err = ioutil.WriteFile(*proofFile, data, 0644)
+            }
+            if err != nil {
+                fmt.Printf("Error writing append-only proof: %v\n", err)
+                return 1
+            }
+        }
+    }
+
+    if err := wal.LogEpoch(tree, tree.NumEpochs()-1); err != nil {
+        fmt.Printf("Error logging epoch: %v\n", err)
+        return 1
+    }
+    // Rewriting a big snapshot takes a while, so stop cleanly on Ctrl+C: the epoch is in the WAL
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    if err := _saveDb(ctx, tree, *dbFile); err != nil {
+        fmt.Printf("Error saving tree: %v\n", err)
+        if errors.Is(err, context.Canceled) {
+            fmt.Printf("The new epoch was logged and will be replayed from the WAL when the tree is opened\n")
+        }
+        return 1
+    }
+    if err := wal.Checkpoint(); err != nil {
+        fmt.Printf("Error checkpointing WAL: %v\n", err)
+        return 1
+    }
+    fmt.Printf("Epoch %d, root %s\n", tree.NumEpochs()-1, hashStr(tree.GetRootHash()))
+    return 0
+}
+
+/**
+ * Prints the membership (or non-membership) proof of a key as of the last epoch, in the same JSON
+ * format as the HTTP server's /v1/leaf/ endpoint.
+ */
+func proveMembershipCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dbFile := flags.String("db", "", "the tree's snapshot file")
+    mmapFile := flags.String("mmap", "", "a mapped tree file (see 'export-mmap') to read instead of --db, without loading it")
+    key := flags.String("key", "", "the key whose membership is proved")
+    prefetch := flags.Int("prefetch", 0, "with --mmap, the # of goroutines that read the proof's siblings concurrently")
+    flags.Parse(args)
+
+    if (*dbFile == "") == (*mmapFile == "") || *key == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+    if *mmapFile != "" {
+        return _proveMembershipMMap(*mmapFile, *key, *prefetch)
+    }
+
+    tree, err := _openDb(*dbFile)
+    if err == nil && tree.NumEpochs() == 0 {
+        err = errors.New("the tree has no epochs")
+    }
+    if err != nil {
+        fmt.Printf("Error opening tree: %v\n", err)
+        return 1
+    }
+
+    leafNo := _keyLeafNo(*key)
+    resp := _newLeafResponse(tree.NumEpochs()-1, tree.GetRootHash(), leafNo, tree.ProveMembership(leafNo))
+
+    data, _ := json.MarshalIndent(resp, "", "  ")
+    fmt.Printf("%s\n", data)
+    return 0
+}
+
+/**
+ * Like proveMembershipCmd(), but reads a mapped tree file, which has no epochs, so the printed
+ * epoch is always 0.
+ */
+func _proveMembershipMMap(path string, key string, prefetch int) int {
+    mt, err := OpenSnapshotMMap(path)
+    if err != nil {
+        fmt.Printf("Error opening mapped tree file: %v\n", err)
+        return 1
+    }
+    defer mt.Close()
+    mt.SetPrefetch(prefetch)
+
+    leafNo := _keyLeafNo(key)
+    proof := mt.ProveMembership(leafNo)
+    resp := leafResponseJSON{Root: hashStr(mt.GetRootHash()), Leaf: hashStr(leafNo), LeafHash: hashStr(proof.LeafHash)}
+    _, resp.Exists = mt.Get(leafNo)
+    for _, sibling := range proof.Siblings {
+        resp.Siblings = append(resp.Siblings, hashStr(sibling))
+    }
+
+    data, _ := json.MarshalIndent(resp, "", "  ")
+    fmt.Printf("%s\n", data)
+    return 0
+}
+
+/**
+ * Writes the tree as of its last epoch as a mapped tree file (see Tree::SnapshotMMap()), which
+ * auditors can query without loading it into memory.
+ */
+func exportMMapCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dbFile := flags.String("db", "", "the tree's snapshot file")
+    outFile := flags.String("out", "", "the mapped tree file to write")
+    flags.Parse(args)
+
+    if *dbFile == "" || *outFile == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    tree, err := _openDb(*dbFile)
+    if err != nil {
+        fmt.Printf("Error opening tree: %v\n", err)
+        return 1
+    }
+
+    f, err := os.Create(*outFile)
+    if err != nil {
+        fmt.Printf("Error: %v\n", err)
+        return 1
+    }
+    err = tree.SnapshotMMap(f)
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(*outFile)
+        fmt.Printf("Error writing mapped tree file: %v\n", err)
+        return 1
+    }
+
+    fmt.Printf("Wrote %d leaves with root %s to %s\n", tree.NumLeaves(), hashStr(tree.GetRootHash()), *outFile)
+    return 0
+}
+
+/**
+ * Writes the static proof bundle of an epoch (see ExportBundle()), with the proofs of all of its
+ * leaves or of the keys listed in --keys, one per line.
+ */
+func exportBundleCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dbFile := flags.String("db", "", "the tree's snapshot file")
+    epoch := flags.Int("epoch", -1, "the epoch to export, the latest one if negative")
+    outDir := flags.String("out", "", "the directory the bundle is written to, created if it does not exist")
+    keysFile := flags.String("keys", "", "a file with the keys whose proofs are exported, one per line (all leaves if not set)")
+    signingKeyFile := flags.String("signing-key", "", "a file with the hex-encoded Ed25519 seed (32 bytes) to sign the epoch's head with")
+    flags.Parse(args)
+
+    if *dbFile == "" || *outDir == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    var opts BundleOptions
+    if *keysFile != "" {
+        data, err := ioutil.ReadFile(*keysFile)
+        if err != nil {
+            fmt.Printf("Error reading keys: %v\n", err)
+            return 1
+        }
+        opts.LeafNos = [][32]byte{}
+        for _, key := range strings.Split(string(data), "\n") {
+            if key = strings.TrimRight(key, "\r"); key != "" {
+                opts.LeafNos = append(opts.LeafNos, _keyLeafNo(key))
+            }
+        }
+    }
+    if *signingKeyFile != "" {
+        data, err := ioutil.ReadFile(*signingKeyFile)
+        if err != nil {
+            fmt.Printf("Error reading signing key: %v\n", err)
+            return 1
+        }
+        seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+        if err != nil || len(seed) != ed25519.SeedSize {
+            fmt.Printf("Error: the signing key must be a hex-encoded %d-byte Ed25519 seed\n", ed25519.SeedSize)
+            return 1
+        }
+        key := ed25519.NewKeyFromSeed(seed)
+        opts.SignHead = func(head TreeHead) []byte {
+            message, _ := head.MarshalProto()
+            return ed25519.Sign(key, message)
+        }
+    }
+
+    tree, err := _openDb(*dbFile)
+    if err == nil && tree.NumEpochs() == 0 {
+        err = errors.New("the tree has no epochs")
+    }
+    if err != nil {
+        fmt.Printf("Error opening tree: %v\n", err)
+        return 1
+    }
+    if *epoch < 0 {
+        *epoch = tree.NumEpochs() - 1
+    }
+
+    numLeaves, err := ExportBundle(tree, *epoch, *outDir, opts)
+    if err != nil {
+        fmt.Printf("Error writing bundle: %v\n", err)
+        return 1
+    }
+    fmt.Printf("Wrote the bundle of epoch %d (root %s) with %d proofs to %s\n", *epoch, hashStr(tree.GetEpochRoot(*epoch)), numLeaves, *outDir)
+    return 0
+}
+
+/**
+ * Stores the tree in a ShardedStorage, creating it with the shards given via --shards (each a
+ * directory, see DirShardStore) if there is none in --dir yet.
+ */
+func saveShardedCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dbFile := flags.String("db", "", "the tree's snapshot file")
+    dir := flags.String("dir", "", "the directory of the sharded storage, which keeps the top levels")
+    shardDirs := flags.String("shards", "", "comma-separated list of the shards' directories or object store URLs, optionally prefixed with page transforms (e.g., 'delta+deflate:<dir>'), when creating the storage")
+    prefixBits := flags.Int("prefix-bits", 8, "the level at which the subtrees stored on the shards are rooted, when creating the storage")
+    publishURL := flags.String("publish", "", "an object store URL to upload the storage's local file to, so verifiers can bootstrap from it (see load-sharded --from)")
+    flags.Parse(args)
+
+    if *dbFile == "" || *dir == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    tree, err := _openDb(*dbFile)
+    if err != nil {
+        fmt.Printf("Error opening tree: %v\n", err)
+        return 1
+    }
+
+    storage, err := OpenShardedStorage(*dir, _openShard)
+    if os.IsNotExist(err) {
+        if *shardDirs == "" {
+            fmt.Printf("Error: --shards is required to create a sharded storage\n")
+            return 1
+        }
+        var shards []ShardStore
+        if shards, err = _openShards(*shardDirs); err == nil {
+            storage, err = CreateShardedStorage(*dir, *prefixBits, shards)
+        }
+    }
+    if err != nil {
+        fmt.Printf("Error opening sharded storage: %v\n", err)
+        return 1
+    }
+
+    if err := storage.Save(tree); err != nil {
+        fmt.Printf("Error saving tree: %v\n", err)
+        return 1
+    }
+    fmt.Printf("Saved tree with root %s to %s\n", hashStr(tree.GetRootHash()), *dir)
+    _printSubtreesPerShard(storage)
+
+    if *publishURL != "" {
+        if err := _publishShardsFile(*dir, *publishURL); err != nil {
+            fmt.Printf("Error publishing to %s: %v\n", *publishURL, err)
+            return 1
+        }
+        fmt.Printf("Published %s to %s\n", shardsFileName, *publishURL)
+    }
+    return 0
+}
+
+/**
+ * Uploads the local file of the sharded storage in 'dir' to the object store at 'rawURL'.
+ */
+func _publishShardsFile(dir string, rawURL string) error {
+    store, err := OpenObjectShardStore(rawURL, _objectStoreOptions(""))
+    if err != nil {
+        return err
+    }
+    data, err := ioutil.ReadFile(filepath.Join(dir, shardsFileName))
+    if err != nil {
+        return err
+    }
+    return store.PutObject(shardsFileName, data)
+}
+
+/**
+ * Loads the tree from a ShardedStorage and writes it to a snapshot file.
+ */
+func loadShardedCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dir := flags.String("dir", "", "the directory of the sharded storage")
+    dbFile := flags.String("db", "", "the snapshot file to write")
+    fromURL := flags.String("from", "", "an object store URL to download the storage's local file from into --dir first (see save-sharded --publish)")
+    flags.Parse(args)
+
+    if *dir == "" || *dbFile == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    if *fromURL != "" {
+        if err := _fetchShardsFile(*fromURL, *dir); err != nil {
+            fmt.Printf("Error downloading from %s: %v\n", *fromURL, err)
+            return 1
+        }
+    }
+    storage, err := OpenShardedStorage(*dir, _openShard)
+    if err != nil {
+        fmt.Printf("Error opening sharded storage: %v\n", err)
+        return 1
+    }
+    tree, err := storage.Load(dbOptions)
+    if err != nil {
+        fmt.Printf("Error loading tree: %v\n", err)
+        return 1
+    }
+
+    if err := _saveDb(context.Background(), tree, *dbFile); err != nil {
+        fmt.Printf("Error saving tree: %v\n", err)
+        return 1
+    }
+    fmt.Printf("Loaded tree with root %s from %s\n", hashStr(tree.GetRootHash()), *dir)
+    return 0
+}
+
+/**
+ * Downloads the local file of a sharded storage published to the object store at 'rawURL' (see
+ * _publishShardsFile()) into 'dir', replacing the one there, if any.
+ */
+func _fetchShardsFile(rawURL string, dir string) error {
+    store, err := OpenObjectShardStore(rawURL, _objectStoreOptions(""))
+    if err != nil {
+        return err
+    }
+    data, err := store.GetObject(shardsFileName)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    return _writeFileDurably(filepath.Join(dir, shardsFileName), func(w io.Writer) error {
+        _, err := w.Write(data)
+        return err
+    })
+}
+
+/**
+ * Spreads a ShardedStorage's subtrees evenly across the shards given via --shards, e.g., to add
+ * or remove a shard (see ShardedStorage::Rebalance()).
+ */
+func rebalanceShardsCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dir := flags.String("dir", "", "the directory of the sharded storage")
+    shardDirs := flags.String("shards", "", "comma-separated list of the shards' directories or object store URLs after rebalancing")
+    flags.Parse(args)
+
+    if *dir == "" || *shardDirs == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    storage, err := OpenShardedStorage(*dir, _openShard)
+    if err != nil {
+        fmt.Printf("Error opening sharded storage: %v\n", err)
+        return 1
+    }
+    shards, err := _openShards(*shardDirs)
+    if err != nil {
+        fmt.Printf("Error opening shards: %v\n", err)
+        return 1
+    }
+
+    numMoved, err := storage.Rebalance(shards)
+    if err != nil {
+        fmt.Printf("Error rebalancing (after moving %d subtrees): %v\n", numMoved, err)
+        return 1
+    }
+    fmt.Printf("Moved %d subtrees\n", numMoved)
+    _printSubtreesPerShard(storage)
+    return 0
+}
+
+func _openShards(names string) ([]ShardStore, error) {
+    var shards []ShardStore
+    for _, name := range strings.Split(names, ",") {
+        shard, err := _openShard(name)
+        if err != nil {
+            return nil, err
+        }
+        shards = append(shards, shard)
+    }
+    return shards, nil
+}
+
+/**
+ * Opens a shard given by its directory or object store URL, with the options in the environment.
+ */
+func _openShard(name string) (ShardStore, error) {
+    return OpenShardStore(name, _objectStoreOptions(name))
+}
+
+/**
+ * Returns the options of the object store shard named 'name' given by the environment (see
+ * above). Every shard gets its own cache directory, named after the hash of its name.
+ */
+func _objectStoreOptions(name string) ObjectStoreOptions {
+    opts := ObjectStoreOptions{
+        AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+        SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+        SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+        Region:          os.Getenv("AWS_REGION"),
+    }
+    if cacheDir := os.Getenv(shardCacheDirEnv); cacheDir != "" && name != "" {
+        nameHash := sha256.Sum256([]byte(name))
+        opts.CacheDir = filepath.Join(cacheDir, hex.EncodeToString(nameHash[:8]))
+    }
+    return opts
+}
+
+func _printSubtreesPerShard(storage *ShardedStorage) {
+    counts := storage.NumSubtreesPerShard()
+    for _, shard := range storage.shards {
+        fmt.Printf("  %s: %d subtrees\n", shard.Name(), counts[shard.Name()])
+    }
+}
+
+/**
+ * Checks an append-only proof (i.e., a ProofEnvelope, as written by 'insert --proof' or served
+ * by the HTTP server) between two root hashes.
+ */
+func verifyAppendOnlyCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    oldRootHex := flags.String("old", "", "the hex-encoded root hash of the old epoch")
+    newRootHex := flags.String("new", "", "the hex-encoded root hash of the new epoch")
+    proofFile := flags.String("proof", "", "the file with the append-only proof")
+    dbFile := flags.String("db", "", "a database with the tree, to locate the subtree the proof diverges on if it is invalid")
+    flags.Parse(args)
+
+    if *oldRootHex == "" || *newRootHex == "" || *proofFile == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    oldRoot, err := _parseHashHex(*oldRootHex)
+    if err != nil {
+        fmt.Printf("Error parsing old root: %v\n", err)
+        return exitUsage
+    }
+    newRoot, err := _parseHashHex(*newRootHex)
+    if err != nil {
+        fmt.Printf("Error parsing new root: %v\n", err)
+        return exitUsage
+    }
+
+    data, err := ioutil.ReadFile(*proofFile)
+    if err != nil {
+        fmt.Printf("Error reading append-only proof: %v\n", err)
+        return 1
+    }
+    var env ProofEnvelope
+    if err := env.UnmarshalBinary(data); err != nil {
+        fmt.Printf("Error parsing append-only proof: %v\n", err)
+        return 1
+    }
+
+    if env.OldRoot != oldRoot || env.NewRoot != newRoot {
+        fmt.Printf("Error: the proof is from root %s to root %s\n", hashStr(env.OldRoot), hashStr(env.NewRoot))
+        return 1
+    }
+    if err := env.Verify(NewTree(257)); err != nil {
+        fmt.Printf("Error: %v\n", err)
+        if *dbFile != "" {
+            _printDivergence(*dbFile, &env, err)
+        }
+        return 1
+    }
+
+    fmt.Printf("OK: epoch %d (root %s) is append-only with respect to epoch %d (root %s)\n",
+        env.ToEpoch, hashStr(newRoot), env.FromEpoch, hashStr(oldRoot))
+    return 0
+}
+
+/**
+ * Prints where the proof in the envelope, which failed verification with 'verifyErr', diverges
+ * from the tree in database 'dbFile' as of the envelope's old epoch (or new epoch, if the old
+ * root matched).
+ */
+func _printDivergence(dbFile string, env *ProofEnvelope, verifyErr error) {
+    tree, err := _openDb(dbFile)
+    if err != nil {
+        fmt.Printf("Error opening database: %v\n", err)
+        return
+    }
+
+    newSide := errors.Is(verifyErr, ErrNewRootMismatch)
+    epoch := env.FromEpoch
+    if newSide {
+        epoch = env.ToEpoch
+    }
+    if epoch >= tree.NumEpochs() {
+        fmt.Printf("The database only has %d epochs, so it cannot tell where epoch %d diverged\n", tree.NumEpochs(), epoch)
+        return
+    }
+    if epoch != tree.NumEpochs()-1 || tree.NumPendingChanges() > 0 {
+        tree = tree.TreeAtEpoch(epoch)
+    }
+
+    d, err := env.Proof.FindDivergence(tree, newSide)
+    if err != nil {
+        fmt.Printf("The proof is malformed: %v\n", err)
+    } else if d == nil {
+        fmt.Printf("The proof agrees with the database's epoch %d\n", epoch)
+    } else {
+        fmt.Printf("Diverges from the database's epoch %d at %v\n", epoch, d)
+    }
+}
+
+/**
+ * Compares the latest heads of several endpoints (and of heads saved to files), see Gossip().
+ * Exits with 1 if any two of them diverge or could not be compared, or if a head could not be fetched.
+ */
+func gossipCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    endpoints := flags.String("endpoints", "", "comma-separated list of the base URLs of the HTTP servers to compare, e.g., 'https://a.example.com,https://b.example.com'")
+    files := flags.String("files", "", "comma-separated list of files with heads to compare too, e.g., saved responses of GET /v1/root")
+    apiKey := flags.String("api-key", "", "the API key to send to the endpoints")
+    numLevels := flags.Int("levels", maxNumLevels, "the # of levels of the tree")
+    flags.Parse(args)
+
+    var sources []HeadSource
+    for _, endpoint := range strings.Split(*endpoints, ",") {
+        if endpoint != "" {
+            sources = append(sources, NewHTTPHeadSource(endpoint, *apiKey))
+        }
+    }
+    for _, file := range strings.Split(*files, ",") {
+        if file != "" {
+            sources = append(sources, &FileHeadSource{Path: file})
+        }
+    }
+    if len(sources) < 2 || flags.NArg() != 0 {
+        fmt.Printf("Error: need at least two endpoints or files to compare\n")
+        return exitUsage
+    }
+
+    emptyTree, err := NewTreeWithOptions(*numLevels, TreeOptions{})
+    if err != nil {
+        fmt.Printf("Error: %v\n", err)
+        return exitUsage
+    }
+
+    report := Gossip(sources, emptyTree)
+    numHeads := 0
+    for _, head := range report.Heads {
+        if head.Err != nil {
+            fmt.Printf("%s: error: %v\n", head.Source, head.Err)
+            continue
+        }
+        numHeads++
+        fmt.Printf("%s: epoch %d, root %s\n", head.Source, head.Head.Epoch, hashStr(head.Head.Root))
+    }
+    for _, d := range report.Divergences {
+        fmt.Printf("DIVERGENCE: %s\n", d.String())
+    }
+    for _, u := range report.Unchecked {
+        fmt.Printf("Unchecked: %s\n", u)
+    }
+
+    if numHeads < 2 {
+        fmt.Printf("Error: got %d head(s), need at least two to compare\n", numHeads)
+        return 1
+    }
+    if !report.Consistent() {
+        fmt.Printf("%d of %d pairs of heads are consistent\n", report.NumChecked, report.NumChecked+len(report.Divergences)+len(report.Unchecked))
+        return 1
+    }
+    fmt.Printf("OK: the %d heads are consistent\n", numHeads)
+    return 0
+}
+
+func _keyLeafNo(key string) [32]byte {
+    if dbOptions.HMACLeafNos {
+        leafNo, _ := HMACKeyMapper{dbOptions.HMACKey}.MapKey([]byte(key), maxNumLevels)
+        return leafNo
+    }
+    leafNo, _ := HashKeyMapper{}.MapKey([]byte(key), maxNumLevels)
+    return leafNo
+}
+
+/**
+ * Returns the options of --db trees given by the environment (see hmacKeyFileEnv), or an error if
+ * they are invalid.
+ */
+func _loadDbOptions() (TreeOptions, error) {
+    var opts TreeOptions
+    keyFile := os.Getenv(hmacKeyFileEnv)
+    mode := os.Getenv(hmacModeEnv)
+    if keyFile == "" {
+        if mode != "" {
+            return opts, fmt.Errorf("%s is set, but %s is not", hmacModeEnv, hmacKeyFileEnv)
+        }
+        return opts, nil
+    }
+
+    data, err := ioutil.ReadFile(keyFile)
+    if err != nil {
+        return opts, fmt.Errorf("reading HMAC key: %v", err)
+    }
+    opts.HMACKey, err = hex.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil || len(opts.HMACKey) == 0 {
+        return opts, fmt.Errorf("%s does not have a hex-encoded HMAC key", keyFile)
+    }
+
+    if mode == "" {
+        mode = "leaf-nos,leaf-hashes"
+    }
+    for _, keyed := range strings.Split(mode, ",") {
+        switch keyed {
+        case "leaf-nos":
+            opts.HMACLeafNos = true
+        case "leaf-hashes":
+            opts.HMACLeafHashes = true
+        default:
+            return opts, fmt.Errorf("%s must be \"leaf-nos\", \"leaf-hashes\" or \"leaf-nos,leaf-hashes\", not \"%s\"", hmacModeEnv, mode)
+        }
+    }
+    return opts, nil
+}
+
+func _parseHashHex(s string) ([32]byte, error) {
+    var h [32]byte
+    b, err := hex.DecodeString(s)
+    if err != nil || len(b) != 32 {
+        return h, fmt.Errorf("'%s' is not 32 hex-encoded bytes", s)
+    }
+    copy(h[:], b)
+    return h, nil
+}
+
+// The suffix of the WAL's file name, after the snapshot file's name
+const walSuffix = ".wal"
+
+/**
+ * Loads the tree from its snapshot file, or starts from a new tree if the file does not exist,
+ * and then replays the epochs from its WAL that did not make it into the snapshot.
+ */
+func _openDb(path string) (*Tree, error) {
+    tree, err := _loadDbSnapshot(path)
+    if err != nil {
+        return nil, err
+    }
+
+    if _, err := os.Stat(path + walSuffix); os.IsNotExist(err) {
+        return tree, nil
+    }
+    wal, err := OpenWAL(path + walSuffix)
+    if err != nil {
+        return nil, err
+    }
+    defer wal.Close()
+
+    if _, err := wal.Replay(tree); err != nil {
+        return nil, fmt.Errorf("replaying WAL: %v", err)
+    }
+    return tree, nil
+}
+
+func _loadDbSnapshot(path string) (*Tree, error) {
+    f, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return NewTreeWithOptions(257, dbOptions)
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    tree, err := LoadSnapshotWithOptions(f, dbOptions)
+    if err == nil && len(tree.pending) > 0 {
+        err = fmt.Errorf("the tree has %d changes that are not in an epoch", len(tree.pending))
+    }
+    return tree, err
+}
+
+/**
+ * Writes the tree's snapshot to a temporary file and renames it over 'path', so that a failed (or
+ * canceled) write does not lose the previous snapshot.
+ */
+func _saveDb(ctx context.Context, tree *Tree, path string) error {
+    tmpPath := path + ".tmp"
+    f, err := os.Create(tmpPath)
+    if err != nil {
+        return err
+    }
+
+    err = tree.SnapshotContext(ctx, f)
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+        return err
+    }
+    return os.Rename(tmpPath, path)
+}
+
+/**
+ * Prints the statistics of the tree (see Tree::Stats()) and, optionally, appends them to a CSV
+ * file, so that the statistics of several trees (e.g., of different sizes) end up in one file,
+ * and writes its per-level node counts to another.
+ */
+func statsCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dbFile := flags.String("db", "", "the tree's snapshot file")
+    csvFile := flags.String("csv", "", "the CSV file to append the statistics to")
+    levelsCsvFile := flags.String("levels-csv", "", "the CSV file to write the # of nodes on each level to")
+    flags.Parse(args)
+
+    if *dbFile == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    tree, err := _openDb(*dbFile)
+    if err != nil {
+        fmt.Printf("Error opening tree: %v\n", err)
+        return 1
+    }
+
+    stats := tree.Stats()
+    fmt.Printf("%v\n", stats)
+    for level, numNodes := range stats.NodesPerLevel {
+        if numNodes > 0 {
+            fmt.Printf("  level %3d: %d nodes\n", level, numNodes)
+        }
+    }
+
+    if *csvFile != "" {
+        w := AppendCsvWriter(*csvFile, treeStatsCsvHeader, 0)
+        w.Write(stats.CsvRow()...)
+        if err := w.Close(); err != nil {
+            fmt.Printf("Error writing %s: %v\n", *csvFile, err)
+            return 1
+        }
+    }
+    if *levelsCsvFile != "" {
+        w := NewCsvWriter(*levelsCsvFile, []string{"level", "numNodes"}, 0)
+        for level, numNodes := range stats.NodesPerLevel {
+            w.Write(level, numNodes)
+        }
+        if err := w.Close(); err != nil {
+            fmt.Printf("Error writing %s: %v\n", *levelsCsvFile, err)
+            return 1
+        }
+    }
+    return 0
+}
+
+/**
+ * Builds a tree from a leaf file via BuildFromLeaves(), records its leaves as the first epoch and
+ * saves it. With -gen, first writes a leaf file with that many random leaves (as in the benchmarks).
+ */
+func buildCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    inputFile := flags.String("input", "", "the leaf file, with 64-byte (leaf no, data hash) records sorted by leaf no")
+    dbFile := flags.String("db", "", "the snapshot file to save the tree to")
+    numLevels := flags.Int("levels", maxNumLevels, "the # of levels of the tree")
+    numGen := flags.Int("gen", 0, "first write this many random leaves to the leaf file")
+    seed := flags.Int64("seed", 0, "with -gen, the seed of the PRNG that generates the leaves")
+    flags.Parse(args)
+
+    if *inputFile == "" || *dbFile == "" || *numGen < 0 || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    if *numGen > 0 {
+        gen := newLeafGenerator(*seed)
+        leaves := make([]LeafData, *numGen)
+        for i := range leaves {
+            leaves[i].LeafNo, leaves[i].DataHash = gen.next()
+            leaves[i].LeafNo = rshBytes(leaves[i].LeafNo, uint(maxNumLevels-*numLevels))
+        }
+        f, err := os.Create(*inputFile)
+        if err == nil {
+            err = WriteLeafFile(f, leaves)
+            if closeErr := f.Close(); err == nil {
+                err = closeErr
+            }
+        }
+        if err != nil {
+            fmt.Printf("Error writing %s: %v\n", *inputFile, err)
+            return 1
+        }
+    }
+
+    f, err := os.Open(*inputFile)
+    if err != nil {
+        fmt.Printf("Error opening leaf file: %v\n", err)
+        return 1
+    }
+    defer f.Close()
+
+    start := time.Now()
+    tree, err := BuildFromLeaves(*numLevels, dbOptions, NewLeafFileReader(f))
+    if err != nil {
+        fmt.Printf("Error building tree: %v\n", err)
+        return 1
+    }
+    took := time.Since(start)
+    epoch := tree.RecordEpoch()
+
+    if err := _saveDb(context.Background(), tree, *dbFile); err != nil {
+        fmt.Printf("Error saving tree: %v\n", err)
+        return 1
+    }
+    fmt.Printf("Built a tree of %d leaves in %v (epoch %d, root %s)\n", tree.NumLeaves(), took, epoch, hashStr(tree.GetRootHash()))
+    return 0
+}
+
+/**
+ * Writes the test vectors of all tree configurations (see GenerateTestVectors()) to a directory,
+ * for checking other implementations of the verifiers against this one.
+ */
+func testVectorsCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    outDir := flags.String("out", "", "the directory the vectors are written to, created if it does not exist")
+    seed := flags.Int64("seed", 0, "the seed of the PRNG that generates the leaves")
+    flags.Parse(args)
+
+    if *outDir == "" || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    vectors, err := GenerateTestVectors(*seed)
+    if err != nil {
+        fmt.Printf("Error generating test vectors: %v\n", err)
+        return 1
+    }
+    if err := os.MkdirAll(*outDir, 0755); err != nil {
+        fmt.Printf("Error creating %s: %v\n", *outDir, err)
+        return 1
+    }
+    if err := WriteTestVectors(*outDir, vectors); err != nil {
+        fmt.Printf("Error writing test vectors: %v\n", err)
+        return 1
+    }
+    for _, vector := range vectors {
+        fmt.Printf("Wrote %s (%d epochs, %d membership proofs, %d append-only proofs)\n",
+            vector.Name, len(vector.Epochs), len(vector.Membership), len(vector.AppendOnly))
+    }
+    return 0
+}
+
+/**
+ * Compares node hashing before and after the hashing pipeline, see RunHashBench().
+ */
+func hashBenchCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    numHashes := flags.Int("n", 2000000, "the # of hashes to compute in each measurement")
+    batchSize := flags.Int("batch", 4096, "the # of independent pairs hashed per batch, as when rehashing a level")
+    flags.Parse(args)
+
+    if *numHashes <= 0 || *batchSize <= 0 || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    results := RunHashBench(*numHashes, *batchSize)
+    fmt.Printf("%-14s %12s %14s %10s\n", "", "ns/hash", "allocs/hash", "speedup")
+    for _, result := range results {
+        fmt.Printf("%-14s %12.1f %14.2f %9.2fx\n", result.Name, result.NsecPerHash, result.AllocsPerHash,
+            results[0].NsecPerHash/result.NsecPerHash)
+    }
+    return 0
+}
+
+/**
+ * Measures how proving the membership of many leaves scales with the # of goroutines, see
+ * RunProveBench().
+ */
+func proveBenchCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    numLeaves := flags.Int("leaves", 1<<18, "the # of leaves in the tree")
+    numProofs := flags.Int("n", 1<<16, "the # of membership proofs in each measurement")
+    maxParallelism := flags.Int("max-parallelism", runtime.NumCPU(), "the most goroutines to prove with")
+    seed := flags.Int64("seed", 0, "the seed of the PRNG that generates the leaves")
+    flags.Parse(args)
+
+    if *numLeaves <= 0 || *numProofs <= 0 || *maxParallelism <= 0 || flags.NArg() != 0 {
+        return exitUsage
+    }
+
+    results := RunProveBench(*numLeaves, *numProofs, *maxParallelism, *seed)
+    fmt.Printf("%12s %14s %10s\n", "goroutines", "proofs/sec", "speedup")
+    for _, result := range results {
+        fmt.Printf("%12d %14.0f %9.2fx\n", result.Parallelism, result.ProofsPerSec, result.ProofsPerSec/results[0].ProofsPerSec)
+    }
+    return 0
+}
+
+/**
+ * Writes the Solidity contract that stores the tree's epoch roots (see SolidityVerifier()) and,
+ * optionally, the hex-encoded calldata of the contract call that appends the root of epoch --to,
+ * with the append-only proof from epoch --from (see SolidityCalldata()).
+ */
+func solidityCmd(name string, args []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dbFile := flags.String("db", "", "the tree's snapshot file")
+    contractFile := flags.String("contract", "", "the file where the contract's source is written")
+    calldataFile := flags.String("calldata", "", "the file where the calldata of appendRoot() is written, in hex")
+    fromEpoch := flags.Int("from", -1, "with --calldata, the epoch whose root the contract has last")
+    toEpoch := flags.Int("to", -1, "with --calldata, the epoch whose root is appended (the last epoch by default)")
+    flags.Parse(args)
+
+    if *dbFile == "" || (*contractFile == "" && *calldataFile == "") || flags.NArg() != 0 {
+        return exitUsage
+    }
+    if *calldataFile != "" && *fromEpoch < 0 {
+        return exitUsage
+    }
+
+    tree, err := _openDb(*dbFile)
+    if err != nil {
+        fmt.Printf("Error opening tree: %v\n", err)
+        return 1
+    }
+
+    if *contractFile != "" {
+        src, err := SolidityVerifier(tree.Options())
+        if err == nil {
+            err = ioutil.WriteFile(*contractFile, []byte(src), 0644)
+        }
+        if err != nil {
+            fmt.Printf("Error writing contract: %v\n", err)
+            return 1
+        }
+        fmt.Printf("Wrote contract to %s\n", *contractFile)
+    }
+
+    if *calldataFile != "" {
+        if *toEpoch < 0 {
+            *toEpoch = tree.NumEpochs() - 1
+        }
+        if *fromEpoch >= *toEpoch || *toEpoch >= tree.NumEpochs() {
+            fmt.Printf("Error: cannot prove epoch %d is a prefix of epoch %d (have %d epochs)\n", *fromEpoch, *toEpoch, tree.NumEpochs())
+            return 1
+        }
+
+        proof := NewAppendOnlyProof(tree.ProveAppendOnly(*fromEpoch, *toEpoch))
+        newRoot := tree.GetEpochRoot(*toEpoch)
+        nodes, appended := proof.MarshalSolidity()
+        if err := VerifySolidityProof(nodes, appended, tree.GetEpochRoot(*fromEpoch), newRoot, tree.Options()); err != nil {
+            fmt.Printf("Error: the proof does not verify: %v\n", err)
+            return 1
+        }
+
+        calldata := SolidityCalldata(newRoot, proof)
+        if err := ioutil.WriteFile(*calldataFile, []byte("0x"+hex.EncodeToString(calldata)+"\n"), 0644); err != nil {
+            fmt.Printf("Error writing calldata: %v\n", err)
+            return 1
+        }
+        fmt.Printf("Wrote %d bytes of calldata (%d proof nodes) appending root %s to %s\n",
+            len(calldata), proof.NumNodes(), hashStr(newRoot), *calldataFile)
+    }
+    return 0
+}
@@ -0,0 +1,296 @@
+package client
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "hash"
+    "io"
+    "sort"
+)
+
+/**
+ * A node of an append-only proof, see ProofNode in package aomt.
+ */
+type ProofNode struct {
+    Level    int
+    NodeNo   [32]byte // the node's LN
+    Hash     [32]byte
+    IsNew    bool
+    Appended [][32]byte // the data hashes appended to an old leaf, oldest first
+}
+
+/**
+ * An append-only proof between two epochs, i.e., its nodes in any order.
+ */
+type AppendOnlyProof struct {
+    Nodes []ProofNode
+}
+
+// The flags of a node in the streamed encoding
+const (
+    proofFlagNew      = 1 << 0
+    proofFlagAppended = 1 << 1
+)
+
+/**
+ * Checks that the proof is a valid append-only proof from the tree with root 'oldRoot' to the
+ * tree with root 'newRoot'. An empty proof is only valid if the two roots are the same.
+ */
+func VerifyAppendOnly(proof *AppendOnlyProof, oldRoot [32]byte, newRoot [32]byte, opts Options) error {
+    if proof == nil {
+        return _verifyErrorf(ErrMalformedProof, "no append-only proof")
+    }
+    verifier, err := NewVerifier(oldRoot, newRoot, opts)
+    if err != nil {
+        return err
+    }
+
+    for _, node := range _leftToRight(proof.Nodes) {
+        if err := verifier.Add(node); err != nil {
+            return err
+        }
+    }
+    return verifier.Finish()
+}
+
+/**
+ * Returns the nodes sorted from left to right, i.e., by the first leaf of their subtrees (and,
+ * for nodes with the same first leaf, ancestors first), which is the order Verifier expects.
+ */
+func _leftToRight(nodes []ProofNode) []*ProofNode {
+    type sortedNode struct {
+        node      *ProofNode
+        firstLeaf [32]byte
+    }
+
+    sorted := make([]sortedNode, len(nodes))
+    for i := range nodes {
+        sorted[i] = sortedNode{&nodes[i], _lshBytes(nodes[i].NodeNo, uint(256-nodes[i].Level))}
+    }
+    sort.Slice(sorted, func(i, j int) bool {
+        if c := bytes.Compare(sorted[i].firstLeaf[:], sorted[j].firstLeaf[:]); c != 0 {
+            return c < 0
+        }
+        return sorted[i].node.Level < sorted[j].node.Level
+    })
+
+    ordered := make([]*ProofNode, len(sorted))
+    for i := range sorted {
+        ordered[i] = sorted[i].node
+    }
+    return ordered
+}
+
+/**
+ * Incrementally checks an append-only proof whose nodes are added from left to right, via Add(),
+ * keeping only the hashes of the left siblings along the current node's path, so memory use does
+ * not depend on the proof's size (see AppendOnlyVerifier in package aomt).
+ */
+type Verifier struct {
+    oldRoot   [32]byte
+    newRoot   [32]byte
+    newHash   func() hash.Hash
+    rfc6962   bool
+    scheme    HashingScheme
+    leafLevel int // the level of the leaves, below which there can be no proof nodes
+
+    // The left siblings along the path of the last added node, waiting for their right siblings
+    stack []verifierNode
+    // The root's hashes, once all of its subtrees were added
+    root *verifierNode
+}
+
+type verifierNode struct {
+    level  int
+    nodeNo [32]byte
+    old    [32]byte // the node's hash in the old tree
+    new    [32]byte // the node's hash in the new tree
+}
+
+/**
+ * Returns a verifier for an append-only proof from the tree with root 'oldRoot' to the tree with
+ * root 'newRoot'.
+ */
+func NewVerifier(oldRoot [32]byte, newRoot [32]byte, opts Options) (*Verifier, error) {
+    newHash, numLevels, err := opts._params()
+    if err != nil {
+        return nil, err
+    }
+    return &Verifier{oldRoot: oldRoot, newRoot: newRoot, newHash: newHash, rfc6962: opts.RFC6962, scheme: opts.HashingScheme, leafLevel: numLevels - 1}, nil
+}
+
+/**
+ * Adds the next proof node. Nodes must be added from left to right.
+ */
+func (verifier *Verifier) Add(node *ProofNode) error {
+    // Only leaves that existed before the batch can have appended versions
+    if len(node.Appended) > 0 {
+        if node.Level != verifier.leafLevel {
+            return _verifyErrorf(ErrMalformedProof, "proof node %s on level %d is not a leaf but has appended hashes", _hashStr(node.NodeNo), node.Level)
+        }
+        if node.IsNew {
+            return _verifyErrorf(ErrMalformedProof, "proof leaf %s is new but has appended hashes", _hashStr(node.NodeNo))
+        }
+    }
+
+    vn := verifierNode{level: node.Level, nodeNo: node.NodeNo, old: node.Hash, new: node.Hash}
+    if node.IsNew {
+        vn.old = [32]byte{}
+    }
+    for _, dataHash := range node.Appended {
+        vn.new = _nodeHash(verifier.newHash, verifier.rfc6962, vn.new, dataHash)
+    }
+    return verifier._push(vn)
+}
+
+/**
+ * Checks that the added nodes hash to the old and the new root.
+ */
+func (verifier *Verifier) Finish() error {
+    if verifier.root == nil {
+        if len(verifier.stack) > 0 {
+            top := verifier.stack[len(verifier.stack)-1]
+            return _verifyErrorf(ErrMalformedProof, "proof is missing the right sibling of node %s on level %d", _hashStr(top.nodeNo), top.level)
+        }
+        if !_hashEqual(verifier.oldRoot, verifier.newRoot) {
+            return _verifyErrorf(ErrNewRootMismatch, "empty append-only proof, but the roots differ")
+        }
+        return nil
+    }
+
+    if !_hashEqual(verifier.root.old, verifier.oldRoot) {
+        return _verifyErrorf(ErrOldRootMismatch, "old root hash check failed: got %s", _hashStr(verifier.root.old))
+    }
+    if !_hashEqual(verifier.root.new, verifier.newRoot) {
+        return _verifyErrorf(ErrNewRootMismatch, "new root hash check failed: got %s", _hashStr(verifier.root.new))
+    }
+    return nil
+}
+
+/**
+ * Adds a node with known hashes, hashing it with its left siblings on the stack for as long as it
+ * is a right child.
+ */
+func (verifier *Verifier) _push(node verifierNode) error {
+    if verifier.root != nil {
+        return _verifyErrorf(ErrMalformedProof, "proof has node %s on level %d after the root's subtrees", _hashStr(node.nodeNo), node.level)
+    }
+    if node.level < 0 || node.level > verifier.leafLevel {
+        return _verifyErrorf(ErrMalformedProof, "proof node %s has invalid level %d", _hashStr(node.nodeNo), node.level)
+    }
+    if !_fitsInLevel(node.nodeNo, node.level) {
+        return _verifyErrorf(ErrMalformedProof, "proof node %s has an LN that is too large for level %d", _hashStr(node.nodeNo), node.level)
+    }
+
+    // The node must be in the subtree of the right sibling of the node on top of the stack, or
+    // else the proof is not sorted (or has overlapping nodes)
+    if n := len(verifier.stack); n > 0 {
+        top := verifier.stack[n-1]
+        topSibling, _ := _siblingOf(top.nodeNo)
+        if node.level < top.level || _rshBytes(node.nodeNo, uint(node.level-top.level)) != topSibling {
+            return _verifyErrorf(ErrMalformedProof, "proof node %s on level %d is not to the right of node %s on level %d", _hashStr(node.nodeNo), node.level, _hashStr(top.nodeNo), top.level)
+        }
+    }
+
+    for node.level > 0 {
+        _, isLeft := _siblingOf(node.nodeNo)
+        if isLeft {
+            verifier.stack = append(verifier.stack, node)
+            return nil
+        }
+
+        // By the check above, the left sibling is on top of the stack if this is a right child
+        n := len(verifier.stack)
+        if n == 0 || verifier.stack[n-1].level != node.level {
+            return _verifyErrorf(ErrMalformedProof, "proof is missing the left sibling of node %s on level %d", _hashStr(node.nodeNo), node.level)
+        }
+        left := verifier.stack[n-1]
+        verifier.stack = verifier.stack[:n-1]
+
+        level, parentNo := node.level-1, _rshBytes(node.nodeNo, 1)
+        node = verifierNode{
+            level:  level,
+            nodeNo: parentNo,
+            old:    _schemeNodeHash(verifier.newHash, verifier.rfc6962, verifier.scheme, level, parentNo, left.old, node.old),
+            new:    _schemeNodeHash(verifier.newHash, verifier.rfc6962, verifier.scheme, level, parentNo, left.new, node.new),
+        }
+    }
+
+    verifier.root = &node
+    return nil
+}
+
+/**
+ * Checks a streamed append-only proof (see AppendOnlyProof::WriteStream() in package aomt)
+ * as it is read, from the tree with root 'oldRoot' to the tree with root 'newRoot'.
+ */
+func VerifyAppendOnlyStream(r io.Reader, oldRoot [32]byte, newRoot [32]byte, opts Options) error {
+    verifier, err := NewVerifier(oldRoot, newRoot, opts)
+    if err != nil {
+        return err
+    }
+    br := bufio.NewReader(r)
+
+    var numNodes uint32
+    if err := binary.Read(br, binary.BigEndian, &numNodes); err != nil {
+        return _verifyErrorf(ErrMalformedProof, "reading # of proof nodes: %v", err)
+    }
+    for i := 0; i < int(numNodes); i++ {
+        node, err := _readStreamedNode(br, i)
+        if err != nil {
+            return err
+        }
+        if err := verifier.Add(node); err != nil {
+            return err
+        }
+    }
+
+    if _, err := br.ReadByte(); err != io.EOF {
+        return _verifyErrorf(ErrMalformedProof, "trailing bytes after proof")
+    }
+    return verifier.Finish()
+}
+
+func _readStreamedNode(br *bufio.Reader, i int) (*ProofNode, error) {
+    var node ProofNode
+    var level uint16
+    if err := binary.Read(br, binary.BigEndian, &level); err != nil {
+        return nil, _verifyErrorf(ErrMalformedProof, "reading level of proof node %d: %v", i, err)
+    }
+    if level > 256 {
+        return nil, _verifyErrorf(ErrMalformedProof, "proof node %d has invalid level %d", i, level)
+    }
+    node.Level = int(level)
+
+    // A level-L node's LN has at most L bits, so only its last ceil(L / 8) bytes are encoded
+    lnNumBytes := (node.Level + 7) / 8
+    if _, err := io.ReadFull(br, node.NodeNo[32-lnNumBytes:]); err != nil {
+        return nil, _verifyErrorf(ErrMalformedProof, "reading LN of proof node %d: %v", i, err)
+    }
+
+    flags, err := br.ReadByte()
+    if err != nil {
+        return nil, _verifyErrorf(ErrMalformedProof, "reading flags of proof node %d: %v", i, err)
+    }
+    node.IsNew = flags&proofFlagNew != 0
+
+    if _, err := io.ReadFull(br, node.Hash[:]); err != nil {
+        return nil, _verifyErrorf(ErrMalformedProof, "reading hash of proof node %d: %v", i, err)
+    }
+
+    if flags&proofFlagAppended != 0 {
+        var numAppended uint32
+        if err := binary.Read(br, binary.BigEndian, &numAppended); err != nil {
+            return nil, _verifyErrorf(ErrMalformedProof, "reading # of appended hashes of proof node %d: %v", i, err)
+        }
+        for j := uint32(0); j < numAppended; j++ {
+            var dataHash [32]byte
+            if _, err := io.ReadFull(br, dataHash[:]); err != nil {
+                return nil, _verifyErrorf(ErrMalformedProof, "reading appended hash of proof node %d: %v", i, err)
+            }
+            node.Appended = append(node.Appended, dataHash)
+        }
+    }
+    return &node, nil
+}
@@ -0,0 +1,223 @@
+/**
+ * Package client verifies the proofs served by an append-only Merkle prefix tree (see the HTTP
+ * server in package aomt): membership and non-membership proofs, append-only proofs between
+ * epochs and signed tree heads. It is for auditors and other clients that never hold a tree, so
+ * unlike package aomt it has no per-level node maps and depends only on the standard library,
+ * which keeps binaries small and lets it compile to WebAssembly (GOOS=js GOARCH=wasm) for
+ * auditors running in a browser.
+ *
+ * NOTE: The hashing and encodings here mirror those of package aomt (see membership.go,
+ * values.go, commitments.go, stream.go, json.go and proto.go there), so any change to those must
+ * be made here too: client_test.go checks that the two agree on proofs served by package aomt for
+ * every hashing option.
+ */
+package client
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "hash"
+)
+
+// The # of levels of trees created via NewTree(257) (i.e., the server's default)
+const DefaultNumLevels = 257
+
+/**
+ * The parameters of the tree the proofs are about, i.e., the options it was created with via
+ * NewTreeWithOptions() in package aomt.
+ */
+type Options struct {
+    NewHash   func() hash.Hash // the hash function, SHA256 if nil
+    RFC6962   bool             // if true, leaves and internal nodes are domain-separated as in RFC 6962
+    NumLevels int              // DefaultNumLevels if 0
+
+    // How internal nodes are domain-separated by their position in the tree (see HashingScheme)
+    HashingScheme HashingScheme
+
+    // If true, membership proofs of existing leaves must carry the leaves' extensions (see
+    // ExtendedLeaf)
+    LeafExtensions bool
+
+    // The operator's HMAC key, if the tree keys its leaf hashes (see TreeOptions::HMACLeafHashes in
+    // package aomt). Without it, openings, values and extensions cannot be checked.
+    LeafHashKey []byte
+}
+
+/**
+ * Returns the hash function and # of levels of the options, or an error if they are invalid.
+ */
+func (opts Options) _params() (func() hash.Hash, int, error) {
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    if size := newHash().Size(); size != 32 {
+        return nil, 0, _verifyErrorf(ErrInvalidParams, "hash function must output 32-byte digests, got %d bytes", size)
+    }
+
+    numLevels := opts.NumLevels
+    if numLevels == 0 {
+        numLevels = DefaultNumLevels
+    }
+    if numLevels < 2 || numLevels > DefaultNumLevels {
+        return nil, 0, _verifyErrorf(ErrInvalidParams, "trees must have between 2 and %d levels, not %d", DefaultNumLevels, numLevels)
+    }
+    if opts.HashingScheme < HashingPlain || opts.HashingScheme > HashingLevelLN {
+        return nil, 0, _verifyErrorf(ErrInvalidParams, "unknown hashing scheme %d", int(opts.HashingScheme))
+    }
+    return newHash, numLevels, nil
+}
+
+// The prefixes of leaves and of internal nodes when hashing as in RFC 6962
+var rfc6962LeafPrefix = []byte{0x00}
+var rfc6962NodePrefix = []byte{0x01}
+
+/**
+ * Hashes the concatenation of 'parts' using the specified hash function.
+ */
+func _hashWith(newHash func() hash.Hash, parts ...[]byte) [32]byte {
+    digest := newHash()
+    for _, part := range parts {
+        digest.Write(part)
+    }
+
+    var h [32]byte
+    copy(h[:], digest.Sum(nil))
+    return h
+}
+
+/**
+ * Hashes two children hashes, domain-separated as in RFC 6962 if 'rfc6962' is true.
+ */
+func _nodeHash(newHash func() hash.Hash, rfc6962 bool, h1 [32]byte, h2 [32]byte) [32]byte {
+    if !rfc6962 {
+        return _hashWith(newHash, h1[:], h2[:])
+    }
+    return _hashWith(newHash, rfc6962NodePrefix, h1[:], h2[:])
+}
+
+/**
+ * How internal nodes are domain-separated by their position in the tree, see HashingScheme in
+ * package aomt.
+ */
+type HashingScheme int
+
+const (
+    // H(left || right)
+    HashingPlain HashingScheme = iota
+    // H(level || left || right), where the level is the node's, 2 bytes, big-endian
+    HashingLevel
+    // H(level || LN || left || right), where the LN is the node's, 32 bytes
+    HashingLevelLN
+)
+
+/**
+ * Hashes the children hashes of the internal node with LN 'nodeNo' on level 'level' as specified
+ * by 'scheme', after the RFC 6962 prefix if 'rfc6962' is true.
+ */
+func _schemeNodeHash(newHash func() hash.Hash, rfc6962 bool, scheme HashingScheme, level int, nodeNo [32]byte, h1 [32]byte, h2 [32]byte) [32]byte {
+    if scheme == HashingPlain {
+        return _nodeHash(newHash, rfc6962, h1, h2)
+    }
+
+    var prefix []byte
+    if rfc6962 {
+        prefix = append(prefix, rfc6962NodePrefix...)
+    }
+    prefix = append(prefix, byte(level>>8), byte(level))
+    if scheme == HashingLevelLN {
+        prefix = append(prefix, nodeNo[:]...)
+    }
+    return _hashWith(newHash, prefix, h1[:], h2[:])
+}
+
+/**
+ * Returns the hash of a leaf with the specified data hash, keyed with 'key' if not nil.
+ */
+func _leafHash(newHash func() hash.Hash, rfc6962 bool, key []byte, dataHash [32]byte) [32]byte {
+    if key != nil {
+        mac := hmac.New(newHash, key)
+        if rfc6962 {
+            mac.Write(rfc6962LeafPrefix)
+        }
+        mac.Write(dataHash[:])
+
+        var leafHash [32]byte
+        copy(leafHash[:], mac.Sum(nil))
+        return leafHash
+    }
+    if !rfc6962 {
+        return dataHash
+    }
+    return _hashWith(newHash, rfc6962LeafPrefix, dataHash[:])
+}
+
+/**
+ * Shifts 'num', a 256-bit big-endian number, to the right by 'bits' bits.
+ */
+func _rshBytes(num [32]byte, bits uint) [32]byte {
+    var shifted [32]byte
+    byteShift := int(bits / 8)
+    bitShift := bits % 8
+
+    for i := 31; i >= byteShift; i-- {
+        shifted[i] = num[i-byteShift] >> bitShift
+        if bitShift > 0 && i-byteShift > 0 {
+            shifted[i] |= num[i-byteShift-1] << (8 - bitShift)
+        }
+    }
+    return shifted
+}
+
+/**
+ * Shifts 'num', a 256-bit big-endian number, to the left by 'bits' bits, dropping the top bits.
+ */
+func _lshBytes(num [32]byte, bits uint) [32]byte {
+    var shifted [32]byte
+    byteShift := int(bits / 8)
+    bitShift := bits % 8
+
+    for i := 0; i < 32-byteShift; i++ {
+        shifted[i] = num[i+byteShift] << bitShift
+        if bitShift > 0 && i+byteShift < 31 {
+            shifted[i] |= num[i+byteShift+1] >> (8 - bitShift)
+        }
+    }
+    return shifted
+}
+
+/**
+ * Returns the i-th least significant bit of 'num'.
+ */
+func _bitOf(num [32]byte, i uint) byte {
+    return (num[31-i/8] >> (i % 8)) & 1
+}
+
+/**
+ * Returns the LN of the sibling of the node with LN 'nodeNo', and true if 'nodeNo' is a left child.
+ */
+func _siblingOf(nodeNo [32]byte) ([32]byte, bool) {
+    isLeft := nodeNo[31]&1 == 0
+    nodeNo[31] ^= 1
+    return nodeNo, isLeft
+}
+
+/**
+ * Returns true if 'nodeNo' is the LN of a node on the specified level, i.e., has at most 'level' bits.
+ */
+func _fitsInLevel(nodeNo [32]byte, level int) bool {
+    return level >= 256 || _rshBytes(nodeNo, uint(level)) == [32]byte{}
+}
+
+/**
+ * Returns true if the two hashes are equal, in constant time (see hashEqual() in package aomt).
+ */
+func _hashEqual(a [32]byte, b [32]byte) bool {
+    return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+func _hashStr(h [32]byte) string {
+    return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,351 @@
+package client
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    aomt ".."
+)
+
+/**
+ * The hashing options the client must agree with package aomt on: for each, the options the tree
+ * is created with and the client options matching them.
+ */
+var testCases = []struct {
+    name      string
+    numLevels int
+    treeOpts  aomt.TreeOptions
+    opts      Options
+}{
+    {"plain", 257, aomt.TreeOptions{}, Options{}},
+    {"rfc6962", 257, aomt.TreeOptions{RFC6962: true}, Options{RFC6962: true}},
+    {"level", 257, aomt.TreeOptions{HashingScheme: aomt.HashingLevel}, Options{HashingScheme: HashingLevel}},
+    {"level-ln", 257, aomt.TreeOptions{RFC6962: true, HashingScheme: aomt.HashingLevelLN}, Options{RFC6962: true, HashingScheme: HashingLevelLN}},
+    {"keccak", 257, aomt.TreeOptions{NewHash: aomt.NewKeccak256}, Options{NewHash: aomt.NewKeccak256}},
+    {"hmac", 257, aomt.TreeOptions{HMACKey: []byte("key"), HMACLeafHashes: true}, Options{LeafHashKey: []byte("key")}},
+    {"extensions", 257, aomt.TreeOptions{LeafExtensions: true}, Options{LeafExtensions: true}},
+    {"16 levels", 16, aomt.TreeOptions{HashingScheme: aomt.HashingLevelLN}, Options{NumLevels: 16, HashingScheme: HashingLevelLN}},
+}
+
+// The # of epochs of the test trees, and the # of leaves inserted in each
+const testNumEpochs = 3
+const testLeavesPerEpoch = 8
+
+/**
+ * Returns the leaf no of the i-th test leaf, which fits in a tree with 'numLevels' levels.
+ */
+func _testLeafNo(i int, numLevels int) [32]byte {
+    leafNo := sha256.Sum256([]byte(fmt.Sprintf("leaf %d", i)))
+    for bit := 0; bit < 257-numLevels; bit++ {
+        leafNo[bit/8] &^= 0x80 >> uint(bit%8)
+    }
+    return leafNo
+}
+
+/**
+ * Returns how _testServer() inserts leaf 'i': plainly (0), with a value (1), committed (2) or with
+ * extensions (3).
+ */
+func _testLeafKind(i int, treeOpts aomt.TreeOptions) int {
+    if treeOpts.LeafExtensions {
+        return 3
+    }
+    return i % 3
+}
+
+/**
+ * Returns a handler serving a tree with the specified options, whose epochs each insert
+ * testLeavesPerEpoch leaves: extended ones if the options require extensions, or else plain ones,
+ * ones with values and committed ones (see _testLeafKind()).
+ */
+func _testServer(t *testing.T, numLevels int, treeOpts aomt.TreeOptions) (*aomt.Tree, http.Handler) {
+    tree, err := aomt.NewTreeWithOptions(numLevels, treeOpts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for e := 0; e < testNumEpochs; e++ {
+        for i := e * testLeavesPerEpoch; i < (e+1)*testLeavesPerEpoch; i++ {
+            leafNo := _testLeafNo(i, numLevels)
+            value := []byte(fmt.Sprintf("value %d", i))
+            switch _testLeafKind(i, treeOpts) {
+            case 0:
+                tree.Insert(leafNo, sha256.Sum256(value), nil)
+            case 1:
+                tree.InsertValue(leafNo, value, nil)
+            case 2:
+                tree.InsertCommitted(leafNo, value, nil)
+            case 3:
+                tree.InsertWithExtensions(leafNo, sha256.Sum256(value), aomt.LeafExtensions{Timestamp: uint64(i), Policy: value}, nil)
+            }
+        }
+        tree.RecordEpoch()
+    }
+
+    srv := aomt.NewServer(aomt.WrapTree(tree), aomt.ServerOptions{APIKeys: []string{"key"}, RequestsPerSecond: 1e6, Burst: 1e6})
+    return tree, srv.Handler()
+}
+
+/**
+ * GETs 'path' from the handler and decodes the response into 'v' via the client's decoders.
+ */
+func _testGet(t *testing.T, handler http.Handler, path string, v json.Unmarshaler) {
+    req := httptest.NewRequest(http.MethodGet, path, nil)
+    req.Header.Set("Authorization", "Bearer key")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("GET %s: status %d: %s", path, rec.Code, rec.Body.String())
+    }
+    if err := v.UnmarshalJSON(rec.Body.Bytes()); err != nil {
+        t.Fatalf("GET %s: %v", path, err)
+    }
+}
+
+/**
+ * Returns the options with another hashing scheme, which proofs must not verify with.
+ */
+func _testOtherScheme(opts Options) Options {
+    opts.HashingScheme = (opts.HashingScheme + 1) % (HashingLevelLN + 1)
+    return opts
+}
+
+/**
+ * The proofs the server serves, decoded via the client's JSON decoders, must verify with the
+ * client's verifiers for every hashing option, both for leaves that exist and for leaves that do
+ * not (yet), and the append-only proofs between every two epochs must verify too.
+ */
+func TestClientVerifiesServerProofs(t *testing.T) {
+    for _, c := range testCases {
+        tree, handler := _testServer(t, c.numLevels, c.treeOpts)
+        latest := testNumEpochs - 1
+
+        for e := 0; e < testNumEpochs; e++ {
+            for i := 0; i < testNumEpochs*testLeavesPerEpoch+2; i++ {
+                leafNo := _testLeafNo(i, c.numLevels)
+                path := fmt.Sprintf("/v1/leaf/%s?epoch=%d&fresh=1&value=1", hex.EncodeToString(leafNo[:]), e)
+                var resp LeafResponse
+                _testGet(t, handler, path, &resp)
+
+                exists := i < (e+1)*testLeavesPerEpoch
+                if resp.Head.Epoch != e || resp.Head.Root != tree.GetEpochRoot(e) || resp.Exists != exists || resp.Proof.LeafNo != leafNo {
+                    t.Fatalf("%s: leaf %d at epoch %d has head %+v (exists: %v)", c.name, i, e, resp.Head, resp.Exists)
+                }
+                if err := resp.Verify(c.opts); err != nil {
+                    t.Errorf("%s: proof of leaf %d at epoch %d does not verify: %v", c.name, i, e, err)
+                }
+                if resp.Fresh == nil || resp.Fresh.NewHead.Epoch != latest || resp.Fresh.NewHead.Root != tree.GetEpochRoot(latest) {
+                    t.Errorf("%s: proof of leaf %d at epoch %d is not fresh", c.name, i, e)
+                }
+                if exists && c.opts.HashingScheme != HashingPlain || c.opts.HashingScheme == HashingPlain && i == 0 {
+                    if err := VerifyMembership(&resp.Proof, resp.Head.Root, _testOtherScheme(c.opts)); err == nil {
+                        t.Errorf("%s: proof of leaf %d at epoch %d verifies with another hashing scheme", c.name, i, e)
+                    }
+                }
+
+                // The leaves' values, openings and extensions are served as of every epoch
+                if kind := _testLeafKind(i, c.treeOpts); exists {
+                    kinds := []bool{false, resp.Proof.Value != nil, resp.Proof.Opening != nil, resp.Proof.Extended != nil}
+                    if kind != 0 && !kinds[kind] {
+                        t.Errorf("%s: proof of leaf %d at epoch %d is missing its value, opening or extensions", c.name, i, e)
+                    }
+                    if kind == 1 {
+                        if err := VerifyValue(&resp.Proof, resp.Head.Root, []byte(fmt.Sprintf("value %d", i)), c.opts); err != nil {
+                            t.Errorf("%s: value of leaf %d does not verify: %v", c.name, i, err)
+                        }
+                    }
+                }
+            }
+        }
+
+        for from := 0; from < testNumEpochs; from++ {
+            for to := from + 1; to < testNumEpochs; to++ {
+                var resp AppendOnlyResponse
+                _testGet(t, handler, fmt.Sprintf("/v1/proof/append-only?from=%d&to=%d", from, to), &resp)
+                if resp.OldHead.Root != tree.GetEpochRoot(from) || resp.NewHead.Root != tree.GetEpochRoot(to) {
+                    t.Fatalf("%s: append-only proof from %d to %d has heads %+v and %+v", c.name, from, to, resp.OldHead, resp.NewHead)
+                }
+                if err := resp.Verify(c.opts); err != nil {
+                    t.Errorf("%s: append-only proof from %d to %d does not verify: %v", c.name, from, to, err)
+                }
+                if err := VerifyAppendOnly(&resp.Proof, resp.OldHead.Root, resp.NewHead.Root, _testOtherScheme(c.opts)); err == nil {
+                    t.Errorf("%s: append-only proof from %d to %d verifies with another hashing scheme", c.name, from, to)
+                }
+
+                // The proof encoded by package aomt must decode to the same proof
+                data, err := json.Marshal(aomt.NewAppendOnlyProof(tree.ProveAppendOnly(from, to)))
+                if err != nil {
+                    t.Fatal(err)
+                }
+                var proof AppendOnlyProof
+                if err := proof.UnmarshalJSON(data); err != nil {
+                    t.Fatalf("%s: %v", c.name, err)
+                }
+                if err := VerifyAppendOnly(&proof, resp.OldHead.Root, resp.NewHead.Root, c.opts); err != nil {
+                    t.Errorf("%s: encoded append-only proof from %d to %d does not verify: %v", c.name, from, to, err)
+                }
+            }
+        }
+    }
+}
+
+/**
+ * Returns a copy of the proof, so that tampering with it leaves the original intact.
+ */
+func _testCopyMembership(proof *MembershipProof) *MembershipProof {
+    p := *proof
+    p.Siblings = append([][32]byte{}, proof.Siblings...)
+    if proof.Opening != nil {
+        opening := *proof.Opening
+        opening.Value = append([]byte{}, opening.Value...)
+        p.Opening = &opening
+    }
+    if proof.Value != nil {
+        p.Value = append([]byte{}, proof.Value...)
+    }
+    if proof.Extended != nil {
+        extended := *proof.Extended
+        p.Extended = &extended
+    }
+    return &p
+}
+
+func _testCopyAppendOnly(proof *AppendOnlyProof) *AppendOnlyProof {
+    return &AppendOnlyProof{Nodes: append([]ProofNode{}, proof.Nodes...)}
+}
+
+/**
+ * Tampering with any part of a server's proofs must make the client reject them, for every hashing
+ * option.
+ */
+func TestClientRejectsTamperedProofs(t *testing.T) {
+    for _, c := range testCases {
+        tree, handler := _testServer(t, c.numLevels, c.treeOpts)
+        latest := testNumEpochs - 1
+        root := tree.GetEpochRoot(latest)
+
+        for i := 0; i < testLeavesPerEpoch+1; i++ {
+            leafNo := _testLeafNo(i, c.numLevels)
+            if i == testLeavesPerEpoch {
+                leafNo = _testLeafNo(1000, c.numLevels) // never inserted
+            }
+            var resp LeafResponse
+            _testGet(t, handler, fmt.Sprintf("/v1/leaf/%s?epoch=%d&value=1", hex.EncodeToString(leafNo[:]), latest), &resp)
+            proof := &resp.Proof
+            if err := VerifyMembership(proof, root, c.opts); err != nil {
+                t.Fatalf("%s: proof of leaf %d does not verify: %v", c.name, i, err)
+            }
+
+            tampered := map[string]*MembershipProof{}
+            p := _testCopyMembership(proof)
+            p.LeafHash[0] ^= 1
+            tampered["leaf hash flipped"] = p
+            // The non-membership proof of a leaf is also that of its sibling, if both are empty
+            if p := _testCopyMembership(proof); p.Exists() {
+                p.LeafNo[31] ^= 1
+                tampered["leaf no flipped"] = p
+            }
+            p = _testCopyMembership(proof)
+            p.Siblings = p.Siblings[:len(p.Siblings)-1]
+            tampered["sibling dropped"] = p
+            p = _testCopyMembership(proof)
+            p.Siblings = append(p.Siblings, [32]byte{})
+            tampered["sibling added"] = p
+            if p := _testCopyMembership(proof); p.Value != nil {
+                p.Value[0] ^= 1
+                tampered["value flipped"] = p
+            }
+            if p := _testCopyMembership(proof); p.Opening != nil {
+                p.Opening.Salt[0] ^= 1
+                tampered["salt flipped"] = p
+            }
+            if p := _testCopyMembership(proof); p.Extended != nil {
+                p.Extended.Extensions.Timestamp++
+                tampered["timestamp changed"] = p
+            }
+            for name, p := range tampered {
+                if err := VerifyMembership(p, root, c.opts); err == nil {
+                    t.Errorf("%s: proof of leaf %d verifies with %s", c.name, i, name)
+                }
+            }
+            for j := range proof.Siblings {
+                p := _testCopyMembership(proof)
+                p.Siblings[j][0] ^= 1
+                if err := VerifyMembership(p, root, c.opts); err == nil {
+                    t.Errorf("%s: proof of leaf %d verifies with sibling %d flipped", c.name, i, j)
+                }
+            }
+            if err := VerifyMembership(proof, tree.GetEpochRoot(latest-1), c.opts); err == nil {
+                t.Errorf("%s: proof of leaf %d verifies against an old root", c.name, i)
+            }
+
+            resp.Exists = !resp.Exists
+            if err := resp.Verify(c.opts); err == nil {
+                t.Errorf("%s: response for leaf %d verifies with 'exists' flipped", c.name, i)
+            }
+        }
+
+        var resp AppendOnlyResponse
+        _testGet(t, handler, fmt.Sprintf("/v1/proof/append-only?from=0&to=%d", latest), &resp)
+        proof, oldRoot, newRoot := &resp.Proof, resp.OldHead.Root, resp.NewHead.Root
+        if err := VerifyAppendOnly(proof, oldRoot, newRoot, c.opts); err != nil {
+            t.Fatalf("%s: append-only proof does not verify: %v", c.name, err)
+        }
+        tampered := map[string]error{
+            "swapped roots":  VerifyAppendOnly(proof, newRoot, oldRoot, c.opts),
+            "other old root": VerifyAppendOnly(proof, tree.GetEpochRoot(1), newRoot, c.opts),
+            "other new root": VerifyAppendOnly(proof, oldRoot, tree.GetEpochRoot(1), c.opts),
+            "no nodes":       VerifyAppendOnly(&AppendOnlyProof{}, oldRoot, newRoot, c.opts),
+        }
+        for name, err := range tampered {
+            if err == nil {
+                t.Errorf("%s: append-only proof verifies with %s", c.name, name)
+            }
+        }
+        for j := range proof.Nodes {
+            p := _testCopyAppendOnly(proof)
+            p.Nodes[j].Hash[0] ^= 1
+            if err := VerifyAppendOnly(p, oldRoot, newRoot, c.opts); err == nil {
+                t.Errorf("%s: append-only proof verifies with the hash of node %d flipped", c.name, j)
+            }
+            // Empty nodes hash the same whether they are new or not
+            p = _testCopyAppendOnly(proof)
+            p.Nodes[j].IsNew = !p.Nodes[j].IsNew
+            if err := VerifyAppendOnly(p, oldRoot, newRoot, c.opts); err == nil && p.Nodes[j].Hash != ([32]byte{}) {
+                t.Errorf("%s: append-only proof verifies with node %d's 'isNew' flipped", c.name, j)
+            }
+            p = _testCopyAppendOnly(proof)
+            p.Nodes = append(p.Nodes[:j], p.Nodes[j+1:]...)
+            if err := VerifyAppendOnly(p, oldRoot, newRoot, c.opts); err == nil {
+                t.Errorf("%s: append-only proof verifies with node %d dropped", c.name, j)
+            }
+        }
+    }
+}
+
+/**
+ * The client's decoders must reject what package aomt would never encode, rather than decode it
+ * into proofs that happen to verify.
+ */
+func TestClientRejectsMalformedJSON(t *testing.T) {
+    hash := hex.EncodeToString(make([]byte, 32))
+    malformed := map[string]json.Unmarshaler{
+        `{"epoch": 0, "root": "00", "exists": false, "leaf": "` + hash + `", "leafHash": "` + hash + `", "siblings": []}`: &LeafResponse{},
+        `{"epoch": 0, "root": "` + hash + `", "exists": false, "leaf": "zz", "leafHash": "` + hash + `", "siblings": []}`: &LeafResponse{},
+        `{"leaf": "` + hash + `", "leafHash": "` + hash + `", "siblings": ["00"]}`:                                      &MembershipProof{},
+        `{"leaf": "` + hash + `", "leafHash": "` + hash + `", "siblings": [], "other": 1}`:                              &MembershipProof{},
+        `{"leaf": "` + hash + `", "leafHash": "` + hash + `", "siblings": []} {}`:                                       &MembershipProof{},
+        `{"from": 0, "to": 1, "oldRoot": "` + hash + `", "newRoot": "0", "nodes": []}`:                                   &AppendOnlyResponse{},
+        `{"nodes": [{"level": 0, "node": "` + hash + `", "hash": "00", "isNew": true}]}`:                               &AppendOnlyProof{},
+        `{"nodes": 1}`: &AppendOnlyProof{},
+    }
+    for data, v := range malformed {
+        if err := v.UnmarshalJSON([]byte(data)); err == nil {
+            t.Errorf("decoded %s into %T", data, v)
+        }
+    }
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+    "errors"
+    "fmt"
+)
+
+/**
+ * The classes of verification failures, as in package aomt (see verifyerrors.go there), so
+ * that clients can branch on them via errors.Is() and get the details via errors.As() and
+ * VerifyError. Clients also check signatures, whose failures are ErrInvalidSignature.
+ */
+var (
+    // The proof does not hash to the old root (append-only proofs)
+    ErrOldRootMismatch = errors.New("old root mismatch")
+    // The proof hashes to the old root, but not to the new root (append-only proofs), or the two
+    // heads of an epoch have different roots
+    ErrNewRootMismatch = errors.New("new root mismatch")
+    // The proof does not hash to the root (membership proofs)
+    ErrRootMismatch = errors.New("root mismatch")
+    // The proof is missing or not well-formed, e.g., nodes are missing, out of order or on invalid
+    // levels
+    ErrMalformedProof = errors.New("malformed proof")
+    // The leaf's opening, value or extensions do not match its hash or are missing, or the leaf is
+    // not in the tree when it should be
+    ErrLeafMismatch = errors.New("leaf mismatch")
+    // The verifier's parameters (e.g., the hash function, # of levels, heads or public keys) are
+    // invalid
+    ErrInvalidParams = errors.New("invalid parameters")
+    // A head is not signed, or not cosigned by enough witnesses
+    ErrInvalidSignature = errors.New("invalid signature")
+)
+
+/**
+ * A verification failure: one of the Err* classes above, along with what exactly failed.
+ */
+type VerifyError struct {
+    Kind error
+    Msg  string
+}
+
+func (e *VerifyError) Error() string {
+    return e.Msg
+}
+
+func (e *VerifyError) Unwrap() error {
+    return e.Kind
+}
+
+func _verifyErrorf(kind error, format string, args ...interface{}) error {
+    return &VerifyError{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+    "bytes"
+    "crypto/ed25519"
+    "encoding/binary"
+    "fmt"
+)
+
+/**
+ * The root hash of the tree at the end of an epoch.
+ */
+type TreeHead struct {
+    Epoch int
+    Root  [32]byte
+}
+
+/**
+ * A tree head signed by the server. The signature is over TreeHead::MarshalProto() and its scheme
+ * is up to the application (see Tree::SetTreeHeadSigner() in package aomt).
+ */
+type SignedTreeHead struct {
+    Head      TreeHead
+    Signature []byte
+}
+
+/**
+ * Returns the protobuf encoding of the head (see aomt.proto), which is what servers sign. As in
+ * package aomt, fields with default values are omitted, so the encoding is deterministic.
+ */
+func (head TreeHead) MarshalProto() []byte {
+    var buf bytes.Buffer
+    if head.Epoch != 0 {
+        _writeProtoVarint(&buf, 1<<3|0)
+        _writeProtoVarint(&buf, uint64(head.Epoch))
+    }
+    if head.Root != ([32]byte{}) {
+        _writeProtoVarint(&buf, 2<<3|2)
+        _writeProtoVarint(&buf, 32)
+        buf.Write(head.Root[:])
+    }
+    return buf.Bytes()
+}
+
+func _writeProtoVarint(buf *bytes.Buffer, v uint64) {
+    var varint [binary.MaxVarintLen64]byte
+    buf.Write(varint[:binary.PutUvarint(varint[:], v)])
+}
+
+/**
+ * Checks the head's signature via 'verify', which checks a signature of the specified message
+ * under the server's public key.
+ */
+func VerifySignedTreeHead(sth *SignedTreeHead, verify func(message []byte, signature []byte) bool) error {
+    if len(sth.Signature) == 0 {
+        return _verifyErrorf(ErrInvalidSignature, "the head of epoch %d is not signed", sth.Head.Epoch)
+    }
+    if !verify(sth.Head.MarshalProto(), sth.Signature) {
+        return _verifyErrorf(ErrInvalidSignature, "invalid signature on the head of epoch %d", sth.Head.Epoch)
+    }
+    return nil
+}
+
+/**
+ * Like VerifySignedTreeHead(), for servers that sign heads with Ed25519.
+ */
+func VerifySignedTreeHeadEd25519(sth *SignedTreeHead, pk ed25519.PublicKey) error {
+    if len(pk) != ed25519.PublicKeySize {
+        return _verifyErrorf(ErrInvalidParams, "invalid Ed25519 public key")
+    }
+    return VerifySignedTreeHead(sth, func(message []byte, signature []byte) bool {
+        return ed25519.Verify(pk, message, signature)
+    })
+}
+
+/**
+ * Checks that the tree with head 'newHead' extends the one with head 'oldHead', i.e., that the
+ * proof is a valid append-only proof between their roots, e.g., for an auditor moving from the
+ * last head it checked to the latest one. The heads' signatures are not checked.
+ */
+func VerifyExtends(oldHead TreeHead, newHead TreeHead, proof *AppendOnlyProof, opts Options) error {
+    if newHead.Epoch < oldHead.Epoch {
+        return _verifyErrorf(ErrInvalidParams, "epoch %d is before epoch %d", newHead.Epoch, oldHead.Epoch)
+    }
+    if newHead.Epoch == oldHead.Epoch {
+        if !_hashEqual(newHead.Root, oldHead.Root) {
+            return _verifyErrorf(ErrNewRootMismatch, "epoch %d has two roots", newHead.Epoch)
+        }
+        return nil
+    }
+    if err := VerifyAppendOnly(proof, oldHead.Root, newHead.Root, opts); err != nil {
+        return fmt.Errorf("epoch %d is not a prefix of epoch %d: %w", oldHead.Epoch, newHead.Epoch, err)
+    }
+    return nil
+}
+
+// The prefix of the messages witnesses sign (see cosign.go in package aomt)
+const cosignaturePrefix = "aomt-cosignature/v1\n"
+
+/**
+ * A witness's signature of a tree head.
+ */
+type Cosignature struct {
+    WitnessID string
+    Signature []byte
+}
+
+/**
+ * A signed head along with the cosignatures of the witnesses that vouched for it.
+ */
+type CosignedTreeHead struct {
+    SignedTreeHead
+    Cosignatures []Cosignature
+}
+
+/**
+ * Returns the message witnesses sign for a head, i.e., a domain separation prefix followed by
+ * TreeHead::MarshalProto().
+ */
+func CosignatureMessage(head TreeHead) []byte {
+    return append([]byte(cosignaturePrefix), head.MarshalProto()...)
+}
+
+/**
+ * Checks that at least 'threshold' of the witnesses in 'witnesses', which maps witness IDs to
+ * functions that check a signature of a message under the witness's public key, cosigned the head.
+ * Cosignatures of unknown witnesses are ignored and each witness counts once, so a threshold of k
+ * means k distinct known witnesses. The server's signature is not checked (see
+ * VerifySignedTreeHead()).
+ */
+func VerifyCosignatures(cth *CosignedTreeHead, witnesses map[string]func(message []byte, signature []byte) bool, threshold int) error {
+    if threshold < 1 || threshold > len(witnesses) {
+        return _verifyErrorf(ErrInvalidParams, "invalid threshold %d for %d witnesses", threshold, len(witnesses))
+    }
+
+    message := CosignatureMessage(cth.Head)
+    valid := make(map[string]bool)
+    for _, cosig := range cth.Cosignatures {
+        verify, ok := witnesses[cosig.WitnessID]
+        if !ok || valid[cosig.WitnessID] {
+            continue
+        }
+        if verify(message, cosig.Signature) {
+            valid[cosig.WitnessID] = true
+        }
+    }
+
+    if len(valid) < threshold {
+        return _verifyErrorf(ErrInvalidSignature, "the head of epoch %d has %d valid cosignatures but %d are required", cth.Head.Epoch, len(valid), threshold)
+    }
+    return nil
+}
+
+/**
+ * Like VerifyCosignatures(), for witnesses that cosign with Ed25519, given their public keys.
+ */
+func VerifyCosignaturesEd25519(cth *CosignedTreeHead, witnessKeys map[string]ed25519.PublicKey, threshold int) error {
+    witnesses := make(map[string]func(message []byte, signature []byte) bool, len(witnessKeys))
+    for id, pk := range witnessKeys {
+        if len(pk) != ed25519.PublicKeySize {
+            return _verifyErrorf(ErrInvalidParams, "invalid Ed25519 public key for witness '%s'", id)
+        }
+        pk := pk
+        witnesses[id] = func(message []byte, signature []byte) bool {
+            return ed25519.Verify(pk, message, signature)
+        }
+    }
+    return VerifyCosignatures(cth, witnesses, threshold)
+}
@@ -0,0 +1,372 @@
+package client
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+)
+
+/**
+ * Decoding of the JSON the HTTP server sends (see json.go and server.go in package aomt).
+ * Proofs are decoded as strictly as package aomt decodes them, while responses ignore fields
+ * they do not know, so that clients keep working when the server adds some.
+ */
+
+type treeHeadJSON struct {
+    Epoch     int    `json:"epoch"`
+    Root      string `json:"root"`
+    Signature string `json:"signature,omitempty"`
+}
+
+func (head *TreeHead) UnmarshalJSON(data []byte) error {
+    var v treeHeadJSON
+    if err := json.Unmarshal(data, &v); err != nil {
+        return err
+    }
+    if v.Epoch < 0 {
+        return fmt.Errorf("invalid epoch %d", v.Epoch)
+    }
+
+    root, err := _hashFromJSON("root", v.Root)
+    if err != nil {
+        return err
+    }
+    *head = TreeHead{Epoch: v.Epoch, Root: root}
+    return nil
+}
+
+/**
+ * Decodes a signed head as sent by /v1/roots/stream, i.e., a head with a hex-encoded signature.
+ */
+func (sth *SignedTreeHead) UnmarshalJSON(data []byte) error {
+    var v treeHeadJSON
+    if err := json.Unmarshal(data, &v); err != nil {
+        return err
+    }
+
+    var head TreeHead
+    if err := head.UnmarshalJSON(data); err != nil {
+        return err
+    }
+    signature, err := _bytesFromJSON("signature", v.Signature)
+    if err != nil {
+        return err
+    }
+    *sth = SignedTreeHead{Head: head, Signature: signature}
+    return nil
+}
+
+type openingJSON struct {
+    Salt  string `json:"salt"`
+    Value string `json:"value"`
+}
+
+func (opening *Opening) UnmarshalJSON(data []byte) error {
+    var v openingJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+
+    salt, err := _hashFromJSON("salt", v.Salt)
+    if err != nil {
+        return err
+    }
+    value, err := _bytesFromJSON("value", v.Value)
+    if err != nil {
+        return err
+    }
+    *opening = Opening{Salt: salt, Value: value}
+    return nil
+}
+
+type extendedLeafJSON struct {
+    DataHash  string `json:"dataHash"`
+    Timestamp uint64 `json:"timestamp"`
+    Expiry    uint64 `json:"expiry"`
+    Policy    string  `json:"policy"`
+    Epoch     *uint64 `json:"epoch,omitempty"`
+}
+
+func (leaf *ExtendedLeaf) UnmarshalJSON(data []byte) error {
+    var v extendedLeafJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+
+    dataHash, err := _hashFromJSON("dataHash", v.DataHash)
+    if err != nil {
+        return err
+    }
+    policy, err := _bytesFromJSON("policy", v.Policy)
+    if err != nil {
+        return err
+    }
+    if len(policy) == 0 {
+        policy = nil
+    }
+    *leaf = ExtendedLeaf{DataHash: dataHash, Extensions: LeafExtensions{Timestamp: v.Timestamp, Expiry: v.Expiry, Policy: policy, Epoch: v.Epoch}}
+    return nil
+}
+
+type membershipProofJSON struct {
+    Leaf     string        `json:"leaf"`
+    LeafHash string        `json:"leafHash"`
+    Siblings []string      `json:"siblings"`
+    Opening  *Opening      `json:"opening,omitempty"`
+    Value    *string       `json:"value,omitempty"` // hex-encoded, a pointer so that empty values are kept
+    Extended *ExtendedLeaf `json:"extended,omitempty"`
+}
+
+func (proof *MembershipProof) UnmarshalJSON(data []byte) error {
+    var v membershipProofJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+    return proof._fromJSON(&v)
+}
+
+func (proof *MembershipProof) _fromJSON(v *membershipProofJSON) error {
+    var p MembershipProof
+    var err error
+    if p.LeafNo, err = _hashFromJSON("leaf", v.Leaf); err != nil {
+        return err
+    }
+    if p.LeafHash, err = _hashFromJSON("leafHash", v.LeafHash); err != nil {
+        return err
+    }
+    if p.Siblings, err = _hashesFromJSON("siblings", v.Siblings); err != nil {
+        return err
+    }
+    p.Opening = v.Opening
+    if v.Value != nil {
+        if p.Value, err = _bytesFromJSON("value", *v.Value); err != nil {
+            return err
+        }
+        p.Value = append([]byte{}, p.Value...)
+    }
+    p.Extended = v.Extended
+    *proof = p
+    return nil
+}
+
+type proofNodeJSON struct {
+    Level    int      `json:"level"`
+    LN       string   `json:"ln"`
+    Hash     string   `json:"hash"`
+    IsNew    bool     `json:"isNew,omitempty"`
+    Appended []string `json:"appended,omitempty"`
+}
+
+func (node *ProofNode) UnmarshalJSON(data []byte) error {
+    var v proofNodeJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+    if v.Level < 0 || v.Level > 256 {
+        return fmt.Errorf("invalid level %d", v.Level)
+    }
+
+    n := ProofNode{Level: v.Level, IsNew: v.IsNew}
+    var err error
+    if n.NodeNo, err = _hashFromJSON("ln", v.LN); err != nil {
+        return err
+    }
+    // A level-L node's LN has at most L bits
+    if !_fitsInLevel(n.NodeNo, v.Level) {
+        return fmt.Errorf("LN %s is too large for level %d", v.LN, v.Level)
+    }
+    if n.Hash, err = _hashFromJSON("hash", v.Hash); err != nil {
+        return err
+    }
+    if n.Appended, err = _hashesFromJSON("appended", v.Appended); err != nil {
+        return err
+    }
+    *node = n
+    return nil
+}
+
+type appendOnlyProofJSON struct {
+    Nodes []ProofNode `json:"nodes"`
+}
+
+func (proof *AppendOnlyProof) UnmarshalJSON(data []byte) error {
+    var v appendOnlyProofJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+    if v.Nodes == nil {
+        return errors.New("missing 'nodes'")
+    }
+    proof.Nodes = v.Nodes
+    return nil
+}
+
+/**
+ * A response of the server's /v1/leaf/ endpoint: the proof that the leaf is (or is not) in the
+ * tree as of the head's epoch, and, if asked for via 'fresh=1', the append-only proof from that
+ * epoch to the latest one.
+ */
+type LeafResponse struct {
+    Head   TreeHead
+    Exists bool
+    Proof  MembershipProof
+    Fresh  *AppendOnlyResponse // nil unless asked for
+}
+
+type leafResponseJSON struct {
+    membershipProofJSON
+    Epoch  int            `json:"epoch"`
+    Root   string         `json:"root"`
+    Exists bool           `json:"exists"`
+    Fresh  *freshnessJSON `json:"fresh,omitempty"`
+}
+
+type freshnessJSON struct {
+    To      int         `json:"to"`
+    NewRoot string      `json:"newRoot"`
+    Nodes   []ProofNode `json:"nodes"`
+}
+
+func (resp *LeafResponse) UnmarshalJSON(data []byte) error {
+    var v leafResponseJSON
+    if err := json.Unmarshal(data, &v); err != nil {
+        return err
+    }
+
+    r := LeafResponse{Exists: v.Exists}
+    if err := r.Proof._fromJSON(&v.membershipProofJSON); err != nil {
+        return err
+    }
+    var err error
+    r.Head.Epoch = v.Epoch
+    if r.Head.Root, err = _hashFromJSON("root", v.Root); err != nil {
+        return err
+    }
+    if v.Fresh != nil {
+        r.Fresh = &AppendOnlyResponse{OldHead: r.Head, NewHead: TreeHead{Epoch: v.Fresh.To}}
+        if r.Fresh.NewHead.Root, err = _hashFromJSON("fresh.newRoot", v.Fresh.NewRoot); err != nil {
+            return err
+        }
+        r.Fresh.Proof.Nodes = v.Fresh.Nodes
+    }
+    *resp = r
+    return nil
+}
+
+/**
+ * Checks the membership proof against the head's root, and that the tree with the fresh head (if
+ * any) extends it. The head itself is not checked: the caller must check that its root is the
+ * one everybody else sees, e.g., via a signed head (see VerifySignedTreeHead()) or an anchor.
+ */
+func (resp *LeafResponse) Verify(opts Options) error {
+    if resp.Exists != resp.Proof.Exists() {
+        return errors.New("'exists' does not match the proof")
+    }
+    if err := VerifyMembership(&resp.Proof, resp.Head.Root, opts); err != nil {
+        return err
+    }
+    if resp.Fresh != nil {
+        return resp.Fresh.Verify(opts)
+    }
+    return nil
+}
+
+/**
+ * A response of the server's /v1/proof/append-only endpoint.
+ */
+type AppendOnlyResponse struct {
+    OldHead TreeHead
+    NewHead TreeHead
+    Proof   AppendOnlyProof
+}
+
+type appendOnlyResponseJSON struct {
+    From    int         `json:"from"`
+    To      int         `json:"to"`
+    OldRoot string      `json:"oldRoot"`
+    NewRoot string      `json:"newRoot"`
+    Nodes   []ProofNode `json:"nodes"`
+}
+
+func (resp *AppendOnlyResponse) UnmarshalJSON(data []byte) error {
+    var v appendOnlyResponseJSON
+    if err := json.Unmarshal(data, &v); err != nil {
+        return err
+    }
+
+    r := AppendOnlyResponse{OldHead: TreeHead{Epoch: v.From}, NewHead: TreeHead{Epoch: v.To}}
+    var err error
+    if r.OldHead.Root, err = _hashFromJSON("oldRoot", v.OldRoot); err != nil {
+        return err
+    }
+    if r.NewHead.Root, err = _hashFromJSON("newRoot", v.NewRoot); err != nil {
+        return err
+    }
+    r.Proof.Nodes = v.Nodes
+    *resp = r
+    return nil
+}
+
+/**
+ * Checks that the tree with the new head extends the one with the old head (see VerifyExtends()).
+ * As with LeafResponse::Verify(), the heads themselves are not checked.
+ */
+func (resp *AppendOnlyResponse) Verify(opts Options) error {
+    return VerifyExtends(resp.OldHead, resp.NewHead, &resp.Proof, opts)
+}
+
+func _unmarshalStrict(data []byte, v interface{}) error {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(v); err != nil {
+        return err
+    }
+    if dec.More() {
+        return errors.New("trailing data after JSON value")
+    }
+    return nil
+}
+
+func _hashesFromJSON(field string, strs []string) ([][32]byte, error) {
+    var hashes [][32]byte
+    for i, s := range strs {
+        h, err := _hashFromJSON(fmt.Sprintf("%s[%d]", field, i), s)
+        if err != nil {
+            return nil, err
+        }
+        hashes = append(hashes, h)
+    }
+    return hashes, nil
+}
+
+func _hashFromJSON(field string, s string) ([32]byte, error) {
+    var h [32]byte
+    b, err := _bytesFromJSON(field, s)
+    if err != nil {
+        return h, err
+    }
+    if len(b) != 32 {
+        return h, fmt.Errorf("'%s' must be 32 bytes, got %d", field, len(b))
+    }
+    copy(h[:], b)
+    return h, nil
+}
+
+/**
+ * Decodes lowercase hex, so that every byte string has exactly one encoding.
+ */
+func _bytesFromJSON(field string, s string) ([]byte, error) {
+    for _, c := range s {
+        if c >= 'A' && c <= 'F' {
+            return nil, fmt.Errorf("'%s' must be lowercase hex", field)
+        }
+    }
+
+    b, err := hex.DecodeString(s)
+    if err != nil {
+        return nil, fmt.Errorf("'%s' is not hex: %v", field, err)
+    }
+    return b, nil
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+    "crypto/subtle"
+    "encoding/binary"
+    "hash"
+)
+
+/**
+ * A membership (or non-membership) proof for a leaf, see MembershipProof in package aomt.
+ */
+type MembershipProof struct {
+    LeafNo   [32]byte
+    LeafHash [32]byte   // the empty hash, if this is a non-membership proof
+    Siblings [][32]byte // Siblings[0] is the leaf's sibling, Siblings[len - 1] is the root's child
+
+    // The opening of the leaf's value, if it was committed to (see Opening)
+    Opening *Opening
+
+    // The leaf's value, if it has one and the server included it (see ValueHash())
+    Value []byte
+
+    // The leaf's data hash and extensions, if it was inserted with extensions (see ExtendedLeaf)
+    Extended *ExtendedLeaf
+}
+
+/**
+ * The opening of a hiding commitment to a leaf value: the leaf's data hash is H(Salt || Value).
+ */
+type Opening struct {
+    Salt  [32]byte
+    Value []byte
+}
+
+/**
+ * Returns the commitment to the opening's value, i.e., the leaf's data hash.
+ */
+func (opening *Opening) Commitment(newHash func() hash.Hash) [32]byte {
+    return _hashWith(newHash, opening.Salt[:], opening.Value)
+}
+
+// The domain separation tag of value data hashes, see ValueHash()
+var valueHashTag = []byte("aomt-leaf-value")
+
+/**
+ * Returns the data hash of a leaf with the specified value, i.e., H(tag || leafNo || len(value) ||
+ * value), where the length is 8 bytes, big-endian.
+ */
+func ValueHash(newHash func() hash.Hash, leafNo [32]byte, value []byte) [32]byte {
+    var length [8]byte
+    binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+    return _hashWith(newHash, valueHashTag, leafNo[:], length[:], value)
+}
+
+// The domain separation tags of extended data hashes without and with an epoch, see ExtendedLeafHash()
+var leafExtensionsTag = []byte("aomt-leaf-ext")
+var leafExtensionsEpochTag = []byte("aomt-leaf-ext-epoch")
+
+/**
+ * The metadata a leaf commits to besides its data hash, see LeafExtensions in package aomt.
+ */
+type LeafExtensions struct {
+    Timestamp uint64
+    Expiry    uint64
+    Policy    []byte
+    Epoch     *uint64 // the epoch the leaf was inserted in, if it commits to one
+}
+
+/**
+ * A leaf's data hash and extensions, i.e., what its extended data hash commits to.
+ */
+type ExtendedLeaf struct {
+    DataHash   [32]byte
+    Extensions LeafExtensions
+}
+
+/**
+ * Returns the data hash of leaf 'leafNo' with data hash 'dataHash' and the specified extensions,
+ * i.e., H(tag || leafNo || dataHash || timestamp || expiry || len(policy) || policy), where the
+ * integers are 8 bytes, big-endian, or H(epochTag || leafNo || dataHash || timestamp || expiry ||
+ * epoch || len(policy) || policy) if the extensions have an epoch.
+ */
+func ExtendedLeafHash(newHash func() hash.Hash, leafNo [32]byte, dataHash [32]byte, ext LeafExtensions) [32]byte {
+    if ext.Epoch != nil {
+        var ints [32]byte
+        binary.BigEndian.PutUint64(ints[0:], ext.Timestamp)
+        binary.BigEndian.PutUint64(ints[8:], ext.Expiry)
+        binary.BigEndian.PutUint64(ints[16:], *ext.Epoch)
+        binary.BigEndian.PutUint64(ints[24:], uint64(len(ext.Policy)))
+        return _hashWith(newHash, leafExtensionsEpochTag, leafNo[:], dataHash[:], ints[:], ext.Policy)
+    }
+
+    var ints [24]byte
+    binary.BigEndian.PutUint64(ints[0:], ext.Timestamp)
+    binary.BigEndian.PutUint64(ints[8:], ext.Expiry)
+    binary.BigEndian.PutUint64(ints[16:], uint64(len(ext.Policy)))
+    return _hashWith(newHash, leafExtensionsTag, leafNo[:], dataHash[:], ints[:], ext.Policy)
+}
+
+/**
+ * Returns true if the proof is a membership proof, i.e., the leaf is in the tree. Leaves never
+ * have the empty hash.
+ */
+func (proof *MembershipProof) Exists() bool {
+    return proof.LeafHash != ([32]byte{})
+}
+
+/**
+ * Checks a membership (or non-membership, see Exists()) proof against the specified root hash,
+ * along with the opening and value of the leaf, if the proof has them.
+ */
+func VerifyMembership(proof *MembershipProof, rootHash [32]byte, opts Options) error {
+    newHash, numLevels, err := opts._params()
+    if err != nil {
+        return err
+    }
+    if proof == nil {
+        return _verifyErrorf(ErrMalformedProof, "no membership proof")
+    }
+
+    leafLevel := numLevels - 1
+    if len(proof.Siblings) != leafLevel {
+        return _verifyErrorf(ErrMalformedProof, "proof has %d siblings, but a tree with %d levels needs %d", len(proof.Siblings), numLevels, leafLevel)
+    }
+    if !_fitsInLevel(proof.LeafNo, leafLevel) {
+        return _verifyErrorf(ErrMalformedProof, "leaf %s is too large for level %d", _hashStr(proof.LeafNo), leafLevel)
+    }
+
+    if proof.Opening != nil || proof.Value != nil || proof.Extended != nil {
+        if !proof.Exists() {
+            return _verifyErrorf(ErrMalformedProof, "non-membership proof has an opening, a value or extensions")
+        }
+    }
+    if proof.Opening != nil {
+        dataHash := proof.Opening.Commitment(newHash)
+        if !_hashEqual(_leafHash(newHash, opts.RFC6962, opts.LeafHashKey, dataHash), proof.LeafHash) {
+            return _verifyErrorf(ErrLeafMismatch, "the opening does not match the leaf hash")
+        }
+    }
+    if proof.Value != nil {
+        dataHash := ValueHash(newHash, proof.LeafNo, proof.Value)
+        if !_hashEqual(_leafHash(newHash, opts.RFC6962, opts.LeafHashKey, dataHash), proof.LeafHash) {
+            return _verifyErrorf(ErrLeafMismatch, "the value does not match the leaf hash")
+        }
+    }
+    if proof.Extended != nil {
+        dataHash := ExtendedLeafHash(newHash, proof.LeafNo, proof.Extended.DataHash, proof.Extended.Extensions)
+        if !_hashEqual(_leafHash(newHash, opts.RFC6962, opts.LeafHashKey, dataHash), proof.LeafHash) {
+            return _verifyErrorf(ErrLeafMismatch, "the extensions do not match the leaf hash")
+        }
+    } else if opts.LeafExtensions && proof.Exists() {
+        return _verifyErrorf(ErrLeafMismatch, "the proof of leaf %s has no extensions", _hashStr(proof.LeafNo))
+    }
+
+    var emptyHash [32]byte
+    nodeHash := proof.LeafHash
+    for i, siblingHash := range proof.Siblings {
+        // Nodes with empty subtrees are not stored in the tree, so their hash is the empty hash
+        // rather than the hash of their two empty children
+        if nodeHash == emptyHash && siblingHash == emptyHash {
+            continue
+        }
+
+        // The ancestor at this level is a left child if its last bit is 0
+        parentLevel, parentNo := leafLevel-1-i, _rshBytes(proof.LeafNo, uint(i+1))
+        if _bitOf(proof.LeafNo, uint(i)) == 0 {
+            nodeHash = _schemeNodeHash(newHash, opts.RFC6962, opts.HashingScheme, parentLevel, parentNo, nodeHash, siblingHash)
+        } else {
+            nodeHash = _schemeNodeHash(newHash, opts.RFC6962, opts.HashingScheme, parentLevel, parentNo, siblingHash, nodeHash)
+        }
+    }
+
+    if !_hashEqual(nodeHash, rootHash) {
+        return _verifyErrorf(ErrRootMismatch, "root hash check failed: got %s", _hashStr(nodeHash))
+    }
+    return nil
+}
+
+/**
+ * Returns the epoch the proof's leaf committed to being inserted in and true, or false if it did
+ * not commit to one. Only meaningful once the proof was verified.
+ */
+func (proof *MembershipProof) InsertionEpoch() (uint64, bool) {
+    if proof.Extended == nil || proof.Extended.Extensions.Epoch == nil {
+        return 0, false
+    }
+    return *proof.Extended.Extensions.Epoch, true
+}
+
+/**
+ * Checks that the proof shows its leaf is in the tree with root 'rootHash' and has been since
+ * epoch 'epoch', i.e., that it committed to being inserted in that epoch or before it. Lets
+ * clients enforce policies like "keys must be at least a day old" against the heads of old epochs.
+ */
+func VerifyExistedSince(proof *MembershipProof, rootHash [32]byte, epoch uint64, opts Options) error {
+    if err := VerifyMembership(proof, rootHash, opts); err != nil {
+        return err
+    }
+    if !proof.Exists() {
+        return _verifyErrorf(ErrLeafMismatch, "leaf %s is not in the tree", _hashStr(proof.LeafNo))
+    }
+    insertedIn, ok := proof.InsertionEpoch()
+    if !ok {
+        return _verifyErrorf(ErrLeafMismatch, "leaf %s does not commit to its insertion epoch", _hashStr(proof.LeafNo))
+    }
+    if insertedIn > epoch {
+        return _verifyErrorf(ErrLeafMismatch, "leaf %s was inserted in epoch %d, after epoch %d", _hashStr(proof.LeafNo), insertedIn, epoch)
+    }
+    return nil
+}
+
+/**
+ * Checks that the proof shows the proof's leaf has the specified value as of the specified root
+ * hash, for a client that already has the raw value (so the proof need not include it).
+ */
+func VerifyValue(proof *MembershipProof, rootHash [32]byte, value []byte, opts Options) error {
+    if proof == nil {
+        return _verifyErrorf(ErrMalformedProof, "no membership proof")
+    }
+    if proof.Value != nil && subtle.ConstantTimeCompare(proof.Value, value) != 1 {
+        return _verifyErrorf(ErrLeafMismatch, "the proof is for a different value")
+    }
+    if !proof.Exists() {
+        return _verifyErrorf(ErrLeafMismatch, "leaf %s is not in the tree", _hashStr(proof.LeafNo))
+    }
+    // The value must be non-nil, or else VerifyMembership() would not check it
+    if value == nil {
+        value = []byte{}
+    }
+
+    withValue := *proof
+    withValue.Value = value
+    return VerifyMembership(&withValue, rootHash, opts)
+}
@@ -0,0 +1,198 @@
+//go:build js && wasm
+// +build js,wasm
+
+/**
+ * Exports the client package's verifiers to JavaScript, so that web clients of a transparency
+ * service can check its proofs in the browser. Build with:
+ *
+ *   GOOS=js GOARCH=wasm go build -o aomt.wasm ./client/wasm
+ *
+ * and load aomt.wasm via the wasm_exec.js shipped with Go (in $(go env GOROOT)/lib/wasm), after
+ * which the functions below are globals. Proofs are passed either as JSON strings or as the
+ * objects the server's JSON decodes to, and hashes as lowercase hex. The functions return null if
+ * the proof is valid and the reason it is not otherwise, so callers cannot mistake an error for
+ * success:
+ *
+ *   verifyMembership(proof, root, options)
+ *       Checks a membership (or non-membership) proof, as in MembershipProof's JSON, against the
+ *       root hash. If 'root' is null, 'proof' is instead a /v1/leaf/ response, which is checked
+ *       against its own root (see client.LeafResponse::Verify()).
+ *
+ *   verifyAppendOnly(proof, oldRoot, newRoot, options)
+ *       Checks an append-only proof, as in AppendOnlyProof's JSON, between the two root hashes. If
+ *       the roots are null, 'proof' is instead a /v1/proof/append-only response.
+ *
+ * 'options' is optional and describes the tree as {rfc6962: bool, numLevels: number,
+ * leafExtensions: bool, hashingScheme: "plain" | "level" | "level-ln"}. Trees must use SHA256.
+ */
+package main
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "syscall/js"
+
+    ".."
+)
+
+func main() {
+    js.Global().Set("verifyMembership", js.FuncOf(_verifyMembership))
+    js.Global().Set("verifyAppendOnly", js.FuncOf(_verifyAppendOnly))
+
+    // The exported functions only work while the Go program runs
+    select {}
+}
+
+func _verifyMembership(this js.Value, args []js.Value) interface{} {
+    return _result(func() error {
+        if len(args) < 2 {
+            return errors.New("usage: verifyMembership(proof, root, options)")
+        }
+        opts, err := _options(_arg(args, 2))
+        if err != nil {
+            return err
+        }
+
+        if _isNull(args[1]) {
+            var resp client.LeafResponse
+            if err := _unmarshalArg(args[0], &resp); err != nil {
+                return err
+            }
+            return resp.Verify(opts)
+        }
+
+        var proof client.MembershipProof
+        if err := _unmarshalArg(args[0], &proof); err != nil {
+            return err
+        }
+        root, err := _hashArg("root", args[1])
+        if err != nil {
+            return err
+        }
+        return client.VerifyMembership(&proof, root, opts)
+    })
+}
+
+func _verifyAppendOnly(this js.Value, args []js.Value) interface{} {
+    return _result(func() error {
+        if len(args) < 3 {
+            return errors.New("usage: verifyAppendOnly(proof, oldRoot, newRoot, options)")
+        }
+        opts, err := _options(_arg(args, 3))
+        if err != nil {
+            return err
+        }
+
+        if _isNull(args[1]) && _isNull(args[2]) {
+            var resp client.AppendOnlyResponse
+            if err := _unmarshalArg(args[0], &resp); err != nil {
+                return err
+            }
+            return resp.Verify(opts)
+        }
+
+        var proof client.AppendOnlyProof
+        if err := _unmarshalArg(args[0], &proof); err != nil {
+            return err
+        }
+        oldRoot, err := _hashArg("oldRoot", args[1])
+        if err != nil {
+            return err
+        }
+        newRoot, err := _hashArg("newRoot", args[2])
+        if err != nil {
+            return err
+        }
+        return client.VerifyAppendOnly(&proof, oldRoot, newRoot, opts)
+    })
+}
+
+/**
+ * Returns null if 'verify' succeeds and its error as a string otherwise, recovering from panics so
+ * that a malformed argument cannot stop the Go program (and with it every exported function).
+ */
+func _result(verify func() error) (result interface{}) {
+    defer func() {
+        if r := recover(); r != nil {
+            result = fmt.Sprintf("%v", r)
+        }
+    }()
+
+    if err := verify(); err != nil {
+        return err.Error()
+    }
+    return nil
+}
+
+func _arg(args []js.Value, i int) js.Value {
+    if i >= len(args) {
+        return js.Undefined()
+    }
+    return args[i]
+}
+
+func _isNull(v js.Value) bool {
+    return v.IsNull() || v.IsUndefined()
+}
+
+/**
+ * Decodes a JSON argument, given either as a string or as an object.
+ */
+func _unmarshalArg(v js.Value, out interface{}) error {
+    data := v
+    if v.Type() != js.TypeString {
+        data = js.Global().Get("JSON").Call("stringify", v)
+    }
+    return json.Unmarshal([]byte(data.String()), out)
+}
+
+func _hashArg(name string, v js.Value) ([32]byte, error) {
+    var h [32]byte
+    if v.Type() != js.TypeString {
+        return h, fmt.Errorf("'%s' must be a hex string", name)
+    }
+    b, err := hex.DecodeString(v.String())
+    if err != nil || len(b) != 32 {
+        return h, fmt.Errorf("'%s' must be a 32-byte hash in hex", name)
+    }
+    copy(h[:], b)
+    return h, nil
+}
+
+func _options(v js.Value) (client.Options, error) {
+    var opts client.Options
+    if _isNull(v) {
+        return opts, nil
+    }
+    if v.Type() != js.TypeObject {
+        return opts, errors.New("'options' must be an object")
+    }
+
+    if rfc6962 := v.Get("rfc6962"); !rfc6962.IsUndefined() {
+        opts.RFC6962 = rfc6962.Truthy()
+    }
+    if leafExtensions := v.Get("leafExtensions"); !leafExtensions.IsUndefined() {
+        opts.LeafExtensions = leafExtensions.Truthy()
+    }
+    if scheme := v.Get("hashingScheme"); !scheme.IsUndefined() {
+        switch scheme.String() {
+        case "plain":
+            opts.HashingScheme = client.HashingPlain
+        case "level":
+            opts.HashingScheme = client.HashingLevel
+        case "level-ln":
+            opts.HashingScheme = client.HashingLevelLN
+        default:
+            return opts, errors.New("'hashingScheme' must be \"plain\", \"level\" or \"level-ln\"")
+        }
+    }
+    if numLevels := v.Get("numLevels"); !numLevels.IsUndefined() {
+        if numLevels.Type() != js.TypeNumber {
+            return opts, errors.New("'numLevels' must be a number")
+        }
+        opts.NumLevels = numLevels.Int()
+    }
+    return opts, nil
+}
@@ -0,0 +1,18 @@
+/**
+ * The amt tool: benchmarks, builds, proves and verifies append-only Merkle prefix trees. Run it
+ * without arguments for its subcommands.
+ *
+ * There is no go.mod, so the tree's package is imported by its relative path, which only works in
+ * GOPATH mode (see the Makefile).
+ */
+package main
+
+import (
+    "os"
+
+    aomt "../.."
+)
+
+func main() {
+    os.Exit(aomt.Main())
+}
@@ -0,0 +1,72 @@
+/**
+ * A long-running transparency-log backend: serves a tree stored in a directory (see aomt.Open())
+ * over gRPC (see aomt.GRPCServer and the AppendOnlyTree service in aomt.proto), e.g.:
+ *
+ *   amtserver -dir /var/lib/aomt -addr :50051 -signing-key key.hex -api-keys key1,key2
+ *
+ * There is no go.mod, so the tree's package is imported by its relative path, which only works in
+ * GOPATH mode (see the Makefile).
+ */
+package main
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+
+    aomt "../.."
+)
+
+func main() {
+    dir := flag.String("dir", "", "the directory where the tree is stored, created if it does not exist")
+    addr := flag.String("addr", ":50051", "the address to serve gRPC on (unencrypted HTTP/2)")
+    numLevels := flag.Int("levels", 257, "the # of levels of the tree, if it is created")
+    signingKeyFile := flag.String("signing-key", "", "a file with the hex-encoded Ed25519 seed that signs the tree heads")
+    apiKeys := flag.String("api-keys", "", "comma-separated list of API keys allowed to call the server (default: everyone)")
+    checkpointEvery := flag.Int("checkpoint-every", 1000, "checkpoint the tree every this many epochs")
+    maxMessageMB := flag.Int("max-message-mb", 4, "the size of the largest request accepted, in MiB")
+    flag.Parse()
+
+    if *dir == "" || flag.NArg() != 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    opts := aomt.Options{NumLevels: *numLevels, CheckpointEvery: *checkpointEvery}
+    if *signingKeyFile != "" {
+        data, err := ioutil.ReadFile(*signingKeyFile)
+        if err != nil {
+            fmt.Printf("Error reading signing key: %v\n", err)
+            os.Exit(1)
+        }
+        seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+        if err != nil || len(seed) != ed25519.SeedSize {
+            fmt.Printf("Error: the signing key must be a hex-encoded %d-byte Ed25519 seed\n", ed25519.SeedSize)
+            os.Exit(1)
+        }
+        opts.SigningKey = ed25519.NewKeyFromSeed(seed)
+    }
+
+    db, err := aomt.Open(*dir, opts)
+    if err != nil {
+        fmt.Printf("Error opening %s: %v\n", *dir, err)
+        os.Exit(1)
+    }
+    defer db.Close()
+
+    var srvOpts aomt.GRPCServerOptions
+    if *apiKeys != "" {
+        srvOpts.APIKeys = strings.Split(*apiKeys, ",")
+    }
+    srvOpts.MaxMessageBytes = *maxMessageMB << 20
+
+    fmt.Printf("Serving tree with %d epochs from %s via gRPC on %s ...\n", db.NumEpochs(), *dir, *addr)
+    if err := aomt.NewGRPCServer(db, srvOpts).ListenAndServe(*addr); err != nil {
+        fmt.Printf("Error serving gRPC: %v\n", err)
+        os.Exit(1)
+    }
+}
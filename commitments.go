@@ -0,0 +1,90 @@
+package aomt
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "hash"
+)
+
+/**
+ * The opening of a hiding commitment to a leaf value: the leaf's data hash is H(Salt || Value),
+ * where H is the tree's hash function and the salt is random. Without the salt, equal values in
+ * different leaves have different data hashes, so the root hash and the siblings in proofs do not
+ * leak which leaves have the same value (nor let anyone check guesses of a leaf's value).
+ *
+ * The tree keeps the openings of leaves inserted via Tree::InsertCommitted(), and only reveals the
+ * opening of a leaf in that leaf's membership proofs.
+ *
+ * NOTE: Updating a committed leaf via Tree::Update() drops its opening, since the leaf hash then
+ * commits to a version chain (see Tree::Update()) rather than to a single value. Openings are not
+ * stored in snapshots either.
+ */
+type Opening struct {
+    Salt  [32]byte
+    Value []byte
+}
+
+/**
+ * Returns the commitment to the opening's value, i.e., the leaf's data hash.
+ */
+func (opening *Opening) Commitment(newHash func() hash.Hash) [32]byte {
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    return _hashWith(newHash, opening.Salt[:], opening.Value)
+}
+
+/**
+ * Inserts a new leaf whose data hash is a hiding commitment to 'value' under a fresh random salt
+ * (see Tree::Insert()). Returns the leaf's opening, which the tree keeps for membership proofs.
+ */
+func (tree *Tree) InsertCommitted(leafNo [32]byte, value []byte, proofTree *Tree) *Opening {
+    opening := _newOpening(value)
+    tree.Insert(leafNo, opening.Commitment(tree.newHash), proofTree)
+    tree.openings[leafNo] = opening
+    return opening
+}
+
+/**
+ * Inserts a new leaf committing to 'value' in the epoch (see Tree::InsertCommitted()).
+ */
+func (epoch *Epoch) InsertCommitted(leafNo [32]byte, value []byte) (*Opening, error) {
+    opening := _newOpening(value)
+    if err := epoch.Insert(leafNo, opening.Commitment(epoch.tree.newHash)); err != nil {
+        return nil, err
+    }
+    epoch.tree.openings[leafNo] = opening
+    return opening, nil
+}
+
+func _newOpening(value []byte) *Opening {
+    opening := &Opening{Value: append([]byte(nil), value...)}
+    if _, err := rand.Read(opening.Salt[:]); err != nil {
+        panic("Error generating commitment salt: " + err.Error())
+    }
+    return opening
+}
+
+/**
+ * Returns the opening of the specified leaf, or nil if it was not inserted via InsertCommitted().
+ */
+func (tree *Tree) GetOpening(leafNo [32]byte) *Opening {
+    return tree.openings[leafNo]
+}
+
+/**
+ * Returns true if the proof's opening (if any) opens the proof's leaf hash.
+ */
+func _checkOpening(proof *MembershipProof, opts TreeOptions) bool {
+    if proof.Opening == nil {
+        return true
+    }
+
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+
+    leafHash := _leafHashWith(newHash, opts.RFC6962, opts._leafHashKey(), proof.Opening.Commitment(newHash))
+    return hashEqual(leafHash, proof.LeafHash)
+}
@@ -0,0 +1,258 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "time"
+)
+
+/**
+ * An alternative, pointer-based representation of the tree, which computes the same root hash as
+ * Tree but uses a lot less memory: Tree stores all 256 ancestors of every leaf in the per-level
+ * maps, while CompactTree skips the runs of single-child nodes (like a Patricia trie). Thus, it only
+ * stores the leaves and the nodes with two children, i.e., 2n - 1 nodes for n leaves.
+ *
+ * NOTE: Append-only proofs are only implemented for Tree, so this is only useful for measuring
+ * insert time and memory for now.
+ */
+type CompactTree struct {
+    root      *compactNode
+    numLevels int
+    numLeaves int64
+    numNodes  int64
+}
+
+type compactNode struct {
+    level  int      // the level of this node, from 0 (the root) to 256 (the leaves)
+    nodeNo [32]byte // the LN of this node on its level
+    hash   [32]byte // the Merkle hash of this node, as in Tree
+
+    // The hash of this node's ancestor right below its parent (i.e., on level 'parent.level + 1'),
+    // which is the hash the parent needs. Different from 'hash' when single-child nodes are skipped.
+    ext [32]byte
+
+    child [2]*compactNode // left and right child, which can be several levels below
+}
+
+/**
+ * Creates a new, empty compact tree with a certain # of levels.
+ */
+func NewCompactTree(numLevels int) *CompactTree {
+    if numLevels != 257 {
+        panic("This code can only handle trees which have 257 levels.")
+    }
+
+    tree := &CompactTree{numLevels: numLevels}
+    tree.root = &compactNode{level: 0}
+    tree.numNodes = 1
+    return tree
+}
+
+/**
+ * Inserts the specified 'dataHash' in the specified 'leafNo' in the last level of the tree.
+ */
+func (tree *CompactTree) Insert(leafNo [32]byte, dataHash [32]byte) {
+    tree._insert(tree.root, leafNo, dataHash)
+    tree.numLeaves++
+}
+
+/**
+ * Returns the hash stored at the specified leaf and true, or the empty hash and false if the leaf
+ * does not exist.
+ */
+func (tree *CompactTree) Get(leafNo [32]byte) ([32]byte, bool) {
+    lastLevel := tree.numLevels - 1
+    node := tree.root
+    for node != nil {
+        if node.level == lastLevel {
+            if node.nodeNo == leafNo {
+                return node.hash, true
+            }
+            break
+        }
+
+        if rshBytes(leafNo, uint(lastLevel-node.level)) != node.nodeNo {
+            break
+        }
+        node = node.child[tree._childDir(node.level, leafNo)]
+    }
+
+    var emptyHash [32]byte
+    return emptyHash, false
+}
+
+/**
+ * Returns the root hash of the tree, which is the same as the root hash of a Tree with the same leaves.
+ */
+func (tree *CompactTree) GetRootHash() [32]byte {
+    return tree.root.hash
+}
+
+/**
+ * Returns the # of nodes actually stored in the tree.
+ */
+func (tree *CompactTree) GetNumNodes() int64 {
+    return tree.numNodes
+}
+
+func (tree *CompactTree) GetNumLeaves() int64 {
+    return tree.numLeaves
+}
+
+/**
+ * Returns 0 if the child of the level-'level' ancestor of 'leafNo' on the path to 'leafNo' is a
+ * left child and 1 otherwise.
+ */
+func (tree *CompactTree) _childDir(level int, leafNo [32]byte) int {
+    return int(bitOf(leafNo, uint(tree.numLevels-2-level)))
+}
+
+/**
+ * Inserts the leaf in the subtree rooted at 'node', which must be an ancestor of the leaf, and
+ * recomputes the hashes along the way back up.
+ */
+func (tree *CompactTree) _insert(node *compactNode, leafNo [32]byte, dataHash [32]byte) {
+    lastLevel := tree.numLevels - 1
+    dir := tree._childDir(node.level, leafNo)
+    child := node.child[dir]
+
+    if child == nil {
+        child = tree._newNode(lastLevel, leafNo, dataHash)
+        node.child[dir] = child
+    } else if rshBytes(leafNo, uint(lastLevel-child.level)) == child.nodeNo {
+        if child.level == lastLevel {
+            panic(fmt.Sprintf("Already set leaf '%s' at last level", hashStr(leafNo)))
+        }
+        tree._insert(child, leafNo, dataHash)
+    } else {
+        // The leaf's path diverges from the child's path somewhere in between, so we need a new
+        // node with two children there
+        childFirstLeaf := lshBytes(child.nodeNo, uint(lastLevel-child.level))
+        level := commonPrefixLen(leafNo, childFirstLeaf)
+
+        branch := tree._newNode(level, rshBytes(leafNo, uint(lastLevel-level)), [32]byte{})
+        leaf := tree._newNode(lastLevel, leafNo, dataHash)
+        branch.child[tree._childDir(level, leafNo)] = leaf
+        branch.child[tree._childDir(level, childFirstLeaf)] = child
+        leaf._setExt(level)
+        child._setExt(level)
+        branch._rehash()
+
+        child = branch
+        node.child[dir] = child
+    }
+
+    // Only the child on the leaf's path changed, so the other child's 'ext' hash is still valid
+    child._setExt(node.level)
+    node._rehash()
+}
+
+func (tree *CompactTree) _newNode(level int, nodeNo [32]byte, hash [32]byte) *compactNode {
+    tree.numNodes++
+    return &compactNode{level: level, nodeNo: nodeNo, hash: hash}
+}
+
+/**
+ * Recomputes this node's 'ext' hash, given its parent's level.
+ */
+func (node *compactNode) _setExt(parentLevel int) {
+    node.ext = _extendHash(node.hash, node.nodeNo, node.level, parentLevel+1)
+}
+
+/**
+ * Recomputes the hash of this node from its children's 'ext' hashes.
+ */
+func (node *compactNode) _rehash() {
+    var hashes [2][32]byte
+    for i, child := range node.child {
+        if child != nil {
+            hashes[i] = child.ext
+        }
+    }
+
+    // Like in Tree, a node with no children has the empty hash
+    var emptyHash [32]byte
+    if node.child[0] == nil && node.child[1] == nil {
+        node.hash = emptyHash
+    } else {
+        node.hash = _merkleHash(hashes[0], hashes[1])
+    }
+}
+
+/**
+ * Computes the hash of the level-'toLevel' ancestor of the node with LN 'nodeNo' on level
+ * 'fromLevel' with hash 'hash', assuming all the siblings along the way are empty.
+ */
+func _extendHash(hash [32]byte, nodeNo [32]byte, fromLevel int, toLevel int) [32]byte {
+    var emptyHash [32]byte
+    for level := fromLevel; level > toLevel; level-- {
+        if nodeNo[31]&1 == 0 {
+            hash = _merkleHash(hash, emptyHash)
+        } else {
+            hash = _merkleHash(emptyHash, hash)
+        }
+        nodeNo = parentOf(nodeNo)
+    }
+    return hash
+}
+
+/**
+ * Like hashsparse(), but only measures insert time and memory in a CompactTree, since it cannot
+ * compute append-only proofs.
+ */
+func hashcompact(sizes []int, seed int64, csvFile string, opts BenchOptions) {
+    memGc()
+
+    // Same leaves as in hashsparse(), so the two CSVs can be compared
+    gen := opts._newLeafSource(seed)
+
+    tree := NewCompactTree(257)
+    var rootNo [32]byte
+    tree.Insert(rootNo, sha256.Sum256([]byte("Dummy leaf")))
+
+    prevSize := 1
+    numCompleted := opts._numCompletedSizes(csvFile, sizes)
+    for i := 0; i < numCompleted; i++ {
+        fmt.Printf("Rebuilding completed batch of size %v ...\n", sizes[i]-prevSize)
+        for j := 0; j < sizes[i]-prevSize; j++ {
+            tree.Insert(gen.next())
+        }
+        prevSize = sizes[i]
+    }
+
+    csv := opts._openCsv(csvFile, []string{"dictSize", "numNodes", "heapMB", "insertUsec"})
+
+    for i := numCompleted; i < len(sizes); i++ {
+        newSize := sizes[i]
+        fmt.Printf("\nAppending new batch of size %v ...\n", newSize-prevSize)
+
+        startTime := time.Now()
+        for j := 0; j < newSize-prevSize; j++ {
+            tree.Insert(gen.next())
+        }
+        insertElapsed := time.Since(startTime)
+
+        heapMB := heapUsageAfterGc()
+        fmt.Printf(
+            "# kv's: %v, "+
+                "# tree nodes: %v, "+
+                "heap: %v MB, "+
+                "root: %s\n"+
+                "Insert time: %s\n",
+            newSize,
+            tree.GetNumNodes(),
+            heapMB,
+            hashStr(tree.GetRootHash()),
+            insertElapsed)
+
+        csv.Write(newSize, tree.GetNumNodes(), heapMB, int64(insertElapsed/time.Microsecond))
+        prevSize = newSize
+    }
+
+    if err := csv.Close(); err != nil {
+        panic("Error writing CSV file: " + err.Error())
+    }
+
+    fmt.Printf("\n")
+    memGc()
+}
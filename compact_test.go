@@ -0,0 +1,48 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * A compact tree must have the same root as a Tree with the same leaves after every insert, while
+ * storing only the leaves and the nodes with two children.
+ */
+func TestCompactTreeMatchesTree(t *testing.T) {
+    compact := NewCompactTree(257)
+    tree := NewTree(257)
+
+    var leaves []LeafData
+    for i := 0; i < 100; i++ {
+        leaves = append(leaves, _testLeaf(i))
+        // Siblings share all their ancestors, so they branch on the last level
+        if i%10 == 0 {
+            sibling := _testLeaf(i)
+            sibling.LeafNo[31] ^= 1
+            leaves = append(leaves, sibling)
+        }
+    }
+    for i, leaf := range leaves {
+        compact.Insert(leaf.LeafNo, leaf.DataHash)
+        tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        if compact.GetRootHash() != tree.GetRootHash() {
+            t.Fatalf("root after %d leaves is %s, expected %s", i+1, hashStr(compact.GetRootHash()), hashStr(tree.GetRootHash()))
+        }
+    }
+
+    if compact.GetNumLeaves() != int64(len(leaves)) {
+        t.Errorf("tree has %d leaves, expected %d", compact.GetNumLeaves(), len(leaves))
+    }
+    // The leaves, the nodes with two children and the root
+    if compact.GetNumNodes() > int64(2*len(leaves)) {
+        t.Errorf("tree stores %d nodes for %d leaves", compact.GetNumNodes(), len(leaves))
+    }
+    for _, leaf := range leaves {
+        if hash, ok := compact.Get(leaf.LeafNo); !ok || hash != leaf.DataHash {
+            t.Errorf("leaf %x is missing", leaf.LeafNo)
+        }
+    }
+    if _, ok := compact.Get(_testLeaf(1000).LeafNo); ok {
+        t.Errorf("missing leaf is in the tree")
+    }
+}
@@ -0,0 +1,306 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "errors"
+    "flag"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "./log"
+)
+
+/**
+ * Runs the same insertion workload (i.e., the leaves of the 'bench' subcommand for the same seed
+ * and -workload) against this tree and other Merkle-based dictionaries, and writes their insert
+ * throughput, proof sizes and verify times to a single CSV file with one row per (dictionary,
+ * batch). Proofs that a dictionary does not have (e.g., append-only proofs for Trillian's map) are
+ * left empty.
+ *
+ * The dictionaries are:
+ *
+ *   prefix-tree  this tree, with an append-only proof per batch
+ *   ct-log       a naive Certificate Transparency log (see package log) of the leaves, whose
+ *                append-only proofs are consistency proofs and membership proofs are inclusion
+ *                proofs, but which cannot prove non-membership nor that a key has a single value
+ *   trillian-map Trillian's in-memory sparse Merkle map, only when built with '-tags trillian'
+ *                (e.g., 'make TAGS=trillian', see compare_trillian.go)
+ */
+type comparedDict interface {
+    /**
+     * Appends a batch of leaves in a new epoch. Returns the size in bytes of the proof that the new
+     * epoch extends the previous one and a function that verifies it, or -1 and nil if there is no
+     * such proof (e.g., for the first batch).
+     */
+    appendBatch(leaves []LeafData) (int64, func() error)
+
+    /**
+     * Returns the size in bytes of the membership proof of a leaf in the last epoch and a function
+     * that verifies it, or -1 and nil if the dictionary has no membership proofs.
+     */
+    proveMembership(leafNo [32]byte) (int64, func() error)
+}
+
+// The dictionaries that can be compared, by name. Others can be registered from init() functions.
+var comparedDicts = map[string]func() comparedDict{
+    "prefix-tree": newComparedPrefixTree,
+    "ct-log":      newComparedCtLog,
+}
+
+func _comparedDictNames() []string {
+    var names []string
+    for name := range comparedDicts {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func compareCmd(name string, cmdArgs []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    dicts := flags.String("dicts", strings.Join(_comparedDictNames(), ","), "comma-separated list of the dictionaries to compare")
+    repeat := flags.Int("repeat", 1, "verify every proof this many times, and record the mean verify time")
+    workload := flags.String("workload", workloadUniform, "the leaves to insert, as for the 'bench' subcommand")
+    rawKeys := flags.Bool("raw-keys", false, "use the workload's keys as leaf no's instead of hashing them")
+    outputFormat := flags.String("output-format", OutputCSV, "the format of the results: 'csv' or 'json' (JSON Lines, see CsvWriter)")
+    flags.Parse(cmdArgs)
+    args := flags.Args()
+
+    if len(args) < 2 || *repeat < 1 {
+        return exitUsage
+    }
+    if err := checkWorkload(*workload, *rawKeys); err != nil {
+        fmt.Printf("Error: %v\n", err)
+        return exitUsage
+    }
+    if err := checkOutputFormat(*outputFormat); err != nil {
+        fmt.Printf("Error: %v\n", err)
+        return exitUsage
+    }
+    for _, dict := range strings.Split(*dicts, ",") {
+        if comparedDicts[dict] == nil {
+            fmt.Printf("Error: unknown dictionary '%s' (have %s)\n", dict, strings.Join(_comparedDictNames(), ", "))
+            return exitUsage
+        }
+    }
+
+    seed, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil {
+        fmt.Printf("Error parsing PRNG seed: %v\n", err)
+        return exitUsage
+    }
+    sizes := []int{100, 200, 300, 400, 500}
+    if len(args) > 2 {
+        sizes = nil
+        for _, arg := range args[2:] {
+            size, err := strconv.Atoi(arg)
+            if err != nil || (len(sizes) > 0 && size <= sizes[len(sizes)-1]) {
+                fmt.Printf("Error: sizes must be increasing integers, not '%s'\n", arg)
+                return exitUsage
+            }
+            sizes = append(sizes, size)
+        }
+    }
+
+    csv := NewResultWriter(args[1], *outputFormat, []string{"dict", "dictSize", "batchSize", "insertUsec", "insertsPerSec",
+        "appendOnlyProofBytes", "appendOnlyVerifyUsec", "membershipProofBytes", "membershipVerifyUsec"}, 0)
+    for _, dict := range strings.Split(*dicts, ",") {
+        fmt.Printf("\nComparing %s on sizes %v, seed %v ...\n", dict, sizes, seed)
+        gen, err := newLeafSource(*workload, *rawKeys, seed)
+        if err == nil {
+            err = _compareDict(csv, dict, comparedDicts[dict](), gen, sizes, *repeat)
+        }
+        if err != nil {
+            fmt.Printf("Error: %s: %v\n", dict, err)
+            csv.Close()
+            return 1
+        }
+    }
+    if err := csv.Close(); err != nil {
+        fmt.Printf("Error writing results: %v\n", err)
+        return 1
+    }
+    return 0
+}
+
+/**
+ * Inserts the batches of leaves in the dictionary, writing one CSV row per batch.
+ */
+func _compareDict(csv *CsvWriter, name string, dict comparedDict, gen leafSource, sizes []int, repeat int) error {
+    memGc()
+
+    prevSize := 0
+    for _, size := range sizes {
+        leaves := make([]LeafData, size-prevSize)
+        for i := range leaves {
+            leaves[i].LeafNo, leaves[i].DataHash = gen.next()
+        }
+
+        startTime := time.Now()
+        proofBytes, verifyProof := dict.appendBatch(leaves)
+        insertElapsed := time.Since(startTime)
+
+        verifyUsec, err := _compareVerifyUsec(verifyProof, repeat)
+        if err != nil {
+            return fmt.Errorf("append-only proof of batch ending at size %d: %v", size, err)
+        }
+        membershipBytes, verifyMembership := dict.proveMembership(leaves[0].LeafNo)
+        membershipVerifyUsec, err := _compareVerifyUsec(verifyMembership, repeat)
+        if err != nil {
+            return fmt.Errorf("membership proof at size %d: %v", size, err)
+        }
+
+        insertsPerSec := float64(len(leaves)) / insertElapsed.Seconds()
+        fmt.Printf("# kv's: %v, insert time: %v (%.0f inserts/sec), append-only proof: %s bytes (verify: %s usec), "+
+            "membership proof: %s bytes (verify: %s usec)\n",
+            size, insertElapsed, insertsPerSec, _comparePrint(proofBytes), _comparePrint(verifyUsec),
+            _comparePrint(membershipBytes), _comparePrint(membershipVerifyUsec))
+        csv.Write(name, size, len(leaves), insertElapsed.Microseconds(), int64(insertsPerSec),
+            _compareCell(proofBytes), _compareCell(verifyUsec), _compareCell(membershipBytes), _compareCell(membershipVerifyUsec))
+
+        prevSize = size
+    }
+    return nil
+}
+
+/**
+ * Returns the mean time in microseconds taken by 'verify' over 'repeat' runs, or -1 if it is nil.
+ */
+func _compareVerifyUsec(verify func() error, repeat int) (int64, error) {
+    if verify == nil {
+        return -1, nil
+    }
+
+    var times []time.Duration
+    for r := 0; r < repeat; r++ {
+        startTime := time.Now()
+        if err := verify(); err != nil {
+            return 0, err
+        }
+        times = append(times, time.Since(startTime))
+    }
+    mean, _ := _meanStddevUsec(times)
+    return mean, nil
+}
+
+/**
+ * Formats a measurement for the CSV file, where missing ones (i.e., -1) are left empty.
+ */
+func _compareCell(value int64) string {
+    if value < 0 {
+        return ""
+    }
+    return strconv.FormatInt(value, 10)
+}
+
+func _comparePrint(value int64) string {
+    if value < 0 {
+        return "n/a"
+    }
+    return strconv.FormatInt(value, 10)
+}
+
+type comparedPrefixTree struct {
+    tree *Tree
+}
+
+func newComparedPrefixTree() comparedDict {
+    return &comparedPrefixTree{tree: NewTree(257)}
+}
+
+func (dict *comparedPrefixTree) appendBatch(leaves []LeafData) (int64, func() error) {
+    tree := dict.tree
+
+    // The first epoch has no previous epoch to be append-only with respect to
+    if tree.NumEpochs() == 0 {
+        for _, leaf := range leaves {
+            tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        }
+        tree.RecordEpoch()
+        return -1, nil
+    }
+
+    epoch := tree.BeginEpoch()
+    for _, leaf := range leaves {
+        if err := epoch.Insert(leaf.LeafNo, leaf.DataHash); err != nil {
+            panic(err)
+        }
+    }
+    oldRoot := epoch.OldRoot()
+    proof, newRoot := epoch.Commit()
+
+    opts := tree.Options()
+    return proof.SizeBytes(), func() error {
+        return proof.VerifyWithOptions(oldRoot, newRoot, opts)
+    }
+}
+
+func (dict *comparedPrefixTree) proveMembership(leafNo [32]byte) (int64, func() error) {
+    root := dict.tree.GetRootHash()
+    proof := dict.tree.ProveMembership(leafNo)
+
+    return MembershipProofSize(dict.tree.numLevels), func() error {
+        if !VerifyMembershipProof(proof, root) {
+            return errors.New("invalid membership proof")
+        }
+        return nil
+    }
+}
+
+/**
+ * A naive CT log of the leaves: entry i is SHA256(leafNo || dataHash) of the i-th inserted leaf.
+ */
+type comparedCtLog struct {
+    log     *log.Log
+    indices map[[32]byte]int // the index of every leaf's entry, which a CT log client must know
+    entries [][32]byte
+}
+
+func newComparedCtLog() comparedDict {
+    return &comparedCtLog{log: log.New(), indices: make(map[[32]byte]int)}
+}
+
+func _ctLogEntry(leaf LeafData) [32]byte {
+    return sha256.Sum256(append(leaf.LeafNo[:], leaf.DataHash[:]...))
+}
+
+func (dict *comparedCtLog) appendBatch(leaves []LeafData) (int64, func() error) {
+    oldSize := dict.log.Size()
+    oldRoot := dict.log.Root()
+    for _, leaf := range leaves {
+        entry := _ctLogEntry(leaf)
+        dict.indices[leaf.LeafNo] = dict.log.Append(entry)
+        dict.entries = append(dict.entries, entry)
+    }
+    newSize := dict.log.Size()
+    newRoot := dict.log.Root()
+
+    if oldSize == 0 {
+        return -1, nil
+    }
+    proof, err := dict.log.ProveConsistency(oldSize, newSize)
+    if err != nil {
+        panic(err)
+    }
+    return 32 * int64(len(proof)), func() error {
+        return log.VerifyConsistency(oldSize, newSize, oldRoot, newRoot, proof)
+    }
+}
+
+func (dict *comparedCtLog) proveMembership(leafNo [32]byte) (int64, func() error) {
+    index := dict.indices[leafNo]
+    size := dict.log.Size()
+    root := dict.log.Root()
+    proof, err := dict.log.ProveInclusion(index, size)
+    if err != nil {
+        panic(err)
+    }
+
+    entry := dict.entries[index]
+    return 32 * int64(len(proof)), func() error {
+        return log.VerifyInclusion(entry, index, size, proof, root)
+    }
+}
@@ -0,0 +1,56 @@
+//go:build trillian
+// +build trillian
+
+package aomt
+
+import (
+    "math/big"
+
+    "github.com/google/trillian/merkle/maphasher"
+    "github.com/google/trillian/merkle/smt"
+)
+
+/**
+ * Trillian's in-memory sparse Merkle map, for the 'compare' subcommand. Only built with '-tags
+ * trillian', since this is the only code that depends on Trillian (i.e., on a release that still
+ * has its map, such as v1.3.x).
+ *
+ * The map has no append-only proofs and, without its storage layer, no membership proofs either,
+ * so we only measure how long it takes to compute the root of the map after every batch, via
+ * HStar2 over all the leaves, as the in-memory map does.
+ */
+func init() {
+    comparedDicts["trillian-map"] = newComparedTrillianMap
+}
+
+// The tree ID the map's leaf and node hashes are domain-separated with
+const trillianMapTreeID = 1
+
+type comparedTrillianMap struct {
+    hstar2 *smt.HStar2
+    leaves []*smt.HStar2LeafHash
+}
+
+func newComparedTrillianMap() comparedDict {
+    hstar2 := smt.NewHStar2(trillianMapTreeID, maphasher.Default)
+    return &comparedTrillianMap{hstar2: &hstar2}
+}
+
+func (dict *comparedTrillianMap) appendBatch(leaves []LeafData) (int64, func() error) {
+    for _, leaf := range leaves {
+        leafHash := maphasher.Default.HashLeaf(trillianMapTreeID, leaf.LeafNo[:], leaf.DataHash[:])
+        dict.leaves = append(dict.leaves, &smt.HStar2LeafHash{
+            Index:    new(big.Int).SetBytes(leaf.LeafNo[:]),
+            LeafHash: leafHash,
+        })
+    }
+
+    if _, err := dict.hstar2.HStar2Root(maphasher.Default.BitLen(), dict.leaves); err != nil {
+        panic(err)
+    }
+    return -1, nil
+}
+
+func (dict *comparedTrillianMap) proveMembership(leafNo [32]byte) (int64, func() error) {
+    return -1, nil
+}
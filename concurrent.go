@@ -0,0 +1,233 @@
+package aomt
+
+import (
+    "context"
+    "sync"
+)
+
+/**
+ * A wrapper around Tree that is safe for concurrent use: readers (Get, ProveMembership, ...) can
+ * run concurrently with one another, while inserts and updates hold a write lock. This way, a
+ * server can answer lookup queries while appending a batch of leaves.
+ */
+type ConcurrentTree struct {
+    mu   sync.RWMutex
+    tree *Tree
+}
+
+/**
+ * Creates a new, empty concurrent tree with a certain # of levels.
+ */
+func NewConcurrentTree(numLevels int) *ConcurrentTree {
+    return WrapTree(NewTree(numLevels))
+}
+
+/**
+ * Wraps an existing tree. The tree must not be used directly afterwards.
+ */
+func WrapTree(tree *Tree) *ConcurrentTree {
+    return &ConcurrentTree{tree: tree}
+}
+
+func (ct *ConcurrentTree) Insert(leafNo [32]byte, dataHash [32]byte, proofTree *Tree) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    ct.tree.Insert(leafNo, dataHash, proofTree)
+}
+
+func (ct *ConcurrentTree) TryInsert(leafNo [32]byte, dataHash [32]byte, proofTree *Tree) error {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    return ct.tree.TryInsert(leafNo, dataHash, proofTree)
+}
+
+func (ct *ConcurrentTree) InsertValue(leafNo [32]byte, value []byte, proofTree *Tree) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    ct.tree.InsertValue(leafNo, value, proofTree)
+}
+
+func (ct *ConcurrentTree) Update(leafNo [32]byte, newDataHash [32]byte, proofTree *Tree) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    ct.tree.Update(leafNo, newDataHash, proofTree)
+}
+
+/**
+ * Inserts a whole batch of leaves while holding the write lock, so readers never observe a
+ * partially-inserted batch. Returns the new root hash.
+ */
+func (ct *ConcurrentTree) InsertBatch(leaves []LeafData, proofTree *Tree) [32]byte {
+    root, _ := ct.InsertBatchContext(context.Background(), leaves, proofTree)
+    return root
+}
+
+/**
+ * Like InsertBatch(), but stops if the context is canceled, returning a ProgressError (and no root
+ * hash) whose Done is the # of leaves that were inserted. These leaves stay inserted (i.e., as
+ * pending changes), so the caller can insert the rest via leaves[Done:] or record what was
+ * inserted as an epoch.
+ */
+func (ct *ConcurrentTree) InsertBatchContext(ctx context.Context, leaves []LeafData, proofTree *Tree) ([32]byte, error) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    for i, leaf := range leaves {
+        if i%cancelCheckInterval == 0 {
+            if err := _checkCanceled(ctx, "inserting batch", "leaves", i, len(leaves)); err != nil {
+                return [32]byte{}, err
+            }
+        }
+        ct.tree.Insert(leaf.LeafNo, leaf.DataHash, proofTree)
+    }
+
+    return ct.tree.GetRootHash(), nil
+}
+
+/**
+ * Inserts the leaves that can be inserted (see Epoch::Insert()) in a new epoch and commits it
+ * while holding the write lock, so readers never observe a partially-inserted epoch, and the epoch
+ * gets an append-only proof from the previous one, like epochs committed via Tree::BeginEpoch().
+ * Returns the new epoch, or -1 if no leaf was inserted (and thus no epoch was committed), and why
+ * each leaf was rejected (i.e., nil for the inserted leaves), in the same order as 'leaves'.
+ *
+ * NOTE: As for Tree::BeginEpoch(), all previous changes must have been recorded. The first epoch
+ * is recorded via Tree::RecordEpoch() instead, since there is no previous epoch.
+ */
+func (ct *ConcurrentTree) TryInsertEpoch(leaves []LeafData) (int, []error) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    errs := make([]error, len(leaves))
+    // The first epoch has no previous epoch to be append-only with respect to (and the empty tree
+    // has no root to begin an epoch from), so it is simply recorded
+    if ct.tree.NumEpochs() == 0 {
+        inserted := false
+        for i, leaf := range leaves {
+            errs[i] = ct.tree.TryInsert(leaf.LeafNo, leaf.DataHash, nil)
+            inserted = inserted || errs[i] == nil
+        }
+        if !inserted {
+            return -1, errs
+        }
+        return ct.tree.RecordEpoch(), errs
+    }
+
+    epoch := ct.tree.BeginEpoch()
+    for i, leaf := range leaves {
+        errs[i] = epoch.Insert(leaf.LeafNo, leaf.DataHash)
+    }
+    if epoch.NumChanges() == 0 {
+        epoch._discard()
+        return -1, errs
+    }
+    epoch.Commit()
+    return epoch.number, errs
+}
+
+func (ct *ConcurrentTree) RecordEpoch() int {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    return ct.tree.RecordEpoch()
+}
+
+/**
+ * Subscribes to the tree's epoch roots (see Tree::SubscribeRoots()). Takes the write lock, since
+ * it adds a subscriber to the tree.
+ */
+func (ct *ConcurrentTree) SubscribeRoots() <-chan SignedTreeHead {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    return ct.tree.SubscribeRoots()
+}
+
+/**
+ * Sets the tree's metrics (see Tree::SetMetrics()). Takes the write lock, since inserts and epochs
+ * update them.
+ */
+func (ct *ConcurrentTree) SetMetrics(m *Metrics) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    ct.tree.SetMetrics(m)
+}
+
+func (ct *ConcurrentTree) UnsubscribeRoots(ch <-chan SignedTreeHead) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+
+    ct.tree.UnsubscribeRoots(ch)
+}
+
+func (ct *ConcurrentTree) Get(leafNo [32]byte) ([32]byte, bool) {
+    ct.mu.RLock()
+    defer ct.mu.RUnlock()
+
+    return ct.tree.Get(leafNo)
+}
+
+func (ct *ConcurrentTree) GetInsertionEpoch(leafNo [32]byte) (int, bool) {
+    ct.mu.RLock()
+    defer ct.mu.RUnlock()
+
+    return ct.tree.GetInsertionEpoch(leafNo)
+}
+
+/**
+ * Takes the write lock if the tree is pruned, since the pruned subtree on the leaf's path is
+ * rebuilt (see _lockForProofs()).
+ */
+func (ct *ConcurrentTree) ProveMembership(leafNo [32]byte) *MembershipProof {
+    defer ct._lockForProofs()()
+
+    return ct.tree.ProveMembership(leafNo)
+}
+
+/**
+ * Proves the membership of many leaves in parallel, see Tree::ProveMembershipMany(). Takes the
+ * write lock if the tree is pruned, since the pruned subtrees on the leaves' paths are rebuilt.
+ */
+func (ct *ConcurrentTree) ProveMembershipMany(leafNos [][32]byte, parallelism int) []*MembershipProof {
+    defer ct._lockForProofs()()
+
+    return ct.tree.ProveMembershipMany(leafNos, parallelism)
+}
+
+/**
+ * Takes the read lock or, if the tree is pruned, the write lock, since proofs rebuild the pruned
+ * subtrees they descend into (see Tree::Prune()). Returns the matching unlock function.
+ */
+func (ct *ConcurrentTree) _lockForProofs() func() {
+    ct.mu.RLock()
+    if ct.tree.numPruned == 0 {
+        return ct.mu.RUnlock
+    }
+    ct.mu.RUnlock()
+
+    ct.mu.Lock()
+    return ct.mu.Unlock
+}
+
+func (ct *ConcurrentTree) GetRootHash() [32]byte {
+    ct.mu.RLock()
+    defer ct.mu.RUnlock()
+
+    return ct.tree.GetRootHash()
+}
+
+/**
+ * Calls 'readFunc' on the underlying tree while holding the read lock, for read-only operations
+ * that are not wrapped above. 'readFunc' must not modify the tree, but it can prove memberships,
+ * since the write lock is held instead if the tree is pruned (see _lockForProofs()).
+ */
+func (ct *ConcurrentTree) Read(readFunc func(*Tree)) {
+    defer ct._lockForProofs()()
+
+    readFunc(ct.tree)
+}
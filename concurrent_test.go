@@ -0,0 +1,165 @@
+package aomt
+
+import (
+    "sync"
+    "testing"
+)
+
+/**
+ * Readers run concurrently with batches of inserts, and must only ever see whole batches (run with
+ * -race to catch them seeing partial ones).
+ */
+func TestConcurrentReadsDuringInserts(t *testing.T) {
+    var leaves []LeafData
+    for i := 0; i < 200; i++ {
+        leaves = append(leaves, _testLeaf(i))
+    }
+    // The roots after each batch of 20 leaves, which are the only ones readers may see
+    roots := map[[32]byte]bool{}
+    reference := NewTree(257)
+    for i, leaf := range leaves {
+        reference.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        if (i+1)%20 == 0 {
+            roots[reference.GetRootHash()] = true
+        }
+    }
+
+    ct := NewConcurrentTree(257)
+    ct.InsertBatch(leaves[:20], nil)
+    var wg sync.WaitGroup
+    for w := 0; w < 4; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for n := 0; n < 100; n++ {
+                i := (w + 4*n) % 20
+                var root [32]byte
+                var proof *MembershipProof
+                ct.Read(func(tree *Tree) {
+                    root = tree.GetRootHash()
+                    proof = tree.ProveMembership(leaves[i].LeafNo)
+                })
+                if !roots[root] {
+                    t.Errorf("reader saw the root %s of a partially inserted batch", hashStr(root))
+                    return
+                }
+                if !VerifyMembershipProof(proof, root) {
+                    t.Errorf("membership proof of %x does not verify", leaves[i].LeafNo)
+                    return
+                }
+            }
+        }(w)
+    }
+    for b := 20; b < len(leaves); b += 20 {
+        ct.InsertBatch(leaves[b:b+20], nil)
+    }
+    wg.Wait()
+
+    if ct.GetRootHash() != reference.GetRootHash() {
+        t.Errorf("root after all batches is %s, expected %s", hashStr(ct.GetRootHash()), hashStr(reference.GetRootHash()))
+    }
+    for _, leaf := range leaves {
+        if hash, ok := ct.Get(leaf.LeafNo); !ok || hash != leaf.DataHash {
+            t.Errorf("leaf %x is missing after all batches", leaf.LeafNo)
+        }
+    }
+}
+
+/**
+ * Proofs run under the read lock, so with cached levels they must not fill the caches (run with
+ * -race to catch them doing so).
+ */
+func TestConcurrentProofsWithCachedLevels(t *testing.T) {
+    tree, err := NewTreeWithOptions(257, TreeOptions{CachedLevels: 8})
+    if err != nil {
+        t.Fatal(err)
+    }
+    ct := WrapTree(tree)
+
+    var leaves []LeafData
+    for i := 0; i < 200; i++ {
+        leaves = append(leaves, _testLeaf(i))
+    }
+    ct.InsertBatch(leaves[:100], nil)
+    ct.RecordEpoch()
+
+    var wg sync.WaitGroup
+    for w := 0; w < 4; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for _, leaf := range leaves[:100] {
+                proof := ct.ProveMembership(leaf.LeafNo)
+                if proof.LeafHash == tree.EmptyHash {
+                    t.Errorf("leaf %x is missing from its proof", leaf.LeafNo)
+                    return
+                }
+            }
+        }()
+    }
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for _, leaf := range leaves[100:] {
+            ct.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        }
+    }()
+    wg.Wait()
+    ct.RecordEpoch()
+
+    root := ct.GetRootHash()
+    for _, leaf := range leaves {
+        proof := ct.ProveMembership(leaf.LeafNo)
+        if !VerifyMembershipProofWithOptions(proof, root, tree.Options()) {
+            t.Fatalf("membership proof of %x does not verify", leaf.LeafNo)
+        }
+    }
+}
+
+/**
+ * Proofs rebuild pruned subtrees, so on a pruned tree they must not run under the read lock (run
+ * with -race to catch them doing so).
+ */
+func TestConcurrentProofsOfPrunedTree(t *testing.T) {
+    tree := NewTree(257)
+    var leaves []LeafData
+    for i := 0; i < 200; i++ {
+        leaves = append(leaves, _testLeaf(i))
+        tree.Insert(leaves[i].LeafNo, leaves[i].DataHash, nil)
+        if i == 99 {
+            tree.RecordEpoch()
+        }
+    }
+    tree.RecordEpoch()
+    if _, err := tree.Prune(1); err != nil {
+        t.Fatal(err)
+    }
+    if tree.NumPrunedSubtrees() == 0 {
+        t.Fatal("expected the tree to have pruned subtrees")
+    }
+    ct := WrapTree(tree)
+    root := ct.GetRootHash()
+
+    var wg sync.WaitGroup
+    for w := 0; w < 4; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for i := w; i < len(leaves); i += 4 {
+                var proof *MembershipProof
+                if i%2 == 0 {
+                    proof = ct.ProveMembership(leaves[i].LeafNo)
+                } else {
+                    ct.Read(func(tree *Tree) {
+                        proof = tree.ProveMembership(leaves[i].LeafNo)
+                    })
+                }
+                if !VerifyMembershipProofWithOptions(proof, root, tree.Options()) {
+                    t.Errorf("membership proof of %x does not verify", leaves[i].LeafNo)
+                    return
+                }
+            }
+        }(w)
+    }
+    wg.Wait()
+}
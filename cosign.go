@@ -0,0 +1,149 @@
+package aomt
+
+import (
+    "crypto/ed25519"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+)
+
+/**
+ * Witness cosigning of tree heads, as in the cosigned checkpoints of transparency-log gossip
+ * designs: independent witnesses each check a signed head (e.g., its server signature, and that it
+ * does not conflict with the heads they cosigned before) and cosign it, and verifiers only trust a
+ * root that a threshold of the witnesses they know cosigned (see VerifyCosignatures() in the
+ * client package). A server that shows two roots for an epoch then needs enough witnesses to
+ * cosign both, rather than just its own key.
+ *
+ * Cosignatures are over CosignatureMessage(), i.e., a domain separation prefix followed by
+ * TreeHead::MarshalProto(), so a cosignature cannot pass as the server's signature of the head
+ * (and vice versa), even if the witness and the server share a key.
+ */
+
+// The prefix of the messages witnesses sign
+const cosignaturePrefix = "aomt-cosignature/v1\n"
+
+/**
+ * A witness's signature of a tree head.
+ */
+type Cosignature struct {
+    WitnessID string
+    Signature []byte
+}
+
+/**
+ * A signed head along with the cosignatures of the witnesses that vouched for it, sorted by
+ * witness ID.
+ */
+type CosignedTreeHead struct {
+    SignedTreeHead
+    Cosignatures []Cosignature
+}
+
+/**
+ * A witness that checks and cosigns tree heads. Cosign() returns an error if the witness refuses to
+ * cosign the head (e.g., it cosigned another root for the same epoch).
+ */
+type Witness interface {
+    ID() string
+    Cosign(sth SignedTreeHead) ([]byte, error)
+}
+
+/**
+ * Returns the message witnesses sign for a head.
+ */
+func CosignatureMessage(head TreeHead) []byte {
+    headBytes, _ := head.MarshalProto()
+    return append([]byte(cosignaturePrefix), headBytes...)
+}
+
+/**
+ * Asks each witness to cosign the signed head and returns the head with the cosignatures of the
+ * witnesses that did, along with an error that lists the witnesses that refused (nil if none did),
+ * so the caller can decide whether the cosignatures it got are enough.
+ */
+func CollectCosignatures(sth SignedTreeHead, witnesses []Witness) (*CosignedTreeHead, error) {
+    cth := &CosignedTreeHead{SignedTreeHead: sth}
+
+    var refusals []string
+    for _, witness := range witnesses {
+        signature, err := witness.Cosign(sth)
+        if err != nil {
+            refusals = append(refusals, fmt.Sprintf("%s: %v", witness.ID(), err))
+            continue
+        }
+        cth.Cosignatures = append(cth.Cosignatures, Cosignature{WitnessID: witness.ID(), Signature: signature})
+    }
+    sort.Slice(cth.Cosignatures, func(i, j int) bool {
+        return cth.Cosignatures[i].WitnessID < cth.Cosignatures[j].WitnessID
+    })
+
+    if len(refusals) > 0 {
+        return cth, fmt.Errorf("%d of %d witnesses refused to cosign the head of epoch %d: %s",
+            len(refusals), len(witnesses), sth.Head.Epoch, strings.Join(refusals, "; "))
+    }
+    return cth, nil
+}
+
+/**
+ * A witness that cosigns heads with an Ed25519 key. It checks the server's signature of each head
+ * via 'verifyServer' (if set) and remembers the roots it cosigned, refusing to cosign a second root
+ * for an epoch or a head older than the latest one it cosigned, so it never vouches for a split
+ * view. It does not check that newer heads extend older ones: witnesses that can fetch append-only
+ * proofs should wrap Cosign() to check them first (see VerifyExtends() in the client package).
+ */
+type Ed25519Witness struct {
+    id           string
+    key          ed25519.PrivateKey
+    verifyServer func(message []byte, signature []byte) bool
+
+    mu     sync.Mutex
+    roots  map[int][32]byte // the roots cosigned so far, by epoch
+    latest int              // the latest epoch cosigned so far, -1 if none
+}
+
+func NewEd25519Witness(id string, key ed25519.PrivateKey, verifyServer func(message []byte, signature []byte) bool) (*Ed25519Witness, error) {
+    if id == "" {
+        return nil, errors.New("empty witness ID")
+    }
+    if len(key) != ed25519.PrivateKeySize {
+        return nil, errors.New("invalid Ed25519 private key")
+    }
+    return &Ed25519Witness{id: id, key: key, verifyServer: verifyServer, roots: make(map[int][32]byte), latest: -1}, nil
+}
+
+func (w *Ed25519Witness) ID() string {
+    return w.id
+}
+
+func (w *Ed25519Witness) PublicKey() ed25519.PublicKey {
+    return w.key.Public().(ed25519.PublicKey)
+}
+
+func (w *Ed25519Witness) Cosign(sth SignedTreeHead) ([]byte, error) {
+    if w.verifyServer != nil {
+        headBytes, _ := sth.Head.MarshalProto()
+        if len(sth.Signature) == 0 || !w.verifyServer(headBytes, sth.Signature) {
+            return nil, fmt.Errorf("invalid server signature on the head of epoch %d", sth.Head.Epoch)
+        }
+    }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if root, ok := w.roots[sth.Head.Epoch]; ok {
+        if root != sth.Head.Root {
+            return nil, fmt.Errorf("already cosigned root %s for epoch %d", hashStr(root), sth.Head.Epoch)
+        }
+    } else if sth.Head.Epoch < w.latest {
+        return nil, fmt.Errorf("epoch %d is older than the latest cosigned epoch %d", sth.Head.Epoch, w.latest)
+    }
+
+    w.roots[sth.Head.Epoch] = sth.Head.Root
+    if sth.Head.Epoch > w.latest {
+        w.latest = sth.Head.Epoch
+    }
+    return ed25519.Sign(w.key, CosignatureMessage(sth.Head)), nil
+}
@@ -0,0 +1,300 @@
+package aomt
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+/**
+ * Writes benchmark results to a CSV file from a dedicated goroutine, so that the measured
+ * insert/verify phases are not perturbed by file I/O. Every row is timestamped (in microseconds
+ * since the Unix epoch) when Write() is called, in an extra 'timestamp' column.
+ *
+ * Results can also be written in JSON (see OutputJSON), as JSON Lines: one object per row, whose
+ * keys are the header's columns, so that analysis code looks columns up by name and keeps working
+ * when columns are added. Every object also has a 'schemaVersion' key, which is bumped whenever a
+ * column is renamed, removed or changes meaning (adding columns does not bump it). Empty cells
+ * (i.e., missing measurements) are null, and cells that are numbers are JSON numbers.
+ *
+ * Each benchmark scenario should use its own CsvWriter (and file), which makes it safe to run
+ * multiple scenarios concurrently.
+ */
+type CsvWriter struct {
+    rows   chan csvRow
+    done   chan error
+    format string
+
+    // The file is fsync'ed after every 'syncEvery' rows. If 0, it is only fsync'ed on Close().
+    syncEvery int
+}
+
+// The formats benchmark results can be written in
+const (
+    OutputCSV  = "csv"
+    OutputJSON = "json"
+)
+
+// The version of the columns of the benchmarks' results, see CsvWriter
+const benchSchemaVersion = 1
+
+func checkOutputFormat(format string) error {
+    if format != OutputCSV && format != OutputJSON {
+        return fmt.Errorf("unknown output format '%s' (have %s, %s)", format, OutputCSV, OutputJSON)
+    }
+    return nil
+}
+
+type csvRow struct {
+    timestamp time.Time
+    values    []interface{}
+}
+
+/**
+ * Creates (or truncates) the CSV file at 'path', writes the header and starts the writer goroutine.
+ */
+func NewCsvWriter(path string, header []string, syncEvery int) *CsvWriter {
+    return NewResultWriter(path, OutputCSV, header, syncEvery)
+}
+
+/**
+ * Like NewCsvWriter(), but writes the results in the specified format (see OutputCSV and
+ * OutputJSON).
+ */
+func NewResultWriter(path string, format string, header []string, syncEvery int) *CsvWriter {
+    f, err := os.Create(path)
+    if err != nil {
+        panic("Error opening file: " + err.Error())
+    }
+
+    return _newCsvWriter(f, format, header, syncEvery)
+}
+
+/**
+ * Like NewCsvWriter(), but appends rows to the CSV file at 'path' if it exists. The header is
+ * only written if the file is empty.
+ */
+func AppendCsvWriter(path string, header []string, syncEvery int) *CsvWriter {
+    return AppendResultWriter(path, OutputCSV, header, syncEvery)
+}
+
+/**
+ * Like NewResultWriter(), but appends rows to the file at 'path' if it exists. The CSV header is
+ * only written if the file is empty.
+ */
+func AppendResultWriter(path string, format string, header []string, syncEvery int) *CsvWriter {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+    if err != nil {
+        panic("Error opening file: " + err.Error())
+    }
+
+    info, err := f.Stat()
+    if err != nil {
+        panic("Error opening file: " + err.Error())
+    }
+    // JSON files have no header, but the writer still needs the columns
+    if info.Size() > 0 && format == OutputCSV {
+        header = nil
+    }
+
+    return _newCsvWriter(f, format, header, syncEvery)
+}
+
+func _newCsvWriter(f *os.File, format string, header []string, syncEvery int) *CsvWriter {
+    w := &CsvWriter{
+        rows:      make(chan csvRow, 1024),
+        done:      make(chan error, 1),
+        format:    format,
+        syncEvery: syncEvery,
+    }
+
+    if format == OutputJSON {
+        go w._writeJSONLoop(f, header)
+    } else {
+        go w._writeLoop(f, header)
+    }
+    return w
+}
+
+/**
+ * Returns the value in the first column of the last row of the CSV file at 'path' (i.e., the
+ * dictionary size of the last completed batch), or 0 if the file has no rows or does not exist.
+ */
+func LastCsvDictSize(path string) (int, error) {
+    return LastDictSize(path, OutputCSV)
+}
+
+/**
+ * Like LastCsvDictSize(), for a file in the specified format, whose rows must have a 'dictSize'
+ * column if it is JSON.
+ */
+func LastDictSize(path string, format string) (int, error) {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+    if format == OutputJSON {
+        if lines[0] == "" {
+            return 0, nil
+        }
+        var lastRow struct {
+            DictSize *int `json:"dictSize"`
+        }
+        if err := json.Unmarshal([]byte(lines[len(lines)-1]), &lastRow); err != nil || lastRow.DictSize == nil {
+            return 0, fmt.Errorf("cannot parse dictionary size in last row of %s: %v", path, err)
+        }
+        return *lastRow.DictSize, nil
+    }
+
+    // The first line is the header
+    if len(lines) < 2 {
+        return 0, nil
+    }
+
+    lastRow := strings.Split(lines[len(lines)-1], ",")
+    size, err := strconv.Atoi(strings.TrimSpace(lastRow[0]))
+    if err != nil {
+        return 0, fmt.Errorf("cannot parse dictionary size in last row of %s: %v", path, err)
+    }
+    return size, nil
+}
+
+/**
+ * Queues a row for writing. Does not block unless the writer goroutine falls far behind.
+ */
+func (w *CsvWriter) Write(values ...interface{}) {
+    w.rows <- csvRow{timestamp: time.Now(), values: values}
+}
+
+/**
+ * Waits for all queued rows to be written, fsyncs and closes the file.
+ */
+func (w *CsvWriter) Close() error {
+    close(w.rows)
+    return <-w.done
+}
+
+func (w *CsvWriter) _writeLoop(f *os.File, header []string) {
+    buf := bufio.NewWriter(f)
+    var err error
+
+    // Once we get an error we stop writing, but we keep draining the channel so Write() never blocks
+    fail := func(e error) {
+        if err == nil && e != nil {
+            err = e
+        }
+    }
+
+    if header != nil {
+        _, e := fmt.Fprintf(buf, "%s,timestamp,\n", strings.Join(header, ","))
+        fail(e)
+    }
+
+    numRows := 0
+    for row := range w.rows {
+        if err != nil {
+            continue
+        }
+
+        for _, v := range row.values {
+            _, e := fmt.Fprintf(buf, "%v, ", v)
+            fail(e)
+        }
+        _, e := fmt.Fprintf(buf, "%d,\n", row.timestamp.UnixNano()/int64(time.Microsecond))
+        fail(e)
+
+        numRows++
+        if w.syncEvery > 0 && numRows%w.syncEvery == 0 {
+            fail(buf.Flush())
+            fail(f.Sync())
+        }
+    }
+
+    fail(buf.Flush())
+    fail(f.Sync())
+    fail(f.Close())
+    w.done <- err
+}
+
+/**
+ * Like _writeLoop(), but writes every row as a JSON object keyed by the header's columns.
+ */
+func (w *CsvWriter) _writeJSONLoop(f *os.File, header []string) {
+    buf := bufio.NewWriter(f)
+    var err error
+
+    fail := func(e error) {
+        if err == nil && e != nil {
+            err = e
+        }
+    }
+
+    numRows := 0
+    for row := range w.rows {
+        if err != nil {
+            continue
+        }
+
+        fail(_writeJSONRow(buf, header, row))
+
+        numRows++
+        if w.syncEvery > 0 && numRows%w.syncEvery == 0 {
+            fail(buf.Flush())
+            fail(f.Sync())
+        }
+    }
+
+    fail(buf.Flush())
+    fail(f.Sync())
+    fail(f.Close())
+    w.done <- err
+}
+
+/**
+ * Writes a row as one line of JSON, with the keys in the order of the header.
+ */
+func _writeJSONRow(buf *bufio.Writer, header []string, row csvRow) error {
+    if len(row.values) != len(header) {
+        return fmt.Errorf("row has %d values, but there are %d columns", len(row.values), len(header))
+    }
+
+    fmt.Fprintf(buf, "{\"schemaVersion\":%d", benchSchemaVersion)
+    for i, column := range header {
+        value, err := json.Marshal(_jsonCell(row.values[i]))
+        if err != nil {
+            return err
+        }
+        key, _ := json.Marshal(column)
+        fmt.Fprintf(buf, ",%s:%s", key, value)
+    }
+    _, err := fmt.Fprintf(buf, ",\"timestamp\":%d}\n", row.timestamp.UnixNano()/int64(time.Microsecond))
+    return err
+}
+
+/**
+ * Returns the JSON value of a cell: null if it is empty and a number if it is a string holding
+ * one (e.g., from _compareCell()).
+ */
+func _jsonCell(v interface{}) interface{} {
+    s, ok := v.(string)
+    if !ok {
+        return v
+    }
+    if s == "" {
+        return nil
+    }
+    if _, err := strconv.ParseFloat(s, 64); err == nil {
+        return json.Number(s)
+    }
+    return s
+}
@@ -0,0 +1,120 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "errors"
+    "fmt"
+)
+
+/**
+ * The data hash that marks a leaf as deleted (i.e., revoked). Deleting a leaf is just an update
+ * whose new data hash is the tombstone, so the leaf's previous versions are still committed to by
+ * its hash and append-only proofs work as for any other update: nothing is removed from the tree,
+ * the tombstone is only appended to the leaf's version chain.
+ */
+var TombstoneHash = sha256.Sum256([]byte("append-only-merkle-prefix-trees tombstone"))
+
+/**
+ * A proof that a leaf was deleted: a membership proof of the leaf and the hash the leaf had before
+ * its tombstone was appended. The leaf's current hash must be h(PrevLeafHash, TombstoneHash), which
+ * shows both that the leaf had a value (whose hash is PrevLeafHash) and that it was then revoked.
+ */
+type DeletionProof struct {
+    Membership   *MembershipProof
+    PrevLeafHash [32]byte
+}
+
+/**
+ * Deletes the existing leaf 'leafNo' by appending the tombstone to its version chain (see
+ * Tree::Update()). Returns an error if the leaf does not exist or was already deleted. A deleted
+ * leaf can be given a value again via Update().
+ */
+func (tree *Tree) MarkDeleted(leafNo [32]byte, proofTree *Tree) error {
+    if err := tree._checkDeletable(leafNo); err != nil {
+        return err
+    }
+
+    tree.Update(leafNo, TombstoneHash, proofTree)
+    return nil
+}
+
+/**
+ * Deletes an existing leaf in the epoch (see Tree::MarkDeleted()).
+ */
+func (epoch *Epoch) MarkDeleted(leafNo [32]byte) error {
+    if epoch.committed {
+        return errEpochCommitted
+    }
+    if err := epoch.tree._checkDeletable(leafNo); err != nil {
+        return err
+    }
+
+    return epoch.Update(leafNo, TombstoneHash)
+}
+
+/**
+ * Returns true if the leaf exists and its latest version is the tombstone.
+ */
+func (tree *Tree) IsDeleted(leafNo [32]byte) bool {
+    versions, ok := tree.history[leafNo]
+    return ok && versions[len(versions)-1] == TombstoneHash
+}
+
+/**
+ * Returns a proof that the specified leaf was deleted, or an error if it was not.
+ */
+func (tree *Tree) ProveDeleted(leafNo [32]byte) (*DeletionProof, error) {
+    if !tree.IsDeleted(leafNo) {
+        return nil, fmt.Errorf("leaf %s is not deleted", hashStr(leafNo))
+    }
+
+    // Rebuild the leaf's hash before the tombstone from its version chain
+    versions := tree.history[leafNo]
+    prevLeafHash := versions[0]
+    for _, dataHash := range versions[1 : len(versions)-1] {
+        prevLeafHash = tree._merkleHash(prevLeafHash, dataHash)
+    }
+
+    return &DeletionProof{Membership: tree.ProveMembership(leafNo), PrevLeafHash: prevLeafHash}, nil
+}
+
+/**
+ * Checks a deletion proof against the specified root hash of a tree that uses SHA256.
+ */
+func VerifyDeletionProof(proof *DeletionProof, rootHash [32]byte) error {
+    return VerifyDeletionProofWithOptions(proof, rootHash, TreeOptions{})
+}
+
+/**
+ * Like VerifyDeletionProof(), for trees created via NewTreeWithOptions() with the specified options.
+ */
+func VerifyDeletionProofWithOptions(proof *DeletionProof, rootHash [32]byte, opts TreeOptions) error {
+    if proof.Membership == nil {
+        return errors.New("deletion proof has no membership proof")
+    }
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    if newHash().Size() != 32 {
+        return fmt.Errorf("hash function must output 32-byte digests, got %d bytes", newHash().Size())
+    }
+
+    if !hashEqual(_nodeHash(newHash, opts.RFC6962, proof.PrevLeafHash, TombstoneHash), proof.Membership.LeafHash) {
+        return fmt.Errorf("leaf %s does not end with a tombstone", hashStr(proof.Membership.LeafNo))
+    }
+    if !VerifyMembershipProofWithOptions(proof.Membership, rootHash, opts) {
+        return fmt.Errorf("membership proof of leaf %s does not match the root", hashStr(proof.Membership.LeafNo))
+    }
+    return nil
+}
+
+func (tree *Tree) _checkDeletable(leafNo [32]byte) error {
+    if _, ok := tree.Get(leafNo); !ok {
+        return fmt.Errorf("cannot delete leaf %s because it was never inserted", hashStr(leafNo))
+    }
+    if tree.IsDeleted(leafNo) {
+        return fmt.Errorf("leaf %s was already deleted", hashStr(leafNo))
+    }
+    return nil
+}
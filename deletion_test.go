@@ -0,0 +1,62 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Deleted leaves must be proven deleted, for every hashing option, while leaves that were not
+ * deleted (or were given a value again) and tampered proofs must not be.
+ */
+func TestDeletionProofs(t *testing.T) {
+    for _, opts := range []TreeOptions{{}, {RFC6962: true}} {
+        tree, leafNos := _testMembershipTree(t, 257, opts)
+        tree.Update(leafNos[0], _testLeaf(-1).DataHash, nil)
+        for _, leafNo := range leafNos[:3] {
+            if err := tree.MarkDeleted(leafNo, nil); err != nil {
+                t.Fatal(err)
+            }
+        }
+        if err := tree.MarkDeleted(leafNos[0], nil); err == nil {
+            t.Errorf("%+v: deleting a deleted leaf succeeds", opts)
+        }
+        if err := tree.MarkDeleted(_testLeaf(1000).LeafNo, nil); err == nil {
+            t.Errorf("%+v: deleting a missing leaf succeeds", opts)
+        }
+        tree.Update(leafNos[2], _testLeaf(-2).DataHash, nil)
+        tree.RecordEpoch()
+        root := tree.GetRootHash()
+
+        for i, leafNo := range leafNos[:2] {
+            proof, err := tree.ProveDeleted(leafNo)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := VerifyDeletionProofWithOptions(proof, root, opts); err != nil {
+                t.Errorf("%+v: deletion proof of leaf %d does not verify: %v", opts, i, err)
+            }
+            if err := VerifyDeletionProofWithOptions(proof, tree.EmptyHash, opts); err == nil {
+                t.Errorf("%+v: deletion proof of leaf %d verifies against another root", opts, i)
+            }
+            tampered := *proof
+            tampered.PrevLeafHash[0] ^= 1
+            if err := VerifyDeletionProofWithOptions(&tampered, root, opts); err == nil {
+                t.Errorf("%+v: deletion proof of leaf %d verifies with the previous hash flipped", opts, i)
+            }
+        }
+
+        // Leaf 2 was given a value again, and leaf 3 was never deleted
+        for _, leafNo := range leafNos[2:4] {
+            if tree.IsDeleted(leafNo) {
+                t.Errorf("%+v: leaf %s is deleted", opts, hashStr(leafNo))
+            }
+            if _, err := tree.ProveDeleted(leafNo); err == nil {
+                t.Errorf("%+v: leaf %s is proven deleted", opts, hashStr(leafNo))
+            }
+            forged := &DeletionProof{Membership: tree.ProveMembership(leafNo)}
+            if err := VerifyDeletionProofWithOptions(forged, root, opts); err == nil {
+                t.Errorf("%+v: forged deletion proof of leaf %s verifies", opts, hashStr(leafNo))
+            }
+        }
+    }
+}
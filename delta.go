@@ -0,0 +1,257 @@
+package aomt
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "time"
+)
+
+/**
+ * Read replicas are kept in sync with a primary tree by shipping them each recorded epoch's delta,
+ * i.e., the leaf changes of the epoch along with the roots before and after it (see Epoch::Delta()
+ * and Tree::EpochDelta()), which followers apply in order via Tree::ApplyDelta(). Applied epochs
+ * get the primary's timestamps, so a follower ends up with the same roots and epoch headers as the
+ * primary and can serve the same proofs.
+ *
+ * A delta can also carry the epoch's append-only proof, i.e., the epoch's new interior nodes and
+ * their siblings, so that a follower checks that the primary's tree extends its own before
+ * applying it, e.g., to catch a primary that forked the tree.
+ *
+ * Deltas are sent as protobuf messages (see EpochDelta in aomt.proto).
+ *
+ * NOTE: As with the WAL, leaf values and commitment openings are not part of a delta, so followers
+ * can serve membership proofs but not the values themselves (see InsertValue()).
+ */
+type EpochDelta struct {
+    ParamsDigest [32]byte // the parameters of the primary's tree, see Tree::ParamsDigest()
+    Epoch        int
+    OldRoot      [32]byte // the root of the previous epoch, or the empty hash for epoch 0
+    NewRoot      [32]byte
+    Timestamp    int64    // when the primary recorded the epoch, in Unix nanoseconds
+    Header       [32]byte // the hash of the epoch's header, see EpochHeader
+    Changes      []DeltaChange
+    Proof        *AppendOnlyProof // the append-only proof from OldRoot to NewRoot, if included
+}
+
+/**
+ * A leaf change in an epoch delta, in the order the primary made it.
+ */
+type DeltaChange struct {
+    LeafNo   [32]byte
+    DataHash [32]byte
+    IsUpdate bool // true if this was an Update() rather than an Insert()
+}
+
+/**
+ * Returns the delta of the committed epoch, including its append-only proof if 'withProof' is true.
+ * Epoch 0 never has a proof, since every tree extends the empty one.
+ */
+func (epoch *Epoch) Delta(withProof bool) (*EpochDelta, error) {
+    if !epoch.committed {
+        return nil, errors.New("epoch was not committed yet")
+    }
+
+    delta := epoch.tree._epochDelta(epoch.number)
+    if withProof && epoch.number > 0 {
+        delta.Proof = epoch.proof
+    }
+    return delta, nil
+}
+
+/**
+ * Returns the delta of the specified recorded epoch, e.g., for a follower that is catching up.
+ * If 'withProof' is true, the epoch's append-only proof is computed by replaying the tree up to
+ * the epoch (see ProveAppendOnly()), which is slow for old epochs of large trees.
+ */
+func (tree *Tree) EpochDelta(epoch int, withProof bool) (*EpochDelta, error) {
+    if epoch < 0 || epoch >= len(tree.epochs) {
+        return nil, fmt.Errorf("epoch %d was never recorded (have %d epochs)", epoch, len(tree.epochs))
+    }
+
+    delta := tree._epochDelta(epoch)
+    if withProof && epoch > 0 {
+        replay := tree.TreeAtEpoch(epoch - 1)
+        proofTree := tree.NewEmptyTree()
+        replay._replayEpoch(tree.epochs[epoch], proofTree)
+        delta.Proof = _compressAndFlatten(proofTree)
+    }
+    return delta, nil
+}
+
+func (tree *Tree) _epochDelta(epoch int) *EpochDelta {
+    record := tree.epochs[epoch]
+    delta := &EpochDelta{
+        ParamsDigest: tree.ParamsDigest(),
+        Epoch:        epoch,
+        OldRoot:      tree.EmptyHash,
+        NewRoot:      record.root,
+        Timestamp:    record.timestamp,
+        Header:       record.header,
+    }
+    if epoch > 0 {
+        delta.OldRoot = tree.epochs[epoch-1].root
+    }
+    for _, change := range record.changes {
+        delta.Changes = append(delta.Changes, DeltaChange{LeafNo: change.leafNo, DataHash: change.dataHash, IsUpdate: change.isUpdate})
+    }
+    return delta
+}
+
+/**
+ * Applies the delta of the epoch that follows the tree's last recorded epoch and records it, with
+ * the primary's timestamp. The delta is checked against the tree before it is applied: it must be
+ * for a tree with the same parameters, its old root must be the tree's root, its proof (if any)
+ * must be valid and its changes must apply (i.e., inserted leaves must be new and updated ones
+ * must exist). If the applied changes then do not lead to the delta's root and header, the epoch
+ * is not recorded and an error is returned, after which the tree refuses further deltas and must
+ * be discarded (e.g., and resynced from a snapshot of the primary).
+ *
+ * NOTE: The proof only shows that the delta's new root extends the tree's root, not that the
+ * delta's changes lead to it, which only the root check after applying them shows.
+ */
+func (tree *Tree) ApplyDelta(delta *EpochDelta) error {
+    if tree.open != nil || len(tree.pending) > 0 {
+        return errors.New("cannot apply a delta to a tree with changes that are not in an epoch")
+    }
+    if delta.ParamsDigest != tree.ParamsDigest() {
+        return errors.New("the delta is for a tree with different parameters (# of levels or hash function)")
+    }
+    if delta.Epoch != len(tree.epochs) {
+        return fmt.Errorf("the delta is for epoch %d, but the tree is at epoch %d", delta.Epoch, len(tree.epochs)-1)
+    }
+
+    oldRoot := tree.EmptyHash
+    if len(tree.epochs) > 0 {
+        oldRoot = tree.epochs[len(tree.epochs)-1].root
+    }
+    if !hashEqual(delta.OldRoot, oldRoot) {
+        return fmt.Errorf("the delta's old root %s is not the root of epoch %d", hashStr(delta.OldRoot), delta.Epoch-1)
+    }
+    if len(delta.Changes) == 0 && !hashEqual(delta.OldRoot, delta.NewRoot) {
+        return fmt.Errorf("the delta of epoch %d has no changes, but its roots differ", delta.Epoch)
+    }
+    if delta.Proof != nil {
+        if err := delta.Proof.VerifyWithOptions(delta.OldRoot, delta.NewRoot, tree.Options()); err != nil {
+            return fmt.Errorf("invalid append-only proof in the delta of epoch %d: %v", delta.Epoch, err)
+        }
+    }
+    if err := tree._checkDeltaChanges(delta.Changes); err != nil {
+        return err
+    }
+
+    record := &epochRecord{}
+    for _, change := range delta.Changes {
+        record.changes = append(record.changes, epochLeaf{leafNo: change.LeafNo, dataHash: change.DataHash, isUpdate: change.IsUpdate})
+    }
+    tree._replayEpoch(record, nil)
+    if !hashEqual(tree.GetRootHash(), delta.NewRoot) {
+        return fmt.Errorf("the applied root hash of epoch %d does not match the delta's", delta.Epoch)
+    }
+    tree._recordEpochAt(time.Unix(0, delta.Timestamp))
+    if tree.epochs[delta.Epoch].header != delta.Header {
+        return fmt.Errorf("the applied header of epoch %d does not match the delta's", delta.Epoch)
+    }
+    return nil
+}
+
+/**
+ * Checks that the changes can be applied to the tree in order, so that applying them does not
+ * panic half-way through.
+ */
+func (tree *Tree) _checkDeltaChanges(changes []DeltaChange) error {
+    inserted := make(map[[32]byte]bool)
+    for i, change := range changes {
+        if !_fitsInLevel(change.LeafNo, tree.numLevels-1) {
+            return fmt.Errorf("change %d: leaf no %s is too large for a tree with %d levels", i, hashStr(change.LeafNo), tree.numLevels)
+        }
+        _, exists := tree.Get(change.LeafNo)
+        exists = exists || inserted[change.LeafNo]
+        if change.IsUpdate && !exists {
+            return fmt.Errorf("change %d: cannot update leaf %s, which is not in the tree", i, hashStr(change.LeafNo))
+        }
+        if !change.IsUpdate && exists {
+            return fmt.Errorf("change %d: cannot insert leaf %s, which is already in the tree", i, hashStr(change.LeafNo))
+        }
+        inserted[change.LeafNo] = true
+    }
+    return nil
+}
+
+func (change DeltaChange) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoHash(&buf, 1, change.LeafNo)
+    _writeProtoHash(&buf, 2, change.DataHash)
+    if change.IsUpdate {
+        _writeProtoUint(&buf, 3, 1)
+    }
+    return buf.Bytes(), nil
+}
+
+func (change *DeltaChange) UnmarshalProto(data []byte) error {
+    *change = DeltaChange{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.hashTo(&change.LeafNo)
+        case 2:
+            return value.hashTo(&change.DataHash)
+        case 3:
+            return value.boolTo(&change.IsUpdate)
+        }
+        return fmt.Errorf("unknown EpochDelta.Change field %d", fieldNo)
+    })
+}
+
+func (delta *EpochDelta) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoHash(&buf, 1, delta.ParamsDigest)
+    _writeProtoUint(&buf, 2, uint64(delta.Epoch))
+    _writeProtoHash(&buf, 3, delta.OldRoot)
+    _writeProtoHash(&buf, 4, delta.NewRoot)
+    _writeProtoUint(&buf, 5, uint64(delta.Timestamp))
+    _writeProtoHash(&buf, 6, delta.Header)
+    for _, change := range delta.Changes {
+        _writeProtoMessage(&buf, 7, change)
+    }
+    if delta.Proof != nil {
+        _writeProtoMessage(&buf, 8, delta.Proof)
+    }
+    return buf.Bytes(), nil
+}
+
+func (delta *EpochDelta) UnmarshalProto(data []byte) error {
+    *delta = EpochDelta{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.hashTo(&delta.ParamsDigest)
+        case 2:
+            return value.intTo(&delta.Epoch)
+        case 3:
+            return value.hashTo(&delta.OldRoot)
+        case 4:
+            return value.hashTo(&delta.NewRoot)
+        case 5:
+            var timestamp uint64
+            if err := value.uint64To(&timestamp); err != nil {
+                return err
+            }
+            delta.Timestamp = int64(timestamp)
+            return nil
+        case 6:
+            return value.hashTo(&delta.Header)
+        case 7:
+            var change DeltaChange
+            if err := value.messageTo(&change); err != nil {
+                return err
+            }
+            delta.Changes = append(delta.Changes, change)
+            return nil
+        case 8:
+            delta.Proof = &AppendOnlyProof{}
+            return value.messageTo(delta.Proof)
+        }
+        return fmt.Errorf("unknown EpochDelta field %d", fieldNo)
+    })
+}
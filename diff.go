@@ -0,0 +1,90 @@
+package aomt
+
+import (
+    "fmt"
+)
+
+type LeafChangeKind byte
+
+const (
+    LeafAdded   LeafChangeKind = iota // only in the second tree
+    LeafRemoved                       // only in the first tree
+    LeafChanged                       // in both trees, with different hashes
+)
+
+func (kind LeafChangeKind) String() string {
+    switch kind {
+    case LeafAdded:
+        return "added"
+    case LeafRemoved:
+        return "removed"
+    case LeafChanged:
+        return "changed"
+    }
+    return fmt.Sprintf("LeafChangeKind(%d)", byte(kind))
+}
+
+/**
+ * A leaf that differs between two trees, see Diff().
+ */
+type LeafChange struct {
+    Kind    LeafChangeKind
+    LeafNo  [32]byte
+    OldHash [32]byte // the leaf's hash in the first tree, or the empty hash if it is not there
+    NewHash [32]byte // the leaf's hash in the second tree, or the empty hash if it is not there
+}
+
+/**
+ * Returns the leaves that differ between 'a' and 'b', i.e., the changes that turn the leaves of 'a'
+ * into those of 'b', sorted by leaf no. Only the subtrees whose hashes differ are descended into,
+ * so diffing trees that mostly agree (e.g., a mirror and the server it mirrors) is cheap. The trees
+ * must have the same parameters (see Tree::ParamsDigest()), or else their hashes cannot be compared.
+ *
+ * NOTE: The pruned subtrees that are descended into are rebuilt (see Tree::Prune()), so this is
+ * not a read-only operation for pruned trees.
+ */
+func Diff(a, b *Tree) []LeafChange {
+    if a.ParamsDigest() != b.ParamsDigest() {
+        panic("Cannot diff trees with different parameters (# of levels or hash function)")
+    }
+
+    var changes []LeafChange
+    _diffSubtree(a, b, 0, a.RootNo, &changes)
+    return changes
+}
+
+func _diffSubtree(a, b *Tree, level int, nodeNo [32]byte, changes *[]LeafChange) {
+    nodeA, nodeB := a.lvl[level].peek(nodeNo), b.lvl[level].peek(nodeNo)
+    if nodeA == nil && nodeB == nil {
+        return
+    }
+    if nodeA != nil && nodeB != nil && nodeA.Hash == nodeB.Hash {
+        return
+    }
+
+    if level == a.numLevels-1 {
+        change := LeafChange{Kind: LeafChanged, LeafNo: nodeNo, OldHash: a.EmptyHash, NewHash: b.EmptyHash}
+        if nodeA == nil {
+            change.Kind = LeafAdded
+        } else {
+            change.OldHash = nodeA.Hash
+        }
+        if nodeB == nil {
+            change.Kind = LeafRemoved
+        } else {
+            change.NewHash = nodeB.Hash
+        }
+        *changes = append(*changes, change)
+        return
+    }
+
+    if nodeA != nil && nodeA.Pruned {
+        a._restore(level, nodeNo)
+    }
+    if nodeB != nil && nodeB.Pruned {
+        b._restore(level, nodeNo)
+    }
+    leftNo, rightNo := childrenOf(nodeNo)
+    _diffSubtree(a, b, level+1, leftNo, changes)
+    _diffSubtree(a, b, level+1, rightNo, changes)
+}
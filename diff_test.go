@@ -0,0 +1,49 @@
+package aomt
+
+import (
+    "bytes"
+    "testing"
+)
+
+/**
+ * Diff must find exactly the added, removed and changed leaves, sorted by leaf no.
+ */
+func TestDiffFindsChangedLeaves(t *testing.T) {
+    a, b := NewTree(257), NewTree(257)
+    for i := 0; i < 50; i++ {
+        leaf := _testLeaf(i)
+        a.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        leaf = _testLeaf(i + 10)
+        b.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    }
+    b.Update(_testLeaf(20).LeafNo, _testLeaf(-20).DataHash, nil)
+
+    expected := map[[32]byte]LeafChangeKind{_testLeaf(20).LeafNo: LeafChanged}
+    for i := 0; i < 10; i++ {
+        expected[_testLeaf(i).LeafNo] = LeafRemoved
+        expected[_testLeaf(i+50).LeafNo] = LeafAdded
+    }
+
+    changes := Diff(a, b)
+    if len(changes) != len(expected) {
+        t.Fatalf("found %d changes, expected %d", len(changes), len(expected))
+    }
+    for i, change := range changes {
+        if i > 0 && bytes.Compare(changes[i-1].LeafNo[:], change.LeafNo[:]) >= 0 {
+            t.Errorf("changes are not sorted by leaf no")
+        }
+        if kind, ok := expected[change.LeafNo]; !ok || kind != change.Kind {
+            t.Errorf("leaf %s is %s, expected %s", hashStr(change.LeafNo), change.Kind, kind)
+        }
+        oldHash, _ := a.Get(change.LeafNo)
+        newHash, _ := b.Get(change.LeafNo)
+        if change.OldHash != oldHash || change.NewHash != newHash {
+            t.Errorf("%s leaf %s has hashes %s and %s, expected %s and %s", change.Kind, hashStr(change.LeafNo),
+                hashStr(change.OldHash), hashStr(change.NewHash), hashStr(oldHash), hashStr(newHash))
+        }
+    }
+
+    if changes := Diff(a, a); len(changes) != 0 {
+        t.Errorf("tree differs from itself in %d leaves", len(changes))
+    }
+}
@@ -0,0 +1,140 @@
+package aomt
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math/big"
+)
+
+// The colors of the nodes in ExportDOT()'s output
+const (
+    dotColorOld     = "lightblue"
+    dotColorNew     = "palegreen"
+    dotColorEmpty   = "white"
+    dotColorImplied = "lightgrey"
+)
+
+/**
+ * Writes the tree in Graphviz's DOT format (render it via, e.g., 'dot -Tsvg'), for debugging
+ * proof construction on small trees. Every node is labeled with its level, its LN, the first bytes
+ * of its hash and the # of hashes appended to it, if any. Empty nodes (i.e., the empty siblings
+ * of proof trees) are white and dashed, and, if 'markNew' is true, new nodes are green and old
+ * ones blue, like Print() with 'includeNew' set.
+ *
+ * Compressed proof trees only keep the highest node of every new subtree and its siblings, so the
+ * ancestors whose hashes the verifier computes are missing. The missing ancestors that are the root
+ * or where paths branch are drawn grey and unlabeled by a hash, and every node has an edge from its
+ * lowest drawn ancestor, which is dashed if it skips levels.
+ */
+func (tree *Tree) ExportDOT(w io.Writer, markNew bool) error {
+    implied := tree._dotImpliedNodes()
+
+    bw := bufio.NewWriter(w)
+    fmt.Fprintf(bw, "digraph tree {\n")
+    fmt.Fprintf(bw, "    node [shape=box, style=filled, fontname=\"monospace\"];\n")
+
+    isDrawn := func(level int, nodeNo [32]byte) bool {
+        _, ok := tree.lvl[level].node[nodeNo]
+        return ok || implied[level][nodeNo]
+    }
+    for level := 0; level < tree.numLevels; level++ {
+        lvl := tree.lvl[level]
+        nodeNos := lvl.sortedNodeNos()
+        for nodeNo := range implied[level] {
+            nodeNos = append(nodeNos, nodeNo)
+        }
+        if len(nodeNos) == 0 {
+            continue
+        }
+        _sortHashes(nodeNos)
+
+        fmt.Fprintf(bw, "\n    // Level %d\n", level)
+        for _, nodeNo := range nodeNos {
+            fmt.Fprintf(bw, "    %s [%s];\n", _dotNodeId(level, nodeNo), tree._dotNodeAttrs(level, nodeNo, lvl.node[nodeNo], markNew))
+
+            for ancestor := level - 1; ancestor >= 0; ancestor-- {
+                ancestorNo := rshBytes(nodeNo, uint(level-ancestor))
+                if !isDrawn(ancestor, ancestorNo) {
+                    continue
+                }
+
+                style := ""
+                if ancestor < level-1 {
+                    style = " [style=dashed]"
+                }
+                fmt.Fprintf(bw, "    %s -> %s%s;\n", _dotNodeId(ancestor, ancestorNo), _dotNodeId(level, nodeNo), style)
+                break
+            }
+        }
+        fmt.Fprintf(bw, "    { rank=same;")
+        for _, nodeNo := range nodeNos {
+            fmt.Fprintf(bw, " %s;", _dotNodeId(level, nodeNo))
+        }
+        fmt.Fprintf(bw, " }\n")
+    }
+
+    fmt.Fprintf(bw, "}\n")
+    return bw.Flush()
+}
+
+/**
+ * Returns the missing ancestors ExportDOT() draws: the root and those with nodes in both of their
+ * subtrees, per level.
+ */
+func (tree *Tree) _dotImpliedNodes() []map[[32]byte]bool {
+    // The bits of children[level][LN] say which of the node's children have nodes in their subtrees
+    children := make([]map[[32]byte]byte, tree.numLevels)
+    for level := range children {
+        children[level] = make(map[[32]byte]byte)
+    }
+    for level := 1; level < tree.numLevels; level++ {
+        for nodeNo := range tree.lvl[level].node {
+            for ancestor := level - 1; ancestor >= 0; ancestor-- {
+                childNo := rshBytes(nodeNo, uint(level-ancestor-1))
+                children[ancestor][rshBytes(childNo, 1)] |= 1 << (childNo[31] & 1)
+            }
+        }
+    }
+
+    implied := make([]map[[32]byte]bool, tree.numLevels)
+    for level := range implied {
+        implied[level] = make(map[[32]byte]bool)
+        for nodeNo, bits := range children[level] {
+            if _, ok := tree.lvl[level].node[nodeNo]; !ok && (bits == 3 || level == 0) {
+                implied[level][nodeNo] = true
+            }
+        }
+    }
+    return implied
+}
+
+func _dotNodeId(level int, nodeNo [32]byte) string {
+    return fmt.Sprintf("n%d_%s", level, hashStr(nodeNo))
+}
+
+/**
+ * Returns the DOT attributes of a node, which is nil if it is implied (see _dotImpliedNodes()).
+ */
+func (tree *Tree) _dotNodeAttrs(level int, nodeNo [32]byte, node *Node, markNew bool) string {
+    var ln big.Int
+    ln.SetBytes(nodeNo[:])
+    label := fmt.Sprintf("L%d #%s", level, &ln)
+
+    if node == nil {
+        return fmt.Sprintf("label=\"%s\", fillcolor=%s, style=\"filled,dotted\"", label, dotColorImplied)
+    }
+    if node.Hash == tree.EmptyHash {
+        return fmt.Sprintf("label=\"%s\\nempty\", fillcolor=%s, style=\"filled,dashed\"", label, dotColorEmpty)
+    }
+
+    label += "\\n" + hashStr(node.Hash)[:8]
+    if len(node.Appended) > 0 {
+        label += fmt.Sprintf("\\n+%d appended", len(node.Appended))
+    }
+    color := dotColorOld
+    if markNew && tree.lvl[level].isNew(nodeNo) {
+        color = dotColorNew
+    }
+    return fmt.Sprintf("label=\"%s\", fillcolor=%s", label, color)
+}
@@ -0,0 +1,269 @@
+/**
+ * Package aomt implements append-only Merkle prefix trees: sparse Merkle trees whose epochs come
+ * with proofs that they only appended leaves to the previous ones. Services can embed a
+ * persistent, signed tree via Open(), or serve one over HTTP (see Server) or gRPC (see
+ * cmd/amtserver). Clients that only verify proofs should use package client instead.
+ */
+package aomt
+
+import (
+    "crypto/ed25519"
+    "errors"
+    "fmt"
+    "sync"
+)
+
+/**
+ * A tree embedded in the calling process, without a server: its epochs are stored in a directory,
+ * in the same format as the stores of long-running servers (see Store), and their heads are signed
+ * as servers sign them, so clients verify the DB's proofs and heads as they verify a server's
+ * (e.g., via package client), with the DB's Options::Tree. The DB only inserts leaves' data
+ * hashes, which is all its WAL logs (see WAL), so it cannot be opened with
+ * TreeOptions::LeafExtensions, whose leaves must have extensions.
+ *
+ * Every epoch is durably logged before Insert() returns it, and a checkpoint is written every
+ * Options::CheckpointEvery epochs, so opening the DB again recovers every epoch Insert() returned.
+ * If logging an epoch fails, the epoch is in the tree but not on disk, so the DB refuses further
+ * inserts and must be reopened.
+ *
+ * A DB is safe for concurrent use: lookups and proofs run concurrently with one another, and with
+ * inserts except while their epoch is being committed.
+ */
+type DB struct {
+    mu        sync.Mutex // serializes inserts, logging and checkpoints
+    store     *Store
+    tree      *ConcurrentTree
+    opts      Options
+    numLogged int   // the # of epochs logged since the last checkpoint
+    err       error // the error that made the DB read-only, if any
+}
+
+/**
+ * The options of an embedded tree.
+ */
+type Options struct {
+    NumLevels int         // the # of levels of new trees, 257 if 0
+    Tree      TreeOptions // must be the same every time the DB is opened, see Store
+
+    // The key that signs the heads of epochs (over TreeHead::MarshalProto(), as the bundles
+    // exported by 'amt export-bundle --signing-key'). If nil, heads are not signed.
+    SigningKey ed25519.PrivateKey
+
+    // Write a checkpoint every this many epochs, so that opening the DB replays at most that many
+    // epochs from the WAL. If 0, checkpoints are only written via DB::Checkpoint().
+    CheckpointEvery int
+}
+
+var errDBClosed = errors.New("the DB is closed")
+
+/**
+ * Opens the DB in directory 'dir', creating it if it does not exist. Fails if 'opts.Tree' has
+ * LeafExtensions set, see DB.
+ */
+func Open(dir string, opts Options) (*DB, error) {
+    if opts.NumLevels == 0 {
+        opts.NumLevels = maxNumLevels
+    }
+    if opts.SigningKey != nil && len(opts.SigningKey) != ed25519.PrivateKeySize {
+        return nil, fmt.Errorf("the signing key must have %d bytes, not %d", ed25519.PrivateKeySize, len(opts.SigningKey))
+    }
+    if opts.CheckpointEvery < 0 {
+        return nil, fmt.Errorf("invalid checkpoint interval %d", opts.CheckpointEvery)
+    }
+    if opts.Tree.LeafExtensions {
+        return nil, errors.New("the DB cannot insert leaves with extensions, so its tree cannot require them")
+    }
+
+    store, err := OpenStore(dir, opts.NumLevels, opts.Tree)
+    if err != nil {
+        return nil, err
+    }
+    if key := opts.SigningKey; key != nil {
+        store.Tree().SetTreeHeadSigner(func(head TreeHead) []byte {
+            message, _ := head.MarshalProto()
+            return ed25519.Sign(key, message)
+        })
+    }
+    return &DB{store: store, tree: WrapTree(store.Tree()), opts: opts, numLogged: store.NumReplayed()}, nil
+}
+
+/**
+ * Returns the public key that verifies the heads' signatures, or nil if they are not signed.
+ */
+func (db *DB) PublicKey() ed25519.PublicKey {
+    if db.opts.SigningKey == nil {
+        return nil
+    }
+    return db.opts.SigningKey.Public().(ed25519.PublicKey)
+}
+
+/**
+ * Inserts the leaves in a new epoch and durably logs it, see ConcurrentTree::TryInsertEpoch().
+ * Returns the new epoch, or -1 if every leaf was rejected (and thus no epoch was committed), and
+ * why each leaf was rejected (i.e., nil for the inserted leaves), in the same order as 'leaves'.
+ * Returns an error if the epoch could not be logged or checkpointed.
+ */
+func (db *DB) Insert(leaves []LeafData) (int, []error, error) {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+
+    if db.err != nil {
+        return -1, nil, db.err
+    }
+    epoch, errs := db.tree.TryInsertEpoch(leaves)
+    if epoch < 0 {
+        return -1, errs, nil
+    }
+
+    var err error
+    db.tree.Read(func(tree *Tree) {
+        err = db.store.LogEpoch(epoch)
+    })
+    if err != nil {
+        db.err = fmt.Errorf("epoch %d could not be logged, so the DB must be reopened: %v", epoch, err)
+        return -1, errs, db.err
+    }
+    db.numLogged++
+
+    if db.opts.CheckpointEvery > 0 && db.numLogged >= db.opts.CheckpointEvery {
+        if err := db._checkpoint(epoch); err != nil {
+            return epoch, errs, fmt.Errorf("epoch %d was logged, but not checkpointed: %v", epoch, err)
+        }
+    }
+    return epoch, errs, nil
+}
+
+/**
+ * Writes a checkpoint of the last epoch, see Store::Checkpoint(). Does nothing if there is no
+ * epoch yet.
+ */
+func (db *DB) Checkpoint() error {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+
+    if db.err != nil {
+        return db.err
+    }
+    epoch := db.NumEpochs() - 1
+    if epoch < 0 || epoch == db.store.CheckpointEpoch() {
+        return nil
+    }
+    return db._checkpoint(epoch)
+}
+
+func (db *DB) _checkpoint(epoch int) error {
+    var err error
+    db.tree.Read(func(tree *Tree) {
+        err = db.store.Checkpoint(epoch)
+    })
+    if err == nil {
+        db.numLogged = 0
+    }
+    return err
+}
+
+func (db *DB) NumEpochs() int {
+    var numEpochs int
+    db.tree.Read(func(tree *Tree) {
+        numEpochs = tree.NumEpochs()
+    })
+    return numEpochs
+}
+
+func (db *DB) NumLevels() int {
+    return db.store.Tree().numLevels
+}
+
+/**
+ * Returns the signed head of the last epoch, or an error if there is no epoch yet.
+ */
+func (db *DB) Head() (SignedTreeHead, error) {
+    var sth SignedTreeHead
+    var err error
+    db.tree.Read(func(tree *Tree) {
+        if tree.NumEpochs() == 0 {
+            err = errors.New("the DB has no epochs")
+            return
+        }
+        sth = tree.GetSignedTreeHead(tree.NumEpochs() - 1)
+    })
+    return sth, err
+}
+
+/**
+ * Returns the signed head of the specified epoch.
+ */
+func (db *DB) HeadAt(epoch int) (SignedTreeHead, error) {
+    var sth SignedTreeHead
+    var err error
+    db.tree.Read(func(tree *Tree) {
+        if epoch < 0 || epoch >= tree.NumEpochs() {
+            err = fmt.Errorf("epoch %d was never recorded (have %d epochs)", epoch, tree.NumEpochs())
+            return
+        }
+        sth = tree.GetSignedTreeHead(epoch)
+    })
+    return sth, err
+}
+
+/**
+ * Returns the (non-)membership proof of a leaf in the last epoch, along with that epoch's signed
+ * head, which the proof verifies against (see VerifyMembershipProofWithOptions()).
+ */
+func (db *DB) Get(leafNo [32]byte) (*MembershipProof, SignedTreeHead, error) {
+    var proof *MembershipProof
+    var sth SignedTreeHead
+    var err error
+    db.tree.Read(func(tree *Tree) {
+        if tree.NumEpochs() == 0 {
+            err = errors.New("the DB has no epochs")
+            return
+        }
+        if !_fitsInLevel(leafNo, tree.numLevels-1) {
+            err = fmt.Errorf("leaf %s does not fit in a tree with %d levels", hashStr(leafNo), tree.numLevels)
+            return
+        }
+        sth = tree.GetSignedTreeHead(tree.NumEpochs() - 1)
+        proof = tree.ProveMembership(leafNo)
+    })
+    return proof, sth, err
+}
+
+/**
+ * Returns the proof that epoch 'toEpoch' only appended leaves to epoch 'fromEpoch', see
+ * Tree::ProveAppendOnly().
+ */
+func (db *DB) ProveAppendOnly(fromEpoch int, toEpoch int) (*AppendOnlyProof, error) {
+    var proof *AppendOnlyProof
+    var err error
+    db.tree.Read(func(tree *Tree) {
+        if fromEpoch < 0 || toEpoch >= tree.NumEpochs() || fromEpoch >= toEpoch {
+            err = fmt.Errorf("cannot prove epoch %d is a prefix of epoch %d (have %d epochs)", fromEpoch, toEpoch, tree.NumEpochs())
+            return
+        }
+        proof = NewAppendOnlyProof(tree.ProveAppendOnly(fromEpoch, toEpoch))
+    })
+    return proof, err
+}
+
+/**
+ * Returns the DB's tree, for the lookups and proofs not wrapped above. Its epochs must only be
+ * changed via Insert(), or they would not be logged.
+ */
+func (db *DB) Tree() *ConcurrentTree {
+    return db.tree
+}
+
+/**
+ * Closes the DB's WAL. Every epoch returned by Insert() is already on disk, so this writes nothing.
+ */
+func (db *DB) Close() error {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+
+    if db.err == errDBClosed {
+        return nil
+    }
+    db.err = errDBClosed
+    return db.store.Close()
+}
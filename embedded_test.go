@@ -0,0 +1,159 @@
+package aomt
+
+import (
+    "crypto/ed25519"
+    "testing"
+)
+
+/**
+ * Epochs inserted in a DB must be recovered, with the same signed heads, when it is reopened, both
+ * from the WAL and from checkpoints, and its proofs must verify against its heads.
+ */
+func TestDBRecoversEpochs(t *testing.T) {
+    dir := t.TempDir()
+    key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+    opts := Options{NumLevels: 257, SigningKey: key, CheckpointEvery: 2}
+    db, err := Open(dir, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var heads []SignedTreeHead
+    for e := 0; e < 5; e++ {
+        var leaves []LeafData
+        for i := 10 * e; i < 10*(e+1); i++ {
+            leaves = append(leaves, _testLeaf(i))
+        }
+        epoch, errs, err := db.Insert(leaves)
+        if err != nil || epoch != e {
+            t.Fatalf("inserted epoch %d (%v), expected %d", epoch, err, e)
+        }
+        for _, err := range errs {
+            if err != nil {
+                t.Fatal(err)
+            }
+        }
+        head, err := db.Head()
+        if err != nil {
+            t.Fatal(err)
+        }
+        message, _ := head.Head.MarshalProto()
+        if head.Head.Epoch != e || !ed25519.Verify(db.PublicKey(), message, head.Signature) {
+            t.Fatalf("head of epoch %d is for epoch %d or badly signed", e, head.Head.Epoch)
+        }
+        heads = append(heads, head)
+    }
+
+    // Leaves that are already in the tree are rejected, without a new epoch
+    epoch, errs, err := db.Insert([]LeafData{_testLeaf(3)})
+    if err != nil || epoch != -1 || errs[0] == nil {
+        t.Fatalf("inserting an existing leaf returned epoch %d, %v and %v", epoch, errs, err)
+    }
+
+    proof, head, err := db.Get(_testLeaf(42).LeafNo)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if proof.LeafHash == ([32]byte{}) || !VerifyMembershipProofWithOptions(proof, head.Head.Root, opts.Tree) {
+        t.Errorf("membership proof of leaf 42 does not verify")
+    }
+    appendOnly, err := db.ProveAppendOnly(1, 4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := appendOnly.Verify(heads[1].Head.Root, heads[4].Head.Root); err != nil {
+        t.Errorf("append-only proof does not verify: %v", err)
+    }
+    if _, err := db.ProveAppendOnly(4, 5); err == nil {
+        t.Errorf("proved an epoch that does not exist")
+    }
+    if err := db.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := db.Insert([]LeafData{_testLeaf(100)}); err == nil {
+        t.Fatal("inserted in a closed DB")
+    }
+
+    // Epoch 3 is checkpointed and epoch 4 is only in the WAL
+    db, err = Open(dir, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+    if db.store.CheckpointEpoch() != 3 || db.store.NumReplayed() != 1 {
+        t.Fatalf("reopened DB has checkpoint %d and replayed %d epochs, expected 3 and 1", db.store.CheckpointEpoch(), db.store.NumReplayed())
+    }
+    for e, head := range heads {
+        reopened, err := db.HeadAt(e)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if reopened.Head != head.Head || string(reopened.Signature) != string(head.Signature) {
+            t.Errorf("reopened DB has another head for epoch %d", e)
+        }
+    }
+    if epoch, _, err := db.Insert([]LeafData{_testLeaf(100)}); err != nil || epoch != 5 {
+        t.Fatalf("inserted epoch %d (%v) in the reopened DB, expected 5", epoch, err)
+    }
+}
+
+/**
+ * Proofs of a reopened DB must verify with the TreeOptions it was opened with, for every option a
+ * DB can be opened with, and DBs whose leaves would need extensions must not open.
+ */
+func TestDBReopensWithTreeOptions(t *testing.T) {
+    cases := map[string]TreeOptions{
+        "rfc6962":          {RFC6962: true, HashingScheme: HashingLevelLN},
+        "keccak":           {NewHash: NewKeccak256},
+        "hmac":             {HMACKey: []byte("key"), HMACLeafNos: true, HMACLeafHashes: true},
+        "insertion epochs": {InsertionEpochs: true},
+    }
+    for name, treeOpts := range cases {
+        dir := t.TempDir()
+        opts := Options{Tree: treeOpts, CheckpointEvery: 2}
+        db, err := Open(dir, opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for e := 0; e < 3; e++ {
+            var leaves []LeafData
+            for i := 10 * e; i < 10*(e+1); i++ {
+                leaves = append(leaves, _testLeaf(i))
+            }
+            if epoch, _, err := db.Insert(leaves); err != nil || epoch != e {
+                t.Fatalf("%s: inserted epoch %d (%v), expected %d", name, epoch, err, e)
+            }
+        }
+        db.Close()
+
+        // Epoch 1 is checkpointed and epoch 2 is only in the WAL
+        db, err = Open(dir, opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, i := range []int{5, 15, 25, 35} {
+            proof, head, err := db.Get(_testLeaf(i).LeafNo)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := CheckMembershipProof(proof, head.Head.Root, treeOpts); err != nil {
+                t.Errorf("%s: proof of leaf %d in the reopened DB does not verify: %v", name, i, err)
+            }
+        }
+        first, _ := db.HeadAt(0)
+        last, _ := db.Head()
+        appendOnly, err := db.ProveAppendOnly(0, 2)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := appendOnly.VerifyWithOptions(first.Head.Root, last.Head.Root, treeOpts); err != nil {
+            t.Errorf("%s: append-only proof of the reopened DB does not verify: %v", name, err)
+        }
+        db.Close()
+    }
+
+    if db, err := Open(t.TempDir(), Options{Tree: TreeOptions{LeafExtensions: true}}); err == nil {
+        db.Close()
+        t.Errorf("opened a DB whose leaves need extensions")
+    }
+}
@@ -0,0 +1,176 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash"
+)
+
+/**
+ * A self-describing wrapper around a serialized append-only proof, which says what the proof is
+ * about, so that mismatched or truncated proof files fail fast with a clear error instead of
+ * failing verification with a confusing root mismatch. The binary encoding is:
+ *
+ *   magic         ("AOMTPROF", 8 bytes)
+ *   version       (1 byte, currently 1)
+ *   paramsDigest  (32 bytes, see Tree::ParamsDigest())
+ *   fromEpoch     (8 bytes)
+ *   toEpoch       (8 bytes)
+ *   oldRoot       (32 bytes, the root hash of 'fromEpoch')
+ *   newRoot       (32 bytes, the root hash of 'toEpoch')
+ *   proofLen      (8 bytes), followed by the proof (proofLen bytes, see AppendOnlyProof)
+ *   checksum      (32 bytes, the SHA256 hash of everything above)
+ *
+ * All integers are big-endian.
+ */
+type ProofEnvelope struct {
+    ParamsDigest [32]byte
+    FromEpoch    int
+    ToEpoch      int
+    OldRoot      [32]byte
+    NewRoot      [32]byte
+    Proof        *AppendOnlyProof
+}
+
+const proofEnvelopeMagic = "AOMTPROF"
+const proofEnvelopeVersion = 1
+
+// The size of everything but the proof in the envelope's encoding
+const proofEnvelopeOverhead = 8 + 1 + 32 + 8 + 8 + 32 + 32 + 8 + 32
+
+/**
+ * Wraps the append-only proof between the two epochs of the tree.
+ */
+func NewProofEnvelope(tree *Tree, fromEpoch int, toEpoch int, proof *AppendOnlyProof) *ProofEnvelope {
+    return &ProofEnvelope{
+        ParamsDigest: tree.ParamsDigest(),
+        FromEpoch:    fromEpoch,
+        ToEpoch:      toEpoch,
+        OldRoot:      tree.GetEpochRoot(fromEpoch),
+        NewRoot:      tree.GetEpochRoot(toEpoch),
+        Proof:        proof,
+    }
+}
+
+/**
+ * Returns a digest of the tree's parameters (i.e., the # of levels and the hash function), so
+ * proofs can only be checked against trees with the same parameters. Since the hash function
+ * itself cannot be serialized, we identify it by the Merkle hash of two fixed nodes, which also
+ * depends on the tree's hashing scheme (and is the same as before schemes for plain trees).
+ */
+func (tree *Tree) ParamsDigest() [32]byte {
+    return _paramsDigest(tree.newHash, tree.rfc6962, tree.scheme, tree.numLevels)
+}
+
+/**
+ * Returns the ParamsDigest() of a tree with the specified parameters.
+ */
+func _paramsDigest(newHash func() hash.Hash, rfc6962 bool, scheme HashingScheme, numLevels int) [32]byte {
+    var left, right [32]byte
+    for i := range right {
+        right[i] = 0xff
+    }
+
+    buf := bytes.NewBufferString("aomt-params")
+    binary.Write(buf, binary.BigEndian, uint16(numLevels))
+    testHash := _schemeNodeHash(newHash, rfc6962, scheme, 1, [32]byte{31: 1}, left, right)
+    buf.Write(testHash[:])
+    return sha256.Sum256(buf.Bytes())
+}
+
+func (env *ProofEnvelope) MarshalBinary() ([]byte, error) {
+    proofBytes, err := env.Proof.MarshalBinary()
+    if err != nil {
+        return nil, err
+    }
+
+    buf := bytes.NewBuffer(make([]byte, 0, proofEnvelopeOverhead+len(proofBytes)))
+    buf.WriteString(proofEnvelopeMagic)
+    buf.WriteByte(proofEnvelopeVersion)
+    buf.Write(env.ParamsDigest[:])
+    binary.Write(buf, binary.BigEndian, uint64(env.FromEpoch))
+    binary.Write(buf, binary.BigEndian, uint64(env.ToEpoch))
+    buf.Write(env.OldRoot[:])
+    buf.Write(env.NewRoot[:])
+    binary.Write(buf, binary.BigEndian, uint64(len(proofBytes)))
+    buf.Write(proofBytes)
+
+    checksum := sha256.Sum256(buf.Bytes())
+    buf.Write(checksum[:])
+    return buf.Bytes(), nil
+}
+
+/**
+ * Decodes an envelope, checking its header, length and checksum before decoding the proof.
+ */
+func (env *ProofEnvelope) UnmarshalBinary(data []byte) error {
+    if len(data) < len(proofEnvelopeMagic) || string(data[:len(proofEnvelopeMagic)]) != proofEnvelopeMagic {
+        return errors.New("not an append-only proof envelope")
+    }
+    if len(data) < proofEnvelopeOverhead {
+        return fmt.Errorf("truncated proof envelope: got %d bytes, but the header alone takes %d", len(data), proofEnvelopeOverhead)
+    }
+    if version := data[8]; version != proofEnvelopeVersion {
+        return fmt.Errorf("unsupported proof envelope version %d (expected %d)", version, proofEnvelopeVersion)
+    }
+
+    r := bytes.NewReader(data[9:])
+    var fromEpoch, toEpoch, proofLen uint64
+    r.Read(env.ParamsDigest[:])
+    binary.Read(r, binary.BigEndian, &fromEpoch)
+    binary.Read(r, binary.BigEndian, &toEpoch)
+    r.Read(env.OldRoot[:])
+    r.Read(env.NewRoot[:])
+    binary.Read(r, binary.BigEndian, &proofLen)
+
+    if expected := uint64(proofEnvelopeOverhead) + proofLen; proofLen > uint64(len(data)) || uint64(len(data)) != expected {
+        return fmt.Errorf("truncated or padded proof envelope: got %d bytes, expected %d", len(data), expected)
+    }
+
+    body := data[:len(data)-32]
+    var checksum [32]byte
+    copy(checksum[:], data[len(data)-32:])
+    if sha256.Sum256(body) != checksum {
+        return errors.New("proof envelope checksum mismatch: the proof is corrupted")
+    }
+
+    env.FromEpoch = int(fromEpoch)
+    env.ToEpoch = int(toEpoch)
+    env.Proof = &AppendOnlyProof{}
+    if err := env.Proof.UnmarshalBinary(body[len(body)-int(proofLen):]); err != nil {
+        return fmt.Errorf("decoding proof: %v", err)
+    }
+    return nil
+}
+
+/**
+ * Checks that the envelope's proof is a valid append-only proof between its two roots, for a tree
+ * with the same parameters as 'emptyTree'.
+ */
+func (env *ProofEnvelope) Verify(emptyTree *Tree) error {
+    if env.Proof == nil {
+        return _verifyErrorf(ErrMalformedProof, "the envelope has no proof")
+    }
+    if env.ParamsDigest != emptyTree.ParamsDigest() {
+        return _verifyErrorf(ErrInvalidParams, "the proof is for a tree with different parameters (# of levels or hash function)")
+    }
+    if env.FromEpoch >= env.ToEpoch {
+        return _verifyErrorf(ErrMalformedProof, "invalid epoch range %d to %d", env.FromEpoch, env.ToEpoch)
+    }
+    // Epochs without changes have empty proofs
+    if env.Proof.NumNodes() == 0 {
+        if !hashEqual(env.OldRoot, env.NewRoot) {
+            return _verifyErrorf(ErrNewRootMismatch, "empty append-only proof, but the roots of epochs %d and %d differ", env.FromEpoch, env.ToEpoch)
+        }
+        return nil
+    }
+    // Wrapped, so that the error is still classified (see VerifyError)
+    if err := env.Proof.VerifyWithOptions(env.OldRoot, env.NewRoot, emptyTree.Options()); err != nil {
+        return fmt.Errorf("invalid append-only proof from epoch %d (root %s) to epoch %d (root %s): %w",
+            env.FromEpoch, hashStr(env.OldRoot), env.ToEpoch, hashStr(env.NewRoot), err)
+    }
+    return nil
+}
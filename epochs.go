@@ -0,0 +1,591 @@
+package aomt
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "./log"
+)
+
+/**
+ * A leaf change (i.e., an Insert() or an Update()) that happened during an epoch.
+ */
+type epochLeaf struct {
+    leafNo   [32]byte
+    dataHash [32]byte
+    isUpdate bool // true if this was an Update() rather than an Insert()
+}
+
+/**
+ * An epoch is recorded after every batch of changes. We keep the root hash at the end of the
+ * epoch and the leaf changes made during it, so that we can later rebuild the tree as of any
+ * epoch (i.e., its frontier) and compute append-only proofs between arbitrary epochs.
+ */
+type epochRecord struct {
+    root      [32]byte
+    changes   []epochLeaf
+    timestamp int64    // when the epoch was recorded, in Unix nanoseconds
+    header    [32]byte // the hash of the epoch's header, see EpochHeader
+}
+
+/**
+ * Records a new epoch consisting of all leaf changes since the previous epoch, with the current
+ * root hash as the epoch's root and a header linking it to the previous epoch (see EpochHeader),
+ * and pushes its head to subscribers (see SubscribeRoots()). Returns the number of the new epoch,
+ * starting from 0.
+ */
+func (tree *Tree) RecordEpoch() int {
+    return tree._recordEpochAt(time.Now())
+}
+
+/**
+ * Like RecordEpoch(), but with the specified timestamp in the epoch's header (e.g., the logged
+ * one, when replaying a WAL).
+ */
+func (tree *Tree) _recordEpochAt(now time.Time) int {
+    epoch := &epochRecord{root: tree.GetRootHash(), changes: tree.pending}
+    tree._linkEpochHeader(epoch, now)
+    tree.epochs = append(tree.epochs, epoch)
+    tree.rootLog.Append(epoch.root)
+    tree._indexInsertions(len(tree.epochs)-1, epoch.changes)
+    tree.metrics._observeEpoch(tree.pending)
+    tree.pending = nil
+    tree._notifyRoots(len(tree.epochs) - 1)
+
+    return len(tree.epochs) - 1
+}
+
+/**
+ * Returns the log of the tree's epoch roots, see package log.
+ */
+func (tree *Tree) RootLog() *log.Log {
+    return tree.rootLog
+}
+
+/**
+ * Returns the number of recorded epochs.
+ */
+func (tree *Tree) NumEpochs() int {
+    return len(tree.epochs)
+}
+
+/**
+ * Records 'epoch' as the insertion epoch of the leaves first inserted in it, if the tree indexes
+ * insertion epochs (see TreeOptions::InsertionEpochs).
+ */
+func (tree *Tree) _indexInsertions(epoch int, changes []epochLeaf) {
+    if tree.insertedIn == nil {
+        return
+    }
+    for _, change := range changes {
+        if change.isUpdate {
+            continue
+        }
+        if _, ok := tree.insertedIn[change.leafNo]; !ok {
+            tree.insertedIn[change.leafNo] = epoch
+        }
+    }
+}
+
+/**
+ * Returns the epoch the specified leaf was first inserted in (i.e., the first epoch whose tree
+ * has it) and true, or false if the leaf does not exist or the tree was created without
+ * TreeOptions::InsertionEpochs. Updates do not change it. Leaves inserted since the last epoch
+ * are in the next one, i.e., epoch NumEpochs().
+ */
+func (tree *Tree) GetInsertionEpoch(leafNo [32]byte) (int, bool) {
+    if tree.insertedIn == nil {
+        return 0, false
+    }
+    if epoch, ok := tree.insertedIn[leafNo]; ok {
+        return epoch, true
+    }
+    if _, ok := tree.Get(leafNo); ok {
+        return len(tree.epochs), true
+    }
+    return 0, false
+}
+
+/**
+ * Returns the root hash of the tree at the end of the specified epoch.
+ */
+func (tree *Tree) GetEpochRoot(epoch int) [32]byte {
+    if epoch < 0 || epoch >= len(tree.epochs) {
+        panic(fmt.Sprintf("Epoch %d was never recorded (have %d epochs)", epoch, len(tree.epochs)))
+    }
+
+    return tree.epochs[epoch].root
+}
+
+/**
+ * Computes an append-only proof between the tree at the end of epoch 'fromEpoch' and the tree at
+ * the end of epoch 'toEpoch', so an auditor who last saw 'fromEpoch' can catch up with a single proof.
+ * The proof can be checked via VerifyAppendOnlyProof() against the two epochs' root hashes.
+ *
+ * NOTE: The tree only stores the latest hashes, so we rebuild the part of the tree as of
+ * 'fromEpoch' that the changes made since touch (see _frontierAt()) and then replay the epochs up
+ * to 'toEpoch' on it while building the proof. This costs O(# of leaf changes since 'fromEpoch'),
+ * rather than O(# of leaves).
+ */
+func (tree *Tree) ProveAppendOnly(fromEpoch int, toEpoch int) *Tree {
+    if fromEpoch < 0 || toEpoch >= len(tree.epochs) || fromEpoch >= toEpoch {
+        panic(fmt.Sprintf("Cannot prove epoch %d is a prefix of epoch %d (have %d epochs)",
+            fromEpoch, toEpoch, len(tree.epochs)))
+    }
+
+    replay := tree._frontierAt(fromEpoch, nil)
+
+    proofTree := tree.NewEmptyTree()
+    for e := fromEpoch + 1; e <= toEpoch; e++ {
+        replay._replayEpoch(tree.epochs[e], proofTree)
+    }
+    if replay.GetRootHash() != tree.epochs[toEpoch].root {
+        panic(fmt.Sprintf("Something's off: replayed root does not match epoch %d root", toEpoch))
+    }
+
+    proofTree._compressProofTree()
+    return proofTree
+}
+
+/**
+ * Returns a new tree with the same leaves as this tree had at the end of the specified epoch, by
+ * replaying the recorded leaf changes. The returned tree has no recorded epochs.
+ *
+ * NOTE: This replays every leaf change up to 'epoch', so proofs about past epochs should rather
+ * use ProveAppendOnly() and ProveMembershipAtEpoch(), which only rebuild what changed since.
+ */
+func (tree *Tree) TreeAtEpoch(epoch int) *Tree {
+    if epoch < 0 || epoch >= len(tree.epochs) {
+        panic(fmt.Sprintf("Epoch %d was never recorded (have %d epochs)", epoch, len(tree.epochs)))
+    }
+
+    replay := tree.NewEmptyTree()
+    for e := 0; e <= epoch; e++ {
+        replay._replayEpoch(tree.epochs[e], nil)
+    }
+    if replay.GetRootHash() != tree.epochs[epoch].root {
+        panic(fmt.Sprintf("Something's off: replayed root does not match epoch %d root", epoch))
+    }
+
+    return replay
+}
+
+/**
+ * Returns a partial tree with the nodes this tree had at the end of the specified epoch that are
+ * on the paths of the leaves changed since (including the pending changes) and of the 'extra'
+ * leaves, and their siblings. The subtrees of the siblings did not change since, so their hashes
+ * are the current ones. Replaying the changes made after 'epoch' on the partial tree (or proving
+ * the membership of an 'extra' leaf) thus gives the same hashes as on a full replay (see
+ * TreeAtEpoch()), but only costs O(# of changed leaves + # of extra leaves).
+ */
+func (tree *Tree) _frontierAt(epoch int, extra [][32]byte) *Tree {
+    // Leaves whose first change since 'epoch' is an update already existed, with the hash they
+    // had before all their updates since
+    numUpdates := make(map[[32]byte]int)
+    isInserted := make(map[[32]byte]bool)
+    countChanges := func(changes []epochLeaf) {
+        for _, change := range changes {
+            if _, ok := numUpdates[change.leafNo]; !ok {
+                numUpdates[change.leafNo] = 0
+                isInserted[change.leafNo] = !change.isUpdate
+            }
+            if change.isUpdate {
+                numUpdates[change.leafNo]++
+            }
+        }
+    }
+    for _, record := range tree.epochs[epoch+1:] {
+        countChanges(record.changes)
+    }
+    countChanges(tree.pending)
+
+    getNode := func(level int, nodeNo [32]byte) *Node {
+        tree._restore(level, nodeNo)
+        return tree.getNode(tree.lvl[level], nodeNo)
+    }
+
+    lastLevel := tree.numLevels - 1
+    frontier := tree.NewEmptyTree()
+    pathNos := make([][32]byte, 0, len(numUpdates)+len(extra))
+    for leafNo := range numUpdates {
+        pathNos = append(pathNos, leafNo)
+    }
+    for _, leafNo := range extra {
+        if _, ok := numUpdates[leafNo]; !ok {
+            pathNos = append(pathNos, leafNo)
+        }
+    }
+    _sortHashes(pathNos)
+
+    leafLvl := frontier.lvl[lastLevel]
+    for i, leafNo := range pathNos {
+        if i > 0 && pathNos[i-1] == leafNo {
+            continue
+        }
+        var leafHash [32]byte
+        if n, ok := numUpdates[leafNo]; !ok {
+            leaf := getNode(lastLevel, leafNo)
+            if leaf == nil {
+                continue
+            }
+            leafHash = leaf.Hash
+        } else if isInserted[leafNo] {
+            continue
+        } else {
+            versions := tree.history[leafNo]
+            leafHash = versions[0]
+            for _, dataHash := range versions[1 : len(versions)-n] {
+                leafHash = tree._merkleHash(leafHash, dataHash)
+            }
+        }
+        leaf := leafLvl.newNode()
+        leaf.Hash = leafHash
+        frontier._putNode(leafLvl, leafNo, leaf)
+    }
+
+    for level := lastLevel; level > 0; level-- {
+        lvl, parentLvl := frontier.lvl[level], frontier.lvl[level-1]
+        onPath := make(map[[32]byte]bool, len(pathNos))
+        for _, nodeNo := range pathNos {
+            onPath[nodeNo] = true
+        }
+        for _, nodeNo := range pathNos {
+            siblingNo, _ := siblingOf(nodeNo)
+            if onPath[siblingNo] {
+                continue
+            }
+            if sibling := getNode(level, siblingNo); sibling != nil {
+                node := lvl.newNode()
+                node.Hash = sibling.Hash
+                frontier._putNode(lvl, siblingNo, node)
+            }
+        }
+
+        parents := _distinctParents(pathNos)
+        for _, parentNo := range parents {
+            leftNo, rightNo := childrenOf(parentNo)
+            left, right := lvl.node[leftNo], lvl.node[rightNo]
+            if left == nil && right == nil {
+                continue
+            }
+
+            leftHash, rightHash := tree.EmptyHash, tree.EmptyHash
+            if left != nil {
+                leftHash = left.Hash
+            }
+            if right != nil {
+                rightHash = right.Hash
+            }
+            parent := parentLvl.newNode()
+            parent.Hash = tree._nodeHashAt(level-1, parentNo, leftHash, rightHash)
+            frontier._putNode(parentLvl, parentNo, parent)
+        }
+        pathNos = parents
+    }
+
+    return frontier
+}
+
+/**
+ * Returns the # of leaf changes since the last recorded epoch.
+ */
+func (tree *Tree) NumPendingChanges() int {
+    return len(tree.pending)
+}
+
+/**
+ * Re-applies the leaf changes of a recorded epoch to this tree, adding them to 'proofTree' if not nil.
+ */
+func (tree *Tree) _replayEpoch(epoch *epochRecord, proofTree *Tree) {
+    for _, change := range epoch.changes {
+        if change.isUpdate {
+            tree.Update(change.leafNo, change.dataHash, proofTree)
+        } else {
+            // The recorded data hashes of leaves with extensions already commit to them
+            tree._insert(change.leafNo, change.dataHash, proofTree)
+        }
+    }
+}
+
+/**
+ * A batch of leaf changes that is committed atomically as a new epoch, together with the
+ * append-only proof from the previous epoch:
+ *
+ *   epoch := tree.BeginEpoch()
+ *   epoch.Insert(leafNo, dataHash)
+ *   ...
+ *   proof, root := epoch.Commit()
+ *
+ * The tree must not be changed directly (i.e., via Tree::Insert() or Tree::Update()) while an
+ * epoch is open.
+ */
+type Epoch struct {
+    tree      *Tree
+    proofTree *Tree
+    oldRoot   [32]byte
+    leaves    [][32]byte // the leaves changed in this epoch, whose 'new' flags are cleared on Commit()
+    committed bool
+
+    // Once committed, the # of the recorded epoch and its append-only proof, see Delta()
+    number int
+    proof  *AppendOnlyProof
+
+    // The time spent adding the changes to the proof tree and compressing it, for benchmarks
+    proofBuildTime    time.Duration
+    proofCompressTime time.Duration
+    updatedOldLeaves  bool // true if leaves from previous epochs were updated, see _rebuildProofTree()
+
+    // The most nodes the proof tree had, and how often it was compressed before the epoch was
+    // committed, see TreeOptions::ProofTreeMaxNodes
+    proofTreePeakNodes     int64
+    numProofCompressions   int
+    nextProofCompressNodes int64 // the size at which the proof tree is compressed next
+}
+
+var errEpochCommitted = errors.New("epoch was already committed")
+
+/**
+ * Starts a new epoch. There can only be one open epoch at a time, and all changes since the last
+ * recorded epoch must have been recorded.
+ */
+func (tree *Tree) BeginEpoch() *Epoch {
+    if tree.open != nil {
+        panic("Cannot begin an epoch while another one is open")
+    }
+    if len(tree.pending) > 0 {
+        panic(fmt.Sprintf("Cannot begin an epoch with %d unrecorded changes", len(tree.pending)))
+    }
+
+    tree.open = &Epoch{tree: tree, proofTree: tree.NewEmptyTree(), oldRoot: tree.GetRootHash()}
+    return tree.open
+}
+
+/**
+ * Inserts a new leaf in the epoch (see Tree::Insert()). Returns an error, and records the
+ * rejection, if the leaf cannot be inserted (see Tree::TryInsert()).
+ */
+func (epoch *Epoch) Insert(leafNo [32]byte, dataHash [32]byte) error {
+    if err := epoch.tree._checkExtensionsNotRequired("insert", leafNo, dataHash); err != nil {
+        return err
+    }
+    return epoch._insert(leafNo, dataHash)
+}
+
+/**
+ * Inserts the leaf like Insert(), whether or not the tree's leaves must have extensions.
+ */
+func (epoch *Epoch) _insert(leafNo [32]byte, dataHash [32]byte) error {
+    if epoch.committed {
+        return errEpochCommitted
+    }
+    if err := epoch.tree._checkInsertable("insert", leafNo, dataHash); err != nil {
+        return err
+    }
+
+    epoch.tree._insertLeaf(leafNo, dataHash, true)
+    epoch.leaves = append(epoch.leaves, leafNo)
+
+    startTime := time.Now()
+    epoch.tree._proofAddInserted(leafNo, epoch.proofTree)
+    epoch._limitProofTree()
+    epoch.proofBuildTime += time.Since(startTime)
+    return nil
+}
+
+/**
+ * Updates an existing leaf in the epoch (see Tree::Update()). Returns an error, and records the
+ * rejection, if the leaf is not in the tree.
+ */
+func (epoch *Epoch) Update(leafNo [32]byte, newDataHash [32]byte) error {
+    if epoch.committed {
+        return errEpochCommitted
+    }
+    if err := epoch.tree._checkUpdatable("update", leafNo, newDataHash); err != nil {
+        return err
+    }
+
+    prevLeafHash, wasNew := epoch.tree._updateLeaf(leafNo, newDataHash)
+    epoch.leaves = append(epoch.leaves, leafNo)
+    epoch.updatedOldLeaves = epoch.updatedOldLeaves || !wasNew
+
+    startTime := time.Now()
+    epoch.tree._proofAddUpdated(leafNo, prevLeafHash, wasNew, newDataHash, epoch.proofTree)
+    epoch._limitProofTree()
+    epoch.proofBuildTime += time.Since(startTime)
+    return nil
+}
+
+/**
+ * Commits the epoch: computes the append-only proof from the previous epoch (and picks its
+ * smallest encoding, see ProofForm), clears the 'new' flags, and records the epoch. Returns the
+ * proof and the epoch's root hash. The epoch cannot be changed afterwards.
+ */
+func (epoch *Epoch) Commit() (*AppendOnlyProof, [32]byte) {
+    return epoch._commit(true)
+}
+
+/**
+ * Like Commit(), but the proof is only compressed if 'compress' is true, so that benchmarks can
+ * leave out the cost of compressing it.
+ *
+ * NOTE: Uncompressed proofs have redundant siblings, which verifiers reject.
+ */
+func (epoch *Epoch) _commit(compress bool) (*AppendOnlyProof, [32]byte) {
+    if epoch.committed {
+        panic(errEpochCommitted.Error())
+    }
+    tree := epoch.tree
+
+    epoch._observeProofTreeSize(epoch.proofTree.GetNumNodes())
+
+    startTime := time.Now()
+    var proof *AppendOnlyProof
+    if compress {
+        proof = _compressAndFlatten(epoch.proofTree)
+    } else {
+        proof = NewAppendOnlyProof(epoch.proofTree)
+    }
+    proof.Form = proof.ChooseForm()
+    epoch.proofCompressTime = time.Since(startTime)
+    tree.metrics._observeProof(proof)
+
+    // Only the epoch's nodes are marked as 'new', so there's no need to walk the whole tree
+    tree._clearNewNodes()
+    epoch.number = tree.RecordEpoch()
+    epoch.proof = proof
+    tree._archiveProof(epoch.number, proof)
+
+    epoch.committed = true
+    tree.open = nil
+    return proof, tree.GetRootHash()
+}
+
+/**
+ * Closes an epoch without changes (e.g., whose inserts were all rejected) without recording it, so
+ * another one can begin.
+ */
+func (epoch *Epoch) _discard() {
+    if epoch.committed || len(epoch.leaves) > 0 {
+        panic("Cannot discard an epoch that was committed or has changes")
+    }
+    epoch.committed = true
+    epoch.tree.open = nil
+}
+
+/**
+ * Returns the root hash of the tree before the epoch.
+ */
+func (epoch *Epoch) OldRoot() [32]byte {
+    return epoch.oldRoot
+}
+
+/**
+ * Returns the # of leaf changes in the epoch.
+ */
+func (epoch *Epoch) NumChanges() int {
+    return len(epoch.leaves)
+}
+
+/**
+ * Returns the time spent building the epoch's append-only proof so far, as part of Insert() and
+ * Update(). Does not include the time to compress the proof, see ProofCompressTime().
+ */
+func (epoch *Epoch) ProofBuildTime() time.Duration {
+    return epoch.proofBuildTime
+}
+
+/**
+ * Returns the time spent compressing and flattening the epoch's append-only proof in Commit(), or
+ * 0 if the epoch was not committed yet.
+ */
+func (epoch *Epoch) ProofCompressTime() time.Duration {
+    return epoch.proofCompressTime
+}
+
+/**
+ * Returns the most nodes the epoch's proof tree had while it was built, before it was compressed,
+ * or 0 if the epoch was not committed yet.
+ */
+func (epoch *Epoch) ProofTreePeakNodes() int64 {
+    return epoch.proofTreePeakNodes
+}
+
+/**
+ * Returns how many times the epoch's proof tree was compressed before it was committed, because it
+ * grew beyond TreeOptions::ProofTreeMaxNodes.
+ */
+func (epoch *Epoch) NumProofCompressions() int {
+    return epoch.numProofCompressions
+}
+
+/**
+ * Compresses the proof tree if it grew beyond TreeOptions::ProofTreeMaxNodes. Compressing only
+ * removes the ancestors of the proof's nodes, which the final compression in Commit() would remove
+ * anyway, and nodes removed early are added again (with their latest hash) if a later leaf needs
+ * them, so the proof is the same as if it was only compressed on Commit().
+ *
+ * The next compression happens when the tree has twice as many nodes as are left after this one,
+ * so that epochs whose compressed proofs alone exceed the limit are not compressed on every leaf.
+ */
+func (epoch *Epoch) _limitProofTree() {
+    maxNodes := int64(epoch.tree.proofTreeMaxNodes)
+    if maxNodes == 0 {
+        return
+    }
+
+    numNodes := epoch.proofTree.GetNumNodes()
+    if numNodes <= maxNodes || numNodes < epoch.nextProofCompressNodes {
+        return
+    }
+
+    epoch._observeProofTreeSize(numNodes)
+    epoch.proofTree._compressProofTree()
+    epoch.numProofCompressions++
+    epoch.nextProofCompressNodes = 2 * epoch.proofTree.GetNumNodes()
+}
+
+func (epoch *Epoch) _observeProofTreeSize(numNodes int64) {
+    if numNodes > epoch.proofTreePeakNodes {
+        epoch.proofTreePeakNodes = numNodes
+    }
+}
+
+/**
+ * Compresses the proof tree and flattens it into an AppendOnlyProof.
+ */
+func _compressAndFlatten(proofTree *Tree) *AppendOnlyProof {
+    // An empty epoch has an empty proof, which _compressProofTree() does not expect
+    if proofTree.GetNumNodes() > 0 {
+        proofTree._compressProofTree()
+    }
+    return NewAppendOnlyProof(proofTree)
+}
+
+/**
+ * Builds the epoch's (uncompressed) proof tree again from scratch, in a new tree, so benchmarks
+ * can time proof building repeatedly. Since all hashes are final, every changed leaf is added once,
+ * like in Tree::InsertBatchParallel(). This only works if the epoch did not update any leaves from
+ * previous epochs, whose previous hashes we no longer have.
+ */
+func (epoch *Epoch) _rebuildProofTree() *Tree {
+    if epoch.committed {
+        panic(errEpochCommitted.Error())
+    }
+    if epoch.updatedOldLeaves {
+        panic("Cannot rebuild the proof of an epoch that updated leaves from previous epochs")
+    }
+
+    // Leaves updated after being inserted in the epoch are listed more than once
+    proofTree := epoch.tree.NewEmptyTree()
+    isAdded := make(map[[32]byte]bool, len(epoch.leaves))
+    for _, leafNo := range epoch.leaves {
+        if isAdded[leafNo] {
+            epoch.tree._proofAdd(leafNo, proofTree)
+        } else {
+            epoch.tree._proofAddInserted(leafNo, proofTree)
+            isAdded[leafNo] = true
+        }
+    }
+    return proofTree
+}
@@ -0,0 +1,214 @@
+package aomt
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+    "time"
+)
+
+/**
+ * Builds a tree with 'numEpochs' epochs of inserts and updates, and some pending changes.
+ */
+func _testEpochTree(numEpochs int) *Tree {
+    tree := NewTree(257)
+    next := 0
+    for e := 0; e < numEpochs; e++ {
+        for i := 0; i < 20; i++ {
+            leaf := _testLeaf(next)
+            tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+            next++
+        }
+        // Update some leaves of this epoch and of the previous ones, some of them several times
+        for i := 0; i < next; i += 7 {
+            tree.Update(_testLeaf(i).LeafNo, _testLeaf(i+e).DataHash, nil)
+        }
+        tree.RecordEpoch()
+    }
+    leaf := _testLeaf(next)
+    tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    tree.Update(_testLeaf(0).LeafNo, leaf.DataHash, nil)
+    return tree
+}
+
+/**
+ * Append-only proofs between any two epochs must verify against their roots, and only theirs.
+ */
+func TestProveAppendOnlyBetweenEpochs(t *testing.T) {
+    tree := _testEpochTree(5)
+    for from := 0; from < tree.NumEpochs(); from++ {
+        for to := from + 1; to < tree.NumEpochs(); to++ {
+            proof := tree.ProveAppendOnly(from, to)
+            if !VerifyAppendOnlyProof(proof, tree.GetEpochRoot(from), tree.GetEpochRoot(to)) {
+                t.Errorf("proof from epoch %d to %d does not verify", from, to)
+            }
+            if VerifyAppendOnlyProof(proof, tree.GetEpochRoot(to), tree.GetEpochRoot(from)) {
+                t.Errorf("proof from epoch %d to %d verifies with the roots swapped", from, to)
+            }
+            if to+1 < tree.NumEpochs() && VerifyAppendOnlyProof(proof, tree.GetEpochRoot(from), tree.GetEpochRoot(to+1)) {
+                t.Errorf("proof from epoch %d to %d verifies up to epoch %d", from, to, to+1)
+            }
+        }
+    }
+}
+
+/**
+ * Computes the append-only proof on a full replay of the tree, as ProveAppendOnly() used to.
+ */
+func _replayAppendOnlyProof(tree *Tree, fromEpoch int, toEpoch int) *AppendOnlyProof {
+    replay := tree.TreeAtEpoch(fromEpoch)
+    proofTree := tree.NewEmptyTree()
+    for e := fromEpoch + 1; e <= toEpoch; e++ {
+        replay._replayEpoch(tree.epochs[e], proofTree)
+    }
+    proofTree._compressProofTree()
+    return NewAppendOnlyProof(proofTree)
+}
+
+func TestProveAppendOnlyMatchesReplay(t *testing.T) {
+    tree := _testEpochTree(5)
+    pruned := _testEpochTree(5)
+    if _, err := pruned.Prune(3); err != nil {
+        t.Fatal(err)
+    }
+
+    for from := 0; from < tree.NumEpochs(); from++ {
+        for to := from + 1; to < tree.NumEpochs(); to++ {
+            expected := _replayAppendOnlyProof(tree, from, to)
+            for _, tr := range []*Tree{tree, pruned} {
+                proofTree := tr.ProveAppendOnly(from, to)
+                if !VerifyAppendOnlyProof(proofTree, tr.GetEpochRoot(from), tr.GetEpochRoot(to)) {
+                    t.Fatalf("proof from epoch %d to %d does not verify", from, to)
+                }
+                if proof := NewAppendOnlyProof(proofTree); !reflect.DeepEqual(proof, expected) {
+                    t.Fatalf("proof from epoch %d to %d differs from the one on a full replay", from, to)
+                }
+            }
+        }
+    }
+}
+
+func TestProveMembershipAtEpochMatchesReplay(t *testing.T) {
+    tree := _testEpochTree(4)
+    for epoch := 0; epoch < tree.NumEpochs(); epoch++ {
+        replay := tree.TreeAtEpoch(epoch)
+        root := tree.GetEpochRoot(epoch)
+
+        // Leaves of every epoch, updated or not, and leaves that are not in the tree at all
+        for i := 0; i < 100; i += 3 {
+            leafNo := _testLeaf(i).LeafNo
+            proof := tree.ProveMembershipAtEpoch(leafNo, epoch)
+            if !VerifyMembershipProof(proof, root) {
+                t.Fatalf("proof of leaf %d as of epoch %d does not verify", i, epoch)
+            }
+            if expected := replay.ProveMembership(leafNo); !reflect.DeepEqual(proof, expected) {
+                t.Fatalf("proof of leaf %d as of epoch %d differs from the one on a full replay", i, epoch)
+            }
+        }
+    }
+}
+
+/**
+ * Updating a leaf that is not in the tree must be rejected, not panic, and must leave the epoch
+ * usable.
+ */
+func TestEpochUpdateOfMissingLeaf(t *testing.T) {
+    tree := NewTree(257)
+    leaf := _testLeaf(0)
+    tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    tree.RecordEpoch()
+
+    epoch := tree.BeginEpoch()
+    if err := epoch.Update(_testLeaf(1).LeafNo, leaf.DataHash); err == nil {
+        t.Fatal("update of a missing leaf was not rejected")
+    }
+    var tooLarge [32]byte
+    tooLarge[0] = 0xff
+    small, err := NewTreeWithOptions(16, TreeOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    small.Insert([32]byte{}, leaf.DataHash, nil)
+    small.RecordEpoch()
+    if err := small.BeginEpoch().Update(tooLarge, leaf.DataHash); err == nil {
+        t.Fatal("update of a leaf no that does not fit in the tree was not rejected")
+    }
+
+    rejections := tree.Rejections(time.Time{})
+    if len(rejections) != 1 || rejections[0].Kind != RejectMissingLeaf || rejections[0].Op != "update" {
+        t.Fatalf("the tree recorded rejections %+v, expected a single missing-leaf update", rejections)
+    }
+
+    if err := epoch.Update(leaf.LeafNo, _testLeaf(2).DataHash); err != nil {
+        t.Fatal(err)
+    }
+    proof, root := epoch.Commit()
+    if err := proof.Verify(tree.GetEpochRoot(0), root); err != nil {
+        t.Fatalf("the epoch's proof does not verify: %v", err)
+    }
+}
+
+/**
+ * Trees whose leaves must have extensions must reject inserts of leaves without them, whichever
+ * way they are inserted, and still accept leaves with extensions.
+ */
+func TestInsertWithoutExtensionsIsRejected(t *testing.T) {
+    tree, err := NewTreeWithOptions(257, TreeOptions{LeafExtensions: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    first := _testLeaf(1)
+    tree.InsertWithExtensions(first.LeafNo, first.DataHash, LeafExtensions{Timestamp: 1}, nil)
+    tree.RecordEpoch()
+    leaf := _testLeaf(0)
+
+    panics := map[string]func(){
+        "Insert()":              func() { tree.Insert(leaf.LeafNo, leaf.DataHash, nil) },
+        "InsertValue()":         func() { tree.InsertValue(leaf.LeafNo, []byte("value"), nil) },
+        "InsertCommitted()":     func() { tree.InsertCommitted(leaf.LeafNo, []byte("value"), nil) },
+        "InsertBatchParallel()": func() { tree.InsertBatchParallel([]LeafData{leaf}, 4, nil) },
+    }
+    for name, insert := range panics {
+        func() {
+            defer func() {
+                if recover() == nil {
+                    t.Errorf("%s of a leaf without extensions does not panic", name)
+                }
+            }()
+            insert()
+        }()
+    }
+    if err := tree.TryInsert(leaf.LeafNo, leaf.DataHash, nil); err == nil {
+        t.Errorf("TryInsert() of a leaf without extensions was not rejected")
+    }
+
+    epoch := tree.BeginEpoch()
+    if err := epoch.Insert(leaf.LeafNo, leaf.DataHash); err == nil {
+        t.Errorf("Epoch::Insert() of a leaf without extensions was not rejected")
+    }
+    if err := epoch.InsertValue(leaf.LeafNo, []byte("value")); err == nil {
+        t.Errorf("Epoch::InsertValue() of a leaf without extensions was not rejected")
+    }
+    if _, err := epoch.InsertCommitted(leaf.LeafNo, []byte("value")); err == nil {
+        t.Errorf("Epoch::InsertCommitted() of a leaf without extensions was not rejected")
+    }
+    for _, r := range tree.Rejections(time.Time{}) {
+        if r.Kind != RejectMissingExtensions {
+            t.Errorf("the tree recorded a rejection of kind %s, expected %s", r.Kind, RejectMissingExtensions)
+        }
+    }
+    if _, err := BuildFromLeaves(257, TreeOptions{LeafExtensions: true}, NewLeafFileReader(bytes.NewReader(nil))); err == nil {
+        t.Errorf("building a tree whose leaves must have extensions from a leaf stream succeeds")
+    }
+
+    if err := epoch.InsertWithExtensions(leaf.LeafNo, leaf.DataHash, LeafExtensions{Timestamp: 2}); err != nil {
+        t.Fatal(err)
+    }
+    proof, root := epoch.Commit()
+    if err := proof.Verify(tree.GetEpochRoot(0), root); err != nil {
+        t.Errorf("the epoch's proof does not verify: %v", err)
+    }
+    if proof := tree.ProveMembership(leaf.LeafNo); !VerifyMembershipProofWithOptions(proof, root, tree.Options()) {
+        t.Errorf("proof of the leaf with extensions does not verify")
+    }
+}
@@ -0,0 +1,206 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "fmt"
+    "hash"
+)
+
+/**
+ * Leaf extensions let deployments commit to metadata next to a leaf's data hash (e.g., when it was
+ * inserted, when it expires, or the policy it is subject to), so that proofs bind the metadata as
+ * tightly as the data: in trees created with TreeOptions::LeafExtensions, leaves inserted via
+ * Tree::InsertWithExtensions() have the data hash
+ *
+ *   H(tag || leafNo || dataHash || timestamp || expiry || len(policy) || policy)
+ *
+ * where H is the tree's hash function and the integers are 8 bytes, big-endian. In trees created
+ * with TreeOptions::InsertionEpochs, leaves also commit to the epoch they were inserted in (see
+ * LeafExtensions::Epoch), and have the data hash
+ *
+ *   H(epochTag || leafNo || dataHash || timestamp || expiry || epoch || len(policy) || policy)
+ *
+ * instead. The tree keeps each leaf's data hash and extensions (see ExtendedLeaf) and includes them
+ * in the leaf's membership proofs, and verifiers with TreeOptions::LeafExtensions set reject
+ * membership proofs of leaves without them, so such trees only take leaves with extensions: plain
+ * inserts (including InsertValue() and InsertCommitted()) panic, or are rejected by TryInsert()
+ * and Epoch::Insert(). Trees created without the flag hash leaves as they always did.
+ *
+ * NOTE: Updating a leaf via Tree::Update() drops its extensions, since the leaf hash then commits
+ * to a version chain (see Tree::Update()) rather than to a single data hash. Extensions are not
+ * stored in snapshots either, as with values (see ValueHash()).
+ */
+
+// The domain separation tags of extended data hashes without and with an epoch, see ExtendedLeafHash()
+var leafExtensionsTag = []byte("aomt-leaf-ext")
+var leafExtensionsEpochTag = []byte("aomt-leaf-ext-epoch")
+
+/**
+ * The metadata a leaf commits to, besides its data hash. What the fields mean is up to the
+ * application: this code only hashes them.
+ */
+type LeafExtensions struct {
+    Timestamp uint64 // e.g., the Unix time the leaf was inserted at, 0 if none
+    Expiry    uint64 // e.g., the Unix time the leaf expires at, 0 if never
+    Policy    []byte // application-defined policy bytes, nil if none
+
+    // The epoch the leaf was inserted in, or nil if the leaf does not commit to it. Set by trees
+    // created with TreeOptions::InsertionEpochs, overriding the caller's.
+    Epoch *uint64
+}
+
+/**
+ * A leaf's data hash and extensions, i.e., what its extended data hash commits to.
+ */
+type ExtendedLeaf struct {
+    DataHash   [32]byte
+    Extensions LeafExtensions
+}
+
+/**
+ * Returns the data hash of leaf 'leafNo' with data hash 'dataHash' and the specified extensions.
+ */
+func ExtendedLeafHash(newHash func() hash.Hash, leafNo [32]byte, dataHash [32]byte, ext LeafExtensions) [32]byte {
+    if newHash == nil {
+        newHash = sha256.New
+    }
+
+    if ext.Epoch != nil {
+        var ints [32]byte
+        binary.BigEndian.PutUint64(ints[0:], ext.Timestamp)
+        binary.BigEndian.PutUint64(ints[8:], ext.Expiry)
+        binary.BigEndian.PutUint64(ints[16:], *ext.Epoch)
+        binary.BigEndian.PutUint64(ints[24:], uint64(len(ext.Policy)))
+        return _hashWith(newHash, leafExtensionsEpochTag, leafNo[:], dataHash[:], ints[:], ext.Policy)
+    }
+
+    var ints [24]byte
+    binary.BigEndian.PutUint64(ints[0:], ext.Timestamp)
+    binary.BigEndian.PutUint64(ints[8:], ext.Expiry)
+    binary.BigEndian.PutUint64(ints[16:], uint64(len(ext.Policy)))
+    return _hashWith(newHash, leafExtensionsTag, leafNo[:], dataHash[:], ints[:], ext.Policy)
+}
+
+/**
+ * Returns the data hash the leaf is stored with, see ExtendedLeafHash().
+ */
+func (leaf *ExtendedLeaf) Hash(newHash func() hash.Hash, leafNo [32]byte) [32]byte {
+    return ExtendedLeafHash(newHash, leafNo, leaf.DataHash, leaf.Extensions)
+}
+
+func _newExtendedLeaf(dataHash [32]byte, ext LeafExtensions) *ExtendedLeaf {
+    ext.Policy = append([]byte(nil), ext.Policy...)
+    if ext.Epoch != nil {
+        epoch := *ext.Epoch
+        ext.Epoch = &epoch
+    }
+    return &ExtendedLeaf{DataHash: dataHash, Extensions: ext}
+}
+
+/**
+ * Returns the extensions a new leaf is inserted with: 'ext', along with the epoch the leaf will
+ * be in if the tree indexes insertion epochs.
+ */
+func (tree *Tree) _withInsertionEpoch(ext LeafExtensions) LeafExtensions {
+    if tree.insertedIn == nil {
+        return ext
+    }
+    epoch := uint64(len(tree.epochs))
+    ext.Epoch = &epoch
+    return ext
+}
+
+/**
+ * Inserts a new leaf with the specified data hash and extensions (see Tree::Insert()). Panics if
+ * the tree was not created with TreeOptions::LeafExtensions.
+ */
+func (tree *Tree) InsertWithExtensions(leafNo [32]byte, dataHash [32]byte, ext LeafExtensions, proofTree *Tree) {
+    if !tree.leafExtensions {
+        panic("Cannot insert leaf extensions in a tree created without TreeOptions::LeafExtensions")
+    }
+
+    leaf := _newExtendedLeaf(dataHash, tree._withInsertionEpoch(ext))
+    tree._insert(leafNo, leaf.Hash(tree.newHash, leafNo), proofTree)
+    tree.extended[leafNo] = leaf
+}
+
+/**
+ * Inserts a new leaf with the specified data hash and extensions in the epoch (see
+ * Tree::InsertWithExtensions()).
+ */
+func (epoch *Epoch) InsertWithExtensions(leafNo [32]byte, dataHash [32]byte, ext LeafExtensions) error {
+    if !epoch.tree.leafExtensions {
+        return fmt.Errorf("the tree was created without TreeOptions::LeafExtensions")
+    }
+
+    leaf := _newExtendedLeaf(dataHash, epoch.tree._withInsertionEpoch(ext))
+    if err := epoch._insert(leafNo, leaf.Hash(epoch.tree.newHash, leafNo)); err != nil {
+        return err
+    }
+    epoch.tree.extended[leafNo] = leaf
+    return nil
+}
+
+/**
+ * Returns the data hash and extensions of the specified leaf and true, or nil and false if the
+ * leaf was not inserted via InsertWithExtensions() (or was updated since).
+ */
+func (tree *Tree) GetWithExtensions(leafNo [32]byte) (*ExtendedLeaf, bool) {
+    leaf, ok := tree.extended[leafNo]
+    return leaf, ok
+}
+
+/**
+ * Returns true if the proof's extended leaf (if any) hashes to the proof's leaf hash, and if the
+ * proof of an existing leaf has one in trees with leaf extensions.
+ */
+func _checkExtensions(proof *MembershipProof, opts TreeOptions) bool {
+    exists := proof.LeafHash != ([32]byte{})
+    if proof.Extended == nil {
+        return !opts.LeafExtensions || !exists
+    }
+    if !exists {
+        return false
+    }
+
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+
+    leafHash := _leafHashWith(newHash, opts.RFC6962, opts._leafHashKey(), proof.Extended.Hash(newHash, proof.LeafNo))
+    return hashEqual(leafHash, proof.LeafHash)
+}
+
+/**
+ * Returns the epoch the proof's leaf committed to being inserted in and true, or false if it did
+ * not commit to one (see LeafExtensions::Epoch). Only meaningful once the proof was verified.
+ */
+func (proof *MembershipProof) InsertionEpoch() (uint64, bool) {
+    if proof.Extended == nil || proof.Extended.Extensions.Epoch == nil {
+        return 0, false
+    }
+    return *proof.Extended.Extensions.Epoch, true
+}
+
+/**
+ * Checks that the proof shows its leaf is in the tree with root 'rootHash' and has been since
+ * epoch 'epoch', i.e., that it committed to being inserted in that epoch or before it.
+ */
+func CheckExistedSince(proof *MembershipProof, rootHash [32]byte, epoch uint64, opts TreeOptions) error {
+    if err := CheckMembershipProof(proof, rootHash, opts); err != nil {
+        return err
+    }
+    if proof.LeafHash == ([32]byte{}) {
+        return _verifyErrorf(ErrLeafMismatch, "leaf %s is not in the tree", hashStr(proof.LeafNo))
+    }
+    insertedIn, ok := proof.InsertionEpoch()
+    if !ok {
+        return _verifyErrorf(ErrLeafMismatch, "leaf %s does not commit to its insertion epoch", hashStr(proof.LeafNo))
+    }
+    if insertedIn > epoch {
+        return _verifyErrorf(ErrLeafMismatch, "leaf %s was inserted in epoch %d, after epoch %d", hashStr(proof.LeafNo), insertedIn, epoch)
+    }
+    return nil
+}
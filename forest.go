@@ -0,0 +1,270 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+
+    "./log"
+)
+
+/**
+ * A forest of independent trees, one per namespace, so that one process can host many
+ * dictionaries (e.g., a key directory per application). Every namespace has its own tree, and
+ * thus its own epochs and root log (see Tree::RootLog()), and all trees have the same options.
+ *
+ * The forest's super-root commits to the latest epoch root of every namespace: it is the root of
+ * a Merkle log (see package log) whose entries are NamespaceEntryHash() of the heads of
+ * the namespaces with epochs, sorted by namespace. Clients who trust a super-root (e.g., because
+ * it was anchored) can check a namespace's head against it via ProveNamespace() and
+ * VerifyNamespaceProof(), and a single anchored hash covers every namespace.
+ *
+ * NOTE: The namespaces' heads are read one after the other, so a super-root may include a head
+ * that is older than another namespace's head read before it. Every head in it was recorded,
+ * though.
+ */
+type Forest struct {
+    mu      sync.RWMutex
+    opts    TreeOptions
+    trees   map[string]*ConcurrentTree
+    metrics *Metrics // shared by all namespaces' trees, see SetMetrics()
+}
+
+// The longest namespace name, so that names fit in URL paths and log lines
+const maxNamespaceLen = 64
+
+/**
+ * The head of a namespace's tree, i.e., its latest epoch and root.
+ */
+type NamespaceHead struct {
+    Namespace string
+    Head      TreeHead
+}
+
+/**
+ * A proof that a namespace's head is in the forest's super-root, i.e., an inclusion proof of its
+ * entry in the log of namespace heads.
+ */
+type NamespaceProof struct {
+    NamespaceHead
+    Index    int // the entry's index in the log
+    Size     int // the # of entries in the log
+    Siblings [][32]byte
+}
+
+/**
+ * Creates an empty forest, whose namespaces' trees will have the specified options.
+ */
+func NewForest(opts TreeOptions) (*Forest, error) {
+    // Check the options once, so that adding namespaces only fails for bad names
+    numLevels, err := opts._numLevels()
+    if err != nil {
+        return nil, err
+    }
+    if _, err := NewTreeWithOptions(numLevels, opts); err != nil {
+        return nil, err
+    }
+
+    return &Forest{opts: opts, trees: make(map[string]*ConcurrentTree)}, nil
+}
+
+/**
+ * Checks that 'namespace' is 1 to maxNamespaceLen lowercase letters, digits, '-', '_' or '.', so
+ * that it can be used in URL paths as is.
+ */
+func CheckNamespace(namespace string) error {
+    if len(namespace) == 0 || len(namespace) > maxNamespaceLen {
+        return fmt.Errorf("namespaces must have between 1 and %d characters, not %d", maxNamespaceLen, len(namespace))
+    }
+    for _, c := range namespace {
+        if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+            return fmt.Errorf("namespace '%s' has invalid character '%c'", namespace, c)
+        }
+    }
+    return nil
+}
+
+/**
+ * Adds a namespace with an empty tree and returns its tree. Returns an error if the name is
+ * invalid (see CheckNamespace()) or taken.
+ */
+func (forest *Forest) CreateNamespace(namespace string) (*ConcurrentTree, error) {
+    if err := CheckNamespace(namespace); err != nil {
+        return nil, err
+    }
+
+    forest.mu.Lock()
+    defer forest.mu.Unlock()
+
+    if forest.trees[namespace] != nil {
+        return nil, fmt.Errorf("namespace '%s' already exists", namespace)
+    }
+    numLevels, _ := forest.opts._numLevels()
+    tree, err := NewTreeWithOptions(numLevels, forest.opts)
+    if err != nil {
+        return nil, err
+    }
+
+    tree.SetMetrics(forest.metrics)
+    ct := WrapTree(tree)
+    forest.trees[namespace] = ct
+    return ct, nil
+}
+
+/**
+ * Returns the tree of a namespace, or nil if there is no such namespace.
+ */
+func (forest *Forest) Namespace(namespace string) *ConcurrentTree {
+    forest.mu.RLock()
+    defer forest.mu.RUnlock()
+
+    return forest.trees[namespace]
+}
+
+/**
+ * Returns the names of the namespaces, sorted.
+ */
+func (forest *Forest) Namespaces() []string {
+    forest.mu.RLock()
+    defer forest.mu.RUnlock()
+
+    names := make([]string, 0, len(forest.trees))
+    for name := range forest.trees {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/**
+ * Makes the trees of all namespaces, including the ones created later, count their changes and
+ * proofs in 'm' (see Tree::SetMetrics()).
+ */
+func (forest *Forest) SetMetrics(m *Metrics) {
+    forest.mu.Lock()
+    defer forest.mu.Unlock()
+
+    forest.metrics = m
+    for _, ct := range forest.trees {
+        ct.SetMetrics(m)
+    }
+}
+
+/**
+ * Returns the # of nodes in every band of levels, summed over all namespaces' trees (see
+ * Tree::NodeCountsByBand()).
+ */
+func (forest *Forest) NodeCountsByBand() []int64 {
+    forest.mu.RLock()
+    defer forest.mu.RUnlock()
+
+    var counts []int64
+    for _, ct := range forest.trees {
+        ct.Read(func(tree *Tree) {
+            counts = tree.NodeCountsByBand(counts)
+        })
+    }
+    return counts
+}
+
+/**
+ * Returns the super-root and the heads it commits to, sorted by namespace. Namespaces without
+ * epochs are left out.
+ */
+func (forest *Forest) SuperRoot() ([32]byte, []NamespaceHead) {
+    heads := forest._heads()
+    return _namespaceLog(heads).Root(), heads
+}
+
+/**
+ * Returns the proof that the namespace's head is in the super-root, along with the super-root.
+ */
+func (forest *Forest) ProveNamespace(namespace string) (*NamespaceProof, [32]byte, error) {
+    heads := forest._heads()
+    index := sort.Search(len(heads), func(i int) bool {
+        return heads[i].Namespace >= namespace
+    })
+    if index == len(heads) || heads[index].Namespace != namespace {
+        return nil, [32]byte{}, fmt.Errorf("namespace '%s' does not exist or has no epochs", namespace)
+    }
+
+    hlog := _namespaceLog(heads)
+    siblings, err := hlog.ProveInclusion(index, len(heads))
+    if err != nil {
+        return nil, [32]byte{}, err
+    }
+    proof := &NamespaceProof{
+        NamespaceHead: heads[index],
+        Index:         index,
+        Size:          len(heads),
+        Siblings:      siblings,
+    }
+    return proof, hlog.Root(), nil
+}
+
+/**
+ * Checks that the proof's namespace head is in the forest with the specified super-root.
+ */
+func VerifyNamespaceProof(proof *NamespaceProof, superRoot [32]byte) error {
+    if proof == nil {
+        return errors.New("no namespace proof")
+    }
+    if err := CheckNamespace(proof.Namespace); err != nil {
+        return err
+    }
+    entry := NamespaceEntryHash(proof.Namespace, proof.Head)
+    if err := log.VerifyInclusion(entry, proof.Index, proof.Size, proof.Siblings, superRoot); err != nil {
+        return fmt.Errorf("namespace '%s' is not in the super-root: %v", proof.Namespace, err)
+    }
+    return nil
+}
+
+/**
+ * Returns the entry of a namespace's head in the log of namespace heads, i.e.,
+ * SHA256(len(namespace) || namespace || epoch || root), where the length is 1 byte and the epoch
+ * is 8 big-endian bytes.
+ */
+func NamespaceEntryHash(namespace string, head TreeHead) [32]byte {
+    var buf bytes.Buffer
+    buf.WriteByte(byte(len(namespace)))
+    buf.WriteString(namespace)
+    binary.Write(&buf, binary.BigEndian, uint64(head.Epoch))
+    buf.Write(head.Root[:])
+    return sha256.Sum256(buf.Bytes())
+}
+
+/**
+ * Returns the heads of the namespaces with epochs, sorted by namespace.
+ */
+func (forest *Forest) _heads() []NamespaceHead {
+    forest.mu.RLock()
+    defer forest.mu.RUnlock()
+
+    var heads []NamespaceHead
+    for name, ct := range forest.trees {
+        ct.Read(func(tree *Tree) {
+            if n := tree.NumEpochs(); n > 0 {
+                heads = append(heads, NamespaceHead{name, TreeHead{n - 1, tree.GetEpochRoot(n - 1)}})
+            }
+        })
+    }
+    sort.Slice(heads, func(i, j int) bool {
+        return heads[i].Namespace < heads[j].Namespace
+    })
+    return heads
+}
+
+/**
+ * Returns the log of the heads' entries, in order.
+ */
+func _namespaceLog(heads []NamespaceHead) *log.Log {
+    hlog := log.New()
+    for _, head := range heads {
+        hlog.Append(NamespaceEntryHash(head.Namespace, head.Head))
+    }
+    return hlog
+}
@@ -0,0 +1,79 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Every namespace's head must be proven to be in the super-root, and only as it was recorded,
+ * while namespaces without epochs are left out.
+ */
+func TestForestProvesNamespaces(t *testing.T) {
+    forest, err := NewForest(TreeOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    names := []string{"keys", "apps.v2", "a-b_c"}
+    for i, name := range names {
+        ct, err := forest.CreateNamespace(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for j := 0; j <= i; j++ {
+            leaf := _testLeaf(10*i + j)
+            ct.Insert(leaf.LeafNo, leaf.DataHash, nil)
+            ct.RecordEpoch()
+        }
+    }
+    if _, err := forest.CreateNamespace("empty"); err != nil {
+        t.Fatal(err)
+    }
+    for _, name := range []string{"keys", "", "Upper", "with/slash"} {
+        if _, err := forest.CreateNamespace(name); err == nil {
+            t.Errorf("namespace '%s' was created", name)
+        }
+    }
+
+    superRoot, heads := forest.SuperRoot()
+    if len(heads) != len(names) {
+        t.Fatalf("super-root has %d heads, expected %d", len(heads), len(names))
+    }
+    for _, name := range names {
+        proof, root, err := forest.ProveNamespace(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if root != superRoot {
+            t.Fatalf("namespace '%s' is proven against another super-root", name)
+        }
+        ct := forest.Namespace(name)
+        if proof.Head.Root != ct.GetRootHash() {
+            t.Errorf("namespace '%s' has head %s, expected %s", name, hashStr(proof.Head.Root), hashStr(ct.GetRootHash()))
+        }
+        if err := VerifyNamespaceProof(proof, superRoot); err != nil {
+            t.Errorf("proof of namespace '%s' does not verify: %v", name, err)
+        }
+
+        tampered := *proof
+        tampered.Head.Epoch++
+        if err := VerifyNamespaceProof(&tampered, superRoot); err == nil {
+            t.Errorf("proof of namespace '%s' verifies with another epoch", name)
+        }
+        tampered = *proof
+        tampered.Namespace = "other"
+        if err := VerifyNamespaceProof(&tampered, superRoot); err == nil {
+            t.Errorf("proof of namespace '%s' verifies for another namespace", name)
+        }
+    }
+    if _, _, err := forest.ProveNamespace("empty"); err == nil {
+        t.Errorf("namespace without epochs is proven")
+    }
+
+    // New epochs of any namespace change the super-root
+    leaf := _testLeaf(100)
+    forest.Namespace("keys").Insert(leaf.LeafNo, leaf.DataHash, nil)
+    forest.Namespace("keys").RecordEpoch()
+    if newRoot, _ := forest.SuperRoot(); newRoot == superRoot {
+        t.Errorf("super-root did not change after a new epoch")
+    }
+}
@@ -0,0 +1,106 @@
+package aomt
+
+import (
+    "errors"
+    "fmt"
+)
+
+/**
+ * Returns a proof that the leaf is (or is not) in the tree as of the end of the specified epoch,
+ * which can be checked against that epoch's root hash, for clients that hold an old root. Versioned
+ * trees answer from their versions (see Version()), if they go back far enough, and other trees
+ * rebuild the leaf's path as of 'epoch' from the changes made since (see _frontierAt()), unless it
+ * is the latest one and there are no changes since.
+ */
+func (tree *Tree) ProveMembershipAtEpoch(leafNo [32]byte, epoch int) *MembershipProof {
+    if epoch < 0 || epoch >= len(tree.epochs) {
+        panic(fmt.Sprintf("Epoch %d was never recorded (have %d epochs)", epoch, len(tree.epochs)))
+    }
+
+    if epoch == len(tree.epochs)-1 && len(tree.pending) == 0 {
+        return tree.ProveMembership(leafNo)
+    }
+    if tree.versions != nil && epoch >= tree.versionsFrom {
+        return tree.Version(epoch).ProveMembership(leafNo)
+    }
+
+    proof := tree._frontierAt(epoch, [][32]byte{leafNo}).ProveMembership(leafNo)
+    // The replayed tree has no openings, but committed leaves never change unless they are
+    // updated, which drops their opening (as in TreeVersion::ProveMembership())
+    if proof.LeafHash != tree.EmptyHash {
+        proof.Opening = tree.openings[leafNo]
+        proof.Extended = tree.extended[leafNo]
+    }
+    return proof
+}
+
+/**
+ * A membership proof against an old epoch's root, bundled with an append-only proof from that
+ * epoch to a newer one, so that a client holding the old root can check the leaf and then move
+ * to the newer root in one round trip. The bundle's freshness delta (see Delta()) says how many
+ * epochs the client was behind.
+ */
+type FreshMembershipProof struct {
+    Membership *MembershipProof // against the root of 'FromEpoch'
+    FromEpoch  int
+    ToEpoch    int
+    NewRoot    [32]byte         // the root of 'ToEpoch'
+    AppendOnly *AppendOnlyProof // from 'FromEpoch' to 'ToEpoch', nil if they are the same epoch
+}
+
+/**
+ * Returns the proof that the leaf is (or is not) in the tree as of the end of epoch 'epoch' (see
+ * ProveMembershipAtEpoch()), along with the append-only proof from 'epoch' to the latest epoch.
+ */
+func (tree *Tree) ProveMembershipFresh(leafNo [32]byte, epoch int) *FreshMembershipProof {
+    latest := len(tree.epochs) - 1
+    proof := &FreshMembershipProof{
+        Membership: tree.ProveMembershipAtEpoch(leafNo, epoch),
+        FromEpoch:  epoch,
+        ToEpoch:    latest,
+        NewRoot:    tree.GetEpochRoot(latest),
+    }
+    if epoch < latest {
+        proof.AppendOnly = NewAppendOnlyProof(tree.ProveAppendOnly(epoch, latest))
+    }
+    return proof
+}
+
+/**
+ * Returns the # of epochs between the membership proof's epoch and the newer one.
+ */
+func (proof *FreshMembershipProof) Delta() int {
+    return proof.ToEpoch - proof.FromEpoch
+}
+
+/**
+ * Checks the membership proof against 'oldRoot', the root of the epoch the client holds, and that
+ * the tree with 'oldRoot' is a prefix of the one with the proof's NewRoot, for a tree created via
+ * NewTreeWithOptions() with the specified options. The client can then replace 'oldRoot' with
+ * NewRoot, as long as it trusts NewRoot to be the one everybody else sees (e.g., it is anchored).
+ */
+func VerifyFreshMembershipProof(proof *FreshMembershipProof, oldRoot [32]byte, opts TreeOptions) error {
+    if proof == nil || proof.Membership == nil {
+        return errors.New("no membership proof")
+    }
+    if proof.FromEpoch < 0 || proof.ToEpoch < proof.FromEpoch {
+        return fmt.Errorf("invalid epochs %d to %d", proof.FromEpoch, proof.ToEpoch)
+    }
+    if !VerifyMembershipProofWithOptions(proof.Membership, oldRoot, opts) {
+        return fmt.Errorf("the membership proof does not match the root of epoch %d", proof.FromEpoch)
+    }
+
+    if proof.Delta() == 0 {
+        if proof.AppendOnly != nil || !hashEqual(proof.NewRoot, oldRoot) {
+            return fmt.Errorf("epoch %d has two roots", proof.FromEpoch)
+        }
+        return nil
+    }
+    if proof.AppendOnly == nil {
+        return fmt.Errorf("missing append-only proof from epoch %d to epoch %d", proof.FromEpoch, proof.ToEpoch)
+    }
+    if err := proof.AppendOnly.VerifyWithOptions(oldRoot, proof.NewRoot, opts); err != nil {
+        return fmt.Errorf("epoch %d is not a prefix of epoch %d: %v", proof.FromEpoch, proof.ToEpoch, err)
+    }
+    return nil
+}
@@ -0,0 +1,60 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Proofs as of past epochs must verify against those epochs' roots, for versioned trees and others
+ * alike, and fresh proofs must move a client from any epoch to the latest one.
+ */
+func TestProveMembershipAtPastEpochs(t *testing.T) {
+    for _, opts := range []TreeOptions{{}, {Versioned: true}} {
+        tree, leafNos := _testMembershipTree(t, 257, opts)
+        tree.RecordEpoch()
+        for e := 1; e < 3; e++ {
+            for i := 0; i < 10; i++ {
+                leaf := _testLeaf(100*e + i)
+                tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+                leafNos = append(leafNos, leaf.LeafNo)
+            }
+            tree.Update(leafNos[3*e], _testLeaf(-e).DataHash, nil)
+            tree.RecordEpoch()
+        }
+        // Pending changes are not part of any epoch
+        leaf := _testLeaf(1000)
+        tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        latest := tree.NumEpochs() - 1
+
+        for e := 0; e <= latest; e++ {
+            past := tree.TreeAtEpoch(e)
+            for i := 0; i < len(leafNos); i += 11 {
+                proof := tree.ProveMembershipAtEpoch(leafNos[i], e)
+                if hash, _ := past.Get(leafNos[i]); proof.LeafHash != hash {
+                    t.Errorf("%+v: proof of leaf %d as of epoch %d has hash %s, expected %s", opts, i, e, hashStr(proof.LeafHash), hashStr(hash))
+                }
+                if !VerifyMembershipProofWithOptions(proof, tree.GetEpochRoot(e), opts) {
+                    t.Errorf("%+v: proof of leaf %d as of epoch %d does not verify", opts, i, e)
+                }
+
+                fresh := tree.ProveMembershipFresh(leafNos[i], e)
+                if fresh.Delta() != latest-e {
+                    t.Errorf("%+v: fresh proof from epoch %d has delta %d", opts, e, fresh.Delta())
+                }
+                if err := VerifyFreshMembershipProof(fresh, tree.GetEpochRoot(e), opts); err != nil {
+                    t.Errorf("%+v: fresh proof of leaf %d from epoch %d does not verify: %v", opts, i, e, err)
+                }
+                if e < latest {
+                    tampered := *fresh
+                    tampered.NewRoot[0] ^= 1
+                    if err := VerifyFreshMembershipProof(&tampered, tree.GetEpochRoot(e), opts); err == nil {
+                        t.Errorf("%+v: fresh proof of leaf %d from epoch %d verifies for another new root", opts, i, e)
+                    }
+                    if err := VerifyFreshMembershipProof(fresh, tree.GetEpochRoot(e+1), opts); err == nil {
+                        t.Errorf("%+v: fresh proof of leaf %d from epoch %d verifies from epoch %d", opts, i, e, e+1)
+                    }
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,85 @@
+package aomt
+
+import (
+    "bytes"
+    "fmt"
+)
+
+/**
+ * A fuzzing entry point in the style of go-fuzz (i.e., func Fuzz(data []byte) int), which checks
+ * that verifiers survive malicious provers: 'data' is decoded as an append-only proof in every
+ * encoding we have (binary, compressed, JSON, streamed and within a ProofEnvelope), and every
+ * decoded proof is checked against the two roots in the first 64 bytes of 'data', for trees with
+ * 257 and with 9 levels (which random LNs are more likely to fit). Invalid proofs must be rejected
+ * with an error, never with a panic.
+ *
+ * Returns 1 if 'data' decoded to at least one proof, so that the fuzzer favors such inputs, and 0
+ * otherwise.
+ */
+func FuzzVerifyAppendOnlyProof(data []byte) int {
+    if len(data) < 64 {
+        return 0
+    }
+    var oldRoot, newRoot [32]byte
+    copy(oldRoot[:], data[:32])
+    copy(newRoot[:], data[32:64])
+    encoded := data[64:]
+
+    var proofs []*AppendOnlyProof
+    for _, decode := range []func(*AppendOnlyProof) error{
+        func(proof *AppendOnlyProof) error { return proof.UnmarshalBinary(encoded) },
+        func(proof *AppendOnlyProof) error { return proof.UnmarshalCompressed(encoded) },
+        func(proof *AppendOnlyProof) error { return proof.UnmarshalJSON(encoded) },
+        func(proof *AppendOnlyProof) error { return proof.UnmarshalPaths(encoded) },
+        func(proof *AppendOnlyProof) error { return proof.UnmarshalAdaptive(encoded) },
+    } {
+        proof := &AppendOnlyProof{}
+        if decode(proof) == nil {
+            proofs = append(proofs, proof)
+        }
+    }
+
+    decoded := len(proofs) > 0
+    for _, numLevels := range []int{257, 9} {
+        opts := TreeOptions{NumLevels: numLevels}
+        VerifyAppendOnlyStreamWithOptions(bytes.NewReader(encoded), oldRoot, newRoot, opts)
+
+        for _, proof := range proofs {
+            emptyTree, _ := NewTreeWithOptions(numLevels, TreeOptions{})
+            proofTree, err := proof.ToTree(emptyTree)
+            if err != nil {
+                continue
+            }
+            // Verifying without a proof tree must agree with verifying the proof tree
+            errTree := VerifyAppendOnlyProofTree(proofTree, oldRoot, newRoot)
+            if errList := proof.VerifyWithOptions(oldRoot, newRoot, opts); len(proof.Nodes) > 0 && (errTree == nil) != (errList == nil) {
+                panic(fmt.Sprintf("Something's off: proof tree verification says %v, but proof verification says %v", errTree, errList))
+            }
+
+            // A proof that hashes to some roots must verify against them, with or without a proof tree
+            if roots, err := proof.RootsWithOptions(opts); err == nil {
+                if VerifyAppendOnlyProofTree(proofTree, roots.Old, roots.New) != nil {
+                    panic("Something's off: proof tree does not verify against its own roots")
+                }
+                if proof.VerifyWithOptions(roots.Old, roots.New, opts) != nil {
+                    panic("Something's off: proof does not verify against its own roots")
+                }
+            }
+        }
+    }
+
+    var binEnv, jsonEnv ProofEnvelope
+    if binEnv.UnmarshalBinary(data) == nil {
+        decoded = true
+        binEnv.Verify(NewTree(257))
+    }
+    if jsonEnv.UnmarshalJSON(data) == nil {
+        decoded = true
+        jsonEnv.Verify(NewTree(257))
+    }
+
+    if decoded {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,291 @@
+package aomt
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "time"
+)
+
+/**
+ * Split-view detection: a server that shows different trees to different clients (e.g., one with
+ * and one without a leaf) can only be caught by clients comparing what they were shown. Gossip()
+ * takes the latest heads seen by several parties, i.e., fetched from several endpoints of the HTTP
+ * server (e.g., the same log through different networks or mirrors) or saved to files by other
+ * clients, and checks every pair of them: heads of the same epoch must have the same root, and the
+ * newer of two heads must extend the older one, which is checked via an append-only proof fetched
+ * from an endpoint that has the newer epoch. Any pair that fails is a divergence.
+ *
+ * NOTE: Heads are compared as they are: their signatures (if any) are not checked, so a
+ * divergence only shows the log misbehaved if the heads are signed (see SignedTreeHead).
+ */
+
+/**
+ * Where a head comes from.
+ */
+type HeadSource interface {
+    Name() string
+    LatestHead() (TreeHead, error)
+}
+
+/**
+ * A source that can also prove that the tree of one of its epochs extends that of an earlier one.
+ */
+type AppendOnlyProofSource interface {
+    HeadSource
+    FetchAppendOnly(fromEpoch int, toEpoch int) (*ProofEnvelope, error)
+}
+
+/**
+ * The HTTP server at 'BaseURL' (e.g., 'https://log.example.com'), see server.go.
+ */
+type HTTPHeadSource struct {
+    BaseURL string
+    APIKey  string // sent as a bearer token, if not empty
+    Client  *http.Client
+}
+
+// How long to wait for an endpoint before giving up on it
+const gossipTimeout = 30 * time.Second
+
+func NewHTTPHeadSource(baseURL string, apiKey string) *HTTPHeadSource {
+    return &HTTPHeadSource{
+        BaseURL: strings.TrimSuffix(baseURL, "/"),
+        APIKey:  apiKey,
+        Client:  &http.Client{Timeout: gossipTimeout},
+    }
+}
+
+func (src *HTTPHeadSource) Name() string {
+    return src.BaseURL
+}
+
+func (src *HTTPHeadSource) LatestHead() (TreeHead, error) {
+    data, err := src._get("/v1/root", "application/json")
+    if err != nil {
+        return TreeHead{}, err
+    }
+    return _parseHeadJSON(data)
+}
+
+func (src *HTTPHeadSource) FetchAppendOnly(fromEpoch int, toEpoch int) (*ProofEnvelope, error) {
+    data, err := src._get(fmt.Sprintf("/v1/proof/append-only?from=%d&to=%d", fromEpoch, toEpoch), "application/octet-stream")
+    if err != nil {
+        return nil, err
+    }
+
+    var env ProofEnvelope
+    if err := env.UnmarshalBinary(data); err != nil {
+        return nil, err
+    }
+    return &env, nil
+}
+
+func (src *HTTPHeadSource) _get(path string, accept string) ([]byte, error) {
+    req, err := http.NewRequest(http.MethodGet, src.BaseURL+path, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", accept)
+    if src.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+src.APIKey)
+    }
+
+    resp, err := src.Client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    data, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(data)))
+    }
+    return data, nil
+}
+
+/**
+ * A head saved to a file as JSON, e.g., the response of another client's GET /v1/root, which
+ * has the head's 'epoch' and 'root' (other fields are ignored).
+ */
+type FileHeadSource struct {
+    Path string
+}
+
+func (src *FileHeadSource) Name() string {
+    return src.Path
+}
+
+func (src *FileHeadSource) LatestHead() (TreeHead, error) {
+    data, err := ioutil.ReadFile(src.Path)
+    if err != nil {
+        return TreeHead{}, err
+    }
+    return _parseHeadJSON(data)
+}
+
+func _parseHeadJSON(data []byte) (TreeHead, error) {
+    var v rootResponseJSON
+    if err := json.Unmarshal(data, &v); err != nil {
+        return TreeHead{}, fmt.Errorf("parsing head: %v", err)
+    }
+    if v.Epoch < 0 {
+        return TreeHead{}, fmt.Errorf("parsing head: invalid epoch %d", v.Epoch)
+    }
+    root, err := _parseHashHex(v.Root)
+    if err != nil {
+        return TreeHead{}, fmt.Errorf("parsing head: %v", err)
+    }
+    return TreeHead{Epoch: v.Epoch, Root: root}, nil
+}
+
+/**
+ * The head a source returned, or why it could not.
+ */
+type GossipHead struct {
+    Source string
+    Head   TreeHead
+    Err    error
+}
+
+/**
+ * Two sources whose heads cannot both be of the same append-only tree.
+ */
+type GossipDivergence struct {
+    SourceA, SourceB string
+    HeadA, HeadB     TreeHead
+    Reason           string
+}
+
+func (d *GossipDivergence) String() string {
+    return fmt.Sprintf("%s (epoch %d, root %s) and %s (epoch %d, root %s): %s",
+        d.SourceA, d.HeadA.Epoch, hashStr(d.HeadA.Root), d.SourceB, d.HeadB.Epoch, hashStr(d.HeadB.Root), d.Reason)
+}
+
+type GossipReport struct {
+    Heads       []GossipHead
+    NumChecked  int                // the # of pairs of heads found consistent
+    Divergences []GossipDivergence
+    Unchecked   []string           // why some pairs could not be checked, e.g., no endpoint had a proof
+}
+
+/**
+ * Returns true if every source returned a head and every pair of heads was checked and found
+ * consistent.
+ */
+func (report *GossipReport) Consistent() bool {
+    for _, head := range report.Heads {
+        if head.Err != nil {
+            return false
+        }
+    }
+    return len(report.Divergences) == 0 && len(report.Unchecked) == 0
+}
+
+/**
+ * Fetches the latest head of every source and checks every pair of them, verifying append-only
+ * proofs against the parameters of 'emptyTree' (i.e., its # of levels and hash function).
+ */
+func Gossip(sources []HeadSource, emptyTree *Tree) *GossipReport {
+    report := &GossipReport{}
+    var fetched []int // the sources that returned a head, as indices into report.Heads
+    for _, src := range sources {
+        head, err := src.LatestHead()
+        report.Heads = append(report.Heads, GossipHead{Source: src.Name(), Head: head, Err: err})
+        if err == nil {
+            fetched = append(fetched, len(report.Heads)-1)
+        }
+    }
+
+    for i := 0; i < len(fetched); i++ {
+        for j := i + 1; j < len(fetched); j++ {
+            a, b := fetched[i], fetched[j]
+            // Check the older head against the newer one
+            if report.Heads[a].Head.Epoch > report.Heads[b].Head.Epoch {
+                a, b = b, a
+            }
+            report._checkPair(sources, a, b, emptyTree)
+        }
+    }
+    return report
+}
+
+/**
+ * Checks that the tree with the head of source 'b' extends the one with the head of source 'a',
+ * which is at most as recent.
+ */
+func (report *GossipReport) _checkPair(sources []HeadSource, a int, b int, emptyTree *Tree) {
+    older, newer := &report.Heads[a], &report.Heads[b]
+    diverge := func(format string, args ...interface{}) {
+        report.Divergences = append(report.Divergences, GossipDivergence{
+            SourceA: older.Source, SourceB: newer.Source,
+            HeadA: older.Head, HeadB: newer.Head,
+            Reason: fmt.Sprintf(format, args...),
+        })
+    }
+
+    if older.Head.Epoch == newer.Head.Epoch {
+        if older.Head.Root != newer.Head.Root {
+            diverge("epoch %d has two roots", older.Head.Epoch)
+            return
+        }
+        report.NumChecked++
+        return
+    }
+
+    // Prefer the newer head's source for the proof, then any other one that is at least as recent
+    provers := []int{b}
+    for i := range sources {
+        if i != a && i != b && report.Heads[i].Err == nil && report.Heads[i].Head.Epoch >= newer.Head.Epoch {
+            provers = append(provers, i)
+        }
+    }
+
+    var errs []string
+    for _, i := range provers {
+        prover, ok := sources[i].(AppendOnlyProofSource)
+        if !ok {
+            continue
+        }
+        env, err := prover.FetchAppendOnly(older.Head.Epoch, newer.Head.Epoch)
+        if err != nil {
+            errs = append(errs, fmt.Sprintf("%s: %v", prover.Name(), err))
+            continue
+        }
+
+        via := ""
+        if i != b {
+            via = fmt.Sprintf(" (according to %s)", prover.Name())
+        }
+        if env.FromEpoch != older.Head.Epoch || env.ToEpoch != newer.Head.Epoch {
+            errs = append(errs, fmt.Sprintf("%s: sent a proof from epoch %d to epoch %d", prover.Name(), env.FromEpoch, env.ToEpoch))
+            continue
+        }
+        if env.OldRoot != older.Head.Root {
+            diverge("epoch %d has root %s%s", older.Head.Epoch, hashStr(env.OldRoot), via)
+            return
+        }
+        if env.NewRoot != newer.Head.Root {
+            diverge("epoch %d has root %s%s", newer.Head.Epoch, hashStr(env.NewRoot), via)
+            return
+        }
+        if err := env.Verify(emptyTree); err != nil {
+            diverge("the append-only proof%s does not verify: %v", via, err)
+            return
+        }
+        report.NumChecked++
+        return
+    }
+
+    reason := "no source could prove it"
+    if len(errs) > 0 {
+        reason = strings.Join(errs, "; ")
+    }
+    report.Unchecked = append(report.Unchecked, fmt.Sprintf("%s (epoch %d) to %s (epoch %d): %s",
+        older.Source, older.Head.Epoch, newer.Source, newer.Head.Epoch, reason))
+}
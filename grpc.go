@@ -0,0 +1,354 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+/**
+ * Serves a DB (see Open()) over gRPC, i.e., the AppendOnlyTree service in aomt.proto, for
+ * cmd/amtserver. There is no go.mod to depend on google.golang.org/grpc, so we speak the gRPC
+ * wire protocol (see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md) ourselves via
+ * net/http, with the messages encoded by proto.go. Only unary calls with uncompressed messages are
+ * supported, which is all the service needs, and connections are unencrypted HTTP/2 (i.e., what
+ * gRPC clients speak to "insecure" servers), so TLS must be terminated in front of the server.
+ *
+ * Keys are mapped to leaf nos and values to data hashes as by 'amt insert' (see InsertRequest in
+ * aomt.proto). Heads are signed by the DB, if it has a signing key.
+ *
+ * If the server has API keys, calls must carry one in an 'authorization: Bearer <key>' metadata
+ * entry, as requests to the HTTP server do (see Server). Failed calls get a gRPC status:
+ *
+ *   INVALID_ARGUMENT     malformed requests, leaf nos too large for the tree, non-empty namespaces
+ *   NOT_FOUND            epochs or roots that the tree does not have
+ *   FAILED_PRECONDITION  lookups before the first epoch
+ *   RESOURCE_EXHAUSTED   requests larger than GRPCServerOptions::MaxMessageBytes
+ *   UNIMPLEMENTED        unknown methods and compressed requests
+ *   UNAUTHENTICATED      missing or invalid API keys
+ *   INTERNAL             epochs that could not be logged (see DB::Insert())
+ */
+type GRPCServer struct {
+    db      *DB
+    opts    GRPCServerOptions
+    apiKeys map[string]bool
+    methods map[string]func(data []byte) (protoMarshaler, error)
+}
+
+type GRPCServerOptions struct {
+    APIKeys         []string // the API keys allowed to call the server, or none to allow everyone
+    MaxMessageBytes int      // the size of the largest request accepted, 4 MiB (as in gRPC) if 0
+}
+
+const (
+    grpcService            = "aomt.AppendOnlyTree"
+    defaultGRPCMessageSize = 4 << 20
+)
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+    grpcOK                 = 0
+    grpcInvalidArgument    = 3
+    grpcNotFound           = 5
+    grpcResourceExhausted  = 8
+    grpcFailedPrecondition = 9
+    grpcUnimplemented      = 12
+    grpcInternal           = 13
+    grpcUnauthenticated    = 16
+)
+
+/**
+ * A failed call's gRPC status.
+ */
+type grpcError struct {
+    code int
+    msg  string
+}
+
+func (err *grpcError) Error() string {
+    return fmt.Sprintf("gRPC status %d: %s", err.code, err.msg)
+}
+
+func _grpcErrorf(code int, format string, args ...interface{}) error {
+    return &grpcError{code, fmt.Sprintf(format, args...)}
+}
+
+func NewGRPCServer(db *DB, opts GRPCServerOptions) *GRPCServer {
+    if opts.MaxMessageBytes == 0 {
+        opts.MaxMessageBytes = defaultGRPCMessageSize
+    }
+    srv := &GRPCServer{db: db, opts: opts, apiKeys: make(map[string]bool)}
+    for _, key := range opts.APIKeys {
+        srv.apiKeys[key] = true
+    }
+    srv.methods = map[string]func(data []byte) (protoMarshaler, error){
+        "GetLeaf":            srv._getLeaf,
+        "GetAppendOnlyProof": srv._getAppendOnlyProof,
+        "Insert":             srv._insert,
+        "Get":                srv._get,
+        "GetRoot":            srv._getRoot,
+    }
+    return srv
+}
+
+/**
+ * Serves gRPC calls on 'addr' (e.g., ':50051') over unencrypted HTTP/2.
+ */
+func (srv *GRPCServer) ListenAndServe(addr string) error {
+    var protocols http.Protocols
+    protocols.SetUnencryptedHTTP2(true)
+    server := &http.Server{Addr: addr, Handler: srv, Protocols: &protocols}
+    return server.ListenAndServe()
+}
+
+func (srv *GRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    contentType := r.Header.Get("Content-Type")
+    if r.Method != http.MethodPost || r.ProtoMajor != 2 ||
+        !(contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+proto") || strings.HasPrefix(contentType, "application/grpc;")) {
+        http.Error(w, "this server only answers gRPC calls", http.StatusUnsupportedMediaType)
+        return
+    }
+
+    // The status is always sent in the trailers, even for calls that fail before the response
+    // message, which gRPC clients accept
+    w.Header().Set("Content-Type", "application/grpc")
+    resp, err := srv._call(r)
+    if err == nil {
+        var data []byte
+        if data, err = resp.MarshalProto(); err == nil {
+            var prefix [5]byte
+            binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+            w.Write(prefix[:])
+            w.Write(data)
+        }
+    }
+
+    status, ok := err.(*grpcError)
+    if err != nil && !ok {
+        status = &grpcError{grpcInternal, err.Error()}
+    }
+    if status == nil {
+        w.Header().Set(http.TrailerPrefix+"Grpc-Status", fmt.Sprint(grpcOK))
+        return
+    }
+    w.Header().Set(http.TrailerPrefix+"Grpc-Status", fmt.Sprint(status.code))
+    w.Header().Set(http.TrailerPrefix+"Grpc-Message", _grpcPercentEncode(status.msg))
+}
+
+/**
+ * Authenticates the call, reads its request message and calls its method.
+ */
+func (srv *GRPCServer) _call(r *http.Request) (protoMarshaler, error) {
+    name := strings.TrimPrefix(r.URL.Path, "/"+grpcService+"/")
+    method, ok := srv.methods[name]
+    if !ok || name == r.URL.Path {
+        return nil, _grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+    }
+
+    if len(srv.apiKeys) > 0 && !srv.apiKeys[_apiKey(r)] {
+        return nil, _grpcErrorf(grpcUnauthenticated, "missing or invalid API key")
+    }
+
+    var prefix [5]byte
+    if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+        return nil, _grpcErrorf(grpcInvalidArgument, "reading the request message: %v", err)
+    }
+    if prefix[0] != 0 {
+        return nil, _grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+    }
+    size := binary.BigEndian.Uint32(prefix[1:])
+    if size > uint32(srv.opts.MaxMessageBytes) {
+        return nil, _grpcErrorf(grpcResourceExhausted, "the request message has %d bytes, more than the maximum of %d", size, srv.opts.MaxMessageBytes)
+    }
+    data := make([]byte, size)
+    if _, err := io.ReadFull(r.Body, data); err != nil {
+        return nil, _grpcErrorf(grpcInvalidArgument, "reading the request message: %v", err)
+    }
+    if n, _ := r.Body.Read(prefix[:1]); n > 0 {
+        return nil, _grpcErrorf(grpcInvalidArgument, "%s is a unary method, but the call has more than one request message", name)
+    }
+
+    return method(data)
+}
+
+/**
+ * Percent-encodes a status message for the grpc-message trailer, as the protocol requires.
+ */
+func _grpcPercentEncode(msg string) string {
+    var buf bytes.Buffer
+    for i := 0; i < len(msg); i++ {
+        if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+            fmt.Fprintf(&buf, "%%%02X", c)
+        } else {
+            buf.WriteByte(c)
+        }
+    }
+    return buf.String()
+}
+
+func _decodeGRPCRequest(data []byte, req protoUnmarshaler) error {
+    if err := req.UnmarshalProto(data); err != nil {
+        return _grpcErrorf(grpcInvalidArgument, "malformed request: %v", err)
+    }
+    return nil
+}
+
+func _checkGRPCNamespace(namespace string) error {
+    if namespace != "" {
+        return _grpcErrorf(grpcInvalidArgument, "the server has no namespaces, but the request is for namespace '%s'", namespace)
+    }
+    return nil
+}
+
+/**
+ * Returns the leaf no of a key, see InsertRequest in aomt.proto.
+ */
+func (srv *GRPCServer) _keyLeafNo(key []byte) [32]byte {
+    leafNo, _ := HashKeyMapper{}.MapKey(key, srv.db.NumLevels())
+    return leafNo
+}
+
+func (srv *GRPCServer) _getLeaf(data []byte) (protoMarshaler, error) {
+    var req GetLeafRequest
+    if err := _decodeGRPCRequest(data, &req); err != nil {
+        return nil, err
+    }
+    if err := _checkGRPCNamespace(req.Namespace); err != nil {
+        return nil, err
+    }
+
+    resp := &GetLeafResponse{}
+    var err error
+    srv.db.Tree().Read(func(tree *Tree) {
+        if !_fitsInLevel(req.LeafNo, tree.numLevels-1) {
+            err = _grpcErrorf(grpcInvalidArgument, "leaf %s is too large for a tree with %d levels", hashStr(req.LeafNo), tree.numLevels)
+            return
+        }
+        if req.Epoch >= tree.NumEpochs() {
+            err = _grpcErrorf(grpcNotFound, "epoch %d was never recorded (have %d epochs)", req.Epoch, tree.NumEpochs())
+            return
+        }
+        resp.Head = tree.GetTreeHead(req.Epoch)
+        resp.Proof = tree.ProveMembershipAtEpoch(req.LeafNo, req.Epoch)
+        resp.Exists = resp.Proof.LeafHash != tree.EmptyHash
+    })
+    return resp, err
+}
+
+func (srv *GRPCServer) _getAppendOnlyProof(data []byte) (protoMarshaler, error) {
+    var req GetAppendOnlyProofRequest
+    if err := _decodeGRPCRequest(data, &req); err != nil {
+        return nil, err
+    }
+    if err := _checkGRPCNamespace(req.Namespace); err != nil {
+        return nil, err
+    }
+
+    resp := &GetAppendOnlyProofResponse{}
+    var err error
+    srv.db.Tree().Read(func(tree *Tree) {
+        from, to := req.FromEpoch, req.ToEpoch
+        fromFound, toFound := req.FromRoot == ([32]byte{}), req.ToRoot == ([32]byte{})
+        // Roots are looked up by scanning the epochs, which is cheap next to building the proof
+        for epoch := 0; epoch < tree.NumEpochs(); epoch++ {
+            root := tree.GetEpochRoot(epoch)
+            if !fromFound && root == req.FromRoot {
+                from, fromFound = epoch, true
+            }
+            if !toFound && root == req.ToRoot {
+                to, toFound = epoch, true
+            }
+        }
+        if !fromFound {
+            err = _grpcErrorf(grpcNotFound, "no epoch has root %s", hashStr(req.FromRoot))
+        } else if !toFound {
+            err = _grpcErrorf(grpcNotFound, "no epoch has root %s", hashStr(req.ToRoot))
+        } else if to >= tree.NumEpochs() {
+            err = _grpcErrorf(grpcNotFound, "epoch %d was never recorded (have %d epochs)", to, tree.NumEpochs())
+        } else if from >= to {
+            err = _grpcErrorf(grpcInvalidArgument, "cannot prove epoch %d is a prefix of epoch %d", from, to)
+        }
+        if err != nil {
+            return
+        }
+
+        resp.OldHead = tree.GetTreeHead(from)
+        resp.NewHead = tree.GetTreeHead(to)
+        resp.Proof = NewAppendOnlyProof(tree.ProveAppendOnly(from, to))
+    })
+    return resp, err
+}
+
+func (srv *GRPCServer) _insert(data []byte) (protoMarshaler, error) {
+    var req InsertRequest
+    if err := _decodeGRPCRequest(data, &req); err != nil {
+        return nil, err
+    }
+    if len(req.Entries) == 0 {
+        return nil, _grpcErrorf(grpcInvalidArgument, "the request has no entries")
+    }
+
+    leaves := make([]LeafData, len(req.Entries))
+    for i, entry := range req.Entries {
+        if len(entry.Key) == 0 {
+            return nil, _grpcErrorf(grpcInvalidArgument, "entry %d has an empty key", i)
+        }
+        leaves[i] = LeafData{srv._keyLeafNo(entry.Key), sha256.Sum256(entry.Value)}
+    }
+
+    epoch, errs, err := srv.db.Insert(leaves)
+    if err != nil && epoch < 0 {
+        return nil, _grpcErrorf(grpcInternal, "%v", err)
+    }
+    if err != nil {
+        // The epoch was logged, so the call succeeded, but the DB could not checkpoint it
+        fmt.Printf("Error: %v\n", err)
+    }
+
+    resp := &InsertResponse{Rejected: make([]string, len(errs))}
+    for i, err := range errs {
+        if err != nil {
+            resp.Rejected[i] = err.Error()
+        }
+    }
+    if epoch >= 0 {
+        head, err := srv.db.HeadAt(epoch)
+        if err != nil {
+            return nil, err
+        }
+        resp.Head = &head
+    }
+    return resp, nil
+}
+
+func (srv *GRPCServer) _get(data []byte) (protoMarshaler, error) {
+    var req GetRequest
+    if err := _decodeGRPCRequest(data, &req); err != nil {
+        return nil, err
+    }
+    if len(req.Key) == 0 {
+        return nil, _grpcErrorf(grpcInvalidArgument, "the key is empty")
+    }
+
+    proof, head, err := srv.db.Get(srv._keyLeafNo(req.Key))
+    if err != nil {
+        return nil, _grpcErrorf(grpcFailedPrecondition, "%v", err)
+    }
+    return &GetResponse{Head: head, Exists: proof.LeafHash != srv.db.store.Tree().EmptyHash, Proof: proof}, nil
+}
+
+func (srv *GRPCServer) _getRoot(data []byte) (protoMarshaler, error) {
+    var req GetRootRequest
+    if err := _decodeGRPCRequest(data, &req); err != nil {
+        return nil, err
+    }
+    head, err := srv.db.Head()
+    if err != nil {
+        return nil, _grpcErrorf(grpcFailedPrecondition, "%v", err)
+    }
+    return &head, nil
+}
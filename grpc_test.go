@@ -0,0 +1,179 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/ed25519"
+    "encoding/binary"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "testing"
+)
+
+/**
+ * Makes a unary gRPC call to 'url' and returns its status code, decoding the response message
+ * into 'resp' if the call succeeded.
+ */
+func _testGRPCCall(t *testing.T, url string, method string, apiKey string, req protoMarshaler, resp protoUnmarshaler) int {
+    data, _ := req.MarshalProto()
+    var body bytes.Buffer
+    var prefix [5]byte
+    binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+    body.Write(prefix[:])
+    body.Write(data)
+    return _testGRPCCallRaw(t, url, method, apiKey, body.Bytes(), resp)
+}
+
+func _testGRPCCallRaw(t *testing.T, url string, method string, apiKey string, body []byte, resp protoUnmarshaler) int {
+    var protocols http.Protocols
+    protocols.SetUnencryptedHTTP2(true)
+    client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+
+    httpReq, err := http.NewRequest(http.MethodPost, url+"/"+grpcService+"/"+method, bytes.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    httpReq.Header.Set("Content-Type", "application/grpc")
+    httpReq.Header.Set("TE", "trailers")
+    if apiKey != "" {
+        httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+    }
+    httpResp, err := client.Do(httpReq)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer httpResp.Body.Close()
+    data, err := ioutil.ReadAll(httpResp.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if httpResp.StatusCode != http.StatusOK || httpResp.ProtoMajor != 2 {
+        t.Fatalf("%s: HTTP status %d over HTTP/%d", method, httpResp.StatusCode, httpResp.ProtoMajor)
+    }
+
+    status, err := strconv.Atoi(httpResp.Trailer.Get("Grpc-Status"))
+    if err != nil {
+        t.Fatalf("%s: invalid grpc-status '%s'", method, httpResp.Trailer.Get("Grpc-Status"))
+    }
+    if status != grpcOK {
+        return status
+    }
+    if len(data) < 5 || int(binary.BigEndian.Uint32(data[1:5])) != len(data)-5 {
+        t.Fatalf("%s: malformed response message", method)
+    }
+    if err := resp.UnmarshalProto(data[5:]); err != nil {
+        t.Fatalf("%s: %v", method, err)
+    }
+    return status
+}
+
+func TestGRPCServer(t *testing.T) {
+    key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+    db, err := Open(t.TempDir(), Options{SigningKey: key})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    ts := httptest.NewUnstartedServer(NewGRPCServer(db, GRPCServerOptions{APIKeys: []string{"key"}}))
+    ts.Config.Protocols = &http.Protocols{}
+    ts.Config.Protocols.SetUnencryptedHTTP2(true)
+    ts.Start()
+    defer ts.Close()
+
+    var root SignedTreeHead
+    if status := _testGRPCCall(t, ts.URL, "GetRoot", "key", &GetRootRequest{}, &root); status != grpcFailedPrecondition {
+        t.Fatalf("GetRoot of an empty tree returned status %d", status)
+    }
+
+    var inserted InsertResponse
+    req := &InsertRequest{Entries: []KeyValue{{[]byte("alice"), []byte("1")}, {[]byte("bob"), []byte("2")}}}
+    if status := _testGRPCCall(t, ts.URL, "Insert", "key", req, &inserted); status != grpcOK {
+        t.Fatalf("Insert returned status %d", status)
+    }
+    if inserted.Head == nil || inserted.Head.Head.Epoch != 0 || len(inserted.Rejected) != 2 || inserted.Rejected[0] != "" || inserted.Rejected[1] != "" {
+        t.Fatalf("Insert returned %+v", inserted)
+    }
+    req = &InsertRequest{Entries: []KeyValue{{[]byte("alice"), []byte("3")}, {[]byte("carol"), []byte("4")}}}
+    if status := _testGRPCCall(t, ts.URL, "Insert", "key", req, &inserted); status != grpcOK {
+        t.Fatalf("Insert returned status %d", status)
+    }
+    if inserted.Head == nil || inserted.Head.Head.Epoch != 1 || len(inserted.Rejected) != 2 || inserted.Rejected[0] == "" || inserted.Rejected[1] != "" {
+        t.Fatalf("Insert of an existing key returned %+v", inserted)
+    }
+
+    if status := _testGRPCCall(t, ts.URL, "GetRoot", "key", &GetRootRequest{}, &root); status != grpcOK {
+        t.Fatalf("GetRoot returned status %d", status)
+    }
+    message, _ := root.Head.MarshalProto()
+    if root.Head != inserted.Head.Head || !ed25519.Verify(db.PublicKey(), message, root.Signature) {
+        t.Fatalf("GetRoot returned %+v, expected the signed head of epoch 1", root)
+    }
+
+    for key, exists := range map[string]bool{"alice": true, "carol": true, "dave": false} {
+        var resp GetResponse
+        if status := _testGRPCCall(t, ts.URL, "Get", "key", &GetRequest{Key: []byte(key)}, &resp); status != grpcOK {
+            t.Fatalf("Get(%s) returned status %d", key, status)
+        }
+        if resp.Exists != exists || resp.Head.Head != root.Head || !VerifyMembershipProof(resp.Proof, root.Head.Root) {
+            t.Errorf("Get(%s) returned a proof that does not verify (exists: %v)", key, resp.Exists)
+        }
+    }
+
+    var leaf GetLeafResponse
+    aliceLeafNo, _ := HashKeyMapper{}.MapKey([]byte("alice"), 257)
+    if status := _testGRPCCall(t, ts.URL, "GetLeaf", "key", &GetLeafRequest{LeafNo: aliceLeafNo}, &leaf); status != grpcOK {
+        t.Fatalf("GetLeaf returned status %d", status)
+    }
+    oldRoot := leaf.Head.Root
+    if !leaf.Exists || leaf.Head.Epoch != 0 || !VerifyMembershipProof(leaf.Proof, oldRoot) {
+        t.Errorf("GetLeaf returned a proof that does not verify against epoch 0")
+    }
+
+    var appendOnly GetAppendOnlyProofResponse
+    byRoots := &GetAppendOnlyProofRequest{FromRoot: oldRoot, ToRoot: root.Head.Root}
+    if status := _testGRPCCall(t, ts.URL, "GetAppendOnlyProof", "key", byRoots, &appendOnly); status != grpcOK {
+        t.Fatalf("GetAppendOnlyProof returned status %d", status)
+    }
+    if appendOnly.OldHead.Epoch != 0 || appendOnly.NewHead.Epoch != 1 || appendOnly.Proof.Verify(oldRoot, root.Head.Root) != nil {
+        t.Errorf("GetAppendOnlyProof returned a proof that does not verify")
+    }
+
+    failures := []struct {
+        method string
+        apiKey string
+        req    protoMarshaler
+        status int
+    }{
+        {"GetRoot", "", &GetRootRequest{}, grpcUnauthenticated},
+        {"GetRoot", "other", &GetRootRequest{}, grpcUnauthenticated},
+        {"Delete", "key", &GetRootRequest{}, grpcUnimplemented},
+        {"Insert", "key", &InsertRequest{}, grpcInvalidArgument},
+        {"Insert", "key", &InsertRequest{Entries: []KeyValue{{nil, []byte("1")}}}, grpcInvalidArgument},
+        {"Get", "key", &GetRequest{}, grpcInvalidArgument},
+        {"GetLeaf", "key", &GetLeafRequest{LeafNo: aliceLeafNo, Epoch: 2}, grpcNotFound},
+        {"GetLeaf", "key", &GetLeafRequest{LeafNo: aliceLeafNo, Namespace: "ns"}, grpcInvalidArgument},
+        {"GetAppendOnlyProof", "key", &GetAppendOnlyProofRequest{FromRoot: [32]byte{1}, ToEpoch: 1}, grpcNotFound},
+        {"GetAppendOnlyProof", "key", &GetAppendOnlyProofRequest{FromEpoch: 1, ToEpoch: 1}, grpcInvalidArgument},
+        {"GetAppendOnlyProof", "key", &GetAppendOnlyProofRequest{FromEpoch: 0, ToEpoch: 2}, grpcNotFound},
+    }
+    for _, failure := range failures {
+        if status := _testGRPCCall(t, ts.URL, failure.method, failure.apiKey, failure.req, &root); status != failure.status {
+            t.Errorf("%s(%+v) returned status %d, expected %d", failure.method, failure.req, status, failure.status)
+        }
+    }
+
+    compressed := []byte{1, 0, 0, 0, 0}
+    if status := _testGRPCCallRaw(t, ts.URL, "GetRoot", "key", compressed, &root); status != grpcUnimplemented {
+        t.Errorf("compressed call returned status %d", status)
+    }
+    truncated := []byte{0, 0, 0, 0, 10, 1}
+    if status := _testGRPCCallRaw(t, ts.URL, "GetRoot", "key", truncated, &root); status != grpcInvalidArgument {
+        t.Errorf("truncated call returned status %d", status)
+    }
+    malformed := []byte{0, 0, 0, 0, 2, 0x08, 0x01}
+    if status := _testGRPCCallRaw(t, ts.URL, "GetRoot", "key", malformed, &root); status != grpcInvalidArgument {
+        t.Errorf("call with an unknown field returned status %d", status)
+    }
+}
@@ -0,0 +1,135 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "hash"
+    "reflect"
+    "runtime"
+    "time"
+)
+
+/**
+ * The hashing pipeline. Hashing dominates insert time, so node hashes avoid per-hash allocations:
+ * _hashWith() hashes from a stack buffer and, for SHA256, via sha256.Sum256(), which needs no
+ * digest state. Independent node hashes (e.g., those of all the nodes of a level being rehashed)
+ * can also be computed in batches via _hashPairs(), which hashes them in parallel lanes when built
+ * with the 'sha256mb' tag (see hashlanes_mb.go).
+ *
+ * The hash-bench command compares these with hashing via a new digest per hash, as we used to.
+ */
+
+// The largest input _hashWith() hashes from the stack: RFC 6962 node hashes take 65 bytes
+const hashBufSize = 128
+
+var sha256NewPtr = reflect.ValueOf(sha256.New).Pointer()
+
+/**
+ * Returns true if 'newHash' is sha256.New. Closures never are, even if they call it, since their
+ * code differs.
+ */
+func _isSHA256(newHash func() hash.Hash) bool {
+    return reflect.ValueOf(newHash).Pointer() == sha256NewPtr
+}
+
+/**
+ * The two children hashes of a node, to be Merkle hashed by _hashPairs().
+ */
+type hashPair struct {
+    left  [32]byte
+    right [32]byte
+}
+
+/**
+ * Merkle hashes every pair with the tree's hash function, storing the hash of pairs[i], which are
+ * the children of the node with LN nodeNos[i] on level 'level', in out[i]. Trees whose hashing
+ * scheme is not plain hash the pairs one after the other, since their hashes are prefixed.
+ */
+func (tree *Tree) _merkleHashPairs(level int, nodeNos [][32]byte, pairs []hashPair, out [][32]byte) {
+    if tree.scheme == HashingPlain {
+        _hashPairs(tree.newHash, tree.rfc6962, pairs, out)
+        return
+    }
+    for i := range pairs {
+        out[i] = tree._nodeHashAt(level, nodeNos[i], pairs[i].left, pairs[i].right)
+    }
+}
+
+/**
+ * Merkle hashes the pairs one after the other, which is what each lane of _hashPairs() does.
+ */
+func _hashPairsSerial(newHash func() hash.Hash, rfc6962 bool, pairs []hashPair, out [][32]byte) {
+    for i := range pairs {
+        out[i] = _nodeHash(newHash, rfc6962, pairs[i].left, pairs[i].right)
+    }
+}
+
+/**
+ * The result of one of the hash-bench command's measurements.
+ */
+type hashBenchResult struct {
+    Name          string
+    NumHashes     int
+    NsecPerHash   float64
+    AllocsPerHash float64
+}
+
+/**
+ * Measures SHA256 node hashing (i.e., hashing two 32-byte hashes) 'numHashes' times in each of
+ * three ways: with a new digest per hash (as _hashWith() used to), with _merkleHash() and with
+ * _hashPairs() in batches of 'batchSize' pairs.
+ */
+func RunHashBench(numHashes int, batchSize int) []hashBenchResult {
+    var results []hashBenchResult
+    measure := func(name string, run func()) {
+        runtime.GC()
+        var memBefore, memAfter runtime.MemStats
+        runtime.ReadMemStats(&memBefore)
+        startTime := time.Now()
+        run()
+        elapsed := time.Since(startTime)
+        runtime.ReadMemStats(&memAfter)
+
+        results = append(results, hashBenchResult{
+            Name:          name,
+            NumHashes:     numHashes,
+            NsecPerHash:   float64(elapsed.Nanoseconds()) / float64(numHashes),
+            AllocsPerHash: float64(memAfter.Mallocs-memBefore.Mallocs) / float64(numHashes),
+        })
+    }
+
+    // Each hash depends on the previous one, so none of them can be skipped
+    measure("digest", func() {
+        var h [32]byte
+        for i := 0; i < numHashes; i++ {
+            h = _hashWithDigest(sha256.New, h[:], h[:])
+        }
+    })
+    measure("pipeline", func() {
+        var h [32]byte
+        for i := 0; i < numHashes; i++ {
+            h = _merkleHash(h, h)
+        }
+    })
+
+    // The pairs of a batch are independent, as they would be on a level, but each batch depends
+    // on the previous one
+    pairs := make([]hashPair, batchSize)
+    out := make([][32]byte, batchSize)
+    for i := range out {
+        out[i][0] = byte(i)
+        out[i][1] = byte(i >> 8)
+    }
+    measure(lanesName, func() {
+        for done := 0; done < numHashes; done += batchSize {
+            n := batchSize
+            if numHashes-done < n {
+                n = numHashes - done
+            }
+            for i := 0; i < n; i++ {
+                pairs[i] = hashPair{out[i], out[(i+1)%batchSize]}
+            }
+            _hashPairs(sha256.New, false, pairs[:n], out[:n])
+        }
+    })
+    return results
+}
@@ -0,0 +1,19 @@
+//go:build !sha256mb
+// +build !sha256mb
+
+package aomt
+
+import (
+    "hash"
+)
+
+// The name of _hashPairs()'s implementation in the hash-bench command's output
+const lanesName = "batched"
+
+/**
+ * Merkle hashes every pair, storing the hash of pairs[i] in out[i]. Without the 'sha256mb' build
+ * tag, the pairs are simply hashed one after the other.
+ */
+func _hashPairs(newHash func() hash.Hash, rfc6962 bool, pairs []hashPair, out [][32]byte) {
+    _hashPairsSerial(newHash, rfc6962, pairs, out)
+}
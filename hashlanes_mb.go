@@ -0,0 +1,48 @@
+//go:build sha256mb
+// +build sha256mb
+
+package aomt
+
+import (
+    "hash"
+    "runtime"
+    "sync"
+)
+
+// The name of _hashPairs()'s implementation in the hash-bench command's output
+const lanesName = "multi-buffer"
+
+// Smaller batches are hashed serially, since starting the lanes would take longer than hashing
+const minMultiBufferPairs = 256
+
+/**
+ * Merkle hashes every pair, storing the hash of pairs[i] in out[i], in parallel lanes: the pairs
+ * are split into one contiguous chunk per CPU, each hashed by its own goroutine.
+ *
+ * NOTE: Go has no portable SIMD, so rather than interleaving several SHA256 states in the vector
+ * registers of one core, as multi-buffer SHA256 implementations do, the lanes are cores. The
+ * crypto/sha256 code each lane calls already uses the CPU's SHA instructions, if any.
+ */
+func _hashPairs(newHash func() hash.Hash, rfc6962 bool, pairs []hashPair, out [][32]byte) {
+    numLanes := runtime.GOMAXPROCS(0)
+    if len(pairs) < minMultiBufferPairs || numLanes == 1 {
+        _hashPairsSerial(newHash, rfc6962, pairs, out)
+        return
+    }
+
+    chunkSize := (len(pairs) + numLanes - 1) / numLanes
+    var wg sync.WaitGroup
+    for start := 0; start < len(pairs); start += chunkSize {
+        end := start + chunkSize
+        if end > len(pairs) {
+            end = len(pairs)
+        }
+
+        wg.Add(1)
+        go func(start int, end int) {
+            defer wg.Done()
+            _hashPairsSerial(newHash, rfc6962, pairs[start:end], out[start:end])
+        }(start, end)
+    }
+    wg.Wait()
+}
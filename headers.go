@@ -0,0 +1,112 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "time"
+)
+
+/**
+ * Every recorded epoch has a header that links it to the previous epoch's header, so that epochs
+ * form a hash chain. A client that remembers the hash of the last header it saw can detect a
+ * server that rolled back to an earlier state (or forked), even without an external log: the
+ * server must then show a chain of headers from the remembered one to its latest one (see
+ * VerifyEpochChain()), which it cannot do for a state that does not extend the remembered one.
+ *
+ * The hash of a header is SHA256(prevHeader || epoch || root || timestamp), where 'prevHeader' is
+ * the hash of the previous epoch's header (all zeros for epoch 0), 'epoch' and 'timestamp' (Unix
+ * nanoseconds) are 8 big-endian bytes, and 'root' is the epoch's root hash. Headers always use
+ * SHA256, regardless of the tree's hash function, as the root log does (see package log).
+ */
+type EpochHeader struct {
+    Epoch     int
+    Root      [32]byte
+    Timestamp time.Time // when the epoch was recorded, never before the previous epoch's
+    Prev      [32]byte  // the hash of the previous epoch's header
+}
+
+/**
+ * Returns the hash of the header, which the next epoch's header links to.
+ */
+func (h EpochHeader) Hash() [32]byte {
+    var buf bytes.Buffer
+    buf.Write(h.Prev[:])
+    binary.Write(&buf, binary.BigEndian, uint64(h.Epoch))
+    buf.Write(h.Root[:])
+    binary.Write(&buf, binary.BigEndian, h.Timestamp.UnixNano())
+    return sha256.Sum256(buf.Bytes())
+}
+
+/**
+ * Returns the header of the specified recorded epoch.
+ */
+func (tree *Tree) GetEpochHeader(epoch int) EpochHeader {
+    if epoch < 0 || epoch >= len(tree.epochs) {
+        panic(fmt.Sprintf("Epoch %d was never recorded (have %d epochs)", epoch, len(tree.epochs)))
+    }
+
+    header := EpochHeader{
+        Epoch:     epoch,
+        Root:      tree.epochs[epoch].root,
+        Timestamp: time.Unix(0, tree.epochs[epoch].timestamp),
+    }
+    if epoch > 0 {
+        header.Prev = tree.epochs[epoch-1].header
+    }
+    return header
+}
+
+/**
+ * Checks that the headers are a chain of consecutive epochs, i.e., that each header links to the
+ * previous one and is not older than it. The chain can start at any epoch, but one that starts at
+ * epoch 0 must not link to a previous header. To detect a rollback, a client checks that the
+ * first header's hash is the one it remembers and then remembers the last header's hash.
+ */
+func VerifyEpochChain(headers []EpochHeader) error {
+    if len(headers) == 0 {
+        return errors.New("empty epoch chain")
+    }
+    if headers[0].Epoch < 0 {
+        return fmt.Errorf("invalid epoch %d", headers[0].Epoch)
+    }
+    if headers[0].Epoch == 0 && headers[0].Prev != ([32]byte{}) {
+        return errors.New("the header of epoch 0 links to a previous header")
+    }
+
+    for i := 1; i < len(headers); i++ {
+        prev, h := headers[i-1], headers[i]
+        if h.Epoch != prev.Epoch+1 {
+            return fmt.Errorf("the chain skips from epoch %d to epoch %d", prev.Epoch, h.Epoch)
+        }
+        if !hashEqual(h.Prev, prev.Hash()) {
+            return fmt.Errorf("the header of epoch %d does not link to the header of epoch %d", h.Epoch, prev.Epoch)
+        }
+        if h.Timestamp.Before(prev.Timestamp) {
+            return fmt.Errorf("epoch %d was recorded before epoch %d", h.Epoch, prev.Epoch)
+        }
+    }
+    return nil
+}
+
+/**
+ * Sets the timestamp and header hash of the epoch that is about to be appended to the tree's
+ * epochs. The timestamp is 'now', unless the previous epoch is newer (e.g., after the clock was
+ * set back), so that headers never go back in time.
+ */
+func (tree *Tree) _linkEpochHeader(record *epochRecord, now time.Time) {
+    record.timestamp = now.UnixNano()
+
+    var prev [32]byte
+    if n := len(tree.epochs); n > 0 {
+        prev = tree.epochs[n-1].header
+        if record.timestamp < tree.epochs[n-1].timestamp {
+            record.timestamp = tree.epochs[n-1].timestamp
+        }
+    }
+
+    header := EpochHeader{Epoch: len(tree.epochs), Root: record.root, Timestamp: time.Unix(0, record.timestamp), Prev: prev}
+    record.header = header.Hash()
+}
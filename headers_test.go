@@ -0,0 +1,74 @@
+package aomt
+
+import (
+    "bytes"
+    "testing"
+    "time"
+)
+
+/**
+ * Returns the headers of the tree's epochs from..to.
+ */
+func _testEpochHeaders(tree *Tree, from int, to int) []EpochHeader {
+    var headers []EpochHeader
+    for e := from; e <= to; e++ {
+        headers = append(headers, tree.GetEpochHeader(e))
+    }
+    return headers
+}
+
+/**
+ * The headers of a tree's epochs must form a chain, also from any epoch on and after reloading
+ * the tree, and must not once a header is changed, dropped or goes back in time.
+ */
+func TestEpochHeadersFormChain(t *testing.T) {
+    tree := _testEpochTree(4)
+    // Epochs recorded with a clock set back are not older than the previous epoch
+    tree._recordEpochAt(time.Unix(0, 0))
+    last := tree.NumEpochs() - 1
+    headers := _testEpochHeaders(tree, 0, last)
+    if err := VerifyEpochChain(headers); err != nil {
+        t.Fatalf("chain does not verify: %v", err)
+    }
+    if err := VerifyEpochChain(headers[2:]); err != nil {
+        t.Errorf("chain from epoch 2 does not verify: %v", err)
+    }
+    for e, h := range headers {
+        if h.Epoch != e || h.Root != tree.GetEpochRoot(e) {
+            t.Errorf("header of epoch %d is for epoch %d with root %s", e, h.Epoch, hashStr(h.Root))
+        }
+    }
+
+    tampers := map[string]func(h []EpochHeader) []EpochHeader{
+        "root changed":   func(h []EpochHeader) []EpochHeader { h[1].Root[0] ^= 1; return h },
+        "time changed":   func(h []EpochHeader) []EpochHeader { h[1].Timestamp = h[1].Timestamp.Add(1); return h },
+        "epoch dropped":  func(h []EpochHeader) []EpochHeader { return append(h[:1], h[2:]...) },
+        "epochs swapped": func(h []EpochHeader) []EpochHeader { h[1], h[2] = h[2], h[1]; return h },
+        "back in time": func(h []EpochHeader) []EpochHeader {
+            h[2].Timestamp = h[1].Timestamp.Add(-1)
+            h[3].Prev = h[2].Hash()
+            return h
+        },
+        "link before epoch 0": func(h []EpochHeader) []EpochHeader { h[0].Prev[0] ^= 1; return h },
+    }
+    for name, tamper := range tampers {
+        tampered := tamper(append([]EpochHeader{}, headers...))
+        if err := VerifyEpochChain(tampered); err == nil {
+            t.Errorf("chain verifies with %s", name)
+        }
+    }
+
+    var buf bytes.Buffer
+    if err := tree.Snapshot(&buf); err != nil {
+        t.Fatal(err)
+    }
+    loaded, err := LoadSnapshot(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for e := 0; e <= last; e++ {
+        if loaded.GetEpochHeader(e) != headers[e] {
+            t.Errorf("loaded tree has another header for epoch %d", e)
+        }
+    }
+}
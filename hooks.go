@@ -0,0 +1,38 @@
+package aomt
+
+/**
+ * Applications can keep their own state in sync with the tree's leaves (e.g., a username to leaf
+ * index, metrics or a replication stream) via insert hooks rather than by wrapping every way of
+ * inserting leaves: the tree calls its hooks for every leaf inserted via Insert(), InsertValue(),
+ * InsertCommitted(), InsertBatchParallel(), an open Epoch or Merge().
+ *
+ * Hooks are called synchronously, after the leaf is in the tree, in the order they were added,
+ * and must not change the tree. Updates to existing leaves are not reported.
+ *
+ * NOTE: Hooks are not copied to other trees, so trees built from this one (e.g., via TreeAtEpoch()
+ * or LoadSnapshot()) have none. Leaves replayed from a WAL (see WAL::Replay()) into a tree with
+ * hooks are reported as they are re-inserted, which can be used to rebuild an index on startup.
+ */
+
+/**
+ * Called with the inserted leaf, its data hash and the epoch it is inserted in, i.e., the epoch
+ * that the next RecordEpoch() (or Epoch::Commit()) records.
+ */
+type InsertHook func(leafNo [32]byte, dataHash [32]byte, epoch uint64)
+
+/**
+ * Adds a hook that is called for every leaf inserted from now on.
+ */
+func (tree *Tree) OnInsert(hook InsertHook) {
+    tree.insertHooks = append(tree.insertHooks, hook)
+}
+
+/**
+ * Calls the insert hooks for a leaf that was just inserted.
+ */
+func (tree *Tree) _notifyInsert(leafNo [32]byte, dataHash [32]byte) {
+    epoch := uint64(len(tree.epochs))
+    for _, hook := range tree.insertHooks {
+        hook(leafNo, dataHash, epoch)
+    }
+}
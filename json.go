@@ -0,0 +1,485 @@
+package aomt
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+)
+
+/**
+ * The JSON encoding of proofs and tree heads, e.g., for embedding them in HTTP APIs and logs. The
+ * encoding is canonical, so the same proof is always encoded to the same bytes:
+ *
+ *  - hashes, LNs and byte strings are lowercase hex strings (hashes and LNs always have 64 digits)
+ *  - fields are always in the order below, and lists are never omitted (empty lists are [])
+ *  - optional fields (openings, values, extended leaves and appended hashes) are omitted when absent,
+ *    and null in lists
+ *
+ *   TreeHead:             {"epoch", "root"}
+ *   Opening:              {"salt", "value"}
+ *   ExtendedLeaf:         {"dataHash", "timestamp", "expiry", "policy", "epoch"?}
+ *   MembershipProof:      {"leaf", "leafHash", "siblings", "opening"?, "value"?, "extended"?}
+ *   MultiMembershipProof: {"leaves", "leafHashes", "openings", "siblings"}
+ *   LabelProof:           {"label", "vrfProof", "membership"}
+ *   ProofNode:            {"level", "ln", "hash", "isNew"?, "appended"?}
+ *   AppendOnlyProof:      {"nodes"}
+ *   ProofEnvelope:        {"paramsDigest", "fromEpoch", "toEpoch", "oldRoot", "newRoot", "proof"}
+ *
+ * Decoding is strict: unknown fields, uppercase hex and hashes of the wrong length are rejected,
+ * so that a decoded proof always re-encodes to the same bytes.
+ */
+
+/**
+ * The root hash of the tree at the end of an epoch, i.e., what a client needs to check proofs
+ * about that epoch.
+ */
+type TreeHead struct {
+    Epoch int
+    Root  [32]byte
+}
+
+/**
+ * Returns the head of the specified recorded epoch.
+ */
+func (tree *Tree) GetTreeHead(epoch int) TreeHead {
+    return TreeHead{Epoch: epoch, Root: tree.GetEpochRoot(epoch)}
+}
+
+type treeHeadJSON struct {
+    Epoch int    `json:"epoch"`
+    Root  string `json:"root"`
+}
+
+func (head TreeHead) MarshalJSON() ([]byte, error) {
+    return json.Marshal(treeHeadJSON{Epoch: head.Epoch, Root: hashStr(head.Root)})
+}
+
+func (head *TreeHead) UnmarshalJSON(data []byte) error {
+    var v treeHeadJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+    if v.Epoch < 0 {
+        return fmt.Errorf("invalid epoch %d", v.Epoch)
+    }
+
+    root, err := _hashFromJSON("root", v.Root)
+    if err != nil {
+        return err
+    }
+    *head = TreeHead{Epoch: v.Epoch, Root: root}
+    return nil
+}
+
+type openingJSON struct {
+    Salt  string `json:"salt"`
+    Value string `json:"value"`
+}
+
+func (opening Opening) MarshalJSON() ([]byte, error) {
+    return json.Marshal(openingJSON{Salt: hashStr(opening.Salt), Value: hex.EncodeToString(opening.Value)})
+}
+
+func (opening *Opening) UnmarshalJSON(data []byte) error {
+    var v openingJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+
+    salt, err := _hashFromJSON("salt", v.Salt)
+    if err != nil {
+        return err
+    }
+    value, err := _bytesFromJSON("value", v.Value)
+    if err != nil {
+        return err
+    }
+    *opening = Opening{Salt: salt, Value: value}
+    return nil
+}
+
+type extendedLeafJSON struct {
+    DataHash  string `json:"dataHash"`
+    Timestamp uint64 `json:"timestamp"`
+    Expiry    uint64 `json:"expiry"`
+    Policy    string  `json:"policy"`
+    Epoch     *uint64 `json:"epoch,omitempty"`
+}
+
+func (leaf ExtendedLeaf) MarshalJSON() ([]byte, error) {
+    return json.Marshal(extendedLeafJSON{
+        DataHash:  hashStr(leaf.DataHash),
+        Timestamp: leaf.Extensions.Timestamp,
+        Expiry:    leaf.Extensions.Expiry,
+        Policy:    hex.EncodeToString(leaf.Extensions.Policy),
+        Epoch:     leaf.Extensions.Epoch,
+    })
+}
+
+func (leaf *ExtendedLeaf) UnmarshalJSON(data []byte) error {
+    var v extendedLeafJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+
+    dataHash, err := _hashFromJSON("dataHash", v.DataHash)
+    if err != nil {
+        return err
+    }
+    policy, err := _bytesFromJSON("policy", v.Policy)
+    if err != nil {
+        return err
+    }
+    if len(policy) == 0 {
+        policy = nil
+    }
+    *leaf = ExtendedLeaf{DataHash: dataHash, Extensions: LeafExtensions{Timestamp: v.Timestamp, Expiry: v.Expiry, Policy: policy, Epoch: v.Epoch}}
+    return nil
+}
+
+type membershipProofJSON struct {
+    Leaf     string        `json:"leaf"`
+    LeafHash string        `json:"leafHash"`
+    Siblings []string      `json:"siblings"`
+    Opening  *Opening      `json:"opening,omitempty"`
+    Value    *string       `json:"value,omitempty"` // hex-encoded, a pointer so that empty values are kept
+    Extended *ExtendedLeaf `json:"extended,omitempty"`
+}
+
+func (proof MembershipProof) MarshalJSON() ([]byte, error) {
+    return json.Marshal(membershipProofJSON{
+        Leaf:     hashStr(proof.LeafNo),
+        LeafHash: hashStr(proof.LeafHash),
+        Siblings: _hashesToJSON(proof.Siblings),
+        Opening:  proof.Opening,
+        Value:    _valueToJSON(proof.Value),
+        Extended: proof.Extended,
+    })
+}
+
+func (proof *MembershipProof) UnmarshalJSON(data []byte) error {
+    var v membershipProofJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+
+    var p MembershipProof
+    var err error
+    if p.LeafNo, err = _hashFromJSON("leaf", v.Leaf); err != nil {
+        return err
+    }
+    if p.LeafHash, err = _hashFromJSON("leafHash", v.LeafHash); err != nil {
+        return err
+    }
+    if p.Siblings, err = _hashesFromJSON("siblings", v.Siblings); err != nil {
+        return err
+    }
+    p.Opening = v.Opening
+    if p.Value, err = _valueFromJSON(v.Value); err != nil {
+        return err
+    }
+    p.Extended = v.Extended
+    *proof = p
+    return nil
+}
+
+func _valueToJSON(value []byte) *string {
+    if value == nil {
+        return nil
+    }
+    s := hex.EncodeToString(value)
+    return &s
+}
+
+func _valueFromJSON(s *string) ([]byte, error) {
+    if s == nil {
+        return nil, nil
+    }
+    value, err := _bytesFromJSON("value", *s)
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte{}, value...), nil
+}
+
+type multiMembershipProofJSON struct {
+    Leaves     []string   `json:"leaves"`
+    LeafHashes []string   `json:"leafHashes"`
+    Openings   []*Opening `json:"openings"`
+    Siblings   []string   `json:"siblings"`
+}
+
+func (proof MultiMembershipProof) MarshalJSON() ([]byte, error) {
+    openings := proof.Openings
+    if openings == nil {
+        openings = []*Opening{}
+    }
+    return json.Marshal(multiMembershipProofJSON{
+        Leaves:     _hashesToJSON(proof.LeafNos),
+        LeafHashes: _hashesToJSON(proof.LeafHashes),
+        Openings:   openings,
+        Siblings:   _hashesToJSON(proof.Siblings),
+    })
+}
+
+func (proof *MultiMembershipProof) UnmarshalJSON(data []byte) error {
+    var v multiMembershipProofJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+
+    var p MultiMembershipProof
+    var err error
+    if p.LeafNos, err = _hashesFromJSON("leaves", v.Leaves); err != nil {
+        return err
+    }
+    if p.LeafHashes, err = _hashesFromJSON("leafHashes", v.LeafHashes); err != nil {
+        return err
+    }
+    if p.Siblings, err = _hashesFromJSON("siblings", v.Siblings); err != nil {
+        return err
+    }
+    p.Openings = v.Openings
+    *proof = p
+    return nil
+}
+
+type labelProofJSON struct {
+    Label      string           `json:"label"`
+    VRFProof   string           `json:"vrfProof"`
+    Membership *MembershipProof `json:"membership"`
+}
+
+func (proof LabelProof) MarshalJSON() ([]byte, error) {
+    return json.Marshal(labelProofJSON{
+        Label:      hex.EncodeToString(proof.Label),
+        VRFProof:   hex.EncodeToString(proof.VRFProof),
+        Membership: proof.Membership,
+    })
+}
+
+func (proof *LabelProof) UnmarshalJSON(data []byte) error {
+    var v labelProofJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+
+    var p LabelProof
+    var err error
+    if p.Label, err = _bytesFromJSON("label", v.Label); err != nil {
+        return err
+    }
+    if p.VRFProof, err = _bytesFromJSON("vrfProof", v.VRFProof); err != nil {
+        return err
+    }
+    if v.Membership == nil {
+        return fmt.Errorf("missing 'membership'")
+    }
+    p.Membership = v.Membership
+    *proof = p
+    return nil
+}
+
+type proofNodeJSON struct {
+    Level    int      `json:"level"`
+    LN       string   `json:"ln"`
+    Hash     string   `json:"hash"`
+    IsNew    bool     `json:"isNew,omitempty"`
+    Appended []string `json:"appended,omitempty"`
+}
+
+func (node ProofNode) MarshalJSON() ([]byte, error) {
+    v := proofNodeJSON{Level: node.Level, LN: hashStr(node.NodeNo), Hash: hashStr(node.Hash), IsNew: node.IsNew}
+    if len(node.Appended) > 0 {
+        v.Appended = _hashesToJSON(node.Appended)
+    }
+    return json.Marshal(v)
+}
+
+func (node *ProofNode) UnmarshalJSON(data []byte) error {
+    var v proofNodeJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+    if v.Level < 0 || v.Level > 256 {
+        return fmt.Errorf("invalid level %d", v.Level)
+    }
+
+    n := ProofNode{Level: v.Level, IsNew: v.IsNew}
+    var err error
+    if n.NodeNo, err = _hashFromJSON("ln", v.LN); err != nil {
+        return err
+    }
+    // A level-L node's LN has at most L bits
+    if !_fitsInLevel(n.NodeNo, v.Level) {
+        return fmt.Errorf("LN %s is too large for level %d", v.LN, v.Level)
+    }
+    if n.Hash, err = _hashFromJSON("hash", v.Hash); err != nil {
+        return err
+    }
+    // Appended hashes are omitted rather than empty
+    if v.Appended != nil && len(v.Appended) == 0 {
+        return fmt.Errorf("'appended' must be omitted rather than empty")
+    }
+    if n.Appended, err = _hashesFromJSON("appended", v.Appended); err != nil {
+        return err
+    }
+    if len(n.Appended) == 0 {
+        n.Appended = nil
+    }
+    *node = n
+    return nil
+}
+
+type appendOnlyProofJSON struct {
+    Nodes []ProofNode `json:"nodes"`
+}
+
+func (proof AppendOnlyProof) MarshalJSON() ([]byte, error) {
+    nodes := proof.Nodes
+    if nodes == nil {
+        nodes = []ProofNode{}
+    }
+    return json.Marshal(appendOnlyProofJSON{Nodes: nodes})
+}
+
+func (proof *AppendOnlyProof) UnmarshalJSON(data []byte) error {
+    var v appendOnlyProofJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+    if v.Nodes == nil {
+        return fmt.Errorf("missing 'nodes'")
+    }
+
+    // Same order as NewAppendOnlyProof() and the binary encoding
+    for i := 1; i < len(v.Nodes); i++ {
+        prev, cur := &v.Nodes[i-1], &v.Nodes[i]
+        if prev.Level > cur.Level || (prev.Level == cur.Level && bytes.Compare(prev.NodeNo[:], cur.NodeNo[:]) >= 0) {
+            return fmt.Errorf("proof nodes are not sorted by level and then by LN")
+        }
+    }
+
+    proof.Nodes = v.Nodes
+    if len(proof.Nodes) == 0 {
+        proof.Nodes = nil
+    }
+    return nil
+}
+
+type proofEnvelopeJSON struct {
+    ParamsDigest string           `json:"paramsDigest"`
+    FromEpoch    int              `json:"fromEpoch"`
+    ToEpoch      int              `json:"toEpoch"`
+    OldRoot      string           `json:"oldRoot"`
+    NewRoot      string           `json:"newRoot"`
+    Proof        *AppendOnlyProof `json:"proof"`
+}
+
+func (env ProofEnvelope) MarshalJSON() ([]byte, error) {
+    proof := env.Proof
+    if proof == nil {
+        proof = &AppendOnlyProof{}
+    }
+    return json.Marshal(proofEnvelopeJSON{
+        ParamsDigest: hashStr(env.ParamsDigest),
+        FromEpoch:    env.FromEpoch,
+        ToEpoch:      env.ToEpoch,
+        OldRoot:      hashStr(env.OldRoot),
+        NewRoot:      hashStr(env.NewRoot),
+        Proof:        proof,
+    })
+}
+
+func (env *ProofEnvelope) UnmarshalJSON(data []byte) error {
+    var v proofEnvelopeJSON
+    if err := _unmarshalStrict(data, &v); err != nil {
+        return err
+    }
+    if v.FromEpoch < 0 || v.ToEpoch < 0 {
+        return fmt.Errorf("invalid epoch range %d to %d", v.FromEpoch, v.ToEpoch)
+    }
+    if v.Proof == nil {
+        return fmt.Errorf("missing 'proof'")
+    }
+
+    e := ProofEnvelope{FromEpoch: v.FromEpoch, ToEpoch: v.ToEpoch, Proof: v.Proof}
+    var err error
+    if e.ParamsDigest, err = _hashFromJSON("paramsDigest", v.ParamsDigest); err != nil {
+        return err
+    }
+    if e.OldRoot, err = _hashFromJSON("oldRoot", v.OldRoot); err != nil {
+        return err
+    }
+    if e.NewRoot, err = _hashFromJSON("newRoot", v.NewRoot); err != nil {
+        return err
+    }
+    *env = e
+    return nil
+}
+
+/**
+ * Like json.Unmarshal(), but rejects unknown fields and trailing data.
+ */
+func _unmarshalStrict(data []byte, v interface{}) error {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(v); err != nil {
+        return err
+    }
+    if dec.More() {
+        return fmt.Errorf("trailing data after JSON value")
+    }
+    return nil
+}
+
+func _hashesToJSON(hashes [][32]byte) []string {
+    strs := make([]string, 0, len(hashes))
+    for _, h := range hashes {
+        strs = append(strs, hashStr(h))
+    }
+    return strs
+}
+
+func _hashesFromJSON(field string, strs []string) ([][32]byte, error) {
+    var hashes [][32]byte
+    for i, s := range strs {
+        h, err := _hashFromJSON(fmt.Sprintf("%s[%d]", field, i), s)
+        if err != nil {
+            return nil, err
+        }
+        hashes = append(hashes, h)
+    }
+    return hashes, nil
+}
+
+func _hashFromJSON(field string, s string) ([32]byte, error) {
+    var h [32]byte
+    b, err := _bytesFromJSON(field, s)
+    if err != nil {
+        return h, err
+    }
+    if len(b) != 32 {
+        return h, fmt.Errorf("'%s' must be 32 bytes, got %d", field, len(b))
+    }
+    copy(h[:], b)
+    return h, nil
+}
+
+/**
+ * Decodes lowercase hex, so that every byte string has exactly one encoding.
+ */
+func _bytesFromJSON(field string, s string) ([]byte, error) {
+    for _, c := range s {
+        if c >= 'A' && c <= 'F' {
+            return nil, fmt.Errorf("'%s' must be lowercase hex", field)
+        }
+    }
+
+    b, err := hex.DecodeString(s)
+    if err != nil {
+        return nil, fmt.Errorf("'%s' is not hex: %v", field, err)
+    }
+    return b, nil
+}
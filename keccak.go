@@ -0,0 +1,161 @@
+package aomt
+
+import (
+    "encoding/binary"
+    "hash"
+    "math/bits"
+)
+
+/**
+ * Keccak-256, as used by Ethereum (i.e., with the original Keccak padding rather than SHA3-256's),
+ * so that trees can hash their nodes the way smart contracts do via keccak256() (see solidity.go):
+ *
+ *   tree, err := NewTreeWithOptions(257, TreeOptions{NewHash: NewKeccak256})
+ *
+ * The standard library has no Keccak, so, as with the VRF, we implement it here rather than depend
+ * on golang.org/x/crypto/sha3.
+ */
+const (
+    keccak256Size = 32
+    keccak256Rate = 136 // the # of bytes absorbed per permutation, i.e., 1600 - 2 * 256 bits
+)
+
+var keccakRoundConstants = [24]uint64{
+    0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+    0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+    0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+    0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+    0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+    0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// The rotation offset of lane (x, y) in the rho step, at index x + 5 * y
+var keccakRotations = [25]int{
+    0, 1, 62, 28, 27,
+    36, 44, 6, 55, 20,
+    3, 10, 43, 25, 39,
+    41, 45, 15, 21, 8,
+    18, 2, 61, 56, 14,
+}
+
+type keccak256 struct {
+    state [25]uint64 // lane (x, y) is at index x + 5 * y
+    buf   [keccak256Rate]byte
+    n     int // the # of bytes in 'buf' that were not absorbed yet
+}
+
+/**
+ * Returns a new Keccak-256 hash.
+ */
+func NewKeccak256() hash.Hash {
+    return &keccak256{}
+}
+
+/**
+ * Returns the Keccak-256 hash of the concatenation of 'parts'.
+ */
+func Keccak256(parts ...[]byte) [32]byte {
+    h := &keccak256{}
+    for _, part := range parts {
+        h.Write(part)
+    }
+    var digest [32]byte
+    h._finish(digest[:0])
+    return digest
+}
+
+func (h *keccak256) Write(p []byte) (int, error) {
+    written := len(p)
+    for len(p) > 0 {
+        n := copy(h.buf[h.n:], p)
+        h.n += n
+        p = p[n:]
+        if h.n == keccak256Rate {
+            h._absorb()
+        }
+    }
+    return written, nil
+}
+
+/**
+ * Appends the hash of the data written so far to 'b', without changing the hash's state.
+ */
+func (h *keccak256) Sum(b []byte) []byte {
+    dup := *h
+    return dup._finish(b)
+}
+
+func (h *keccak256) Reset() {
+    *h = keccak256{}
+}
+
+func (h *keccak256) Size() int {
+    return keccak256Size
+}
+
+func (h *keccak256) BlockSize() int {
+    return keccak256Rate
+}
+
+/**
+ * Pads and absorbs the last block and appends the digest to 'b'.
+ */
+func (h *keccak256) _finish(b []byte) []byte {
+    for i := h.n; i < keccak256Rate; i++ {
+        h.buf[i] = 0
+    }
+    h.buf[h.n] ^= 0x01
+    h.buf[keccak256Rate-1] ^= 0x80
+    h._absorb()
+
+    var digest [keccak256Size]byte
+    for i := 0; i < keccak256Size/8; i++ {
+        binary.LittleEndian.PutUint64(digest[8*i:], h.state[i])
+    }
+    return append(b, digest[:]...)
+}
+
+func (h *keccak256) _absorb() {
+    for i := 0; i < keccak256Rate/8; i++ {
+        h.state[i] ^= binary.LittleEndian.Uint64(h.buf[8*i:])
+    }
+    _keccakF1600(&h.state)
+    h.n = 0
+}
+
+/**
+ * The Keccak-f[1600] permutation.
+ */
+func _keccakF1600(a *[25]uint64) {
+    var b [25]uint64
+    var c, d [5]uint64
+    for round := 0; round < 24; round++ {
+        // Theta
+        for x := 0; x < 5; x++ {
+            c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+        }
+        for x := 0; x < 5; x++ {
+            d[x] = c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+        }
+        for i := range a {
+            a[i] ^= d[i%5]
+        }
+
+        // Rho and pi: lane (x, y) is rotated and moved to (y, 2x + 3y)
+        for x := 0; x < 5; x++ {
+            for y := 0; y < 5; y++ {
+                b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], keccakRotations[x+5*y])
+            }
+        }
+
+        // Chi
+        for y := 0; y < 25; y += 5 {
+            for x := 0; x < 5; x++ {
+                a[y+x] = b[y+x] ^ (^b[y+(x+1)%5] & b[y+(x+2)%5])
+            }
+        }
+
+        // Iota
+        a[0] ^= keccakRoundConstants[round]
+    }
+}
@@ -0,0 +1,88 @@
+package aomt
+
+import (
+    "bytes"
+    "encoding/hex"
+    "strings"
+    "testing"
+)
+
+/**
+ * Known answers of Keccak-256 (with the original 0x01 padding, as in Ethereum, not SHA3-256's),
+ * including the messages one byte short of, exactly and one byte over a block.
+ */
+var keccakVectors = []struct {
+    message []byte
+    digest  string
+}{
+    {[]byte(""), "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+    {[]byte("abc"), "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+    {[]byte("hello world"), "47173285a8d7341e5e972fc677286384f802f8ef42a5ec5f03bbfa254cb01fad"},
+    {[]byte("The quick brown fox jumps over the lazy dog"), "4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+    // The first 4 bytes are the Solidity selector of ERC-20's transfer()
+    {[]byte("transfer(address,uint256)"), "a9059cbb2ab09eb219583f4a59a5d0623ade346d962bcd4e46b11da047c9049b"},
+    {[]byte(strings.Repeat("a", 135)), "34367dc248bbd832f4e3e69dfaac2f92638bd0bbd18f2912ba4ef454919cf446"},
+    {[]byte(strings.Repeat("a", 136)), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+    {[]byte(strings.Repeat("a", 137)), "d869f639c7046b4929fc92a4d988a8b22c55fbadb802c0c66ebcd484f1915f39"},
+}
+
+func TestKeccak256Vectors(t *testing.T) {
+    for _, vector := range keccakVectors {
+        if digest := Keccak256(vector.message); hex.EncodeToString(digest[:]) != vector.digest {
+            t.Errorf("Keccak256(%d bytes) = %x, expected %s", len(vector.message), digest, vector.digest)
+        }
+
+        // Writing the message in pieces of any size must not change the digest
+        for _, piece := range []int{1, 7, 64, 135} {
+            h := NewKeccak256()
+            for rest := vector.message; len(rest) > 0; {
+                n := piece
+                if n > len(rest) {
+                    n = len(rest)
+                }
+                h.Write(rest[:n])
+                rest = rest[n:]
+            }
+            if digest := h.Sum(nil); hex.EncodeToString(digest) != vector.digest {
+                t.Errorf("Keccak256(%d bytes) in pieces of %d bytes = %x, expected %s", len(vector.message), piece, digest, vector.digest)
+            }
+        }
+    }
+}
+
+/**
+ * Sum() must append to its argument without changing the hash's state, and Reset() must clear it.
+ * The 1024-byte message is 0, 1, ..., 255, four times.
+ */
+func TestKeccak256State(t *testing.T) {
+    long := make([]byte, 1024)
+    expected := "5902e53903be0d0f9656bdbd5b9f0d8c2d815f865645d629eef77f5185f6cd7f"
+    for i := range long {
+        long[i] = byte(i)
+    }
+
+    h := NewKeccak256()
+    h.Write(long[:500])
+    first := h.Sum([]byte("prefix"))
+    if string(first[:6]) != "prefix" || len(first) != 6+32 {
+        t.Fatalf("Sum() did not append the digest")
+    }
+    if again := h.Sum(nil); !bytes.Equal(again, first[6:]) {
+        t.Errorf("Sum() changed the hash's state")
+    }
+    h.Write(long[500:])
+    if digest := h.Sum(nil); hex.EncodeToString(digest) != expected {
+        t.Errorf("Keccak256(1024 bytes) = %x, expected %s", digest, expected)
+    }
+    if digest := Keccak256(long[:100], long[100:]); hex.EncodeToString(digest[:]) != expected {
+        t.Errorf("Keccak256() of 2 parts = %x, expected %s", digest, expected)
+    }
+
+    h.Reset()
+    if digest := h.Sum(nil); hex.EncodeToString(digest) != keccakVectors[0].digest {
+        t.Errorf("reset hash has digest %x, expected the empty message's", digest)
+    }
+    if h.Size() != 32 || h.BlockSize() != 136 {
+        t.Errorf("Keccak-256 has size %d and block size %d", h.Size(), h.BlockSize())
+    }
+}
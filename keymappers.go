@@ -0,0 +1,249 @@
+package aomt
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "hash"
+)
+
+/**
+ * How a tree maps application keys (e.g., usernames) to leaf nos, configured via
+ * TreeOptions::KeyMapper and used by Tree::InsertKey(), Tree::ProveKey() and friends:
+ *
+ *   HashKeyMapper       leaf no = H(key), so anyone can map keys (what the CLI does)
+ *   HMACKeyMapper       leaf no = HMAC(secret, key), so only the server can map keys, and clients
+ *                       must trust it to, but proofs do not reveal which keys are in the tree
+ *   VRFKeyMapper        leaf no = VRF(key), as for labels (see Tree::InsertLabel()): only the
+ *                       server can map keys, but clients can check the mapping via the VRF proof
+ *   PrefixKeyMapper     leaf no = the key itself, for shallow trees whose keys are short (e.g.,
+ *                       32-bit IDs), or for keys whose common prefixes should share subtrees
+ *
+ * Leaf nos are truncated to the top numLevels - 1 bits of the mapping's 256-bit output, so they
+ * fit in trees with fewer levels.
+ *
+ * A mapping can come with a proof that it is correct (e.g., the VRF proof), which is included in
+ * the key's proofs (see KeyProof), and checked via a KeyVerifier, since verifiers may not be able
+ * to map keys themselves (e.g., they do not have the VRF's private key).
+ */
+type KeyMapper interface {
+    // Returns the leaf no of 'key' in a tree with 'numLevels' levels, and the proof that it is
+    // correct, if any
+    MapKey(key []byte, numLevels int) ([32]byte, []byte)
+}
+
+/**
+ * Checks the mapping of a key to its leaf no.
+ */
+type KeyVerifier interface {
+    // Returns the leaf no of 'key' in a tree with 'numLevels' levels, given the mapping's proof
+    VerifyKey(key []byte, mappingProof []byte, numLevels int) ([32]byte, error)
+}
+
+/**
+ * Truncates a 256-bit mapping output to a leaf no of a tree with 'numLevels' levels, i.e., keeps
+ * its top numLevels - 1 bits (see _vrfLeafNo()).
+ */
+func _truncateLeafNo(output [32]byte, numLevels int) [32]byte {
+    return _vrfLeafNo(output, numLevels)
+}
+
+/**
+ * Maps keys to leaf nos by hashing them. Also a KeyVerifier, since there is nothing to prove.
+ */
+type HashKeyMapper struct {
+    NewHash func() hash.Hash // SHA256 if nil
+}
+
+func (mapper HashKeyMapper) MapKey(key []byte, numLevels int) ([32]byte, []byte) {
+    newHash := mapper.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    return _truncateLeafNo(_hashWith(newHash, key), numLevels), nil
+}
+
+func (mapper HashKeyMapper) VerifyKey(key []byte, mappingProof []byte, numLevels int) ([32]byte, error) {
+    if len(mappingProof) != 0 {
+        return [32]byte{}, errors.New("hashed keys have no mapping proofs")
+    }
+    leafNo, _ := mapper.MapKey(key, numLevels)
+    return leafNo, nil
+}
+
+/**
+ * Maps keys to leaf nos via HMAC-SHA256 with a server secret. Also a KeyVerifier, but only for
+ * those who know the secret (e.g., the server's own auditors).
+ */
+type HMACKeyMapper struct {
+    Secret []byte
+}
+
+func (mapper HMACKeyMapper) MapKey(key []byte, numLevels int) ([32]byte, []byte) {
+    mac := hmac.New(sha256.New, mapper.Secret)
+    mac.Write(key)
+
+    var output [32]byte
+    copy(output[:], mac.Sum(nil))
+    return _truncateLeafNo(output, numLevels), nil
+}
+
+func (mapper HMACKeyMapper) VerifyKey(key []byte, mappingProof []byte, numLevels int) ([32]byte, error) {
+    if len(mappingProof) != 0 {
+        return [32]byte{}, errors.New("HMAC-mapped keys have no mapping proofs")
+    }
+    leafNo, _ := mapper.MapKey(key, numLevels)
+    return leafNo, nil
+}
+
+/**
+ * Maps keys to leaf nos via a VRF, whose proofs clients check via VRFKeyVerifier.
+ */
+type VRFKeyMapper struct {
+    Key *VRFPrivateKey
+}
+
+func (mapper VRFKeyMapper) MapKey(key []byte, numLevels int) ([32]byte, []byte) {
+    output, vrfProof := mapper.Key.Prove(key)
+    return _truncateLeafNo(output, numLevels), vrfProof
+}
+
+/**
+ * Checks the VRF proofs of keys mapped via VRFKeyMapper.
+ */
+type VRFKeyVerifier struct {
+    PK *VRFPublicKey
+}
+
+func (verifier VRFKeyVerifier) VerifyKey(key []byte, mappingProof []byte, numLevels int) ([32]byte, error) {
+    output, err := verifier.PK.Verify(key, mappingProof)
+    if err != nil {
+        return [32]byte{}, err
+    }
+    return _truncateLeafNo(output, numLevels), nil
+}
+
+/**
+ * Maps keys to leaf nos by using them as leaf nos: the key, zero-padded (or truncated) to 32 bytes,
+ * is the leaf no's top bits. Keys longer than the tree's leaf nos are rejected, rather than
+ * truncated, unless 'Truncate' is set, since keys with the same prefix would share a leaf. Also a
+ * KeyVerifier.
+ */
+type PrefixKeyMapper struct {
+    Truncate bool
+}
+
+func (mapper PrefixKeyMapper) MapKey(key []byte, numLevels int) ([32]byte, []byte) {
+    leafNo, err := mapper._leafNo(key, numLevels)
+    if err != nil {
+        panic(err.Error())
+    }
+    return leafNo, nil
+}
+
+func (mapper PrefixKeyMapper) VerifyKey(key []byte, mappingProof []byte, numLevels int) ([32]byte, error) {
+    if len(mappingProof) != 0 {
+        return [32]byte{}, errors.New("prefix-mapped keys have no mapping proofs")
+    }
+    return mapper._leafNo(key, numLevels)
+}
+
+func (mapper PrefixKeyMapper) _leafNo(key []byte, numLevels int) ([32]byte, error) {
+    var padded [32]byte
+    copy(padded[:], key)
+
+    // The key still fits if its bits past the leaf no's are all zero
+    numBits := numLevels - 1
+    if !mapper.Truncate && (len(key) > 32 || lshBytes(padded, uint(numBits)) != [32]byte{}) {
+        return [32]byte{}, fmt.Errorf("key of %d bytes does not fit in the %d-bit leaf nos of a tree with %d levels", len(key), numBits, numLevels)
+    }
+    return _truncateLeafNo(padded, numLevels), nil
+}
+
+/**
+ * Returns the tree's key mapper: TreeOptions::KeyMapper, or an HMACKeyMapper with the tree's HMAC
+ * key if TreeOptions::HMACLeafNos is set, or a VRFKeyMapper if the tree has a VRF key, or a
+ * HashKeyMapper with the tree's hash function.
+ */
+func (tree *Tree) _keyMapper() KeyMapper {
+    switch {
+    case tree.keyMapper != nil:
+        return tree.keyMapper
+    case tree.hmacLeafNos:
+        return HMACKeyMapper{tree.hmacKey}
+    case tree.vrfKey != nil:
+        return VRFKeyMapper{tree.vrfKey}
+    }
+    return HashKeyMapper{tree.newHash}
+}
+
+/**
+ * Returns the leaf no of 'key' and the proof that the mapping is correct, if any (see KeyMapper).
+ */
+func (tree *Tree) KeyLeafNo(key []byte) ([32]byte, []byte) {
+    return tree._keyMapper().MapKey(key, tree.numLevels)
+}
+
+/**
+ * Inserts a new leaf for 'key', at the leaf no the tree's key mapper maps it to (see Tree::Insert()).
+ * Returns the leaf no.
+ */
+func (tree *Tree) InsertKey(key []byte, dataHash [32]byte, proofTree *Tree) [32]byte {
+    leafNo, _ := tree.KeyLeafNo(key)
+    tree.Insert(leafNo, dataHash, proofTree)
+    return leafNo
+}
+
+/**
+ * Inserts a new leaf for 'key' in the epoch (see Tree::InsertKey()).
+ */
+func (epoch *Epoch) InsertKey(key []byte, dataHash [32]byte) ([32]byte, error) {
+    leafNo, _ := epoch.tree.KeyLeafNo(key)
+    return leafNo, epoch.Insert(leafNo, dataHash)
+}
+
+/**
+ * A membership (or non-membership) proof for a key: the proof that the key maps to
+ * 'Membership.LeafNo', if the tree's key mapper has one (see KeyMapper), and the membership proof
+ * of that leaf.
+ */
+type KeyProof struct {
+    Key          []byte
+    MappingProof []byte
+    Membership   *MembershipProof
+}
+
+/**
+ * Returns a proof that the specified key is in the tree, or that it is not.
+ */
+func (tree *Tree) ProveKey(key []byte) *KeyProof {
+    leafNo, mappingProof := tree.KeyLeafNo(key)
+    return &KeyProof{
+        Key:          append([]byte(nil), key...),
+        MappingProof: mappingProof,
+        Membership:   tree.ProveMembership(leafNo),
+    }
+}
+
+/**
+ * Checks a key proof against the root hash of a tree created via NewTreeWithOptions() with the
+ * specified options, checking the key's mapping via 'verifier'.
+ */
+func VerifyKeyProof(proof *KeyProof, verifier KeyVerifier, rootHash [32]byte, opts TreeOptions) error {
+    numLevels, err := opts._numLevels()
+    if err != nil {
+        return err
+    }
+    leafNo, err := verifier.VerifyKey(proof.Key, proof.MappingProof, numLevels)
+    if err != nil {
+        return err
+    }
+    if proof.Membership == nil || !hashEqual(proof.Membership.LeafNo, leafNo) {
+        return fmt.Errorf("membership proof is not for the key's leaf %s", hashStr(leafNo))
+    }
+    if !VerifyMembershipProofWithOptions(proof.Membership, rootHash, opts) {
+        return errors.New("invalid membership proof")
+    }
+    return nil
+}
@@ -0,0 +1,132 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "hash"
+)
+
+/**
+ * A membership (or non-membership) proof for a label, for trees whose leaves are inserted by label
+ * (see Tree::InsertLabel()): the VRF proof that the label maps to 'Membership.LeafNo', and the
+ * membership proof of that leaf. Verifiers learn nothing about the leaf nos of other labels.
+ */
+type LabelProof struct {
+    Label      []byte
+    VRFProof   []byte
+    Membership *MembershipProof
+}
+
+var errNoVRFKey = errors.New("tree has no VRF key (see TreeOptions::VRFKey)")
+
+/**
+ * Returns the leaf no of the specified label, i.e., VRF(label), and the VRF proof for it. Panics if
+ * the tree has no VRF key. For trees with fewer than 257 levels, the leaf no is the top bits of
+ * the VRF output.
+ */
+func (tree *Tree) LabelLeafNo(label []byte) ([32]byte, []byte) {
+    if tree.vrfKey == nil {
+        panic(errNoVRFKey.Error())
+    }
+    output, vrfProof := tree.vrfKey.Prove(label)
+    return _vrfLeafNo(output, tree.numLevels), vrfProof
+}
+
+/**
+ * Truncates a VRF output to a leaf no of a tree with 'numLevels' levels.
+ */
+func _vrfLeafNo(output [32]byte, numLevels int) [32]byte {
+    return rshBytes(output, uint(maxNumLevels-numLevels))
+}
+
+/**
+ * Inserts a new leaf for the specified label, at leaf no VRF(label) (see Tree::Insert()). Returns
+ * the leaf no.
+ */
+func (tree *Tree) InsertLabel(label []byte, dataHash [32]byte, proofTree *Tree) [32]byte {
+    leafNo, _ := tree.LabelLeafNo(label)
+    tree.Insert(leafNo, dataHash, proofTree)
+    return leafNo
+}
+
+/**
+ * Updates the leaf of the specified label (see Tree::Update()). Returns the leaf no.
+ */
+func (tree *Tree) UpdateLabel(label []byte, newDataHash [32]byte, proofTree *Tree) [32]byte {
+    leafNo, _ := tree.LabelLeafNo(label)
+    tree.Update(leafNo, newDataHash, proofTree)
+    return leafNo
+}
+
+/**
+ * Inserts a new leaf for the specified label in the epoch (see Tree::InsertLabel()).
+ */
+func (epoch *Epoch) InsertLabel(label []byte, dataHash [32]byte) ([32]byte, error) {
+    if epoch.tree.vrfKey == nil {
+        return [32]byte{}, errNoVRFKey
+    }
+    leafNo, _ := epoch.tree.LabelLeafNo(label)
+    return leafNo, epoch.Insert(leafNo, dataHash)
+}
+
+/**
+ * Updates the leaf of the specified label in the epoch (see Tree::UpdateLabel()).
+ */
+func (epoch *Epoch) UpdateLabel(label []byte, newDataHash [32]byte) ([32]byte, error) {
+    if epoch.tree.vrfKey == nil {
+        return [32]byte{}, errNoVRFKey
+    }
+    leafNo, _ := epoch.tree.LabelLeafNo(label)
+    return leafNo, epoch.Update(leafNo, newDataHash)
+}
+
+/**
+ * Returns a proof that the specified label is in the tree, or that it is not.
+ */
+func (tree *Tree) ProveLabel(label []byte) *LabelProof {
+    leafNo, vrfProof := tree.LabelLeafNo(label)
+    return &LabelProof{
+        Label:      append([]byte(nil), label...),
+        VRFProof:   vrfProof,
+        Membership: tree.ProveMembership(leafNo),
+    }
+}
+
+/**
+ * Checks a label proof against the VRF public key and the root hash of a tree that uses SHA256.
+ */
+func VerifyLabelProof(proof *LabelProof, pk *VRFPublicKey, rootHash [32]byte) error {
+    return VerifyLabelProofWithHash(proof, pk, rootHash, sha256.New)
+}
+
+/**
+ * Checks a label proof against the VRF public key and the root hash of a tree that uses the
+ * specified hash function.
+ */
+func VerifyLabelProofWithHash(proof *LabelProof, pk *VRFPublicKey, rootHash [32]byte, newHash func() hash.Hash) error {
+    return VerifyLabelProofWithOptions(proof, pk, rootHash, TreeOptions{NewHash: newHash})
+}
+
+/**
+ * Checks a label proof against the VRF public key and the root hash of a tree created via
+ * NewTreeWithOptions() with the specified options (except for the VRF key, which verifiers do not have).
+ */
+func VerifyLabelProofWithOptions(proof *LabelProof, pk *VRFPublicKey, rootHash [32]byte, opts TreeOptions) error {
+    numLevels, err := opts._numLevels()
+    if err != nil {
+        return err
+    }
+    output, err := pk.Verify(proof.Label, proof.VRFProof)
+    if err != nil {
+        return err
+    }
+    leafNo := _vrfLeafNo(output, numLevels)
+    if proof.Membership == nil || !hashEqual(proof.Membership.LeafNo, leafNo) {
+        return fmt.Errorf("membership proof is not for the label's leaf %s", hashStr(leafNo))
+    }
+    if !VerifyMembershipProofWithOptions(proof.Membership, rootHash, opts) {
+        return errors.New("invalid membership proof")
+    }
+    return nil
+}
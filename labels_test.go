@@ -0,0 +1,77 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "testing"
+)
+
+/**
+ * Label proofs of labels that are in the tree and of labels that are not must verify, and must not
+ * verify for another label, key or root, nor with their VRF or membership proofs tampered with.
+ */
+func TestLabelProofRejectsTampering(t *testing.T) {
+    seed := sha256.Sum256([]byte("key"))
+    key, err := NewVRFPrivateKey(seed[:])
+    if err != nil {
+        t.Fatal(err)
+    }
+    otherSeed := sha256.Sum256([]byte("other"))
+    otherKey, err := NewVRFPrivateKey(otherSeed[:])
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, numLevels := range []int{257, 32} {
+        opts := TreeOptions{VRFKey: key, HashingScheme: HashingLevelLN}
+        tree, err := NewTreeWithOptions(numLevels, opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for i := 0; i < 20; i++ {
+            label := []byte(fmt.Sprintf("label %d", i))
+            tree.InsertLabel(label, sha256.Sum256(label), nil)
+        }
+        tree.RecordEpoch()
+        root := tree.GetRootHash()
+        opts = TreeOptions{NumLevels: numLevels, HashingScheme: HashingLevelLN}
+
+        for _, label := range []string{"label 3", "label 19", "missing"} {
+            proof := tree.ProveLabel([]byte(label))
+            if err := VerifyLabelProofWithOptions(proof, key.Public(), root, opts); err != nil {
+                t.Fatalf("%d levels: proof of '%s' does not verify: %v", numLevels, label, err)
+            }
+
+            flippedVRF := *proof
+            flippedVRF.VRFProof = append([]byte{}, proof.VRFProof...)
+            flippedVRF.VRFProof[len(flippedVRF.VRFProof)-1] ^= 1
+            otherLabel := *proof
+            otherLabel.Label = []byte(label + "!")
+            otherLeaf := *proof
+            otherLeaf.Membership = tree.ProveLabel([]byte("label 0")).Membership
+            tamperedLeaf := *proof
+            membership := *proof.Membership
+            membership.LeafHash[0] ^= 1
+            tamperedLeaf.Membership = &membership
+            noMembership := *proof
+            noMembership.Membership = nil
+
+            cases := map[string]error{
+                "VRF proof flipped":    VerifyLabelProofWithOptions(&flippedVRF, key.Public(), root, opts),
+                "other label":          VerifyLabelProofWithOptions(&otherLabel, key.Public(), root, opts),
+                "other leaf's proof":   VerifyLabelProofWithOptions(&otherLeaf, key.Public(), root, opts),
+                "leaf hash flipped":    VerifyLabelProofWithOptions(&tamperedLeaf, key.Public(), root, opts),
+                "no membership proof":  VerifyLabelProofWithOptions(&noMembership, key.Public(), root, opts),
+                "other key":            VerifyLabelProofWithOptions(proof, otherKey.Public(), root, opts),
+                "other root":           VerifyLabelProofWithOptions(proof, key.Public(), tree.EmptyHash, opts),
+                "other # of levels":    VerifyLabelProofWithOptions(proof, key.Public(), root, TreeOptions{NumLevels: numLevels - 1, HashingScheme: HashingLevelLN}),
+                "other hashing scheme": VerifyLabelProofWithOptions(proof, key.Public(), root, TreeOptions{NumLevels: numLevels}),
+            }
+            for name, err := range cases {
+                if err == nil {
+                    t.Errorf("%d levels: proof of '%s' verifies with %s", numLevels, label, name)
+                }
+            }
+        }
+    }
+}
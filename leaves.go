@@ -0,0 +1,174 @@
+package aomt
+
+import (
+    "bytes"
+    "fmt"
+)
+
+/**
+ * Iterates through the leaves of a tree in increasing order of leaf no, e.g., for auditors who
+ * mirror the whole dictionary:
+ *
+ *   it := tree.Leaves()
+ *   for it.Next() {
+ *       leafNo, dataHash := it.Leaf()
+ *       ...
+ *   }
+ *
+ * The tree must not be changed while iterating (pruned subtrees are rebuilt as they are reached).
+ */
+type LeafIterator struct {
+    tree *Tree
+
+    // Only leaves in [start, end) are returned, or in [start, 2^(numLevels - 1)) if 'hasEnd' is false
+    start  [32]byte
+    end    [32]byte
+    hasEnd bool
+
+    // The nodes left to visit, in depth-first order (i.e., the next node is on top)
+    stack []iteratorNode
+
+    leafNo   [32]byte
+    dataHash [32]byte
+
+    // The latest data hash of every leaf, for RFC 6962 trees, whose leaf hashes are not data hashes
+    dataHashes map[[32]byte][32]byte
+}
+
+type iteratorNode struct {
+    level  int
+    nodeNo [32]byte
+}
+
+/**
+ * Returns an iterator over all the leaves of the tree.
+ */
+func (tree *Tree) Leaves() *LeafIterator {
+    return tree._newLeafIterator([32]byte{}, [32]byte{}, false)
+}
+
+/**
+ * Returns an iterator over the leaves whose leaf no is in [start, end).
+ */
+func (tree *Tree) LeavesInRange(start [32]byte, end [32]byte) *LeafIterator {
+    return tree._newLeafIterator(start, end, true)
+}
+
+/**
+ * Returns an iterator over the leaves whose leaf no starts with the first 'numBits' bits of
+ * 'prefix' (i.e., the leaves in the subtree of the level-'numBits' node with LN 'prefix').
+ */
+func (tree *Tree) LeavesWithPrefix(prefix [32]byte, numBits int) *LeafIterator {
+    if numBits < 0 || numBits > tree.numLevels-1 {
+        panic(fmt.Sprintf("Invalid prefix length %d", numBits))
+    }
+    if !_fitsInLevel(prefix, numBits) {
+        panic(fmt.Sprintf("Prefix %s has more than %d bits", hashStr(prefix), numBits))
+    }
+
+    it := tree._newLeafIterator(lshBytes(prefix, uint(tree.numLevels-1-numBits)), [32]byte{}, false)
+    it.stack = nil
+    tree._restore(numBits, prefix)
+    if tree.lvl[numBits].node[prefix] != nil {
+        it.stack = append(it.stack, iteratorNode{numBits, prefix})
+    }
+    return it
+}
+
+func (tree *Tree) _newLeafIterator(start [32]byte, end [32]byte, hasEnd bool) *LeafIterator {
+    it := &LeafIterator{tree: tree, start: start, end: end, hasEnd: hasEnd}
+    if tree.lvl[0].node[tree.RootNo] != nil {
+        it.stack = append(it.stack, iteratorNode{0, tree.RootNo})
+    }
+    return it
+}
+
+/**
+ * Advances to the next leaf. Returns false if there are no more leaves.
+ */
+func (it *LeafIterator) Next() bool {
+    tree := it.tree
+    lastLevel := tree.numLevels - 1
+
+    for len(it.stack) > 0 {
+        top := it.stack[len(it.stack)-1]
+        it.stack = it.stack[:len(it.stack)-1]
+
+        if top.level == lastLevel {
+            it.leafNo = top.nodeNo
+            it.dataHash = it._dataHash(top.nodeNo, tree.lvl[lastLevel].node[top.nodeNo])
+            return true
+        }
+
+        if node := tree.lvl[top.level].node[top.nodeNo]; node.Pruned {
+            tree._rebuildSubtree(top.level, top.nodeNo, node)
+        }
+
+        // Push the right child first, so that the left one is visited first
+        leftNo, rightNo := childrenOf(top.nodeNo)
+        for _, childNo := range [][32]byte{rightNo, leftNo} {
+            if tree.lvl[top.level+1].node[childNo] != nil && it._intersects(top.level+1, childNo) {
+                it.stack = append(it.stack, iteratorNode{top.level + 1, childNo})
+            }
+        }
+    }
+
+    return false
+}
+
+/**
+ * Returns the current leaf's leaf no and its latest data hash (see Tree::GetHistory() for the
+ * previous ones).
+ */
+func (it *LeafIterator) Leaf() ([32]byte, [32]byte) {
+    return it.leafNo, it.dataHash
+}
+
+/**
+ * Returns true if the subtree of the specified node has leaves in the iterator's range.
+ */
+func (it *LeafIterator) _intersects(level int, nodeNo [32]byte) bool {
+    var ones [32]byte
+    for i := range ones {
+        ones[i] = 0xff
+    }
+
+    // The subtree's leaves are in [lo, hi]
+    height := it.tree.numLevels - 1 - level
+    lo := lshBytes(nodeNo, uint(height))
+    hi := lo
+    suffix := rshBytes(ones, uint(256-height))
+    for i := range hi {
+        hi[i] |= suffix[i]
+    }
+
+    if bytes.Compare(hi[:], it.start[:]) < 0 {
+        return false
+    }
+    return !it.hasEnd || bytes.Compare(lo[:], it.end[:]) < 0
+}
+
+func (it *LeafIterator) _dataHash(leafNo [32]byte, leaf *Node) [32]byte {
+    tree := it.tree
+    if versions, ok := tree.history[leafNo]; ok {
+        return versions[len(versions)-1]
+    }
+    if !tree.rfc6962 {
+        return leaf.Hash
+    }
+
+    // Leaf hashes of RFC 6962 trees are hashes of the data hashes, so we look the data hashes up
+    // in the epoch log
+    if it.dataHashes == nil {
+        it.dataHashes = make(map[[32]byte][32]byte)
+        for _, epoch := range tree.epochs {
+            for _, change := range epoch.changes {
+                it.dataHashes[change.leafNo] = change.dataHash
+            }
+        }
+        for _, change := range tree.pending {
+            it.dataHashes[change.leafNo] = change.dataHash
+        }
+    }
+    return it.dataHashes[leafNo]
+}
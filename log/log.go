@@ -0,0 +1,278 @@
+/**
+ * Package log implements history trees (i.e., RFC 6962 / RFC 9162 Merkle logs) of 32-byte
+ * entries, with inclusion and consistency proofs. Key transparency designs pair the prefix tree
+ * with such a log of its epoch roots, so that clients who only remember the log's root can check
+ * that an epoch root was published (via inclusion proofs) and that the log of epoch roots only
+ * grew (via consistency proofs), without asking for append-only proofs of the prefix tree.
+ *
+ * Every epoch recorded via Tree::RecordEpoch() in package aomt (and thus every Epoch::Commit())
+ * appends its root to the tree's log (see Tree::RootLog() there), so epoch i is the log's entry i.
+ * Like the client package, this package depends only on the standard library.
+ */
+package log
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "errors"
+    "fmt"
+)
+
+/**
+ * A history tree of 32-byte entries. The log always uses SHA256 with RFC 6962 domain separation,
+ * regardless of the prefix tree's hash function: the hash of an entry 'e' is SHA256(0x00 || e) and
+ * the hash of an internal node is SHA256(0x01 || left || right). The root of the empty log is
+ * SHA256("").
+ */
+type Log struct {
+    leaves [][32]byte // the hashes of the entries
+}
+
+func New() *Log {
+    return &Log{}
+}
+
+/**
+ * Appends an entry to the log and returns its index.
+ */
+func (hlog *Log) Append(entry [32]byte) int {
+    hlog.leaves = append(hlog.leaves, _leafHash(entry))
+    return len(hlog.leaves) - 1
+}
+
+/**
+ * Returns the # of entries in the log.
+ */
+func (hlog *Log) Size() int {
+    return len(hlog.leaves)
+}
+
+/**
+ * Returns the root hash of the log.
+ */
+func (hlog *Log) Root() [32]byte {
+    return _root(hlog.leaves)
+}
+
+/**
+ * Returns the root hash of the log when it had 'size' entries.
+ */
+func (hlog *Log) RootAt(size int) ([32]byte, error) {
+    if size < 0 || size > len(hlog.leaves) {
+        return [32]byte{}, fmt.Errorf("log has %d entries, not %d", len(hlog.leaves), size)
+    }
+    return _root(hlog.leaves[:size]), nil
+}
+
+/**
+ * Returns the inclusion proof (i.e., the audit path) of entry 'index' in the log when it had
+ * 'size' entries.
+ */
+func (hlog *Log) ProveInclusion(index int, size int) ([][32]byte, error) {
+    if size < 0 || size > len(hlog.leaves) {
+        return nil, fmt.Errorf("log has %d entries, not %d", len(hlog.leaves), size)
+    }
+    if index < 0 || index >= size {
+        return nil, fmt.Errorf("entry %d is not in a log with %d entries", index, size)
+    }
+    return _path(index, hlog.leaves[:size]), nil
+}
+
+/**
+ * Returns the consistency proof between the log when it had 'oldSize' entries and the log when
+ * it had 'newSize' entries.
+ */
+func (hlog *Log) ProveConsistency(oldSize int, newSize int) ([][32]byte, error) {
+    if newSize < 0 || newSize > len(hlog.leaves) {
+        return nil, fmt.Errorf("log has %d entries, not %d", len(hlog.leaves), newSize)
+    }
+    if oldSize < 0 || oldSize > newSize {
+        return nil, fmt.Errorf("invalid log sizes %d and %d", oldSize, newSize)
+    }
+    // Every log is consistent with the empty log and with itself
+    if oldSize == 0 || oldSize == newSize {
+        return nil, nil
+    }
+    return _subproof(oldSize, hlog.leaves[:newSize], true), nil
+}
+
+/**
+ * Checks that 'entry' is entry 'index' of the log with 'size' entries and root hash 'root'.
+ */
+func VerifyInclusion(entry [32]byte, index int, size int, proof [][32]byte, root [32]byte) error {
+    return _verifyInclusion(_leafHash(entry), index, size, proof, root)
+}
+
+/**
+ * Checks the inclusion proof of the entry with hash 'leafHash', see VerifyInclusion().
+ */
+func _verifyInclusion(leafHash [32]byte, index int, size int, proof [][32]byte, root [32]byte) error {
+    if index < 0 || index >= size {
+        return fmt.Errorf("entry %d is not in a log with %d entries", index, size)
+    }
+
+    // RFC 9162, section 2.1.3.2
+    fn, sn := uint64(index), uint64(size-1)
+    r := leafHash
+    for _, p := range proof {
+        if sn == 0 {
+            return errors.New("log inclusion proof is too long")
+        }
+        if fn&1 == 1 || fn == sn {
+            r = _nodeHash(p, r)
+            if fn&1 == 0 {
+                for fn&1 == 0 && fn != 0 {
+                    fn >>= 1
+                    sn >>= 1
+                }
+            }
+        } else {
+            r = _nodeHash(r, p)
+        }
+        fn >>= 1
+        sn >>= 1
+    }
+
+    if sn != 0 {
+        return errors.New("log inclusion proof is too short")
+    }
+    if !_hashEqual(r, root) {
+        return fmt.Errorf("log inclusion proof does not match the root: got %s", hex.EncodeToString(r[:]))
+    }
+    return nil
+}
+
+/**
+ * Checks that the log with 'newSize' entries and root hash 'newRoot' extends the log with
+ * 'oldSize' entries and root hash 'oldRoot'.
+ */
+func VerifyConsistency(oldSize int, newSize int, oldRoot [32]byte, newRoot [32]byte, proof [][32]byte) error {
+    if oldSize < 0 || oldSize > newSize {
+        return fmt.Errorf("invalid log sizes %d and %d", oldSize, newSize)
+    }
+    if oldSize == 0 || oldSize == newSize {
+        if len(proof) != 0 {
+            return errors.New("log consistency proof should be empty")
+        }
+        if oldSize == newSize && !_hashEqual(oldRoot, newRoot) {
+            return errors.New("logs of the same size have different roots")
+        }
+        return nil
+    }
+    if len(proof) == 0 {
+        return errors.New("log consistency proof is empty")
+    }
+
+    // RFC 9162, section 2.1.4.2
+    if oldSize&(oldSize-1) == 0 {
+        proof = append([][32]byte{oldRoot}, proof...)
+    }
+    fn, sn := uint64(oldSize-1), uint64(newSize-1)
+    for fn&1 == 1 {
+        fn >>= 1
+        sn >>= 1
+    }
+
+    fr, sr := proof[0], proof[0]
+    for _, c := range proof[1:] {
+        if sn == 0 {
+            return errors.New("log consistency proof is too long")
+        }
+        if fn&1 == 1 || fn == sn {
+            fr = _nodeHash(c, fr)
+            sr = _nodeHash(c, sr)
+            if fn&1 == 0 {
+                for fn&1 == 0 && fn != 0 {
+                    fn >>= 1
+                    sn >>= 1
+                }
+            }
+        } else {
+            sr = _nodeHash(sr, c)
+        }
+        fn >>= 1
+        sn >>= 1
+    }
+
+    if sn != 0 {
+        return errors.New("log consistency proof is too short")
+    }
+    if !_hashEqual(fr, oldRoot) || !_hashEqual(sr, newRoot) {
+        return errors.New("log consistency proof does not match the roots")
+    }
+    return nil
+}
+
+func _leafHash(entry [32]byte) [32]byte {
+    return sha256.Sum256(append([]byte{0x00}, entry[:]...))
+}
+
+func _nodeHash(left [32]byte, right [32]byte) [32]byte {
+    data := make([]byte, 0, 65)
+    data = append(append(append(data, 0x01), left[:]...), right[:]...)
+    return sha256.Sum256(data)
+}
+
+/**
+ * Returns true if the two hashes are equal, in constant time.
+ */
+func _hashEqual(a [32]byte, b [32]byte) bool {
+    return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+/**
+ * Returns the largest power of 2 smaller than n, for n > 1.
+ */
+func _split(n int) int {
+    k := 1
+    for k<<1 < n {
+        k <<= 1
+    }
+    return k
+}
+
+/**
+ * MTH() from RFC 9162, given the hashes of the entries.
+ */
+func _root(leaves [][32]byte) [32]byte {
+    switch len(leaves) {
+    case 0:
+        return sha256.Sum256(nil)
+    case 1:
+        return leaves[0]
+    }
+    k := _split(len(leaves))
+    return _nodeHash(_root(leaves[:k]), _root(leaves[k:]))
+}
+
+/**
+ * PATH() from RFC 9162.
+ */
+func _path(index int, leaves [][32]byte) [][32]byte {
+    if len(leaves) <= 1 {
+        return nil
+    }
+    k := _split(len(leaves))
+    if index < k {
+        return append(_path(index, leaves[:k]), _root(leaves[k:]))
+    }
+    return append(_path(index-k, leaves[k:]), _root(leaves[:k]))
+}
+
+/**
+ * SUBPROOF() from RFC 9162.
+ */
+func _subproof(m int, leaves [][32]byte, complete bool) [][32]byte {
+    if m == len(leaves) {
+        if complete {
+            return nil
+        }
+        return [][32]byte{_root(leaves)}
+    }
+    k := _split(len(leaves))
+    if m <= k {
+        return append(_subproof(m, leaves[:k], complete), _root(leaves[k:]))
+    }
+    return append(_subproof(m-k, leaves[k:], false), _root(leaves[:k]))
+}
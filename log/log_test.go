@@ -0,0 +1,259 @@
+package log
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "testing"
+)
+
+/**
+ * The leaves of the RFC 6962 / RFC 9162 test vectors (as used by Certificate Transparency's
+ * implementations), which are not 32-byte entries, so the tests hash them as leaves themselves.
+ */
+var testLeaves = []string{
+    "",
+    "00",
+    "10",
+    "2021",
+    "3031",
+    "40414243",
+    "5051525354555657",
+    "606162636465666768696a6b6c6d6e6f",
+}
+
+// The roots of the logs with the first 1, 2, ..., 8 test leaves
+var testRoots = []string{
+    "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+    "fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+    "aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+    "d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+    "4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+    "76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+    "ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+    "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+var testInclusionProofs = []struct {
+    index int
+    size  int
+    proof []string
+}{
+    {0, 1, nil},
+    {0, 8, []string{
+        "96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+        "5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+        "6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+    }},
+    {5, 8, []string{
+        "bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+        "ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+        "d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+    }},
+    {2, 3, []string{
+        "fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+    }},
+    {1, 5, []string{
+        "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+        "5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+        "bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+    }},
+}
+
+var testConsistencyProofs = []struct {
+    oldSize int
+    newSize int
+    proof   []string
+}{
+    {1, 1, nil},
+    {1, 8, []string{
+        "96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+        "5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+        "6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+    }},
+    {6, 8, []string{
+        "0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+        "ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+        "d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+    }},
+    {2, 5, []string{
+        "5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+        "bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+    }},
+}
+
+func _testHash(t *testing.T, s string) [32]byte {
+    var hash [32]byte
+    data, err := hex.DecodeString(s)
+    if err != nil || len(data) != 32 {
+        t.Fatalf("invalid hash %s", s)
+    }
+    copy(hash[:], data)
+    return hash
+}
+
+func _testHashes(t *testing.T, strs []string) [][32]byte {
+    var hashes [][32]byte
+    for _, s := range strs {
+        hashes = append(hashes, _testHash(t, s))
+    }
+    return hashes
+}
+
+/**
+ * Returns the log with the test leaves, hashed as RFC 6962 leaves.
+ */
+func _testLog(t *testing.T) *Log {
+    hlog := New()
+    for _, leaf := range testLeaves {
+        data, err := hex.DecodeString(leaf)
+        if err != nil {
+            t.Fatal(err)
+        }
+        hlog.leaves = append(hlog.leaves, sha256.Sum256(append([]byte{0x00}, data...)))
+    }
+    return hlog
+}
+
+func _testEqualHashes(a [][32]byte, b [][32]byte) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func TestLogVectors(t *testing.T) {
+    hlog := _testLog(t)
+    if root, _ := hlog.RootAt(0); hex.EncodeToString(root[:]) != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+        t.Errorf("empty log has root %x", root)
+    }
+    for i, expected := range testRoots {
+        if root, err := hlog.RootAt(i + 1); err != nil || root != _testHash(t, expected) {
+            t.Errorf("log with %d leaves has root %x (%v), expected %s", i+1, root, err, expected)
+        }
+    }
+
+    for _, vector := range testInclusionProofs {
+        expected := _testHashes(t, vector.proof)
+        proof, err := hlog.ProveInclusion(vector.index, vector.size)
+        if err != nil || !_testEqualHashes(proof, expected) {
+            t.Errorf("inclusion proof of leaf %d in a log with %d leaves is %x (%v)", vector.index, vector.size, proof, err)
+        }
+        root := _testHash(t, testRoots[vector.size-1])
+        if err := _verifyInclusion(hlog.leaves[vector.index], vector.index, vector.size, expected, root); err != nil {
+            t.Errorf("inclusion proof of leaf %d in a log with %d leaves does not verify: %v", vector.index, vector.size, err)
+        }
+    }
+
+    for _, vector := range testConsistencyProofs {
+        expected := _testHashes(t, vector.proof)
+        proof, err := hlog.ProveConsistency(vector.oldSize, vector.newSize)
+        if err != nil || !_testEqualHashes(proof, expected) {
+            t.Errorf("consistency proof from %d to %d leaves is %x (%v)", vector.oldSize, vector.newSize, proof, err)
+        }
+        oldRoot, newRoot := _testHash(t, testRoots[vector.oldSize-1]), _testHash(t, testRoots[vector.newSize-1])
+        if err := VerifyConsistency(vector.oldSize, vector.newSize, oldRoot, newRoot, expected); err != nil {
+            t.Errorf("consistency proof from %d to %d leaves does not verify: %v", vector.oldSize, vector.newSize, err)
+        }
+    }
+}
+
+/**
+ * Every proof between every two sizes of a log must verify, and must not verify once tampered
+ * with: with any hash flipped, a hash dropped or added, or for other indices, sizes or roots.
+ */
+func TestLogRejectsTamperedProofs(t *testing.T) {
+    hlog := New()
+    var entries [][32]byte
+    for i := 0; i < 13; i++ {
+        entry := sha256.Sum256([]byte{byte(i)})
+        entries = append(entries, entry)
+        hlog.Append(entry)
+    }
+    extra := sha256.Sum256([]byte("extra"))
+
+    for size := 1; size <= hlog.Size(); size++ {
+        root, _ := hlog.RootAt(size)
+        otherRoot, _ := hlog.RootAt(size - 1)
+        for index := 0; index < size; index++ {
+            proof, err := hlog.ProveInclusion(index, size)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := VerifyInclusion(entries[index], index, size, proof, root); err != nil {
+                t.Fatalf("inclusion proof of entry %d in %d entries does not verify: %v", index, size, err)
+            }
+
+            tampered := map[string]error{
+                "other entry": VerifyInclusion(extra, index, size, proof, root),
+                "other root":  VerifyInclusion(entries[index], index, size, proof, otherRoot),
+                "index + 1":   VerifyInclusion(entries[index], index+1, size, proof, root),
+                "hash added":  VerifyInclusion(entries[index], index, size, append(append([][32]byte{}, proof...), extra), root),
+            }
+            if len(proof) > 0 {
+                tampered["hash dropped"] = VerifyInclusion(entries[index], index, size, proof[:len(proof)-1], root)
+            }
+            if index > 0 {
+                tampered["index - 1"] = VerifyInclusion(entries[index], index-1, size, proof, root)
+            }
+            for i := range proof {
+                flipped := append([][32]byte{}, proof...)
+                flipped[i][0] ^= 1
+                if err := VerifyInclusion(entries[index], index, size, flipped, root); err == nil {
+                    t.Errorf("inclusion proof of entry %d in %d entries verifies with hash %d flipped", index, size, i)
+                }
+            }
+            for name, err := range tampered {
+                if err == nil {
+                    t.Errorf("inclusion proof of entry %d in %d entries verifies with %s", index, size, name)
+                }
+            }
+        }
+
+        for oldSize := 1; oldSize < size; oldSize++ {
+            oldRoot, _ := hlog.RootAt(oldSize)
+            proof, err := hlog.ProveConsistency(oldSize, size)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := VerifyConsistency(oldSize, size, oldRoot, root, proof); err != nil {
+                t.Fatalf("consistency proof from %d to %d entries does not verify: %v", oldSize, size, err)
+            }
+
+            tampered := map[string]error{
+                "other old root": VerifyConsistency(oldSize, size, extra, root, proof),
+                "other new root": VerifyConsistency(oldSize, size, oldRoot, extra, proof),
+                "swapped roots":  VerifyConsistency(oldSize, size, root, oldRoot, proof),
+                "hash added":     VerifyConsistency(oldSize, size, oldRoot, root, append(append([][32]byte{}, proof...), extra)),
+                "hash dropped":   VerifyConsistency(oldSize, size, oldRoot, root, proof[:len(proof)-1]),
+                "no proof":       VerifyConsistency(oldSize, size, oldRoot, root, nil),
+            }
+            if oldSize > 1 {
+                tampered["old size - 1"] = VerifyConsistency(oldSize-1, size, oldRoot, root, proof)
+            }
+            for i := range proof {
+                flipped := append([][32]byte{}, proof...)
+                flipped[i][0] ^= 1
+                if err := VerifyConsistency(oldSize, size, oldRoot, root, flipped); err == nil {
+                    t.Errorf("consistency proof from %d to %d entries verifies with hash %d flipped", oldSize, size, i)
+                }
+            }
+            for name, err := range tampered {
+                if err == nil {
+                    t.Errorf("consistency proof from %d to %d entries verifies with %s", oldSize, size, name)
+                }
+            }
+        }
+    }
+
+    if err := VerifyConsistency(3, 3, extra, extra, nil); err != nil {
+        t.Errorf("equal logs are not consistent: %v", err)
+    }
+    if err := VerifyConsistency(3, 2, extra, extra, nil); err == nil {
+        t.Errorf("a log is consistent with a larger one")
+    }
+}
@@ -1,19 +1,160 @@
-package main
+package aomt
 
 import (
+    "flag"
     "time"
+    "io"
     "os"
     "fmt"
     "strconv"
+    "strings"
 )
 
-func main() {
-    args := os.Args[1:] // exclude program"s name
+/**
+ * The tool's subcommands. Each one returns the process's exit code, which is 'exitUsage' if it was
+ * called with invalid arguments.
+ */
+var commands = []struct {
+    name  string
+    usage string
+    run   func(name string, args []string) int
+}{
+    {"bench", "[-repr maps|compact|cached|pages] [-cached-levels <k>] [-slab-nodes <n>] [-proof-tree-max-nodes <n>] [-append | -resume] [-repeat <n>] [-no-proofs | -no-verify | -no-compress] [-dot <file> [-dot-batch <n>]] [-paranoid] [-keep-going] [-workload <workload> [-raw-keys]] [-artifacts <dir> [-heap-profiles]] [-output-format csv|json] [-serve <addr> -api-keys <key1,key2,...> [-anchors <file>] [-metrics] [-proof-cache-mb <n>] [-proof-archive <dir> [-proof-archive-mb <n>]] [-audit-interval <duration> [-audit-samples <n>]] [-max-queued-leaves <n> [-max-leaves-per-epoch <n>] [-epoch-interval <duration>]]] <prng-seed> <csv-output> [<size1> <size2> ... <size-n>]", benchCmd},
+    {"insert", "--db <file> [--proof <file>] <key>=<value> [<key>=<value> ...]", insertCmd},
+    {"prove-membership", "--db <file> | --mmap <file> [--prefetch <n>] --key <key>", proveMembershipCmd},
+    {"build", "--input <file> --db <file> [--levels <n>] [--gen <# of leaves> [--seed <seed>]]", buildCmd},
+    {"export-mmap", "--db <file> --out <file>", exportMMapCmd},
+    {"export-bundle", "--db <file> --out <dir> [--epoch <n>] [--keys <file>] [--signing-key <file>]", exportBundleCmd},
+    {"save-sharded", "--db <file> --dir <dir> [--shards <dir-or-url1,dir-or-url2,...> [--prefix-bits <k>]] [--publish <url>]", saveShardedCmd},
+    {"load-sharded", "--dir <dir> --db <file> [--from <url>]", loadShardedCmd},
+    {"rebalance-shards", "--dir <dir> --shards <dir-or-url1,dir-or-url2,...>", rebalanceShardsCmd},
+    {"verify-append-only", "--old <hex root> --new <hex root> --proof <file> [--db <file>]", verifyAppendOnlyCmd},
+    {"gossip", "--endpoints <url1,url2,...> [--files <file1,file2,...>] [--api-key <key>] [--levels <n>]", gossipCmd},
+    {"solidity", "--db <file> [--contract <file>] [--calldata <file> --from <epoch> [--to <epoch>]]", solidityCmd},
+    {"testvectors", "--out <dir> [--seed <seed>]", testVectorsCmd},
+    {"diff", "<snapshot-a> <snapshot-b>", diffCmd},
+    {"ls-artifacts", "<dir>", lsArtifactsCmd},
+    {"stats", "--db <file> [--csv <file>] [--levels-csv <file>]", statsCmd},
+    {"hash-bench", "[-n <# of hashes>] [-batch <# of pairs>]", hashBenchCmd},
+    {"prove-bench", "[-leaves <# of leaves>] [-n <# of proofs>] [-max-parallelism <n>] [-seed <seed>]", proveBenchCmd},
+    {"compare", "[-dicts prefix-tree,ct-log,...] [-repeat <n>] [-workload <workload> [-raw-keys]] [-output-format csv|json] <prng-seed> <csv-output> [<size1> <size2> ... <size-n>]", compareCmd},
+}
+
+/**
+ * Runs the subcommand in os.Args (see 'commands') and returns the process's exit code. This is the
+ * amt tool's main(), see cmd/amt.
+ */
+func Main() int {
+    if len(os.Args) >= 2 {
+        for _, cmd := range commands {
+            if os.Args[1] == cmd.name {
+                var err error
+                if dbOptions, err = _loadDbOptions(); err != nil {
+                    fmt.Printf("Error: %v\n", err)
+                    return 1
+                }
+                code := cmd.run(cmd.name, os.Args[2:])
+                if code == exitUsage {
+                    _printUsage(os.Stdout)
+                }
+                return code
+            }
+        }
+    }
+
+    _printUsage(os.Stdout)
+    return exitUsage
+}
+
+func _printUsage(w io.Writer) {
+    for i, cmd := range commands {
+        prefix := "Usage:"
+        if i > 0 {
+            prefix = "      "
+        }
+        fmt.Fprintf(w, "%s %s %s %s\n", prefix, os.Args[0], cmd.name, cmd.usage)
+    }
+    fmt.Fprintf(w, "\n")
+    fmt.Fprintf(w, "Set %s to a file with a hex-encoded HMAC key to key the --db tree's leaf nos and leaf hashes\n", hmacKeyFileEnv)
+    fmt.Fprintf(w, "(or only one of them, via %s=leaf-nos or %s=leaf-hashes).\n", hmacModeEnv, hmacModeEnv)
+    fmt.Fprintf(w, "\n")
+}
+
+/**
+ * Runs the benchmarks (see hashsparse(), hashcompact(), hashcached() and hashpages()) and, optionally, serves
+ * the resulting tree over HTTP.
+ */
+func benchCmd(name string, cmdArgs []string) int {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    repr := flags.String("repr", "maps", "the tree representation: 'maps' (per-level maps, with append-only proofs), 'compact' (pointer-based, inserts only), 'cached' (compares 'maps' with and without cached levels) or 'pages' (the on-disk bytes per leaf of sharded subtrees, with and without page transforms)")
+    serveAddr := flags.String("serve", "", "after the benchmark, serve the tree over HTTP on this address (e.g., ':8080')")
+    apiKeys := flags.String("api-keys", "", "comma-separated list of API keys allowed to access the HTTP server")
+    metrics := flags.Bool("metrics", false, "collect Prometheus metrics during the benchmark and serve them at /metrics (requires -serve)")
+    proofCacheMB := flags.Int64("proof-cache-mb", 0, "cache up to this many MiB of the membership proofs served over HTTP (with -serve)")
+    proofArchiveDir := flags.String("proof-archive", "", "archive the append-only proof of every epoch in this directory, and serve proofs from it (with -serve)")
+    proofArchiveMB := flags.Int64("proof-archive-mb", 0, "keep at most this many MiB of proofs in the archive, dropping the oldest ones first (0 for no limit)")
+    auditInterval := flags.Duration("audit-interval", 0, "audit the served tree this often in the background, e.g., '10m' (with -serve)")
+    auditSamples := flags.Int("audit-samples", 64, "the # of random leaves whose paths each audit rehashes")
+    maxQueuedLeaves := flags.Int("max-queued-leaves", 0, "accept inserts via POST /v1/leaves, queueing at most this many leaves at once (with -serve)")
+    maxLeavesPerEpoch := flags.Int("max-leaves-per-epoch", 0, "insert at most this many queued leaves per epoch (0 for the default)")
+    epochInterval := flags.Duration("epoch-interval", 0, "insert the queued leaves this often, e.g., '5s' (0 for the default)")
+    var opts BenchOptions
+    flags.BoolVar(&opts.Append, "append", false, "append to the CSV file instead of truncating it")
+    flags.BoolVar(&opts.Resume, "resume", false, "skip the sizes already in the CSV file, rebuilding the tree up to the last one, and append the rest")
+    flags.IntVar(&opts.Repeat, "repeat", 1, "measure proof building, compression and verification this many times per batch, and record the mean and standard deviation")
+    flags.BoolVar(&opts.NoProofs, "no-proofs", false, "insert the leaves without building append-only proofs, to measure insert throughput alone (with -repr maps)")
+    flags.BoolVar(&opts.NoVerify, "no-verify", false, "do not verify the append-only proofs (with -repr maps)")
+    flags.BoolVar(&opts.NoCompress, "no-compress", false, "do not compress the append-only proofs, which implies -no-verify (with -repr maps)")
+    flags.StringVar(&opts.DotFile, "dot", "", "write the proof tree of one batch (see -dot-batch) to this file, in Graphviz's DOT format (with -repr maps)")
+    flags.IntVar(&opts.DotBatch, "dot-batch", 1, "the batch whose proof tree -dot writes, numbered from 1")
+    flags.BoolVar(&opts.Paranoid, "paranoid", false, "also insert every batch in a slow reference tree and cross-check the root hashes and proofs after every epoch (with -repr maps)")
+    flags.BoolVar(&opts.KeepGoing, "keep-going", false, "record the checks a batch fails in the CSV file and go on with the next batch, instead of panicking (with -repr maps)")
+    flags.BoolVar(&opts.HeapProfiles, "heap-profiles", false, "save a pprof heap profile after every batch (requires -artifacts)")
+    flags.IntVar(&opts.CachedLevels, "cached-levels", 0, "the # of top levels of the tree to cache in dense arrays (with -repr cached, defaults to 20)")
+    flags.IntVar(&opts.ProofTreeMaxNodes, "proof-tree-max-nodes", 0, "compress each batch's proof tree whenever it grows beyond this many nodes, to bound its memory (with -repr maps)")
+    flags.IntVar(&opts.SlabSize, "slab-nodes", 0, "allocate the tree's nodes in slabs of this many nodes, rather than one by one (with -repr maps)")
+    flags.StringVar(&opts.Workload, "workload", workloadUniform, "the leaves to insert: 'uniform', 'usernames', 'emails' or 'trace:<file>' (see workload.go)")
+    flags.BoolVar(&opts.RawKeys, "raw-keys", false, "use the workload's keys as leaf no's instead of hashing them (not with -workload uniform)")
+    flags.StringVar(&opts.OutputFormat, "output-format", OutputCSV, "the format of the results: 'csv' or 'json' (JSON Lines with a versioned schema, see CsvWriter)")
+    artifactsDir := flags.String("artifacts", "", "the directory where the CSV file and the append-only proofs are stored")
+    anchorsFile := flags.String("anchors", "", "the file where the HTTP server stores the epochs' anchors (default: in the artifacts directory, if any)")
+    flags.Parse(cmdArgs)
+    args := flags.Args() // exclude the subcommand and its flags
+
+    if len(args) < 2 || (*repr != "maps" && *repr != "compact" && *repr != "cached" && *repr != "pages") || (*serveAddr != "" && *repr != "maps") {
+        return exitUsage
+    }
+
+    if err := checkWorkload(opts.Workload, opts.RawKeys); err != nil {
+        fmt.Printf("Error: %v\n", err)
+        return exitUsage
+    }
 
-    if len(args) < 2 {
-        fmt.Printf("Usage: %s <prng-seed> <csv-output> [<size1> <size2> ... <size-n>]\n", os.Args[0])
-        fmt.Printf("\n")
-        return
+    if err := checkOutputFormat(opts.OutputFormat); err != nil {
+        fmt.Printf("Error: %v\n", err)
+        return exitUsage
+    }
+
+    if *metrics {
+        if *serveAddr == "" {
+            fmt.Printf("Error: -metrics requires -serve\n")
+            return 1
+        }
+        opts.Metrics = NewMetrics()
+    }
+
+    if opts.HeapProfiles && *artifactsDir == "" {
+        fmt.Printf("Error: -heap-profiles requires -artifacts\n")
+        return 1
+    }
+
+    if *artifactsDir != "" {
+        dir, err := OpenArtifactDir(*artifactsDir)
+        if err != nil {
+            fmt.Printf("Error opening artifacts directory: %v\n", err)
+            return 1
+        }
+        opts.Artifacts = dir
     }
 
     var seed int64 = 1337
@@ -43,6 +184,64 @@ func main() {
     fmt.Printf("Sizes: %v, seed: %v\n", sizes, seed)
 
     t := time.Now()
-    hashsparse(sizes, seed, csvFile)
+    var tree *Tree
+    if *repr == "compact" {
+        hashcompact(sizes, seed, csvFile, opts)
+    } else if *repr == "pages" {
+        hashpages(sizes, seed, csvFile, opts)
+    } else if *repr == "cached" {
+        cachedLevels := opts.CachedLevels
+        if cachedLevels == 0 {
+            cachedLevels = 20
+        }
+        hashcached(sizes, seed, csvFile, opts, cachedLevels)
+    } else {
+        tree = hashsparse(sizes, seed, csvFile, opts)
+    }
     fmt.Printf("Took %v\n", time.Since(t))
+
+    if *serveAddr != "" {
+        srvOpts := DefaultServerOptions()
+        srvOpts.APIKeys = strings.Split(*apiKeys, ",")
+        if *anchorsFile == "" && opts.Artifacts != nil {
+            *anchorsFile = opts.Artifacts.NamedPath(ArtifactAnchor, "log", "jsonl")
+        }
+        if *anchorsFile != "" {
+            anchors, err := OpenAnchorStore(*anchorsFile)
+            if err != nil {
+                fmt.Printf("Error opening anchors file: %v\n", err)
+                return 1
+            }
+            defer anchors.Close()
+            srvOpts.Anchors = anchors
+        }
+        srvOpts.Metrics = opts.Metrics
+        srvOpts.ProofCacheBytes = *proofCacheMB << 20
+        srvOpts.AuditInterval = *auditInterval
+        srvOpts.AuditSamples = *auditSamples
+        srvOpts.MaxQueuedLeaves = *maxQueuedLeaves
+        srvOpts.MaxLeavesPerEpoch = *maxLeavesPerEpoch
+        srvOpts.EpochInterval = *epochInterval
+        if *proofArchiveDir != "" {
+            archive, err := OpenProofArchive(*proofArchiveDir, ProofArchiveOptions{MaxBytes: *proofArchiveMB << 20})
+            if err != nil {
+                fmt.Printf("Error opening proof archive: %v\n", err)
+                return 1
+            }
+            n, err := archive.Backfill(tree)
+            if err != nil {
+                fmt.Printf("Error archiving proofs: %v\n", err)
+                return 1
+            }
+            fmt.Printf("Archived %d proofs in %s\n", n, *proofArchiveDir)
+            tree.SetProofArchive(archive)
+            srvOpts.ProofArchive = archive
+        }
+        fmt.Printf("Serving tree with %d epochs on %s ...\n", tree.NumEpochs(), *serveAddr)
+        if err := NewServer(WrapTree(tree), srvOpts).ListenAndServe(*serveAddr); err != nil {
+            fmt.Printf("Error serving HTTP: %v\n", err)
+            return 1
+        }
+    }
+    return 0
 }
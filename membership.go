@@ -0,0 +1,168 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "hash"
+)
+
+/**
+ * A membership (or non-membership) proof for a leaf: the leaf's hash (empty hash if the leaf is
+ * not in the tree) and the hashes of its siblings along the path to the root.
+ */
+type MembershipProof struct {
+    LeafNo   [32]byte
+    LeafHash [32]byte   // the empty hash, if this is a non-membership proof
+    Siblings [][32]byte // Siblings[0] is the leaf's sibling, Siblings[len - 1] is the root's child
+
+    // The opening of the leaf's value, if it was inserted via Tree::InsertCommitted()
+    Opening *Opening
+
+    // The leaf's value, if it was inserted via Tree::InsertValue() and the proof was asked to
+    // include it (see Tree::ProveMembershipWithValue()). Empty values are not nil.
+    Value []byte
+
+    // The leaf's data hash and extensions, if it was inserted via Tree::InsertWithExtensions()
+    Extended *ExtendedLeaf
+}
+
+/**
+ * Returns the hash stored at the specified leaf and true, or the empty hash and false if the leaf
+ * does not exist.
+ */
+func (tree *Tree) Get(leafNo [32]byte) ([32]byte, bool) {
+    leaf, ok := tree.lvl[tree.numLevels-1].node[leafNo]
+    if !ok {
+        return tree.EmptyHash, false
+    }
+
+    return leaf.Hash, true
+}
+
+/**
+ * Returns a proof that the specified leaf is in the tree, or that it is not in the tree if the
+ * leaf does not exist.
+ */
+func (tree *Tree) ProveMembership(leafNo [32]byte) *MembershipProof {
+    tree._restore(tree.numLevels-1, leafNo)
+    return tree._proveMembership(leafNo, tree.getNode)
+}
+
+/**
+ * Returns the membership proof of a leaf whose path is not pruned, looking up nodes via 'getNode'.
+ */
+func (tree *Tree) _proveMembership(leafNo [32]byte, getNode func(*TreeLevel, [32]byte) *Node) *MembershipProof {
+    proof := &MembershipProof{LeafNo: leafNo}
+    proof.LeafHash, _ = tree.Get(leafNo)
+    proof.Siblings = make([][32]byte, 0, tree.numLevels-1)
+    proof.Opening = tree.openings[leafNo]
+    proof.Extended = tree.extended[leafNo]
+
+    tree._visitPath(
+        leafNo,
+        tree.numLevels-1,
+        func(lvl *TreeLevel, nodeNo [32]byte, siblingNo [32]byte, dir bool) {
+            // The root has no sibling
+            if lvl.num == 0 {
+                return
+            }
+
+            siblingHash := tree.EmptyHash
+            if sibling := getNode(lvl, siblingNo); sibling != nil {
+                siblingHash = sibling.Hash
+            }
+            proof.Siblings = append(proof.Siblings, siblingHash)
+        },
+        nil)
+
+    return proof
+}
+
+/**
+ * Checks a membership (or non-membership) proof against the specified root hash of a tree that
+ * uses SHA256 (i.e., created via NewTree()).
+ */
+func VerifyMembershipProof(proof *MembershipProof, rootHash [32]byte) bool {
+    return VerifyMembershipProofWithHash(proof, rootHash, sha256.New)
+}
+
+/**
+ * Checks a membership (or non-membership) proof against the specified root hash of a tree that
+ * uses the specified hash function (i.e., created via NewTreeWithHash()).
+ */
+func VerifyMembershipProofWithHash(proof *MembershipProof, rootHash [32]byte, newHash func() hash.Hash) bool {
+    return VerifyMembershipProofWithOptions(proof, rootHash, TreeOptions{NewHash: newHash})
+}
+
+/**
+ * Checks a membership (or non-membership) proof against the specified root hash of a tree created
+ * via NewTreeWithOptions() with the specified options.
+ */
+func VerifyMembershipProofWithOptions(proof *MembershipProof, rootHash [32]byte, opts TreeOptions) bool {
+    return CheckMembershipProof(proof, rootHash, opts) == nil
+}
+
+/**
+ * Like VerifyMembershipProofWithOptions(), but returns why the proof is invalid (see VerifyError).
+ */
+func CheckMembershipProof(proof *MembershipProof, rootHash [32]byte, opts TreeOptions) error {
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    if newHash().Size() != 32 {
+        return _verifyErrorf(ErrInvalidParams, "hash function must output 32-byte digests, got %d bytes", newHash().Size())
+    }
+
+    numLevels, err := opts._numLevels()
+    if err != nil {
+        return _verifyErrorf(ErrInvalidParams, "%v", err)
+    }
+    if err := opts.HashingScheme._check(); err != nil {
+        return _verifyErrorf(ErrInvalidParams, "%v", err)
+    }
+    if err := opts._checkHMAC(); err != nil {
+        return _verifyErrorf(ErrInvalidParams, "%v", err)
+    }
+    leafLevel := numLevels - 1
+    if len(proof.Siblings) != leafLevel {
+        return _verifyErrorf(ErrMalformedProof, "proof has %d siblings, but a tree with %d levels needs %d", len(proof.Siblings), numLevels, leafLevel)
+    }
+    if !_fitsInLevel(proof.LeafNo, leafLevel) {
+        return _verifyErrorf(ErrMalformedProof, "leaf %s is too large for level %d", hashStr(proof.LeafNo), leafLevel)
+    }
+    if !_checkOpening(proof, opts) {
+        return _verifyErrorf(ErrLeafMismatch, "the opening does not match the leaf hash")
+    }
+    if !_checkValue(proof, opts) {
+        return _verifyErrorf(ErrLeafMismatch, "the value does not match the leaf hash")
+    }
+    if !_checkExtensions(proof, opts) {
+        return _verifyErrorf(ErrLeafMismatch, "the extensions of leaf %s are missing or do not match the leaf hash", hashStr(proof.LeafNo))
+    }
+
+    var emptyHash [32]byte
+    nodeHash := proof.LeafHash
+    for i, siblingHash := range proof.Siblings {
+        // The ancestor at this level is a left child if its last bit is 0
+        isLeft := bitOf(proof.LeafNo, uint(i)) == 0
+
+        // Nodes with empty subtrees are not stored in the tree, so their hash is the empty hash
+        // rather than the hash of their two empty children
+        if nodeHash == emptyHash && siblingHash == emptyHash {
+            continue
+        }
+
+        // The parent is on level leafLevel - 1 - i, and its LN is the leaf's without its last i + 1 bits
+        parentLevel, parentNo := leafLevel-1-i, rshBytes(proof.LeafNo, uint(i+1))
+        if isLeft {
+            nodeHash = _schemeNodeHash(newHash, opts.RFC6962, opts.HashingScheme, parentLevel, parentNo, nodeHash, siblingHash)
+        } else {
+            nodeHash = _schemeNodeHash(newHash, opts.RFC6962, opts.HashingScheme, parentLevel, parentNo, siblingHash, nodeHash)
+        }
+    }
+
+    if !hashEqual(nodeHash, rootHash) {
+        return _verifyErrorf(ErrRootMismatch, "root hash check failed: got %s", hashStr(nodeHash))
+    }
+    return nil
+}
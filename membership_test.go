@@ -0,0 +1,177 @@
+package aomt
+
+import (
+    "errors"
+    "fmt"
+    "testing"
+)
+
+/**
+ * Returns a tree with the specified options and 40 leaves whose leaf nos fit in the tree, all
+ * inserted with extensions if the options require them, or else a third each inserted plainly,
+ * with a value and committed.
+ */
+func _testMembershipTree(t *testing.T, numLevels int, opts TreeOptions) (*Tree, [][32]byte) {
+    tree, err := NewTreeWithOptions(numLevels, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var leafNos [][32]byte
+    for i := 0; i < 40; i++ {
+        leaf := _testLeaf(i)
+        for bit := 0; bit < maxNumLevels-numLevels; bit++ {
+            leaf.LeafNo[bit/8] &^= 0x80 >> uint(bit%8)
+        }
+        value := []byte(fmt.Sprintf("value %d", i))
+        switch {
+        case opts.LeafExtensions:
+            tree.InsertWithExtensions(leaf.LeafNo, leaf.DataHash, LeafExtensions{Timestamp: uint64(i), Policy: value}, nil)
+        case i%3 == 0:
+            tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        case i%3 == 1:
+            tree.InsertValue(leaf.LeafNo, value, nil)
+        default:
+            tree.InsertCommitted(leaf.LeafNo, value, nil)
+        }
+        leafNos = append(leafNos, leaf.LeafNo)
+    }
+    return tree, leafNos
+}
+
+/**
+ * Returns a copy of the proof, so that tampering with it leaves the original intact.
+ */
+func _testCopyMembershipProof(proof *MembershipProof) *MembershipProof {
+    p := *proof
+    p.Siblings = append([][32]byte{}, proof.Siblings...)
+    if proof.Opening != nil {
+        opening := *proof.Opening
+        p.Opening = &opening
+    }
+    if proof.Value != nil {
+        p.Value = append([]byte{}, proof.Value...)
+    }
+    if proof.Extended != nil {
+        extended := *proof.Extended
+        p.Extended = &extended
+    }
+    return &p
+}
+
+/**
+ * Membership and non-membership proofs must verify for every hashing option, and must not verify
+ * once any of their parts is tampered with, failing with the right kind of VerifyError.
+ */
+func TestMembershipProofRejectsTampering(t *testing.T) {
+    cases := []struct {
+        name      string
+        numLevels int
+        opts      TreeOptions
+    }{
+        {"plain", 257, TreeOptions{}},
+        {"rfc6962", 257, TreeOptions{RFC6962: true}},
+        {"level", 257, TreeOptions{HashingScheme: HashingLevel}},
+        {"level-ln", 257, TreeOptions{RFC6962: true, HashingScheme: HashingLevelLN}},
+        {"keccak", 257, TreeOptions{NewHash: NewKeccak256}},
+        {"hmac", 257, TreeOptions{HMACKey: []byte("key"), HMACLeafHashes: true}},
+        {"extensions", 257, TreeOptions{LeafExtensions: true}},
+        {"16 levels", 16, TreeOptions{HashingScheme: HashingLevelLN}},
+    }
+    for _, c := range cases {
+        tree, leafNos := _testMembershipTree(t, c.numLevels, c.opts)
+        opts := c.opts
+        opts.NumLevels = c.numLevels
+        root := tree.GetRootHash()
+        missing := _testLeaf(1000).LeafNo
+        for bit := 0; bit < maxNumLevels-c.numLevels; bit++ {
+            missing[bit/8] &^= 0x80 >> uint(bit%8)
+        }
+
+        for i, leafNo := range append(append([][32]byte{}, leafNos[:8]...), missing) {
+            proof := tree.ProveMembershipWithValue(leafNo)
+            if err := CheckMembershipProof(proof, root, opts); err != nil {
+                t.Fatalf("%s: proof of leaf %d does not verify: %v", c.name, i, err)
+            }
+
+            // The tampered proofs, and the kind of error each must fail with (nil for any)
+            tampered := map[string]*MembershipProof{}
+            kinds := map[string]error{}
+            tamper := func(name string, kind error, change func(p *MembershipProof)) {
+                tampered[name] = _testCopyMembershipProof(proof)
+                change(tampered[name])
+                kinds[name] = kind
+            }
+
+            tamper("sibling dropped", ErrMalformedProof, func(p *MembershipProof) { p.Siblings = p.Siblings[1:] })
+            tamper("sibling added", ErrMalformedProof, func(p *MembershipProof) { p.Siblings = append(p.Siblings, [32]byte{}) })
+            if c.numLevels < maxNumLevels {
+                tamper("leaf no out of its level", ErrMalformedProof, func(p *MembershipProof) { p.LeafNo[0] |= 0x80 })
+            }
+            exists := proof.LeafHash != tree.EmptyHash
+            if exists {
+                tamper("leaf hash flipped", nil, func(p *MembershipProof) { p.LeafHash[0] ^= 1 })
+                tamper("leaf hash dropped", nil, func(p *MembershipProof) { p.LeafHash = [32]byte{} })
+                tamper("leaf no flipped", nil, func(p *MembershipProof) { p.LeafNo[31] ^= 1 })
+            } else {
+                // The non-membership proof of a leaf is also that of its sibling, if both are empty,
+                // and verifiers that require extensions reject a leaf without them before the root
+                kind := ErrRootMismatch
+                if opts.LeafExtensions {
+                    kind = ErrLeafMismatch
+                }
+                tamper("leaf hash added", kind, func(p *MembershipProof) { p.LeafHash[0] ^= 1 })
+            }
+            if proof.Value != nil {
+                tamper("value flipped", ErrLeafMismatch, func(p *MembershipProof) { p.Value[0] ^= 1 })
+            }
+            if proof.Opening != nil {
+                tamper("salt flipped", ErrLeafMismatch, func(p *MembershipProof) { p.Opening.Salt[0] ^= 1 })
+                tamper("value changed", ErrLeafMismatch, func(p *MembershipProof) { p.Opening.Value = []byte("other") })
+            }
+            if proof.Extended != nil {
+                tamper("timestamp changed", ErrLeafMismatch, func(p *MembershipProof) { p.Extended.Extensions.Timestamp++ })
+            }
+
+            for name, p := range tampered {
+                err := CheckMembershipProof(p, root, opts)
+                if err == nil {
+                    t.Errorf("%s: proof of leaf %d verifies with %s", c.name, i, name)
+                } else if kinds[name] != nil && !errors.Is(err, kinds[name]) {
+                    t.Errorf("%s: proof of leaf %d with %s failed with %v, expected %v", c.name, i, name, err, kinds[name])
+                }
+            }
+
+            // Verifiers that require extensions must reject proofs without them
+            if proof.Extended != nil {
+                dropped := _testCopyMembershipProof(proof)
+                dropped.Extended = nil
+                if err := CheckMembershipProof(dropped, root, opts); !errors.Is(err, ErrLeafMismatch) {
+                    t.Errorf("%s: proof of leaf %d with its extensions dropped failed with %v", c.name, i, err)
+                }
+            }
+
+            // Most siblings are empty, and flipping any of them fails alike
+            for j := range proof.Siblings {
+                if proof.Siblings[j] == tree.EmptyHash && j%32 != 0 {
+                    continue
+                }
+                p := _testCopyMembershipProof(proof)
+                p.Siblings[j][0] ^= 1
+                if err := CheckMembershipProof(p, root, opts); !errors.Is(err, ErrRootMismatch) {
+                    t.Errorf("%s: proof of leaf %d with sibling %d flipped failed with %v", c.name, i, j, err)
+                }
+            }
+
+            if err := CheckMembershipProof(proof, tree.EmptyHash, opts); !errors.Is(err, ErrRootMismatch) {
+                t.Errorf("%s: proof of leaf %d against another root failed with %v", c.name, i, err)
+            }
+            // Verifiers with other options must reject the proof
+            other := opts
+            other.HashingScheme = (opts.HashingScheme + 1) % (HashingLevelLN + 1)
+            if err := CheckMembershipProof(proof, root, other); err == nil && exists {
+                t.Errorf("%s: proof of leaf %d verifies with hashing scheme %d", c.name, i, other.HashingScheme)
+            }
+        }
+    }
+}
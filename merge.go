@@ -0,0 +1,133 @@
+package aomt
+
+import (
+    "errors"
+    "fmt"
+)
+
+/**
+ * Merges the leaves of 'other' into this tree, e.g., to combine trees built independently on
+ * several machines (say, one per prefix of the leaf nos). The trees must have the same parameters
+ * (see Tree::ParamsDigest()), and leaves in both trees must have the same hash, or else nothing is
+ * merged and an error is returned.
+ *
+ * The nodes of 'other' whose subtrees are empty in this tree are copied as they are, so only the
+ * nodes in both trees have to be rehashed, which is cheap when the trees' leaves are mostly in
+ * different subtrees. The changes of 'other' (its epochs and pending changes) to the leaves that
+ * were not already in this tree become pending changes of this tree, so the merge is part of the
+ * next epoch recorded via RecordEpoch() and of the append-only proofs about it.
+ */
+func (tree *Tree) Merge(other *Tree) error {
+    if tree.ParamsDigest() != other.ParamsDigest() {
+        return errors.New("cannot merge trees with different parameters (# of levels or hash function)")
+    }
+    if tree.open != nil || other.open != nil {
+        return errors.New("cannot merge trees with an open epoch")
+    }
+    if other.numPruned > 0 {
+        return errors.New("cannot merge a pruned tree, whose interior nodes are missing")
+    }
+
+    // Check all leaves first, so that we don't merge half of the tree
+    lastLevel := tree.numLevels - 1
+    leafNodes := tree.lvl[lastLevel].node
+    merged := make(map[[32]byte]bool)
+    for leafNo, leaf := range other.lvl[lastLevel].node {
+        if existing, ok := leafNodes[leafNo]; ok {
+            if existing.Hash != leaf.Hash {
+                return fmt.Errorf("leaf %s has hash %s in this tree but hash %s in the other tree",
+                    hashStr(leafNo), hashStr(existing.Hash), hashStr(leaf.Hash))
+            }
+            continue
+        }
+        merged[leafNo] = true
+    }
+
+    // The interior nodes along the other tree's paths must exist, in case this tree was pruned
+    for leafNo := range other.lvl[lastLevel].node {
+        tree._restore(lastLevel, leafNo)
+    }
+
+    // Copy the nodes that are only in 'other' and remember the ones in both trees
+    shared := make([][][32]byte, lastLevel)
+    for level := 0; level <= lastLevel; level++ {
+        lvlNodes := tree.lvl[level].node
+        for nodeNo, node := range other.lvl[level].node {
+            if _, ok := lvlNodes[nodeNo]; ok {
+                if level < lastLevel {
+                    shared[level] = append(shared[level], nodeNo)
+                }
+                continue
+            }
+
+            merged := tree.lvl[level].newNode()
+            merged.Hash = node.Hash
+            tree._putNode(tree.lvl[level], nodeNo, merged)
+            tree._recordVersion(level, nodeNo, node.Hash)
+        }
+    }
+
+    // Rehash the nodes in both trees from the bottom up, so that their children are up to date.
+    // The nodes of a level do not depend on each other, so each level is hashed in one batch.
+    var pairs []hashPair
+    var hashes [][32]byte
+    for level := lastLevel - 1; level >= 0; level-- {
+        childLvl := tree.lvl[level+1]
+        pairs = pairs[:0]
+        for _, nodeNo := range shared[level] {
+            leftNo, rightNo := childrenOf(nodeNo)
+            pair := hashPair{tree.EmptyHash, tree.EmptyHash}
+            if left := childLvl.get(leftNo); left != nil {
+                pair.left = left.Hash
+            }
+            if right := childLvl.get(rightNo); right != nil {
+                pair.right = right.Hash
+            }
+            pairs = append(pairs, pair)
+        }
+
+        if cap(hashes) < len(pairs) {
+            hashes = make([][32]byte, len(pairs))
+        }
+        hashes = hashes[:len(pairs)]
+        tree._merkleHashPairs(level, shared[level], pairs, hashes)
+
+        for i, nodeNo := range shared[level] {
+            node := tree.lvl[level].get(nodeNo)
+            node.Hash = hashes[i]
+            tree._recordVersion(level, nodeNo, node.Hash)
+        }
+    }
+
+    // Replay the other tree's log for the merged leaves
+    var changes []epochLeaf
+    for _, record := range other.epochs {
+        changes = append(changes, record.changes...)
+    }
+    changes = append(changes, other.pending...)
+    for _, change := range changes {
+        if merged[change.leafNo] {
+            tree.pending = append(tree.pending, change)
+            if !change.isUpdate {
+                tree._notifyInsert(change.leafNo, change.dataHash)
+            }
+        }
+    }
+
+    for leafNo := range merged {
+        if versions, ok := other.history[leafNo]; ok {
+            tree.history[leafNo] = append([][32]byte(nil), versions...)
+        }
+        if opening, ok := other.openings[leafNo]; ok {
+            tree.openings[leafNo] = opening
+        }
+        if value, ok := other.values[leafNo]; ok {
+            tree.values[leafNo] = value
+        }
+        if leaf, ok := other.extended[leafNo]; ok {
+            tree.extended[leafNo] = leaf
+        }
+    }
+
+    return nil
+}
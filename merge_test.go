@@ -0,0 +1,69 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Returns a tree with the test leaves from..to-1.
+ */
+func _testMergeTree(from int, to int) *Tree {
+    tree := NewTree(257)
+    for i := from; i < to; i++ {
+        leaf := _testLeaf(i)
+        tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    }
+    return tree
+}
+
+/**
+ * Merging overlapping trees must give the tree with the leaves of both, whose new leaves are part
+ * of the next epoch, while conflicting trees must not be merged at all.
+ */
+func TestMergeMatchesInserts(t *testing.T) {
+    tree := _testMergeTree(0, 60)
+    tree.RecordEpoch()
+    other := _testMergeTree(30, 100)
+    other.RecordEpoch()
+    leaf := _testLeaf(100)
+    other.Insert(leaf.LeafNo, leaf.DataHash, nil)
+
+    if err := tree.Merge(other); err != nil {
+        t.Fatal(err)
+    }
+    reference := _testMergeTree(0, 101)
+    if tree.GetRootHash() != reference.GetRootHash() {
+        t.Fatalf("merged tree has root %s, expected %s", hashStr(tree.GetRootHash()), hashStr(reference.GetRootHash()))
+    }
+    if tree.NumPendingChanges() != 41 {
+        t.Errorf("merged tree has %d pending changes, expected the 41 new leaves", tree.NumPendingChanges())
+    }
+    tree.RecordEpoch()
+    proof := tree.ProveAppendOnly(0, 1)
+    if !VerifyAppendOnlyProof(proof, tree.GetEpochRoot(0), tree.GetEpochRoot(1)) {
+        t.Errorf("append-only proof of the merge does not verify")
+    }
+    for i := 0; i <= 100; i += 9 {
+        proof := tree.ProveMembership(_testLeaf(i).LeafNo)
+        if proof.LeafHash != _testLeaf(i).DataHash || !VerifyMembershipProof(proof, tree.GetRootHash()) {
+            t.Errorf("leaf %d is not proven to be in the merged tree", i)
+        }
+    }
+
+    conflicting := _testMergeTree(50, 70)
+    conflicting.Update(_testLeaf(55).LeafNo, _testLeaf(-1).DataHash, nil)
+    root := tree.GetRootHash()
+    if err := tree.Merge(conflicting); err == nil {
+        t.Errorf("merging a tree with another hash for a leaf succeeds")
+    }
+    if tree.GetRootHash() != root || tree.NumPendingChanges() != 0 {
+        t.Errorf("failed merge changed the tree")
+    }
+    rfc6962, err := NewTreeWithOptions(257, TreeOptions{RFC6962: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := tree.Merge(rfc6962); err == nil {
+        t.Errorf("merging a tree with other parameters succeeds")
+    }
+}
@@ -0,0 +1,218 @@
+package aomt
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "runtime"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/**
+ * Metrics about a long-running tree (e.g., one backing a transparency log), in the Prometheus
+ * text exposition format, so that operators can scrape them from the server's /metrics endpoint:
+ *
+ *   aomt_inserts_total, aomt_updates_total    the leaves inserted and updated in recorded epochs
+ *                                             (inserts/sec is their rate())
+ *   aomt_epochs_total                         the recorded epochs
+ *   aomt_audits_total, aomt_audit_failures_total   the server's self-audits, and the failed ones
+ *                                             (see SelfAuditor)
+ *   aomt_nodes{levels="<from>-<to>"}          the # of nodes in every band of metricsLevelBand levels
+ *   aomt_proof_bytes                          a histogram of the sizes of the epochs' append-only
+ *                                             proofs, as sent by MarshalAdaptive()
+ *   aomt_verify_seconds                       a histogram of how long verifying append-only proofs
+ *                                             takes, as reported via ObserveVerify()
+ *   aomt_heap_alloc_bytes, aomt_heap_sys_bytes   the Go heap's usage, see runtime.MemStats
+ *
+ * A Metrics can be shared by several trees (e.g., a forest's namespaces) and used concurrently.
+ * Trees only update it if it was set via Tree::SetMetrics(), so the tree has no overhead otherwise.
+ *
+ * NOTE: The counters start at 0 when the process starts, so epochs loaded from a snapshot or
+ * replayed from a WAL are counted too.
+ */
+type Metrics struct {
+    inserts uint64 // accessed atomically
+    updates uint64 // accessed atomically
+    epochs  uint64 // accessed atomically
+
+    audits        uint64 // accessed atomically
+    auditFailures uint64 // accessed atomically
+
+    proofBytes    *metricsHistogram
+    verifySeconds *metricsHistogram
+}
+
+// The # of levels whose nodes are counted together in aomt_nodes
+const metricsLevelBand = 32
+
+/**
+ * A Prometheus histogram, i.e., cumulative counts of the observations at most every bucket's
+ * upper bound, along with their count and sum.
+ */
+type metricsHistogram struct {
+    mu     sync.Mutex
+    bounds []float64
+    counts []uint64 // counts[i] is the # of observations in (bounds[i-1], bounds[i]], not cumulative
+    count  uint64
+    sum    float64
+}
+
+func NewMetrics() *Metrics {
+    return &Metrics{
+        proofBytes:    _newMetricsHistogram(256, 1<<10, 4<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20, 16<<20),
+        verifySeconds: _newMetricsHistogram(.00001, .0001, .001, .01, .1, 1, 10),
+    }
+}
+
+func _newMetricsHistogram(bounds ...float64) *metricsHistogram {
+    return &metricsHistogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *metricsHistogram) observe(value float64) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    for i, bound := range h.bounds {
+        if value <= bound {
+            h.counts[i]++
+            break
+        }
+    }
+    h.count++
+    h.sum += value
+}
+
+func (h *metricsHistogram) write(w io.Writer, name, help string) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+    var cumulative uint64
+    for i, bound := range h.bounds {
+        cumulative += h.counts[i]
+        fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+    }
+    fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+    fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+/**
+ * Records how long verifying an append-only proof took. Trees do not verify their own proofs, so
+ * whoever does (e.g., the benchmark or an auditor) reports it here.
+ */
+func (m *Metrics) ObserveVerify(elapsed time.Duration) {
+    m.verifySeconds.observe(elapsed.Seconds())
+}
+
+/**
+ * Counts the leaf changes of an epoch that is being recorded. Does nothing if 'm' is nil.
+ */
+func (m *Metrics) _observeEpoch(changes []epochLeaf) {
+    if m == nil {
+        return
+    }
+
+    var updates uint64
+    for _, change := range changes {
+        if change.isUpdate {
+            updates++
+        }
+    }
+    atomic.AddUint64(&m.updates, updates)
+    atomic.AddUint64(&m.inserts, uint64(len(changes))-updates)
+    atomic.AddUint64(&m.epochs, 1)
+}
+
+/**
+ * Counts an audit of the tree (see SelfAuditor). Does nothing if 'm' is nil.
+ */
+func (m *Metrics) _observeAudit(failed bool) {
+    if m == nil {
+        return
+    }
+
+    atomic.AddUint64(&m.audits, 1)
+    if failed {
+        atomic.AddUint64(&m.auditFailures, 1)
+    }
+}
+
+/**
+ * Records the size of an epoch's append-only proof, in the form picked by Epoch::Commit() (plus
+ * the form's header, see MarshalAdaptive()). Does nothing if 'm' is nil.
+ */
+func (m *Metrics) _observeProof(proof *AppendOnlyProof) {
+    if m == nil {
+        return
+    }
+
+    size := proof.CompressedSize()
+    if proof.Form == ProofFormPaths {
+        size = proof.PathsSize()
+    }
+    m.proofBytes.observe(float64(1 + size))
+}
+
+/**
+ * Writes the metrics in the Prometheus text exposition format. 'nodeCounts' are the # of nodes in
+ * every band of levels (see NodeCountsByBand()), summed over the trees whose metrics these are.
+ */
+func (m *Metrics) WriteText(w io.Writer, nodeCounts []int64) error {
+    bw := bufio.NewWriter(w)
+
+    counters := []struct {
+        name, help string
+        value      *uint64
+    }{
+        {"aomt_inserts_total", "Leaves inserted in recorded epochs.", &m.inserts},
+        {"aomt_updates_total", "Leaves updated in recorded epochs.", &m.updates},
+        {"aomt_epochs_total", "Recorded epochs.", &m.epochs},
+        {"aomt_audits_total", "Self-audits of the tree.", &m.audits},
+        {"aomt_audit_failures_total", "Self-audits of the tree that found a problem.", &m.auditFailures},
+    }
+    for _, c := range counters {
+        fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, atomic.LoadUint64(c.value))
+    }
+
+    fmt.Fprintf(bw, "# HELP aomt_nodes Nodes in the tree, per band of %d levels.\n# TYPE aomt_nodes gauge\n", metricsLevelBand)
+    for band, count := range nodeCounts {
+        from := band * metricsLevelBand
+        fmt.Fprintf(bw, "aomt_nodes{levels=\"%d-%d\"} %d\n", from, from+metricsLevelBand-1, count)
+    }
+
+    m.proofBytes.write(bw, "aomt_proof_bytes", "Sizes of the epochs' append-only proofs.")
+    m.verifySeconds.write(bw, "aomt_verify_seconds", "Time spent verifying append-only proofs.")
+
+    var mem runtime.MemStats
+    runtime.ReadMemStats(&mem)
+    fmt.Fprintf(bw, "# HELP aomt_heap_alloc_bytes Bytes of allocated heap objects.\n# TYPE aomt_heap_alloc_bytes gauge\naomt_heap_alloc_bytes %d\n", mem.HeapAlloc)
+    fmt.Fprintf(bw, "# HELP aomt_heap_sys_bytes Bytes of heap memory obtained from the OS.\n# TYPE aomt_heap_sys_bytes gauge\naomt_heap_sys_bytes %d\n", mem.HeapSys)
+
+    return bw.Flush()
+}
+
+/**
+ * Returns the # of nodes in every band of metricsLevelBand levels, from the root down, adding
+ * them to 'counts' (which can be nil) so that the counts of several trees can be summed.
+ */
+func (tree *Tree) NodeCountsByBand(counts []int64) []int64 {
+    numBands := (tree.numLevels + metricsLevelBand - 1) / metricsLevelBand
+    for len(counts) < numBands {
+        counts = append(counts, 0)
+    }
+    for level := 0; level < tree.numLevels; level++ {
+        counts[level/metricsLevelBand] += int64(len(tree.lvl[level].node))
+    }
+    return counts
+}
+
+/**
+ * Makes the tree count its inserts, updates and epochs, and the sizes of its epochs' append-only
+ * proofs, in 'm' (or stop counting, if 'm' is nil).
+ */
+func (tree *Tree) SetMetrics(m *Metrics) {
+    tree.metrics = m
+}
@@ -0,0 +1,287 @@
+package aomt
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+)
+
+/**
+ * A mapped tree file is an on-disk layout of a tree's nodes that verifiers and auditors can open
+ * via OpenSnapshotMMap() without loading it into RAM: the file is memory-mapped and Get() and
+ * ProveMembership() binary-search the sorted nodes of each level in place, so only the pages on
+ * the searched paths are read from disk. The layout is:
+ *
+ *   magic        ("AOMTMMAP", 8 bytes)
+ *   version      (1 byte, currently 1)
+ *   numLevels    (2 bytes)
+ *   for each level, from the root to the leaves, the level's index:
+ *     offset     (8 bytes), the offset of the level's first node record in the file
+ *     numNodes   (8 bytes)
+ *   for each level, from the root to the leaves, its node records sorted by LN, each encoded as:
+ *     LN         (ceil(level / 8) bytes, as in AppendOnlyProof)
+ *     flags      (1 byte: bit 0 is set for 'new' nodes, see TreeLevel::newNodes)
+ *     hash       (32 bytes)
+ *
+ * so the records of a level all have the same size. All integers are big-endian.
+ *
+ * NOTE: Only the nodes are stored, so the file has no epochs, version chains, openings or values,
+ * and proofs from it have none either. Pruned trees cannot be written, since proofs about leaves
+ * in pruned subtrees need the pruned nodes.
+ */
+const mmapMagic = "AOMTMMAP"
+const mmapVersion = 1
+
+const mmapFlagNew = 1 << 0
+
+// The size of the magic, version and # of levels, followed by the levels' indexes
+const mmapHeaderSize = len(mmapMagic) + 1 + 2
+const mmapIndexEntrySize = 8 + 8
+
+/**
+ * Writes the tree's nodes to 'w' in the mapped tree file layout (see OpenSnapshotMMap()). The
+ * tree cannot have an open epoch nor be pruned.
+ */
+func (tree *Tree) SnapshotMMap(w io.Writer) error {
+    if tree.open != nil {
+        return errors.New("cannot snapshot a tree with an open epoch")
+    }
+    if tree.numPruned > 0 {
+        return errors.New("cannot write a mapped tree file of a pruned tree")
+    }
+
+    levelNodes := make([][][32]byte, tree.numLevels)
+    for level := 0; level < tree.numLevels; level++ {
+        levelNodes[level] = tree.lvl[level].sortedNodeNos()
+    }
+
+    bw := bufio.NewWriter(w)
+    bw.WriteString(mmapMagic)
+    bw.WriteByte(mmapVersion)
+    binary.Write(bw, binary.BigEndian, uint16(tree.numLevels))
+
+    offset := uint64(mmapHeaderSize + tree.numLevels*mmapIndexEntrySize)
+    for level, nodeNos := range levelNodes {
+        binary.Write(bw, binary.BigEndian, offset)
+        binary.Write(bw, binary.BigEndian, uint64(len(nodeNos)))
+        offset += uint64(len(nodeNos)) * uint64(_mmapRecordSize(level))
+    }
+
+    for level, nodeNos := range levelNodes {
+        lvlNodes := tree.lvl[level].node
+        for _, nodeNo := range nodeNos {
+            node := lvlNodes[nodeNo]
+            var flags byte
+            if tree.lvl[level].isNew(nodeNo) {
+                flags |= mmapFlagNew
+            }
+
+            bw.Write(nodeNo[32-_lnNumBytes(level):])
+            bw.WriteByte(flags)
+            bw.Write(node.Hash[:])
+        }
+    }
+
+    // bufio.Writer remembers the first write error, so we only need to check it here
+    return bw.Flush()
+}
+
+/**
+ * Returns the size of a node record on level 'level'.
+ */
+func _mmapRecordSize(level int) int {
+    return _lnNumBytes(level) + 1 + 32
+}
+
+/**
+ * A read-only tree backed by a memory-mapped file (see OpenSnapshotMMap()). It can be used
+ * concurrently, and must be closed to unmap the file.
+ */
+type MappedTree struct {
+    data      []byte
+    numLevels int
+    levels    []mappedLevel
+    unmap     func() error
+
+    prefetchWorkers int // the # of goroutines that look up a proof's siblings, see SetPrefetch()
+}
+
+/**
+ * The node records of a level in the mapped file.
+ */
+type mappedLevel struct {
+    records    []byte
+    numNodes   int
+    lnBytes    int
+    recordSize int
+}
+
+/**
+ * Opens a mapped tree file written via Tree::SnapshotMMap(), mapping it into memory read-only.
+ * The layout is checked (i.e., that every level's records are in the file), but the records are
+ * not read, so opening a big file is fast. Records that are out of order make lookups miss nodes,
+ * which makes proofs fail to verify rather than the reader crash.
+ */
+func OpenSnapshotMMap(path string) (*MappedTree, error) {
+    data, unmap, err := _mmapFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    mt, err := _newMappedTree(data)
+    if err != nil {
+        unmap()
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    mt.unmap = unmap
+    return mt, nil
+}
+
+func _newMappedTree(data []byte) (*MappedTree, error) {
+    if len(data) < mmapHeaderSize || string(data[:len(mmapMagic)]) != mmapMagic {
+        return nil, errors.New("not a mapped tree file")
+    }
+    if version := data[len(mmapMagic)]; version != mmapVersion {
+        return nil, fmt.Errorf("unsupported mapped tree file version %d", version)
+    }
+    numLevels := int(binary.BigEndian.Uint16(data[len(mmapMagic)+1:]))
+    if numLevels < 2 || numLevels > maxNumLevels {
+        return nil, fmt.Errorf("trees must have between 2 and %d levels, not %d", maxNumLevels, numLevels)
+    }
+
+    indexEnd := mmapHeaderSize + numLevels*mmapIndexEntrySize
+    if len(data) < indexEnd {
+        return nil, errors.New("truncated level index")
+    }
+
+    mt := &MappedTree{data: data, numLevels: numLevels, levels: make([]mappedLevel, numLevels)}
+    for level := 0; level < numLevels; level++ {
+        entry := data[mmapHeaderSize+level*mmapIndexEntrySize:]
+        offset := binary.BigEndian.Uint64(entry)
+        numNodes := binary.BigEndian.Uint64(entry[8:])
+        recordSize := uint64(_mmapRecordSize(level))
+
+        // Check for overflows before multiplying, since the index may be bogus
+        if offset < uint64(indexEnd) || offset > uint64(len(data)) || numNodes > (uint64(len(data))-offset)/recordSize {
+            return nil, fmt.Errorf("level %d's %d records are not in the file", level, numNodes)
+        }
+        mt.levels[level] = mappedLevel{
+            records:    data[offset : offset+numNodes*recordSize],
+            numNodes:   int(numNodes),
+            lnBytes:    _lnNumBytes(level),
+            recordSize: int(recordSize),
+        }
+    }
+    return mt, nil
+}
+
+/**
+ * Unmaps the file. The tree must not be used afterwards.
+ */
+func (mt *MappedTree) Close() error {
+    if mt.unmap == nil {
+        return nil
+    }
+    err := mt.unmap()
+    mt.unmap = nil
+    mt.data = nil
+    return err
+}
+
+func (mt *MappedTree) NumLevels() int {
+    return mt.numLevels
+}
+
+/**
+ * Returns the # of nodes on the leaf level.
+ */
+func (mt *MappedTree) NumLeaves() int {
+    return mt.levels[mt.numLevels-1].numNodes
+}
+
+/**
+ * Returns the hash of the node with LN 'nodeNo' on level 'level', or nil if there is no such node.
+ */
+func (mt *MappedTree) _getNode(level int, nodeNo [32]byte) *[32]byte {
+    lvl := &mt.levels[level]
+    ln := nodeNo[32-lvl.lnBytes:]
+
+    i := sort.Search(lvl.numNodes, func(i int) bool {
+        record := lvl.records[i*lvl.recordSize:]
+        return bytes.Compare(record[:lvl.lnBytes], ln) >= 0
+    })
+    if i == lvl.numNodes {
+        return nil
+    }
+    record := lvl.records[i*lvl.recordSize : (i+1)*lvl.recordSize]
+    if !bytes.Equal(record[:lvl.lnBytes], ln) {
+        return nil
+    }
+
+    var hash [32]byte
+    copy(hash[:], record[lvl.lnBytes+1:])
+    return &hash
+}
+
+/**
+ * Returns the root hash, or the empty hash if the tree is empty.
+ */
+func (mt *MappedTree) GetRootHash() [32]byte {
+    if root := mt._getNode(0, [32]byte{}); root != nil {
+        return *root
+    }
+    return [32]byte{}
+}
+
+/**
+ * Returns the hash stored at the specified leaf and true, or the empty hash and false if the leaf
+ * does not exist (see Tree::Get()).
+ */
+func (mt *MappedTree) Get(leafNo [32]byte) ([32]byte, bool) {
+    if hash := mt._getNode(mt.numLevels-1, leafNo); hash != nil {
+        return *hash, true
+    }
+    return [32]byte{}, false
+}
+
+/**
+ * Returns a proof that the specified leaf is in the tree, or that it is not in the tree if the
+ * leaf does not exist (see Tree::ProveMembership()).
+ */
+func (mt *MappedTree) ProveMembership(leafNo [32]byte) *MembershipProof {
+    proof := &MembershipProof{LeafNo: leafNo}
+    proof.LeafHash, _ = mt.Get(leafNo)
+    proof.Siblings = PrefetchSiblings(mt._lookupNode, mt.numLevels, leafNo, mt.prefetchWorkers)
+    return proof
+}
+
+/**
+ * Returns the membership proofs of 'leafNos', in the same order. The siblings of up to 'lookahead'
+ * upcoming leaves are looked up in the background while the current proof is assembled (see
+ * ProveMembershipsWith()).
+ */
+func (mt *MappedTree) ProveMemberships(leafNos [][32]byte, lookahead int) []*MembershipProof {
+    return ProveMembershipsWith(mt._lookupNode, mt.numLevels, leafNos, lookahead, mt.prefetchWorkers)
+}
+
+/**
+ * Makes proofs look up their siblings via 'workers' goroutines, or one level at a time if
+ * 'workers' is at most 1 (the default).
+ */
+func (mt *MappedTree) SetPrefetch(workers int) {
+    mt.prefetchWorkers = workers
+}
+
+/**
+ * A NodeLookup over the mapped file's records.
+ */
+func (mt *MappedTree) _lookupNode(level int, nodeNo [32]byte) ([32]byte, bool) {
+    if hash := mt._getNode(level, nodeNo); hash != nil {
+        return *hash, true
+    }
+    return [32]byte{}, false
+}
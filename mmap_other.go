@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package aomt
+
+import (
+    "io/ioutil"
+)
+
+/**
+ * Reads the file at 'path' into memory, on platforms where we do not map files.
+ */
+func _mmapFile(path string) ([]byte, func() error, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, nil, err
+    }
+    return data, func() error { return nil }, nil
+}
@@ -0,0 +1,57 @@
+package aomt
+
+import (
+    "bytes"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+/**
+ * A mapped tree file must have the tree's root, leaves and membership proofs, while truncated
+ * files must not open.
+ */
+func TestMappedTreeMatchesTree(t *testing.T) {
+    tree := _testEpochTree(3)
+    tree.RecordEpoch()
+    var buf bytes.Buffer
+    if err := tree.SnapshotMMap(&buf); err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(t.TempDir(), "tree.mmap")
+    if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    mt, err := OpenSnapshotMMap(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer mt.Close()
+    if mt.GetRootHash() != tree.GetRootHash() {
+        t.Fatalf("mapped tree has root %s, expected %s", hashStr(mt.GetRootHash()), hashStr(tree.GetRootHash()))
+    }
+    for i := 0; i < 100; i += 3 {
+        leafNo := _testLeaf(i).LeafNo
+        hash, ok := mt.Get(leafNo)
+        treeHash, treeOk := tree.Get(leafNo)
+        if hash != treeHash || ok != treeOk {
+            t.Errorf("mapped tree has leaf %d as %s (%v), expected %s (%v)", i, hashStr(hash), ok, hashStr(treeHash), treeOk)
+        }
+        proof := mt.ProveMembership(leafNo)
+        if !reflect.DeepEqual(proof, tree.ProveMembership(leafNo)) {
+            t.Errorf("mapped tree proves leaf %d unlike the tree", i)
+        }
+        if !VerifyMembershipProof(proof, tree.GetRootHash()) {
+            t.Errorf("mapped tree's proof of leaf %d does not verify", i)
+        }
+    }
+
+    data := buf.Bytes()
+    for n := 0; n < len(data); n += len(data)/16 + 1 {
+        if _, err := _newMappedTree(data[:n]); err == nil {
+            t.Errorf("mapped tree file truncated to %d of %d bytes opens", n, len(data))
+        }
+    }
+}
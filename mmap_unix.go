@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package aomt
+
+import (
+    "os"
+    "syscall"
+)
+
+/**
+ * Maps the file at 'path' into memory read-only, returning its bytes and a function that unmaps it.
+ */
+func _mmapFile(path string) ([]byte, func() error, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, nil, err
+    }
+    // The mapping stays valid after the file is closed
+    defer f.Close()
+
+    info, err := f.Stat()
+    if err != nil {
+        return nil, nil, err
+    }
+    if info.Size() == 0 {
+        return nil, func() error { return nil }, nil
+    }
+
+    data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+    if err != nil {
+        return nil, nil, err
+    }
+    return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,230 @@
+package aomt
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "hash"
+)
+
+/**
+ * A membership (or non-membership) proof for several leaves at once. The paths of the leaves
+ * share their top ancestors, and the sibling of a node on one leaf's path is often on another
+ * leaf's path, so it can be recomputed rather than sent. Thus, a multiproof only has the siblings
+ * that are not on any of the paths, which is a lot fewer than 256 per leaf when leaves share
+ * prefixes (and always at most 256 per leaf).
+ *
+ * Siblings are ordered as they are consumed by the verifier: level by level, from the leaves up to
+ * the root's children, and by LN within a level.
+ */
+type MultiMembershipProof struct {
+    LeafNos    [][32]byte // sorted and distinct
+    LeafHashes [][32]byte // LeafHashes[i] is the hash of LeafNos[i], or the empty hash
+    Openings   []*Opening // Openings[i] is the opening of LeafNos[i], if any (see Tree::InsertCommitted())
+    Siblings   [][32]byte
+}
+
+/**
+ * Returns a single proof for the membership (or non-membership) of all the specified leaves.
+ */
+func (tree *Tree) ProveMembershipBatch(leafNos [][32]byte) *MultiMembershipProof {
+    proof, _ := tree.ProveMembershipBatchContext(context.Background(), leafNos)
+    return proof
+}
+
+/**
+ * Like ProveMembershipBatch(), but stops if the context is canceled, returning a ProgressError
+ * that counts the levels whose siblings were added to the proof.
+ */
+func (tree *Tree) ProveMembershipBatchContext(ctx context.Context, leafNos [][32]byte) (*MultiMembershipProof, error) {
+    const op = "proving membership of batch"
+
+    proof := &MultiMembershipProof{LeafNos: _sortedDistinct(leafNos)}
+    for i, leafNo := range proof.LeafNos {
+        if i%cancelCheckInterval == 0 {
+            if err := _checkCanceled(ctx, op, "levels", 0, tree.numLevels-1); err != nil {
+                return nil, err
+            }
+        }
+        tree._restore(tree.numLevels-1, leafNo)
+        leafHash, _ := tree.Get(leafNo)
+        proof.LeafHashes = append(proof.LeafHashes, leafHash)
+        proof.Openings = append(proof.Openings, tree.openings[leafNo])
+    }
+
+    nodes := proof.LeafNos
+    for level := tree.numLevels - 1; level > 0; level-- {
+        if err := _checkCanceled(ctx, op, "levels", tree.numLevels-1-level, tree.numLevels-1); err != nil {
+            return nil, err
+        }
+        lvl := tree.lvl[level]
+        for i := 0; i < len(nodes); i++ {
+            siblingNo, isLeft := siblingOf(nodes[i])
+            // Since the nodes are sorted, a node's sibling can only be the next node
+            if isLeft && i+1 < len(nodes) && nodes[i+1] == siblingNo {
+                i++
+                continue
+            }
+
+            siblingHash := tree.EmptyHash
+            if sibling := tree.getNode(lvl, siblingNo); sibling != nil {
+                siblingHash = sibling.Hash
+            }
+            proof.Siblings = append(proof.Siblings, siblingHash)
+        }
+        nodes = _distinctParents(nodes)
+    }
+
+    return proof, nil
+}
+
+/**
+ * Returns the # of leaves in the proof.
+ */
+func (proof *MultiMembershipProof) NumLeaves() int {
+    return len(proof.LeafNos)
+}
+
+/**
+ * Returns the size of the proof in bytes, counting 32 bytes per leaf no and per hash, plus the
+ * openings, to compare with the size of separate MembershipProofs (see MembershipProofSize()).
+ */
+func (proof *MultiMembershipProof) SizeBytes() int64 {
+    size := 32 * int64(2*len(proof.LeafNos)+len(proof.Siblings))
+    for _, opening := range proof.Openings {
+        if opening != nil {
+            size += 32 + int64(len(opening.Value))
+        }
+    }
+    return size
+}
+
+/**
+ * Returns the size in bytes of a MembershipProof without an opening, counted like
+ * MultiMembershipProof::SizeBytes().
+ */
+func MembershipProofSize(numLevels int) int64 {
+    return 32 * int64(2+numLevels-1)
+}
+
+/**
+ * Checks a multiproof against the specified root hash of a tree that uses SHA256.
+ */
+func VerifyMembershipBatch(proof *MultiMembershipProof, rootHash [32]byte) bool {
+    return VerifyMembershipBatchWithHash(proof, rootHash, sha256.New)
+}
+
+/**
+ * Checks a multiproof against the specified root hash of a tree that uses the specified hash function.
+ */
+func VerifyMembershipBatchWithHash(proof *MultiMembershipProof, rootHash [32]byte, newHash func() hash.Hash) bool {
+    return VerifyMembershipBatchWithOptions(proof, rootHash, TreeOptions{NewHash: newHash})
+}
+
+/**
+ * Checks a multiproof against the specified root hash of a tree created via NewTreeWithOptions()
+ * with the specified options.
+ */
+func VerifyMembershipBatchWithOptions(proof *MultiMembershipProof, rootHash [32]byte, opts TreeOptions) bool {
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    if newHash().Size() != 32 {
+        return false
+    }
+
+    numLeaves := len(proof.LeafNos)
+    if numLeaves == 0 || len(proof.LeafHashes) != numLeaves || len(proof.Openings) != numLeaves {
+        return false
+    }
+    numLevels, err := opts._numLevels()
+    if err != nil || opts.HashingScheme._check() != nil || opts._checkHMAC() != nil {
+        return false
+    }
+    leafLevel := numLevels - 1
+    for i := range proof.LeafNos {
+        if i > 0 && bytes.Compare(proof.LeafNos[i-1][:], proof.LeafNos[i][:]) >= 0 {
+            return false
+        }
+        if !_fitsInLevel(proof.LeafNos[i], leafLevel) {
+            return false
+        }
+
+        opening := &MembershipProof{LeafHash: proof.LeafHashes[i], Opening: proof.Openings[i]}
+        if !_checkOpening(opening, opts) {
+            return false
+        }
+    }
+
+    var emptyHash [32]byte
+    parentHash := func(level int, nodeNo [32]byte, leftHash [32]byte, rightHash [32]byte) [32]byte {
+        // Nodes with empty subtrees are not stored in the tree, see VerifyMembershipProofWithOptions()
+        if leftHash == emptyHash && rightHash == emptyHash {
+            return emptyHash
+        }
+        return _schemeNodeHash(newHash, opts.RFC6962, opts.HashingScheme, level, nodeNo, leftHash, rightHash)
+    }
+
+    nodes := proof.LeafNos
+    hashes := proof.LeafHashes
+    siblings := proof.Siblings
+    for level := leafLevel; level > 0; level-- {
+        var parentHashes [][32]byte
+        for i := 0; i < len(nodes); i++ {
+            siblingNo, isLeft := siblingOf(nodes[i])
+            parentNo := parentOf(nodes[i])
+            if isLeft && i+1 < len(nodes) && nodes[i+1] == siblingNo {
+                parentHashes = append(parentHashes, parentHash(level-1, parentNo, hashes[i], hashes[i+1]))
+                i++
+                continue
+            }
+
+            if len(siblings) == 0 {
+                return false
+            }
+            if isLeft {
+                parentHashes = append(parentHashes, parentHash(level-1, parentNo, hashes[i], siblings[0]))
+            } else {
+                parentHashes = append(parentHashes, parentHash(level-1, parentNo, siblings[0], hashes[i]))
+            }
+            siblings = siblings[1:]
+        }
+
+        nodes = _distinctParents(nodes)
+        hashes = parentHashes
+    }
+
+    return len(siblings) == 0 && len(hashes) == 1 && hashEqual(hashes[0], rootHash)
+}
+
+/**
+ * Returns the distinct LNs, sorted.
+ */
+func _sortedDistinct(nodeNos [][32]byte) [][32]byte {
+    sorted := append([][32]byte(nil), nodeNos...)
+    _sortHashes(sorted)
+
+    distinct := sorted[:0]
+    for _, nodeNo := range sorted {
+        if len(distinct) == 0 || nodeNo != distinct[len(distinct)-1] {
+            distinct = append(distinct, nodeNo)
+        }
+    }
+    return distinct
+}
+
+/**
+ * Returns the distinct parents of the sorted LNs, which are sorted as well.
+ */
+func _distinctParents(nodeNos [][32]byte) [][32]byte {
+    var parents [][32]byte
+    for _, nodeNo := range nodeNos {
+        parentNo := parentOf(nodeNo)
+        if len(parents) == 0 || parents[len(parents)-1] != parentNo {
+            parents = append(parents, parentNo)
+        }
+    }
+    return parents
+}
+
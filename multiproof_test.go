@@ -0,0 +1,117 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Returns a copy of the multiproof, so that tampering with it leaves the original intact.
+ */
+func _testCopyMultiProof(proof *MultiMembershipProof) *MultiMembershipProof {
+    p := &MultiMembershipProof{
+        LeafNos:    append([][32]byte{}, proof.LeafNos...),
+        LeafHashes: append([][32]byte{}, proof.LeafHashes...),
+        Openings:   append([]*Opening{}, proof.Openings...),
+        Siblings:   append([][32]byte{}, proof.Siblings...),
+    }
+    for i, opening := range p.Openings {
+        if opening != nil {
+            copied := *opening
+            p.Openings[i] = &copied
+        }
+    }
+    return p
+}
+
+/**
+ * Multiproofs of existing and missing leaves must verify for every hashing option, and must not
+ * verify once any of their parts is tampered with.
+ */
+func TestMultiProofRejectsTampering(t *testing.T) {
+    cases := []struct {
+        name      string
+        numLevels int
+        opts      TreeOptions
+    }{
+        {"plain", 257, TreeOptions{}},
+        {"rfc6962", 257, TreeOptions{RFC6962: true}},
+        {"level-ln", 257, TreeOptions{RFC6962: true, HashingScheme: HashingLevelLN}},
+        {"hmac", 257, TreeOptions{HMACKey: []byte("key"), HMACLeafHashes: true}},
+        {"16 levels", 16, TreeOptions{HashingScheme: HashingLevel}},
+    }
+    for _, c := range cases {
+        tree, leafNos := _testMembershipTree(t, c.numLevels, c.opts)
+        opts := c.opts
+        opts.NumLevels = c.numLevels
+        root := tree.GetRootHash()
+
+        // Some of the leaves, a missing one and a missing sibling of an existing one
+        batch := append([][32]byte{}, leafNos[:12]...)
+        missing := _testLeaf(1000).LeafNo
+        for bit := 0; bit < maxNumLevels-c.numLevels; bit++ {
+            missing[bit/8] &^= 0x80 >> uint(bit%8)
+        }
+        sibling, _ := siblingOf(leafNos[0])
+        batch = append(batch, missing, sibling)
+
+        proof := tree.ProveMembershipBatch(batch)
+        if !VerifyMembershipBatchWithOptions(proof, root, opts) {
+            t.Fatalf("%s: multiproof does not verify", c.name)
+        }
+
+        tampered := map[string]*MultiMembershipProof{}
+        p := _testCopyMultiProof(proof)
+        p.LeafNos[0], p.LeafNos[1] = p.LeafNos[1], p.LeafNos[0]
+        tampered["leaves unsorted"] = p
+        p = _testCopyMultiProof(proof)
+        p.LeafNos[1] = p.LeafNos[0]
+        tampered["leaf repeated"] = p
+        p = _testCopyMultiProof(proof)
+        p.LeafHashes = p.LeafHashes[1:]
+        tampered["leaf hash dropped"] = p
+        p = _testCopyMultiProof(proof)
+        p.Openings = p.Openings[1:]
+        tampered["opening dropped"] = p
+        p = _testCopyMultiProof(proof)
+        p.Siblings = p.Siblings[1:]
+        tampered["sibling dropped"] = p
+        p = _testCopyMultiProof(proof)
+        p.Siblings = append(p.Siblings, tree.EmptyHash)
+        tampered["sibling added"] = p
+        tampered["no leaves"] = &MultiMembershipProof{Siblings: proof.Siblings}
+        for i := range proof.LeafNos {
+            p := _testCopyMultiProof(proof)
+            p.LeafHashes[i][0] ^= 1
+            if VerifyMembershipBatchWithOptions(p, root, opts) {
+                t.Errorf("%s: multiproof verifies with the hash of leaf %d flipped", c.name, i)
+            }
+            if proof.Openings[i] != nil {
+                p := _testCopyMultiProof(proof)
+                p.Openings[i].Salt[0] ^= 1
+                if VerifyMembershipBatchWithOptions(p, root, opts) {
+                    t.Errorf("%s: multiproof verifies with the salt of leaf %d flipped", c.name, i)
+                }
+            }
+        }
+        // Most siblings are empty, and flipping any of them fails alike
+        for i := range proof.Siblings {
+            if proof.Siblings[i] == tree.EmptyHash && i%64 != 0 {
+                continue
+            }
+            p := _testCopyMultiProof(proof)
+            p.Siblings[i][0] ^= 1
+            if VerifyMembershipBatchWithOptions(p, root, opts) {
+                t.Errorf("%s: multiproof verifies with sibling %d flipped", c.name, i)
+            }
+        }
+
+        for name, p := range tampered {
+            if VerifyMembershipBatchWithOptions(p, root, opts) {
+                t.Errorf("%s: multiproof verifies with %s", c.name, name)
+            }
+        }
+        if VerifyMembershipBatchWithOptions(proof, tree.EmptyHash, opts) {
+            t.Errorf("%s: multiproof verifies against another root", c.name)
+        }
+    }
+}
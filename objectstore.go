@@ -0,0 +1,364 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/**
+ * A ShardStore that keeps every subtree as an object of an S3-compatible object store (AWS S3,
+ * MinIO, Ceph, GCS's XML API, ...), so a ShardedStorage can hold trees far larger than its local
+ * disk, and verifiers can bootstrap from the published storage rather than from the server (see
+ * the 'load-sharded' command). The store is given by the URL of a bucket and, optionally, of a key
+ * prefix in it, which also names it (see ShardStore::Name()), e.g.:
+ *
+ *   https://s3.us-east-1.amazonaws.com/my-bucket/trees/prod
+ *
+ * and the subtree with prefix 'p' and root hash 'r' is the object '<url>/<p>-<r>.subtree' (as for
+ * DirShardStore), requested path-style via S3's REST API. Requests are signed via AWS Signature
+ * Version 4 if there are credentials (see ObjectStoreOptions), or sent unsigned (e.g., by
+ * verifiers reading a public bucket) otherwise.
+ *
+ * Subtrees are written through a local cache directory, if any, from which they are also read.
+ * Since a subtree version is stored under its root hash and never overwritten by another one,
+ * cached copies cannot go stale. ShardedStorage::Save() writes all of an epoch's changed subtrees
+ * to the store at once via WriteSubtrees() (see BatchShardStore), which uploads them concurrently.
+ *
+ * NOTE: Only S3's REST API is supported: there is no gRPC transport, since this package only
+ * depends on the standard library.
+ */
+type ObjectShardStore struct {
+    url    string // the bucket's (and key prefix's) URL, without a trailing slash
+    opts   ObjectStoreOptions
+    cache  *DirShardStore
+    client *http.Client
+}
+
+type ObjectStoreOptions struct {
+    // The credentials requests are signed with. Requests are not signed if AccessKeyID is empty.
+    AccessKeyID     string
+    SecretAccessKey string
+    SessionToken    string // for temporary credentials, if not empty
+    Region          string // "us-east-1" if empty
+
+    // The local directory subtrees are cached in, or no cache if empty
+    CacheDir string
+
+    // The most subtrees WriteSubtrees() uploads at once, defaultObjectStoreParallelism if 0
+    Parallelism int
+
+    // The client requests are sent with, one with an objectStoreTimeout timeout if nil
+    Client *http.Client
+}
+
+const defaultObjectStoreParallelism = 16
+
+// How long to wait for a request to the object store before giving up on it
+const objectStoreTimeout = 60 * time.Second
+
+/**
+ * A subtree to be written to a shard, see BatchShardStore.
+ */
+type ShardSubtree struct {
+    Prefix int
+    Root   [32]byte
+    Data   []byte
+}
+
+/**
+ * A ShardStore that writes many subtrees faster at once than one by one, e.g., because it is
+ * remote. ShardedStorage::Save() writes all of a shard's changed subtrees via WriteSubtrees() if
+ * the shard implements it.
+ */
+type BatchShardStore interface {
+    ShardStore
+
+    // Stores the encoded subtrees, as WriteSubtree() would. Some may be stored if it fails.
+    WriteSubtrees(subtrees []ShardSubtree) error
+}
+
+/**
+ * Opens the object store at 'rawURL' (see ObjectShardStore) as a shard. Nothing is requested
+ * from the store until subtrees are read or written.
+ */
+func OpenObjectShardStore(rawURL string, opts ObjectStoreOptions) (*ObjectShardStore, error) {
+    u, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+    if err != nil {
+        return nil, err
+    }
+    if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, fmt.Errorf("'%s' is not an http(s) URL", rawURL)
+    }
+    if strings.Trim(u.Path, "/") == "" {
+        return nil, fmt.Errorf("'%s' has no bucket", rawURL)
+    }
+    if u.RawQuery != "" || u.Fragment != "" {
+        return nil, fmt.Errorf("'%s' cannot have a query nor a fragment", rawURL)
+    }
+    if opts.Parallelism < 0 {
+        return nil, fmt.Errorf("cannot upload %d subtrees at once", opts.Parallelism)
+    }
+    if opts.Parallelism == 0 {
+        opts.Parallelism = defaultObjectStoreParallelism
+    }
+    if opts.Region == "" {
+        opts.Region = "us-east-1"
+    }
+
+    store := &ObjectShardStore{
+        url:    u.String(),
+        opts:   opts,
+        client: opts.Client,
+    }
+    if store.client == nil {
+        store.client = &http.Client{Timeout: objectStoreTimeout}
+    }
+    if opts.CacheDir != "" {
+        cache, err := OpenDirShardStore(opts.CacheDir)
+        if err != nil {
+            return nil, fmt.Errorf("opening cache: %v", err)
+        }
+        store.cache = cache.(*DirShardStore)
+    }
+    return store, nil
+}
+
+func (store *ObjectShardStore) Name() string {
+    return store.url
+}
+
+/**
+ * Returns the name of the file or object subtree versions are stored under, see DirShardStore.
+ */
+func _subtreeObjectName(prefix int, root [32]byte) string {
+    return fmt.Sprintf("%08x-%x.subtree", prefix, root)
+}
+
+func (store *ObjectShardStore) ReadSubtree(prefix int, root [32]byte) ([]byte, error) {
+    if store.cache != nil {
+        if data, err := store.cache.ReadSubtree(prefix, root); err == nil {
+            return data, nil
+        }
+    }
+
+    data, err := store.GetObject(_subtreeObjectName(prefix, root))
+    if err != nil {
+        return nil, err
+    }
+    // The cache is only an optimization, so failing to fill it is not an error
+    if store.cache != nil {
+        store.cache.WriteSubtree(prefix, root, data)
+    }
+    return data, nil
+}
+
+/**
+ * Uploads the subtree and then writes it to the cache, so the cache never has a subtree the store
+ * does not.
+ */
+func (store *ObjectShardStore) WriteSubtree(prefix int, root [32]byte, data []byte) error {
+    if err := store.PutObject(_subtreeObjectName(prefix, root), data); err != nil {
+        return err
+    }
+    if store.cache != nil {
+        store.cache.WriteSubtree(prefix, root, data)
+    }
+    return nil
+}
+
+/**
+ * Uploads the subtrees concurrently, at most ObjectStoreOptions::Parallelism at once, and returns
+ * the first error, if any, after all uploads are done.
+ */
+func (store *ObjectShardStore) WriteSubtrees(subtrees []ShardSubtree) error {
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    var firstErr error
+
+    sem := make(chan struct{}, store.opts.Parallelism)
+    for _, subtree := range subtrees {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(subtree ShardSubtree) {
+            defer func() { <-sem; wg.Done() }()
+
+            if err := store.WriteSubtree(subtree.Prefix, subtree.Root, subtree.Data); err != nil {
+                mu.Lock()
+                if firstErr == nil {
+                    firstErr = fmt.Errorf("subtree %d: %v", subtree.Prefix, err)
+                }
+                mu.Unlock()
+            }
+        }(subtree)
+    }
+    wg.Wait()
+    return firstErr
+}
+
+func (store *ObjectShardStore) DeleteSubtree(prefix int, root [32]byte) error {
+    if store.cache != nil {
+        if err := store.cache.DeleteSubtree(prefix, root); err != nil {
+            return err
+        }
+    }
+    return store.DeleteObject(_subtreeObjectName(prefix, root))
+}
+
+/**
+ * Returns the object 'name' under the store's URL, e.g., a ShardedStorage's local file published
+ * next to its subtrees.
+ */
+func (store *ObjectShardStore) GetObject(name string) ([]byte, error) {
+    return store._do(http.MethodGet, name, nil)
+}
+
+/**
+ * Stores 'data' as the object 'name' under the store's URL, replacing it if it exists.
+ */
+func (store *ObjectShardStore) PutObject(name string, data []byte) error {
+    _, err := store._do(http.MethodPut, name, data)
+    return err
+}
+
+/**
+ * Deletes the object 'name' under the store's URL. Deleting an object that does not exist is not
+ * an error.
+ */
+func (store *ObjectShardStore) DeleteObject(name string) error {
+    _, err := store._do(http.MethodDelete, name, nil)
+    return err
+}
+
+/**
+ * Sends a (signed, if there are credentials) request for the object 'name' and returns the
+ * response's body, or an error if the request failed.
+ */
+func (store *ObjectShardStore) _do(method string, name string, body []byte) ([]byte, error) {
+    if name == "" || strings.ContainsAny(name, "/?#%") {
+        return nil, fmt.Errorf("invalid object name '%s'", name)
+    }
+    req, err := http.NewRequest(method, store.url+"/"+name, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.ContentLength = int64(len(body))
+    if store.opts.AccessKeyID != "" {
+        store._sign(req, body, time.Now())
+    }
+
+    resp, err := store.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    data, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    switch {
+    case method == http.MethodDelete && resp.StatusCode == http.StatusNotFound:
+        return nil, nil
+    case resp.StatusCode < 200 || resp.StatusCode > 299:
+        return nil, fmt.Errorf("%s %s: %s: %s", method, name, resp.Status, strings.TrimSpace(string(data)))
+    }
+    return data, nil
+}
+
+/**
+ * Signs the request as of 'now' via AWS Signature Version 4, for the S3 service of the store's
+ * region: every header the request has, and the host, is signed, along with the payload's hash.
+ */
+func (store *ObjectShardStore) _sign(req *http.Request, payload []byte, now time.Time) {
+    now = now.UTC()
+    amzDate := now.Format("20060102T150405Z")
+    day := now.Format("20060102")
+    payloadHash := sha256.Sum256(payload)
+
+    req.Header.Set("X-Amz-Date", amzDate)
+    req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+    if store.opts.SessionToken != "" {
+        req.Header.Set("X-Amz-Security-Token", store.opts.SessionToken)
+    }
+
+    host := req.Host
+    if host == "" {
+        host = req.URL.Host
+    }
+    headers := map[string]string{"host": host}
+    for name, values := range req.Header {
+        headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+    }
+    var names []string
+    for name := range headers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var canonicalHeaders strings.Builder
+    for _, name := range names {
+        canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+    }
+    signedHeaders := strings.Join(names, ";")
+
+    canonicalRequest := strings.Join([]string{
+        req.Method,
+        req.URL.EscapedPath(),
+        req.URL.RawQuery,
+        canonicalHeaders.String(),
+        signedHeaders,
+        hex.EncodeToString(payloadHash[:]),
+    }, "\n")
+    requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+    scope := day + "/" + store.opts.Region + "/s3/aws4_request"
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+    key := _hmacSHA256([]byte("AWS4"+store.opts.SecretAccessKey), day)
+    for _, part := range []string{store.opts.Region, "s3", "aws4_request"} {
+        key = _hmacSHA256(key, part)
+    }
+    signature := hex.EncodeToString(_hmacSHA256(key, stringToSign))
+
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        store.opts.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func _hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+/**
+ * Opens the shard named 'name': an ObjectShardStore with the specified options if it is an
+ * http(s) URL, or a DirShardStore otherwise, whose pages are transformed if the name starts with
+ * page transforms (see TransformedShardStore).
+ */
+func OpenShardStore(name string, opts ObjectStoreOptions) (ShardStore, error) {
+    if transforms, inner, ok := _splitTransformedName(name); ok {
+        store, err := OpenShardStore(inner, opts)
+        if err != nil {
+            return nil, err
+        }
+        return NewTransformedShardStore(store, transforms...), nil
+    }
+    if !strings.HasPrefix(name, "http://") && !strings.HasPrefix(name, "https://") {
+        return OpenDirShardStore(name)
+    }
+    store, err := OpenObjectShardStore(name, opts)
+    if err != nil {
+        return nil, err
+    }
+    return store, nil
+}
@@ -0,0 +1,491 @@
+package aomt
+
+import (
+    "bytes"
+    "compress/flate"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "strings"
+    "time"
+)
+
+/**
+ * The subtrees a ShardedStorage writes to its shards are pages of nodes: every level's records
+ * (i.e., LN, flags and hash), sorted by LN. The hashes are SHA256 outputs, so they do not
+ * compress, but the LNs (i.e., the coordinates) of a level are sorted, so the difference between
+ * consecutive ones is much smaller than the LNs themselves. A NodeStore can thus be wrapped into
+ * a TransformedShardStore, which transforms the pages before they are written and undoes the
+ * transforms when they are read back, transparently to ShardedStorage:
+ *
+ *   "delta"    delta-encodes the LNs of every level, each as a length byte followed by its
+ *              difference to the previous LN, without leading zero bytes
+ *   "deflate"  compresses the page with DEFLATE (i.e., LZ77 dictionary compression and Huffman
+ *              coding), which mostly pays off on the delta-encoded LNs and the flags
+ *
+ * A shard named "<transform>+<transform>+...:<name>" (e.g., "delta+deflate:/mnt/disk1") is the
+ * shard named <name> with these transforms, applied in order (see OpenShardStore()). Since the
+ * shard's name is kept in the storage's local file, the storage is reopened with the same
+ * transforms. The bytes per leaf they save are reported by the 'pages' benchmark (see
+ * hashpages()).
+ */
+
+/**
+ * A store of pages of nodes, each holding the nodes of a subtree (see ShardedStorage), e.g., a
+ * directory or an object store.
+ */
+type NodeStore interface {
+    // Returns the encoded subtree, or an error if it was not written
+    ReadSubtree(prefix int, root [32]byte) ([]byte, error)
+
+    // Stores the encoded subtree, replacing any data stored under the same prefix and root
+    WriteSubtree(prefix int, root [32]byte, data []byte) error
+
+    // Deletes the subtree. Deleting a subtree that is not stored is not an error.
+    DeleteSubtree(prefix int, root [32]byte) error
+}
+
+/**
+ * A reversible transform of the pages written to a NodeStore.
+ */
+type PageTransform interface {
+    // Identifies the transform in shard names, see above
+    Name() string
+
+    Encode(page []byte) ([]byte, error)
+
+    // Undoes Encode(), or returns an error if 'data' was not encoded by it
+    Decode(data []byte) ([]byte, error)
+}
+
+var pageTransforms = map[string]PageTransform{
+    "delta":   DeltaLNTransform{},
+    "deflate": DeflateTransform{},
+}
+
+/**
+ * Returns the transforms in 'names' (e.g., "delta+deflate"), or an error if one is unknown.
+ */
+func ParsePageTransforms(names string) ([]PageTransform, error) {
+    var transforms []PageTransform
+    for _, name := range strings.Split(names, "+") {
+        transform, ok := pageTransforms[name]
+        if !ok {
+            return nil, fmt.Errorf("unknown page transform '%s'", name)
+        }
+        transforms = append(transforms, transform)
+    }
+    return transforms, nil
+}
+
+/**
+ * Splits a shard name into its transforms and the name of the underlying shard, or returns false
+ * if the name has no transforms.
+ */
+func _splitTransformedName(name string) ([]PageTransform, string, bool) {
+    i := strings.Index(name, ":")
+    if i <= 0 {
+        return nil, name, false
+    }
+    transforms, err := ParsePageTransforms(name[:i])
+    if err != nil {
+        // E.g., the scheme of an http(s) URL
+        return nil, name, false
+    }
+    return transforms, name[i+1:], true
+}
+
+/**
+ * A ShardStore whose pages are transformed before they are written to another ShardStore.
+ */
+type TransformedShardStore struct {
+    store      ShardStore
+    transforms []PageTransform
+}
+
+func NewTransformedShardStore(store ShardStore, transforms ...PageTransform) *TransformedShardStore {
+    return &TransformedShardStore{store: store, transforms: transforms}
+}
+
+func (store *TransformedShardStore) Name() string {
+    return _transformNames(store.transforms) + ":" + store.store.Name()
+}
+
+func _transformNames(transforms []PageTransform) string {
+    names := make([]string, len(transforms))
+    for i, transform := range transforms {
+        names[i] = transform.Name()
+    }
+    return strings.Join(names, "+")
+}
+
+func (store *TransformedShardStore) _encode(data []byte) ([]byte, error) {
+    var err error
+    for _, transform := range store.transforms {
+        if data, err = transform.Encode(data); err != nil {
+            return nil, fmt.Errorf("%s: %v", transform.Name(), err)
+        }
+    }
+    return data, nil
+}
+
+func (store *TransformedShardStore) ReadSubtree(prefix int, root [32]byte) ([]byte, error) {
+    data, err := store.store.ReadSubtree(prefix, root)
+    if err != nil {
+        return nil, err
+    }
+    for i := len(store.transforms) - 1; i >= 0; i-- {
+        if data, err = store.transforms[i].Decode(data); err != nil {
+            return nil, fmt.Errorf("%s: %v", store.transforms[i].Name(), err)
+        }
+    }
+    return data, nil
+}
+
+func (store *TransformedShardStore) WriteSubtree(prefix int, root [32]byte, data []byte) error {
+    data, err := store._encode(data)
+    if err != nil {
+        return err
+    }
+    return store.store.WriteSubtree(prefix, root, data)
+}
+
+func (store *TransformedShardStore) DeleteSubtree(prefix int, root [32]byte) error {
+    return store.store.DeleteSubtree(prefix, root)
+}
+
+/**
+ * Writes the subtrees all at once if the underlying store can (see BatchShardStore), or one by
+ * one otherwise.
+ */
+func (store *TransformedShardStore) WriteSubtrees(subtrees []ShardSubtree) error {
+    encoded := make([]ShardSubtree, len(subtrees))
+    for i, subtree := range subtrees {
+        data, err := store._encode(subtree.Data)
+        if err != nil {
+            return fmt.Errorf("subtree %d: %v", subtree.Prefix, err)
+        }
+        encoded[i] = ShardSubtree{subtree.Prefix, subtree.Root, data}
+    }
+
+    if batchStore, ok := store.store.(BatchShardStore); ok {
+        return batchStore.WriteSubtrees(encoded)
+    }
+    for _, subtree := range encoded {
+        if err := store.store.WriteSubtree(subtree.Prefix, subtree.Root, subtree.Data); err != nil {
+            return fmt.Errorf("subtree %d: %v", subtree.Prefix, err)
+        }
+    }
+    return nil
+}
+
+/**
+ * Delta-encodes the LNs of a subtree page (see above). The page's header is kept, except for its
+ * magic.
+ */
+type DeltaLNTransform struct{}
+
+const deltaPageMagic = "AOMTDLTA"
+
+// The size of a subtree page's header: magic, version, numLevels, prefixBits and prefix
+const subtreeHeaderSize = 8 + 1 + 2 + 1 + 4
+
+func (DeltaLNTransform) Name() string {
+    return "delta"
+}
+
+func (DeltaLNTransform) Encode(page []byte) ([]byte, error) {
+    if len(page) < subtreeHeaderSize || string(page[:len(subtreeMagic)]) != subtreeMagic {
+        return nil, errors.New("not a subtree")
+    }
+    numLevels, prefixBits := _subtreeHeaderLevels(page)
+
+    out := bytes.NewBuffer(make([]byte, 0, len(page)))
+    out.WriteString(deltaPageMagic)
+    out.Write(page[len(subtreeMagic):subtreeHeaderSize])
+    page = page[subtreeHeaderSize:]
+
+    var varint [binary.MaxVarintLen64]byte
+    for level := prefixBits; level < numLevels; level++ {
+        if len(page) < 8 {
+            return nil, fmt.Errorf("level %d is truncated", level)
+        }
+        numNodes := binary.BigEndian.Uint64(page)
+        page = page[8:]
+        lnBytes := _lnNumBytes(level)
+        if numNodes > uint64(len(page)/(lnBytes+1+32)) {
+            return nil, fmt.Errorf("level %d claims %d nodes but only %d bytes are left", level, numNodes, len(page))
+        }
+        out.Write(varint[:binary.PutUvarint(varint[:], numNodes)])
+
+        var prevNo [32]byte
+        for i := uint64(0); i < numNodes; i++ {
+            var nodeNo [32]byte
+            copy(nodeNo[32-lnBytes:], page[:lnBytes])
+            if i > 0 && bytes.Compare(prevNo[:], nodeNo[:]) >= 0 {
+                return nil, fmt.Errorf("nodes on level %d are not sorted", level)
+            }
+            delta := _subBytes(nodeNo, prevNo)
+            numZeros := 0
+            for numZeros < 32 && delta[numZeros] == 0 {
+                numZeros++
+            }
+            out.WriteByte(byte(32 - numZeros))
+            out.Write(delta[numZeros:])
+            // The flags and the hash
+            out.Write(page[lnBytes : lnBytes+1+32])
+
+            page = page[lnBytes+1+32:]
+            prevNo = nodeNo
+        }
+    }
+    if len(page) != 0 {
+        return nil, fmt.Errorf("%d trailing bytes after subtree", len(page))
+    }
+    return out.Bytes(), nil
+}
+
+func (DeltaLNTransform) Decode(data []byte) ([]byte, error) {
+    if len(data) < subtreeHeaderSize || string(data[:len(deltaPageMagic)]) != deltaPageMagic {
+        return nil, errors.New("not a delta-encoded subtree")
+    }
+    numLevels, prefixBits := _subtreeHeaderLevels(data)
+
+    out := bytes.NewBuffer(make([]byte, 0, 2*len(data)))
+    out.WriteString(subtreeMagic)
+    out.Write(data[len(deltaPageMagic):subtreeHeaderSize])
+    r := bytes.NewReader(data[subtreeHeaderSize:])
+
+    var numNodesBuf [8]byte
+    for level := prefixBits; level < numLevels; level++ {
+        numNodes, err := binary.ReadUvarint(r)
+        if err != nil {
+            return nil, fmt.Errorf("reading # of nodes on level %d: %v", level, err)
+        }
+        // Every record takes at least a length byte, the flags and the hash
+        if numNodes > uint64(r.Len()/(1+1+32)) {
+            return nil, fmt.Errorf("level %d claims %d nodes but only %d bytes are left", level, numNodes, r.Len())
+        }
+        binary.BigEndian.PutUint64(numNodesBuf[:], numNodes)
+        out.Write(numNodesBuf[:])
+
+        lnBytes := _lnNumBytes(level)
+        var nodeNo [32]byte
+        for i := uint64(0); i < numNodes; i++ {
+            deltaLen, _ := r.ReadByte()
+            var delta [32]byte
+            if int(deltaLen) > lnBytes || int(deltaLen) > r.Len() {
+                return nil, fmt.Errorf("node %d on level %d has a %d-byte delta", i, level, deltaLen)
+            }
+            r.Read(delta[32-int(deltaLen):])
+            var carry bool
+            if nodeNo, carry = _addBytes(nodeNo, delta); carry || !_fitsInLevel(nodeNo, level) {
+                return nil, fmt.Errorf("node %d on level %d is out of range", i, level)
+            }
+            out.Write(nodeNo[32-lnBytes:])
+
+            var record [1 + 32]byte
+            if n, _ := r.Read(record[:]); n != len(record) {
+                return nil, fmt.Errorf("node %d on level %d is truncated", i, level)
+            }
+            out.Write(record[:])
+        }
+    }
+    if r.Len() != 0 {
+        return nil, fmt.Errorf("%d trailing bytes after subtree", r.Len())
+    }
+    return out.Bytes(), nil
+}
+
+/**
+ * Returns the # of levels of the tree and the level of the subtree's root in a page's header.
+ */
+func _subtreeHeaderLevels(page []byte) (int, int) {
+    return int(binary.BigEndian.Uint16(page[9:])), int(page[11])
+}
+
+/**
+ * Returns a - b, as 256-bit big-endian integers, for a >= b.
+ */
+func _subBytes(a [32]byte, b [32]byte) [32]byte {
+    var diff [32]byte
+    borrow := 0
+    for i := 31; i >= 0; i-- {
+        d := int(a[i]) - int(b[i]) - borrow
+        borrow = 0
+        if d < 0 {
+            d += 256
+            borrow = 1
+        }
+        diff[i] = byte(d)
+    }
+    return diff
+}
+
+/**
+ * Returns a + b and whether it overflowed, as 256-bit big-endian integers.
+ */
+func _addBytes(a [32]byte, b [32]byte) ([32]byte, bool) {
+    var sum [32]byte
+    carry := 0
+    for i := 31; i >= 0; i-- {
+        s := int(a[i]) + int(b[i]) + carry
+        sum[i] = byte(s)
+        carry = s >> 8
+    }
+    return sum, carry != 0
+}
+
+/**
+ * Compresses pages with DEFLATE, see above.
+ */
+type DeflateTransform struct{}
+
+func (DeflateTransform) Name() string {
+    return "deflate"
+}
+
+func (DeflateTransform) Encode(page []byte) ([]byte, error) {
+    var out bytes.Buffer
+    w, _ := flate.NewWriter(&out, flate.BestCompression)
+    if _, err := w.Write(page); err != nil {
+        return nil, err
+    }
+    if err := w.Close(); err != nil {
+        return nil, err
+    }
+    return out.Bytes(), nil
+}
+
+func (DeflateTransform) Decode(data []byte) ([]byte, error) {
+    r := flate.NewReader(bytes.NewReader(data))
+    defer r.Close()
+    return ioutil.ReadAll(r)
+}
+
+/**
+ * Encodes every subtree rooted at level 'prefixBits' as a page, as ShardedStorage::Save() does.
+ */
+func (tree *Tree) _encodeSubtrees(prefixBits int) [][]byte {
+    levels := make(map[int][][][32]byte)
+    for level := prefixBits; level < tree.numLevels; level++ {
+        for nodeNo := range tree.lvl[level].node {
+            prefix := _subtreePrefix(nodeNo, level, prefixBits)
+            if _, ok := levels[prefix]; !ok {
+                levels[prefix] = make([][][32]byte, tree.numLevels-prefixBits)
+            }
+            levels[prefix][level-prefixBits] = append(levels[prefix][level-prefixBits], nodeNo)
+        }
+    }
+
+    pages := make([][]byte, 0, len(levels))
+    for prefix, subtreeLevels := range levels {
+        pages = append(pages, tree._encodeSubtree(prefixBits, prefix, subtreeLevels))
+    }
+    return pages
+}
+
+// The level the subtrees of the 'pages' benchmark are rooted at, as for a ShardedStorage with
+// 2^8 subtrees
+const pagesBenchPrefixBits = 8
+
+/**
+ * Measures the bytes per leaf of the tree's subtree pages (see ShardedStorage) as the batches are
+ * inserted, without transforms and with every combination of the transforms above, and how long
+ * encoding and decoding the pages takes.
+ */
+func hashpages(sizes []int, seed int64, csvFile string, opts BenchOptions) {
+    memGc()
+
+    // Same leaves as in hashsparse(), so the CSVs can be compared
+    gen := opts._newLeafSource(seed)
+    tree := NewTree(257)
+    tree.Insert(tree.RootNo, sha256.Sum256([]byte("Dummy leaf")), nil)
+    tree.RecordEpoch()
+
+    combos := [][]PageTransform{
+        {DeltaLNTransform{}},
+        {DeflateTransform{}},
+        {DeltaLNTransform{}, DeflateTransform{}},
+    }
+    header := []string{"dictSize", "rawBytesPerLeaf"}
+    for _, combo := range combos {
+        name := strings.Replace(_transformNames(combo), "+", "-", -1)
+        header = append(header, name+"BytesPerLeaf", name+"EncodeUsec", name+"DecodeUsec")
+    }
+
+    prevSize := 1
+    numCompleted := opts._numCompletedSizes(csvFile, sizes)
+    for i := 0; i < numCompleted; i++ {
+        fmt.Printf("Rebuilding completed batch of size %v ...\n", sizes[i]-prevSize)
+        for j := 0; j < sizes[i]-prevSize; j++ {
+            leafNo, dataHash := gen.next()
+            tree.Insert(leafNo, dataHash, nil)
+        }
+        tree.RecordEpoch()
+        prevSize = sizes[i]
+    }
+
+    csv := opts._openCsv(csvFile, header)
+
+    for i := numCompleted; i < len(sizes); i++ {
+        newSize := sizes[i]
+        fmt.Printf("\nAppending new batch of size %v ...\n", newSize-prevSize)
+        for j := 0; j < newSize-prevSize; j++ {
+            leafNo, dataHash := gen.next()
+            tree.Insert(leafNo, dataHash, nil)
+        }
+        tree.RecordEpoch()
+
+        pages := tree._encodeSubtrees(pagesBenchPrefixBits)
+        rawBytes := 0
+        for _, page := range pages {
+            rawBytes += len(page)
+        }
+        numLeaves := float64(tree.NumLeaves())
+        row := []interface{}{newSize, float64(rawBytes) / numLeaves}
+        fmt.Printf("# kv's: %v, raw: %.1f bytes/leaf", newSize, float64(rawBytes)/numLeaves)
+
+        for _, combo := range combos {
+            store := NewTransformedShardStore(nil, combo...)
+            numBytes := 0
+            var encodeTime, decodeTime time.Duration
+            for _, page := range pages {
+                startTime := time.Now()
+                data, err := store._encode(page)
+                encodeTime += time.Since(startTime)
+                if err != nil {
+                    panic(err.Error())
+                }
+                numBytes += len(data)
+
+                startTime = time.Now()
+                for t := len(combo) - 1; t >= 0; t-- {
+                    if data, err = combo[t].Decode(data); err != nil {
+                        panic(err.Error())
+                    }
+                }
+                decodeTime += time.Since(startTime)
+                if !bytes.Equal(data, page) {
+                    panic("Something's off: a decoded page differs from the encoded one")
+                }
+            }
+            fmt.Printf(", %s: %.1f bytes/leaf", _transformNames(combo), float64(numBytes)/numLeaves)
+            row = append(row, float64(numBytes)/numLeaves,
+                int64(encodeTime/time.Microsecond), int64(decodeTime/time.Microsecond))
+        }
+        fmt.Printf("\n")
+
+        csv.Write(row...)
+        prevSize = newSize
+    }
+
+    if err := csv.Close(); err != nil {
+        panic("Error writing CSV file: " + err.Error())
+    }
+
+    fmt.Printf("\n")
+    memGc()
+}
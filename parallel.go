@@ -0,0 +1,260 @@
+package aomt
+
+import (
+    "fmt"
+    "sync"
+    "time"
+)
+
+/**
+ * A leaf to insert or update as part of a batch.
+ */
+type LeafData struct {
+    LeafNo   [32]byte
+    DataHash [32]byte
+}
+
+/**
+ * Inserts a batch of leaves using 'workers' goroutines and returns the new root hash.
+ *
+ * The batch is partitioned by the top k bits of the leaf numbers, so each partition falls in a
+ * distinct subtree rooted at level k. The 2^k subtrees are disjoint, so they can be built in
+ * parallel: each worker writes the nodes it creates in its own per-level maps, since Go maps are
+ * not safe for concurrent writes. Then, we merge these maps into the tree and compute the top k
+ * levels sequentially.
+ *
+ * If 'proofTree' is not nil, the append-only proof is computed after all leaves are inserted.
+ */
+func (tree *Tree) InsertBatchParallel(leaves []LeafData, workers int, proofTree *Tree) [32]byte {
+    if tree.leafExtensions {
+        panic("Cannot insert leaves without extensions in a tree created with TreeOptions::LeafExtensions")
+    }
+    markNew := proofTree != nil
+
+    // Use a few more partitions than workers, so that workers are less likely to sit idle
+    topLevel := 0
+    for (1<<uint(topLevel)) < 4*workers && topLevel < 16 {
+        topLevel++
+    }
+
+    if workers <= 1 || topLevel >= tree.numLevels-1 {
+        for _, leaf := range leaves {
+            tree.Insert(leaf.LeafNo, leaf.DataHash, proofTree)
+        }
+        return tree.GetRootHash()
+    }
+
+    // Partition the leaves by their ancestor on level 'topLevel'
+    partitions := make(map[[32]byte][]LeafData)
+    for _, leaf := range leaves {
+        // The workers cannot rebuild pruned subtrees concurrently
+        tree._restore(tree.numLevels-1, leaf.LeafNo)
+
+        idx := rshBytes(leaf.LeafNo, uint(tree.numLevels-1-topLevel))
+        partitions[idx] = append(partitions[idx], leaf)
+    }
+
+    // Build the subtrees in parallel
+    work := make(chan [32]byte, len(partitions))
+    for idx, _ := range partitions {
+        work <- idx
+    }
+    close(work)
+
+    overlays := make(chan []map[[32]byte]*Node, len(partitions))
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for idx := range work {
+                overlays <- tree._insertSubtree(partitions[idx], topLevel)
+            }
+        }()
+    }
+    wg.Wait()
+    close(overlays)
+
+    // Merge the subtrees' new nodes into the tree, marking the ones the tree did not have as 'new'
+    for overlay := range overlays {
+        for level := topLevel; level < tree.numLevels; level++ {
+            lvl := tree.lvl[level]
+            for idx, node := range overlay[level] {
+                if _, ok := lvl.node[idx]; !ok && markNew {
+                    lvl.markNew(idx)
+                }
+                tree._putNode(lvl, idx, node)
+                tree._recordVersion(level, idx, node.Hash)
+            }
+        }
+    }
+
+    // Compute the top levels sequentially, starting from each subtree's root
+    for idx, _ := range partitions {
+        subtreeRoot := tree.lvl[topLevel].node[idx]
+        tree._setNodeHash(idx, topLevel, subtreeRoot.Hash, markNew, nil)
+    }
+
+    for _, leaf := range leaves {
+        tree.pending = append(tree.pending, epochLeaf{leafNo: leaf.LeafNo, dataHash: leaf.DataHash})
+        tree._notifyInsert(leaf.LeafNo, leaf.DataHash)
+    }
+
+    // All hashes are final now, so we can build the proof in one go
+    if proofTree != nil {
+        for _, leaf := range leaves {
+            tree._proofAddInserted(leaf.LeafNo, proofTree)
+        }
+    }
+
+    return tree.GetRootHash()
+}
+
+/**
+ * Inserts the specified leaves, which must all be in the same subtree rooted at level 'topLevel',
+ * and computes the hashes from the leaves up to and including the subtree's root.
+ * Existing nodes are updated in place, but new nodes are only stored in the returned per-level
+ * maps, so that this can be called concurrently for disjoint subtrees. The returned maps contain
+ * all the nodes whose hashes changed, including existing ones, so they can be versioned on merge
+ * (and the new ones marked as 'new', since the marks are not safe for concurrent writes either).
+ */
+func (tree *Tree) _insertSubtree(leaves []LeafData, topLevel int) []map[[32]byte]*Node {
+    overlay := make([]map[[32]byte]*Node, tree.numLevels)
+    for level := topLevel; level < tree.numLevels; level++ {
+        overlay[level] = make(map[[32]byte]*Node)
+    }
+
+    getNode := func(level int, idx [32]byte) *Node {
+        if node, ok := overlay[level][idx]; ok {
+            return node
+        }
+        return tree.lvl[level].node[idx]
+    }
+
+    lastLevel := tree.numLevels - 1
+    for _, leaf := range leaves {
+        if getNode(lastLevel, leaf.LeafNo) != nil {
+            panic(fmt.Sprintf("Already set leaf '%s' at last level", hashStr(leaf.LeafNo)))
+        }
+
+        var prevSibling *Node = nil
+        var prevHash [32]byte
+        var prevDir bool
+
+        tree._visitPath(
+            leaf.LeafNo,
+            lastLevel,
+            func(lvl *TreeLevel, ancestorNo [32]byte, siblingNo [32]byte, dir bool) {
+                // The levels above the subtree's root are computed after merging
+                if lvl.num < topLevel {
+                    return
+                }
+
+                node := getNode(lvl.num, ancestorNo)
+                if node == nil {
+                    node = &Node{}
+                }
+                overlay[lvl.num][ancestorNo] = node
+
+                if lvl.num == lastLevel {
+                    node.Hash = tree._leafHash(leaf.DataHash)
+                } else {
+                    node.Hash = tree._computeHash(lvl.num, ancestorNo, prevHash, prevSibling, prevDir)
+                }
+
+                // NOTE: The sibling of the subtree's root is in another subtree, so we must not read it
+                if lvl.num > topLevel {
+                    prevHash = node.Hash
+                    prevSibling = getNode(lvl.num, siblingNo)
+                    prevDir = dir
+                }
+            },
+            nil)
+    }
+
+    return overlay
+}
+
+/**
+ * Returns the membership proofs of 'leafNos', in the same order, computed by 'parallelism'
+ * goroutines. Proofs only read the tree, so the goroutines share it without locking, but they do
+ * not fill the caches of the cached levels (see TreeLevel::peek()). Pruned subtrees on the leaves'
+ * paths are rebuilt first, since that changes the tree.
+ */
+func (tree *Tree) ProveMembershipMany(leafNos [][32]byte, parallelism int) []*MembershipProof {
+    for _, leafNo := range leafNos {
+        tree._restore(tree.numLevels-1, leafNo)
+    }
+
+    proofs := make([]*MembershipProof, len(leafNos))
+    peek := func(lvl *TreeLevel, nodeNo [32]byte) *Node {
+        return lvl.peek(nodeNo)
+    }
+    if parallelism <= 1 || len(leafNos) <= 1 {
+        for i, leafNo := range leafNos {
+            proofs[i] = tree._proveMembership(leafNo, peek)
+        }
+        return proofs
+    }
+
+    // Each worker proves a contiguous range of leaves, so the workers never write to the same
+    // cache lines of 'proofs'
+    var wg sync.WaitGroup
+    chunkSize := (len(leafNos) + parallelism - 1) / parallelism
+    for start := 0; start < len(leafNos); start += chunkSize {
+        end := minInt(start+chunkSize, len(leafNos))
+        wg.Add(1)
+        go func(start int, end int) {
+            defer wg.Done()
+            for i := start; i < end; i++ {
+                proofs[i] = tree._proveMembership(leafNos[i], peek)
+            }
+        }(start, end)
+    }
+    wg.Wait()
+    return proofs
+}
+
+/**
+ * The result of one of the prove-bench command's measurements.
+ */
+type proveBenchResult struct {
+    Parallelism  int
+    NumProofs    int
+    ProofsPerSec float64
+}
+
+/**
+ * Builds a tree with 'numLeaves' random leaves and measures proving the membership of 'numProofs'
+ * of them via ProveMembershipMany(), with a parallelism of 1, 2, 4, ... up to 'maxParallelism'.
+ */
+func RunProveBench(numLeaves int, numProofs int, maxParallelism int, seed int64) []proveBenchResult {
+    gen := newLeafGenerator(seed)
+    tree := NewTree(257)
+    leafNos := make([][32]byte, 0, numProofs)
+    for i := 0; i < numLeaves; i++ {
+        leafNo, dataHash := gen.next()
+        tree.Insert(leafNo, dataHash, nil)
+        if len(leafNos) < numProofs {
+            leafNos = append(leafNos, leafNo)
+        }
+    }
+    tree.RecordEpoch()
+
+    var results []proveBenchResult
+    for parallelism := 1; ; parallelism *= 2 {
+        parallelism = minInt(parallelism, maxParallelism)
+        startTime := time.Now()
+        tree.ProveMembershipMany(leafNos, parallelism)
+        elapsed := time.Since(startTime)
+
+        results = append(results, proveBenchResult{
+            Parallelism:  parallelism,
+            NumProofs:    len(leafNos),
+            ProofsPerSec: float64(len(leafNos)) / elapsed.Seconds(),
+        })
+        if parallelism == maxParallelism {
+            return results
+        }
+    }
+}
@@ -0,0 +1,58 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Batches inserted in parallel must give the same roots, proofs and epochs as batches inserted one
+ * leaf at a time, for any # of workers.
+ */
+func TestInsertBatchParallelMatchesInsert(t *testing.T) {
+    for _, workers := range []int{1, 2, 8} {
+        tree := NewTree(257)
+        reference := NewTree(257)
+        next := 0
+        for batch := 0; batch < 4; batch++ {
+            var leaves []LeafData
+            for i := 0; i < 50*(batch+1); i++ {
+                leaves = append(leaves, _testLeaf(next))
+                next++
+            }
+            for _, leaf := range leaves {
+                reference.Insert(leaf.LeafNo, leaf.DataHash, nil)
+            }
+            reference.RecordEpoch()
+
+            // The first batch has no old root to prove against
+            var oldRoot [32]byte
+            var proofTree *Tree
+            if batch > 0 {
+                oldRoot = tree.GetRootHash()
+                proofTree = NewTree(257)
+            }
+            root := tree.InsertBatchParallel(leaves, workers, proofTree)
+            tree.RecordEpoch()
+            if root != reference.GetRootHash() || root != tree.GetRootHash() {
+                t.Fatalf("%d workers: root after batch %d is %s, expected %s", workers, batch, hashStr(root), hashStr(reference.GetRootHash()))
+            }
+            if proofTree != nil {
+                proofTree._compressProofTree()
+                tree._clearNewNodes()
+                if !VerifyAppendOnlyProof(proofTree, oldRoot, root) {
+                    t.Errorf("%d workers: proof of batch %d does not verify", workers, batch)
+                }
+            }
+        }
+
+        // The epochs record the parallel batches' leaves, so they can be proven later on
+        proof := tree.ProveAppendOnly(0, tree.NumEpochs()-1)
+        if !VerifyAppendOnlyProof(proof, tree.GetEpochRoot(0), tree.GetRootHash()) {
+            t.Errorf("%d workers: proof from the first epoch to the last does not verify", workers)
+        }
+        leaf := _testLeaf(next - 1)
+        if !VerifyMembershipProof(tree.ProveMembership(leaf.LeafNo), tree.GetRootHash()) {
+            t.Errorf("%d workers: membership proof of the last leaf does not verify", workers)
+        }
+    }
+}
@@ -0,0 +1,100 @@
+package aomt
+
+import (
+    "sync"
+)
+
+/**
+ * A membership proof reads one sibling per level and, when the nodes are in a disk-backed store
+ * rather than in memory, every read of a cold node blocks on the disk. Since the LNs of all the
+ * siblings are known upfront from the leaf no, the store can look them up from several goroutines
+ * instead (see PrefetchSiblings()), so that the reads of different levels overlap rather than being
+ * served one after the other.
+ *
+ * When proving many leaves, ProveMembershipsWith() also looks up the siblings of the next few
+ * leaves while the current proof is assembled, so a cold proof mostly costs the slowest of its
+ * reads, rather than the sum of all of them.
+ */
+
+/**
+ * Returns the hash of the node with LN 'nodeNo' on level 'level' and true, or false if there is
+ * no such node (i.e., its subtree is empty). Must be safe to call from several goroutines.
+ */
+type NodeLookup func(level int, nodeNo [32]byte) ([32]byte, bool)
+
+/**
+ * Returns the hashes of the siblings of 'leafNo' in a tree with 'numLevels' levels, from the
+ * leaf's sibling to the root's child, looking them up via 'workers' goroutines, or one level at a
+ * time if 'workers' is at most 1.
+ */
+func PrefetchSiblings(lookup NodeLookup, numLevels int, leafNo [32]byte, workers int) [][32]byte {
+    siblings := make([][32]byte, numLevels-1)
+    siblingNos := make([][32]byte, numLevels)
+    nodeNo := leafNo
+    for level := numLevels - 1; level > 0; level-- {
+        siblingNos[level], _ = siblingOf(nodeNo)
+        nodeNo = parentOf(nodeNo)
+    }
+
+    fetch := func(level int) {
+        if hash, ok := lookup(level, siblingNos[level]); ok {
+            siblings[numLevels-1-level] = hash
+        }
+    }
+
+    if workers <= 1 {
+        for level := numLevels - 1; level > 0; level-- {
+            fetch(level)
+        }
+        return siblings
+    }
+
+    // Worker 'w' looks up levels w + 1, w + 1 + workers, ..., so every worker gets some of the
+    // bottom levels, whose nodes are the least likely to be cached
+    var wg sync.WaitGroup
+    for w := 0; w < workers && w < numLevels-1; w++ {
+        wg.Add(1)
+        go func(first int) {
+            defer wg.Done()
+            for level := first; level < numLevels; level += workers {
+                fetch(level)
+            }
+        }(w + 1)
+    }
+    wg.Wait()
+    return siblings
+}
+
+/**
+ * Returns the membership proofs of 'leafNos' in a tree with 'numLevels' levels, in the same order,
+ * looking up each proof's siblings via PrefetchSiblings() with 'workers' goroutines. The siblings
+ * of up to 'lookahead' upcoming leaves are looked up in the background while the current proof is
+ * assembled.
+ */
+func ProveMembershipsWith(lookup NodeLookup, numLevels int, leafNos [][32]byte, lookahead int, workers int) []*MembershipProof {
+    if lookahead < 0 {
+        lookahead = 0
+    }
+
+    // Sibling lookups are started in order, and each one's result is sent on its own channel
+    pending := make(chan chan [][32]byte, lookahead)
+    go func() {
+        for _, leafNo := range leafNos {
+            result := make(chan [][32]byte, 1)
+            pending <- result
+            go func(leafNo [32]byte) {
+                result <- PrefetchSiblings(lookup, numLevels, leafNo, workers)
+            }(leafNo)
+        }
+        close(pending)
+    }()
+
+    proofs := make([]*MembershipProof, 0, len(leafNos))
+    for result := range pending {
+        leafNo := leafNos[len(proofs)]
+        proof := &MembershipProof{LeafNo: leafNo, Siblings: <-result}
+        proof.LeafHash, _ = lookup(numLevels-1, leafNo)
+        proofs = append(proofs, proof)
+    }
+    return proofs
+}
@@ -0,0 +1,647 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "fmt"
+    "hash"
+    "io"
+    "sort"
+    "sync/atomic"
+)
+
+/**
+ * An append-only proof, as a flat list of the nodes in a (compressed) proof tree.
+ * This is what gets serialized and sent to auditors, who can check it directly via Verify(),
+ * without building a Tree, or turn it back into a proof tree via ToTree().
+ *
+ * The binary encoding is:
+ *
+ *   numNodes (4 bytes), followed by numNodes nodes, each encoded as:
+ *     level    (2 bytes)
+ *     LN       (ceil(level / 8) bytes, since the LN of a level-L node has L bits)
+ *     flags    (1 byte: bit 0 is IsNew, bit 1 is set if there are appended hashes)
+ *     hash     (32 bytes)
+ *     [numAppended (4 bytes), followed by numAppended 32-byte hashes, only if bit 1 is set]
+ *
+ * All integers are big-endian. Nodes are sorted by level and then by LN.
+ */
+type AppendOnlyProof struct {
+    Nodes []ProofNode
+
+    // The encoding that MarshalAdaptive() uses, chosen by Epoch::Commit() (see ProofForm), or 0 to
+    // choose it when encoding
+    Form ProofForm
+
+    // The # of leaves inserted between the two roots, if known, see NumNewLeaves()
+    numNewLeaves    int64
+    hasNumNewLeaves bool
+
+    // The *proofSummary of the nodes, computed on demand by RootsWithOptions()
+    summary atomic.Value
+}
+
+/**
+ * What RootsWithOptions() computed, and for which nodes and parameters. The nodes are identified
+ * by a hash of their contents (see _nodesDigest()), so that the roots are computed again if the
+ * nodes are changed, be it in place or by decoding into the proof.
+ */
+type proofSummary struct {
+    params [32]byte // see _paramsDigest()
+    nodes  [32]byte // see _nodesDigest()
+    roots  ProofHashes
+    err    error
+}
+
+type ProofNode struct {
+    Level    int
+    NodeNo   [32]byte // the node's LN
+    Hash     [32]byte
+    IsNew    bool
+    Appended [][32]byte // see Node::Appended
+}
+
+const (
+    proofFlagNew      = 1 << 0
+    proofFlagAppended = 1 << 1
+)
+
+/**
+ * Flattens a proof tree into an AppendOnlyProof.
+ */
+func NewAppendOnlyProof(proofTree *Tree) *AppendOnlyProof {
+    proof := &AppendOnlyProof{}
+    for level := 0; level < proofTree.numLevels; level++ {
+        start := len(proof.Nodes)
+        lvl := proofTree.lvl[level]
+        for nodeNo, node := range lvl.node {
+            proof.Nodes = append(proof.Nodes, ProofNode{
+                Level:    level,
+                NodeNo:   nodeNo,
+                Hash:     node.Hash,
+                IsNew:    lvl.isNew(nodeNo),
+                Appended: node.Appended,
+            })
+        }
+
+        // Map iteration order is random, so sort the level's nodes to get a canonical encoding
+        lvlNodes := proof.Nodes[start:]
+        sort.Slice(lvlNodes, func(i, j int) bool {
+            return bytes.Compare(lvlNodes[i].NodeNo[:], lvlNodes[j].NodeNo[:]) < 0
+        })
+    }
+    if proofTree.numNewLeaves >= 0 {
+        proof.numNewLeaves, proof.hasNumNewLeaves = proofTree.numNewLeaves, true
+    }
+
+    return proof
+}
+
+/**
+ * Rebuilds the proof tree in the specified (empty) tree, which determines the # of levels and the
+ * hash function used to verify the proof. Returns the tree, or an error if a node is not in the
+ * tree or is in the proof twice.
+ */
+func (proof *AppendOnlyProof) ToTree(emptyTree *Tree) (*Tree, error) {
+    for i, pn := range proof.Nodes {
+        if pn.Level < 0 || pn.Level >= emptyTree.numLevels {
+            return nil, fmt.Errorf("proof node %d has invalid level %d", i, pn.Level)
+        }
+        if !_fitsInLevel(pn.NodeNo, pn.Level) {
+            return nil, fmt.Errorf("proof node %d has an LN that is too large for level %d", i, pn.Level)
+        }
+
+        lvl := emptyTree.lvl[pn.Level]
+        if _, ok := lvl.node[pn.NodeNo]; ok {
+            return nil, fmt.Errorf("proof has node %s on level %d twice", hashStr(pn.NodeNo), pn.Level)
+        }
+        emptyTree._putNode(lvl, pn.NodeNo, &Node{Hash: pn.Hash, Appended: pn.Appended})
+        if pn.IsNew {
+            lvl.markNew(pn.NodeNo)
+        }
+    }
+    emptyTree.numNewLeaves = -1
+    if n, ok := proof.NumNewLeaves(); ok {
+        emptyTree.numNewLeaves = int64(n)
+    }
+    return emptyTree, nil
+}
+
+/**
+ * Checks that the proof is a valid append-only proof from 'oldRoot' to 'newRoot' in a tree with
+ * the default options (see VerifyWithOptions()).
+ */
+func (proof *AppendOnlyProof) Verify(oldRoot [32]byte, newRoot [32]byte) error {
+    return proof.VerifyWithOptions(oldRoot, newRoot, TreeOptions{})
+}
+
+/**
+ * Checks that the proof is a valid append-only proof from 'oldRoot' to 'newRoot' in a tree with
+ * the specified # of levels and hash function, like VerifyAppendOnlyProofTree() but without
+ * building a proof tree (see RootsWithOptions()). An empty proof is only valid if the two roots
+ * are the same.
+ */
+func (proof *AppendOnlyProof) VerifyWithOptions(oldRoot [32]byte, newRoot [32]byte, opts TreeOptions) error {
+    if len(proof.Nodes) == 0 {
+        if _, _, err := opts._verifierParams(); err != nil {
+            return err
+        }
+        if !hashEqual(oldRoot, newRoot) {
+            return _verifyErrorf(ErrNewRootMismatch, "empty append-only proof, but the old and new roots differ")
+        }
+        return nil
+    }
+
+    roots, err := proof.RootsWithOptions(opts)
+    if err != nil {
+        return err
+    }
+    if !hashEqual(roots.Old, oldRoot) {
+        return _verifyErrorf(ErrOldRootMismatch, "old root hash check failed: got %s", hashStr(roots.Old))
+    }
+    if !hashEqual(roots.New, newRoot) {
+        return _verifyErrorf(ErrNewRootMismatch, "new root hash check failed: got %s", hashStr(roots.New))
+    }
+    return nil
+}
+
+/**
+ * Returns the old and the new root hash the proof hashes to in a tree with the specified options
+ * (see RootsWithOptions()).
+ */
+func (proof *AppendOnlyProof) OldRoot(opts TreeOptions) ([32]byte, error) {
+    roots, err := proof.RootsWithOptions(opts)
+    return roots.Old, err
+}
+
+func (proof *AppendOnlyProof) NewRoot(opts TreeOptions) ([32]byte, error) {
+    roots, err := proof.RootsWithOptions(opts)
+    return roots.New, err
+}
+
+/**
+ * Returns the old and the new root hash the proof hashes to in a tree with the specified options,
+ * or a VerifyError if the proof is malformed (e.g., it is missing nodes) or empty. The nodes are
+ * indexed by level and LN, and the two roots are computed together, in a single recursion from
+ * the root. The roots are cached until the nodes change, so checking the proof against several
+ * pairs of roots (e.g., the heads of several witnesses) only recomputes them once.
+ */
+func (proof *AppendOnlyProof) RootsWithOptions(opts TreeOptions) (ProofHashes, error) {
+    newHash, numLevels, err := opts._verifierParams()
+    if err != nil {
+        return ProofHashes{}, err
+    }
+    if len(proof.Nodes) == 0 {
+        return ProofHashes{}, _verifyErrorf(ErrMalformedProof, "empty append-only proof has no roots")
+    }
+
+    params := _paramsDigest(newHash, opts.RFC6962, opts.HashingScheme, numLevels)
+    nodes := proof._nodesDigest()
+    if s, ok := proof.summary.Load().(*proofSummary); ok && s.params == params && s.nodes == nodes {
+        return s.roots, s.err
+    }
+
+    summary := &proofSummary{params: params, nodes: nodes}
+    var v *proofVerifier
+    if v, summary.err = proof._newVerifier(newHash, numLevels, opts); summary.err == nil {
+        summary.roots, summary.err = v._hash(0, [32]byte{})
+    }
+    proof.summary.Store(summary)
+    return summary.roots, summary.err
+}
+
+/**
+ * Returns a SHA256 hash of every field of the proof's nodes, which identifies them in the proof's
+ * summary. Unlike the binary encoding, it has the full levels and LNs, so that nodes that are not
+ * in the tree do not hash like ones that are. Hashing the nodes once is much cheaper than
+ * computing the roots, which hashes every node on the paths from the proof's nodes to the root.
+ */
+func (proof *AppendOnlyProof) _nodesDigest() [32]byte {
+    h := sha256.New()
+    var buf [8]byte
+    writeInt := func(n int) {
+        binary.BigEndian.PutUint64(buf[:], uint64(n))
+        h.Write(buf[:])
+    }
+
+    writeInt(len(proof.Nodes))
+    for i := range proof.Nodes {
+        pn := &proof.Nodes[i]
+        writeInt(pn.Level)
+        h.Write(pn.NodeNo[:])
+        h.Write(pn.Hash[:])
+        if pn.IsNew {
+            h.Write([]byte{1})
+        } else {
+            h.Write([]byte{0})
+        }
+        writeInt(len(pn.Appended))
+        for _, appended := range pn.Appended {
+            h.Write(appended[:])
+        }
+    }
+
+    var digest [32]byte
+    h.Sum(digest[:0])
+    return digest
+}
+
+/**
+ * Returns the hash function and the # of levels of a verifier with the specified options, or a
+ * VerifyError if they are invalid.
+ */
+func (opts TreeOptions) _verifierParams() (func() hash.Hash, int, error) {
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    if newHash().Size() != 32 {
+        return nil, 0, _verifyErrorf(ErrInvalidParams, "hash function must output 32-byte digests, got %d bytes", newHash().Size())
+    }
+    numLevels, err := opts._numLevels()
+    if err != nil {
+        return nil, 0, _verifyErrorf(ErrInvalidParams, "%v", err)
+    }
+    if err := opts.HashingScheme._check(); err != nil {
+        return nil, 0, _verifyErrorf(ErrInvalidParams, "%v", err)
+    }
+    return newHash, numLevels, nil
+}
+
+/**
+ * Indexes the proof's nodes by level and LN, checking that they are in the tree and in the proof
+ * only once.
+ */
+func (proof *AppendOnlyProof) _newVerifier(newHash func() hash.Hash, numLevels int, opts TreeOptions) (*proofVerifier, error) {
+    v := &proofVerifier{
+        nodes:     make(map[proofNodeKey]*ProofNode, len(proof.Nodes)),
+        newHash:   newHash,
+        rfc6962:   opts.RFC6962,
+        scheme:    opts.HashingScheme,
+        leafLevel: numLevels - 1,
+    }
+    for i := range proof.Nodes {
+        pn := &proof.Nodes[i]
+        if pn.Level < 0 || pn.Level >= numLevels {
+            return nil, _verifyErrorf(ErrMalformedProof, "proof node %d has invalid level %d", i, pn.Level)
+        }
+        if !_fitsInLevel(pn.NodeNo, pn.Level) {
+            return nil, _verifyErrorf(ErrMalformedProof, "proof node %d has an LN that is too large for level %d", i, pn.Level)
+        }
+
+        key := proofNodeKey{pn.Level, pn.NodeNo}
+        if _, ok := v.nodes[key]; ok {
+            return nil, _verifyErrorf(ErrMalformedProof, "proof has node %s on level %d twice", hashStr(pn.NodeNo), pn.Level)
+        }
+        v.nodes[key] = pn
+    }
+    return v, nil
+}
+
+type proofNodeKey struct {
+    level  int
+    nodeNo [32]byte
+}
+
+/**
+ * The proof nodes, indexed by level and LN, and the tree parameters needed to hash them.
+ */
+type proofVerifier struct {
+    nodes     map[proofNodeKey]*ProofNode
+    newHash   func() hash.Hash
+    rfc6962   bool
+    scheme    HashingScheme
+    leafLevel int
+
+    // If not nil, _hash() records the hashes of every node it hashes here (see FindDivergence())
+    record map[proofNodeKey]ProofHashes
+}
+
+/**
+ * Recursively computes the old and new hashes of the node with LN 'nodeNo' on level 'level' (see
+ * Tree::_hashProofTreeHelper()). Nodes in the subtrees of proof nodes are never hashed.
+ */
+func (v *proofVerifier) _hash(level int, nodeNo [32]byte) (ProofHashes, error) {
+    hashes, err := v._hashNode(level, nodeNo)
+    if err == nil && v.record != nil {
+        v.record[proofNodeKey{level, nodeNo}] = hashes
+    }
+    return hashes, err
+}
+
+func (v *proofVerifier) _hashNode(level int, nodeNo [32]byte) (ProofHashes, error) {
+    if pn := v.nodes[proofNodeKey{level, nodeNo}]; pn != nil {
+        // Only leaves that existed before the batch can have appended versions
+        if len(pn.Appended) > 0 {
+            if level != v.leafLevel {
+                return ProofHashes{}, _verifyErrorf(ErrMalformedProof, "proof node %s on level %d is not a leaf but has appended hashes", hashStr(nodeNo), level)
+            }
+            if pn.IsNew {
+                return ProofHashes{}, _verifyErrorf(ErrMalformedProof, "proof leaf %s is new but has appended hashes", hashStr(nodeNo))
+            }
+        }
+
+        hashes := ProofHashes{Old: pn.Hash, New: pn.Hash}
+        if pn.IsNew {
+            hashes.Old = [32]byte{}
+        }
+        for _, dataHash := range pn.Appended {
+            hashes.New = _nodeHash(v.newHash, v.rfc6962, hashes.New, dataHash)
+        }
+        return hashes, nil
+    }
+
+    if level == v.leafLevel {
+        // The prover left out a node we need, so we cannot hash its parent
+        return ProofHashes{}, _verifyErrorf(ErrMalformedProof, "proof is missing leaf %s (or one of its ancestors)", hashStr(nodeNo))
+    }
+
+    leftNo, rightNo := childrenOf(nodeNo)
+    left, err := v._hash(level+1, leftNo)
+    if err != nil {
+        return ProofHashes{}, err
+    }
+    right, err := v._hash(level+1, rightNo)
+    if err != nil {
+        return ProofHashes{}, err
+    }
+    return ProofHashes{
+        Old: _schemeNodeHash(v.newHash, v.rfc6962, v.scheme, level, nodeNo, left.Old, right.Old),
+        New: _schemeNodeHash(v.newHash, v.rfc6962, v.scheme, level, nodeNo, left.New, right.New),
+    }, nil
+}
+
+/**
+ * Where a proof disagrees with a tree: the proof implies a hash for the node with LN 'NodeNo' on
+ * level 'Level' that is not the tree's, while the hashes it implies for the node's children (if
+ * any) are the tree's. If the node is in the proof, the subtree below it is the one that diverged;
+ * if not, the proof and the tree hash the same children differently, i.e., they do not have the
+ * same parameters (see Tree::ParamsDigest()).
+ */
+type ProofDivergence struct {
+    Level     int
+    NodeNo    [32]byte
+    ProofHash [32]byte // the hash the proof implies for the node
+    TreeHash  [32]byte // the node's hash in the tree, the empty hash if the tree does not have it
+    InProof   bool
+}
+
+func (d *ProofDivergence) String() string {
+    where := "an ancestor of proof nodes"
+    if d.InProof {
+        where = "a proof node"
+    }
+    return fmt.Sprintf("node %s on level %d (%s) has hash %s in the proof but %s in the tree",
+        hashStr(d.NodeNo), d.Level, where, hashStr(d.ProofHash), hashStr(d.TreeHash))
+}
+
+/**
+ * Locates where the proof diverges from 'tree', which must be the old tree (i.e., as of the epoch
+ * the proof starts from, see Tree::TreeAtEpoch()) if 'newSide' is false, or the new tree if it is
+ * true. Walks down from the root along the nodes whose hashes differ, so that auditors whose
+ * check against a root of theirs failed can tell which subtree the prover disagrees on. Returns
+ * nil if the proof agrees with the tree, or an error if it is malformed.
+ */
+func (proof *AppendOnlyProof) FindDivergence(tree *Tree, newSide bool) (*ProofDivergence, error) {
+    opts := tree.Options()
+    newHash, numLevels, err := opts._verifierParams()
+    if err != nil {
+        return nil, err
+    }
+    if len(proof.Nodes) == 0 {
+        return nil, _verifyErrorf(ErrMalformedProof, "empty append-only proof has no roots")
+    }
+    v, err := proof._newVerifier(newHash, numLevels, opts)
+    if err != nil {
+        return nil, err
+    }
+    v.record = make(map[proofNodeKey]ProofHashes)
+    if _, err := v._hash(0, tree.RootNo); err != nil {
+        return nil, err
+    }
+
+    proofHash := func(level int, nodeNo [32]byte) [32]byte {
+        hashes := v.record[proofNodeKey{level, nodeNo}]
+        if newSide {
+            return hashes.New
+        }
+        return hashes.Old
+    }
+    treeHash := func(level int, nodeNo [32]byte) [32]byte {
+        tree._restore(level, nodeNo)
+        if node := tree.getNode(tree.lvl[level], nodeNo); node != nil {
+            return node.Hash
+        }
+        return tree.EmptyHash
+    }
+
+    level, nodeNo := 0, tree.RootNo
+    if proofHash(level, nodeNo) == treeHash(level, nodeNo) {
+        return nil, nil
+    }
+    for {
+        d := &ProofDivergence{Level: level, NodeNo: nodeNo, ProofHash: proofHash(level, nodeNo), TreeHash: treeHash(level, nodeNo)}
+        if d.InProof = v.nodes[proofNodeKey{level, nodeNo}] != nil; d.InProof {
+            return d, nil
+        }
+
+        // Nodes that are not in the proof are its nodes' ancestors, so both their children were hashed
+        leftNo, rightNo := childrenOf(nodeNo)
+        if proofHash(level+1, leftNo) != treeHash(level+1, leftNo) {
+            nodeNo = leftNo
+        } else if proofHash(level+1, rightNo) != treeHash(level+1, rightNo) {
+            nodeNo = rightNo
+        } else {
+            return d, nil
+        }
+        level++
+    }
+}
+
+/**
+ * Returns the # of nodes in the proof.
+ */
+func (proof *AppendOnlyProof) NumNodes() int {
+    return len(proof.Nodes)
+}
+
+/**
+ * Returns the # of new subtrees in the proof, i.e., of its 'new' nodes. Every new subtree has at
+ * least one of the leaves inserted between the two roots, but the proof does not say how many, so
+ * this is a lower bound on their #.
+ */
+func (proof *AppendOnlyProof) NumNewSubtrees() int {
+    count := 0
+    for _, pn := range proof.Nodes {
+        if pn.IsNew {
+            count++
+        }
+    }
+    return count
+}
+
+/**
+ * Returns the # of leaves inserted between the two roots, or false if it is unknown. The proof's
+ * new subtrees do not say how many leaves they have (see NumNewSubtrees()), so the # is recorded
+ * by the prover when building the proof (see Tree::Insert() and Epoch::Commit()). It is neither
+ * part of the proof's encodings nor committed to by the roots, so decoded proofs do not know it.
+ */
+func (proof *AppendOnlyProof) NumNewLeaves() (int, bool) {
+    return int(proof.numNewLeaves), proof.hasNumNewLeaves
+}
+
+/**
+ * Returns the # of leaves in the proof that were updated between the two roots, i.e., that have
+ * appended hashes.
+ */
+func (proof *AppendOnlyProof) NumUpdatedLeaves() int {
+    count := 0
+    for _, pn := range proof.Nodes {
+        if len(pn.Appended) > 0 {
+            count++
+        }
+    }
+    return count
+}
+
+/**
+ * Returns the size of the proof in bytes under the binary encoding, without encoding it.
+ */
+func (proof *AppendOnlyProof) SizeBytes() int64 {
+    var size int64 = 4
+    for _, pn := range proof.Nodes {
+        size += 2 + int64(_lnNumBytes(pn.Level)) + 1 + 32
+        if len(pn.Appended) > 0 {
+            size += 4 + 32*int64(len(pn.Appended))
+        }
+    }
+    return size
+}
+
+/**
+ * Returns the # of bytes needed to store the LN of a node on level 'level'.
+ */
+func _lnNumBytes(level int) int {
+    return (level + 7) / 8
+}
+
+func (proof *AppendOnlyProof) MarshalBinary() ([]byte, error) {
+    buf := bytes.NewBuffer(make([]byte, 0, proof.SizeBytes()))
+
+    binary.Write(buf, binary.BigEndian, uint32(len(proof.Nodes)))
+    for i := range proof.Nodes {
+        _writeProofNode(buf, &proof.Nodes[i])
+    }
+
+    return buf.Bytes(), nil
+}
+
+/**
+ * Writes a node's level, LN, flags, hash and appended hashes, as in the binary encoding.
+ */
+func _writeProofNode(buf *bytes.Buffer, pn *ProofNode) {
+    var flags byte
+    if pn.IsNew {
+        flags |= proofFlagNew
+    }
+    if len(pn.Appended) > 0 {
+        flags |= proofFlagAppended
+    }
+
+    binary.Write(buf, binary.BigEndian, uint16(pn.Level))
+    buf.Write(pn.NodeNo[32-_lnNumBytes(pn.Level):])
+    buf.WriteByte(flags)
+    buf.Write(pn.Hash[:])
+
+    if len(pn.Appended) > 0 {
+        binary.Write(buf, binary.BigEndian, uint32(len(pn.Appended)))
+        for _, h := range pn.Appended {
+            buf.Write(h[:])
+        }
+    }
+}
+
+func (proof *AppendOnlyProof) UnmarshalBinary(data []byte) error {
+    r := bytes.NewReader(data)
+
+    var numNodes uint32
+    if err := binary.Read(r, binary.BigEndian, &numNodes); err != nil {
+        return fmt.Errorf("reading # of proof nodes: %v", err)
+    }
+    // Each node takes at least 35 bytes, so don't let a bogus count make us allocate a lot
+    if int64(numNodes)*35 > int64(r.Len()) {
+        return fmt.Errorf("proof claims %d nodes but only has %d bytes left", numNodes, r.Len())
+    }
+
+    proof.Nodes = make([]ProofNode, numNodes)
+    for i := range proof.Nodes {
+        pn := &proof.Nodes[i]
+
+        flags, err := _readProofNode(r, i, pn)
+        if err != nil {
+            return err
+        }
+
+        if flags&proofFlagAppended != 0 {
+            var numAppended uint32
+            if err := binary.Read(r, binary.BigEndian, &numAppended); err != nil {
+                return fmt.Errorf("reading # of appended hashes of proof node %d: %v", i, err)
+            }
+            if numAppended == 0 || int64(numAppended)*32 > int64(r.Len()) {
+                return fmt.Errorf("proof node %d claims %d appended hashes but only %d bytes are left", i, numAppended, r.Len())
+            }
+
+            pn.Appended = make([][32]byte, numAppended)
+            for j := range pn.Appended {
+                if _, err := io.ReadFull(r, pn.Appended[j][:]); err != nil {
+                    return fmt.Errorf("reading appended hash of proof node %d: %v", i, err)
+                }
+            }
+        }
+    }
+
+    if r.Len() != 0 {
+        return fmt.Errorf("%d trailing bytes after proof", r.Len())
+    }
+    return nil
+}
+
+/**
+ * Reads a node's level, LN, flags and hash (but not its appended hashes) into 'pn' and returns
+ * the node's flags. 'i' is the node's index, for error messages.
+ */
+func _readProofNode(r proofReader, i int, pn *ProofNode) (byte, error) {
+    var level uint16
+    if err := binary.Read(r, binary.BigEndian, &level); err != nil {
+        return 0, fmt.Errorf("reading level of proof node %d: %v", i, err)
+    }
+    if level > 256 {
+        return 0, fmt.Errorf("proof node %d has invalid level %d", i, level)
+    }
+    pn.Level = int(level)
+
+    if _, err := io.ReadFull(r, pn.NodeNo[32-_lnNumBytes(pn.Level):]); err != nil {
+        return 0, fmt.Errorf("reading LN of proof node %d: %v", i, err)
+    }
+
+    flags, err := r.ReadByte()
+    if err != nil {
+        return 0, fmt.Errorf("reading flags of proof node %d: %v", i, err)
+    }
+    // Unknown flags would be dropped when re-encoding the node, so a proof has a single encoding
+    if flags&^(proofFlagNew|proofFlagAppended) != 0 {
+        return 0, fmt.Errorf("proof node %d has unknown flags %#x", i, flags)
+    }
+    pn.IsNew = flags&proofFlagNew != 0
+
+    if _, err := io.ReadFull(r, pn.Hash[:]); err != nil {
+        return 0, fmt.Errorf("reading hash of proof node %d: %v", i, err)
+    }
+    return flags, nil
+}
+
+type proofReader interface {
+    io.Reader
+    io.ByteReader
+}
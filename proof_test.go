@@ -0,0 +1,231 @@
+package aomt
+
+import (
+    "bytes"
+    "testing"
+)
+
+/**
+ * Commits an epoch of 'numNew' new leaves on top of 'numOld' old ones, updating some of both, and
+ * returns the tree and the epoch's proof.
+ */
+func _testAppendOnlyProof(t *testing.T, opts TreeOptions, numOld int, numNew int) (*Tree, *AppendOnlyProof) {
+    tree, err := NewTreeWithOptions(257, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < numOld; i++ {
+        leaf := _testLeaf(i)
+        tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    }
+    tree.RecordEpoch()
+
+    epoch := tree.BeginEpoch()
+    for i := numOld; i < numOld+numNew; i++ {
+        leaf := _testLeaf(i)
+        if err := epoch.Insert(leaf.LeafNo, leaf.DataHash); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Updates of old and of new leaves do not count as new leaves
+    for i := 0; i < numOld+numNew; i += 5 {
+        if err := epoch.Update(_testLeaf(i).LeafNo, _testLeaf(-i).DataHash); err != nil {
+            t.Fatal(err)
+        }
+    }
+    proof, _ := epoch.Commit()
+    return tree, proof
+}
+
+func TestAppendOnlyProofRootsWithOptions(t *testing.T) {
+    for _, opts := range []TreeOptions{{}, {RFC6962: true}, {HashingScheme: HashingLevelLN}} {
+        tree, proof := _testAppendOnlyProof(t, opts, 50, 30)
+        oldRoot, newRoot := tree.GetEpochRoot(0), tree.GetEpochRoot(1)
+
+        if root, err := proof.OldRoot(opts); err != nil || root != oldRoot {
+            t.Errorf("%+v: old root is %s (%v), expected %s", opts, hashStr(root), err, hashStr(oldRoot))
+        }
+        if root, err := proof.NewRoot(opts); err != nil || root != newRoot {
+            t.Errorf("%+v: new root is %s (%v), expected %s", opts, hashStr(root), err, hashStr(newRoot))
+        }
+        if err := proof.VerifyWithOptions(oldRoot, newRoot, opts); err != nil {
+            t.Errorf("%+v: proof does not verify: %v", opts, err)
+        }
+    }
+}
+
+/**
+ * The roots are cached, but changing the nodes in place after a verification must not reuse them.
+ */
+func TestAppendOnlyProofTamperedInPlace(t *testing.T) {
+    tree, proof := _testAppendOnlyProof(t, TreeOptions{}, 50, 30)
+    oldRoot, newRoot := tree.GetEpochRoot(0), tree.GetEpochRoot(1)
+
+    tampers := map[string]func(pn *ProofNode){
+        "hash":                func(pn *ProofNode) { pn.Hash[0] ^= 1 },
+        "new flag":            func(pn *ProofNode) { pn.IsNew = !pn.IsNew },
+        "LN":                  func(pn *ProofNode) { pn.NodeNo[31] ^= 1 },
+        "LN out of its level": func(pn *ProofNode) { pn.NodeNo[0] ^= 0x80 },
+        "appended":            func(pn *ProofNode) { pn.Appended = append(pn.Appended, [32]byte{1}) },
+    }
+    for name, tamper := range tampers {
+        for i := 0; i < len(proof.Nodes); i += 37 {
+            // Flipping the new flag of an empty node changes neither root, so skip empty nodes
+            if proof.Nodes[i].Hash == tree.EmptyHash {
+                continue
+            }
+            if err := proof.Verify(oldRoot, newRoot); err != nil {
+                t.Fatalf("untampered proof does not verify: %v", err)
+            }
+
+            saved := proof.Nodes[i]
+            saved.Appended = append([][32]byte(nil), saved.Appended...)
+            tamper(&proof.Nodes[i])
+            if err := proof.Verify(oldRoot, newRoot); err == nil {
+                t.Errorf("proof with node %d's %s tampered with verifies", i, name)
+            }
+            proof.Nodes[i] = saved
+        }
+    }
+
+    proof.Nodes = proof.Nodes[:len(proof.Nodes)-1]
+    if err := proof.Verify(oldRoot, newRoot); err == nil {
+        t.Errorf("proof with a node removed verifies")
+    }
+}
+
+func TestAppendOnlyProofNumNewLeaves(t *testing.T) {
+    tree, proof := _testAppendOnlyProof(t, TreeOptions{}, 50, 30)
+    if n, ok := proof.NumNewLeaves(); !ok || n != 30 {
+        t.Errorf("committed proof has %d new leaves (known: %v), expected 30", n, ok)
+    }
+    // Compressed proofs have fewer new subtrees than new leaves
+    if proof.NumNewSubtrees() > 30 {
+        t.Errorf("proof has %d new subtrees for 30 new leaves", proof.NumNewSubtrees())
+    }
+
+    if n, ok := NewAppendOnlyProof(tree.ProveAppendOnly(0, 1)).NumNewLeaves(); !ok || n != 30 {
+        t.Errorf("proof of past epochs has %d new leaves (known: %v), expected 30", n, ok)
+    }
+
+    data, err := proof.MarshalBinary()
+    if err != nil {
+        t.Fatal(err)
+    }
+    decoded := &AppendOnlyProof{}
+    if err := decoded.UnmarshalBinary(data); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := decoded.NumNewLeaves(); ok {
+        t.Errorf("decoded proof knows its # of new leaves")
+    }
+}
+
+/**
+ * Tampered proofs must be rejected, for every hashing option, both when verified over their node
+ * list and when rebuilt as proof trees, and malformed ones must be rejected with errors rather
+ * than panics.
+ */
+func TestAppendOnlyProofTreeRejectsTampering(t *testing.T) {
+    for _, opts := range []TreeOptions{{}, {RFC6962: true}, {HashingScheme: HashingLevel}, {HashingScheme: HashingLevelLN}} {
+        tree, proof := _testAppendOnlyProof(t, opts, 50, 30)
+        oldRoot, newRoot := tree.GetEpochRoot(0), tree.GetEpochRoot(1)
+        verify := func(p *AppendOnlyProof) (error, error) {
+            proofTree, err := p.ToTree(tree.NewEmptyTree())
+            if err == nil {
+                err = VerifyAppendOnlyProofTree(proofTree, oldRoot, newRoot)
+            }
+            return p.VerifyWithOptions(oldRoot, newRoot, opts), err
+        }
+        if listErr, treeErr := verify(proof); listErr != nil || treeErr != nil {
+            t.Fatalf("%+v: proof does not verify: %v, %v", opts, listErr, treeErr)
+        }
+
+        tampers := map[string]func(p *AppendOnlyProof, i int){
+            "hash flipped":     func(p *AppendOnlyProof, i int) { p.Nodes[i].Hash[0] ^= 1 },
+            "new flag flipped": func(p *AppendOnlyProof, i int) { p.Nodes[i].IsNew = !p.Nodes[i].IsNew },
+            "LN flipped":       func(p *AppendOnlyProof, i int) { p.Nodes[i].NodeNo[31] ^= 1 },
+            "level too large":  func(p *AppendOnlyProof, i int) { p.Nodes[i].Level = 257 },
+            "dropped":          func(p *AppendOnlyProof, i int) { p.Nodes = append(p.Nodes[:i], p.Nodes[i+1:]...) },
+            "repeated":         func(p *AppendOnlyProof, i int) { p.Nodes = append(p.Nodes, p.Nodes[i]) },
+        }
+        for name, tamper := range tampers {
+            for i := 0; i < len(proof.Nodes); i += len(proof.Nodes)/16 + 1 {
+                // Flipping the new flag of an empty node changes neither root
+                if name == "new flag flipped" && proof.Nodes[i].Hash == tree.EmptyHash {
+                    continue
+                }
+                tampered := &AppendOnlyProof{Nodes: append([]ProofNode{}, proof.Nodes...)}
+                tamper(tampered, i)
+                if listErr, treeErr := verify(tampered); listErr == nil || treeErr == nil {
+                    t.Errorf("%+v: proof with node %d %s verifies (%v, %v)", opts, i, name, listErr, treeErr)
+                }
+            }
+        }
+    }
+}
+
+/**
+ * A proof has a single binary encoding: whatever decodes must re-encode to the same bytes, and
+ * encodings with unknown flags or an appended flag but no appended hashes must be rejected.
+ */
+func TestAppendOnlyProofDecodesCanonically(t *testing.T) {
+    _, proof := _testAppendOnlyProof(t, TreeOptions{}, 50, 30)
+    data, err := proof.MarshalBinary()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Where each node's flags are, and the index of a node with appended hashes and one without
+    var flagsAt []int
+    withAppended, withoutAppended := -1, -1
+    offset := 4
+    for i, pn := range proof.Nodes {
+        flagsAt = append(flagsAt, offset+2+_lnNumBytes(pn.Level))
+        offset += 2 + _lnNumBytes(pn.Level) + 1 + 32
+        if len(pn.Appended) > 0 {
+            offset += 4 + 32*len(pn.Appended)
+            withAppended = i
+        } else {
+            withoutAppended = i
+        }
+    }
+    if withAppended < 0 || withoutAppended < 0 {
+        t.Fatalf("the proof's nodes all have appended hashes, or none do")
+    }
+
+    malformed := map[string][]byte{}
+    malform := func(name string, change func(b []byte) []byte) {
+        malformed[name] = change(append([]byte{}, data...))
+    }
+    malform("unknown flag", func(b []byte) []byte { b[flagsAt[0]] |= 0x80; return b })
+    malform("appended flag dropped", func(b []byte) []byte { b[flagsAt[withAppended]] &^= proofFlagAppended; return b })
+    malform("appended flag without hashes", func(b []byte) []byte {
+        at := flagsAt[withoutAppended] + 1 + 32
+        b[flagsAt[withoutAppended]] |= proofFlagAppended
+        return append(b[:at], append(make([]byte, 4), b[at:]...)...)
+    })
+    malform("truncated", func(b []byte) []byte { return b[:len(b)-1] })
+    malform("trailing byte", func(b []byte) []byte { return append(b, 0) })
+    malform("too many nodes", func(b []byte) []byte { b[3]++; return b })
+    for name, b := range malformed {
+        if err := (&AppendOnlyProof{}).UnmarshalBinary(b); err == nil {
+            t.Errorf("proof with %s decodes", name)
+        }
+    }
+
+    // Any flags byte that decodes must re-encode as it was
+    for _, at := range []int{flagsAt[withAppended], flagsAt[withoutAppended]} {
+        for flags := 0; flags < 256; flags++ {
+            b := append([]byte{}, data...)
+            b[at] = byte(flags)
+            decoded := &AppendOnlyProof{}
+            if decoded.UnmarshalBinary(b) != nil {
+                continue
+            }
+            if encoded, err := decoded.MarshalBinary(); err != nil || !bytes.Equal(encoded, b) {
+                t.Errorf("proof with flags %#x at byte %d does not re-encode to the same bytes", flags, at)
+            }
+        }
+    }
+}
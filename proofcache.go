@@ -0,0 +1,157 @@
+package aomt
+
+import (
+    "container/list"
+    "sync"
+)
+
+/**
+ * An LRU cache of membership proofs keyed by (leaf, epoch), so that a server answering many
+ * lookups of hot keys does not recompute (or, for old epochs, replay the tree to compute) the same
+ * sibling path over and over. A proof about an epoch never changes, so cached proofs are never
+ * stale; still, once a new epoch is committed, lookups move on to it, so the proofs about older
+ * epochs are dropped (see Advance()) rather than left to age out of the cache.
+ *
+ * The cache holds at most 'maxBytes' bytes of proofs, as estimated by _proofCacheEntrySize(), and
+ * can be used concurrently. Cached proofs are shared, so callers must not modify them.
+ */
+type ProofCache struct {
+    mu       sync.Mutex
+    maxBytes int64
+    bytes    int64
+    latest   int // the latest epoch, see Advance()
+    entries  map[proofCacheKey]*list.Element
+    lru      *list.List // of *proofCacheEntry, the most recently used first
+    stats    ProofCacheStats
+}
+
+type ProofCacheStats struct {
+    Hits          uint64 `json:"hits"`
+    Misses        uint64 `json:"misses"`
+    Evictions     uint64 `json:"evictions"`     // the proofs dropped to make room for new ones
+    Invalidations uint64 `json:"invalidations"` // the proofs dropped after a new epoch, see Advance()
+    Entries       int    `json:"entries"`
+    Bytes         int64  `json:"bytes"`
+    MaxBytes      int64  `json:"maxBytes"`
+}
+
+type proofCacheKey struct {
+    leafNo    [32]byte
+    epoch     int
+    withValue bool // see Tree::ProveMembershipWithValue()
+}
+
+type proofCacheEntry struct {
+    key   proofCacheKey
+    root  [32]byte // the root hash of the epoch, which the proof is checked against
+    proof *MembershipProof
+    size  int64
+}
+
+// The estimated size of an entry without its proof's siblings, value and opening, including the
+// map and list bookkeeping
+const proofCacheEntryOverhead = 256
+
+func NewProofCache(maxBytes int64) *ProofCache {
+    return &ProofCache{
+        maxBytes: maxBytes,
+        entries:  make(map[proofCacheKey]*list.Element),
+        lru:      list.New(),
+    }
+}
+
+/**
+ * Returns the cached proof of the leaf as of the epoch, and the root hash of the epoch.
+ */
+func (cache *ProofCache) Get(leafNo [32]byte, epoch int, withValue bool) (*MembershipProof, [32]byte, bool) {
+    cache.mu.Lock()
+    defer cache.mu.Unlock()
+
+    elem, ok := cache.entries[proofCacheKey{leafNo, epoch, withValue}]
+    if !ok {
+        cache.stats.Misses++
+        return nil, [32]byte{}, false
+    }
+    cache.stats.Hits++
+    cache.lru.MoveToFront(elem)
+    entry := elem.Value.(*proofCacheEntry)
+    return entry.proof, entry.root, true
+}
+
+/**
+ * Caches the proof of the leaf as of the epoch, evicting the least recently used proofs if the
+ * cache is full. Proofs about epochs before the latest one (see Advance()) and proofs bigger than
+ * the whole cache are not cached.
+ */
+func (cache *ProofCache) Put(leafNo [32]byte, epoch int, withValue bool, root [32]byte, proof *MembershipProof) {
+    cache.mu.Lock()
+    defer cache.mu.Unlock()
+
+    key := proofCacheKey{leafNo, epoch, withValue}
+    size := _proofCacheEntrySize(proof)
+    if epoch < cache.latest || size > cache.maxBytes {
+        return
+    }
+    if elem, ok := cache.entries[key]; ok {
+        cache._remove(elem)
+    }
+
+    for cache.bytes+size > cache.maxBytes {
+        cache._remove(cache.lru.Back())
+        cache.stats.Evictions++
+    }
+    cache.entries[key] = cache.lru.PushFront(&proofCacheEntry{key: key, root: root, proof: proof, size: size})
+    cache.bytes += size
+}
+
+/**
+ * Tells the cache that the tree has 'numEpochs' epochs, dropping the proofs about the epochs
+ * before the latest one if it is new.
+ */
+func (cache *ProofCache) Advance(numEpochs int) {
+    cache.mu.Lock()
+    defer cache.mu.Unlock()
+
+    latest := numEpochs - 1
+    if latest <= cache.latest {
+        return
+    }
+    cache.latest = latest
+
+    for elem := cache.lru.Front(); elem != nil; {
+        next := elem.Next()
+        if elem.Value.(*proofCacheEntry).key.epoch < latest {
+            cache._remove(elem)
+            cache.stats.Invalidations++
+        }
+        elem = next
+    }
+}
+
+func (cache *ProofCache) Stats() ProofCacheStats {
+    cache.mu.Lock()
+    defer cache.mu.Unlock()
+
+    stats := cache.stats
+    stats.Entries = len(cache.entries)
+    stats.Bytes = cache.bytes
+    stats.MaxBytes = cache.maxBytes
+    return stats
+}
+
+func (cache *ProofCache) _remove(elem *list.Element) {
+    entry := cache.lru.Remove(elem).(*proofCacheEntry)
+    delete(cache.entries, entry.key)
+    cache.bytes -= entry.size
+}
+
+/**
+ * Estimates the memory used by a cached proof.
+ */
+func _proofCacheEntrySize(proof *MembershipProof) int64 {
+    size := int64(proofCacheEntryOverhead + 32*len(proof.Siblings) + len(proof.Value))
+    if proof.Opening != nil {
+        size += int64(32 + len(proof.Opening.Value))
+    }
+    return size
+}
@@ -0,0 +1,60 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * The cache must return the proofs put in it, evict the least recently used ones once full, and
+ * drop the proofs about older epochs once a new epoch is committed.
+ */
+func TestProofCacheEvictsAndAdvances(t *testing.T) {
+    tree := _testEpochTree(1)
+    tree.RecordEpoch()
+    root := tree.GetRootHash()
+    var proofs []*MembershipProof
+    for i := 0; i < 4; i++ {
+        proofs = append(proofs, tree.ProveMembership(_testLeaf(i).LeafNo))
+    }
+    size := _proofCacheEntrySize(proofs[0])
+
+    cache := NewProofCache(3 * size)
+    cache.Advance(2)
+    for i, proof := range proofs[:3] {
+        cache.Put(proof.LeafNo, 1, false, root, proof)
+        if cached, cachedRoot, ok := cache.Get(proof.LeafNo, 1, false); !ok || cached != proof || cachedRoot != root {
+            t.Fatalf("proof %d is not cached", i)
+        }
+    }
+    if _, _, ok := cache.Get(proofs[0].LeafNo, 1, true); ok {
+        t.Errorf("proof without the value is returned for a proof with the value")
+    }
+    if _, _, ok := cache.Get(proofs[0].LeafNo, 0, false); ok {
+        t.Errorf("proof is returned for another epoch")
+    }
+
+    // Proof 0 was used last, so proof 1 is evicted
+    cache.Get(proofs[0].LeafNo, 1, false)
+    cache.Put(proofs[3].LeafNo, 1, false, root, proofs[3])
+    if _, _, ok := cache.Get(proofs[1].LeafNo, 1, false); ok {
+        t.Errorf("least recently used proof was not evicted")
+    }
+    for _, i := range []int{0, 2, 3} {
+        if _, _, ok := cache.Get(proofs[i].LeafNo, 1, false); !ok {
+            t.Errorf("proof %d was evicted", i)
+        }
+    }
+    if stats := cache.Stats(); stats.Entries != 3 || stats.Bytes > stats.MaxBytes || stats.Evictions != 1 {
+        t.Errorf("cache has stats %+v", stats)
+    }
+
+    // Proofs about epochs before the latest one are dropped, and no longer cached
+    cache.Advance(3)
+    if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 || stats.Invalidations != 3 {
+        t.Errorf("cache has stats %+v after a new epoch", stats)
+    }
+    cache.Put(proofs[0].LeafNo, 1, false, root, proofs[0])
+    if _, _, ok := cache.Get(proofs[0].LeafNo, 1, false); ok {
+        t.Errorf("proof about an old epoch was cached")
+    }
+}
@@ -0,0 +1,307 @@
+package aomt
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+)
+
+/**
+ * The compact encoding (see MarshalCompressed()) sends the shape of the proof tree, which costs a
+ * few bits per node but then needs every node's position. For small batches, it is smaller to
+ * send the changed leaves (i.e., the new leaves and the updated ones) with their full positions
+ * and just the hashes of the siblings along their paths to the root, in the order the verifier
+ * walks up the paths: the siblings' positions follow from the changed leaves' positions. This is
+ * the paths form:
+ *
+ *   numChanged   (4 bytes), followed by the changed nodes in pre-order, each encoded as:
+ *     level, LN, flags, hash and appended hashes, as in AppendOnlyProof's binary encoding
+ *   isEmpty      (numSiblings bits, padded with zeros to a whole # of bytes)
+ *   hashes       (32 bytes each, of the siblings that are not empty)
+ *
+ * where the siblings are enumerated by walking up from each changed node, in order, until the
+ * root or a node on the path of a previous changed node, and taking the sibling of every node on
+ * the way, unless it is on the path of a changed node. Integers are big-endian.
+ *
+ * Epoch::Commit() picks the smaller of the two forms (see ChooseForm()), and MarshalAdaptive()
+ * writes the chosen one after a 1-byte header saying which it is.
+ */
+type ProofForm byte
+
+const (
+    ProofFormSubtree ProofForm = 1 // the compact encoding, see MarshalCompressed()
+    ProofFormPaths   ProofForm = 2 // see MarshalPaths()
+)
+
+func (form ProofForm) String() string {
+    switch form {
+    case ProofFormSubtree:
+        return "subtree"
+    case ProofFormPaths:
+        return "paths"
+    }
+    return fmt.Sprintf("ProofForm(%d)", byte(form))
+}
+
+/**
+ * Returns the form whose encoding of the proof is the smallest. The proof must be compressed, like
+ * the ones returned by Epoch::Commit().
+ */
+func (proof *AppendOnlyProof) ChooseForm() ProofForm {
+    if proof.PathsSize() < proof.CompressedSize() {
+        return ProofFormPaths
+    }
+    return ProofFormSubtree
+}
+
+/**
+ * Returns the size of the proof in bytes under the paths form, without encoding it. The proof
+ * must be compressed, like the ones returned by Epoch::Commit().
+ */
+func (proof *AppendOnlyProof) PathsSize() int64 {
+    var size, numSiblings int64 = 4, 0
+    for _, pn := range proof.Nodes {
+        if !_isChangedNode(&pn) {
+            numSiblings++
+            if pn.Hash != [32]byte{} {
+                size += 32
+            }
+            continue
+        }
+
+        size += 2 + int64(_lnNumBytes(pn.Level)) + 1 + 32
+        if len(pn.Appended) > 0 {
+            size += 4 + 32*int64(len(pn.Appended))
+        }
+    }
+    return size + (numSiblings+7)/8
+}
+
+/**
+ * Encodes the proof under the form chosen by Epoch::Commit() (or, if none was, the smallest one),
+ * preceded by a 1-byte header with the form.
+ */
+func (proof *AppendOnlyProof) MarshalAdaptive() ([]byte, error) {
+    form := proof.Form
+    if form == 0 {
+        form = proof.ChooseForm()
+    }
+
+    var body []byte
+    var err error
+    switch form {
+    case ProofFormSubtree:
+        body, err = proof.MarshalCompressed()
+    case ProofFormPaths:
+        body, err = proof.MarshalPaths()
+    default:
+        err = fmt.Errorf("unknown proof form %v", form)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte{byte(form)}, body...), nil
+}
+
+/**
+ * Decodes a proof encoded via MarshalAdaptive(), remembering its form.
+ */
+func (proof *AppendOnlyProof) UnmarshalAdaptive(data []byte) error {
+    if len(data) == 0 {
+        return errors.New("missing proof form")
+    }
+
+    var err error
+    form := ProofForm(data[0])
+    switch form {
+    case ProofFormSubtree:
+        err = proof.UnmarshalCompressed(data[1:])
+    case ProofFormPaths:
+        err = proof.UnmarshalPaths(data[1:])
+    default:
+        err = fmt.Errorf("unknown proof form %d", data[0])
+    }
+    if err != nil {
+        return err
+    }
+    proof.Form = form
+    return nil
+}
+
+/**
+ * Encodes the proof under the paths form. Fails if the proof is not compressed or has nodes that
+ * are not changed nodes nor siblings along their paths.
+ */
+func (proof *AppendOnlyProof) MarshalPaths() ([]byte, error) {
+    var changed []*ProofNode
+    var changedKeys []proofNodeKey
+    others := make(map[proofNodeKey]*ProofNode)
+    for _, pn := range proof._preOrderNodes() {
+        key := proofNodeKey{pn.Level, pn.NodeNo}
+        if _, ok := others[key]; ok {
+            return nil, fmt.Errorf("proof has node %s on level %d twice", hashStr(pn.NodeNo), pn.Level)
+        }
+        if _isChangedNode(pn.ProofNode) {
+            changed = append(changed, pn.ProofNode)
+            changedKeys = append(changedKeys, key)
+        } else {
+            others[key] = pn.ProofNode
+        }
+    }
+
+    siblings, err := _pathSiblings(changedKeys)
+    if err != nil {
+        return nil, err
+    }
+    if len(siblings) != len(others) {
+        return nil, fmt.Errorf("proof has %d nodes off the paths of its changed nodes", len(others)-len(siblings))
+    }
+
+    buf := bytes.NewBuffer(make([]byte, 0, proof.PathsSize()))
+    binary.Write(buf, binary.BigEndian, uint32(len(changed)))
+    for _, pn := range changed {
+        _writeProofNode(buf, pn)
+    }
+
+    var isEmpty bitList
+    var hashes bytes.Buffer
+    for _, key := range siblings {
+        pn, ok := others[key]
+        if !ok {
+            return nil, fmt.Errorf("proof is missing sibling %s on level %d", hashStr(key.nodeNo), key.level)
+        }
+        isEmpty.append(pn.Hash == [32]byte{})
+        if pn.Hash != [32]byte{} {
+            hashes.Write(pn.Hash[:])
+        }
+    }
+    buf.Write(isEmpty.bits)
+    buf.Write(hashes.Bytes())
+
+    return buf.Bytes(), nil
+}
+
+/**
+ * Decodes a proof from the paths form. The nodes end up sorted by level and then by LN, as in
+ * UnmarshalCompressed().
+ */
+func (proof *AppendOnlyProof) UnmarshalPaths(data []byte) error {
+    r := bytes.NewReader(data)
+
+    var numChanged uint32
+    if err := binary.Read(r, binary.BigEndian, &numChanged); err != nil {
+        return fmt.Errorf("reading # of changed proof nodes: %v", err)
+    }
+    // Each node takes at least 35 bytes, so don't let a bogus count make us allocate a lot
+    if int64(numChanged)*35 > int64(r.Len()) {
+        return fmt.Errorf("proof claims %d changed nodes but only has %d bytes left", numChanged, r.Len())
+    }
+
+    proof.Nodes = make([]ProofNode, numChanged)
+    changedKeys := make([]proofNodeKey, numChanged)
+    for i := range proof.Nodes {
+        pn := &proof.Nodes[i]
+        flags, err := _readProofNode(r, i, pn)
+        if err != nil {
+            return err
+        }
+        if !_fitsInLevel(pn.NodeNo, pn.Level) {
+            return fmt.Errorf("proof node %d has an LN that is too large for level %d", i, pn.Level)
+        }
+
+        if flags&proofFlagAppended != 0 {
+            var numAppended uint32
+            if err := binary.Read(r, binary.BigEndian, &numAppended); err != nil {
+                return fmt.Errorf("reading # of appended hashes of proof node %d: %v", i, err)
+            }
+            if numAppended == 0 || int64(numAppended)*32 > int64(r.Len()) {
+                return fmt.Errorf("proof node %d claims %d appended hashes but only %d bytes are left", i, numAppended, r.Len())
+            }
+
+            pn.Appended = make([][32]byte, numAppended)
+            for j := range pn.Appended {
+                if _, err := io.ReadFull(r, pn.Appended[j][:]); err != nil {
+                    return fmt.Errorf("reading appended hash of proof node %d: %v", i, err)
+                }
+            }
+        }
+        if !_isChangedNode(pn) {
+            return fmt.Errorf("proof node %d is neither new nor updated", i)
+        }
+        changedKeys[i] = proofNodeKey{pn.Level, pn.NodeNo}
+    }
+
+    siblings, err := _pathSiblings(changedKeys)
+    if err != nil {
+        return err
+    }
+
+    var isEmpty bitList
+    isEmpty.n = len(siblings)
+    isEmpty.bits = make([]byte, (len(siblings)+7)/8)
+    if _, err := io.ReadFull(r, isEmpty.bits); err != nil {
+        return fmt.Errorf("reading which of the %d siblings are empty: %v", len(siblings), err)
+    }
+
+    for i, key := range siblings {
+        pn := ProofNode{Level: key.level, NodeNo: key.nodeNo}
+        if !isEmpty.get(i) {
+            if _, err := io.ReadFull(r, pn.Hash[:]); err != nil {
+                return fmt.Errorf("reading hash of sibling %d: %v", i, err)
+            }
+        }
+        proof.Nodes = append(proof.Nodes, pn)
+    }
+
+    if r.Len() != 0 {
+        return fmt.Errorf("%d trailing bytes after proof", r.Len())
+    }
+
+    proof._sortNodes()
+    return nil
+}
+
+/**
+ * Returns true if the node is a new or updated leaf (or subtree), i.e., one whose path the
+ * verifier walks up in the paths form.
+ */
+func _isChangedNode(pn *ProofNode) bool {
+    return pn.IsNew || len(pn.Appended) > 0
+}
+
+/**
+ * Returns the positions of the siblings along the paths of the changed nodes, in the order of the
+ * paths form. Fails if a changed node is on the path of another one (or is in the list twice).
+ */
+func _pathSiblings(changed []proofNodeKey) ([]proofNodeKey, error) {
+    onPath := make(map[proofNodeKey]bool)
+    for _, key := range changed {
+        for level, nodeNo := key.level, key.nodeNo; level > 0; {
+            level, nodeNo = level-1, parentOf(nodeNo)
+            onPath[proofNodeKey{level, nodeNo}] = true
+        }
+    }
+    for _, key := range changed {
+        if onPath[key] {
+            return nil, fmt.Errorf("changed proof node %s on level %d is on the path of another one", hashStr(key.nodeNo), key.level)
+        }
+        onPath[key] = true
+    }
+
+    var siblings []proofNodeKey
+    walked := make(map[proofNodeKey]bool)
+    for _, key := range changed {
+        for key.level > 0 && !walked[key] {
+            walked[key] = true
+
+            siblingNo, _ := siblingOf(key.nodeNo)
+            if sibling := (proofNodeKey{key.level, siblingNo}); !onPath[sibling] {
+                siblings = append(siblings, sibling)
+            }
+            key = proofNodeKey{key.level - 1, parentOf(key.nodeNo)}
+        }
+    }
+    return siblings, nil
+}
@@ -0,0 +1,308 @@
+package aomt
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+)
+
+/**
+ * A more compact encoding of append-only proofs, which does not store the nodes' levels and LNs.
+ *
+ * The nodes of a compressed proof tree (see Tree::_compressProofTree()) are the leaves of a binary
+ * tree whose inner nodes are the ancestors that the verifier recomputes. So the proof's shape can
+ * be sent as one bit per position of that tree, in pre-order from the root: 1 if the position is
+ * recomputed from its two children, 0 if it is a proof node. A proof with n nodes has exactly
+ * 2n - 1 positions, and each node's level and LN follow from its path from the root. The encoding is:
+ *
+ *   numNodes     (4 bytes)
+ *   shape        (2 * numNodes - 1 bits)
+ *   isNew        (numNodes bits)
+ *   isEmpty      (numNodes bits: set for nodes whose hash is the all-zero hash of empty subtrees)
+ *   hasAppended  (numNodes bits)
+ *   hashes       (32 bytes each, of the nodes that are not empty)
+ *   for each node with appended hashes:
+ *     numAppended (4 bytes), followed by numAppended 32-byte hashes
+ *
+ * Each list of bits is padded with zeros to a whole # of bytes. The nodes' bits, hashes and
+ * appended hashes are all in pre-order. Integers are big-endian.
+ *
+ * Empty siblings are common in proofs (see Tree::GetNumEmptySiblings()), so not sending their
+ * hashes saves even more than not sending the coordinates.
+ */
+
+/**
+ * Returns the size of the proof in bytes under the compact encoding, without encoding it. The
+ * proof must be compressed, like the ones returned by Epoch::Commit().
+ */
+func (proof *AppendOnlyProof) CompressedSize() int64 {
+    n := int64(len(proof.Nodes))
+    if n == 0 {
+        return 4
+    }
+
+    size := 4 + (2*n-1+7)/8 + 3*((n+7)/8)
+    for _, pn := range proof.Nodes {
+        if pn.Hash != [32]byte{} {
+            size += 32
+        }
+        if len(pn.Appended) > 0 {
+            size += 4 + 32*int64(len(pn.Appended))
+        }
+    }
+    return size
+}
+
+/**
+ * Encodes the proof under the compact encoding. Fails if the proof is not compressed (i.e., has a
+ * node in the subtree of another node) or is missing a subtree that the verifier would need.
+ */
+func (proof *AppendOnlyProof) MarshalCompressed() ([]byte, error) {
+    var shape, isNew, isEmpty, hasAppended bitList
+    var ordered []*ProofNode
+
+    if len(proof.Nodes) > 0 {
+        if err := _encodeProofShape(proof._preOrderNodes(), 0, [32]byte{}, &shape, &ordered); err != nil {
+            return nil, err
+        }
+    }
+
+    var hashes bytes.Buffer
+    var appended bytes.Buffer
+    for _, pn := range ordered {
+        isNew.append(pn.IsNew)
+        isEmpty.append(pn.Hash == [32]byte{})
+        hasAppended.append(len(pn.Appended) > 0)
+
+        if pn.Hash != [32]byte{} {
+            hashes.Write(pn.Hash[:])
+        }
+        if len(pn.Appended) > 0 {
+            binary.Write(&appended, binary.BigEndian, uint32(len(pn.Appended)))
+            for _, h := range pn.Appended {
+                appended.Write(h[:])
+            }
+        }
+    }
+
+    buf := bytes.NewBuffer(make([]byte, 0, proof.CompressedSize()))
+    binary.Write(buf, binary.BigEndian, uint32(len(ordered)))
+    for _, bits := range []*bitList{&shape, &isNew, &isEmpty, &hasAppended} {
+        buf.Write(bits.bits)
+    }
+    buf.Write(hashes.Bytes())
+    buf.Write(appended.Bytes())
+
+    return buf.Bytes(), nil
+}
+
+type pathProofNode struct {
+    *ProofNode
+    firstLeaf [32]byte // the LN of the leftmost leaf in the node's subtree
+}
+
+/**
+ * Returns the proof's nodes in pre-order, which is the order of their leftmost leaves, since an
+ * ancestor would come right before its descendants. For compressed proofs, this is also the
+ * post-order and the left-to-right order of the nodes.
+ */
+func (proof *AppendOnlyProof) _preOrderNodes() []pathProofNode {
+    nodes := make([]pathProofNode, len(proof.Nodes))
+    for i := range proof.Nodes {
+        pn := &proof.Nodes[i]
+        nodes[i] = pathProofNode{pn, lshBytes(pn.NodeNo, uint(256-pn.Level))}
+    }
+    sort.Slice(nodes, func(i, j int) bool {
+        if c := bytes.Compare(nodes[i].firstLeaf[:], nodes[j].firstLeaf[:]); c != 0 {
+            return c < 0
+        }
+        return nodes[i].Level < nodes[j].Level
+    })
+    return nodes
+}
+
+/**
+ * Appends the shape of the subtree of the node with LN 'nodeNo' on level 'level' to 'shape', and
+ * its proof nodes to 'ordered', given the proof nodes in that subtree, sorted in pre-order.
+ */
+func _encodeProofShape(nodes []pathProofNode, level int, nodeNo [32]byte, shape *bitList, ordered *[]*ProofNode) error {
+    if len(nodes) == 0 {
+        return fmt.Errorf("proof is missing the subtree of node %s on level %d", hashStr(nodeNo), level)
+    }
+
+    if nodes[0].Level == level {
+        if len(nodes) > 1 {
+            return fmt.Errorf("proof is not compressed: node %s on level %d has descendants in the proof", hashStr(nodeNo), level)
+        }
+        shape.append(false)
+        *ordered = append(*ordered, nodes[0].ProofNode)
+        return nil
+    }
+
+    // Nodes in the right subtree have a 1 in their leftmost leaf's LN, right after this node's path
+    split := sort.Search(len(nodes), func(i int) bool {
+        return _getBit(nodes[i].firstLeaf, level)
+    })
+
+    shape.append(true)
+    leftNo, rightNo := childrenOf(nodeNo)
+    if err := _encodeProofShape(nodes[:split], level+1, leftNo, shape, ordered); err != nil {
+        return err
+    }
+    return _encodeProofShape(nodes[split:], level+1, rightNo, shape, ordered)
+}
+
+/**
+ * Decodes a proof from the compact encoding. The nodes end up sorted by level and then by LN, so
+ * the decoded proof is the same as the one that was encoded, if it came from NewAppendOnlyProof().
+ */
+func (proof *AppendOnlyProof) UnmarshalCompressed(data []byte) error {
+    r := bytes.NewReader(data)
+
+    var numNodes uint32
+    if err := binary.Read(r, binary.BigEndian, &numNodes); err != nil {
+        return fmt.Errorf("reading # of proof nodes: %v", err)
+    }
+    n := int64(numNodes)
+
+    proof.Nodes = nil
+    if n == 0 {
+        if r.Len() != 0 {
+            return fmt.Errorf("%d trailing bytes after proof", r.Len())
+        }
+        return nil
+    }
+
+    // Don't let a bogus count make us allocate a lot
+    if (2*n-1+7)/8+3*((n+7)/8) > int64(r.Len()) {
+        return fmt.Errorf("proof claims %d nodes but only has %d bytes left", numNodes, r.Len())
+    }
+
+    var shape, isNew, isEmpty, hasAppended bitList
+    for _, bits := range []struct {
+        list *bitList
+        num  int64
+    }{{&shape, 2*n - 1}, {&isNew, n}, {&isEmpty, n}, {&hasAppended, n}} {
+        bits.list.n = int(bits.num)
+        bits.list.bits = make([]byte, (bits.num+7)/8)
+        io.ReadFull(r, bits.list.bits)
+    }
+
+    proof.Nodes = make([]ProofNode, 0, n)
+    pos := 0
+    if err := _decodeProofShape(&shape, &pos, 0, [32]byte{}, &proof.Nodes); err != nil {
+        return err
+    }
+    if pos != shape.n || int64(len(proof.Nodes)) != n {
+        return fmt.Errorf("proof shape has %d positions and %d nodes, expected %d and %d", pos, len(proof.Nodes), shape.n, n)
+    }
+
+    for i := range proof.Nodes {
+        pn := &proof.Nodes[i]
+        pn.IsNew = isNew.get(i)
+        if !isEmpty.get(i) {
+            if _, err := io.ReadFull(r, pn.Hash[:]); err != nil {
+                return fmt.Errorf("reading hash of proof node %d: %v", i, err)
+            }
+        }
+    }
+
+    for i := range proof.Nodes {
+        if !hasAppended.get(i) {
+            continue
+        }
+
+        pn := &proof.Nodes[i]
+        var numAppended uint32
+        if err := binary.Read(r, binary.BigEndian, &numAppended); err != nil {
+            return fmt.Errorf("reading # of appended hashes of proof node %d: %v", i, err)
+        }
+        if numAppended == 0 || int64(numAppended)*32 > int64(r.Len()) {
+            return fmt.Errorf("proof node %d claims %d appended hashes but only %d bytes are left", i, numAppended, r.Len())
+        }
+
+        pn.Appended = make([][32]byte, numAppended)
+        for j := range pn.Appended {
+            if _, err := io.ReadFull(r, pn.Appended[j][:]); err != nil {
+                return fmt.Errorf("reading appended hash of proof node %d: %v", i, err)
+            }
+        }
+    }
+
+    if r.Len() != 0 {
+        return fmt.Errorf("%d trailing bytes after proof", r.Len())
+    }
+
+    proof._sortNodes()
+    return nil
+}
+
+/**
+ * Sorts the proof's nodes by level and then by LN, as NewAppendOnlyProof() does.
+ */
+func (proof *AppendOnlyProof) _sortNodes() {
+    sort.SliceStable(proof.Nodes, func(i, j int) bool {
+        if proof.Nodes[i].Level != proof.Nodes[j].Level {
+            return proof.Nodes[i].Level < proof.Nodes[j].Level
+        }
+        return bytes.Compare(proof.Nodes[i].NodeNo[:], proof.Nodes[j].NodeNo[:]) < 0
+    })
+}
+
+/**
+ * Reads the shape of the subtree of the node with LN 'nodeNo' on level 'level', starting at
+ * position 'pos' of 'shape', and appends its proof nodes to 'nodes' in pre-order.
+ */
+func _decodeProofShape(shape *bitList, pos *int, level int, nodeNo [32]byte, nodes *[]ProofNode) error {
+    if *pos >= shape.n {
+        return errors.New("proof shape ends too early")
+    }
+
+    isInner := shape.get(*pos)
+    *pos++
+    if !isInner {
+        *nodes = append(*nodes, ProofNode{Level: level, NodeNo: nodeNo})
+        return nil
+    }
+
+    if level >= 256 {
+        return errors.New("proof shape goes below the leaves")
+    }
+    leftNo, rightNo := childrenOf(nodeNo)
+    if err := _decodeProofShape(shape, pos, level+1, leftNo, nodes); err != nil {
+        return err
+    }
+    return _decodeProofShape(shape, pos, level+1, rightNo, nodes)
+}
+
+/**
+ * A list of bits, packed in bytes starting from the most significant bit.
+ */
+type bitList struct {
+    bits []byte
+    n    int
+}
+
+func (bl *bitList) append(bit bool) {
+    if bl.n%8 == 0 {
+        bl.bits = append(bl.bits, 0)
+    }
+    if bit {
+        bl.bits[bl.n/8] |= 0x80 >> uint(bl.n%8)
+    }
+    bl.n++
+}
+
+func (bl *bitList) get(i int) bool {
+    return bl.bits[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+/**
+ * Returns the i-th bit of 'h', counting from the most significant bit of h[0].
+ */
+func _getBit(h [32]byte, i int) bool {
+    return h[i/8]&(0x80>>uint(i%8)) != 0
+}
@@ -0,0 +1,781 @@
+package aomt
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+)
+
+/**
+ * The protobuf encoding of proofs, tree heads and RPC messages, as defined in aomt.proto, so that
+ * clients in other languages can generate their types from the schema and talk to our servers.
+ * We encode and decode the wire format by hand rather than via generated code, since this code
+ * has no dependencies besides the standard library (see also trillian.go).
+ *
+ * Fields are encoded in the order of their field numbers and fields with default values are
+ * omitted, as protobuf libraries do, so encodings are deterministic (e.g., for signing tree heads).
+ * Decoding rejects unknown fields and hashes that are not 32 bytes.
+ */
+
+// Protobuf wire types
+const (
+    protoWireVarint = 0
+    protoWireBytes  = 2 // length-delimited fields (i.e., bytes and embedded messages)
+)
+
+/**
+ * A tree head signed by the server. This code does not sign tree heads itself: the signature is
+ * over TreeHead::MarshalProto() and its scheme is up to the application.
+ */
+type SignedTreeHead struct {
+    Head      TreeHead
+    Signature []byte
+}
+
+/**
+ * A request for the membership proof of a leaf as of an epoch, i.e., the protobuf counterpart of
+ * the HTTP server's /v1/leaf/ endpoint.
+ */
+type GetLeafRequest struct {
+    LeafNo    [32]byte
+    Epoch     int
+    Namespace string // the namespace's tree, for servers of a Forest (empty otherwise)
+}
+
+type GetLeafResponse struct {
+    Head   TreeHead
+    Exists bool
+    Proof  *MembershipProof
+}
+
+/**
+ * A request for the append-only proof between two epochs, i.e., the protobuf counterpart of the
+ * HTTP server's /v1/proof/append-only endpoint.
+ */
+type GetAppendOnlyProofRequest struct {
+    FromEpoch int
+    ToEpoch   int
+    Namespace string   // as in GetLeafRequest
+    FromRoot  [32]byte // if not empty, the proof is from the epoch with this root instead
+    ToRoot    [32]byte // likewise, for the epoch the proof is to
+}
+
+type GetAppendOnlyProofResponse struct {
+    OldHead TreeHead
+    NewHead TreeHead
+    Proof   *AppendOnlyProof
+}
+
+/**
+ * A request to insert key-value pairs in a new epoch, see GRPCServer.
+ */
+type InsertRequest struct {
+    Entries []KeyValue
+}
+
+type KeyValue struct {
+    Key   []byte
+    Value []byte
+}
+
+type InsertResponse struct {
+    Head     *SignedTreeHead // nil if every entry was rejected
+    Rejected []string        // why each entry was rejected, in order (empty for inserted ones)
+}
+
+/**
+ * A request for the membership proof of a key's leaf in the latest epoch, see GRPCServer.
+ */
+type GetRequest struct {
+    Key []byte
+}
+
+type GetResponse struct {
+    Head   SignedTreeHead
+    Exists bool
+    Proof  *MembershipProof
+}
+
+type GetRootRequest struct{}
+
+func (head TreeHead) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoUint(&buf, 1, uint64(head.Epoch))
+    _writeProtoHash(&buf, 2, head.Root)
+    return buf.Bytes(), nil
+}
+
+func (head *TreeHead) UnmarshalProto(data []byte) error {
+    *head = TreeHead{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.intTo(&head.Epoch)
+        case 2:
+            return value.hashTo(&head.Root)
+        }
+        return fmt.Errorf("unknown TreeHead field %d", fieldNo)
+    })
+}
+
+func (sth *SignedTreeHead) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoMessage(&buf, 1, sth.Head)
+    _writeProtoOptionalBytes(&buf, 2, sth.Signature)
+    return buf.Bytes(), nil
+}
+
+func (sth *SignedTreeHead) UnmarshalProto(data []byte) error {
+    *sth = SignedTreeHead{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.messageTo(&sth.Head)
+        case 2:
+            return value.bytesTo(&sth.Signature)
+        }
+        return fmt.Errorf("unknown SignedTreeHead field %d", fieldNo)
+    })
+}
+
+func (leaf LeafData) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoHash(&buf, 1, leaf.LeafNo)
+    _writeProtoHash(&buf, 2, leaf.DataHash)
+    return buf.Bytes(), nil
+}
+
+func (leaf *LeafData) UnmarshalProto(data []byte) error {
+    *leaf = LeafData{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.hashTo(&leaf.LeafNo)
+        case 2:
+            return value.hashTo(&leaf.DataHash)
+        }
+        return fmt.Errorf("unknown LeafEntry field %d", fieldNo)
+    })
+}
+
+func (opening *Opening) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoHash(&buf, 1, opening.Salt)
+    _writeProtoOptionalBytes(&buf, 2, opening.Value)
+    return buf.Bytes(), nil
+}
+
+func (opening *Opening) UnmarshalProto(data []byte) error {
+    *opening = Opening{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.hashTo(&opening.Salt)
+        case 2:
+            return value.bytesTo(&opening.Value)
+        }
+        return fmt.Errorf("unknown Opening field %d", fieldNo)
+    })
+}
+
+func (proof *MembershipProof) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoHash(&buf, 1, proof.LeafNo)
+    _writeProtoHash(&buf, 2, proof.LeafHash)
+    for _, sibling := range proof.Siblings {
+        _writeProtoRepeatedHash(&buf, 3, sibling)
+    }
+    if proof.Opening != nil {
+        _writeProtoMessage(&buf, 4, proof.Opening)
+    }
+    // Written even if empty, since an empty value is not the same as no value
+    if proof.Value != nil {
+        _writeProtoBytes(&buf, 5, proof.Value)
+    }
+    if proof.Extended != nil {
+        _writeProtoMessage(&buf, 6, proof.Extended)
+    }
+    return buf.Bytes(), nil
+}
+
+func (proof *MembershipProof) UnmarshalProto(data []byte) error {
+    *proof = MembershipProof{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.hashTo(&proof.LeafNo)
+        case 2:
+            return value.hashTo(&proof.LeafHash)
+        case 3:
+            var sibling [32]byte
+            if err := value.hashTo(&sibling); err != nil {
+                return err
+            }
+            proof.Siblings = append(proof.Siblings, sibling)
+            return nil
+        case 4:
+            proof.Opening = &Opening{}
+            return value.messageTo(proof.Opening)
+        case 5:
+            if err := value.bytesTo(&proof.Value); err != nil {
+                return err
+            }
+            if proof.Value == nil {
+                proof.Value = []byte{}
+            }
+            return nil
+        case 6:
+            proof.Extended = &ExtendedLeaf{}
+            return value.messageTo(proof.Extended)
+        }
+        return fmt.Errorf("unknown MembershipProof field %d", fieldNo)
+    })
+}
+
+func (leaf *ExtendedLeaf) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoHash(&buf, 1, leaf.DataHash)
+    _writeProtoUint(&buf, 2, leaf.Extensions.Timestamp)
+    _writeProtoUint(&buf, 3, leaf.Extensions.Expiry)
+    _writeProtoOptionalBytes(&buf, 4, leaf.Extensions.Policy)
+    // Written even if 0, since epoch 0 differs from no epoch
+    if leaf.Extensions.Epoch != nil {
+        _writeProtoKey(&buf, 5, protoWireVarint)
+        _writeProtoVarint(&buf, *leaf.Extensions.Epoch)
+    }
+    return buf.Bytes(), nil
+}
+
+func (leaf *ExtendedLeaf) UnmarshalProto(data []byte) error {
+    *leaf = ExtendedLeaf{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.hashTo(&leaf.DataHash)
+        case 2:
+            return value.uint64To(&leaf.Extensions.Timestamp)
+        case 3:
+            return value.uint64To(&leaf.Extensions.Expiry)
+        case 4:
+            return value.bytesTo(&leaf.Extensions.Policy)
+        case 5:
+            var epoch uint64
+            leaf.Extensions.Epoch = &epoch
+            return value.uint64To(leaf.Extensions.Epoch)
+        }
+        return fmt.Errorf("unknown ExtendedLeaf field %d", fieldNo)
+    })
+}
+
+func (pn *ProofNode) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoUint(&buf, 1, uint64(pn.Level))
+    _writeProtoHash(&buf, 2, pn.NodeNo)
+    _writeProtoHash(&buf, 3, pn.Hash)
+    if pn.IsNew {
+        _writeProtoUint(&buf, 4, 1)
+    }
+    for _, dataHash := range pn.Appended {
+        _writeProtoRepeatedHash(&buf, 5, dataHash)
+    }
+    return buf.Bytes(), nil
+}
+
+func (pn *ProofNode) UnmarshalProto(data []byte) error {
+    *pn = ProofNode{}
+    err := _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.intTo(&pn.Level)
+        case 2:
+            return value.hashTo(&pn.NodeNo)
+        case 3:
+            return value.hashTo(&pn.Hash)
+        case 4:
+            return value.boolTo(&pn.IsNew)
+        case 5:
+            var dataHash [32]byte
+            if err := value.hashTo(&dataHash); err != nil {
+                return err
+            }
+            pn.Appended = append(pn.Appended, dataHash)
+            return nil
+        }
+        return fmt.Errorf("unknown ProofNode field %d", fieldNo)
+    })
+    if err != nil {
+        return err
+    }
+
+    if pn.Level > 256 {
+        return fmt.Errorf("proof node has invalid level %d", pn.Level)
+    }
+    if !_fitsInLevel(pn.NodeNo, pn.Level) {
+        return fmt.Errorf("proof node %s has an LN that is too large for level %d", hashStr(pn.NodeNo), pn.Level)
+    }
+    return nil
+}
+
+func (proof *AppendOnlyProof) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    for i := range proof.Nodes {
+        _writeProtoMessage(&buf, 1, &proof.Nodes[i])
+    }
+    return buf.Bytes(), nil
+}
+
+func (proof *AppendOnlyProof) UnmarshalProto(data []byte) error {
+    *proof = AppendOnlyProof{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        if fieldNo != 1 {
+            return fmt.Errorf("unknown AppendOnlyProof field %d", fieldNo)
+        }
+        var pn ProofNode
+        if err := value.messageTo(&pn); err != nil {
+            return err
+        }
+        proof.Nodes = append(proof.Nodes, pn)
+        return nil
+    })
+}
+
+func (req *GetLeafRequest) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoHash(&buf, 1, req.LeafNo)
+    _writeProtoUint(&buf, 2, uint64(req.Epoch))
+    _writeProtoOptionalBytes(&buf, 3, []byte(req.Namespace))
+    return buf.Bytes(), nil
+}
+
+func (req *GetLeafRequest) UnmarshalProto(data []byte) error {
+    *req = GetLeafRequest{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.hashTo(&req.LeafNo)
+        case 2:
+            return value.intTo(&req.Epoch)
+        case 3:
+            return value.stringTo(&req.Namespace)
+        }
+        return fmt.Errorf("unknown GetLeafRequest field %d", fieldNo)
+    })
+}
+
+func (resp *GetLeafResponse) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoMessage(&buf, 1, resp.Head)
+    if resp.Exists {
+        _writeProtoUint(&buf, 2, 1)
+    }
+    if resp.Proof != nil {
+        _writeProtoMessage(&buf, 3, resp.Proof)
+    }
+    return buf.Bytes(), nil
+}
+
+func (resp *GetLeafResponse) UnmarshalProto(data []byte) error {
+    *resp = GetLeafResponse{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.messageTo(&resp.Head)
+        case 2:
+            return value.boolTo(&resp.Exists)
+        case 3:
+            resp.Proof = &MembershipProof{}
+            return value.messageTo(resp.Proof)
+        }
+        return fmt.Errorf("unknown GetLeafResponse field %d", fieldNo)
+    })
+}
+
+func (req *GetAppendOnlyProofRequest) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoUint(&buf, 1, uint64(req.FromEpoch))
+    _writeProtoUint(&buf, 2, uint64(req.ToEpoch))
+    _writeProtoOptionalBytes(&buf, 3, []byte(req.Namespace))
+    _writeProtoHash(&buf, 4, req.FromRoot)
+    _writeProtoHash(&buf, 5, req.ToRoot)
+    return buf.Bytes(), nil
+}
+
+func (req *GetAppendOnlyProofRequest) UnmarshalProto(data []byte) error {
+    *req = GetAppendOnlyProofRequest{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.intTo(&req.FromEpoch)
+        case 2:
+            return value.intTo(&req.ToEpoch)
+        case 3:
+            return value.stringTo(&req.Namespace)
+        case 4:
+            return value.hashTo(&req.FromRoot)
+        case 5:
+            return value.hashTo(&req.ToRoot)
+        }
+        return fmt.Errorf("unknown GetAppendOnlyProofRequest field %d", fieldNo)
+    })
+}
+
+func (resp *GetAppendOnlyProofResponse) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoMessage(&buf, 1, resp.OldHead)
+    _writeProtoMessage(&buf, 2, resp.NewHead)
+    if resp.Proof != nil {
+        _writeProtoMessage(&buf, 3, resp.Proof)
+    }
+    return buf.Bytes(), nil
+}
+
+func (resp *GetAppendOnlyProofResponse) UnmarshalProto(data []byte) error {
+    *resp = GetAppendOnlyProofResponse{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.messageTo(&resp.OldHead)
+        case 2:
+            return value.messageTo(&resp.NewHead)
+        case 3:
+            resp.Proof = &AppendOnlyProof{}
+            return value.messageTo(resp.Proof)
+        }
+        return fmt.Errorf("unknown GetAppendOnlyProofResponse field %d", fieldNo)
+    })
+}
+
+func (kv *KeyValue) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoOptionalBytes(&buf, 1, kv.Key)
+    _writeProtoOptionalBytes(&buf, 2, kv.Value)
+    return buf.Bytes(), nil
+}
+
+func (kv *KeyValue) UnmarshalProto(data []byte) error {
+    *kv = KeyValue{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.bytesTo(&kv.Key)
+        case 2:
+            return value.bytesTo(&kv.Value)
+        }
+        return fmt.Errorf("unknown InsertRequest.Entry field %d", fieldNo)
+    })
+}
+
+func (req *InsertRequest) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    for i := range req.Entries {
+        _writeProtoMessage(&buf, 1, &req.Entries[i])
+    }
+    return buf.Bytes(), nil
+}
+
+func (req *InsertRequest) UnmarshalProto(data []byte) error {
+    *req = InsertRequest{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        if fieldNo != 1 {
+            return fmt.Errorf("unknown InsertRequest field %d", fieldNo)
+        }
+        var kv KeyValue
+        if err := value.messageTo(&kv); err != nil {
+            return err
+        }
+        req.Entries = append(req.Entries, kv)
+        return nil
+    })
+}
+
+func (resp *InsertResponse) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    if resp.Head != nil {
+        _writeProtoMessage(&buf, 1, resp.Head)
+    }
+    // Repeated fields are written even if empty, so that the reasons stay aligned with the entries
+    for _, reason := range resp.Rejected {
+        _writeProtoBytes(&buf, 2, []byte(reason))
+    }
+    return buf.Bytes(), nil
+}
+
+func (resp *InsertResponse) UnmarshalProto(data []byte) error {
+    *resp = InsertResponse{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            resp.Head = &SignedTreeHead{}
+            return value.messageTo(resp.Head)
+        case 2:
+            var reason string
+            if err := value.stringTo(&reason); err != nil {
+                return err
+            }
+            resp.Rejected = append(resp.Rejected, reason)
+            return nil
+        }
+        return fmt.Errorf("unknown InsertResponse field %d", fieldNo)
+    })
+}
+
+func (req *GetRequest) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoOptionalBytes(&buf, 1, req.Key)
+    return buf.Bytes(), nil
+}
+
+func (req *GetRequest) UnmarshalProto(data []byte) error {
+    *req = GetRequest{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        if fieldNo != 1 {
+            return fmt.Errorf("unknown GetRequest field %d", fieldNo)
+        }
+        return value.bytesTo(&req.Key)
+    })
+}
+
+func (resp *GetResponse) MarshalProto() ([]byte, error) {
+    var buf bytes.Buffer
+    _writeProtoMessage(&buf, 1, &resp.Head)
+    if resp.Exists {
+        _writeProtoUint(&buf, 2, 1)
+    }
+    if resp.Proof != nil {
+        _writeProtoMessage(&buf, 3, resp.Proof)
+    }
+    return buf.Bytes(), nil
+}
+
+func (resp *GetResponse) UnmarshalProto(data []byte) error {
+    *resp = GetResponse{}
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        switch fieldNo {
+        case 1:
+            return value.messageTo(&resp.Head)
+        case 2:
+            return value.boolTo(&resp.Exists)
+        case 3:
+            resp.Proof = &MembershipProof{}
+            return value.messageTo(resp.Proof)
+        }
+        return fmt.Errorf("unknown GetResponse field %d", fieldNo)
+    })
+}
+
+func (req *GetRootRequest) MarshalProto() ([]byte, error) {
+    return nil, nil
+}
+
+func (req *GetRootRequest) UnmarshalProto(data []byte) error {
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        return fmt.Errorf("unknown GetRootRequest field %d", fieldNo)
+    })
+}
+
+/**
+ * A message that can be embedded in another one.
+ */
+type protoMarshaler interface {
+    MarshalProto() ([]byte, error)
+}
+
+type protoUnmarshaler interface {
+    UnmarshalProto(data []byte) error
+}
+
+func _writeProtoKey(buf *bytes.Buffer, fieldNo int, wireType int) {
+    _writeProtoVarint(buf, uint64(fieldNo<<3|wireType))
+}
+
+func _writeProtoVarint(buf *bytes.Buffer, v uint64) {
+    var varint [binary.MaxVarintLen64]byte
+    buf.Write(varint[:binary.PutUvarint(varint[:], v)])
+}
+
+/**
+ * Writes an integer field, unless it is 0 (i.e., the default value).
+ */
+func _writeProtoUint(buf *bytes.Buffer, fieldNo int, v uint64) {
+    if v != 0 {
+        _writeProtoKey(buf, fieldNo, protoWireVarint)
+        _writeProtoVarint(buf, v)
+    }
+}
+
+func _writeProtoBytes(buf *bytes.Buffer, fieldNo int, data []byte) {
+    _writeProtoKey(buf, fieldNo, protoWireBytes)
+    _writeProtoVarint(buf, uint64(len(data)))
+    buf.Write(data)
+}
+
+/**
+ * Writes a bytes field, unless it is empty (i.e., the default value).
+ */
+func _writeProtoOptionalBytes(buf *bytes.Buffer, fieldNo int, data []byte) {
+    if len(data) > 0 {
+        _writeProtoBytes(buf, fieldNo, data)
+    }
+}
+
+/**
+ * Writes a hash field, unless it is the empty hash, which is the default value.
+ */
+func _writeProtoHash(buf *bytes.Buffer, fieldNo int, hash [32]byte) {
+    if hash != ([32]byte{}) {
+        _writeProtoBytes(buf, fieldNo, hash[:])
+    }
+}
+
+/**
+ * Writes an element of a repeated hash field, where empty hashes are empty byte strings.
+ */
+func _writeProtoRepeatedHash(buf *bytes.Buffer, fieldNo int, hash [32]byte) {
+    if hash == ([32]byte{}) {
+        _writeProtoBytes(buf, fieldNo, nil)
+    } else {
+        _writeProtoBytes(buf, fieldNo, hash[:])
+    }
+}
+
+func _writeProtoMessage(buf *bytes.Buffer, fieldNo int, msg protoMarshaler) {
+    // None of our messages fail to encode
+    data, _ := msg.MarshalProto()
+    _writeProtoBytes(buf, fieldNo, data)
+}
+
+/**
+ * The value of a decoded field: either a varint or a length-delimited byte string.
+ */
+type protoValue struct {
+    wireType int
+    varint   uint64
+    bytes    []byte
+}
+
+func (value protoValue) intTo(dst *int) error {
+    if value.wireType != protoWireVarint {
+        return fmt.Errorf("expected an integer, got wire type %d", value.wireType)
+    }
+    if value.varint > math.MaxInt32 {
+        return fmt.Errorf("integer %d is too large", value.varint)
+    }
+    *dst = int(value.varint)
+    return nil
+}
+
+func (value protoValue) uint64To(dst *uint64) error {
+    if value.wireType != protoWireVarint {
+        return fmt.Errorf("expected an integer, got wire type %d", value.wireType)
+    }
+    *dst = value.varint
+    return nil
+}
+
+func (value protoValue) boolTo(dst *bool) error {
+    if value.wireType != protoWireVarint {
+        return fmt.Errorf("expected a bool, got wire type %d", value.wireType)
+    }
+    *dst = value.varint != 0
+    return nil
+}
+
+func (value protoValue) bytesTo(dst *[]byte) error {
+    if value.wireType != protoWireBytes {
+        return fmt.Errorf("expected bytes, got wire type %d", value.wireType)
+    }
+    *dst = append([]byte(nil), value.bytes...)
+    return nil
+}
+
+func (value protoValue) stringTo(dst *string) error {
+    if value.wireType != protoWireBytes {
+        return fmt.Errorf("expected a string, got wire type %d", value.wireType)
+    }
+    *dst = string(value.bytes)
+    return nil
+}
+
+func (value protoValue) hashTo(dst *[32]byte) error {
+    if value.wireType != protoWireBytes {
+        return fmt.Errorf("expected a hash, got wire type %d", value.wireType)
+    }
+    return _copyProtoHash(dst[:], value.bytes, true)
+}
+
+func (value protoValue) messageTo(msg protoUnmarshaler) error {
+    if value.wireType != protoWireBytes {
+        return fmt.Errorf("expected a message, got wire type %d", value.wireType)
+    }
+    return msg.UnmarshalProto(value.bytes)
+}
+
+/**
+ * Calls 'fieldFunc' on every field of a protobuf message. Only varint and length-delimited fields
+ * are supported, since our messages have no others.
+ */
+func _readProto(data []byte, fieldFunc func(int, protoValue) error) error {
+    for len(data) > 0 {
+        key, n := binary.Uvarint(data)
+        if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+            return errors.New("invalid protobuf field key")
+        }
+        data = data[n:]
+
+        value := protoValue{wireType: int(key & 7)}
+        switch value.wireType {
+        case protoWireVarint:
+            value.varint, n = binary.Uvarint(data)
+            if n <= 0 {
+                return errors.New("invalid or truncated protobuf varint")
+            }
+            data = data[n:]
+
+        case protoWireBytes:
+            length, n := binary.Uvarint(data)
+            if n <= 0 || length > uint64(len(data)-n) {
+                return errors.New("invalid or truncated protobuf field length")
+            }
+            data = data[n:]
+            value.bytes = data[:length]
+            data = data[length:]
+
+        default:
+            return fmt.Errorf("unexpected protobuf wire type %d", value.wireType)
+        }
+
+        if err := fieldFunc(int(key>>3), value); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+/**
+ * Calls 'fieldFunc' on every field of a protobuf message made up of length-delimited fields.
+ */
+func _readProtoFields(data []byte, fieldFunc func(int, []byte) error) error {
+    return _readProto(data, func(fieldNo int, value protoValue) error {
+        if value.wireType != protoWireBytes {
+            return fmt.Errorf("unexpected protobuf wire type %d", value.wireType)
+        }
+        return fieldFunc(fieldNo, value.bytes)
+    })
+}
+
+/**
+ * Copies a 32-byte hash from a decoded field, which can be empty (i.e., the empty hash) if
+ * 'canBeEmpty' is true.
+ */
+func _copyProtoHash(dst []byte, src []byte, canBeEmpty bool) error {
+    if len(src) == 0 && canBeEmpty {
+        return nil
+    }
+    if len(src) != 32 {
+        return fmt.Errorf("expected 32 bytes, got %d", len(src))
+    }
+    copy(dst, src)
+    return nil
+}
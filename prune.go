@@ -0,0 +1,198 @@
+package aomt
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "sort"
+)
+
+/**
+ * Servers that only answer proofs about recent epochs do not need the interior nodes of subtrees
+ * that did not change recently: only the subtrees' roots (i.e., the frontier nodes) are ever
+ * included in append-only proofs about later epochs. Tree::Prune() drops the interior nodes below
+ * these frontier nodes and marks the frontier nodes as pruned. The leaves are kept, so a pruned
+ * subtree can be rebuilt from its leaves when an operation needs to descend into it (e.g., an
+ * insert, an update or a membership proof of one of its leaves).
+ *
+ * NOTE: Versioned trees cannot be pruned, since TreeVersion looks up the nodes of past epochs.
+ */
+
+/**
+ * Drops the interior nodes that did not change after epoch 'epoch', except for the roots of the
+ * unchanged subtrees (which are needed for append-only proofs from 'epoch' onward). Returns the #
+ * of dropped nodes.
+ */
+func (tree *Tree) Prune(epoch int) (int64, error) {
+    if tree.versions != nil {
+        return 0, errors.New("cannot prune a versioned tree")
+    }
+    if tree.open != nil {
+        return 0, errors.New("cannot prune a tree with an open epoch")
+    }
+    if epoch < 0 || epoch >= len(tree.epochs) {
+        return 0, fmt.Errorf("epoch %d does not exist, there are %d epochs", epoch, len(tree.epochs))
+    }
+
+    // The ancestors of the leaves changed after 'epoch' must stay, so rebuild them if a previous
+    // call pruned them
+    lastLevel := tree.numLevels - 1
+    recent := make([]map[[32]byte]bool, lastLevel)
+    for level := range recent {
+        recent[level] = make(map[[32]byte]bool)
+    }
+    markRecent := func(changes []epochLeaf) {
+        for _, change := range changes {
+            tree._restore(lastLevel, change.leafNo)
+
+            nodeNo := change.leafNo
+            for level := lastLevel - 1; level >= 0; level-- {
+                nodeNo = parentOf(nodeNo)
+                if recent[level][nodeNo] {
+                    break
+                }
+                recent[level][nodeNo] = true
+            }
+        }
+    }
+    for _, record := range tree.epochs[epoch+1:] {
+        markRecent(record.changes)
+    }
+    markRecent(tree.pending)
+
+    // Every node that is not recent is either the child of a recent node (i.e., the root of an
+    // unchanged subtree) or in the subtree of such a node. If nothing changed, the root is the
+    // only frontier node.
+    var numDropped int64
+    for level := 0; level < lastLevel; level++ {
+        lvlNodes := tree.lvl[level].node
+        for nodeNo, node := range lvlNodes {
+            isFrontier := level == 0 || recent[level-1][parentOf(nodeNo)]
+            if recent[level][nodeNo] {
+                continue
+            }
+
+            if !isFrontier {
+                if node.Pruned {
+                    tree.numPruned--
+                }
+                delete(lvlNodes, nodeNo)
+                numDropped++
+                continue
+            }
+
+            // The children of level lastLevel - 1 nodes are leaves, so there's nothing to drop
+            if !node.Pruned && level < lastLevel-1 {
+                node.Pruned = true
+                tree.numPruned++
+            }
+        }
+    }
+
+    // The caches could still point to the dropped nodes
+    for _, lvl := range tree.lvl {
+        if lvl.cache != nil {
+            lvl.cache = make([]*Node, len(lvl.cache))
+        }
+    }
+
+    tree._indexPrunedLeaves()
+    return numDropped, nil
+}
+
+/**
+ * Returns the # of pruned subtrees, i.e., subtrees whose interior nodes must be rebuilt before use.
+ */
+func (tree *Tree) NumPrunedSubtrees() int {
+    return tree.numPruned
+}
+
+/**
+ * Sorts the leaf nos of the tree, so that the leaves of a pruned subtree can be found quickly.
+ * Leaves inserted later are never in a pruned subtree, since inserting them rebuilds the subtree.
+ */
+func (tree *Tree) _indexPrunedLeaves() {
+    tree.prunedLeaves = nil
+    if tree.numPruned == 0 {
+        return
+    }
+
+    leafNodes := tree.lvl[tree.numLevels-1].node
+    tree.prunedLeaves = make([][32]byte, 0, len(leafNodes))
+    for leafNo := range leafNodes {
+        tree.prunedLeaves = append(tree.prunedLeaves, leafNo)
+    }
+    _sortHashes(tree.prunedLeaves)
+}
+
+/**
+ * Makes sure that the path from the root to the node with LN 'nodeNo' on level 'level' is not in
+ * a pruned subtree, rebuilding the subtree if it is. Does nothing for trees that were never pruned.
+ */
+func (tree *Tree) _restore(level int, nodeNo [32]byte) {
+    if tree.numPruned == 0 {
+        return
+    }
+
+    for l := 0; l <= level && l < tree.numLevels-1; l++ {
+        ancestorNo := rshBytes(nodeNo, uint(level-l))
+        ancestor := tree.lvl[l].node[ancestorNo]
+        if ancestor == nil {
+            // The node is in an empty subtree
+            return
+        }
+        if ancestor.Pruned {
+            tree._rebuildSubtree(l, ancestorNo, ancestor)
+            return
+        }
+    }
+}
+
+/**
+ * Recomputes the interior nodes of the pruned subtree rooted at 'root' from its leaves.
+ */
+func (tree *Tree) _rebuildSubtree(level int, rootNo [32]byte, root *Node) {
+    lastLevel := tree.numLevels - 1
+
+    // The subtree's leaves are the ones whose leaf no starts with 'rootNo'
+    lo := lshBytes(rootNo, uint(lastLevel-level))
+    first := sort.Search(len(tree.prunedLeaves), func(i int) bool {
+        return bytes.Compare(tree.prunedLeaves[i][:], lo[:]) >= 0
+    })
+    last := first
+    for last < len(tree.prunedLeaves) && rshBytes(tree.prunedLeaves[last], uint(lastLevel-level)) == rootNo {
+        last++
+    }
+
+    if first == last {
+        panic(fmt.Sprintf("Something's off: pruned subtree %s on level %d has no leaves", hashStr(rootNo), level))
+    }
+
+    nodes := tree.prunedLeaves[first:last]
+    for l := lastLevel - 1; l >= level; l-- {
+        childLvl := tree.lvl[l+1]
+        parents := _distinctParents(nodes)
+        for _, parentNo := range parents {
+            leftNo, rightNo := childrenOf(parentNo)
+            leftHash := tree.EmptyHash
+            if left := childLvl.node[leftNo]; left != nil {
+                leftHash = left.Hash
+            }
+            parentHash := tree._computeHash(l, parentNo, leftHash, childLvl.node[rightNo], true)
+
+            if l == level {
+                if parentHash != root.Hash {
+                    panic(fmt.Sprintf("Something's off: rebuilt pruned subtree %s on level %d has a different hash", hashStr(rootNo), level))
+                }
+                continue
+            }
+            parent := tree.lvl[l].newNode()
+            parent.Hash = parentHash
+            tree.lvl[l].node[parentNo] = parent
+        }
+        nodes = parents
+    }
+
+    root.Pruned = false
+    tree.numPruned--
+}
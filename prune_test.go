@@ -0,0 +1,65 @@
+package aomt
+
+import (
+    "reflect"
+    "testing"
+)
+
+/**
+ * A pruned tree must drop nodes, yet prove the same as the tree it was pruned from, and keep
+ * matching it after further inserts and updates into pruned subtrees.
+ */
+func TestPruneKeepsProofs(t *testing.T) {
+    tree := _testEpochTree(5)
+    reference := _testEpochTree(5)
+    numNodes := tree.GetNumNodes()
+
+    numDropped, err := tree.Prune(2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if numDropped == 0 || tree.GetNumNodes() >= numNodes {
+        t.Errorf("pruning dropped %d nodes, leaving %d of %d", numDropped, tree.GetNumNodes(), numNodes)
+    }
+    if tree.GetRootHash() != reference.GetRootHash() {
+        t.Fatalf("pruned tree has root %s, expected %s", hashStr(tree.GetRootHash()), hashStr(reference.GetRootHash()))
+    }
+    for i := 0; i < 110; i += 3 {
+        leafNo := _testLeaf(i).LeafNo
+        if !reflect.DeepEqual(tree.ProveMembership(leafNo), reference.ProveMembership(leafNo)) {
+            t.Errorf("pruned tree proves leaf %d unlike the unpruned one", i)
+        }
+    }
+    last := tree.NumEpochs() - 1
+    for from := 2; from < last; from++ {
+        proof := tree.ProveAppendOnly(from, last)
+        if !VerifyAppendOnlyProof(proof, tree.GetEpochRoot(from), tree.GetEpochRoot(last)) {
+            t.Errorf("append-only proof from epoch %d of the pruned tree does not verify", from)
+        }
+    }
+
+    // Updates of old leaves descend into pruned subtrees
+    for _, tr := range []*Tree{tree, reference} {
+        leaf := _testLeaf(200)
+        tr.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        tr.Update(_testLeaf(1).LeafNo, leaf.DataHash, nil)
+        tr.RecordEpoch()
+    }
+    if tree.GetRootHash() != reference.GetRootHash() {
+        t.Errorf("pruned tree has root %s after more changes, expected %s", hashStr(tree.GetRootHash()), hashStr(reference.GetRootHash()))
+    }
+    if _, err := tree.Prune(tree.NumEpochs()); err == nil {
+        t.Errorf("pruning a missing epoch succeeds")
+    }
+
+    versioned, err := NewTreeWithOptions(257, TreeOptions{Versioned: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    leaf := _testLeaf(0)
+    versioned.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    versioned.RecordEpoch()
+    if _, err := versioned.Prune(0); err == nil {
+        t.Errorf("pruning a versioned tree succeeds")
+    }
+}
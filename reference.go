@@ -0,0 +1,134 @@
+package aomt
+
+import (
+    "crypto/hmac"
+    "bytes"
+    "fmt"
+    "hash"
+    "sort"
+)
+
+/**
+ * A deliberately simple and slow sparse Merkle tree, for differential testing of Tree (see the
+ * bench's -paranoid flag): it only keeps its leaves in a map and recomputes the root hash from
+ * scratch every time, hashing via the hash function directly rather than via the tree's (batched,
+ * cached or compressed) code paths. Only inserts are supported, since the benchmarks only insert.
+ */
+type referenceTree struct {
+    numLevels int
+    newHash   func() hash.Hash
+    rfc6962   bool
+    scheme    HashingScheme
+    hmacKey   []byte // the key leaf hashes are keyed with, if any
+    leaves    map[[32]byte][32]byte // leaf no -> data hash
+}
+
+/**
+ * Returns a reference tree with the same parameters and leaves as 'tree'.
+ */
+func newReferenceTree(tree *Tree) *referenceTree {
+    ref := &referenceTree{
+        numLevels: tree.numLevels,
+        newHash:   tree.newHash,
+        rfc6962:   tree.rfc6962,
+        scheme:    tree.scheme,
+        hmacKey:   tree.Options()._leafHashKey(),
+        leaves:    make(map[[32]byte][32]byte),
+    }
+    for it := tree.Leaves(); it.Next(); {
+        ref.insert(it.Leaf())
+    }
+    return ref
+}
+
+func (ref *referenceTree) insert(leafNo [32]byte, dataHash [32]byte) {
+    if _, ok := ref.leaves[leafNo]; ok {
+        panic(fmt.Sprintf("Already set leaf '%s' in reference tree", hashStr(leafNo)))
+    }
+    ref.leaves[leafNo] = dataHash
+}
+
+/**
+ * Recomputes the root hash from all the leaves.
+ */
+func (ref *referenceTree) rootHash() [32]byte {
+    leafNos := make([][32]byte, 0, len(ref.leaves))
+    for leafNo := range ref.leaves {
+        leafNos = append(leafNos, leafNo)
+    }
+    sort.Slice(leafNos, func(i, j int) bool {
+        return bytes.Compare(leafNos[i][:], leafNos[j][:]) < 0
+    })
+    return ref._subtreeHash(0, leafNos)
+}
+
+/**
+ * Returns the hash of the node on level 'level' whose subtree has the specified (sorted) leaves.
+ */
+func (ref *referenceTree) _subtreeHash(level int, leafNos [][32]byte) [32]byte {
+    if len(leafNos) == 0 {
+        return [32]byte{}
+    }
+    if level == ref.numLevels-1 {
+        dataHash := ref.leaves[leafNos[0]]
+        if ref.hmacKey != nil {
+            mac := hmac.New(ref.newHash, ref.hmacKey)
+            if ref.rfc6962 {
+                mac.Write([]byte{0x00})
+            }
+            mac.Write(dataHash[:])
+
+            var leafHash [32]byte
+            copy(leafHash[:], mac.Sum(nil))
+            return leafHash
+        }
+        if !ref.rfc6962 {
+            return dataHash
+        }
+        return ref._hash([]byte{0x00}, dataHash[:])
+    }
+
+    // The leaves in the left subtree have a 0 as the bit below this level, so they come first
+    bit := uint(ref.numLevels - 2 - level)
+    split := sort.Search(len(leafNos), func(i int) bool {
+        return bitOf(leafNos[i], bit) == 1
+    })
+    left := ref._subtreeHash(level+1, leafNos[:split])
+    right := ref._subtreeHash(level+1, leafNos[split:])
+
+    var prefix []byte
+    if ref.rfc6962 {
+        prefix = append(prefix, 0x01)
+    }
+    if ref.scheme != HashingPlain {
+        prefix = append(prefix, byte(level>>8), byte(level))
+    }
+    if ref.scheme == HashingLevelLN {
+        // This node's LN is the first bits of the LNs of the leaves in its subtree
+        nodeNo := rshBytes(leafNos[0], uint(ref.numLevels-1-level))
+        prefix = append(prefix, nodeNo[:]...)
+    }
+    return ref._hash(prefix, left[:], right[:])
+}
+
+func (ref *referenceTree) _hash(parts ...[]byte) [32]byte {
+    h := ref.newHash()
+    for _, part := range parts {
+        h.Write(part)
+    }
+    var digest [32]byte
+    copy(digest[:], h.Sum(nil))
+    return digest
+}
+
+/**
+ * Checks that the root hash of the tree's specified epoch is the reference tree's, when the
+ * reference tree has the leaves of that epoch. Returns the reference tree's root hash.
+ */
+func (ref *referenceTree) check(tree *Tree, epoch int) ([32]byte, error) {
+    refRoot := ref.rootHash()
+    if root := tree.GetEpochRoot(epoch); root != refRoot {
+        return refRoot, fmt.Errorf("the root hash of epoch %d is %s, but the reference tree's is %s", epoch, hashStr(root), hashStr(refRoot))
+    }
+    return refRoot, nil
+}
@@ -0,0 +1,140 @@
+package aomt
+
+import (
+    "fmt"
+    "sort"
+    "time"
+)
+
+/**
+ * In an append-only dictionary, a leaf is never bound to another value by an insert, so an insert
+ * of an existing leaf is either a client bug or a client trying to overwrite someone else's
+ * binding. Insert() panics on such inserts, since the benchmarks never make them, while TryInsert()
+ * and Epoch::Insert() reject them, as well as inserts of leaf nos that do not fit in the tree and
+ * inserts without extensions in trees whose leaves must have them (and Epoch::Update() rejects
+ * updates of leaves that are not in the tree), and record them in the tree's rejection log, so that
+ * operators can find out who tried what (see Tree::Rejections() and the server's /v1/rejections
+ * endpoint).
+ *
+ * NOTE: The log is only kept in memory, and only its last maxRejections entries, so a client
+ * cannot exhaust the server's memory by making bad inserts.
+ */
+
+// Why an operation was rejected
+const (
+    RejectDuplicateLeaf     = "duplicate-leaf"     // the leaf is already in the tree
+    RejectMalformedLeafNo   = "malformed-leaf-no"  // the leaf no has more bits than the tree's leaves
+    RejectMissingLeaf       = "missing-leaf"       // the updated leaf is not in the tree
+    RejectMissingExtensions = "missing-extensions" // the tree's leaves must be inserted with extensions
+)
+
+// The most rejections a tree remembers, after which the oldest ones are dropped
+const maxRejections = 10000
+
+/**
+ * A rejected operation on the tree.
+ */
+type Rejection struct {
+    Time     time.Time
+    Op       string // the rejected operation, e.g., "insert"
+    LeafNo   [32]byte
+    DataHash [32]byte
+    Kind     string // see RejectDuplicateLeaf and friends
+    Reason   string // a human-readable explanation
+}
+
+/**
+ * Inserts the specified leaf like Insert(), but returns an error rather than panicking if the leaf
+ * is already in the tree or if its leaf no does not fit in the tree, and records the rejection.
+ */
+func (tree *Tree) TryInsert(leafNo [32]byte, dataHash [32]byte, proofTree *Tree) error {
+    if err := tree._checkExtensionsNotRequired("insert", leafNo, dataHash); err != nil {
+        return err
+    }
+    if err := tree._checkInsertable("insert", leafNo, dataHash); err != nil {
+        return err
+    }
+    tree.Insert(leafNo, dataHash, proofTree)
+    return nil
+}
+
+/**
+ * Returns an error if the leaf cannot be inserted, after recording the rejected operation 'op'.
+ */
+func (tree *Tree) _checkInsertable(op string, leafNo [32]byte, dataHash [32]byte) error {
+    if !_fitsInLevel(leafNo, tree.numLevels-1) {
+        return tree._reject(op, leafNo, dataHash, RejectMalformedLeafNo,
+            fmt.Sprintf("leaf no %s is too large for a tree with %d levels", hashStr(leafNo), tree.numLevels))
+    }
+    if _, ok := tree.lvl[tree.numLevels-1].node[leafNo]; ok {
+        return tree._reject(op, leafNo, dataHash, RejectDuplicateLeaf,
+            fmt.Sprintf("leaf %s is already in the tree", hashStr(leafNo)))
+    }
+    return nil
+}
+
+/**
+ * Returns an error if the tree was created with TreeOptions::LeafExtensions, whose leaves must be
+ * inserted via InsertWithExtensions(), after recording the rejected operation 'op'.
+ */
+func (tree *Tree) _checkExtensionsNotRequired(op string, leafNo [32]byte, dataHash [32]byte) error {
+    if tree.leafExtensions {
+        return tree._reject(op, leafNo, dataHash, RejectMissingExtensions,
+            fmt.Sprintf("leaf %s has no extensions, which the tree requires", hashStr(leafNo)))
+    }
+    return nil
+}
+
+/**
+ * Returns an error if the leaf cannot be updated because it is not in the tree, after recording
+ * the rejected operation 'op'.
+ */
+func (tree *Tree) _checkUpdatable(op string, leafNo [32]byte, dataHash [32]byte) error {
+    if !_fitsInLevel(leafNo, tree.numLevels-1) {
+        return tree._reject(op, leafNo, dataHash, RejectMalformedLeafNo,
+            fmt.Sprintf("leaf no %s is too large for a tree with %d levels", hashStr(leafNo), tree.numLevels))
+    }
+    tree._restore(tree.numLevels-1, leafNo)
+    if _, ok := tree.lvl[tree.numLevels-1].node[leafNo]; !ok {
+        return tree._reject(op, leafNo, dataHash, RejectMissingLeaf,
+            fmt.Sprintf("leaf %s was never inserted", hashStr(leafNo)))
+    }
+    return nil
+}
+
+/**
+ * Records a rejected operation and returns the error to return to its caller.
+ */
+func (tree *Tree) _reject(op string, leafNo [32]byte, dataHash [32]byte, kind string, reason string) error {
+    tree.numRejections++
+    if len(tree.rejections) == maxRejections {
+        copy(tree.rejections, tree.rejections[1:])
+        tree.rejections = tree.rejections[:maxRejections-1]
+    }
+    tree.rejections = append(tree.rejections, Rejection{
+        Time:     time.Now(),
+        Op:       op,
+        LeafNo:   leafNo,
+        DataHash: dataHash,
+        Kind:     kind,
+        Reason:   reason,
+    })
+    return fmt.Errorf("%s rejected: %s", op, reason)
+}
+
+/**
+ * Returns the recorded rejections made after 'since', oldest first.
+ */
+func (tree *Tree) Rejections(since time.Time) []Rejection {
+    i := sort.Search(len(tree.rejections), func(i int) bool {
+        return tree.rejections[i].Time.After(since)
+    })
+    return append([]Rejection(nil), tree.rejections[i:]...)
+}
+
+/**
+ * Returns the # of rejections the tree recorded, including the ones that were dropped.
+ */
+func (tree *Tree) NumRejections() int64 {
+    return tree.numRejections
+}
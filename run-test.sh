@@ -14,5 +14,5 @@ for i in `seq 1 10`; do
     echo
     echo "Running with seed $i..."
     echo
-    ./hashperiments "$i" "$filePrefix-seed-$i.csv" $@ 2>&1 | tee $filePrefix-seed-${i}.log
+    ./amt bench "$i" "$filePrefix-seed-$i.csv" $@ 2>&1 | tee $filePrefix-seed-${i}.log
 done
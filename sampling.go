@@ -0,0 +1,207 @@
+package aomt
+
+import (
+    "crypto/rand"
+    "math"
+    "math/big"
+)
+
+/**
+ * An append-only proof annotated with the old and the new hash of every internal node of the
+ * proof tree (i.e., the ancestors of the nodes in the proof tree, which VerifyAppendOnlyProof()
+ * recomputes).
+ *
+ * The annotations let resource-poor auditors spot-check a proof via
+ * VerifyAppendOnlyProofSampled(), which only checks the paths from a few randomly sampled new
+ * nodes (i.e., roots of new subtrees and updated leaves) to the root, instead of hashing the
+ * whole proof tree.
+ *
+ * SECURITY: A sampled check is NOT a proof that the tree is append-only. A prover who tampers
+ * with the proof must break the hash relation at some internal node, and we only catch it if
+ * we sample a new node whose path goes through that internal node or through its parent. If a
+ * fraction f of the new nodes have such a path, k samples catch it with probability
+ * 1 - (1 - f)^k (see SampledDetectionProbability()). A prover who tampers with a single path
+ * out of n therefore gets away with probability about (1 - 1/n)^k, so auditors who need
+ * certainty must call VerifyAppendOnlyProof() instead. Also, samples must be unpredictable to
+ * the prover, which is why we draw them via crypto/rand after receiving the proof.
+ */
+type AnnotatedProof struct {
+    ProofTree *Tree
+    Inner     []map[[32]byte]ProofHashes // Inner[level][LN] are the hashes of an internal node
+}
+
+type ProofHashes struct {
+    Old [32]byte // the node's hash in the old tree
+    New [32]byte // the node's hash in the new tree
+}
+
+/**
+ * Annotates a (compressed) proof tree with the hashes of its internal nodes. This is done by the
+ * prover and costs as much as VerifyAppendOnlyProof().
+ */
+func AnnotateAppendOnlyProof(proofTree *Tree) *AnnotatedProof {
+    ap := &AnnotatedProof{
+        ProofTree: proofTree,
+        Inner:     make([]map[[32]byte]ProofHashes, proofTree.numLevels),
+    }
+    for level := range ap.Inner {
+        ap.Inner[level] = make(map[[32]byte]ProofHashes)
+    }
+
+    ap._annotate(0, proofTree.RootNo)
+    return ap
+}
+
+/**
+ * Recursively computes the old and the new hash of the specified node, just like
+ * _hashProofTreeHelper() does, and records them for internal nodes.
+ */
+func (ap *AnnotatedProof) _annotate(level int, nodeNo [32]byte) ProofHashes {
+    tree := ap.ProofTree
+    if node, ok := tree.lvl[level].node[nodeNo]; ok {
+        return tree._proofNodeHashes(level, nodeNo, node)
+    }
+
+    if level == tree.numLevels-1 {
+        panic("Something's off: Reached leaf nil leaf node. Should've stopped descending earlier.")
+    }
+
+    leftNo, rightNo := childrenOf(nodeNo)
+    left := ap._annotate(level+1, leftNo)
+    right := ap._annotate(level+1, rightNo)
+
+    hashes := ProofHashes{
+        Old: tree._nodeHashAt(level, nodeNo, left.Old, right.Old),
+        New: tree._nodeHashAt(level, nodeNo, left.New, right.New),
+    }
+    ap.Inner[level][nodeNo] = hashes
+    return hashes
+}
+
+/**
+ * Returns the old and the new hash of a node in the proof tree (see _hashProofTreeHelper()).
+ */
+func (tree *Tree) _proofNodeHashes(level int, nodeNo [32]byte, node *Node) ProofHashes {
+    hashes := ProofHashes{Old: node.Hash, New: node.Hash}
+    if tree.lvl[level].isNew(nodeNo) {
+        hashes.Old = tree.EmptyHash
+    }
+    for _, dataHash := range node.Appended {
+        hashes.New = tree._merkleHash(hashes.New, dataHash)
+    }
+    return hashes
+}
+
+/**
+ * Returns the hashes of the specified node, either from the proof tree or from the annotations.
+ */
+func (ap *AnnotatedProof) _hashesOf(level int, nodeNo [32]byte) (ProofHashes, bool) {
+    if node, ok := ap.ProofTree.lvl[level].node[nodeNo]; ok {
+        return ap.ProofTree._proofNodeHashes(level, nodeNo, node), true
+    }
+
+    hashes, ok := ap.Inner[level][nodeNo]
+    return hashes, ok
+}
+
+/**
+ * Spot-checks an annotated append-only proof: checks that the root's annotations are the old and
+ * the new root hash, and that the annotations along the paths of 'numSamples' randomly sampled
+ * new nodes are consistent with their children. This costs at most 2 * 256 * numSamples hashes,
+ * no matter how big the proof is.
+ *
+ * Read the SECURITY note on AnnotatedProof before relying on this.
+ */
+func VerifyAppendOnlyProofSampled(ap *AnnotatedProof, oldHash [32]byte, newHash [32]byte, numSamples int) bool {
+    tree := ap.ProofTree
+    if len(ap.Inner) != tree.numLevels {
+        return false
+    }
+
+    // Check the root transition
+    rootHashes, ok := ap._hashesOf(0, tree.RootNo)
+    if !ok || !hashEqual(rootHashes.Old, oldHash) || !hashEqual(rootHashes.New, newHash) {
+        return false
+    }
+
+    // Collect the new nodes, which are the ones we sample from
+    type levelNode struct {
+        level  int
+        nodeNo [32]byte
+    }
+    var newNodes []levelNode
+    for level := 0; level < tree.numLevels; level++ {
+        lvl := tree.lvl[level]
+        for nodeNo, node := range lvl.node {
+            if lvl.isNew(nodeNo) || len(node.Appended) > 0 {
+                newNodes = append(newNodes, levelNode{level, nodeNo})
+            }
+        }
+    }
+
+    if len(newNodes) == 0 {
+        return hashEqual(rootHashes.Old, rootHashes.New)
+    }
+
+    for i := 0; i < numSamples; i++ {
+        idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(newNodes))))
+        if err != nil {
+            panic(err)
+        }
+        sample := newNodes[idx.Int64()]
+
+        if !ap._verifyPath(sample.level, sample.nodeNo) {
+            return false
+        }
+    }
+
+    return true
+}
+
+/**
+ * Checks that the hashes of every ancestor of the specified node are the hashes of the
+ * ancestor's children.
+ */
+func (ap *AnnotatedProof) _verifyPath(level int, nodeNo [32]byte) bool {
+    tree := ap.ProofTree
+    for ; level > 0; level-- {
+        parentNo := parentOf(nodeNo)
+        leftNo, rightNo := childrenOf(parentNo)
+
+        left, okLeft := ap._hashesOf(level, leftNo)
+        right, okRight := ap._hashesOf(level, rightNo)
+        parent, okParent := ap._hashesOf(level-1, parentNo)
+        if !okLeft || !okRight || !okParent {
+            return false
+        }
+
+        if tree._nodeHashAt(level-1, parentNo, left.Old, right.Old) != parent.Old ||
+            tree._nodeHashAt(level-1, parentNo, left.New, right.New) != parent.New {
+            return false
+        }
+
+        nodeNo = parentNo
+    }
+
+    return true
+}
+
+/**
+ * Returns the probability that 'numSamples' samples catch a tampered proof in which a fraction
+ * 'tamperedFraction' of the new nodes have a path with inconsistent hashes.
+ */
+func SampledDetectionProbability(numSamples int, tamperedFraction float64) float64 {
+    return 1 - math.Pow(1-tamperedFraction, float64(numSamples))
+}
+
+/**
+ * Returns the # of samples needed to catch a tampered proof with probability at least
+ * 'detectionProb', when a fraction 'tamperedFraction' of the new nodes have a path with
+ * inconsistent hashes.
+ */
+func SamplesForDetectionProbability(detectionProb float64, tamperedFraction float64) int {
+    if tamperedFraction >= 1 {
+        return 1
+    }
+    return int(math.Ceil(math.Log(1-detectionProb) / math.Log(1-tamperedFraction)))
+}
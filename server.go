@@ -0,0 +1,1190 @@
+package aomt
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+/**
+ * An HTTP server exposing the tree to clients, via the following JSON endpoints:
+ *
+ *   GET /v1/root[?epoch=<epoch>][&require=<kind>,...] the root hash of an epoch (default: the latest),
+ *                                                     with its anchors
+ *   GET /v1/anchors?epoch=<epoch>                     the anchors of an epoch
+ *   POST /v1/anchors                                  adds an anchor (an EpochAnchor without 'addedAt')
+ *   GET /v1/leaf/<hex leaf>[?epoch=<epoch>][&value=1][&fresh=1]
+ *                                                     a leaf's hash, with a membership proof (and
+ *                                                     its value, see Tree::InsertValue(), and an
+ *                                                     append-only proof from the epoch to the
+ *                                                     latest one, see FreshMembershipProof)
+ *   GET /v1/proof/append-only?from=<epoch>&to=<epoch> an append-only proof between two epochs (from the
+ *                                                     proof archive, if it has it, see ProofArchive)
+ *   GET /v1/archive                                   the epochs of the archived proofs
+ *   GET /v1/headers?from=<epoch>[&to=<epoch>]         the chain of epoch headers between two epochs
+ *                                                     (default: up to the latest), see EpochHeader
+ *   GET /v1/nodes?epoch=<epoch>&node=<level>:<hex LN>[&node=...]
+ *                                                     raw node hashes, so third parties can build
+ *                                                     custom proofs or audits themselves
+ *   GET /v1/info                                      info about the server and the tree (and
+ *                                                     the last self-audit, see SelfAuditor)
+ *   GET /v1/rejections[?since=<unix nanos>][&limit=<n>]
+ *                                                     the last rejected inserts, e.g., of leaves
+ *                                                     that were already in the tree, oldest first
+ *                                                     (see Tree::TryInsert())
+ *   GET /v1/roots/stream                              a server-sent events stream of the latest
+ *                                                     epoch's head and of every new one, as 'root'
+ *                                                     events (see Tree::SubscribeRoots())
+ *   POST /v1/leaves                                   queues leaves ({"leaves": [{"leaf": ...,
+ *                                                     "dataHash": ...}, ...]}) to be inserted in a
+ *                                                     later epoch, if the server accepts inserts
+ *                                                     (see admission.go)
+ *   GET /metrics                                      Prometheus metrics (see Metrics), if the
+ *                                                     server has them
+ *
+ * All hashes and LNs are hex-encoded. Append-only proofs are returned as a binary ProofEnvelope
+ * instead, if the client sends an 'Accept: application/octet-stream' header.
+ *
+ * Anchors (see EpochAnchor) are only served if the server has an anchor store. The server does not
+ * check them, so verifiers must check the anchors they require themselves. They can ask for the
+ * root only if it has anchors of certain kinds via 'require'.
+ *
+ * Requests must carry an API key in an 'Authorization: Bearer <key>' header and are rate limited
+ * per API key. Inserted leaves are also rate limited per API key, and queued in a bounded queue.
+ *
+ * A server for a Forest (see NewForestServer()) serves every namespace's tree under
+ * /v1/ns/<namespace>/, with the endpoints above except the anchors and archive ones (e.g.,
+ * /v1/ns/<namespace>/leaf/<hex leaf>), and also:
+ *
+ *   GET /v1/namespaces                                the super-root and the namespaces' heads
+ *   GET /v1/ns/<namespace>/super-root-proof           the proof that the namespace's head is in
+ *                                                     the super-root (see Forest::ProveNamespace())
+ */
+type Server struct {
+    tree *ConcurrentTree
+    opts ServerOptions
+
+    forest     *Forest // nil if the server serves a single tree
+    nsMu       sync.Mutex
+    nsHandlers map[string]http.Handler // the handlers of the namespaces served so far
+
+    apiKeys     map[string]*tokenBucket
+    calibration *Calibration // nil if the server was not calibrated
+    proofCache  *ProofCache  // nil if proofs are not cached
+    auditor     *SelfAuditor // nil if the tree is not audited
+
+    inserts       *insertQueue            // nil if the server does not accept inserts
+    insertBuckets map[string]*tokenBucket // the leaves each API key can queue, see admission.go
+}
+
+type ServerOptions struct {
+    APIKeys            []string // the API keys allowed to access the server
+    MaxNodesPerRequest int      // the max # of nodes a client can ask for in one request (0 to calibrate)
+    RequestsPerSecond  float64  // the rate at which each API key can make requests...
+    Burst              int      // ...after it has used up this many requests
+
+    Anchors *AnchorStore // if nil, the anchors endpoints are disabled
+    Metrics *Metrics     // if nil, the /metrics endpoint is disabled
+
+    ProofArchive *ProofArchive // if nil, append-only proofs are always computed from the tree
+
+    // The most memory used to cache the membership proofs served via /v1/leaf/ (see ProofCache), per
+    // tree, or 0 to not cache them
+    ProofCacheBytes int64
+
+    // If not 0, the server audits the tree this often in the background (see SelfAuditor),
+    // rehashing the paths of AuditSamples random leaves each time
+    AuditInterval time.Duration
+    AuditSamples  int
+
+    // If not 0, the server measures the machine's performance for about this long on startup,
+    // and uses the results to set the limits above that are 0
+    CalibrationTime time.Duration
+
+    // If not 0, clients can insert leaves via POST /v1/leaves, and at most this many leaves are
+    // queued at once (see admission.go). Forest servers do not accept inserts.
+    MaxQueuedLeaves   int
+    MaxLeavesPerEpoch int           // the most queued leaves inserted per epoch, defaultMaxLeavesPerEpoch if 0
+    EpochInterval     time.Duration // how often queued leaves are inserted, defaultEpochInterval if 0
+    LeavesPerSecond   float64       // the rate at which each API key can queue leaves (defaultLeavesPerSecond if 0)...
+    LeavesBurst       int           // ...after it has queued this many leaves (defaultLeavesBurst if 0)
+}
+
+// The limits used when the server is not calibrated
+const defaultMaxNodesPerRequest = 1024
+
+// The max time a request should spend looking up nodes, used to calibrate MaxNodesPerRequest
+const maxNodesRequestTime = 10 * time.Millisecond
+
+func DefaultServerOptions() ServerOptions {
+    return ServerOptions{
+        RequestsPerSecond: 10,
+        Burst:             20,
+        CalibrationTime:   200 * time.Millisecond,
+    }
+}
+
+func NewServer(tree *ConcurrentTree, opts ServerOptions) *Server {
+    srv := &Server{tree: tree}
+
+    if opts.CalibrationTime > 0 {
+        srv.calibration = Calibrate(opts.CalibrationTime)
+        if opts.MaxNodesPerRequest == 0 {
+            opts.MaxNodesPerRequest = srv.calibration.SuggestedMaxNodesPerRequest(maxNodesRequestTime)
+        }
+    }
+    if opts.MaxNodesPerRequest == 0 {
+        opts.MaxNodesPerRequest = defaultMaxNodesPerRequest
+    }
+    srv.opts = opts
+    if opts.ProofCacheBytes > 0 {
+        srv.proofCache = NewProofCache(opts.ProofCacheBytes)
+    }
+    if opts.AuditInterval > 0 && tree != nil {
+        srv.auditor = StartSelfAuditor(tree, opts.AuditInterval, opts.AuditSamples, opts.Metrics)
+    }
+
+    srv.apiKeys = make(map[string]*tokenBucket)
+    for _, key := range opts.APIKeys {
+        srv.apiKeys[key] = newTokenBucket(opts.RequestsPerSecond, opts.Burst)
+    }
+
+    if opts.MaxQueuedLeaves > 0 && tree != nil {
+        if srv.opts.MaxLeavesPerEpoch == 0 {
+            srv.opts.MaxLeavesPerEpoch = defaultMaxLeavesPerEpoch
+        }
+        if srv.opts.EpochInterval == 0 {
+            srv.opts.EpochInterval = defaultEpochInterval
+        }
+        if srv.opts.LeavesPerSecond == 0 {
+            srv.opts.LeavesPerSecond = defaultLeavesPerSecond
+        }
+        if srv.opts.LeavesBurst == 0 {
+            srv.opts.LeavesBurst = defaultLeavesBurst
+        }
+        srv.inserts = startInsertQueue(tree, opts.MaxQueuedLeaves, srv.opts.MaxLeavesPerEpoch, srv.opts.EpochInterval)
+        srv.insertBuckets = make(map[string]*tokenBucket)
+        for _, key := range opts.APIKeys {
+            srv.insertBuckets[key] = newTokenBucket(srv.opts.LeavesPerSecond, srv.opts.LeavesBurst)
+        }
+    }
+    return srv
+}
+
+/**
+ * Stops the server's background work, i.e., auditing the tree and inserting queued leaves. The
+ * leaves still queued are dropped.
+ */
+func (srv *Server) Close() {
+    if srv.auditor != nil {
+        srv.auditor.Stop()
+    }
+    if srv.inserts != nil {
+        srv.inserts.Stop()
+    }
+}
+
+/**
+ * Creates a server for all the namespaces of a forest, including the ones added later. The
+ * namespaces share the API keys and rate limits, and have no anchors endpoints, since anchors
+ * are per tree (anchor the super-root instead).
+ */
+func NewForestServer(forest *Forest, opts ServerOptions) *Server {
+    opts.Anchors = nil
+    opts.ProofArchive = nil
+    srv := NewServer(nil, opts)
+    srv.forest = forest
+    srv.nsHandlers = make(map[string]http.Handler)
+    return srv
+}
+
+/**
+ * Returns the server's HTTP handler, so it can be mounted in another server or used in tests.
+ */
+func (srv *Server) Handler() http.Handler {
+    var mux *http.ServeMux
+    if srv.forest != nil {
+        mux = http.NewServeMux()
+        mux.HandleFunc("/v1/namespaces", srv._authenticated(srv._handleNamespaces))
+        mux.HandleFunc("/v1/ns/", srv._authenticated(srv._handleNamespace))
+    } else {
+        mux = srv._treeHandler(srv._authenticated)
+    }
+    if srv.opts.Metrics != nil {
+        mux.HandleFunc("/metrics", srv._authenticated(srv._handleMetrics))
+    }
+    return mux
+}
+
+/**
+ * Returns the handler of the tree's endpoints, each wrapped via 'wrap'.
+ */
+func (srv *Server) _treeHandler(wrap func(http.HandlerFunc) http.HandlerFunc) *http.ServeMux {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/v1/root", wrap(srv._handleRoot))
+    mux.HandleFunc("/v1/anchors", wrap(srv._handleAnchors))
+    mux.HandleFunc("/v1/leaf/", wrap(srv._handleLeaf))
+    mux.HandleFunc("/v1/proof/append-only", wrap(srv._handleAppendOnlyProof))
+    mux.HandleFunc("/v1/archive", wrap(srv._handleArchive))
+    mux.HandleFunc("/v1/headers", wrap(srv._handleHeaders))
+    mux.HandleFunc("/v1/nodes", wrap(srv._handleNodes))
+    mux.HandleFunc("/v1/info", wrap(srv._handleInfo))
+    mux.HandleFunc("/v1/rejections", wrap(srv._handleRejections))
+    mux.HandleFunc("/v1/roots/stream", wrap(srv._handleRootsStream))
+    mux.HandleFunc("/v1/leaves", wrap(srv._handleInsert))
+    return mux
+}
+
+func (srv *Server) ListenAndServe(addr string) error {
+    return http.ListenAndServe(addr, srv.Handler())
+}
+
+type rootResponseJSON struct {
+    Epoch   int           `json:"epoch"`
+    Root    string        `json:"root"`
+    Header  string        `json:"header"` // the hash of the epoch's header, see EpochHeader
+    Anchors []EpochAnchor `json:"anchors,omitempty"`
+}
+
+func (srv *Server) _handleRoot(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    epoch, ok := srv._parseEpochParam(w, r, "epoch")
+    if !ok {
+        return
+    }
+
+    var root, header [32]byte
+    srv.tree.Read(func(tree *Tree) {
+        root = tree.GetEpochRoot(epoch)
+        header = tree.GetEpochHeader(epoch).Hash()
+    })
+    resp := rootResponseJSON{Epoch: epoch, Root: hashStr(root), Header: hashStr(header)}
+    if srv.opts.Anchors != nil {
+        resp.Anchors = srv.opts.Anchors.Get(epoch, root)
+    }
+
+    if require := r.URL.Query().Get("require"); require != "" {
+        for _, kind := range strings.Split(require, ",") {
+            found := false
+            for _, anchor := range resp.Anchors {
+                found = found || anchor.Kind == kind
+            }
+            if !found {
+                _httpError(w, http.StatusNotFound, fmt.Sprintf("epoch %d has no '%s' anchor", epoch, kind))
+                return
+            }
+        }
+    }
+
+    _writeJSON(w, resp)
+}
+
+type anchorsResponseJSON struct {
+    Epoch   int           `json:"epoch"`
+    Anchors []EpochAnchor `json:"anchors"`
+}
+
+func (srv *Server) _handleAnchors(w http.ResponseWriter, r *http.Request) {
+    if srv.opts.Anchors == nil {
+        _httpError(w, http.StatusNotFound, "this server does not store anchors")
+        return
+    }
+
+    switch r.Method {
+    case http.MethodGet:
+        if r.URL.Query().Get("epoch") == "" {
+            _httpError(w, http.StatusBadRequest, "missing 'epoch' parameter")
+            return
+        }
+        epoch, ok := srv._parseEpochParam(w, r, "epoch")
+        if !ok {
+            return
+        }
+        var root [32]byte
+        srv.tree.Read(func(tree *Tree) {
+            root = tree.GetEpochRoot(epoch)
+        })
+        _writeJSON(w, anchorsResponseJSON{Epoch: epoch, Anchors: srv.opts.Anchors.Get(epoch, root)})
+
+    case http.MethodPost:
+        var req EpochAnchor
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+            _httpError(w, http.StatusBadRequest, "invalid anchor: "+err.Error())
+            return
+        }
+
+        // Anchors can only be added to sealed (i.e., recorded) epochs, and must pin their root
+        var root [32]byte
+        found := false
+        srv.tree.Read(func(tree *Tree) {
+            found = req.Epoch >= 0 && req.Epoch < tree.NumEpochs()
+            if found {
+                root = tree.GetEpochRoot(req.Epoch)
+            }
+        })
+        if !found {
+            _httpError(w, http.StatusNotFound, fmt.Sprintf("epoch %d was not recorded", req.Epoch))
+            return
+        }
+        if req.Root != hashStr(root) {
+            _httpError(w, http.StatusConflict, fmt.Sprintf("epoch %d has root %s", req.Epoch, hashStr(root)))
+            return
+        }
+
+        anchor, err := srv.opts.Anchors.Add(req.Epoch, root, req.Kind, req.Ref)
+        if err != nil {
+            _httpError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        _writeJSON(w, anchor)
+
+    default:
+        _httpError(w, http.StatusMethodNotAllowed, "only GET and POST are allowed")
+    }
+}
+
+type leafResponseJSON struct {
+    Epoch    int      `json:"epoch"`
+    Root     string   `json:"root"`
+    Leaf     string   `json:"leaf"`
+    Exists   bool     `json:"exists"`
+    LeafHash string   `json:"leafHash"` // the empty hash, if the leaf does not exist
+    Siblings []string `json:"siblings"` // see MembershipProof::Siblings
+
+    // Only for leaves inserted via Tree::InsertCommitted(), see Opening
+    Opening *Opening `json:"opening,omitempty"`
+
+    // Only for leaves inserted via Tree::InsertValue(), and if asked for via 'value=1'
+    Value *string `json:"value,omitempty"`
+
+    // Only for leaves inserted via Tree::InsertWithExtensions(), see LeafExtensions (which include
+    // the leaf's insertion epoch in trees created with TreeOptions::InsertionEpochs)
+    Extended *ExtendedLeaf `json:"extended,omitempty"`
+
+    // Only if asked for via 'fresh=1'
+    Fresh *freshnessJSON `json:"fresh,omitempty"`
+}
+
+/**
+ * The latest epoch, and the append-only proof from a leaf's epoch to it (with no nodes if the
+ * leaf's epoch is the latest one), see FreshMembershipProof.
+ */
+type freshnessJSON struct {
+    To      int         `json:"to"`
+    NewRoot string      `json:"newRoot"`
+    Nodes   []ProofNode `json:"nodes"` // see ProofNode::MarshalJSON()
+}
+
+/**
+ * Returns the response of /v1/leaf/ with the membership proof of leaf 'leafNo' in the tree with
+ * root 'root' at the end of epoch 'epoch', without the freshness proof.
+ */
+func _newLeafResponse(epoch int, root [32]byte, leafNo [32]byte, proof *MembershipProof) leafResponseJSON {
+    resp := leafResponseJSON{Epoch: epoch, Root: hashStr(root), Leaf: hashStr(leafNo)}
+    // Non-membership proofs have the empty hash as the leaf's hash, see Tree::Get()
+    resp.Exists = proof.LeafHash != ([32]byte{})
+    resp.LeafHash = hashStr(proof.LeafHash)
+    for _, sibling := range proof.Siblings {
+        resp.Siblings = append(resp.Siblings, hashStr(sibling))
+    }
+    resp.Opening = proof.Opening
+    resp.Value = _valueToJSON(proof.Value)
+    resp.Extended = proof.Extended
+    return resp
+}
+
+func (srv *Server) _handleLeaf(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    leaf, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/v1/leaf/"))
+    if err != nil || len(leaf) != 32 {
+        _httpError(w, http.StatusBadRequest, "the leaf must be 32 hex-encoded bytes")
+        return
+    }
+    var leafNo [32]byte
+    copy(leafNo[:], leaf)
+    if numLevels := srv._numLevels(); !_fitsInLevel(leafNo, numLevels-1) {
+        _httpError(w, http.StatusBadRequest, fmt.Sprintf("the leaf is too large for a tree with %d levels", numLevels))
+        return
+    }
+
+    epoch, ok := srv._parseEpochParam(w, r, "epoch")
+    if !ok {
+        return
+    }
+
+    withValue := r.URL.Query().Get("value") == "1"
+
+    var proof *MembershipProof
+    var root [32]byte
+    cached := false
+    if srv.proofCache != nil {
+        proof, root, cached = srv.proofCache.Get(leafNo, epoch, withValue)
+    }
+    if !cached {
+        srv.tree.Read(func(tree *Tree) {
+            root = tree.GetEpochRoot(epoch)
+            proof = tree.ProveMembershipAtEpoch(leafNo, epoch)
+            // Updates drop a leaf's value, so the leaf has it as of the epoch if it existed then
+            if withValue && proof.LeafHash != tree.EmptyHash {
+                proof.Value = tree.values[leafNo]
+            }
+        })
+        if srv.proofCache != nil {
+            srv.proofCache.Put(leafNo, epoch, withValue, root, proof)
+        }
+    }
+
+    resp := _newLeafResponse(epoch, root, leafNo, proof)
+    if r.URL.Query().Get("fresh") == "1" {
+        srv.tree.Read(func(tree *Tree) {
+            latest := tree.NumEpochs() - 1
+            resp.Fresh = &freshnessJSON{To: latest, NewRoot: hashStr(tree.GetEpochRoot(latest)), Nodes: []ProofNode{}}
+            if epoch < latest {
+                resp.Fresh.Nodes = NewAppendOnlyProof(tree.ProveAppendOnly(epoch, latest)).Nodes
+            }
+        })
+    }
+    _writeJSON(w, resp)
+}
+
+type appendOnlyProofResponseJSON struct {
+    From    int         `json:"from"`
+    To      int         `json:"to"`
+    OldRoot string      `json:"oldRoot"`
+    NewRoot string      `json:"newRoot"`
+    Nodes   []ProofNode `json:"nodes"` // see ProofNode::MarshalJSON()
+}
+
+func (srv *Server) _handleAppendOnlyProof(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    query := r.URL.Query()
+    if query.Get("from") == "" || query.Get("to") == "" {
+        _httpError(w, http.StatusBadRequest, "missing 'from' or 'to' parameter")
+        return
+    }
+    from, ok := srv._parseEpochParam(w, r, "from")
+    if !ok {
+        return
+    }
+    to, ok := srv._parseEpochParam(w, r, "to")
+    if !ok {
+        return
+    }
+    if from >= to {
+        _httpError(w, http.StatusBadRequest, "'from' must be before 'to'")
+        return
+    }
+
+    var env *ProofEnvelope
+    if srv.opts.ProofArchive != nil {
+        archived, found, err := srv.opts.ProofArchive.Get(from, to)
+        if err != nil {
+            _httpError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        if found {
+            env = archived
+        }
+    }
+    if env == nil {
+        srv.tree.Read(func(tree *Tree) {
+            env = NewProofEnvelope(tree, from, to, NewAppendOnlyProof(tree.ProveAppendOnly(from, to)))
+        })
+    }
+    proof := env.Proof
+    resp := appendOnlyProofResponseJSON{From: from, To: to, OldRoot: hashStr(env.OldRoot), NewRoot: hashStr(env.NewRoot)}
+
+    if strings.Contains(r.Header.Get("Accept"), "application/octet-stream") {
+        data, err := env.MarshalBinary()
+        if err != nil {
+            _httpError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        w.Header().Set("Content-Type", "application/octet-stream")
+        w.Header().Set("X-Old-Root", resp.OldRoot)
+        w.Header().Set("X-New-Root", resp.NewRoot)
+        w.Write(data)
+        return
+    }
+
+    resp.Nodes = proof.Nodes
+    _writeJSON(w, resp)
+}
+
+type archiveResponseJSON struct {
+    Stats  ProofArchiveStats `json:"stats"`
+    Proofs []ProofArchiveKey `json:"proofs"`
+}
+
+func (srv *Server) _handleArchive(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+    if srv.opts.ProofArchive == nil {
+        _httpError(w, http.StatusNotFound, "this server does not archive proofs")
+        return
+    }
+
+    _writeJSON(w, archiveResponseJSON{Stats: srv.opts.ProofArchive.Stats(), Proofs: srv.opts.ProofArchive.Keys()})
+}
+
+// The most headers the server returns in one request, so that clients far behind page through them
+const maxHeadersPerRequest = 1024
+
+type epochHeaderJSON struct {
+    Epoch     int    `json:"epoch"`
+    Root      string `json:"root"`
+    Timestamp int64  `json:"timestamp"` // in Unix nanoseconds
+    Prev      string `json:"prev"`
+    Hash      string `json:"hash"`
+}
+
+type headersResponseJSON struct {
+    Headers []epochHeaderJSON `json:"headers"`
+}
+
+func (srv *Server) _handleHeaders(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    if r.URL.Query().Get("from") == "" {
+        _httpError(w, http.StatusBadRequest, "missing 'from' parameter")
+        return
+    }
+    from, ok := srv._parseEpochParam(w, r, "from")
+    if !ok {
+        return
+    }
+    to, ok := srv._parseEpochParam(w, r, "to")
+    if !ok {
+        return
+    }
+    if from > to {
+        _httpError(w, http.StatusBadRequest, "'from' must not be after 'to'")
+        return
+    }
+    if to-from >= maxHeadersPerRequest {
+        to = from + maxHeadersPerRequest - 1
+    }
+
+    resp := headersResponseJSON{}
+    srv.tree.Read(func(tree *Tree) {
+        for epoch := from; epoch <= to; epoch++ {
+            header := tree.GetEpochHeader(epoch)
+            resp.Headers = append(resp.Headers, epochHeaderJSON{
+                Epoch:     header.Epoch,
+                Root:      hashStr(header.Root),
+                Timestamp: header.Timestamp.UnixNano(),
+                Prev:      hashStr(header.Prev),
+                Hash:      hashStr(header.Hash()),
+            })
+        }
+    })
+    _writeJSON(w, resp)
+}
+
+/**
+ * Parses the specified epoch parameter, which defaults to the latest recorded epoch. Writes an
+ * error and returns false if the parameter is invalid or there is no such epoch.
+ */
+func (srv *Server) _parseEpochParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+    numEpochs := 0
+    srv.tree.Read(func(tree *Tree) {
+        numEpochs = tree.NumEpochs()
+    })
+    // Every request about an epoch goes through here, so this is where the cache learns of new epochs
+    if srv.proofCache != nil {
+        srv.proofCache.Advance(numEpochs)
+    }
+
+    param := r.URL.Query().Get(name)
+    if param == "" {
+        if numEpochs == 0 {
+            _httpError(w, http.StatusNotFound, "no epochs were recorded yet")
+            return 0, false
+        }
+        return numEpochs - 1, true
+    }
+
+    epoch, err := strconv.Atoi(param)
+    if err != nil {
+        _httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid '%s' parameter", name))
+        return 0, false
+    }
+    if epoch < 0 || epoch >= numEpochs {
+        _httpError(w, http.StatusNotFound, fmt.Sprintf("epoch %d was not recorded", epoch))
+        return 0, false
+    }
+    return epoch, true
+}
+
+type nodeJSON struct {
+    Level  int    `json:"level"`
+    LN     string `json:"ln"`
+    Hash   string `json:"hash"`
+    Exists bool   `json:"exists"` // false for empty nodes, which have the empty hash
+}
+
+type nodesResponseJSON struct {
+    Epoch int        `json:"epoch"`
+    Root  string     `json:"root"`
+    Nodes []nodeJSON `json:"nodes"`
+}
+
+func (srv *Server) _handleNodes(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    query := r.URL.Query()
+    epoch, err := strconv.Atoi(query.Get("epoch"))
+    if err != nil {
+        _httpError(w, http.StatusBadRequest, "missing or invalid 'epoch' parameter")
+        return
+    }
+
+    nodeParams := query["node"]
+    if len(nodeParams) == 0 {
+        _httpError(w, http.StatusBadRequest, "missing 'node' parameter")
+        return
+    }
+    if len(nodeParams) > srv.opts.MaxNodesPerRequest {
+        _httpError(w, http.StatusBadRequest, fmt.Sprintf("asked for %d nodes, but at most %d are allowed per request",
+            len(nodeParams), srv.opts.MaxNodesPerRequest))
+        return
+    }
+
+    resp := nodesResponseJSON{Epoch: epoch}
+    numLevels := srv._numLevels()
+    for _, param := range nodeParams {
+        level, nodeNo, err := _parseNodeParam(param, numLevels)
+        if err != nil {
+            _httpError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        resp.Nodes = append(resp.Nodes, nodeJSON{Level: level, LN: hex.EncodeToString(nodeNo[:])})
+    }
+
+    found := false
+    srv.tree.Read(func(tree *Tree) {
+        if epoch < 0 || epoch >= tree.NumEpochs() {
+            return
+        }
+        found = true
+        resp.Root = hashStr(tree.GetEpochRoot(epoch))
+
+        // Every node is on the path of its leftmost leaf, so the nodes are in the part of the tree
+        // as of the epoch on those leaves' paths (see _frontierAt())
+        nodeNos := make([][32]byte, len(resp.Nodes))
+        leafNos := make([][32]byte, len(resp.Nodes))
+        for i := range resp.Nodes {
+            nodeNo, _ := hex.DecodeString(resp.Nodes[i].LN)
+            copy(nodeNos[i][:], nodeNo)
+            leafNos[i] = lshBytes(nodeNos[i], uint(tree.numLevels-1-resp.Nodes[i].Level))
+        }
+        frontier := tree._frontierAt(epoch, leafNos)
+        for i := range resp.Nodes {
+            nodeHash := tree.EmptyHash
+            if node := frontier.getNode(frontier.lvl[resp.Nodes[i].Level], nodeNos[i]); node != nil {
+                nodeHash = node.Hash
+                resp.Nodes[i].Exists = true
+            }
+            resp.Nodes[i].Hash = hashStr(nodeHash)
+        }
+    })
+    if !found {
+        _httpError(w, http.StatusNotFound, fmt.Sprintf("epoch %d was not recorded", epoch))
+        return
+    }
+
+    _writeJSON(w, resp)
+}
+
+type infoResponseJSON struct {
+    NumEpochs          int          `json:"numEpochs"`
+    Root               string       `json:"root"`
+    NumLeaves          int64        `json:"numLeaves"`
+    MaxNodesPerRequest int          `json:"maxNodesPerRequest"`
+    SuggestedWorkers   int          `json:"suggestedWorkers,omitempty"`
+    Calibration        *Calibration `json:"calibration,omitempty"`
+
+    // The hit/miss statistics of the proof cache, if proofs are cached
+    ProofCache *ProofCacheStats `json:"proofCache,omitempty"`
+
+    // The last self-audit of the tree, if the server audits it and did so already
+    LastAudit *AuditResult `json:"lastAudit,omitempty"`
+
+    // The proof sizes clients can expect given the tree's current # of leaves
+    ProofSizes *ProofSizeEstimate `json:"proofSizes"`
+
+    // The # of leaves waiting to be inserted and the most that can be, if the server accepts inserts
+    QueuedLeaves    int `json:"queuedLeaves,omitempty"`
+    MaxQueuedLeaves int `json:"maxQueuedLeaves,omitempty"`
+}
+
+func (srv *Server) _handleInfo(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    resp := infoResponseJSON{
+        MaxNodesPerRequest: srv.opts.MaxNodesPerRequest,
+        Calibration:        srv.calibration,
+    }
+    if srv.calibration != nil {
+        resp.SuggestedWorkers = srv.calibration.SuggestedWorkers()
+    }
+    if srv.proofCache != nil {
+        stats := srv.proofCache.Stats()
+        resp.ProofCache = &stats
+    }
+    if srv.auditor != nil {
+        resp.LastAudit = srv.auditor.Last()
+    }
+    if srv.inserts != nil {
+        resp.QueuedLeaves = srv.inserts.len()
+        resp.MaxQueuedLeaves = srv.opts.MaxQueuedLeaves
+    }
+    srv.tree.Read(func(tree *Tree) {
+        resp.NumEpochs = tree.NumEpochs()
+        resp.Root = hashStr(tree.GetRootHash())
+        resp.NumLeaves = tree.NumLeaves()
+        resp.ProofSizes = EstimateProofSizes(tree.numLevels, resp.NumLeaves)
+    })
+
+    _writeJSON(w, resp)
+}
+
+// The most rejections the server returns in one request, so that clients page through them via 'since'
+const maxRejectionsPerRequest = 1000
+
+type rejectionJSON struct {
+    Time     int64  `json:"time"` // in Unix nanoseconds
+    Op       string `json:"op"`
+    Leaf     string `json:"leaf"`
+    DataHash string `json:"dataHash"`
+    Kind     string `json:"kind"`
+    Reason   string `json:"reason"`
+}
+
+type rejectionsResponseJSON struct {
+    Total      int64           `json:"total"` // the # of rejections since the server started
+    Rejections []rejectionJSON `json:"rejections"`
+}
+
+func (srv *Server) _handleRejections(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    query := r.URL.Query()
+    var since time.Time
+    if param := query.Get("since"); param != "" {
+        nanos, err := strconv.ParseInt(param, 10, 64)
+        if err != nil {
+            _httpError(w, http.StatusBadRequest, "invalid 'since' parameter")
+            return
+        }
+        since = time.Unix(0, nanos)
+    }
+    limit := maxRejectionsPerRequest
+    if param := query.Get("limit"); param != "" {
+        n, err := strconv.Atoi(param)
+        if err != nil || n <= 0 {
+            _httpError(w, http.StatusBadRequest, "invalid 'limit' parameter")
+            return
+        }
+        if n < limit {
+            limit = n
+        }
+    }
+
+    resp := rejectionsResponseJSON{Rejections: []rejectionJSON{}}
+    srv.tree.Read(func(tree *Tree) {
+        resp.Total = tree.NumRejections()
+        rejections := tree.Rejections(since)
+        if len(rejections) > limit {
+            rejections = rejections[:limit]
+        }
+        for _, rejection := range rejections {
+            resp.Rejections = append(resp.Rejections, rejectionJSON{
+                Time:     rejection.Time.UnixNano(),
+                Op:       rejection.Op,
+                Leaf:     hashStr(rejection.LeafNo),
+                DataHash: hashStr(rejection.DataHash),
+                Kind:     rejection.Kind,
+                Reason:   rejection.Reason,
+            })
+        }
+    })
+    _writeJSON(w, resp)
+}
+
+type rootEventJSON struct {
+    Epoch     int    `json:"epoch"`
+    Root      string `json:"root"`
+    Signature string `json:"signature,omitempty"` // hex-encoded
+}
+
+/**
+ * Streams epoch heads until the client disconnects. Heads are sent in increasing epoch order, so
+ * a client that sees a gap missed some heads (see SubscribeRoots()) and can fetch them via /v1/root.
+ */
+func (srv *Server) _handleRootsStream(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        _httpError(w, http.StatusInternalServerError, "streaming is not supported")
+        return
+    }
+
+    // Subscribe before reading the latest head, so that no epoch is recorded in between
+    heads := srv.tree.SubscribeRoots()
+    defer srv.tree.UnsubscribeRoots(heads)
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.WriteHeader(http.StatusOK)
+
+    lastEpoch := -1
+    send := func(sth SignedTreeHead) {
+        // The latest head may also have been pushed to us after we subscribed
+        if sth.Head.Epoch <= lastEpoch {
+            return
+        }
+        data, _ := json.Marshal(rootEventJSON{
+            Epoch:     sth.Head.Epoch,
+            Root:      hashStr(sth.Head.Root),
+            Signature: hex.EncodeToString(sth.Signature),
+        })
+        fmt.Fprintf(w, "id: %d\nevent: root\ndata: %s\n\n", sth.Head.Epoch, data)
+        flusher.Flush()
+        lastEpoch = sth.Head.Epoch
+    }
+
+    var latest *SignedTreeHead
+    srv.tree.Read(func(tree *Tree) {
+        if tree.NumEpochs() > 0 {
+            sth := tree.GetSignedTreeHead(tree.NumEpochs() - 1)
+            latest = &sth
+        }
+    })
+    if latest != nil {
+        send(*latest)
+    }
+
+    for {
+        select {
+        case sth := <-heads:
+            send(sth)
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+type namespaceHeadJSON struct {
+    Namespace string `json:"namespace"`
+    Epoch     int    `json:"epoch"`
+    Root      string `json:"root"`
+}
+
+type namespacesResponseJSON struct {
+    SuperRoot  string              `json:"superRoot"`
+    Heads      []namespaceHeadJSON `json:"heads"`      // only the namespaces with epochs
+    Namespaces []string            `json:"namespaces"` // all namespaces
+}
+
+func (srv *Server) _handleNamespaces(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    superRoot, heads := srv.forest.SuperRoot()
+    resp := namespacesResponseJSON{
+        SuperRoot:  hashStr(superRoot),
+        Heads:      []namespaceHeadJSON{},
+        Namespaces: srv.forest.Namespaces(),
+    }
+    for _, head := range heads {
+        resp.Heads = append(resp.Heads, namespaceHeadJSON{head.Namespace, head.Head.Epoch, hashStr(head.Head.Root)})
+    }
+    _writeJSON(w, resp)
+}
+
+/**
+ * Serves the metrics in the Prometheus text exposition format (see Metrics), counting the nodes of
+ * the tree (or of all the forest's trees) now.
+ */
+func (srv *Server) _handleMetrics(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    var nodeCounts []int64
+    if srv.forest != nil {
+        nodeCounts = srv.forest.NodeCountsByBand()
+    } else {
+        srv.tree.Read(func(tree *Tree) {
+            nodeCounts = tree.NodeCountsByBand(nil)
+        })
+    }
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    srv.opts.Metrics.WriteText(w, nodeCounts)
+}
+
+type namespaceProofResponseJSON struct {
+    namespaceHeadJSON
+    SuperRoot string   `json:"superRoot"`
+    Index     int      `json:"index"`
+    Size      int      `json:"size"`
+    Siblings  []string `json:"siblings"` // see NamespaceProof::Siblings
+}
+
+/**
+ * Serves /v1/ns/<namespace>/<endpoint> via the namespace's tree endpoints, as /v1/<endpoint>.
+ */
+func (srv *Server) _handleNamespace(w http.ResponseWriter, r *http.Request) {
+    parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/ns/"), "/", 2)
+    if len(parts) != 2 {
+        _httpError(w, http.StatusNotFound, "expected /v1/ns/<namespace>/<endpoint>")
+        return
+    }
+    namespace, endpoint := parts[0], parts[1]
+
+    if endpoint == "super-root-proof" {
+        srv._handleNamespaceProof(w, r, namespace)
+        return
+    }
+    if endpoint == "anchors" {
+        _httpError(w, http.StatusNotFound, "namespaces have no anchors")
+        return
+    }
+
+    handler := srv._namespaceHandler(namespace)
+    if handler == nil {
+        _httpError(w, http.StatusNotFound, fmt.Sprintf("no namespace '%s'", namespace))
+        return
+    }
+    nsReq := r.Clone(r.Context())
+    nsReq.URL.Path = "/v1/" + endpoint
+    handler.ServeHTTP(w, nsReq)
+}
+
+func (srv *Server) _handleNamespaceProof(w http.ResponseWriter, r *http.Request, namespace string) {
+    if r.Method != http.MethodGet {
+        _httpError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+        return
+    }
+
+    proof, superRoot, err := srv.forest.ProveNamespace(namespace)
+    if err != nil {
+        _httpError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    resp := namespaceProofResponseJSON{
+        namespaceHeadJSON: namespaceHeadJSON{namespace, proof.Head.Epoch, hashStr(proof.Head.Root)},
+        SuperRoot:         hashStr(superRoot),
+        Index:             proof.Index,
+        Size:              proof.Size,
+        Siblings:          []string{},
+    }
+    for _, sibling := range proof.Siblings {
+        resp.Siblings = append(resp.Siblings, hashStr(sibling))
+    }
+    _writeJSON(w, resp)
+}
+
+/**
+ * Returns the (unauthenticated) handler of a namespace's tree endpoints, or nil if there is no
+ * such namespace. Every namespace gets its own server, so that it caches its own proofs.
+ */
+func (srv *Server) _namespaceHandler(namespace string) http.Handler {
+    tree := srv.forest.Namespace(namespace)
+    if tree == nil {
+        return nil
+    }
+
+    srv.nsMu.Lock()
+    defer srv.nsMu.Unlock()
+
+    handler := srv.nsHandlers[namespace]
+    if handler == nil {
+        nsSrv := &Server{
+            tree:        tree,
+            opts:        srv.opts,
+            apiKeys:     srv.apiKeys,
+            calibration: srv.calibration,
+        }
+        if srv.opts.ProofCacheBytes > 0 {
+            nsSrv.proofCache = NewProofCache(srv.opts.ProofCacheBytes)
+        }
+        // The forest server already checked the API key and rate limit
+        handler = nsSrv._treeHandler(func(h http.HandlerFunc) http.HandlerFunc { return h })
+        srv.nsHandlers[namespace] = handler
+    }
+    return handler
+}
+
+/**
+ * Returns the # of levels of the served tree.
+ */
+func (srv *Server) _numLevels() int {
+    var numLevels int
+    srv.tree.Read(func(tree *Tree) {
+        numLevels = tree.numLevels
+    })
+    return numLevels
+}
+
+/**
+ * Parses a node given as '<level>:<hex LN>' of a tree with 'numLevels' levels.
+ */
+func _parseNodeParam(param string, numLevels int) (int, [32]byte, error) {
+    var nodeNo [32]byte
+
+    parts := strings.SplitN(param, ":", 2)
+    if len(parts) != 2 {
+        return 0, nodeNo, fmt.Errorf("node '%s' is not of the form <level>:<hex LN>", param)
+    }
+
+    level, err := strconv.Atoi(parts[0])
+    if err != nil || level < 0 || level >= numLevels {
+        return 0, nodeNo, fmt.Errorf("node '%s' has an invalid level (the tree has %d levels)", param, numLevels)
+    }
+
+    ln, err := hex.DecodeString(parts[1])
+    if err != nil || len(ln) > 32 {
+        return 0, nodeNo, fmt.Errorf("node '%s' has an invalid LN", param)
+    }
+    copy(nodeNo[32-len(ln):], ln)
+
+    // A level-L node's LN has at most L bits
+    if !_fitsInLevel(nodeNo, level) {
+        return 0, nodeNo, fmt.Errorf("node '%s' has an LN that is too large for its level", param)
+    }
+
+    return level, nodeNo, nil
+}
+
+/**
+ * Wraps a handler so that it checks the request's API key and rate limits it.
+ */
+func (srv *Server) _authenticated(handler http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        bucket, ok := srv.apiKeys[_apiKey(r)]
+        if !ok {
+            _httpError(w, http.StatusUnauthorized, "missing or invalid API key")
+            return
+        }
+
+        if !bucket.take() {
+            _httpError(w, http.StatusTooManyRequests, "rate limit exceeded")
+            return
+        }
+
+        handler(w, r)
+    }
+}
+
+/**
+ * Returns the API key of the request, see _authenticated().
+ */
+func _apiKey(r *http.Request) string {
+    return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func _writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(v)
+}
+
+func _httpError(w http.ResponseWriter, status int, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+/**
+ * A token bucket rate limiter: refills at 'rate' tokens per second, up to 'burst' tokens.
+ */
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+    return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+/**
+ * Takes a token from the bucket, returning false if there are none left.
+ */
+func (b *tokenBucket) take() bool {
+    return b.takeN(1)
+}
+
+/**
+ * Takes 'n' tokens from the bucket, or none if there are fewer than 'n' left, in which case it
+ * returns false.
+ */
+func (b *tokenBucket) takeN(n float64) bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    now := time.Now()
+    b.tokens += now.Sub(b.last).Seconds() * b.rate
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+    b.last = now
+
+    if b.tokens < n {
+        return false
+    }
+    b.tokens -= n
+    return true
+}
+
+/**
+ * Gives back 'n' tokens taken for a request that was not served after all.
+ */
+func (b *tokenBucket) refund(n float64) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.tokens = math.Min(b.tokens+n, b.burst)
+}
@@ -0,0 +1,178 @@
+package aomt
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+)
+
+/**
+ * Nodes and leaves that do not fit in the tree must be rejected with a 400, rather than crash the
+ * handler or return proofs that cannot verify.
+ */
+func TestServerRejectsOutOfRangeNodes(t *testing.T) {
+    tree := NewTree(16)
+    var leafNo [32]byte
+    leafNo[31] = 0x2a
+    tree.Insert(leafNo, [32]byte{1}, nil)
+    tree.RecordEpoch()
+
+    srv := NewServer(WrapTree(tree), ServerOptions{APIKeys: []string{"key"}, RequestsPerSecond: 1000, Burst: 1000})
+    handler := srv.Handler()
+    get := func(path string) int {
+        req := httptest.NewRequest(http.MethodGet, path, nil)
+        req.Header.Set("Authorization", "Bearer key")
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    cases := map[string]int{
+        "/v1/nodes?epoch=0&node=0:00":   http.StatusOK,
+        "/v1/nodes?epoch=0&node=15:2a":  http.StatusOK,
+        "/v1/nodes?epoch=0&node=16:00":  http.StatusBadRequest,
+        "/v1/nodes?epoch=0&node=100:00": http.StatusBadRequest,
+        "/v1/nodes?epoch=0&node=256:00": http.StatusBadRequest,
+        "/v1/nodes?epoch=0&node=-1:00":  http.StatusBadRequest,
+        "/v1/leaf/" + hashStr(leafNo) + "?epoch=0": http.StatusOK,
+        // 2^15 needs 16 bits, but leaves are on level 15
+        "/v1/leaf/0000000000000000000000000000000000000000000000000000000000008000?epoch=0": http.StatusBadRequest,
+        "/v1/leaf/ff00000000000000000000000000000000000000000000000000000000000000?epoch=0": http.StatusBadRequest,
+    }
+    for path, expected := range cases {
+        if code := get(path); code != expected {
+            t.Errorf("GET %s: got status %d, expected %d", path, code, expected)
+        }
+    }
+}
+
+/**
+ * Nodes of past epochs must be served as they were then, including ones changed or emptied since.
+ */
+func TestServerServesPastEpochNodes(t *testing.T) {
+    tree := NewTree(16)
+    var leafNos [][32]byte
+    for e := 0; e < 3; e++ {
+        for i := 0; i < 10; i++ {
+            leaf := _testLeaf(10*e + i)
+            var leafNo [32]byte
+            copy(leafNo[30:], leaf.LeafNo[30:])
+            leafNo[30] &= 0x7f
+            tree.Insert(leafNo, leaf.DataHash, nil)
+            leafNos = append(leafNos, leafNo)
+        }
+        tree.Update(leafNos[e], _testLeaf(-e).DataHash, nil)
+        tree.RecordEpoch()
+    }
+    tree.Update(leafNos[0], _testLeaf(100).DataHash, nil)
+
+    srv := NewServer(WrapTree(tree), ServerOptions{APIKeys: []string{"key"}, MaxNodesPerRequest: 100, RequestsPerSecond: 1000, Burst: 1000})
+    handler := srv.Handler()
+    for epoch := 0; epoch < tree.NumEpochs(); epoch++ {
+        replay := tree.TreeAtEpoch(epoch)
+
+        // The nodes on the paths of leaves of every epoch, and of a leaf never inserted
+        pathNos := [][32]byte{{30: 0x7f, 31: 0xff}}
+        for i := 0; i < len(leafNos); i += 7 {
+            pathNos = append(pathNos, leafNos[i])
+        }
+        path := fmt.Sprintf("/v1/nodes?epoch=%d", epoch)
+        var expected []nodeJSON
+        for _, leafNo := range pathNos {
+            for _, level := range []int{15, 12, 6, 0} {
+                nodeNo := rshBytes(leafNo, uint(15-level))
+                path += fmt.Sprintf("&node=%d:%x", level, nodeNo)
+                node := nodeJSON{Level: level, LN: hex.EncodeToString(nodeNo[:]), Hash: hashStr(tree.EmptyHash)}
+                if n := replay.getNode(replay.lvl[level], nodeNo); n != nil {
+                    node.Hash, node.Exists = hashStr(n.Hash), true
+                }
+                expected = append(expected, node)
+            }
+        }
+
+        req := httptest.NewRequest(http.MethodGet, path, nil)
+        req.Header.Set("Authorization", "Bearer key")
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        var resp nodesResponseJSON
+        if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+            t.Fatalf("epoch %d: status %d, %v", epoch, rec.Code, err)
+        }
+        if resp.Root != hashStr(tree.GetEpochRoot(epoch)) {
+            t.Errorf("epoch %d: root is %s, expected %s", epoch, resp.Root, hashStr(tree.GetEpochRoot(epoch)))
+        }
+        if !reflect.DeepEqual(resp.Nodes, expected) {
+            t.Errorf("epoch %d: nodes are %+v, expected %+v", epoch, resp.Nodes, expected)
+        }
+    }
+}
+
+/**
+ * Leaf proofs of past epochs must verify against their epoch's root with the tree's options,
+ * along with the leaves' extensions or values as of then.
+ */
+func TestServerServesPastEpochLeafProofs(t *testing.T) {
+    for _, opts := range []TreeOptions{{LeafExtensions: true}, {}} {
+        tree, err := NewTreeWithOptions(257, opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        // Leaf i is inserted in epoch i/5, and the last ones are still pending
+        insert := func(i int) {
+            leaf := _testLeaf(i)
+            value := []byte(fmt.Sprintf("value %d", i))
+            if opts.LeafExtensions {
+                tree.InsertWithExtensions(leaf.LeafNo, leaf.DataHash, LeafExtensions{Timestamp: uint64(i), Policy: value}, nil)
+            } else {
+                tree.InsertValue(leaf.LeafNo, value, nil)
+            }
+        }
+        for i := 0; i < 15; i++ {
+            insert(i)
+            if i%5 == 4 {
+                tree.RecordEpoch()
+            }
+        }
+        insert(15)
+
+        srv := NewServer(WrapTree(tree), ServerOptions{APIKeys: []string{"key"}, RequestsPerSecond: 1000, Burst: 1000})
+        handler := srv.Handler()
+        for epoch := 0; epoch < tree.NumEpochs(); epoch++ {
+            for i := 0; i < 16; i += 2 {
+                leafNo := _testLeaf(i).LeafNo
+                req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/leaf/%x?epoch=%d&value=1", leafNo, epoch), nil)
+                req.Header.Set("Authorization", "Bearer key")
+                rec := httptest.NewRecorder()
+                handler.ServeHTTP(rec, req)
+                var resp leafResponseJSON
+                if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+                    t.Fatalf("%+v: leaf %d as of epoch %d: status %d, %v", opts, i, epoch, rec.Code, err)
+                }
+
+                proof := &MembershipProof{LeafNo: leafNo, Opening: resp.Opening, Extended: resp.Extended}
+                proof.LeafHash, _ = _parseHashHex(resp.LeafHash)
+                for _, sibling := range resp.Siblings {
+                    hash, _ := _parseHashHex(sibling)
+                    proof.Siblings = append(proof.Siblings, hash)
+                }
+                if proof.Value, err = _valueFromJSON(resp.Value); err != nil {
+                    t.Fatal(err)
+                }
+
+                verifyOpts := opts
+                verifyOpts.NumLevels = 257
+                if !VerifyMembershipProofWithOptions(proof, tree.GetEpochRoot(epoch), verifyOpts) {
+                    t.Errorf("%+v: proof of leaf %d as of epoch %d does not verify", opts, i, epoch)
+                }
+                if exists := i/5 <= epoch; resp.Exists != exists || (proof.Extended != nil || proof.Value != nil) != exists {
+                    t.Errorf("%+v: leaf %d as of epoch %d exists: %v, has extensions or a value: %v", opts, i, epoch,
+                        resp.Exists, proof.Extended != nil || proof.Value != nil)
+                }
+            }
+        }
+    }
+}
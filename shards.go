@@ -0,0 +1,755 @@
+package aomt
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+)
+
+/**
+ * Stores a tree whose nodes do not fit on one disk: the subtrees rooted at level 'prefixBits' (i.e.,
+ * the 2^prefixBits subtrees whose leaves share the same first 'prefixBits' bits) are spread across
+ * several shards (see ShardStore), e.g., DB files on different disks or remote object stores (see
+ * ObjectShardStore), while the top 'prefixBits' levels, the epochs and the version chains are kept
+ * in a local file (see Save()). Shards can be added or removed later via Rebalance().
+ *
+ * Subtrees are stored under their prefix and root hash, and are only written when their root
+ * changes, so a Save() after a batch only writes the subtrees the batch touched. Since a new version
+ * of a subtree does not overwrite the old one, a Save() (or Rebalance()) that fails midway leaves
+ * the storage as of the previous Save(): the local file, which says which subtree versions are
+ * current, is replaced last, and the old versions are deleted only afterwards.
+ *
+ * The local file ("shards.aomt" in the storage's directory) is encoded as:
+ *
+ *   magic        ("AOMTSHRD", 8 bytes)
+ *   version      (1 byte, currently 1)
+ *   numLevels    (2 bytes)
+ *   prefixBits   (1 byte)
+ *   numShards    (2 bytes), followed by every shard's name (see ShardStore::Name()), encoded as:
+ *     nameLen    (2 bytes)
+ *     name       (nameLen bytes)
+ *   for each prefix, from 0 to 2^prefixBits - 1:
+ *     shard      (2 bytes), the index of the shard that stores the prefix's subtree
+ *     root       (32 bytes), the root hash of the subtree, or all zeros if there is none
+ *   snapshot     (see Tree::Snapshot()), without the nodes below level 'prefixBits'
+ *
+ * and a subtree is encoded as:
+ *
+ *   magic        ("AOMTSUBT", 8 bytes)
+ *   version      (1 byte, currently 1)
+ *   numLevels    (2 bytes)
+ *   prefixBits   (1 byte)
+ *   prefix       (4 bytes)
+ *   for each level, from 'prefixBits' to the leaves:
+ *     numNodes   (8 bytes), followed by the level's nodes in the subtree, as in a snapshot
+ *
+ * where all integers are big-endian. The roots in the local file let Load() check that every
+ * shard returned the subtree version it was asked for.
+ *
+ * NOTE: As for snapshots, the node hashes are not recomputed when loading, so a shard can still
+ * return a subtree whose inner nodes do not match its root. Pruned trees cannot be stored, since
+ * their pruned subtrees' nodes are rebuilt from the leaves, which may be on another shard.
+ */
+type ShardedStorage struct {
+    dir        string
+    numLevels  int // 0 until the first Save()
+    prefixBits int
+    shards     []ShardStore
+    assignment []int      // the index of the shard of every prefix's subtree
+    roots      [][32]byte // the root hash of every prefix's stored subtree, all zeros if none
+}
+
+/**
+ * A store for the subtrees of a ShardedStorage. Each subtree version is stored under its prefix
+ * and root hash, and must be readable until it is deleted. Its pages can be transformed at rest,
+ * see TransformedShardStore.
+ */
+type ShardStore interface {
+    // Identifies the store in the storage's local file, so it can be reopened (see
+    // OpenShardedStorage()). Must be unique among the storage's shards.
+    Name() string
+
+    NodeStore
+}
+
+// The most prefix bits, so that the local file has at most 2^16 subtree roots (i.e., 2 MiB)
+const maxShardPrefixBits = 16
+
+// The most shards, so that a shard's index fits in 2 bytes
+const maxNumShards = 1<<16 - 1
+
+const shardsFileName = "shards.aomt"
+const shardsMagic = "AOMTSHRD"
+const shardsVersion = 1
+const subtreeMagic = "AOMTSUBT"
+const subtreeVersion = 1
+
+/**
+ * Creates a sharded storage in directory 'dir' whose subtrees are rooted at level 'prefixBits',
+ * spread evenly across 'shards' (i.e., each shard gets a contiguous range of prefixes). Nothing is
+ * written until Save() is called, and it fails if there is already a storage in 'dir'.
+ */
+func CreateShardedStorage(dir string, prefixBits int, shards []ShardStore) (*ShardedStorage, error) {
+    if prefixBits < 1 || prefixBits > maxShardPrefixBits {
+        return nil, fmt.Errorf("subtrees must be rooted between level 1 and level %d, not level %d", maxShardPrefixBits, prefixBits)
+    }
+    names, err := _shardNames(shards)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := os.Stat(filepath.Join(dir, shardsFileName)); err == nil {
+        return nil, fmt.Errorf("there is already a sharded storage in %s", dir)
+    }
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+
+    numPrefixes := 1 << uint(prefixBits)
+    return &ShardedStorage{
+        dir:        dir,
+        prefixBits: prefixBits,
+        shards:     shards,
+        assignment: _balancedAssignment(numPrefixes, nil, nil, names),
+        roots:      make([][32]byte, numPrefixes),
+    }, nil
+}
+
+/**
+ * Opens the sharded storage saved in directory 'dir', opening its shards by name via 'openShard'
+ * (e.g., OpenDirShardStore() or OpenShardStore()).
+ */
+func OpenShardedStorage(dir string, openShard func(name string) (ShardStore, error)) (*ShardedStorage, error) {
+    f, err := os.Open(filepath.Join(dir, shardsFileName))
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    storage, names, err := _readShardsHeader(bufio.NewReader(f))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", f.Name(), err)
+    }
+    storage.dir = dir
+    for _, name := range names {
+        shard, err := openShard(name)
+        if err != nil {
+            return nil, fmt.Errorf("opening shard %s: %v", name, err)
+        }
+        storage.shards = append(storage.shards, shard)
+    }
+    return storage, nil
+}
+
+/**
+ * Returns the level at which the subtrees stored on the shards are rooted.
+ */
+func (storage *ShardedStorage) PrefixBits() int {
+    return storage.prefixBits
+}
+
+/**
+ * Returns the # of prefixes whose subtrees are on every shard, by shard name.
+ */
+func (storage *ShardedStorage) NumSubtreesPerShard() map[string]int {
+    counts := make(map[string]int)
+    for prefix, root := range storage.roots {
+        if root != ([32]byte{}) {
+            counts[storage.shards[storage.assignment[prefix]].Name()]++
+        }
+    }
+    return counts
+}
+
+/**
+ * Stores the tree, writing the subtrees whose roots changed since the last Save() to their shards
+ * and then replacing the local file. The tree cannot have an open epoch nor be pruned, and must
+ * have the same # of levels as the previously saved one (and more than 'prefixBits').
+ */
+func (storage *ShardedStorage) Save(tree *Tree) error {
+    if tree.open != nil {
+        return errors.New("cannot save a tree with an open epoch")
+    }
+    if tree.numPruned > 0 {
+        return errors.New("cannot shard a pruned tree")
+    }
+    if tree.numLevels <= storage.prefixBits {
+        return fmt.Errorf("a tree with %d levels has no subtrees rooted at level %d", tree.numLevels, storage.prefixBits)
+    }
+    if storage.numLevels != 0 && tree.numLevels != storage.numLevels {
+        return fmt.Errorf("the storage has a tree with %d levels, not %d", storage.numLevels, tree.numLevels)
+    }
+
+    // Only the subtrees whose roots changed need to be written
+    k := storage.prefixBits
+    roots := make([][32]byte, len(storage.roots))
+    for rootNo, node := range tree.lvl[k].node {
+        roots[_subtreePrefix(rootNo, k, k)] = node.Hash
+    }
+    changed := make(map[int][][][32]byte)
+    for prefix := range roots {
+        if roots[prefix] != storage.roots[prefix] && roots[prefix] != ([32]byte{}) {
+            changed[prefix] = make([][][32]byte, tree.numLevels-k)
+        }
+    }
+    for level := k; level < tree.numLevels && len(changed) > 0; level++ {
+        for nodeNo := range tree.lvl[level].node {
+            if levels, ok := changed[_subtreePrefix(nodeNo, level, k)]; ok {
+                levels[level-k] = append(levels[level-k], nodeNo)
+            }
+        }
+    }
+
+    // Shards that write batches faster (e.g., remote ones) get all their subtrees at once
+    batches := make(map[int][]ShardSubtree)
+    for prefix, levels := range changed {
+        shard := storage.shards[storage.assignment[prefix]]
+        data := tree._encodeSubtree(k, prefix, levels)
+        if _, ok := shard.(BatchShardStore); ok {
+            batches[storage.assignment[prefix]] = append(batches[storage.assignment[prefix]], ShardSubtree{prefix, roots[prefix], data})
+            continue
+        }
+        if err := shard.WriteSubtree(prefix, roots[prefix], data); err != nil {
+            return fmt.Errorf("writing subtree %d to shard %s: %v", prefix, shard.Name(), err)
+        }
+    }
+    for i, batch := range batches {
+        shard := storage.shards[i].(BatchShardStore)
+        if err := shard.WriteSubtrees(batch); err != nil {
+            return fmt.Errorf("writing %d subtrees to shard %s: %v", len(batch), shard.Name(), err)
+        }
+    }
+
+    oldRoots := storage.roots
+    storage.numLevels, storage.roots = tree.numLevels, roots
+    err := storage._writeLocal(func(w io.Writer) error {
+        return tree._snapshotTop(context.Background(), w, k+1)
+    })
+    if err != nil {
+        storage.roots = oldRoots
+        return err
+    }
+
+    return storage._deleteStale(oldRoots)
+}
+
+/**
+ * Loads the tree, reading every subtree from its shard. The tree must have been created with the
+ * specified options (see LoadSnapshotWithOptions()).
+ */
+func (storage *ShardedStorage) Load(opts TreeOptions) (*Tree, error) {
+    f, err := os.Open(filepath.Join(storage.dir, shardsFileName))
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    br := bufio.NewReader(f)
+    if _, _, err := _readShardsHeader(br); err != nil {
+        return nil, fmt.Errorf("%s: %v", f.Name(), err)
+    }
+    tree, err := LoadSnapshotWithOptions(br, opts)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", f.Name(), err)
+    }
+    if tree.numLevels != storage.numLevels {
+        return nil, fmt.Errorf("%s: the snapshot has %d levels, not %d", f.Name(), tree.numLevels, storage.numLevels)
+    }
+
+    k := storage.prefixBits
+    numSubtrees := 0
+    for prefix, root := range storage.roots {
+        if root == ([32]byte{}) {
+            continue
+        }
+        numSubtrees++
+
+        if node := tree.lvl[k].node[_subtreeRootNo(prefix)]; node == nil || node.Hash != root {
+            return nil, fmt.Errorf("the top levels do not have subtree %d's root", prefix)
+        }
+        shard := storage.shards[storage.assignment[prefix]]
+        data, err := shard.ReadSubtree(prefix, root)
+        if err != nil {
+            return nil, fmt.Errorf("reading subtree %d from shard %s: %v", prefix, shard.Name(), err)
+        }
+        if err := tree._decodeSubtree(data, k, prefix, root); err != nil {
+            return nil, fmt.Errorf("subtree %d from shard %s: %v", prefix, shard.Name(), err)
+        }
+    }
+    if numSubtrees != len(tree.lvl[k].node) {
+        return nil, fmt.Errorf("the top levels have %d subtree roots, but %d subtrees are stored", len(tree.lvl[k].node), numSubtrees)
+    }
+
+    // The snapshot only seeded the versions of the top levels' nodes
+    if tree.versions != nil {
+        tree._seedVersions()
+    }
+    return tree, nil
+}
+
+/**
+ * Spreads the subtrees evenly across 'shards', which may include some of the current shards, and
+ * returns how many subtrees were moved. Subtrees stay on their shard if it is in 'shards' and
+ * does not have more than its share, so adding a shard to N shards only moves about 1/(N+1) of the
+ * subtrees.
+ *
+ * NOTE: Shards get the same # of prefixes, rather than of nodes, which is even enough since leaf
+ * no's are hashes (unless the tree has raw keys).
+ */
+func (storage *ShardedStorage) Rebalance(shards []ShardStore) (int, error) {
+    names, err := _shardNames(shards)
+    if err != nil {
+        return 0, err
+    }
+    oldNames, _ := _shardNames(storage.shards)
+    assignment := _balancedAssignment(len(storage.roots), storage.assignment, oldNames, names)
+
+    // Copy the moved subtrees first, so that the local file never points to a missing one
+    numMoved := 0
+    for prefix, root := range storage.roots {
+        from, to := storage.shards[storage.assignment[prefix]], shards[assignment[prefix]]
+        if root == ([32]byte{}) || from.Name() == to.Name() {
+            continue
+        }
+
+        data, err := from.ReadSubtree(prefix, root)
+        if err != nil {
+            return 0, fmt.Errorf("reading subtree %d from shard %s: %v", prefix, from.Name(), err)
+        }
+        if err := to.WriteSubtree(prefix, root, data); err != nil {
+            return 0, fmt.Errorf("writing subtree %d to shard %s: %v", prefix, to.Name(), err)
+        }
+        numMoved++
+    }
+
+    oldShards, oldAssignment := storage.shards, storage.assignment
+    storage.shards, storage.assignment = shards, assignment
+    if storage.numLevels != 0 {
+        err := storage._writeLocal(func(w io.Writer) error {
+            return storage._copySnapshot(w)
+        })
+        if err != nil {
+            storage.shards, storage.assignment = oldShards, oldAssignment
+            return 0, err
+        }
+    }
+
+    // Delete the moved subtrees from their old shards
+    for prefix, root := range storage.roots {
+        from := oldShards[oldAssignment[prefix]]
+        if root == ([32]byte{}) || from.Name() == shards[assignment[prefix]].Name() {
+            continue
+        }
+        if err := from.DeleteSubtree(prefix, root); err != nil {
+            return numMoved, fmt.Errorf("deleting moved subtree %d from shard %s: %v", prefix, from.Name(), err)
+        }
+    }
+    return numMoved, nil
+}
+
+/**
+ * Deletes the subtree versions in 'oldRoots' that are not current anymore.
+ */
+func (storage *ShardedStorage) _deleteStale(oldRoots [][32]byte) error {
+    for prefix, oldRoot := range oldRoots {
+        if oldRoot == ([32]byte{}) || oldRoot == storage.roots[prefix] {
+            continue
+        }
+        shard := storage.shards[storage.assignment[prefix]]
+        if err := shard.DeleteSubtree(prefix, oldRoot); err != nil {
+            return fmt.Errorf("deleting stale subtree %d from shard %s: %v", prefix, shard.Name(), err)
+        }
+    }
+    return nil
+}
+
+/**
+ * Writes the local file's header followed by 'writeSnapshot's output to a temporary file, and
+ * renames it over the local file.
+ */
+func (storage *ShardedStorage) _writeLocal(writeSnapshot func(w io.Writer) error) error {
+    path := filepath.Join(storage.dir, shardsFileName)
+    tmpPath := path + ".tmp"
+    f, err := os.Create(tmpPath)
+    if err != nil {
+        return err
+    }
+
+    bw := bufio.NewWriter(f)
+    storage._writeHeader(bw)
+    err = writeSnapshot(bw)
+    if err == nil {
+        err = bw.Flush()
+    }
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+        return err
+    }
+    return os.Rename(tmpPath, path)
+}
+
+/**
+ * Copies the snapshot in the current local file to 'w'.
+ */
+func (storage *ShardedStorage) _copySnapshot(w io.Writer) error {
+    f, err := os.Open(filepath.Join(storage.dir, shardsFileName))
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    br := bufio.NewReader(f)
+    if _, _, err := _readShardsHeader(br); err != nil {
+        return fmt.Errorf("%s: %v", f.Name(), err)
+    }
+    _, err = io.Copy(w, br)
+    return err
+}
+
+func (storage *ShardedStorage) _writeHeader(bw *bufio.Writer) {
+    bw.WriteString(shardsMagic)
+    bw.WriteByte(shardsVersion)
+    binary.Write(bw, binary.BigEndian, uint16(storage.numLevels))
+    bw.WriteByte(byte(storage.prefixBits))
+
+    binary.Write(bw, binary.BigEndian, uint16(len(storage.shards)))
+    for _, shard := range storage.shards {
+        binary.Write(bw, binary.BigEndian, uint16(len(shard.Name())))
+        bw.WriteString(shard.Name())
+    }
+    for prefix, root := range storage.roots {
+        binary.Write(bw, binary.BigEndian, uint16(storage.assignment[prefix]))
+        bw.Write(root[:])
+    }
+}
+
+/**
+ * Reads the local file's header, returning a storage without a directory nor shards, and the
+ * shards' names.
+ */
+func _readShardsHeader(br *bufio.Reader) (*ShardedStorage, []string, error) {
+    magic := make([]byte, len(shardsMagic))
+    if _, err := io.ReadFull(br, magic); err != nil || string(magic) != shardsMagic {
+        return nil, nil, errors.New("not a sharded storage")
+    }
+    version, err := br.ReadByte()
+    if err != nil {
+        return nil, nil, fmt.Errorf("reading version: %v", err)
+    }
+    if version != shardsVersion {
+        return nil, nil, fmt.Errorf("unsupported sharded storage version %d", version)
+    }
+
+    var numLevels, numShards uint16
+    if err := binary.Read(br, binary.BigEndian, &numLevels); err != nil {
+        return nil, nil, fmt.Errorf("reading # of levels: %v", err)
+    }
+    prefixBits, err := br.ReadByte()
+    if err != nil {
+        return nil, nil, fmt.Errorf("reading prefix bits: %v", err)
+    }
+    if prefixBits < 1 || prefixBits > maxShardPrefixBits || int(numLevels) <= int(prefixBits) || numLevels > maxNumLevels {
+        return nil, nil, fmt.Errorf("invalid level %d of subtrees in a tree with %d levels", prefixBits, numLevels)
+    }
+    if err := binary.Read(br, binary.BigEndian, &numShards); err != nil {
+        return nil, nil, fmt.Errorf("reading # of shards: %v", err)
+    }
+    if numShards == 0 {
+        return nil, nil, errors.New("no shards")
+    }
+
+    names := make([]string, numShards)
+    for i := range names {
+        var nameLen uint16
+        if err := binary.Read(br, binary.BigEndian, &nameLen); err != nil {
+            return nil, nil, fmt.Errorf("reading length of shard %d's name: %v", i, err)
+        }
+        name := make([]byte, nameLen)
+        if _, err := io.ReadFull(br, name); err != nil {
+            return nil, nil, fmt.Errorf("reading shard %d's name: %v", i, err)
+        }
+        names[i] = string(name)
+    }
+    if _, err := _shardNamesUnique(names); err != nil {
+        return nil, nil, err
+    }
+
+    numPrefixes := 1 << uint(prefixBits)
+    storage := &ShardedStorage{
+        numLevels:  int(numLevels),
+        prefixBits: int(prefixBits),
+        assignment: make([]int, numPrefixes),
+        roots:      make([][32]byte, numPrefixes),
+    }
+    for prefix := 0; prefix < numPrefixes; prefix++ {
+        var shard uint16
+        if err := binary.Read(br, binary.BigEndian, &shard); err != nil {
+            return nil, nil, fmt.Errorf("reading shard of subtree %d: %v", prefix, err)
+        }
+        if int(shard) >= len(names) {
+            return nil, nil, fmt.Errorf("subtree %d is on shard %d, but there are only %d shards", prefix, shard, len(names))
+        }
+        storage.assignment[prefix] = int(shard)
+        if _, err := io.ReadFull(br, storage.roots[prefix][:]); err != nil {
+            return nil, nil, fmt.Errorf("reading root of subtree %d: %v", prefix, err)
+        }
+    }
+    return storage, names, nil
+}
+
+/**
+ * Encodes the subtree with the specified prefix, given the LNs of its nodes on every level from
+ * 'prefixBits' down.
+ */
+func (tree *Tree) _encodeSubtree(prefixBits int, prefix int, levels [][][32]byte) []byte {
+    var buf bytes.Buffer
+    buf.WriteString(subtreeMagic)
+    buf.WriteByte(subtreeVersion)
+    binary.Write(&buf, binary.BigEndian, uint16(tree.numLevels))
+    buf.WriteByte(byte(prefixBits))
+    binary.Write(&buf, binary.BigEndian, uint32(prefix))
+
+    for i, nodeNos := range levels {
+        level := prefixBits + i
+        _sortHashes(nodeNos)
+
+        binary.Write(&buf, binary.BigEndian, uint64(len(nodeNos)))
+        for _, nodeNo := range nodeNos {
+            node := tree.lvl[level].node[nodeNo]
+            var flags byte
+            if tree.lvl[level].isNew(nodeNo) {
+                flags |= snapshotFlagNew
+            }
+
+            buf.Write(nodeNo[32-_lnNumBytes(level):])
+            buf.WriteByte(flags)
+            buf.Write(node.Hash[:])
+        }
+    }
+    return buf.Bytes()
+}
+
+/**
+ * Adds the nodes of an encoded subtree to the tree, below its root, which the tree must already
+ * have. Checks that the subtree is the one with the specified prefix and root.
+ */
+func (tree *Tree) _decodeSubtree(data []byte, prefixBits int, prefix int, root [32]byte) error {
+    r := bytes.NewReader(data)
+
+    magic := make([]byte, len(subtreeMagic))
+    if _, err := io.ReadFull(r, magic); err != nil || string(magic) != subtreeMagic {
+        return errors.New("not a subtree")
+    }
+    var header struct {
+        Version    byte
+        NumLevels  uint16
+        PrefixBits byte
+        Prefix     uint32
+    }
+    if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+        return fmt.Errorf("reading header: %v", err)
+    }
+    if header.Version != subtreeVersion {
+        return fmt.Errorf("unsupported subtree version %d", header.Version)
+    }
+    if int(header.NumLevels) != tree.numLevels || int(header.PrefixBits) != prefixBits || int64(header.Prefix) != int64(prefix) {
+        return fmt.Errorf("got subtree %d of a tree with %d levels, rooted at level %d", header.Prefix, header.NumLevels, header.PrefixBits)
+    }
+
+    for level := prefixBits; level < tree.numLevels; level++ {
+        var numNodes uint64
+        if err := binary.Read(r, binary.BigEndian, &numNodes); err != nil {
+            return fmt.Errorf("reading # of nodes on level %d: %v", level, err)
+        }
+        recordSize := uint64(_lnNumBytes(level) + 1 + 32)
+        if numNodes > uint64(r.Len())/recordSize {
+            return fmt.Errorf("level %d claims %d nodes but only %d bytes are left", level, numNodes, r.Len())
+        }
+        if level == prefixBits && numNodes != 1 {
+            return fmt.Errorf("the subtree has %d roots", numNodes)
+        }
+
+        var prevNo [32]byte
+        // The records are all in 'data' (see above), so reading them cannot fail
+        for i := uint64(0); i < numNodes; i++ {
+            var nodeNo [32]byte
+            r.Read(nodeNo[32-_lnNumBytes(level):])
+            flags, _ := r.ReadByte()
+            var hash [32]byte
+            r.Read(hash[:])
+
+            if !_fitsInLevel(nodeNo, level) || _subtreePrefix(nodeNo, level, prefixBits) != prefix {
+                return fmt.Errorf("node %d on level %d is not in the subtree", i, level)
+            }
+            if i > 0 && bytes.Compare(prevNo[:], nodeNo[:]) >= 0 {
+                return fmt.Errorf("nodes on level %d are not sorted", level)
+            }
+            prevNo = nodeNo
+
+            // The root is already in the top levels
+            if level == prefixBits {
+                if hash != root {
+                    return errors.New("the subtree's root hash does not match")
+                }
+                continue
+            }
+
+            node := tree.lvl[level].newNode()
+            node.Hash = hash
+            tree._putNode(tree.lvl[level], nodeNo, node)
+            if flags&snapshotFlagNew != 0 {
+                tree.lvl[level].markNew(nodeNo)
+            }
+        }
+    }
+
+    if r.Len() != 0 {
+        return fmt.Errorf("%d trailing bytes after subtree", r.Len())
+    }
+    return nil
+}
+
+/**
+ * Returns the prefix of the subtree rooted at level 'prefixBits' that has the node with LN
+ * 'nodeNo' on level 'level' (at least 'prefixBits').
+ */
+func _subtreePrefix(nodeNo [32]byte, level int, prefixBits int) int {
+    prefixNo := rshBytes(nodeNo, uint(level-prefixBits))
+    return int(binary.BigEndian.Uint32(prefixNo[28:]))
+}
+
+/**
+ * Returns the LN of the root of the subtree with the specified prefix.
+ */
+func _subtreeRootNo(prefix int) [32]byte {
+    var rootNo [32]byte
+    binary.BigEndian.PutUint32(rootNo[28:], uint32(prefix))
+    return rootNo
+}
+
+/**
+ * Returns the assignment of 'numPrefixes' prefixes to the shards named 'names', giving each shard
+ * the same # of prefixes (give or take one). Prefixes stay on their shard in 'oldAssignment' (of
+ * the shards named 'oldNames'), if any, as long as it is in 'names' and has room; the other ones
+ * fill up the shards in order, so that a new storage's shards get contiguous ranges of prefixes.
+ */
+func _balancedAssignment(numPrefixes int, oldAssignment []int, oldNames []string, names []string) []int {
+    shardOf := make(map[string]int)
+    share := make([]int, len(names))
+    for i, name := range names {
+        shardOf[name] = i
+        share[i] = numPrefixes / len(names)
+        if i < numPrefixes%len(names) {
+            share[i]++
+        }
+    }
+
+    assignment := make([]int, numPrefixes)
+    numAssigned := make([]int, len(names))
+    for prefix := range assignment {
+        assignment[prefix] = -1
+        if oldAssignment == nil {
+            continue
+        }
+        if shard, ok := shardOf[oldNames[oldAssignment[prefix]]]; ok && numAssigned[shard] < share[shard] {
+            assignment[prefix] = shard
+            numAssigned[shard]++
+        }
+    }
+
+    shard := 0
+    for prefix := range assignment {
+        if assignment[prefix] != -1 {
+            continue
+        }
+        for numAssigned[shard] == share[shard] {
+            shard++
+        }
+        assignment[prefix] = shard
+        numAssigned[shard]++
+    }
+    return assignment
+}
+
+func _shardNames(shards []ShardStore) ([]string, error) {
+    if len(shards) == 0 || len(shards) > maxNumShards {
+        return nil, fmt.Errorf("there must be between 1 and %d shards, not %d", maxNumShards, len(shards))
+    }
+    names := make([]string, len(shards))
+    for i, shard := range shards {
+        names[i] = shard.Name()
+    }
+    return _shardNamesUnique(names)
+}
+
+func _shardNamesUnique(names []string) ([]string, error) {
+    sorted := append([]string(nil), names...)
+    sort.Strings(sorted)
+    for i, name := range sorted {
+        if name == "" || len(name) > 1<<16-1 {
+            return nil, fmt.Errorf("invalid shard name '%s'", name)
+        }
+        if i > 0 && sorted[i-1] == name {
+            return nil, fmt.Errorf("shard '%s' is listed twice", name)
+        }
+    }
+    return names, nil
+}
+
+/**
+ * A ShardStore that keeps every subtree in a file of a local directory, e.g., on its own disk.
+ */
+type DirShardStore struct {
+    dir string
+}
+
+/**
+ * Opens (or creates) the directory 'dir' as a shard, named after the directory.
+ */
+func OpenDirShardStore(dir string) (ShardStore, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    return &DirShardStore{dir: dir}, nil
+}
+
+func (store *DirShardStore) Name() string {
+    return store.dir
+}
+
+func (store *DirShardStore) _path(prefix int, root [32]byte) string {
+    return filepath.Join(store.dir, _subtreeObjectName(prefix, root))
+}
+
+func (store *DirShardStore) ReadSubtree(prefix int, root [32]byte) ([]byte, error) {
+    return ioutil.ReadFile(store._path(prefix, root))
+}
+
+/**
+ * Writes the subtree to a temporary file and renames it, so a failed write leaves no partial file.
+ */
+func (store *DirShardStore) WriteSubtree(prefix int, root [32]byte, data []byte) error {
+    path := store._path(prefix, root)
+    if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+        os.Remove(path + ".tmp")
+        return err
+    }
+    return os.Rename(path+".tmp", path)
+}
+
+func (store *DirShardStore) DeleteSubtree(prefix int, root [32]byte) error {
+    if err := os.Remove(store._path(prefix, root)); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,50 @@
+package aomt
+
+import (
+    "fmt"
+)
+
+/**
+ * Big trees have millions of nodes and, when every node is allocated on its own, the GC has to
+ * track millions of heap objects, which shows up as GC time in the benchmarks. A tree can instead
+ * allocate the nodes of each level from slabs (i.e., slices) of TreeOptions::SlabSize nodes, so
+ * that there are SlabSize times fewer objects. The per-level maps still point to the nodes, which
+ * are just elements of the slabs.
+ *
+ * NOTE: A slab is only freed once none of its nodes are referenced, so the nodes dropped by
+ * Tree::Prune() only free memory if their whole slab is dropped. Proof trees, whose nodes are
+ * dropped by _compressProofTree(), never use slabs.
+ */
+
+// The largest slab size, so that a mistyped size does not allocate gigabytes for every level
+const maxSlabSize = 1 << 20
+
+/**
+ * Allocates a new node on this level, from its slab if the tree uses slabs.
+ */
+func (lvl *TreeLevel) newNode() *Node {
+    if lvl.slabSize == 0 {
+        return new(Node)
+    }
+
+    if len(lvl.slab) == cap(lvl.slab) {
+        lvl.slab = make([]Node, 0, lvl.slabSize)
+    }
+    lvl.slab = lvl.slab[:len(lvl.slab)+1]
+    return &lvl.slab[len(lvl.slab)-1]
+}
+
+/**
+ * Makes every level allocate its nodes from slabs of 'slabSize' nodes, or one by one if 0.
+ */
+func (tree *Tree) _useSlabs(slabSize int) error {
+    if slabSize < 0 || slabSize > maxSlabSize {
+        return fmt.Errorf("slabs must have between 0 and %d nodes, not %d", maxSlabSize, slabSize)
+    }
+
+    for _, lvl := range tree.lvl {
+        lvl.slabSize = slabSize
+        lvl.slab = nil
+    }
+    return nil
+}
@@ -0,0 +1,354 @@
+package aomt
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "sort"
+    "time"
+)
+
+/**
+ * A snapshot is a deterministic serialization of a tree: the same tree always gets the same
+ * bytes, so snapshots can be reloaded instead of rebuilding a big tree, and diffed between two
+ * implementations. The encoding is:
+ *
+ *   magic        ("AOMTSNAP", 8 bytes)
+ *   version      (1 byte, currently 2)
+ *   numLevels    (2 bytes)
+ *   for each level, from the root to the leaves:
+ *     numNodes   (8 bytes), followed by numNodes nodes sorted by LN, each encoded as:
+ *       LN       (ceil(level / 8) bytes, as in AppendOnlyProof)
+ *       flags    (1 byte: bit 0 is set for 'new' nodes (see TreeLevel::newNodes), bit 1 for pruned ones (see Tree::Prune()))
+ *       hash     (32 bytes)
+ *   numHistory   (8 bytes), followed by the version chains of the updated leaves, sorted by leaf:
+ *     leafNo     (32 bytes)
+ *     numVersions(4 bytes), followed by numVersions 32-byte data hashes
+ *   numEpochs    (8 bytes), followed by the epochs (see RecordEpoch()), each encoded as:
+ *     root       (32 bytes)
+ *     timestamp  (8 bytes, in Unix nanoseconds, see EpochHeader)
+ *     changes
+ *   pending changes, i.e., the changes since the last epoch
+ *
+ * where a list of changes is encoded as numChanges (8 bytes), followed by the changes in the
+ * order they were made, each encoded as flags (1 byte: bit 0 is set for updates), leafNo (32
+ * bytes) and dataHash (32 bytes). All integers are big-endian. Version 1 snapshots, whose epochs
+ * have no timestamps, can still be loaded, with all timestamps set to the Unix epoch.
+ *
+ * NOTE: The tree's options are not stored, so snapshots of trees created via NewTreeWithHash() or
+ * NewTreeWithOptions() must be loaded via LoadSnapshotWithOptions() with the same options. The
+ * past versions of versioned trees are not stored either, so a versioned tree loaded from a
+ * snapshot can only be queried as of the last epoch and later ones.
+ */
+const snapshotMagic = "AOMTSNAP"
+const snapshotVersion = 2
+
+const (
+    snapshotFlagNew    = 1 << 0
+    snapshotFlagPruned = 1 << 1
+    snapshotFlagUpdate = 1 << 0
+)
+
+/**
+ * Writes a snapshot of the tree to 'w'. The tree cannot have an open epoch.
+ */
+func (tree *Tree) Snapshot(w io.Writer) error {
+    return tree.SnapshotContext(context.Background(), w)
+}
+
+/**
+ * Like Snapshot(), but stops if the context is canceled, returning a ProgressError that counts the
+ * levels that were written. What was written to 'w' is then not a valid snapshot.
+ */
+func (tree *Tree) SnapshotContext(ctx context.Context, w io.Writer) error {
+    return tree._snapshotTop(ctx, w, tree.numLevels)
+}
+
+/**
+ * Like SnapshotContext(), but only writes the nodes of the top 'numTopLevels' levels, as if the
+ * levels below were empty, for ShardedStorage, which stores their nodes elsewhere.
+ */
+func (tree *Tree) _snapshotTop(ctx context.Context, w io.Writer, numTopLevels int) error {
+    const op = "writing snapshot"
+
+    if tree.open != nil {
+        return errors.New("cannot snapshot a tree with an open epoch")
+    }
+
+    bw := bufio.NewWriter(w)
+    bw.WriteString(snapshotMagic)
+    bw.WriteByte(snapshotVersion)
+    binary.Write(bw, binary.BigEndian, uint16(tree.numLevels))
+
+    for level := 0; level < tree.numLevels; level++ {
+        if err := _checkCanceled(ctx, op, "levels", level, tree.numLevels); err != nil {
+            return err
+        }
+
+        lvlNodes := tree.lvl[level].node
+        var nodeNos [][32]byte
+        if level < numTopLevels {
+            nodeNos = tree.lvl[level].sortedNodeNos()
+        }
+
+        binary.Write(bw, binary.BigEndian, uint64(len(nodeNos)))
+        for i, nodeNo := range nodeNos {
+            if i%cancelCheckInterval == 0 {
+                if err := _checkCanceled(ctx, op, "levels", level, tree.numLevels); err != nil {
+                    return err
+                }
+            }
+
+            node := lvlNodes[nodeNo]
+            var flags byte
+            if tree.lvl[level].isNew(nodeNo) {
+                flags |= snapshotFlagNew
+            }
+            if node.Pruned {
+                flags |= snapshotFlagPruned
+            }
+
+            bw.Write(nodeNo[32-_lnNumBytes(level):])
+            bw.WriteByte(flags)
+            bw.Write(node.Hash[:])
+        }
+    }
+
+    leaves := make([][32]byte, 0, len(tree.history))
+    for leafNo := range tree.history {
+        leaves = append(leaves, leafNo)
+    }
+    _sortHashes(leaves)
+
+    binary.Write(bw, binary.BigEndian, uint64(len(leaves)))
+    for _, leafNo := range leaves {
+        versions := tree.history[leafNo]
+        bw.Write(leafNo[:])
+        binary.Write(bw, binary.BigEndian, uint32(len(versions)))
+        for _, dataHash := range versions {
+            bw.Write(dataHash[:])
+        }
+    }
+
+    binary.Write(bw, binary.BigEndian, uint64(len(tree.epochs)))
+    for _, epoch := range tree.epochs {
+        bw.Write(epoch.root[:])
+        binary.Write(bw, binary.BigEndian, epoch.timestamp)
+        _writeSnapshotChanges(bw, epoch.changes)
+    }
+    _writeSnapshotChanges(bw, tree.pending)
+
+    // bufio.Writer remembers the first write error, so we only need to check it here
+    return bw.Flush()
+}
+
+func _writeSnapshotChanges(bw *bufio.Writer, changes []epochLeaf) {
+    binary.Write(bw, binary.BigEndian, uint64(len(changes)))
+    for _, change := range changes {
+        var flags byte
+        if change.isUpdate {
+            flags |= snapshotFlagUpdate
+        }
+
+        bw.WriteByte(flags)
+        bw.Write(change.leafNo[:])
+        bw.Write(change.dataHash[:])
+    }
+}
+
+func _sortHashes(hashes [][32]byte) {
+    sort.Slice(hashes, func(i, j int) bool {
+        return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+    })
+}
+
+/**
+ * Loads a tree that uses SHA256 (i.e., created via NewTree()) from a snapshot.
+ */
+func LoadSnapshot(r io.Reader) (*Tree, error) {
+    return LoadSnapshotWithHash(r, sha256.New)
+}
+
+/**
+ * Loads a tree that uses the specified hash function (i.e., created via NewTreeWithHash()) from
+ * a snapshot.
+ */
+func LoadSnapshotWithHash(r io.Reader, newHash func() hash.Hash) (*Tree, error) {
+    return LoadSnapshotWithOptions(r, TreeOptions{NewHash: newHash})
+}
+
+/**
+ * Loads a tree created via NewTreeWithOptions() with the specified options from a snapshot.
+ *
+ * NOTE: The node hashes are not recomputed, so a corrupted snapshot can give a tree whose hashes
+ * do not match its leaves.
+ */
+func LoadSnapshotWithOptions(r io.Reader, opts TreeOptions) (*Tree, error) {
+    br := bufio.NewReader(r)
+
+    magic := make([]byte, len(snapshotMagic))
+    if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+        return nil, errors.New("not a tree snapshot")
+    }
+    version, err := br.ReadByte()
+    if err != nil {
+        return nil, fmt.Errorf("reading snapshot version: %v", err)
+    }
+    if version != 1 && version != snapshotVersion {
+        return nil, fmt.Errorf("unsupported snapshot version %d", version)
+    }
+
+    var numLevels uint16
+    if err := binary.Read(br, binary.BigEndian, &numLevels); err != nil {
+        return nil, fmt.Errorf("reading # of levels: %v", err)
+    }
+    if numLevels < 2 || numLevels > maxNumLevels {
+        return nil, fmt.Errorf("invalid # of levels %d", numLevels)
+    }
+
+    tree, err := NewTreeWithOptions(int(numLevels), opts)
+    if err != nil {
+        return nil, err
+    }
+
+    for level := 0; level < tree.numLevels; level++ {
+        var numNodes uint64
+        if err := binary.Read(br, binary.BigEndian, &numNodes); err != nil {
+            return nil, fmt.Errorf("reading # of nodes on level %d: %v", level, err)
+        }
+
+        var prevNo [32]byte
+        for i := uint64(0); i < numNodes; i++ {
+            var nodeNo [32]byte
+            if _, err := io.ReadFull(br, nodeNo[32-_lnNumBytes(level):]); err != nil {
+                return nil, fmt.Errorf("reading LN of node %d on level %d: %v", i, level, err)
+            }
+            if !_fitsInLevel(nodeNo, level) {
+                return nil, fmt.Errorf("node %d on level %d has an LN that is too large", i, level)
+            }
+            if i > 0 && bytes.Compare(prevNo[:], nodeNo[:]) >= 0 {
+                return nil, fmt.Errorf("nodes on level %d are not sorted", level)
+            }
+            prevNo = nodeNo
+
+            flags, err := br.ReadByte()
+            if err != nil {
+                return nil, fmt.Errorf("reading flags of node %d on level %d: %v", i, level, err)
+            }
+
+            node := tree.lvl[level].newNode()
+            if flags&snapshotFlagNew != 0 {
+                tree.lvl[level].markNew(nodeNo)
+            }
+            node.Pruned = flags&snapshotFlagPruned != 0
+            if node.Pruned {
+                tree.numPruned++
+            }
+            if _, err := io.ReadFull(br, node.Hash[:]); err != nil {
+                return nil, fmt.Errorf("reading hash of node %d on level %d: %v", i, level, err)
+            }
+            tree._putNode(tree.lvl[level], nodeNo, node)
+        }
+    }
+
+    var numHistory uint64
+    if err := binary.Read(br, binary.BigEndian, &numHistory); err != nil {
+        return nil, fmt.Errorf("reading # of version chains: %v", err)
+    }
+    for i := uint64(0); i < numHistory; i++ {
+        var leafNo [32]byte
+        var numVersions uint32
+        if _, err := io.ReadFull(br, leafNo[:]); err != nil {
+            return nil, fmt.Errorf("reading leaf of version chain %d: %v", i, err)
+        }
+        if err := binary.Read(br, binary.BigEndian, &numVersions); err != nil {
+            return nil, fmt.Errorf("reading # of versions of version chain %d: %v", i, err)
+        }
+
+        var versions [][32]byte
+        for j := uint32(0); j < numVersions; j++ {
+            var dataHash [32]byte
+            if _, err := io.ReadFull(br, dataHash[:]); err != nil {
+                return nil, fmt.Errorf("reading version %d of version chain %d: %v", j, i, err)
+            }
+            versions = append(versions, dataHash)
+        }
+        tree.history[leafNo] = versions
+    }
+
+    var numEpochs uint64
+    if err := binary.Read(br, binary.BigEndian, &numEpochs); err != nil {
+        return nil, fmt.Errorf("reading # of epochs: %v", err)
+    }
+    for i := uint64(0); i < numEpochs; i++ {
+        epoch := &epochRecord{}
+        if _, err := io.ReadFull(br, epoch.root[:]); err != nil {
+            return nil, fmt.Errorf("reading root of epoch %d: %v", i, err)
+        }
+        var timestamp int64
+        if version >= 2 {
+            if err := binary.Read(br, binary.BigEndian, &timestamp); err != nil {
+                return nil, fmt.Errorf("reading timestamp of epoch %d: %v", i, err)
+            }
+        }
+        if epoch.changes, err = _readSnapshotChanges(br); err != nil {
+            return nil, fmt.Errorf("reading changes of epoch %d: %v", i, err)
+        }
+        tree._linkEpochHeader(epoch, time.Unix(0, timestamp))
+        tree.epochs = append(tree.epochs, epoch)
+        tree.rootLog.Append(epoch.root)
+        tree._indexInsertions(len(tree.epochs)-1, epoch.changes)
+    }
+    if tree.pending, err = _readSnapshotChanges(br); err != nil {
+        return nil, fmt.Errorf("reading pending changes: %v", err)
+    }
+
+    if tree.numPruned > 0 {
+        if tree.versions != nil {
+            return nil, errors.New("cannot load a pruned snapshot as a versioned tree")
+        }
+        tree._indexPrunedLeaves()
+    }
+    if tree.versions != nil {
+        tree._seedVersions()
+    }
+
+    if _, err := br.ReadByte(); err != io.EOF {
+        return nil, errors.New("trailing bytes after snapshot")
+    }
+    if len(tree.pending) == 0 && len(tree.epochs) > 0 && tree.epochs[len(tree.epochs)-1].root != tree.GetRootHash() {
+        return nil, errors.New("the root hash does not match the last epoch's root hash")
+    }
+    return tree, nil
+}
+
+func _readSnapshotChanges(br *bufio.Reader) ([]epochLeaf, error) {
+    var numChanges uint64
+    if err := binary.Read(br, binary.BigEndian, &numChanges); err != nil {
+        return nil, err
+    }
+
+    var changes []epochLeaf
+    for i := uint64(0); i < numChanges; i++ {
+        flags, err := br.ReadByte()
+        if err != nil {
+            return nil, err
+        }
+
+        change := epochLeaf{isUpdate: flags&snapshotFlagUpdate != 0}
+        if _, err := io.ReadFull(br, change.leafNo[:]); err != nil {
+            return nil, err
+        }
+        if _, err := io.ReadFull(br, change.dataHash[:]); err != nil {
+            return nil, err
+        }
+        changes = append(changes, change)
+    }
+    return changes, nil
+}
@@ -0,0 +1,87 @@
+package aomt
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+)
+
+/**
+ * Returns the snapshot of the tree.
+ */
+func _testSnapshot(t *testing.T, tree *Tree) []byte {
+    var buf bytes.Buffer
+    if err := tree.Snapshot(&buf); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+/**
+ * A loaded snapshot must be the same tree, i.e., have the same root, epochs and version chains,
+ * prove the same and snapshot to the same bytes.
+ */
+func TestSnapshotRoundTrip(t *testing.T) {
+    tree := _testEpochTree(4)
+    snapshot := _testSnapshot(t, tree)
+    if !bytes.Equal(snapshot, _testSnapshot(t, tree)) {
+        t.Fatal("snapshots of the same tree differ")
+    }
+
+    loaded, err := LoadSnapshot(bytes.NewReader(snapshot))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if loaded.GetRootHash() != tree.GetRootHash() {
+        t.Errorf("loaded tree has root %s, expected %s", hashStr(loaded.GetRootHash()), hashStr(tree.GetRootHash()))
+    }
+    if loaded.NumEpochs() != tree.NumEpochs() || loaded.NumPendingChanges() != tree.NumPendingChanges() {
+        t.Fatalf("loaded tree has %d epochs and %d pending changes, expected %d and %d",
+            loaded.NumEpochs(), loaded.NumPendingChanges(), tree.NumEpochs(), tree.NumPendingChanges())
+    }
+    for e := 0; e < tree.NumEpochs(); e++ {
+        if loaded.GetEpochRoot(e) != tree.GetEpochRoot(e) {
+            t.Errorf("loaded tree has another root for epoch %d", e)
+        }
+    }
+    for i := 0; i < 80; i += 7 {
+        leafNo := _testLeaf(i).LeafNo
+        if !reflect.DeepEqual(loaded.GetHistory(leafNo), tree.GetHistory(leafNo)) {
+            t.Errorf("loaded tree has another history for leaf %d", i)
+        }
+    }
+    if !bytes.Equal(_testSnapshot(t, loaded), snapshot) {
+        t.Errorf("loaded tree snapshots to other bytes")
+    }
+
+    proof := loaded.ProveAppendOnly(0, loaded.NumEpochs()-1)
+    if !VerifyAppendOnlyProof(proof, tree.GetEpochRoot(0), tree.GetEpochRoot(tree.NumEpochs()-1)) {
+        t.Errorf("append-only proof of the loaded tree does not verify")
+    }
+}
+
+/**
+ * Truncated snapshots, snapshots with trailing bytes and snapshots whose nodes were tampered with
+ * must not load.
+ */
+func TestLoadSnapshotRejectsMalformed(t *testing.T) {
+    tree := _testEpochTree(2)
+    tree.RecordEpoch()
+    snapshot := _testSnapshot(t, tree)
+
+    for n := 0; n < len(snapshot); n += len(snapshot)/16 + 1 {
+        if _, err := LoadSnapshot(bytes.NewReader(snapshot[:n])); err == nil {
+            t.Errorf("snapshot truncated to %d of %d bytes loads", n, len(snapshot))
+        }
+    }
+    padded := append(append([]byte{}, snapshot...), 0)
+    if _, err := LoadSnapshot(bytes.NewReader(padded)); err == nil {
+        t.Errorf("snapshot with a trailing byte loads")
+    }
+    // The root's hash is right after its LN (no bytes on level 0) and flags
+    tampered := append([]byte{}, snapshot...)
+    tampered[len(snapshotMagic)+1+2+8+1] ^= 1
+    if _, err := LoadSnapshot(bytes.NewReader(tampered)); err == nil {
+        t.Errorf("snapshot with the root's hash flipped loads")
+    }
+}
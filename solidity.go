@@ -0,0 +1,357 @@
+package aomt
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash"
+    "reflect"
+    "strings"
+    "text/template"
+)
+
+/**
+ * Append-only proofs that a smart contract can check, so that a contract which only stores the
+ * dictionary's epoch roots can refuse any new root that is not an append-only extension of the
+ * last one (see SolidityVerifier(), which generates such a contract).
+ *
+ * The proof's nodes are sent as one 'bytes' argument, in the order of AppendOnlyProof::WriteStream()
+ * (i.e., from left to right), so the contract can check them with a stack of at most one node per
+ * level, as AppendOnlyVerifier does. Each node is abi.encodePacked(level, nodeNo, isNew,
+ * numAppended, hash), i.e.:
+ *
+ *   level        (uint16, 2 bytes)
+ *   nodeNo       (uint256, 32 bytes, the node's LN)
+ *   isNew        (bool, 1 byte)
+ *   numAppended  (uint32, 4 bytes)
+ *   hash         (bytes32, 32 bytes)
+ *
+ * so all nodes have the same size, which saves the contract from parsing. The hashes appended to
+ * updated leaves (see ProofNode::Appended) are sent separately, as a 'bytes32[]' argument, in the
+ * order of their nodes.
+ *
+ * Contracts can hash with SHA256, via its precompile, but keccak256 is much cheaper, so trees meant
+ * to be checked on-chain should hash nodes with NewKeccak256().
+ */
+const solidityNodeSize = 2 + 32 + 1 + 4 + 32
+
+var keccak256NewPtr = reflect.ValueOf(NewKeccak256).Pointer()
+
+/**
+ * Returns the Solidity function that hashes nodes the same way as 'newHash', or an error if it is
+ * neither SHA256 nor Keccak-256.
+ */
+func _solidityHashFunc(newHash func() hash.Hash) (string, error) {
+    switch ptr := reflect.ValueOf(newHash).Pointer(); {
+    case newHash == nil || ptr == sha256NewPtr:
+        return "sha256", nil
+    case ptr == keccak256NewPtr:
+        return "keccak256", nil
+    }
+    return "", errors.New("contracts can only check trees that hash nodes with SHA256 or Keccak-256")
+}
+
+/**
+ * Encodes the proof for a contract, returning its nodes and its appended hashes. The proof must be
+ * compressed, like the ones returned by Epoch::Commit() and Tree::ProveAppendOnly().
+ */
+func (proof *AppendOnlyProof) MarshalSolidity() ([]byte, [][32]byte) {
+    nodes := bytes.NewBuffer(make([]byte, 0, len(proof.Nodes)*solidityNodeSize))
+    var appended [][32]byte
+    for _, pn := range proof._preOrderNodes() {
+        binary.Write(nodes, binary.BigEndian, uint16(pn.Level))
+        nodes.Write(pn.NodeNo[:])
+        if pn.IsNew {
+            nodes.WriteByte(1)
+        } else {
+            nodes.WriteByte(0)
+        }
+        binary.Write(nodes, binary.BigEndian, uint32(len(pn.Appended)))
+        nodes.Write(pn.Hash[:])
+
+        appended = append(appended, pn.Appended...)
+    }
+    return nodes.Bytes(), appended
+}
+
+/**
+ * Decodes a proof encoded via MarshalSolidity().
+ */
+func UnmarshalSolidity(nodes []byte, appended [][32]byte) (*AppendOnlyProof, error) {
+    if len(nodes)%solidityNodeSize != 0 {
+        return nil, fmt.Errorf("proof nodes take %d bytes, which is not a multiple of %d", len(nodes), solidityNodeSize)
+    }
+
+    proof := &AppendOnlyProof{Nodes: make([]ProofNode, len(nodes)/solidityNodeSize)}
+    for i := range proof.Nodes {
+        record := nodes[i*solidityNodeSize : (i+1)*solidityNodeSize]
+        pn := &proof.Nodes[i]
+        pn.Level = int(binary.BigEndian.Uint16(record))
+        copy(pn.NodeNo[:], record[2:34])
+        switch record[34] {
+        case 0:
+        case 1:
+            pn.IsNew = true
+        default:
+            return nil, fmt.Errorf("proof node %d has invalid isNew byte %d", i, record[34])
+        }
+        numAppended := binary.BigEndian.Uint32(record[35:39])
+        copy(pn.Hash[:], record[39:])
+
+        if uint64(numAppended) > uint64(len(appended)) {
+            return nil, fmt.Errorf("proof node %d has %d appended hashes, but only %d are left", i, numAppended, len(appended))
+        }
+        if numAppended > 0 {
+            pn.Appended = appended[:numAppended]
+            appended = appended[numAppended:]
+        }
+    }
+    if len(appended) > 0 {
+        return nil, fmt.Errorf("%d appended hashes are not used by any proof node", len(appended))
+    }
+    return proof, nil
+}
+
+/**
+ * Checks a proof encoded via MarshalSolidity() exactly like the contract from SolidityVerifier()
+ * does, for trees created via NewTreeWithOptions() with the specified options.
+ */
+func VerifySolidityProof(nodes []byte, appended [][32]byte, oldRoot [32]byte, newRoot [32]byte, opts TreeOptions) error {
+    proof, err := UnmarshalSolidity(nodes, appended)
+    if err != nil {
+        return err
+    }
+    verifier, err := NewAppendOnlyVerifierWithOptions(oldRoot, newRoot, opts)
+    if err != nil {
+        return err
+    }
+
+    for i := range proof.Nodes {
+        pn := &proof.Nodes[i]
+        if len(pn.Appended) > 0 && (pn.IsNew || pn.Level != verifier.leafLevel) {
+            return fmt.Errorf("proof node %s on level %d cannot have appended hashes", hashStr(pn.NodeNo), pn.Level)
+        }
+        if err := verifier.Add(pn); err != nil {
+            return err
+        }
+    }
+    return verifier.Finish()
+}
+
+/**
+ * Returns the calldata of a call to the contract's appendRoot(newRoot, nodes, appended), which
+ * moves the contract from the root the proof starts at to 'newRoot'. The calldata is ABI-encoded,
+ * so it can be sent as is in a transaction's data field.
+ */
+func SolidityCalldata(newRoot [32]byte, proof *AppendOnlyProof) []byte {
+    nodes, appended := proof.MarshalSolidity()
+    selector := Keccak256([]byte("appendRoot(bytes32,bytes,bytes32[])"))
+
+    // The head has the static argument and the offsets of the two dynamic ones, relative to the
+    // start of the arguments
+    paddedNodesLen := (len(nodes) + 31) / 32 * 32
+    nodesOffset := 3 * 32
+    appendedOffset := nodesOffset + 32 + paddedNodesLen
+
+    buf := bytes.NewBuffer(make([]byte, 0, 4+appendedOffset+32+32*len(appended)))
+    buf.Write(selector[:4])
+    buf.Write(newRoot[:])
+    buf.Write(_abiUint256(uint64(nodesOffset)))
+    buf.Write(_abiUint256(uint64(appendedOffset)))
+
+    buf.Write(_abiUint256(uint64(len(nodes))))
+    buf.Write(nodes)
+    buf.Write(make([]byte, paddedNodesLen-len(nodes)))
+
+    buf.Write(_abiUint256(uint64(len(appended))))
+    for _, h := range appended {
+        buf.Write(h[:])
+    }
+    return buf.Bytes()
+}
+
+/**
+ * Returns 'n' as an ABI-encoded uint256.
+ */
+func _abiUint256(n uint64) []byte {
+    var word [32]byte
+    binary.BigEndian.PutUint64(word[24:], n)
+    return word[:]
+}
+
+/**
+ * Returns the Solidity source of a contract that stores the epoch roots of a tree with the
+ * specified options, starting from a root given to its constructor. Only the account that deployed
+ * it can append roots, and only with a valid append-only proof from the last root (see
+ * SolidityCalldata()). Its verifyAppendOnly() function can also be called on its own, and reverts
+ * with the reason a proof is invalid.
+ */
+func SolidityVerifier(opts TreeOptions) (string, error) {
+    numLevels, err := opts._numLevels()
+    if err != nil {
+        return "", err
+    }
+    hashFunc, err := _solidityHashFunc(opts.NewHash)
+    if err != nil {
+        return "", err
+    }
+    if opts.HashingScheme != HashingPlain {
+        return "", fmt.Errorf("the Solidity verifier only supports the plain hashing scheme, not '%v'", opts.HashingScheme)
+    }
+
+    // RFC 6962 trees prefix internal nodes with 0x01
+    nodePrefix := ""
+    if opts.RFC6962 {
+        nodePrefix = "bytes1(0x01), "
+    }
+
+    var src strings.Builder
+    err = solidityTemplate.Execute(&src, struct {
+        LeafLevel  int
+        NodeSize   int
+        HashFunc   string
+        NodePrefix string
+    }{numLevels - 1, solidityNodeSize, hashFunc, nodePrefix})
+    return src.String(), err
+}
+
+var solidityTemplate = template.Must(template.New("contract").Parse(`// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.5;
+
+/**
+ * Stores the epoch roots of an append-only Merkle prefix tree, and only accepts new roots with a
+ * valid append-only proof from the last one. Generated by 'amt solidity'.
+ */
+contract AppendOnlyRoots {
+    uint16 constant LEAF_LEVEL = {{.LeafLevel}};
+    uint256 constant NODE_SIZE = {{.NodeSize}};
+
+    address public immutable server;
+    bytes32[] public roots;
+
+    event RootAppended(uint256 indexed epoch, bytes32 root);
+
+    struct ProofNode {
+        uint16 level;
+        uint256 nodeNo;
+        bytes32 oldHash;
+        bytes32 newHash;
+    }
+
+    constructor(bytes32 firstRoot) {
+        server = msg.sender;
+        roots.push(firstRoot);
+        emit RootAppended(0, firstRoot);
+    }
+
+    function numRoots() external view returns (uint256) {
+        return roots.length;
+    }
+
+    function appendRoot(bytes32 newRoot, bytes calldata nodes, bytes32[] calldata appended) external {
+        require(msg.sender == server, "only the server can append roots");
+        verifyAppendOnly(roots[roots.length - 1], newRoot, nodes, appended);
+        roots.push(newRoot);
+        emit RootAppended(roots.length - 1, newRoot);
+    }
+
+    /**
+     * Reverts with the reason why the proof is not a valid append-only proof from 'oldRoot' to
+     * 'newRoot', if it is not.
+     */
+    function verifyAppendOnly(bytes32 oldRoot, bytes32 newRoot, bytes calldata nodes, bytes32[] calldata appended)
+        public pure
+    {
+        require(nodes.length % NODE_SIZE == 0, "proof nodes have an invalid length");
+        if (nodes.length == 0) {
+            require(oldRoot == newRoot && appended.length == 0, "empty append-only proof, but the roots differ");
+            return;
+        }
+
+        // The left siblings along the last node's path, waiting for their right siblings
+        ProofNode[] memory stack = new ProofNode[](uint256(LEAF_LEVEL) + 1);
+        uint256 top = 0;
+        uint256 nextAppended = 0;
+        ProofNode memory node;
+        for (uint256 off = 0; off < nodes.length; off += NODE_SIZE) {
+            // Once the root is reached (i.e., 'node' is on level 0), there can be no more nodes
+            require(off == 0 || node.level > 0, "proof has nodes after the root's subtrees");
+
+            (node, nextAppended) = _readNode(nodes[off:off + NODE_SIZE], appended, nextAppended);
+            if (top > 0) {
+                _checkRightOf(node, stack[top - 1]);
+            }
+            (node, top) = _push(stack, top, node);
+        }
+
+        require(node.level == 0, "proof is missing the right sibling of a node");
+        require(nextAppended == appended.length, "proof has unused appended hashes");
+        require(node.oldHash == oldRoot, "old root hash check failed");
+        require(node.newHash == newRoot, "new root hash check failed");
+    }
+
+    /**
+     * Decodes a node, folding its appended hashes, if any, into its new hash.
+     */
+    function _readNode(bytes calldata record, bytes32[] calldata appended, uint256 nextAppended)
+        private pure returns (ProofNode memory node, uint256)
+    {
+        uint16 level = uint16(bytes2(record[0:2]));
+        uint256 nodeNo = uint256(bytes32(record[2:34]));
+        uint8 isNew = uint8(record[34]);
+        uint32 numAppended = uint32(bytes4(record[35:39]));
+        bytes32 hash = bytes32(record[39:71]);
+
+        require(level <= LEAF_LEVEL, "proof node is below the leaves");
+        require((nodeNo >> level) == 0, "proof node has an LN that is too large for its level");
+        require(isNew <= 1, "proof node has an invalid isNew byte");
+        require(numAppended == 0 || (isNew == 0 && level == LEAF_LEVEL), "proof node cannot have appended hashes");
+
+        node = ProofNode(level, nodeNo, isNew == 1 ? bytes32(0) : hash, hash);
+        for (uint32 j = 0; j < numAppended; j++) {
+            require(nextAppended < appended.length, "proof is missing appended hashes");
+            node.newHash = _hash(node.newHash, appended[nextAppended++]);
+        }
+        return (node, nextAppended);
+    }
+
+    /**
+     * Checks that 'node' is in the subtree of the right sibling of 'left', which is on top of the
+     * stack, or else the nodes are not sorted (or overlap).
+     */
+    function _checkRightOf(ProofNode memory node, ProofNode memory left) private pure {
+        require(node.level >= left.level && (node.nodeNo >> (node.level - left.level)) == (left.nodeNo ^ 1),
+            "proof nodes are not sorted from left to right");
+    }
+
+    /**
+     * Hashes 'node' with its left siblings on the stack for as long as it is a right child, and
+     * then pushes it. Returns the node it stopped at, which is the root once all of the root's
+     * subtrees were added, and the new size of the stack.
+     */
+    function _push(ProofNode[] memory stack, uint256 top, ProofNode memory node)
+        private pure returns (ProofNode memory, uint256)
+    {
+        while (node.level > 0) {
+            if ((node.nodeNo & 1) == 0) {
+                stack[top] = node;
+                return (node, top + 1);
+            }
+
+            require(top > 0 && stack[top - 1].level == node.level, "proof is missing the left sibling of a node");
+            top--;
+            node = ProofNode(
+                node.level - 1,
+                node.nodeNo >> 1,
+                _hash(stack[top].oldHash, node.oldHash),
+                _hash(stack[top].newHash, node.newHash));
+        }
+        return (node, top);
+    }
+
+    function _hash(bytes32 left, bytes32 right) private pure returns (bytes32) {
+        return {{.HashFunc}}(abi.encodePacked({{.NodePrefix}}left, right));
+    }
+}
+`))
@@ -0,0 +1,60 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Proofs encoded for contracts must verify as they are, for both hash functions contracts support,
+ * and must not verify once any field of their nodes or any of their appended hashes is tampered
+ * with.
+ */
+func TestSolidityProofRejectsTampering(t *testing.T) {
+    for _, opts := range []TreeOptions{{}, {NewHash: NewKeccak256}} {
+        tree, proof := _testAppendOnlyProof(t, opts, 50, 30)
+        oldRoot, newRoot := tree.GetEpochRoot(0), tree.GetEpochRoot(1)
+        nodes, appended := proof.MarshalSolidity()
+        if len(appended) == 0 {
+            t.Fatalf("proof of updated leaves has no appended hashes")
+        }
+        if err := VerifySolidityProof(nodes, appended, oldRoot, newRoot, opts); err != nil {
+            t.Fatalf("proof does not verify: %v", err)
+        }
+        if err := VerifySolidityProof(nodes, appended, newRoot, oldRoot, opts); err == nil {
+            t.Errorf("proof verifies with the roots swapped")
+        }
+
+        // The fields of a node, by their offset in its record
+        fields := map[string]int{"level": 1, "LN": 33, "isNew": 34, "# of appended hashes": 38, "hash": 39}
+        numNodes := len(nodes) / solidityNodeSize
+        for i := 0; i < numNodes; i += numNodes/12 + 1 {
+            record := nodes[i*solidityNodeSize : (i+1)*solidityNodeSize]
+            for name, offset := range fields {
+                // Flipping the new flag of an empty node changes neither root
+                if name == "isNew" && string(record[39:]) == string(tree.EmptyHash[:]) {
+                    continue
+                }
+                tampered := append([]byte{}, nodes...)
+                tampered[i*solidityNodeSize+offset] ^= 1
+                if err := VerifySolidityProof(tampered, appended, oldRoot, newRoot, opts); err == nil {
+                    t.Errorf("proof with node %d's %s flipped verifies", i, name)
+                }
+            }
+        }
+
+        flipped := append([][32]byte{}, appended...)
+        flipped[0][0] ^= 1
+        cases := map[string]error{
+            "appended hash flipped": VerifySolidityProof(nodes, flipped, oldRoot, newRoot, opts),
+            "appended hash dropped": VerifySolidityProof(nodes, appended[1:], oldRoot, newRoot, opts),
+            "appended hash added":   VerifySolidityProof(nodes, append(append([][32]byte{}, appended...), [32]byte{}), oldRoot, newRoot, opts),
+            "node dropped":          VerifySolidityProof(nodes[solidityNodeSize:], appended, oldRoot, newRoot, opts),
+            "byte dropped":          VerifySolidityProof(nodes[1:], appended, oldRoot, newRoot, opts),
+        }
+        for name, err := range cases {
+            if err == nil {
+                t.Errorf("proof verifies with %s", name)
+            }
+        }
+    }
+}
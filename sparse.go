@@ -1,15 +1,20 @@
-package main
+package aomt
 
 import (
+    "crypto/hmac"
     "crypto/sha256"
+    "encoding/binary"
     "fmt"
+    "hash"
     "math/big"
-    "os"
+    "runtime"
     "time"
+
+    "./log"
 )
 
 /**
- * The tree has 257 levels, numbered from 0 to 256. Each level has 2^level nodes.
+ * The tree has 257 levels by default, numbered from 0 to 256. Each level has 2^level nodes.
  * Leaf no's are numbered from 0 to ((2^256) - 1)).
  * Globally, all nodes are assigned a global number (GN) from 1 to ((2^257) - 1).
  * (by enumerating them from the root to the bottom level in left-to-right order)
@@ -18,39 +23,126 @@ import (
  *
  * The tree is stored by levels (257 in total), from level 0 (the root node) to level 256 (the leaves).
  * Each level stores a map[[32]byte]Node dictionary, which maps the LN to the node's hash and whether it's a newly inserted/updated node.
+ *
+ * Trees can also have fewer levels (e.g., 33 or 65 for 32-bit or 64-bit key spaces), in which case
+ * leaf no's are numbered from 0 to ((2^(numLevels - 1)) - 1). LNs are still stored as 32-byte
+ * big-endian numbers, so the top bytes of the leaf no's of such trees are always zero.
  */
 
 type TreeLevel struct {
     num  int                // the level's number, numbered from 0 to numLevels - 1
     node map[[32]byte]*Node // maps a LN to its node's Node struct
+
+    // If not nil, caches the nodes of 'node' by LN (see TreeOptions::CachedLevels)
+    cache []*Node
+
+    // The slab that new nodes are allocated from and the size of new slabs, 0 if nodes are
+    // allocated one by one (see TreeOptions::SlabSize)
+    slab     []Node
+    slabSize int
+
+    /**
+     * The LNs of the level's nodes that are marked as 'new', so we can easily construct append-only
+     * proofs: only nodes created by a Tree::Insert() are marked as 'new', and not nodes whose hash
+     * is changed by the insert. After a batch of Insert()'s, the new nodes are marked and the
+     * append-only proof can be easily computed. We can then clear the marks (see
+     * Tree::_clearNewNodes()), insert a new batch, and repeat the append-only proof. Since only
+     * the batch's nodes are in here, clearing the marks takes time proportional to the batch.
+     *
+     * In proof trees, these are the proof's 'new' nodes. Nil when no node is marked.
+     */
+    newNodes map[[32]byte]struct{}
 }
 
 type Node struct {
     Hash [32]byte // the Merkle hash of this node
 
     /**
-     * Marks this node as 'new' during a Tree::Insert(), so we can easily construct append-only proofs.
-     * Only newly created nodes are marked as 'new', and not nodes whose hash is changed by the insert.
-     * After a batch of Insert()'s, the new nodes are marked and the append-only proof can be easily computed.
-     * We can then 'clear' thew 'new' flag for these nodes, insert a new batch, and repeat the append-only proof.
+     * Only used in proof trees, for leaves that already existed before the batch but were
+     * updated via Tree::Update(). 'Hash' stores the leaf's old version chain hash, while
+     * 'Appended' stores the data hashes that were appended to the leaf's chain during the batch.
      */
-    IsNew bool
+    Appended [][32]byte
+
+    // True if the interior nodes of this node's subtree were dropped by Tree::Prune()
+    Pruned bool
 }
 
 type Tree struct {
-    lvl       []*TreeLevel // the tree is just an array of 257 levels (256 + root node)
+    lvl       []*TreeLevel // the tree is just an array of numLevels levels (e.g., 256 + root node)
     numLevels int          // levels are numbered from 0 to numLevels - 1
     //numNodes int          // this is just 2^numLevels - 1
 
+    // The # of nodes on the last level, kept up to date by Tree::_putNode() (see Tree::NumLeaves())
+    numLeaves int64
+
+    // For proof trees, the # of leaves inserted into the proven tree since the proof was started
+    // (see Tree::_proofAddInserted()), or -1 if unknown
+    numNewLeaves int64
+
+    // Maps the leaf no of every leaf that was updated at least once to its list of data hashes,
+    // from the first inserted one to the latest one. Leaves that were never updated are not
+    // stored here, since their version chain consists of just the leaf hash.
+    history map[[32]byte][][32]byte
+
+    // The openings of the leaves inserted via InsertCommitted(), see Opening
+    openings map[[32]byte]*Opening
+
+    // The values of the leaves inserted via InsertValue(), see ValueHash()
+    values map[[32]byte][]byte
+
+    // The data hashes and extensions of the leaves inserted via InsertWithExtensions(), see
+    // LeafExtensions
+    extended map[[32]byte]*ExtendedLeaf
+
+    epochs  []*epochRecord // the log of recorded epochs, see RecordEpoch()
+    rootLog *log.Log       // the history tree of the recorded epochs' roots, see RootLog()
+    pending []epochLeaf    // the leaf changes since the last recorded epoch
+
+    // The epoch each leaf of a recorded epoch was first inserted in, or nil if the tree does not
+    // index them (see TreeOptions::InsertionEpochs)
+    insertedIn map[[32]byte]int
+
+    open *Epoch // the epoch started via BeginEpoch() and not committed yet, if any
+
+    subscribers []chan SignedTreeHead // the channels that get every new epoch's head, see SubscribeRoots()
+    signHead    func(TreeHead) []byte // signs the heads sent to subscribers, see SetTreeHeadSigner()
+    metrics     *Metrics              // counts the tree's changes and proofs, if not nil (see SetMetrics())
+    insertHooks []InsertHook          // called for every inserted leaf, see OnInsert()
+    archive     *ProofArchive         // archives the proofs of committed epochs, see SetProofArchive()
+
+    // The last rejected operations and the # of all rejected operations, see TryInsert()
+    rejections    []Rejection
+    numRejections int64
+
+    // The hash function used to Merkle hash nodes (SHA256 by default). Must output 32-byte digests.
+    newHash func() hash.Hash
+    rfc6962 bool           // see TreeOptions::RFC6962
+    scheme  HashingScheme  // see TreeOptions::HashingScheme
+    vrfKey  *VRFPrivateKey // see TreeOptions::VRFKey
+
+    keyMapper KeyMapper // see TreeOptions::KeyMapper
+
+    // The operator secret and what it keys, see TreeOptions::HMACKey
+    hmacKey        []byte
+    hmacLeafNos    bool
+    hmacLeafHashes bool
+
+    leafExtensions bool // see TreeOptions::LeafExtensions
+
+    proofTreeMaxNodes int // see TreeOptions::ProofTreeMaxNodes
+
+    // The versions of every (level, LN) node, oldest first, or nil if the tree is not versioned (see
+    // TreeOptions::Versioned). Past epochs can be queried starting from epoch 'versionsFrom'.
+    versions     []map[[32]byte][]nodeVersion
+    versionsFrom int
+
+    // The # of pruned subtrees and the sorted leaf nos of the tree when it was pruned (see Prune())
+    numPruned    int
+    prunedLeaves [][32]byte
+
     EmptyHash [32]byte // the hash of a non-existing node (all zeros)
     RootNo    [32]byte // the LN of the root (all zeros)
-
-    // We'll need big.Int's to represent the value 2^256 and the value 2, which we
-    // use often in our calculations so it's better to cache them here rather than
-    // allocate them all the time and trash the heap.
-    MaxLeafs *big.Int
-    Two      *big.Int
-    One      *big.Int
 }
 
 /**
@@ -64,33 +156,283 @@ func _newTreeLevel(num int) *TreeLevel {
 }
 
 /**
- * Creates a new, empty tree with a certain # of levels.
+ * Creates a new, empty tree with a certain # of levels, which uses SHA256 to hash nodes.
  */
 func NewTree(numLevels int) *Tree {
-    if numLevels != 257 {
-        panic("This code can only handle trees which have 257 levels. Please change the array size in TreeLevel::node to accomodate bigger leaf numbers that would occur when using bigger trees. (Actually will have to change some other things too.)")
+    tree, err := NewTreeWithHash(numLevels, sha256.New)
+    if err != nil {
+        panic(err.Error())
+    }
+    return tree
+}
+
+/**
+ * Configures how a tree hashes its nodes.
+ */
+type TreeOptions struct {
+    NewHash func() hash.Hash // the hash function, SHA256 if nil
+
+    // If true, leaves and internal nodes are domain-separated as in RFC 6962 (Certificate
+    // Transparency): the hash of a leaf with data hash 'd' is H(0x00 || d) rather than 'd', and the
+    // hash of an internal node is H(0x01 || left || right) rather than H(left || right).
+    //
+    // NOTE: Empty subtrees still hash to the empty hash, and CT verifiers assume a dense tree
+    // whose size fits in 64 bits, so our proofs cannot be fed to them as is: they must be checked
+    // via VerifyMembershipProofWithOptions() or VerifyAppendOnlyProof(). However, leaf and node
+    // hashes can be recomputed with the RFC 6962 hashers of CT libraries.
+    RFC6962 bool
+
+    // How internal nodes are domain-separated by their position in the tree (see HashingScheme),
+    // which verifiers must know. HashingPlain, the default, does not separate them at all.
+    HashingScheme HashingScheme
+
+    // If true, the tree keeps the past versions of its nodes, so that it can serve membership
+    // proofs as of any past epoch via Tree::Version(). This costs memory proportional to the # of
+    // node changes across epochs, rather than a copy of the tree per epoch.
+    Versioned bool
+
+    // If not nil, leaves can be inserted by label, at leaf no VRF(label) (see Tree::InsertLabel()).
+    // Only the server should have this key, while verifiers only need its public key.
+    VRFKey *VRFPrivateKey
+
+    // How keys are mapped to leaf nos by Tree::InsertKey() and Tree::ProveKey(). If nil, keys are
+    // mapped via HMAC if HMACLeafNos is set, via the VRF if VRFKey is set, or hashed with NewHash
+    // otherwise (see KeyMapper).
+    KeyMapper KeyMapper
+
+    // An operator secret, for private deployments whose hashes must be unpredictable to outsiders.
+    // If HMACLeafNos is set, keys are mapped to leaf nos via HMAC-SHA256 with it (see
+    // HMACKeyMapper), and if HMACLeafHashes is set, the hash of a leaf with data hash 'd' is
+    // HMAC(HMACKey, d) with NewHash (or HMAC(HMACKey, 0x00 || d) if RFC6962) rather than 'd', so
+    // only those with the key can check leaves' openings, values and extensions. Internal nodes are
+    // not keyed, so anyone can still check proofs given the leaf hashes, and append-only proofs.
+    //
+    // NOTE: The key is never written to snapshots nor to the WAL, so trees must be loaded with it.
+    HMACKey        []byte
+    HMACLeafNos    bool
+    HMACLeafHashes bool
+
+    // If true, leaves commit to extensions (e.g., timestamps or policy bytes) besides their data
+    // hash, which membership proofs then carry (see LeafExtensions), so they must be inserted via
+    // InsertWithExtensions(). Verifiers with this flag reject membership proofs of leaves without
+    // extensions.
+    LeafExtensions bool
+
+    // If true, the tree indexes the epoch each leaf was first inserted in (see
+    // Tree::GetInsertionEpoch()), and the leaves inserted via InsertWithExtensions() commit to it
+    // (see LeafExtensions::Epoch), so clients can check how long a leaf has been in the tree.
+    InsertionEpochs bool
+
+    // The # of top levels whose nodes are cached in dense arrays, which speeds up inserts and
+    // proofs (see TreeLevel::get()). At most 24, since this costs 2^CachedLevels pointers.
+    CachedLevels int
+
+    // The # of nodes per slab the nodes of each level are allocated from, or 0 to allocate nodes
+    // one by one (see TreeLevel::newNode()). Slabs of a few thousand nodes cut the GC's work.
+    SlabSize int
+
+    // If not 0, an epoch's proof tree is compressed whenever it grows beyond this many nodes as
+    // leaves are added to it (see Epoch::Insert()), rather than only when the epoch is committed,
+    // which bounds the memory of the proof trees of very large epochs. The proofs are the same.
+    ProofTreeMaxNodes int
+
+    // The # of levels of the tree, 257 if 0. Trees get their # of levels from NewTreeWithOptions(),
+    // so this is only needed by verifiers of trees with fewer levels, which must know where the
+    // leaves are.
+    NumLevels int
+}
+
+// The most levels a tree can have, since LNs are 256-bit numbers
+const maxNumLevels = 257
+
+/**
+ * Returns the # of levels in the options, or the default one if not set. Returns an error if the #
+ * of levels is invalid, so that verifiers do not have to trust it.
+ */
+func (opts TreeOptions) _numLevels() (int, error) {
+    if opts.NumLevels == 0 {
+        return maxNumLevels, nil
+    }
+    if opts.NumLevels < 2 || opts.NumLevels > maxNumLevels {
+        return 0, fmt.Errorf("trees must have between 2 and %d levels, not %d", maxNumLevels, opts.NumLevels)
+    }
+    return opts.NumLevels, nil
+}
+
+/**
+ * Returns an error if the HMAC key is missing while keyed hashing is on, or set but unused.
+ */
+func (opts TreeOptions) _checkHMAC() error {
+    keyed := opts.HMACLeafNos || opts.HMACLeafHashes
+    if keyed && len(opts.HMACKey) == 0 {
+        return fmt.Errorf("keyed hashing needs an HMAC key")
+    }
+    if !keyed && len(opts.HMACKey) != 0 {
+        return fmt.Errorf("the HMAC key is unused, since neither HMACLeafNos nor HMACLeafHashes is set")
+    }
+    return nil
+}
+
+/**
+ * Returns the key leaf hashes are keyed with, or nil if they are not (see TreeOptions::HMACKey).
+ */
+func (opts TreeOptions) _leafHashKey() []byte {
+    if !opts.HMACLeafHashes {
+        return nil
+    }
+    return opts.HMACKey
+}
+
+/**
+ * Creates a new, empty tree with a certain # of levels, which uses the specified hash function to
+ * hash nodes. Returns an error if the hash function does not output 32-byte digests, since nodes,
+ * proofs and LNs all assume 32-byte hashes.
+ */
+func NewTreeWithHash(numLevels int, newHash func() hash.Hash) (*Tree, error) {
+    return NewTreeWithOptions(numLevels, TreeOptions{NewHash: newHash})
+}
+
+/**
+ * Creates a new, empty tree with a certain # of levels, which hashes nodes as specified by 'opts'.
+ * Returns an error if the hash function does not output 32-byte digests.
+ */
+func NewTreeWithOptions(numLevels int, opts TreeOptions) (*Tree, error) {
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    if size := newHash().Size(); size != 32 {
+        return nil, fmt.Errorf("hash function outputs %d-byte digests, but only 32-byte digests are supported", size)
+    }
+
+    if numLevels < 2 || numLevels > maxNumLevels {
+        return nil, fmt.Errorf("trees must have between 2 and %d levels, not %d", maxNumLevels, numLevels)
+    }
+    if opts.NumLevels != 0 && opts.NumLevels != numLevels {
+        return nil, fmt.Errorf("options are for a tree with %d levels, not %d", opts.NumLevels, numLevels)
+    }
+
+    if opts.CachedLevels < 0 || opts.CachedLevels > minInt(maxCachedLevels, numLevels) {
+        return nil, fmt.Errorf("cannot cache %d levels (at most %d)", opts.CachedLevels, minInt(maxCachedLevels, numLevels))
+    }
+    if err := opts.HashingScheme._check(); err != nil {
+        return nil, err
+    }
+    if opts.ProofTreeMaxNodes < 0 {
+        return nil, fmt.Errorf("proof trees cannot be limited to %d nodes", opts.ProofTreeMaxNodes)
+    }
+    if err := opts._checkHMAC(); err != nil {
+        return nil, err
+    }
+    if opts.HMACLeafNos && opts.KeyMapper != nil {
+        return nil, fmt.Errorf("keys cannot be mapped both via HMACLeafNos and via a KeyMapper")
     }
 
-    lastLevel := numLevels - 1
     tree := new(Tree)
     tree.numLevels = numLevels
     tree.lvl = make([]*TreeLevel, numLevels)
 
-    tree.One = big.NewInt(1)
-    tree.Two = big.NewInt(2)
-    tree.MaxLeafs = new(big.Int)
-    tree.MaxLeafs.Exp(tree.Two, big.NewInt(int64(lastLevel)), nil)
-
     for i, _ := range tree.lvl {
         tree.lvl[i] = _newTreeLevel(i)
     }
 
+    tree._cacheLevels(opts.CachedLevels)
+    if err := tree._useSlabs(opts.SlabSize); err != nil {
+        return nil, err
+    }
+
+    tree.history = make(map[[32]byte][][32]byte)
+    tree.openings = make(map[[32]byte]*Opening)
+    tree.values = make(map[[32]byte][]byte)
+    tree.extended = make(map[[32]byte]*ExtendedLeaf)
+    tree.rootLog = log.New()
+    tree.newHash = newHash
+    tree.rfc6962 = opts.RFC6962
+    tree.scheme = opts.HashingScheme
+    tree.vrfKey = opts.VRFKey
+    tree.keyMapper = opts.KeyMapper
+    if len(opts.HMACKey) != 0 {
+        tree.hmacKey = append([]byte(nil), opts.HMACKey...)
+    }
+    tree.hmacLeafNos = opts.HMACLeafNos
+    tree.hmacLeafHashes = opts.HMACLeafHashes
+    tree.leafExtensions = opts.LeafExtensions
+    if opts.InsertionEpochs {
+        tree.insertedIn = make(map[[32]byte]int)
+    }
+    tree.proofTreeMaxNodes = opts.ProofTreeMaxNodes
+    if opts.Versioned {
+        tree.versions = make([]map[[32]byte][]nodeVersion, numLevels)
+        for i := range tree.versions {
+            tree.versions[i] = make(map[[32]byte][]nodeVersion)
+        }
+    }
+
     for i := 0; i < 32; i++ {
         tree.EmptyHash[i] = 0x00
         tree.RootNo[i] = 0x00
     }
 
-    return tree
+    return tree, nil
+}
+
+/**
+ * Creates a new, empty tree with the same # of levels and options as this tree, except that it is
+ * not versioned, does not index insertion epochs and has no cached levels nor slabs. Used to
+ * create proof trees, which must hash nodes the same way as the tree.
+ */
+func (tree *Tree) NewEmptyTree() *Tree {
+    opts := tree.Options()
+    opts.Versioned = false
+    opts.InsertionEpochs = false
+    opts.CachedLevels = 0
+    opts.SlabSize = 0
+    emptyTree, err := NewTreeWithOptions(tree.numLevels, opts)
+    if err != nil {
+        panic(err.Error())
+    }
+    return emptyTree
+}
+
+/**
+ * Returns the options the tree was created with.
+ */
+func (tree *Tree) Options() TreeOptions {
+    return TreeOptions{
+        NewHash:        tree.newHash,
+        RFC6962:        tree.rfc6962,
+        Versioned:      tree.versions != nil,
+        VRFKey:         tree.vrfKey,
+        KeyMapper:      tree.keyMapper,
+        LeafExtensions: tree.leafExtensions,
+        CachedLevels:   tree._numCachedLevels(),
+        SlabSize:       tree.lvl[0].slabSize,
+        NumLevels:      tree.numLevels,
+
+        ProofTreeMaxNodes: tree.proofTreeMaxNodes,
+        HashingScheme:     tree.scheme,
+        InsertionEpochs:   tree.insertedIn != nil,
+        HMACKey:           tree.hmacKey,
+        HMACLeafNos:       tree.hmacLeafNos,
+        HMACLeafHashes:    tree.hmacLeafHashes,
+    }
+}
+
+/**
+ * Panics if 'leafNo' is too large to be a leaf no of this tree, i.e., if it has more than
+ * numLevels - 1 bits.
+ */
+func (tree *Tree) _checkLeafNo(leafNo [32]byte) {
+    if !_fitsInLevel(leafNo, tree.numLevels-1) {
+        panic(fmt.Sprintf("Leaf no '%s' is too large for a tree with %d levels", hashStr(leafNo), tree.numLevels))
+    }
+}
+
+/**
+ * Returns true if 'nodeNo' is a valid LN on level 'level', i.e., if it has at most 'level' bits.
+ */
+func _fitsInLevel(nodeNo [32]byte, level int) bool {
+    return level >= 256 || rshBytes(nodeNo, uint(level)) == [32]byte{}
 }
 
 /**
@@ -111,6 +453,28 @@ func (tree *Tree) GetNumNodes() int64 {
     return treeSize
 }
 
+/**
+ * Returns the number of leaves in the tree, without walking the last level. For proof trees, this
+ * is the # of leaves in the proof, including the siblings of the proven leaves.
+ */
+func (tree *Tree) NumLeaves() int64 {
+    return tree.numLeaves
+}
+
+/**
+ * Adds the node with LN 'nodeNo' to level 'lvl', or replaces it, counting the new leaves. Nodes
+ * that may be leaves must be added via this, or else NumLeaves() is off.
+ */
+func (tree *Tree) _putNode(lvl *TreeLevel, nodeNo [32]byte, node *Node) {
+    if lvl.num == tree.numLevels-1 {
+        if _, ok := lvl.node[nodeNo]; !ok {
+            tree.numLeaves++
+        }
+    }
+    lvl.node[nodeNo] = node
+    lvl._cacheNode(nodeNo, node)
+}
+
 /**
  * Returns the root hash of the tree.
  */
@@ -129,13 +493,13 @@ func (tree *Tree) GetRootHash() [32]byte {
 }
 
 /**
- * Computes the hash of a parent node, given its two children's hashes.
- * One hash is given directly, while another one is given as a sibling node pointer.
+ * Computes the hash of the parent node with LN 'nodeNo' on level 'level', given its two children's
+ * hashes. One hash is given directly, while another one is given as a sibling node pointer.
  *
  * 'dir' is true when the left hash is in 'prevHash' and 'prevSibling' is the right child
  * 'dir' is false when the right hash is in 'prevHash' and 'prevSibiling' is the left child node
  */
-func (tree *Tree) _computeHash(prevHash [32]byte, prevSibling *Node, dir bool) [32]byte {
+func (tree *Tree) _computeHash(level int, nodeNo [32]byte, prevHash [32]byte, prevSibling *Node, dir bool) [32]byte {
     var leftHash *[32]byte = &prevHash
     var rightHash *[32]byte
 
@@ -151,29 +515,198 @@ func (tree *Tree) _computeHash(prevHash [32]byte, prevSibling *Node, dir bool) [
         rightHash = t
     }
 
-    return _merkleHash(*leftHash, *rightHash)
+    return tree._nodeHashAt(level, nodeNo, *leftHash, *rightHash)
 }
 
 /**
- * Merkle hashes two children hashes.
+ * Merkle hashes two children hashes using the tree's hash function. Only for hashes that are not
+ * those of internal nodes (e.g., version chains), since it ignores the tree's hashing scheme: use
+ * _nodeHashAt() for internal nodes.
  */
-func _merkleHash(h1 [32]byte, h2 [32]byte) [32]byte {
-    digest := sha256.New()
+func (tree *Tree) _merkleHash(h1 [32]byte, h2 [32]byte) [32]byte {
+    return _nodeHash(tree.newHash, tree.rfc6962, h1, h2)
+}
+
+/**
+ * Returns the hash of the internal node with LN 'nodeNo' on level 'level', whose children have
+ * hashes 'h1' and 'h2'.
+ */
+func (tree *Tree) _nodeHashAt(level int, nodeNo [32]byte, h1 [32]byte, h2 [32]byte) [32]byte {
+    return _schemeNodeHash(tree.newHash, tree.rfc6962, tree.scheme, level, nodeNo, h1, h2)
+}
+
+/**
+ * Returns the hash of a leaf with the specified data hash.
+ */
+func (tree *Tree) _leafHash(dataHash [32]byte) [32]byte {
+    var key []byte
+    if tree.hmacLeafHashes {
+        key = tree.hmacKey
+    }
+    return _leafHashWith(tree.newHash, tree.rfc6962, key, dataHash)
+}
+
+/**
+ * Returns the hash of a leaf with the specified data hash, keyed with 'hmacKey' if not nil (see
+ * TreeOptions::HMACKey).
+ */
+func _leafHashWith(newHash func() hash.Hash, rfc6962 bool, hmacKey []byte, dataHash [32]byte) [32]byte {
+    if hmacKey != nil {
+        mac := hmac.New(newHash, hmacKey)
+        if rfc6962 {
+            mac.Write(rfc6962LeafPrefix)
+        }
+        mac.Write(dataHash[:])
+
+        var leafHash [32]byte
+        copy(leafHash[:], mac.Sum(nil))
+        return leafHash
+    }
+    if !rfc6962 {
+        return dataHash
+    }
+    return _hashWith(newHash, rfc6962LeafPrefix, dataHash[:])
+}
+
+// The prefixes of leaves and of internal nodes when hashing as in RFC 6962
+var rfc6962LeafPrefix = []byte{0x00}
+var rfc6962NodePrefix = []byte{0x01}
+
+/**
+ * Merkle hashes two children hashes using the specified hash function, domain-separated as in
+ * RFC 6962 if 'rfc6962' is true (see TreeOptions).
+ */
+func _nodeHash(newHash func() hash.Hash, rfc6962 bool, h1 [32]byte, h2 [32]byte) [32]byte {
+    if !rfc6962 {
+        return _merkleHashWith(newHash, h1, h2)
+    }
+    return _hashWith(newHash, rfc6962NodePrefix, h1[:], h2[:])
+}
+
+/**
+ * How internal nodes are domain-separated by their position in the tree. Without it, the hash of a
+ * node is a function of its children's hashes only, so a node on one level could be passed off as
+ * one on another level (or in another subtree) whose children hash the same. With it, each node
+ * commits to its level (and LN), so no hash can be reused elsewhere in the tree.
+ */
+type HashingScheme int
+
+const (
+    // H(left || right)
+    HashingPlain HashingScheme = iota
+    // H(level || left || right), where the level is the node's, 2 bytes, big-endian
+    HashingLevel
+    // H(level || LN || left || right), where the LN is the node's, 32 bytes
+    HashingLevelLN
+)
+
+func (scheme HashingScheme) String() string {
+    switch scheme {
+    case HashingPlain:
+        return "plain"
+    case HashingLevel:
+        return "level"
+    case HashingLevelLN:
+        return "level-ln"
+    }
+    return fmt.Sprintf("HashingScheme(%d)", int(scheme))
+}
+
+func (scheme HashingScheme) _check() error {
+    if scheme < HashingPlain || scheme > HashingLevelLN {
+        return fmt.Errorf("unknown hashing scheme %d", int(scheme))
+    }
+    return nil
+}
+
+/**
+ * Merkle hashes the children hashes of the internal node with LN 'nodeNo' on level 'level' as
+ * specified by 'scheme', after the RFC 6962 prefix if 'rfc6962' is true. Plain hashes are the
+ * same as _nodeHash()'s.
+ */
+func _schemeNodeHash(newHash func() hash.Hash, rfc6962 bool, scheme HashingScheme, level int, nodeNo [32]byte, h1 [32]byte, h2 [32]byte) [32]byte {
+    if scheme == HashingPlain {
+        return _nodeHash(newHash, rfc6962, h1, h2)
+    }
+
+    var prefix [1 + 2 + 32]byte
+    n := 0
+    if rfc6962 {
+        prefix[n] = rfc6962NodePrefix[0]
+        n++
+    }
+    binary.BigEndian.PutUint16(prefix[n:], uint16(level))
+    n += 2
+    if scheme == HashingLevelLN {
+        n += copy(prefix[n:], nodeNo[:])
+    }
+    return _hashWith(newHash, prefix[:n], h1[:], h2[:])
+}
 
-    digest.Write(h1[:])
-    digest.Write(h2[:])
+/**
+ * Merkle hashes two children hashes using SHA256.
+ */
+func _merkleHash(h1 [32]byte, h2 [32]byte) [32]byte {
+    return _merkleHashWith(sha256.New, h1, h2)
+}
 
+/**
+ * Merkle hashes two children hashes using the specified hash function.
+ */
+func _merkleHashWith(newHash func() hash.Hash, h1 [32]byte, h2 [32]byte) [32]byte {
     //fmt.Printf("h(%s, %s) = ", hashStr(h1), hashStr(h2))
+    return _hashWith(newHash, h1[:], h2[:])
+}
+
+/**
+ * Hashes the concatenation of 'parts' using the specified hash function.
+ *
+ * The parts are first copied into one buffer, on the stack unless they are large, so that the
+ * callers' slices do not escape to the heap. SHA256 (i.e., the default hash function) is then
+ * computed via sha256.Sum256(), which needs no digest state, so it does not allocate at all.
+ */
+func _hashWith(newHash func() hash.Hash, parts ...[]byte) [32]byte {
+    var stackBuf [hashBufSize]byte
+    size := 0
+    for _, part := range parts {
+        size += len(part)
+    }
+    buf := stackBuf[:0]
+    if size > len(stackBuf) {
+        buf = make([]byte, 0, size)
+    }
+    for _, part := range parts {
+        buf = append(buf, part...)
+    }
+
+    if _isSHA256(newHash) {
+        return sha256.Sum256(buf)
+    }
+    // Digests may keep what they are given, so they get a copy, or else 'stackBuf' would escape
+    return _hashWithDigest(newHash, append([]byte(nil), buf...))
+}
+
+/**
+ * Hashes the concatenation of 'parts' using a new digest of the specified hash function, which is
+ * how _hashWith() used to hash (see hashing.go).
+ */
+func _hashWithDigest(newHash func() hash.Hash, parts ...[]byte) [32]byte {
+    digest := newHash()
+    for _, part := range parts {
+        digest.Write(part)
+    }
 
     var storage []byte = make([]byte, 0)
     var hash [32]byte
     storage = digest.Sum(storage)
+    if len(storage) != 32 {
+        // Should've been caught by NewTreeWithHash(), unless the hash function lies about its size
+        panic(fmt.Sprintf("Expected a 32-byte digest from the hash function, got %d bytes", len(storage)))
+    }
     for i := 0; i < len(storage); i++ {
         hash[i] = storage[i]
     }
 
-    //fmt.Printf("%s\n", hashStr(hash))
-
     return hash
 }
 
@@ -186,11 +719,10 @@ func _merkleHash(h1 [32]byte, h2 [32]byte) [32]byte {
 func (tree *Tree) _visitPath(
     localIdx [32]byte, // the LN of the node
     level int, // the level # of the node
-    nodeFunc func(*TreeLevel, *big.Int, *big.Int, bool),
+    nodeFunc func(*TreeLevel, [32]byte, [32]byte, bool),
     leafCheck func([32]byte)) {
-    localNo := hashToInt(localIdx)
-    var siblingNo big.Int
-    var dir bool = tree.GetSiblingNo(&siblingNo, localNo) // returns true if node is left child
+    localNo := localIdx
+    siblingNo, dir := tree.GetSiblingNo(localNo) // returns true if node is left child
 
     // Perform a user-specified node check, such as making sure it does not
     // exist in the tree already
@@ -206,52 +738,64 @@ func (tree *Tree) _visitPath(
         lvl := tree.lvl[levelNo]
 
         // Perform a user-specified action for the ancestor node
-        nodeFunc(lvl, localNo, &siblingNo, dir)
+        nodeFunc(lvl, localNo, siblingNo, dir)
 
         // Compute the next node's per-level local number
-        localNo.Div(localNo, tree.Two) // parent's local no = child's local no / 2
-        dir = tree.GetSiblingNo(&siblingNo, localNo)
+        localNo = parentOf(localNo) // parent's local no = child's local no / 2
+        siblingNo, dir = tree.GetSiblingNo(localNo)
     }
 }
 
 /**
- * Stores the LN of the sibling of 'nodeNo' in the 'siblingNo' bigInt.
- * Returns true, if 'nodeNo' was a left child, false otherwise.
+ * Returns the LN of the sibling of 'nodeNo'.
+ * Also returns true, if 'nodeNo' was a left child, false otherwise.
  */
-func (tree *Tree) GetSiblingNo(siblingNo *big.Int, nodeNo *big.Int) bool {
-    // Is this node a left-child?
-    dir := nodeNo.Bit(0) == 0
-
-    // Compute this node's local sibling no by adding or
-    // subtracting one from the node's local no
-    siblingNo.Set(nodeNo)
-    if dir {
-        siblingNo.Add(siblingNo, tree.One)
-    } else {
-        siblingNo.Sub(siblingNo, tree.One)
-    }
+func (tree *Tree) GetSiblingNo(nodeNo [32]byte) ([32]byte, bool) {
+    // The sibling's LN only differs in the last bit
+    return siblingOf(nodeNo)
+}
 
-    return dir
+/**
+ * Given an LN, returns the Node struct for that node. Does not fill the cached levels (see
+ * TreeLevel::peek()), so readers can call it concurrently.
+ */
+func (tree *Tree) getNode(lvl *TreeLevel, localNo [32]byte) *Node {
+    return lvl.peek(localNo)
 }
 
 /**
- * Given an LN as a big integer, returns the Node struct for that node.
+ * Inserts the specified 'dataHash' in the specified 'leafNo' in the last level of the tree. Panics
+ * if the tree was created with TreeOptions::LeafExtensions, whose leaves must be inserted via
+ * InsertWithExtensions().
  */
-func (tree *Tree) getNode(lvl *TreeLevel, localNo *big.Int) *Node {
-    return lvl.node[bigIntTo32Bytes(localNo)]
+func (tree *Tree) Insert(leafNo [32]byte, dataHash [32]byte, proofTree *Tree) {
+    if tree.leafExtensions {
+        panic("Cannot insert a leaf without extensions in a tree created with TreeOptions::LeafExtensions")
+    }
+    tree._insert(leafNo, dataHash, proofTree)
 }
 
 /**
- * Given an LN as byte array, returns the Node struct for that node.
+ * Inserts the leaf like Insert(), whether or not the tree's leaves must have extensions.
  */
-func (tree *Tree) getNodeByByteArray(lvl *TreeLevel, localNo *[32]byte) *Node {
-    return lvl.node[*localNo]
+func (tree *Tree) _insert(leafNo [32]byte, dataHash [32]byte, proofTree *Tree) {
+    // Don't set the new flag if we're not building consistency proofs
+    tree._insertLeaf(leafNo, dataHash, proofTree != nil)
+
+    // Incrementally build a consistency proof after each insertion
+    if proofTree != nil {
+        //fmt.Printf("Adding leaf %s to proof...\n", hashStr(leafNo))
+        tree._proofAddInserted(leafNo, proofTree)
+    }
 }
 
 /**
- * Inserts the specified 'dataHash' in the specified 'leafNo' in the last level of the tree.
+ * Inserts the leaf without adding it to a proof, marking the new nodes as 'new' if 'markNew' is true.
  */
-func (tree *Tree) Insert(leafNo [32]byte, dataHash [32]byte, proofTree *Tree) {
+func (tree *Tree) _insertLeaf(leafNo [32]byte, dataHash [32]byte, markNew bool) {
+    tree._checkLeafNo(leafNo)
+    tree._restore(tree.numLevels-1, leafNo)
+
     // This will be called before starting to iterate through the path to make sure the leaf's not been inserted before
     checkLeaf := func(leaf [32]byte) {
         if _, ok := tree.lvl[tree.numLevels-1].node[leaf]; ok {
@@ -259,141 +803,281 @@ func (tree *Tree) Insert(leafNo [32]byte, dataHash [32]byte, proofTree *Tree) {
         }
     }
 
+    tree._setLeafHash(leafNo, tree._leafHash(dataHash), markNew, checkLeaf)
+    tree.pending = append(tree.pending, epochLeaf{leafNo: leafNo, dataHash: dataHash})
+    tree._notifyInsert(leafNo, dataHash)
+}
+
+/**
+ * Updates the value of the existing leaf 'leafNo' by appending 'newDataHash' to the leaf's version chain.
+ * The leaf's hash becomes h(prevLeafHash, newDataHash), so the old value is still committed to
+ * by the new leaf hash, which keeps the dictionary append-only.
+ */
+func (tree *Tree) Update(leafNo [32]byte, newDataHash [32]byte, proofTree *Tree) {
+    prevLeafHash, wasNew := tree._updateLeaf(leafNo, newDataHash)
+
+    if proofTree != nil {
+        tree._proofAddUpdated(leafNo, prevLeafHash, wasNew, newDataHash, proofTree)
+    }
+}
+
+/**
+ * Updates the leaf without adding it to a proof. Returns the leaf's previous hash and whether the
+ * leaf was inserted in the current batch (i.e., is marked as 'new').
+ */
+func (tree *Tree) _updateLeaf(leafNo [32]byte, newDataHash [32]byte) ([32]byte, bool) {
+    tree._checkLeafNo(leafNo)
+    tree._restore(tree.numLevels-1, leafNo)
+
+    leafLvl := tree.lvl[tree.numLevels-1]
+    leaf, ok := leafLvl.node[leafNo]
+    if !ok {
+        panic(fmt.Sprintf("Cannot update leaf '%s' because it was never inserted", hashStr(leafNo)))
+    }
+
+    versions, ok := tree.history[leafNo]
+    if !ok {
+        // The first version's data hash is just the leaf hash
+        versions = [][32]byte{leaf.Hash}
+    }
+    tree.history[leafNo] = append(versions, newDataHash)
+    delete(tree.openings, leafNo)
+    delete(tree.values, leafNo)
+    delete(tree.extended, leafNo)
+
+    prevLeafHash := leaf.Hash
+    tree._setLeafHash(leafNo, tree._merkleHash(prevLeafHash, newDataHash), false, nil)
+    tree.pending = append(tree.pending, epochLeaf{leafNo: leafNo, dataHash: newDataHash, isUpdate: true})
+
+    return prevLeafHash, leafLvl.isNew(leafNo)
+}
+
+/**
+ * Adds a leaf that was just inserted to the append-only proof, counting it as a new leaf (see
+ * AppendOnlyProof::NumNewLeaves()). Leaves inserted earlier in the batch and updated since are
+ * added via _proofAdd() directly, since they were already counted.
+ */
+func (tree *Tree) _proofAddInserted(leafNo [32]byte, proofTree *Tree) {
+    if proofTree.numNewLeaves >= 0 {
+        proofTree.numNewLeaves++
+    }
+    tree._proofAdd(leafNo, proofTree)
+}
+
+/**
+ * Adds an updated leaf to the append-only proof (see Tree::_updateLeaf() for the arguments).
+ */
+func (tree *Tree) _proofAddUpdated(leafNo [32]byte, prevLeafHash [32]byte, wasNew bool, newDataHash [32]byte, proofTree *Tree) {
+    if wasNew {
+        // The leaf was inserted in this batch, so the proof only needs its latest hash
+        tree._proofAdd(leafNo, proofTree)
+    } else {
+        tree._proofAddUpdate(leafNo, prevLeafHash, newDataHash, proofTree)
+    }
+}
+
+/**
+ * Returns the data hashes of all versions of the specified leaf, from the oldest to the latest one.
+ * Returns nil if the leaf does not exist.
+ */
+func (tree *Tree) GetHistory(leafNo [32]byte) [][32]byte {
+    if versions, ok := tree.history[leafNo]; ok {
+        return versions
+    }
+
+    leaf, ok := tree.lvl[tree.numLevels-1].node[leafNo]
+    if !ok {
+        return nil
+    }
+    return [][32]byte{leaf.Hash}
+}
+
+/**
+ * Sets the hash of the leaf 'leafNo' to 'leafHash' and recomputes the hashes of all its ancestors.
+ * Missing nodes along the path are created and marked as 'new' if 'markNew' is true.
+ */
+func (tree *Tree) _setLeafHash(leafNo [32]byte, leafHash [32]byte, markNew bool, leafCheck func([32]byte)) {
+    tree._setNodeHash(leafNo, tree.numLevels-1, leafHash, markNew, leafCheck)
+}
+
+/**
+ * Like _setLeafHash(), but starts at the node with LN 'nodeNo' on level 'level'.
+ */
+func (tree *Tree) _setNodeHash(nodeNo [32]byte, level int, nodeHash [32]byte, markNew bool, nodeCheck func([32]byte)) {
     var prevSibling *Node = nil
     var prevHash [32]byte
-    var prevDir bool = leafNo[31]&1 == 0
+    var prevDir bool = nodeNo[31]&1 == 0
 
-    // Our node function will compute the hashes of the internal nodes and set the inserted leaf's hash as well
+    // Our node function will compute the hashes of the internal nodes and set the starting node's hash as well
     newNodes := 0
-    insertNodeFunc := func(lvl *TreeLevel, ancestorNo *big.Int, siblingNo *big.Int, dir bool) {
+    nodeFunc := func(lvl *TreeLevel, ancestorNo [32]byte, siblingNo [32]byte, dir bool) {
         // Need to see if a node exists, and create it if not
-        idx := bigIntTo32Bytes(ancestorNo)
-        node, ok := lvl.node[idx]
-        if !ok {
-            //fmt.Printf("Creating new level %d node: %s\n", lvl.num, ancestorNo)
-            // Don't set the new flag if we're not building consistency proofs
-            isNew := proofTree != nil
-            node = &Node{IsNew: isNew}
-            lvl.node[idx] = node
+        node := lvl.get(ancestorNo)
+        if node == nil {
+            //fmt.Printf("Creating new level %d node: %s\n", lvl.num, hashStr(ancestorNo))
+            node = lvl.newNode()
+            tree._putNode(lvl, ancestorNo, node)
+            if markNew {
+                lvl.markNew(ancestorNo)
+            }
             newNodes++
         }
 
-        // Compute this node's hash from its children (for the leaf we just set the hash to 'dataHash')
-        if lvl.num == tree.numLevels-1 {
-            node.Hash = dataHash
+        // Compute this node's hash from its children (for the starting node we just set the hash to 'nodeHash')
+        if lvl.num == level {
+            node.Hash = nodeHash
         } else {
-            node.Hash = tree._computeHash(prevHash, prevSibling, prevDir)
+            node.Hash = tree._computeHash(lvl.num, ancestorNo, prevHash, prevSibling, prevDir)
         }
+        tree._recordVersion(lvl.num, ancestorNo, node.Hash)
 
         // Remember this node's hash
         prevHash = node.Hash
         // Get this node's sibling. Could be nil.
-        prevSibling = tree.getNode(lvl, siblingNo)
+        prevSibling = lvl.get(siblingNo)
         prevDir = dir
     }
 
-    tree._visitPath(leafNo, tree.numLevels-1, insertNodeFunc, checkLeaf)
-
-    // Incrementally build a consistency proof after each insertion
-    if proofTree != nil {
-        //fmt.Printf("Adding leaf %s to proof...\n", hashStr(leafNo))
-        tree._proofAdd(leafNo, proofTree)
-    }
+    tree._visitPath(nodeNo, level, nodeFunc, nodeCheck)
 
     //fmt.Printf("Created %v nodes\n", newNodes)
 }
 
 /**
- * Checks an append-only proof.
+ * Checks an append-only proof (see VerifyAppendOnlyProofTree() for why it is invalid).
  */
 func VerifyAppendOnlyProof(proofTree *Tree, oldHash [32]byte, newHash [32]byte) bool {
-    var hash [32]byte
-
-    // Check that old nodes hash to old root hash
-    hash = proofTree._hashProofTree(false)
-    if hash != oldHash {
-        fmt.Printf("ERROR: Old hash check failed\n")
-        return false
-    }
-
-    // Check that old + new nodes hash to new root hash
-    hash = proofTree._hashProofTree(true)
-    if hash != newHash {
-        fmt.Printf("ERROR: New hash check failed\n")
-        return false
-    }
-
-    return true
+    return VerifyAppendOnlyProofTree(proofTree, oldHash, newHash) == nil
 }
 
 /**
- * Hashes the proof tree, ignoring new nodes if isNew == true.
- * Obtains the old root if isNew == false, and new root otherwise.
+ * Like VerifyAppendOnlyProof(), but returns why the proof is invalid (see VerifyError). Malformed
+ * proof trees (e.g., with missing siblings) are invalid proofs rather than bugs, since they can
+ * come from a malicious prover. Nodes in the subtrees of other nodes are never hashed, as in
+ * uncompressed proof trees.
  */
-func (tree *Tree) _hashProofTree(isNew bool) [32]byte {
-    var rootNo big.Int
+func VerifyAppendOnlyProofTree(proofTree *Tree, oldHash [32]byte, newHash [32]byte) error {
+    // The old nodes must hash to the old root hash, and the old + new nodes to the new root hash
+    roots, err := proofTree._hashProofTreeHelper(proofTree.lvl[0], proofTree.RootNo)
+    if err != nil {
+        return err
+    }
+    if !hashEqual(roots.Old, oldHash) {
+        return _verifyErrorf(ErrOldRootMismatch, "old root hash check failed: got %s", hashStr(roots.Old))
+    }
+    if !hashEqual(roots.New, newHash) {
+        return _verifyErrorf(ErrNewRootMismatch, "new root hash check failed: got %s", hashStr(roots.New))
+    }
 
-    rootNo.SetUint64(0)
-    return tree._hashProofTreeHelper(tree.lvl[0], &rootNo, isNew)
+    return nil
 }
 
 /**
- * Recursively computes the root hash in the proof tree, treating new nodes as empty nodes when isNew == false
+ * Recursively computes the old and the new hash of the node 'rootNo' in the proof tree, treating
+ * new nodes as empty nodes for the old hash
  */
-func (tree *Tree) _hashProofTreeHelper(lvl *TreeLevel, rootNo *big.Int, isNew bool) [32]byte {
-    rootNode := lvl.node[bigIntTo32Bytes(rootNo)]
+func (tree *Tree) _hashProofTreeHelper(lvl *TreeLevel, rootNo [32]byte) (ProofHashes, error) {
+    rootNode := lvl.node[rootNo]
 
     if rootNode != nil {
-        if isNew {
-            return rootNode.Hash
-        } else {
-            if rootNode.IsNew {
-                return tree.EmptyHash
-            } else {
-                return rootNode.Hash
+        // Only leaves that existed before the batch can have appended versions
+        if len(rootNode.Appended) > 0 {
+            if lvl.num != tree.numLevels-1 {
+                return ProofHashes{}, _verifyErrorf(ErrMalformedProof, "proof node %s on level %d is not a leaf but has appended hashes", hashStr(rootNo), lvl.num)
+            }
+            if lvl.isNew(rootNo) {
+                return ProofHashes{}, _verifyErrorf(ErrMalformedProof, "proof leaf %s is new but has appended hashes", hashStr(rootNo))
             }
         }
+
+        hashes := ProofHashes{Old: rootNode.Hash, New: rootNode.Hash}
+        if lvl.isNew(rootNo) {
+            hashes.Old = tree.EmptyHash
+        }
+        // Updated leaves commit to their old version chain, extended with the appended versions
+        for _, dataHash := range rootNode.Appended {
+            hashes.New = tree._merkleHash(hashes.New, dataHash)
+        }
+        return hashes, nil
     } else {
         if lvl.num == tree.numLevels-1 {
-            //return tree.EmptyHash
-            panic("Something's off: Reached leaf nil leaf node. Should've stopped descending earlier.")
+            // The prover left out a node we need, so we cannot hash its parent
+            return ProofHashes{}, _verifyErrorf(ErrMalformedProof, "proof is missing leaf %s (or one of its ancestors)", hashStr(rootNo))
         }
 
-        var leftNo, rightNo big.Int
-        leftNo.Set(rootNo)
-        leftNo.Mul(&leftNo, tree.Two)
-        rightNo.Set(&leftNo)
-        rightNo.Add(&rightNo, tree.One)
+        leftNo, rightNo := childrenOf(rootNo)
 
         sublvl := tree.lvl[lvl.num+1]
-        leftHash := tree._hashProofTreeHelper(sublvl, &leftNo, isNew)
-        rightHash := tree._hashProofTreeHelper(sublvl, &rightNo, isNew)
+        left, err := tree._hashProofTreeHelper(sublvl, leftNo)
+        if err != nil {
+            return ProofHashes{}, err
+        }
+        right, err := tree._hashProofTreeHelper(sublvl, rightNo)
+        if err != nil {
+            return ProofHashes{}, err
+        }
 
-        return _merkleHash(leftHash, rightHash)
+        return ProofHashes{
+            Old: tree._nodeHashAt(lvl.num, rootNo, left.Old, right.Old),
+            New: tree._nodeHashAt(lvl.num, rootNo, left.New, right.New),
+        }, nil
+    }
+}
+
+/**
+ * Returns true if the level's node 'nodeNo' is marked as 'new' (see TreeLevel::newNodes).
+ */
+func (lvl *TreeLevel) isNew(nodeNo [32]byte) bool {
+    _, ok := lvl.newNodes[nodeNo]
+    return ok
+}
+
+/**
+ * Marks the level's node 'nodeNo' as 'new'.
+ */
+func (lvl *TreeLevel) markNew(nodeNo [32]byte) {
+    if lvl.newNodes == nil {
+        lvl.newNodes = make(map[[32]byte]struct{})
+    }
+    lvl.newNodes[nodeNo] = struct{}{}
+}
+
+/**
+ * Returns the LNs of the level's nodes in increasing order. Unlike ranging over the level's map,
+ * whose order is random, this visits the nodes in the same order from run to run, so anything
+ * computed or written in that order is reproducible.
+ */
+func (lvl *TreeLevel) sortedNodeNos() [][32]byte {
+    nodeNos := make([][32]byte, 0, len(lvl.node))
+    for nodeNo := range lvl.node {
+        nodeNos = append(nodeNos, nodeNo)
     }
+    _sortHashes(nodeNos)
+    return nodeNos
 }
 
 /**
  * Iterates through each level, from bottom-most to root level.
  * Calls 'levelFunc' for each level.
- * Calls 'nodeFunc' for each node on that level.
+ * Calls 'nodeFunc' for each node on that level, in increasing order of LN (see sortedNodeNos()).
+ * Nodes that 'nodeFunc' deletes before they are visited are skipped.
  */
 func (tree *Tree) _visitNodesByLevel(
     levelFunc func(*TreeLevel),
     nodeFunc func(*TreeLevel, [32]byte, *Node)) {
     for level := tree.numLevels - 1; level >= 0; level-- {
         lvl := tree.lvl[level]
-        lvlNodes := lvl.node
 
         if levelFunc != nil {
             levelFunc(lvl)
         }
 
         if nodeFunc != nil {
-            // WARNING: no fixed order for iterating through map
-            for idx, _ := range lvlNodes {
+            for _, idx := range lvl.sortedNodeNos() {
                 //fmt.Printf("idx=%v, ", hashToInt(idx))
-                nodeFunc(lvl, idx, lvlNodes[idx])
+                if node, ok := lvl.node[idx]; ok {
+                    nodeFunc(lvl, idx, node)
+                }
             }
         }
     }
@@ -402,13 +1086,13 @@ func (tree *Tree) _visitLeaves(
     nodeFunc func(*TreeLevel, [32]byte, *Node)) {
     level := tree.numLevels - 1
     lvl := tree.lvl[level]
-    lvlNodes := lvl.node
 
     if nodeFunc != nil {
-        // WARNING: no fixed order for iterating through map
-        for idx, _ := range lvlNodes {
+        for _, idx := range lvl.sortedNodeNos() {
             //fmt.Printf("idx=%v, ", hashToInt(idx))
-            nodeFunc(lvl, idx, lvlNodes[idx])
+            if node, ok := lvl.node[idx]; ok {
+                nodeFunc(lvl, idx, node)
+            }
         }
     }
 }
@@ -418,11 +1102,11 @@ func (tree *Tree) _visitLeaves(
  * new batch of nodes in the tree and measure the append-only proof size.
  */
 func (tree *Tree) _assertNoNewNodes() {
-    tree._visitNodesByLevel(nil, func(lvl *TreeLevel, nodeIdx [32]byte, node *Node) {
-        if node.IsNew == true {
-            panic("Something's off: some nodes' IsNew flag was not cleared")
+    for _, lvl := range tree.lvl {
+        if len(lvl.newNodes) > 0 {
+            panic("Something's off: some nodes' 'new' marks were not cleared")
         }
-    })
+    }
 }
 
 /**
@@ -439,56 +1123,70 @@ func (tree *Tree) GetNumEmptySiblings() int64 {
     return count
 }
 
-func (tree *Tree) _proofAdd(leafNo [32]byte, proofTree *Tree) {
-    // Adds either a 'new' node or 'old' node to the proof, possibly updating hashes in the proof (since a new leaf was added before _proofAdd)
-    include := func(level int, node *Node, nodeNo *big.Int, isNew bool) {
-        lvlNodes := proofTree.lvl[level].node
-        idx := bigIntTo32Bytes(nodeNo)
-
-        // get the hash of the node from the tree, or empty hash if nil node
-        nodeHash := tree.EmptyHash
-        if node != nil {
-            nodeHash = node.Hash
-            if nodeHash == tree.EmptyHash {
-                panic("Did not expect to find empty hash in non-nil node")
-            }
+/**
+ * Adds either a 'new' node or 'old' node to the proof, possibly updating hashes in the proof (since a new leaf was added before _proofAdd)
+ */
+func (tree *Tree) _proofInclude(proofTree *Tree, level int, node *Node, nodeNo [32]byte, isNew bool) {
+    proofLvl := proofTree.lvl[level]
+    lvlNodes := proofLvl.node
+
+    // get the hash of the node from the tree, or empty hash if nil node
+    nodeHash := tree.EmptyHash
+    if node != nil {
+        nodeHash = node.Hash
+        if nodeHash == tree.EmptyHash {
+            panic("Did not expect to find empty hash in non-nil node")
         }
+    }
 
-        // if the node was not yet added to the proof
-        if prevNode, ok := lvlNodes[idx]; !ok {
-            //fmt.Printf("Adding (isNew: %v', nodeNo: %s, level: %d, hash: %s) node to proof tree\n", isNew, nodeNo, level, hashStr(nodeHash))
-            if node == nil && isNew {
-                panic("Did not expect includeNew() to be called on nil node")
-            }
+    // if the node was not yet added to the proof
+    if prevNode, ok := lvlNodes[nodeNo]; !ok {
+        //fmt.Printf("Adding (isNew: %v', nodeNo: %s, level: %d, hash: %s) node to proof tree\n", isNew, nodeNo, level, hashStr(nodeHash))
+        if node == nil && isNew {
+            panic("Did not expect includeNew() to be called on nil node")
+        }
 
-            if nodeHash == tree.EmptyHash && isNew {
-                panic("Did not expect to add 'new' node w/ empty hash")
-            }
+        if nodeHash == tree.EmptyHash && isNew {
+            panic("Did not expect to add 'new' node w/ empty hash")
+        }
 
-            lvlNodes[idx] = &Node{Hash: nodeHash, IsNew: isNew}
-        } else {
-            // Recall that _proofAdd is called after every inserted leaf, so some hashes up the tree might change
-            // NOTE: An 'empty' node can turn into a 'new' node in the proof after appending a leaf to the tree.
-            if prevNode.IsNew == false && isNew == true {
-                if prevNode.Hash != tree.EmptyHash {
-                    panic("Hm, I thought only empty nodes can go from 'old' to 'new'")
-                } else {
-                    //fmt.Printf("Empty node turning 'new'\n")
-                }
-                prevNode.IsNew = true
-            }
+        proofTree._putNode(proofLvl, nodeNo, &Node{Hash: nodeHash})
+        if isNew {
+            proofLvl.markNew(nodeNo)
+        }
+    } else {
+        // Updated leaves must keep their old version chain hash, see _proofAddUpdate()
+        if len(prevNode.Appended) > 0 {
+            return
+        }
 
-            prevNode.Hash = nodeHash
+        // Recall that _proofAdd is called after every inserted leaf, so some hashes up the tree might change
+        // NOTE: An 'empty' node can turn into a 'new' node in the proof after appending a leaf to the tree.
+        if isNew && !proofLvl.isNew(nodeNo) {
+            if prevNode.Hash != tree.EmptyHash {
+                panic("Hm, I thought only empty nodes can go from 'old' to 'new'")
+            } else {
+                //fmt.Printf("Empty node turning 'new'\n")
+            }
+            proofLvl.markNew(nodeNo)
         }
+
+        prevNode.Hash = nodeHash
+    }
+}
+
+func (tree *Tree) _proofAdd(leafNo [32]byte, proofTree *Tree) {
+    include := func(level int, node *Node, nodeNo [32]byte, isNew bool) {
+        tree._proofInclude(proofTree, level, node, nodeNo, isNew)
     }
 
     // Adds an 'old' node to the append-only proof
-    includeExisting := func(level int, node *Node, nodeNo *big.Int) {
+    includeExisting := func(level int, node *Node, nodeNo [32]byte) {
         include(level, node, nodeNo, false)
     }
 
     // Adds a 'new' node to the append-only proof
-    includeNew := func(level int, node *Node, nodeNo *big.Int) {
+    includeNew := func(level int, node *Node, nodeNo [32]byte) {
         include(level, node, nodeNo, true)
     }
 
@@ -498,7 +1196,7 @@ func (tree *Tree) _proofAdd(leafNo [32]byte, proofTree *Tree) {
     tree._visitPath(
         leafNo,
         tree.numLevels-1,
-        func(lvl *TreeLevel, nodeNo *big.Int, siblingNo *big.Int, dir bool) {
+        func(lvl *TreeLevel, nodeNo [32]byte, siblingNo [32]byte, dir bool) {
             // Nothing to do for level 0
             if lvl.num == 0 {
                 return
@@ -508,9 +1206,9 @@ func (tree *Tree) _proofAdd(leafNo [32]byte, proofTree *Tree) {
             //fmt.Printf("Looking at level-%d sibling '%s': %v, foundIntersectionNode: %v\n", lvl.num, siblingNo, sibling, foundIntersectionNode)
             if !foundIntersectionNode {
                 // NOTE: The intersection point of an 'old path' and a 'new path' is defined by a node with one 'old' child and one 'new' child.
-                // That's why here we check that sibling.IsNew == false. Recall that the nodes along the path (i.e., nodeNo) are 'new.
+                // That's why here we check that the sibling is not 'new'. Recall that the nodes along the path (i.e., nodeNo) are 'new.
                 // Also note that if we had two sibling 'new' leaves, we would add an ancestor of both of them to the proof, rather than adding them individually.
-                if sibling != nil && sibling.IsNew == false {
+                if sibling != nil && !lvl.isNew(siblingNo) {
                     //fmt.Printf("Adding level-%d existing sibling '%s' and ancestor '%s'\n", lvl.num, siblingNo, nodeNo)
                     includeExisting(lvl.num, sibling, siblingNo)
                     includeNew(lvl.num, tree.getNode(lvl, nodeNo), nodeNo)
@@ -524,7 +1222,7 @@ func (tree *Tree) _proofAdd(leafNo [32]byte, proofTree *Tree) {
                     includeExisting(lvl.num, sibling, siblingNo)
                 } else {
                     // if there's a sibling, it could be either 'new' or 'old'
-                    include(lvl.num, sibling, siblingNo, sibling.IsNew)
+                    include(lvl.num, sibling, siblingNo, lvl.isNew(siblingNo))
                 }
             }
         },
@@ -535,31 +1233,48 @@ func (tree *Tree) _proofAdd(leafNo [32]byte, proofTree *Tree) {
     // necessary because one of them can be computed from its children. We fix this with _compressProof()
 }
 
-// Clears the IsNew flag from tree nodes after a batch is inserted, so we
-// can be ready to compute consistency proofs for the next batch.
-func (tree *Tree) clearNewFlag() {
-    tree._visitLeaves(
-        func(lvl *TreeLevel, nodeIdx [32]byte, node *Node) {
-            tree.clearNewFlagHelper(nodeIdx)
-        })
-}
+/**
+ * Adds an updated 'old' leaf to the append-only proof, along with the siblings along its path.
+ * The leaf is stored with its version chain hash from before the batch ('prevLeafHash') and the
+ * data hashes appended to it during the batch, so that the verifier can check the old and the
+ * new root hash, as well as the fact that the new leaf hash extends the old one.
+ */
+func (tree *Tree) _proofAddUpdate(leafNo [32]byte, prevLeafHash [32]byte, newDataHash [32]byte, proofTree *Tree) {
+    leafLvl := proofTree.lvl[proofTree.numLevels-1].node
+    if proofLeaf, ok := leafLvl[leafNo]; ok {
+        // Either updated before in this batch, or included as the sibling of another leaf
+        proofLeaf.Appended = append(proofLeaf.Appended, newDataHash)
+    } else {
+        proofTree._putNode(proofTree.lvl[proofTree.numLevels-1], leafNo, &Node{Hash: prevLeafHash, Appended: [][32]byte{newDataHash}})
+    }
 
-func (tree *Tree) clearNewFlagHelper(leaf [32]byte) int {
-    removedCount := 0
+    tree._visitPath(
+        leafNo,
+        tree.numLevels-1,
+        func(lvl *TreeLevel, nodeNo [32]byte, siblingNo [32]byte, dir bool) {
+            // Nothing to do for level 0
+            if lvl.num == 0 {
+                return
+            }
 
-    tree._visitPath(leaf, tree.numLevels-1, func(lvl *TreeLevel,
-        nodeNo *big.Int, siblingNo *big.Int, dir bool) {
-        node := tree.getNode(lvl, nodeNo)
-        if node == nil {
-            panic(fmt.Sprintf("Expected node %v to exist at level %v",
-                nodeNo, lvl.num))
-        }
+            sibling := tree.getNode(lvl, siblingNo)
+            if sibling == nil {
+                tree._proofInclude(proofTree, lvl.num, sibling, siblingNo, false)
+            } else {
+                tree._proofInclude(proofTree, lvl.num, sibling, siblingNo, lvl.isNew(siblingNo))
+            }
+        },
+        nil)
+}
 
-        if node.IsNew {
-            node.IsNew = false
-            removedCount++
-        }
-    }, nil)
+// Clears the 'new' marks of the tree's nodes after a batch is inserted, so we
+// can be ready to compute consistency proofs for the next batch.
+func (tree *Tree) _clearNewNodes() int {
+    removedCount := 0
+    for _, lvl := range tree.lvl {
+        removedCount += len(lvl.newNodes)
+        lvl.newNodes = nil
+    }
 
     //fmt.Printf("Reset %v nodes\n", removedCount)
     return removedCount
@@ -588,7 +1303,7 @@ func (tree *Tree) _compressProofTree() {
             tree._visitPath(
                 nodeIdx,
                 startLevel,
-                func(lvl *TreeLevel, nodeNo *big.Int, siblingNo *big.Int, dir bool) {
+                func(lvl *TreeLevel, nodeNo [32]byte, siblingNo [32]byte, dir bool) {
                     // We do nothing for previously taken care of levels
                     //fmt.Printf("Checking node '%s' at level %d (started at level %d)\n", nodeNo, lvl.num, startLevel)
                     if lvl.num == startLevel {
@@ -601,10 +1316,10 @@ func (tree *Tree) _compressProofTree() {
 
                     // Since this ancestor has descendants, we don't need it in the
                     // proof: we can recompute it => delete it from the tree
-                    idx := bigIntTo32Bytes(nodeNo)
-                    if _, ok := lvl.node[idx]; ok {
-                        //fmt.Printf("Deleted node '%s' at level %d\n", nodeNo, lvl.num)
-                        delete(lvl.node, idx)
+                    if _, ok := lvl.node[nodeNo]; ok {
+                        //fmt.Printf("Deleted node '%s' at level %d\n", hashStr(nodeNo), lvl.num)
+                        delete(lvl.node, nodeNo)
+                        delete(lvl.newNodes, nodeNo)
                     }
                 },
                 nil)
@@ -624,14 +1339,14 @@ func (tree *Tree) _isCorrectlyConstructedProof() bool {
     tree._visitNodesByLevel(
         nil,
         func(lvl *TreeLevel, nodeIdx [32]byte, node *Node) {
-            if !tree._hasEmptySubtree(lvl.num, hashToInt(nodeIdx)) {
+            if !tree._hasEmptySubtree(lvl.num, nodeIdx) {
                 failed = true
             }
         })
     return !failed
 }
 
-func (tree *Tree) isAncestor(levelA int, ancestor *big.Int, levelD int, descendant *big.Int) bool {
+func (tree *Tree) isAncestor(levelA int, ancestor [32]byte, levelD int, descendant [32]byte) bool {
     if levelD <= levelA {
         //return false
         //fmt.Printf(
@@ -639,22 +1354,17 @@ func (tree *Tree) isAncestor(levelA int, ancestor *big.Int, levelD int, descenda
     }
 
     var levelDiff int = levelD - levelA
-    var ancestorExpected big.Int = *descendant
-    for i := 0; i < levelDiff; i++ {
-        ancestorExpected.Div(&ancestorExpected, tree.Two)
-    }
-
-    return ancestorExpected.Cmp(ancestor) == 0
+    return rshBytes(descendant, uint(levelDiff)) == ancestor
 }
 
-func (tree *Tree) _hasEmptySubtree(level int, nodeNo *big.Int) bool {
+func (tree *Tree) _hasEmptySubtree(level int, nodeNo [32]byte) bool {
     var failed bool = false
     // NOTE: starts from the bottom-most level (i.e., the leaves)
     tree._visitNodesByLevel(
         nil,
         func(lvl *TreeLevel, nodeIdx [32]byte, node *Node) {
             if level < lvl.num {
-                if tree.isAncestor(level, nodeNo, lvl.num, hashToInt(nodeIdx)) {
+                if tree.isAncestor(level, nodeNo, lvl.num, nodeIdx) {
                     failed = true
                 } else {
                     //fmt.Printf("Not an ancestor!\n")
@@ -680,8 +1390,9 @@ func (tree *Tree) Print(includeNew bool) {
     }, func(lvl *TreeLevel, nodeIdx [32]byte, node *Node) {
         var nodeNo big.Int
         nodeNo.SetBytes(nodeIdx[:])
-        if !node.IsNew || (node.IsNew && includeNew) {
-            fmt.Printf("\n\t%s -> hash %s, %v", &nodeNo, hashStr(node.Hash), node.IsNew)
+        isNew := lvl.isNew(nodeIdx)
+        if !isNew || includeNew {
+            fmt.Printf("\n\t%s -> hash %s, %v", &nodeNo, hashStr(node.Hash), isNew)
         }
     })
     fmt.Println()
@@ -707,20 +1418,27 @@ func (tree *Tree) PrintSummary() {
     fmt.Printf("Root node hash: %s\n", hashStr(tree.GetRootHash()))
 }
 
+// The maximum # of leaves of a batch that hashsparse() proves the membership of via a multiproof
+const maxMultiProofLeaves = 1024
+
 /**
  * Simulates inserting numBatches batches of public keys in a sparse Merkle tree
  * of size 2^256 leaves (and ((2^257) - 1) nodes in total). Each batch has
  * batchSize public keys in it. The seed parameter is used to seed a (Secure?
  * Doesn't matter.) PRNG that is used to generate email addresses like
  * 'aliceX@wonderland.com' which are hashed to produce leaf numbers.
+ * Returns the tree, with one recorded epoch per batch.
  */
-func hashsparse(sizes []int, seed int64, csvFile string) {
+func hashsparse(sizes []int, seed int64, csvFile string, opts BenchOptions) *Tree {
     memGc()
 
-    // Initialize some bytes that we'll hash repeatedly to obtain leaf no's
-    var randKey [32]byte = bigIntTo32Bytes(big.NewInt(seed))
+    gen := opts._newLeafSource(seed)
 
-    tree := NewTree(257)
+    tree, err := NewTreeWithOptions(257, TreeOptions{CachedLevels: opts.CachedLevels, SlabSize: opts.SlabSize, ProofTreeMaxNodes: opts.ProofTreeMaxNodes})
+    if err != nil {
+        panic(err.Error())
+    }
+    tree.SetMetrics(opts.Metrics)
 
     // We insert the dummy leaf 0, to make sure we have a non-empty tree, which
     // makes our consistency proof code easier to write
@@ -728,94 +1446,330 @@ func hashsparse(sizes []int, seed int64, csvFile string) {
     if tree.GetNumNodes() != 257 {
         panic("Tree size is supposed to be 257 nodes")
     }
+    tree.RecordEpoch()
 
-    f, err := os.Create(csvFile)
-    if err != nil {
-        panic("Error opening file: " + err.Error())
+    // When resuming, load the snapshot of the last completed batch (i.e., of epoch 'numCompleted'),
+    // if we saved one, or rebuild the tree as of that batch, one epoch per batch
+    prevSize := 1
+    numCompleted := opts._numCompletedSizes(csvFile, sizes)
+    var snapshot *Tree
+    if numCompleted > 0 {
+        snapshot = opts._loadSnapshot(numCompleted)
+    }
+    if snapshot != nil {
+        fmt.Printf("Loaded snapshot of epoch %d\n", numCompleted)
+        tree = snapshot
+        tree.SetMetrics(opts.Metrics)
+        prevSize = sizes[numCompleted-1]
+        // The leaves must still be generated, so that the next batches insert the same leaves
+        for j := 1; j < prevSize; j++ {
+            gen.next()
+        }
+    } else {
+        for i := 0; i < numCompleted; i++ {
+            fmt.Printf("Rebuilding completed batch of size %v ...\n", sizes[i]-prevSize)
+            for j := 0; j < sizes[i]-prevSize; j++ {
+                leafNo, dataHash := gen.next()
+                tree.Insert(leafNo, dataHash, nil)
+            }
+            tree.RecordEpoch()
+            prevSize = sizes[i]
+        }
     }
-    fmt.Fprintf(f, "dictSize,appendOnlyProofSize,verifyUsec,\n")
 
-    prevSize := 1
-    for i := 0; i < len(sizes); i++ {
+    // The reference tree starts with the leaves so far, which must already match
+    var ref *referenceTree
+    var refRoot [32]byte
+    if opts.Paranoid {
+        fmt.Printf("Building reference tree... ")
+        ref = newReferenceTree(tree)
+        if refRoot, err = ref.check(tree, tree.NumEpochs()-1); err != nil {
+            panic("Tree does not match the reference tree: " + err.Error())
+        }
+        fmt.Printf("Done.\n")
+    }
+
+    csv := opts._openCsv(csvFile, []string{"dictSize", "appendOnlyProofSize", "verifyUsec", "heapMB", "appendOnlyProofBytes", "appendOnlyProofCompressedBytes",
+        "proofBuildUsec", "proofCompressUsec", "verifyUsecStddev", "proofBuildUsecStddev", "proofCompressUsecStddev",
+        "heapInUseBytes", "heapInUseDeltaBytes", "totalAllocBytes", "numMallocs", "peakRssMB",
+        "multiProofLeaves", "multiProofBytes", "membershipProofsBytes", "numGc", "gcPauseUsec",
+        "appendOnlyProofPathsBytes", "appendOnlyProofForm", "insertUsec", "insertsPerSec",
+        "proofTreePeakNodes", "proofTreeCompressions", "validationFailures", "validationFailed"})
+
+    numFailedBatches := 0
+    for i := numCompleted; i < len(sizes); i++ {
         newSize := sizes[i]
         fmt.Printf("\nAppending new batch of size %v ...\n", newSize-prevSize)
-        proofTree := NewTree(257)
+        // The heap was garbage collected after the previous batch, so the deltas are comparable
+        var memBefore runtime.MemStats
+        runtime.ReadMemStats(&memBefore)
+
+        // The measurements that are skipped (see BenchOptions::NoProofs, NoVerify and NoCompress)
+        // stay -1, and are left empty in the CSV file
+        proofSize, oldProofSize, numEmpty := int64(-1), int64(-1), int64(-1)
+        proofBytes, compressedBytes, pathsBytes := int64(-1), int64(-1), int64(-1)
+        proofBuildUsec, proofBuildStddev := int64(-1), int64(-1)
+        proofCompressUsec, proofCompressStddev := int64(-1), int64(-1)
+        proofVerifyUsec, proofVerifyStddev := int64(-1), int64(-1)
+        multiProofLeaves, multiProofBytes, membershipProofsBytes := int64(-1), int64(-1), int64(-1)
+        proofTreePeakNodes, proofTreeCompressions := int64(-1), int64(-1)
+        proofForm := ""
+        var proof *AppendOnlyProof
+        checks := &benchChecks{keepGoing: opts.KeepGoing}
+
+        var insertElapsed time.Duration
+        if opts.NoProofs {
+            startTime := time.Now()
+            for j := 0; j < newSize-prevSize; j++ {
+                leafNo, dataHash := gen.next()
+                tree.Insert(leafNo, dataHash, nil)
+                if ref != nil {
+                    ref.insert(leafNo, dataHash)
+                }
+            }
+            insertElapsed = time.Since(startTime)
+            tree.RecordEpoch()
+        } else {
+            batch := tree.BeginEpoch()
 
-        oldRootHash := tree.GetRootHash()
+            oldRootHash := batch.OldRoot()
 
-        startTime := time.Now()
-        for j := 0; j < newSize-prevSize; j++ {
-            randKey = sha256.Sum256(randKey[:])
-            dataHash := sha256.Sum256([]byte(fmt.Sprintf("Data for leaf %v", hashStr(randKey))))
+            startTime := time.Now()
+            for j := 0; j < newSize-prevSize; j++ {
+                leafNo, dataHash := gen.next()
 
-            //fmt.Println("Inserting key %s with value %s", hashStr(randKey), hashStr(dataHash))
-            tree.Insert(randKey, dataHash, proofTree)
-        }
-        insertElapsed := time.Since(startTime)
+                //fmt.Println("Inserting key %s with value %s", hashStr(leafNo), hashStr(dataHash))
+                if !checks.check("insert", batch.Insert(leafNo, dataHash)) {
+                    continue
+                }
+                if ref != nil {
+                    ref.insert(leafNo, dataHash)
+                }
+            }
+            insertElapsed = time.Since(startTime)
 
-        newRootHash := tree.GetRootHash()
+            newRootHash := tree.GetRootHash()
 
-        if oldRootHash == newRootHash {
-            panic("Something's off: old and new root hash are the same")
-        }
-        fmt.Printf("Old root: %v\nNew root: %v\n", hashStr(oldRootHash), hashStr(newRootHash))
+            if oldRootHash == newRootHash {
+                checks.check("same-root", fmt.Errorf("Something's off: old and new root hash are the same"))
+            }
+            fmt.Printf("Old root: %v\nNew root: %v\n", hashStr(oldRootHash), hashStr(newRootHash))
+
+            // There will be some extra nodes in the proof that we can eliminate
+            fmt.Printf("Getting number of nodes in proof... ")
+            proofTree := batch.proofTree
+            oldProofSize = proofTree.GetNumNodes()
+            if oldProofSize == 0 {
+                checks.check("empty-proof-tree", fmt.Errorf("Cannot have proof tree be of size 0"))
+            }
+            fmt.Printf("Done.\n")
+
+            // The first measurement of proof building and compression is the actual one, done as part
+            // of the epoch, and the others are done from scratch on the side (before the 'new' flags are cleared)
+            buildTimes := []time.Duration{batch.ProofBuildTime()}
+            var compressTimes []time.Duration
+            if opts._numRepeats() > 1 {
+                fmt.Printf("Rebuilding and compressing proof %d more times... ", opts._numRepeats()-1)
+                for r := 1; r < opts._numRepeats(); r++ {
+                    startTime = time.Now()
+                    rebuilt := batch._rebuildProofTree()
+                    buildTimes = append(buildTimes, time.Since(startTime))
+
+                    if !opts.NoCompress {
+                        startTime = time.Now()
+                        _compressAndFlatten(rebuilt)
+                        compressTimes = append(compressTimes, time.Since(startTime))
+                    }
+                }
+                fmt.Printf("Done.\n")
+            }
 
-        // There will be some extra nodes in the proof that we can eliminate
-        fmt.Printf("Getting number of nodes in proof... ")
-        oldProofSize := proofTree.GetNumNodes()
-        if oldProofSize == 0 {
-            panic("Cannot have proof tree be of size 0")
-        }
-        fmt.Printf("Done.\n")
+            //fmt.Printf("Proof (uncompressed) size: %v\n", oldProofSize)
+            // Compresses the proof (unless asked not to), clears the 'new' marks and records the epoch
+            fmt.Printf("Committing epoch... ")
+            proof, _ = batch._commit(!opts.NoCompress)
+            epoch := tree.NumEpochs() - 1
+            if !opts.NoCompress {
+                compressTimes = append([]time.Duration{batch.ProofCompressTime()}, compressTimes...)
+            }
+            proofTreePeakNodes, proofTreeCompressions = batch.ProofTreePeakNodes(), int64(batch.NumProofCompressions())
+            fmt.Printf("Done.\n")
+
+            //tree.Print()
+            //proofTree.Print(false)
+            //proofTree.Print(true)
+            if opts.DotFile != "" && opts.DotBatch == i+1 {
+                opts._saveProofDOT(proofTree)
+            }
 
-        //fmt.Printf("Proof (uncompressed) size: %v\n", oldProofSize)
-        fmt.Printf("Compressing proof... ")
-        proofTree._compressProofTree()
-        fmt.Printf("Done.\n")
+            // Uncompressed proofs do not verify, so they are not worth saving
+            if !opts.NoCompress {
+                opts._saveProof(tree, proof, epoch-1, epoch)
+            }
+            //fmt.Printf("Asserting 'new' flag is cleared... ")
+            //tree._assertNoNewNodes()
+            //fmt.Printf("Done.\n")
+
+            // NOTE: Slows us down, so commenting it out. Tested proof to be correctly computed in the past.
+            //if !proofTree._isCorrectlyConstructedProof() {
+            //    panic("Proof is not correctly computed. Check your code.");
+            //}
+
+            var compressed []byte
+            if !opts.NoCompress {
+                compressed, err = proof.MarshalCompressed()
+                if checks.check("compact-encode", _prefixErr("Error encoding proof compactly: ", err)) {
+                    compressedBytes = int64(len(compressed))
+                }
+            }
+
+            if !opts._skipVerify() {
+                fmt.Printf("Verifying proof... ")
+                var verifyTimes []time.Duration
+                for r := 0; r < opts._numRepeats(); r++ {
+                    startTime = time.Now()
+                    err := VerifyAppendOnlyProofTree(proofTree, oldRootHash, newRootHash)
+                    if !checks.check("verify", _prefixErr("Invalid consistency proof was generated: ", err)) {
+                        break
+                    }
+                    verifyTimes = append(verifyTimes, time.Since(startTime))
+                    if opts.Metrics != nil {
+                        opts.Metrics.ObserveVerify(verifyTimes[len(verifyTimes)-1])
+                    }
+                }
+                if len(verifyTimes) > 0 {
+                    proofVerifyUsec, proofVerifyStddev = _meanStddevUsec(verifyTimes)
+                }
+                fmt.Printf("Done.\n")
+
+                // Make sure the compact encoding decodes to a proof that checks against the same roots
+                fmt.Printf("Verifying compact proof encoding... ")
+                decoded := &AppendOnlyProof{}
+                if checks.check("compact-decode", _prefixErr("Error decoding compact proof: ", decoded.UnmarshalCompressed(compressed))) {
+                    decodedTree, err := decoded.ToTree(tree.NewEmptyTree())
+                    if checks.check("compact-decode", _prefixErr("Error rebuilding compact proof: ", err)) {
+                        err = VerifyAppendOnlyProofTree(decodedTree, oldRootHash, newRootHash)
+                        checks.check("compact-verify", _prefixErr("Compact proof encoding does not decode to a valid proof: ", err))
+                    }
+                }
+                fmt.Printf("Done.\n")
+
+                // Same for the form picked by Commit(), which may be the paths form
+                fmt.Printf("Verifying %s proof encoding... ", proof.Form)
+                adaptive, err := proof.MarshalAdaptive()
+                if checks.check("adaptive-encode", _prefixErr("Error encoding proof adaptively: ", err)) {
+                    decoded = &AppendOnlyProof{}
+                    if checks.check("adaptive-decode", _prefixErr("Error decoding adaptive proof: ", decoded.UnmarshalAdaptive(adaptive))) {
+                        err = decoded.VerifyWithOptions(oldRootHash, newRootHash, tree.Options())
+                        checks.check("adaptive-verify", _prefixErr("Adaptive proof encoding does not decode to a valid proof: ", err))
+                    }
+                }
+                fmt.Printf("Done.\n")
+            }
 
-        //tree.Print()
-        //proofTree.Print(false)
-        //proofTree.Print(true)
+            numEmpty = proofTree.GetNumEmptySiblings()
+            proofSize = proofTree.GetNumNodes()
+            proofBytes = proof.SizeBytes()
+            pathsBytes = proof.PathsSize()
+            proofForm = proof.Form.String()
+            proofBuildUsec, proofBuildStddev = _meanStddevUsec(buildTimes)
+            if !opts.NoCompress {
+                proofCompressUsec, proofCompressStddev = _meanStddevUsec(compressTimes)
+            }
 
-        // Have to set IsNew flag to false once proof is computed
-        fmt.Printf("Clearing 'new' flag... ")
-        tree.clearNewFlag()
-        fmt.Printf("Done.\n")
-        //fmt.Printf("Asserting 'new' flag is cleared... ")
-        //tree._assertNoNewNodes()
-        //fmt.Printf("Done.\n")
+            // Prove the membership of (some of) the batch's leaves at once, vs. one at a time
+            multiLeaves := batch.leaves
+            if len(multiLeaves) > maxMultiProofLeaves {
+                multiLeaves = multiLeaves[:maxMultiProofLeaves]
+            }
+            multiProof := tree.ProveMembershipBatch(multiLeaves)
+            if !opts._skipVerify() && !VerifyMembershipBatch(multiProof, newRootHash) {
+                checks.check("multiproof", fmt.Errorf("Invalid membership multiproof was generated"))
+            }
+            multiProofLeaves = int64(multiProof.NumLeaves())
+            multiProofBytes = multiProof.SizeBytes()
+            membershipProofsBytes = multiProofLeaves * MembershipProofSize(tree.numLevels)
+        }
+        epoch := tree.NumEpochs() - 1
+
+        if ref != nil {
+            fmt.Printf("Cross-checking against reference tree... ")
+            oldRefRoot := refRoot
+            refRoot, err = ref.check(tree, epoch)
+            // Uncompressed proofs do not verify
+            if checks.check("reference-root", _prefixErr("Tree does not match the reference tree: ", err)) && proof != nil && !opts.NoCompress {
+                err = proof.VerifyWithOptions(oldRefRoot, refRoot, tree.Options())
+                checks.check("reference-proof", _prefixErr("Append-only proof does not verify against the reference tree's roots: ", err))
+            }
+            fmt.Printf("Done.\n")
+        }
 
-        // NOTE: Slows us down, so commenting it out. Tested proof to be correctly computed in the past.
-        //if !proofTree._isCorrectlyConstructedProof() {
-        //    panic("Proof is not correctly computed. Check your code.");
-        //}
+        insertsPerSec := float64(newSize-prevSize) / insertElapsed.Seconds()
+        if opts.NoProofs {
+            fmt.Printf("# kv's: %v, # tree nodes: %v, insert time: %s (no proofs, %.0f inserts/sec)\n",
+                newSize, tree.GetNumNodes(), insertElapsed, insertsPerSec)
+        } else {
+            fmt.Printf(
+                "# kv's: %v, "+
+                    "# tree nodes: %v, "+
+                    "proof size: %s (%s bytes, %s bytes compact, %s bytes as paths, picked %s) "+
+                    "(uncompressed size: %s, # empty hashes: %s)\n"+
+                    "Insert time: %s (incl. proof build, %.0f inserts/sec), "+
+                    "proof build time: %s +/- %s usec, "+
+                    "proof compress time: %s +/- %s usec, "+
+                    "proof verify time: %s +/- %s usec (over %d runs)\n",
+                newSize,
+                tree.GetNumNodes(),
+                _comparePrint(proofSize), _comparePrint(proofBytes), _comparePrint(compressedBytes), _comparePrint(pathsBytes), proofForm,
+                _comparePrint(oldProofSize), _comparePrint(numEmpty),
+                insertElapsed, insertsPerSec,
+                _comparePrint(proofBuildUsec), _comparePrint(proofBuildStddev),
+                _comparePrint(proofCompressUsec), _comparePrint(proofCompressStddev),
+                _comparePrint(proofVerifyUsec), _comparePrint(proofVerifyStddev), opts._numRepeats())
+        }
+        if proofTreeCompressions > 0 {
+            fmt.Printf("Proof tree peaked at %d nodes, compressed %d times before the commit\n", proofTreePeakNodes, proofTreeCompressions)
+        }
+        if multiProofLeaves >= 0 {
+            fmt.Printf("Multiproof for %d leaves: %v bytes (vs. %v bytes for separate proofs)\n",
+                multiProofLeaves, multiProofBytes, membershipProofsBytes)
+        }
 
-        fmt.Printf("Verifying proof... ")
-        startTime = time.Now()
-        if VerifyAppendOnlyProof(proofTree, oldRootHash, newRootHash) == false {
-            panic("Invalid consistency proof was generated")
+        prefixStats := tree.GetPrefixStats(epoch, epoch)
+        fmt.Printf("Key-space prefix stats: %v\n", prefixStats)
+        if prefixStats.Skewed {
+            fmt.Printf("WARNING: Skewed inserts in hot prefixes %v\n", prefixStats.HotPrefixes(2))
         }
-        proofVerifyTime := time.Since(startTime)
-        fmt.Printf("Done.\n")
 
-        numEmpty := proofTree.GetNumEmptySiblings()
-        proofSize := proofTree.GetNumNodes()
-        fmt.Printf(
-            "# kv's: %v, "+
-                "# tree nodes: %v, "+
-                "proof size: %v "+
-                "(uncompressed size: %v, # empty hashes: %d)\n"+
-                "Insert time: %s, "+
-                "proof verify time: %s usec\n",
-            newSize,
-            tree.GetNumNodes(),
-            proofSize,
-            oldProofSize, numEmpty,
-            insertElapsed,
-            proofVerifyTime)
-
-        proofVerifyUsec := int64(proofVerifyTime / time.Microsecond)
-        fmt.Fprintf(f, "%v, %v, %v,\n", newSize, proofSize, proofVerifyUsec)
+        // Measure the memory after the proof tree is no longer referenced, to be comparable with hashcompact()
+        heapMB := heapUsageAfterGc()
+
+        // Allocations during the batch include the proof's, even though it is garbage by now
+        var memAfter runtime.MemStats
+        runtime.ReadMemStats(&memAfter)
+        heapDelta := int64(memAfter.HeapInuse) - int64(memBefore.HeapInuse)
+        totalAlloc := memAfter.TotalAlloc - memBefore.TotalAlloc
+        numMallocs := memAfter.Mallocs - memBefore.Mallocs
+        peakRss := peakRssMB()
+        fmt.Printf("Heap: %v MB (in use: %v bytes, %+d bytes in this batch), "+
+            "allocated in this batch: %v bytes in %v mallocs, peak RSS: %v MB\n",
+            heapMB, memAfter.HeapInuse, heapDelta, totalAlloc, numMallocs, peakRss)
+
+        // Includes the GC forced by heapUsageAfterGc(), whose pause grows with the # of heap objects
+        numGc := memAfter.NumGC - memBefore.NumGC
+        gcPauseUsec := int64(memAfter.PauseTotalNs-memBefore.PauseTotalNs) / 1000
+        fmt.Printf("GC: %v cycles, paused for %v usec in this batch\n", numGc, gcPauseUsec)
+        opts._saveHeapProfile(epoch)
+
+        csv.Write(newSize, _compareCell(proofSize), _compareCell(proofVerifyUsec), heapMB, _compareCell(proofBytes), _compareCell(compressedBytes),
+            _compareCell(proofBuildUsec), _compareCell(proofCompressUsec), _compareCell(proofVerifyStddev), _compareCell(proofBuildStddev), _compareCell(proofCompressStddev),
+            memAfter.HeapInuse, heapDelta, totalAlloc, numMallocs, peakRss,
+            _compareCell(multiProofLeaves), _compareCell(multiProofBytes), _compareCell(membershipProofsBytes), numGc, gcPauseUsec,
+            _compareCell(pathsBytes), proofForm, insertElapsed.Microseconds(), int64(insertsPerSec),
+            _compareCell(proofTreePeakNodes), _compareCell(proofTreeCompressions), len(checks.failed), checks.cell())
+        if len(checks.failed) > 0 {
+            numFailedBatches++
+        }
 
         //if (i + 1) % 4000 == 0 {
         //    fmt.Println("Garbage collecting at i = %d...", i)
@@ -825,7 +1779,17 @@ func hashsparse(sizes []int, seed int64, csvFile string) {
         prevSize = newSize
     }
 
+    if err := csv.Close(); err != nil {
+        panic("Error writing CSV file: " + err.Error())
+    }
+    opts._saveSnapshot(tree, tree.NumEpochs()-1)
+    if numFailedBatches > 0 {
+        fmt.Printf("\nWARNING: %d of %d batches failed checks, see the 'validationFailed' column\n", numFailedBatches, len(sizes)-numCompleted)
+    }
+
     fmt.Printf("\n")
     memGc()
     //tree.PrintSummary()
+
+    return tree
 }
@@ -0,0 +1,88 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "testing"
+)
+
+/**
+ * Returns the i-th test leaf.
+ */
+func _testLeaf(i int) LeafData {
+    var key [8]byte
+    binary.BigEndian.PutUint64(key[:], uint64(i))
+    return LeafData{LeafNo: sha256.Sum256(key[:]), DataHash: sha256.Sum256(append(key[:], 'v'))}
+}
+
+/**
+ * Updates append to a leaf's version chain, so the leaf's history keeps every data hash it had.
+ */
+func TestUpdateKeepsHistory(t *testing.T) {
+    tree := NewTree(257)
+    leaf := _testLeaf(0)
+    tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    if history := tree.GetHistory(leaf.LeafNo); len(history) != 1 || history[0] != leaf.DataHash {
+        t.Fatalf("history of an inserted leaf is %v", history)
+    }
+
+    versions := [][32]byte{leaf.DataHash}
+    for i := 1; i <= 3; i++ {
+        root := tree.GetRootHash()
+        newDataHash := _testLeaf(i).DataHash
+        tree.Update(leaf.LeafNo, newDataHash, nil)
+        versions = append(versions, newDataHash)
+        if tree.GetRootHash() == root {
+            t.Fatalf("update %d did not change the root", i)
+        }
+    }
+    history := tree.GetHistory(leaf.LeafNo)
+    if len(history) != len(versions) {
+        t.Fatalf("history has %d versions, expected %d", len(history), len(versions))
+    }
+    for i := range versions {
+        if history[i] != versions[i] {
+            t.Errorf("version %d is %s, expected %s", i, hashStr(history[i]), hashStr(versions[i]))
+        }
+    }
+
+    if history := tree.GetHistory(_testLeaf(1000).LeafNo); history != nil {
+        t.Errorf("history of a missing leaf is %v", history)
+    }
+}
+
+/**
+ * Append-only proofs of batches that insert new leaves and update old and new ones must verify.
+ */
+func TestAppendOnlyProofWithUpdates(t *testing.T) {
+    tree := NewTree(257)
+    for i := 0; i < 50; i++ {
+        leaf := _testLeaf(i)
+        tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    }
+    oldRoot := tree.GetRootHash()
+
+    proofTree := NewTree(257)
+    for i := 50; i < 80; i++ {
+        leaf := _testLeaf(i)
+        tree.Insert(leaf.LeafNo, leaf.DataHash, proofTree)
+    }
+    // Some leaves are updated several times
+    for i := 0; i < 80; i += 5 {
+        leafNo := _testLeaf(i).LeafNo
+        tree.Update(leafNo, _testLeaf(-i).DataHash, proofTree)
+        if i%3 == 0 {
+            tree.Update(leafNo, oldRoot, proofTree)
+        }
+    }
+    newRoot := tree.GetRootHash()
+    proofTree._compressProofTree()
+    tree._clearNewNodes()
+
+    if !VerifyAppendOnlyProof(proofTree, oldRoot, newRoot) {
+        t.Fatalf("proof does not verify")
+    }
+    if VerifyAppendOnlyProof(proofTree, newRoot, oldRoot) {
+        t.Errorf("proof verifies with the roots swapped")
+    }
+}
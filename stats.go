@@ -0,0 +1,365 @@
+package aomt
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "time"
+)
+
+/**
+ * Insert density per key-space prefix over a range of epochs. Leaf numbers are supposed to be
+ * uniformly distributed (they are hashes), so a skewed distribution indicates a broken indexer or
+ * an adversary grinding keys to make some paths (and thus proofs) longer.
+ */
+type PrefixStats struct {
+    FromEpoch  int
+    ToEpoch    int
+    PrefixBits int     // the # of top bits of the leaf no used to bucket the inserts
+    Counts     []int64 // Counts[p] is the # of leaves inserted with prefix 'p'
+    NumInserts int64
+
+    MaxToMean float64 // the count of the most popular prefix divided by the mean count
+    ChiSquare float64 // Pearson's chi-squared statistic, against the uniform distribution
+    Skewed    bool    // true if the uniform distribution is rejected with p < 0.001
+}
+
+// We need at least this many expected inserts per bucket for the chi-squared test to be meaningful
+const minExpectedPerPrefix = 5
+
+// The maximum # of prefix bits we bucket inserts by
+const maxPrefixBits = 8
+
+/**
+ * Computes the prefix statistics for the leaves inserted in epochs 'fromEpoch' through 'toEpoch'
+ * (inclusive). The # of prefix bits is picked so that we expect enough inserts per prefix.
+ */
+func (tree *Tree) GetPrefixStats(fromEpoch int, toEpoch int) *PrefixStats {
+    if fromEpoch < 0 || toEpoch >= len(tree.epochs) || fromEpoch > toEpoch {
+        panic(fmt.Sprintf("Invalid epoch range [%d, %d] (have %d epochs)", fromEpoch, toEpoch, len(tree.epochs)))
+    }
+
+    stats := &PrefixStats{FromEpoch: fromEpoch, ToEpoch: toEpoch}
+    for e := fromEpoch; e <= toEpoch; e++ {
+        for _, change := range tree.epochs[e].changes {
+            if !change.isUpdate {
+                stats.NumInserts++
+            }
+        }
+    }
+
+    for stats.PrefixBits < minInt(maxPrefixBits, tree.numLevels-1) &&
+        stats.NumInserts>>uint(stats.PrefixBits+1) >= minExpectedPerPrefix {
+        stats.PrefixBits++
+    }
+
+    stats.Counts = make([]int64, 1<<uint(stats.PrefixBits))
+    for e := fromEpoch; e <= toEpoch; e++ {
+        for _, change := range tree.epochs[e].changes {
+            if !change.isUpdate {
+                stats.Counts[_leafPrefix(change.leafNo, tree.numLevels-1, stats.PrefixBits)]++
+            }
+        }
+    }
+
+    stats._computeSkew()
+    return stats
+}
+
+/**
+ * Returns the top 'bits' bits (at most 8) of the leaf no, which has 'leafBits' bits.
+ */
+func _leafPrefix(leafNo [32]byte, leafBits int, bits int) int {
+    return int(rshBytes(leafNo, uint(leafBits-bits))[31])
+}
+
+func (stats *PrefixStats) _computeSkew() {
+    numPrefixes := len(stats.Counts)
+    if numPrefixes < 2 {
+        // Too few inserts to say anything
+        return
+    }
+
+    mean := float64(stats.NumInserts) / float64(numPrefixes)
+    var max int64
+    for _, count := range stats.Counts {
+        diff := float64(count) - mean
+        stats.ChiSquare += diff * diff / mean
+        if count > max {
+            max = count
+        }
+    }
+    stats.MaxToMean = float64(max) / mean
+
+    // Approximate the chi-squared critical value for p = 0.001 via the Wilson-Hilferty transform
+    dof := float64(numPrefixes - 1)
+    z := 3.090 // the standard normal quantile for 0.999
+    critical := dof * math.Pow(1-2/(9*dof)+z*math.Sqrt(2/(9*dof)), 3)
+    stats.Skewed = stats.ChiSquare > critical
+}
+
+/**
+ * Returns the prefixes that received more than 'factor' times the mean # of inserts.
+ */
+func (stats *PrefixStats) HotPrefixes(factor float64) []int {
+    var hot []int
+    if stats.NumInserts == 0 {
+        return hot
+    }
+
+    mean := float64(stats.NumInserts) / float64(len(stats.Counts))
+    for prefix, count := range stats.Counts {
+        if float64(count) > factor*mean {
+            hot = append(hot, prefix)
+        }
+    }
+    return hot
+}
+
+func (stats *PrefixStats) String() string {
+    return fmt.Sprintf("%d inserts over %d prefixes of %d bits, max/mean: %.2f, chi^2: %.2f, skewed: %v",
+        stats.NumInserts, len(stats.Counts), stats.PrefixBits, stats.MaxToMean, stats.ChiSquare, stats.Skewed)
+}
+
+/**
+ * The shape of the tree and the proof sizes it leads to, i.e., the quantities reported when
+ * evaluating the tree (see Tree::Stats()).
+ */
+type TreeStats struct {
+    NumLeaves     int64
+    NumNodes      int64
+    NodesPerLevel []int64 // NodesPerLevel[l] is the # of nodes on level l
+
+    // The level of the lowest ancestor each leaf shares with another leaf, below which the leaf's
+    // path has no other leaves: about log2(NumLeaves) when leaf no's are uniformly distributed
+    MeanSharedDepth float64
+    MaxSharedDepth  int
+
+    // Measured along the paths to 'NumSampledPaths' random leaves, which are almost all absent
+    NumSampledPaths      int
+    MeanEmptySiblings    float64
+    MeanNonEmptySiblings float64
+
+    // Proof size estimates, in bytes
+    MembershipProofBytes           int64   // as in MembershipProof, with one hash per sibling
+    CompressedMembershipProofBytes float64 // with a bitmap of the empty siblings instead of their hashes
+    AppendOnlyProofBytes           float64 // of the paths form (see PathsSize()) for inserting one random leaf
+
+    // The same proof sizes, as expected for the tree's # of leaves rather than measured on its paths
+    Expected *ProofSizeEstimate
+}
+
+// The # of random paths Tree::Stats() samples, and the seed it samples them with, so that the
+// statistics of a tree are reproducible
+const (
+    numStatsPaths = 1000
+    statsSeed     = 1337
+)
+
+/**
+ * Computes the tree's statistics. Only the nodes in memory are counted, so the counts of a pruned
+ * tree (see Tree::Prune()) are those of its unpruned part.
+ */
+func (tree *Tree) Stats() *TreeStats {
+    leafLevel := tree.numLevels - 1
+    stats := &TreeStats{NodesPerLevel: make([]int64, tree.numLevels)}
+    for level := 0; level <= leafLevel; level++ {
+        stats.NodesPerLevel[level] = int64(len(tree.lvl[level].node))
+        stats.NumNodes += stats.NodesPerLevel[level]
+    }
+    stats.NumLeaves = tree.NumLeaves()
+
+    tree._sharedDepthStats(stats)
+    tree._pathStats(stats)
+    stats.Expected = EstimateProofSizes(tree.numLevels, stats.NumLeaves)
+    return stats
+}
+
+/**
+ * The proof sizes expected in a tree with 'NumLeaves' leaves whose leaf no's are uniformly
+ * distributed (see EstimateProofSizes()). Unlike TreeStats, these need no walk of the tree, so
+ * they are cheap enough for servers to report on every request.
+ */
+type ProofSizeEstimate struct {
+    NumLeaves int64 `json:"numLeaves"`
+
+    // The expected # of non-empty siblings along the path to a leaf in the tree
+    NonEmptySiblings float64 `json:"nonEmptySiblings"`
+
+    // In bytes, as in TreeStats
+    MembershipProofBytes           int64   `json:"membershipProofBytes"`
+    CompressedMembershipProofBytes float64 `json:"compressedMembershipProofBytes"`
+    AppendOnlyProofBytes           float64 `json:"appendOnlyProofBytes"`
+}
+
+/**
+ * Estimates the proof sizes in a tree with 'numLevels' levels and 'numLeaves' uniformly distributed
+ * leaves: the sibling on level l of a leaf's path roots a subtree with a 2^-l fraction of the leaf
+ * no's, so it is non-empty with probability 1 - (1 - 2^-l)^(numLeaves - 1). The append-only proof
+ * size is approximate, since it treats the siblings of a new leaf's path as independent of where
+ * the path leaves the tree.
+ */
+func EstimateProofSizes(numLevels int, numLeaves int64) *ProofSizeEstimate {
+    leafLevel := numLevels - 1
+    est := &ProofSizeEstimate{NumLeaves: numLeaves, MembershipProofBytes: 32 * int64(leafLevel)}
+    others := numLeaves - 1
+    if others < 0 {
+        others = 0
+    }
+
+    // 'others' leaves besides the path's own fall in a subtree of a 2^-level fraction of the leaf
+    // no's with probability 1 - (1 - 2^-level)^others, computed via log1p() and expm1() so that it
+    // does not round to 0 on low levels
+    occupied := func(level int, others int64) float64 {
+        return -math.Expm1(float64(others) * math.Log1p(-math.Ldexp(1, -level)))
+    }
+
+    // The path to a new leaf exists down to the level of its lowest existing ancestor, and the
+    // proof has the non-empty siblings along it (see Tree::_pathStats())
+    var newSiblings float64
+    newLevel := 1.0
+    for level := 1; level <= leafLevel; level++ {
+        p := occupied(level, numLeaves)
+        if p < 1e-12 {
+            break
+        }
+        est.NonEmptySiblings += occupied(level, others)
+        newSiblings += p
+        newLevel += p
+    }
+    newLevel = math.Min(newLevel, float64(leafLevel))
+
+    est.CompressedMembershipProofBytes = float64((leafLevel+7)/8) + 32*est.NonEmptySiblings
+    est.AppendOnlyProofBytes = 4 + 2 + float64(_lnNumBytes(int(math.Ceil(newLevel)))) + 1 + 32 + math.Ceil(newLevel/8) + 32*newSiblings
+    return est
+}
+
+/**
+ * Computes the shared depths of the leaves: in sorted order, a leaf's lowest ancestor shared with
+ * another leaf is its lowest common ancestor with the previous or the next leaf.
+ */
+func (tree *Tree) _sharedDepthStats(stats *TreeStats) {
+    leaves := tree.lvl[tree.numLevels-1].sortedNodeNos()
+    if len(leaves) < 2 {
+        return
+    }
+
+    // Leaf no's have 'tree.numLevels - 1' bits, so their top bits are always in common
+    topBits := 256 - (tree.numLevels - 1)
+    prefixLens := make([]int, len(leaves)-1)
+    for i := range prefixLens {
+        prefixLens[i] = commonPrefixLen(leaves[i], leaves[i+1]) - topBits
+    }
+
+    var sum int64
+    for i := range leaves {
+        depth := 0
+        if i > 0 {
+            depth = prefixLens[i-1]
+        }
+        if i < len(prefixLens) {
+            depth = maxInt(depth, prefixLens[i])
+        }
+        sum += int64(depth)
+        stats.MaxSharedDepth = maxInt(stats.MaxSharedDepth, depth)
+    }
+    stats.MeanSharedDepth = float64(sum) / float64(len(leaves))
+}
+
+/**
+ * Walks down the paths to random leaves, counting their empty siblings and estimating the size of
+ * the proofs along them.
+ */
+func (tree *Tree) _pathStats(stats *TreeStats) {
+    leafLevel := tree.numLevels - 1
+    rng := rand.New(rand.NewSource(statsSeed))
+    stats.NumSampledPaths = numStatsPaths
+    stats.MembershipProofBytes = 32 * int64(leafLevel)
+
+    var sumNonEmpty, sumAppendBytes int64
+    for i := 0; i < numStatsPaths; i++ {
+        var leafNo [32]byte
+        rng.Read(leafNo[:])
+        leafNo = rshBytes(leafNo, uint(256-leafLevel))
+
+        // The path's nodes exist down to the level of the leaf's lowest existing ancestor, below
+        // which its subtree is empty, and so are the siblings along the rest of the path
+        numNonEmpty := 0
+        newLevel := leafLevel
+        for level := 1; level <= leafLevel; level++ {
+            nodeNo := rshBytes(leafNo, uint(leafLevel-level))
+            siblingNo, _ := siblingOf(nodeNo)
+            if tree.lvl[level].peek(siblingNo) != nil {
+                numNonEmpty++
+            }
+            if tree.lvl[level].peek(nodeNo) == nil {
+                newLevel = level
+                break
+            }
+        }
+        sumNonEmpty += int64(numNonEmpty)
+
+        // Inserting the leaf makes the path's nodes from 'newLevel' down new, and compressed proofs
+        // only have the highest of them, along with its siblings' hashes (see PathsSize())
+        sumAppendBytes += 4 + 2 + int64(_lnNumBytes(newLevel)) + 1 + 32 + int64(newLevel+7)/8 + 32*int64(numNonEmpty)
+    }
+
+    stats.MeanNonEmptySiblings = float64(sumNonEmpty) / float64(numStatsPaths)
+    stats.MeanEmptySiblings = float64(leafLevel) - stats.MeanNonEmptySiblings
+    stats.CompressedMembershipProofBytes = float64((leafLevel+7)/8) + 32*stats.MeanNonEmptySiblings
+    stats.AppendOnlyProofBytes = float64(sumAppendBytes) / float64(numStatsPaths)
+}
+
+// The columns of TreeStats::CsvRow()
+var treeStatsCsvHeader = []string{
+    "numLeaves", "numNodes", "meanSharedDepth", "maxSharedDepth", "numSampledPaths", "meanEmptySiblings",
+    "meanNonEmptySiblings", "membershipProofBytes", "compressedMembershipProofBytes", "appendOnlyProofBytes",
+    "expectedCompressedMembershipProofBytes", "expectedAppendOnlyProofBytes",
+}
+
+/**
+ * Returns the statistics as a row of the CSV file written by the 'stats' command, whose columns
+ * are in 'treeStatsCsvHeader'. The per-level node counts are written to a separate file.
+ */
+func (stats *TreeStats) CsvRow() []interface{} {
+    return []interface{}{
+        stats.NumLeaves, stats.NumNodes, fmt.Sprintf("%.3f", stats.MeanSharedDepth), stats.MaxSharedDepth,
+        stats.NumSampledPaths, fmt.Sprintf("%.3f", stats.MeanEmptySiblings), fmt.Sprintf("%.3f", stats.MeanNonEmptySiblings),
+        stats.MembershipProofBytes, fmt.Sprintf("%.1f", stats.CompressedMembershipProofBytes), fmt.Sprintf("%.1f", stats.AppendOnlyProofBytes),
+        fmt.Sprintf("%.1f", stats.Expected.CompressedMembershipProofBytes), fmt.Sprintf("%.1f", stats.Expected.AppendOnlyProofBytes),
+    }
+}
+
+func (stats *TreeStats) String() string {
+    return fmt.Sprintf("%d leaves, %d nodes, shared depth: %.2f (max %d), empty siblings: %.2f, proof bytes: %d membership, %.1f compressed membership (%.1f expected), %.1f append-only (%.1f expected)",
+        stats.NumLeaves, stats.NumNodes, stats.MeanSharedDepth, stats.MaxSharedDepth, stats.MeanEmptySiblings,
+        stats.MembershipProofBytes, stats.CompressedMembershipProofBytes, stats.Expected.CompressedMembershipProofBytes,
+        stats.AppendOnlyProofBytes, stats.Expected.AppendOnlyProofBytes)
+}
+
+/**
+ * Returns the mean and the sample standard deviation of the durations, in microseconds. The
+ * standard deviation is 0 if there is only one sample.
+ */
+func _meanStddevUsec(samples []time.Duration) (int64, int64) {
+    if len(samples) == 0 {
+        return 0, 0
+    }
+
+    var sum float64
+    for _, d := range samples {
+        sum += float64(d / time.Microsecond)
+    }
+    mean := sum / float64(len(samples))
+
+    if len(samples) == 1 {
+        return int64(mean), 0
+    }
+
+    var sumSquares float64
+    for _, d := range samples {
+        diff := float64(d/time.Microsecond) - mean
+        sumSquares += diff * diff
+    }
+    return int64(mean), int64(math.Sqrt(sumSquares / float64(len(samples)-1)))
+}
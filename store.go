@@ -0,0 +1,286 @@
+package aomt
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+/**
+ * Persists a tree in a directory, for long-running servers: every recorded epoch is logged to a
+ * WAL (see WAL) and, every now and then, a checkpoint of the tree as of some epoch is written (see
+ * Checkpoint()), after which the WAL only keeps the later epochs. Opening the store loads the last
+ * checkpoint and replays the WAL, so recovery only replays the epochs since the last checkpoint.
+ * The directory has:
+ *
+ *   MANIFEST                 the last checkpoint's file, epoch and root hash, in JSON
+ *   checkpoint-<epoch>.aomt  the last checkpoint, i.e., a snapshot of the tree (see Tree::Snapshot())
+ *   wal                      the WAL
+ *
+ * A checkpoint is written to its own file before the manifest is replaced to point to it, and the
+ * WAL is only truncated after that, so a crash at any point leaves a checkpoint and a WAL that
+ * recover the tree: epochs in both are skipped when replaying the WAL (see WAL::Replay()).
+ *
+ * NOTE: As with snapshots, the tree's options are not stored, so the store must always be opened
+ * with the same options. The store is not safe for concurrent use, and nor is its tree.
+ */
+type Store struct {
+    dir         string
+    tree        *Tree
+    wal         *WAL
+    manifest    *storeManifest // nil until the first checkpoint
+    numReplayed int            // the # of epochs replayed from the WAL when the store was opened
+}
+
+type storeManifest struct {
+    Checkpoint string    `json:"checkpoint"` // the checkpoint's file name, in the store's directory
+    Epoch      int       `json:"epoch"`
+    Root       string    `json:"root"` // the hex-encoded root hash of the epoch
+    CreatedAt  time.Time `json:"createdAt"`
+}
+
+const (
+    storeManifestFile = "MANIFEST"
+    storeWALFile      = "wal"
+)
+
+/**
+ * Opens the store in directory 'dir', creating it if it does not exist, and recovers its tree
+ * from the last checkpoint and the WAL. New stores start with a new tree with 'numLevels' levels.
+ */
+func OpenStore(dir string, numLevels int, opts TreeOptions) (*Store, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    store := &Store{dir: dir}
+
+    data, err := ioutil.ReadFile(filepath.Join(dir, storeManifestFile))
+    if err == nil {
+        store.manifest = &storeManifest{}
+        if err := json.Unmarshal(data, store.manifest); err != nil {
+            return nil, fmt.Errorf("%s: %v", storeManifestFile, err)
+        }
+        store.tree, err = store._loadCheckpoint(opts)
+    } else if os.IsNotExist(err) {
+        store.tree, err = NewTreeWithOptions(numLevels, opts)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    store.wal, err = OpenWAL(filepath.Join(dir, storeWALFile))
+    if err != nil {
+        return nil, err
+    }
+    store.numReplayed, err = store.wal.Replay(store.tree)
+    if err != nil {
+        store.wal.Close()
+        return nil, fmt.Errorf("replaying WAL: %v", err)
+    }
+    return store, nil
+}
+
+/**
+ * Loads the checkpoint in the manifest, checking that it is the tree as of the manifest's epoch.
+ */
+func (store *Store) _loadCheckpoint(opts TreeOptions) (*Tree, error) {
+    f, err := os.Open(filepath.Join(store.dir, store.manifest.Checkpoint))
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    tree, err := LoadSnapshotWithOptions(bufio.NewReader(f), opts)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", store.manifest.Checkpoint, err)
+    }
+    if tree.NumEpochs() != store.manifest.Epoch+1 || len(tree.pending) > 0 {
+        return nil, fmt.Errorf("%s: the checkpoint is not the tree as of epoch %d", store.manifest.Checkpoint, store.manifest.Epoch)
+    }
+    if hashStr(tree.GetEpochRoot(store.manifest.Epoch)) != store.manifest.Root {
+        return nil, fmt.Errorf("%s: the root hash of epoch %d does not match the manifest", store.manifest.Checkpoint, store.manifest.Epoch)
+    }
+    return tree, nil
+}
+
+/**
+ * Returns the store's tree. Epochs recorded in it must be logged via LogEpoch().
+ */
+func (store *Store) Tree() *Tree {
+    return store.tree
+}
+
+/**
+ * Returns the # of epochs that were replayed from the WAL when the store was opened, i.e., how
+ * long recovery took, in epochs.
+ */
+func (store *Store) NumReplayed() int {
+    return store.numReplayed
+}
+
+/**
+ * Returns the epoch of the last checkpoint, or -1 if there is none.
+ */
+func (store *Store) CheckpointEpoch() int {
+    if store.manifest == nil {
+        return -1
+    }
+    return store.manifest.Epoch
+}
+
+/**
+ * Durably logs the specified recorded epoch of the tree, see WAL::LogEpoch(). Epochs must be
+ * logged in order, once they are recorded.
+ */
+func (store *Store) LogEpoch(epoch int) error {
+    return store.wal.LogEpoch(store.tree, epoch)
+}
+
+/**
+ * Writes a checkpoint of the tree as of the specified epoch, which must be logged, records it in
+ * the manifest and drops it and the previous epochs from the WAL. Epochs before the last
+ * checkpoint's cannot be checkpointed.
+ */
+func (store *Store) Checkpoint(epoch int) error {
+    if epoch < 0 || epoch >= store.tree.NumEpochs() {
+        return fmt.Errorf("epoch %d was never recorded (have %d epochs)", epoch, store.tree.NumEpochs())
+    }
+    if epoch < store.CheckpointEpoch() {
+        return fmt.Errorf("epoch %d is before the last checkpoint's epoch %d", epoch, store.CheckpointEpoch())
+    }
+    // Otherwise, the epochs after the last logged one would be neither in the WAL nor replayable
+    // from the checkpoint's epochs once the WAL is truncated
+    if lastLogged := store._lastLoggedEpoch(); epoch > lastLogged {
+        return fmt.Errorf("epoch %d was not logged (the last logged epoch is %d)", epoch, lastLogged)
+    }
+
+    checkpoint := store.tree._treeThroughEpoch(epoch)
+    manifest := &storeManifest{
+        Checkpoint: fmt.Sprintf("checkpoint-%08d.aomt", epoch),
+        Epoch:      epoch,
+        Root:       hashStr(checkpoint.GetRootHash()),
+        CreatedAt:  time.Now().UTC(),
+    }
+
+    err := _writeFileDurably(filepath.Join(store.dir, manifest.Checkpoint), func(w io.Writer) error {
+        return checkpoint.Snapshot(w)
+    })
+    if err != nil {
+        return err
+    }
+    data, err := json.Marshal(manifest)
+    if err != nil {
+        return err
+    }
+    err = _writeFileDurably(filepath.Join(store.dir, storeManifestFile), func(w io.Writer) error {
+        _, err := w.Write(append(data, '\n'))
+        return err
+    })
+    if err != nil {
+        return err
+    }
+
+    // The manifest now points to the new checkpoint, so the previous one and the logged epochs it
+    // covers are no longer needed
+    prev := store.manifest
+    store.manifest = manifest
+    if err := store.wal.TruncateThrough(epoch); err != nil {
+        return fmt.Errorf("truncating WAL: %v", err)
+    }
+    if prev != nil && prev.Checkpoint != manifest.Checkpoint {
+        os.Remove(filepath.Join(store.dir, prev.Checkpoint))
+    }
+    return nil
+}
+
+/**
+ * Returns the last epoch that is durably stored, in the WAL or in the last checkpoint, or -1 if
+ * there is none.
+ */
+func (store *Store) _lastLoggedEpoch() int {
+    if lastLogged := store.wal.LastEpoch(); lastLogged > store.CheckpointEpoch() {
+        return lastLogged
+    }
+    return store.CheckpointEpoch()
+}
+
+func (store *Store) Close() error {
+    return store.wal.Close()
+}
+
+/**
+ * Returns the tree as of the specified epoch, with its epochs up to that one (unlike
+ * TreeAtEpoch()), or the tree itself if that is the last epoch and nothing changed since.
+ */
+func (tree *Tree) _treeThroughEpoch(epoch int) *Tree {
+    if epoch == len(tree.epochs)-1 && len(tree.pending) == 0 && tree.open == nil {
+        return tree
+    }
+
+    // As when replaying a WAL, the replayed epochs get the same timestamps and thus headers
+    replay := tree.NewEmptyTree()
+    for e := 0; e <= epoch; e++ {
+        replay._replayEpoch(tree.epochs[e], nil)
+        replay._recordEpochAt(time.Unix(0, tree.epochs[e].timestamp))
+    }
+    if replay.GetRootHash() != tree.epochs[epoch].root {
+        panic(fmt.Sprintf("Something's off: replayed root does not match epoch %d root", epoch))
+    }
+    return replay
+}
+
+/**
+ * Writes a file via 'write' to a temporary file, which is fsync'ed and renamed over 'path', and
+ * then fsyncs the directory, so that once this returns the file survives a crash, and a crash
+ * before that leaves the previous file, if any.
+ */
+func _writeFileDurably(path string, write func(w io.Writer) error) error {
+    tmpPath := path + ".tmp"
+    f, err := os.Create(tmpPath)
+    if err != nil {
+        return err
+    }
+
+    bw := bufio.NewWriter(f)
+    err = write(bw)
+    if err == nil {
+        err = bw.Flush()
+    }
+    if err == nil {
+        err = f.Sync()
+    }
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(tmpPath, path)
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+        return err
+    }
+
+    return _syncDir(filepath.Dir(path))
+}
+
+/**
+ * Fsyncs the directory, so that the files renamed into (or out of) it survive a crash. Does
+ * nothing on platforms that cannot fsync directories.
+ */
+func _syncDir(path string) error {
+    dir, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer dir.Close()
+    if err := dir.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,61 @@
+package aomt
+
+import (
+    "testing"
+)
+
+/**
+ * Only logged epochs can be checkpointed, since the WAL is truncated through the checkpoint's
+ * epoch, and the store must recover the tree from the checkpoint and the rest of the WAL.
+ */
+func TestStoreCheckpointsLoggedEpochs(t *testing.T) {
+    dir := t.TempDir()
+    store, err := OpenStore(dir, 257, TreeOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tree := store.Tree()
+    for e := 0; e < 4; e++ {
+        for i := 10 * e; i < 10*(e+1); i++ {
+            leaf := _testLeaf(i)
+            tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+        }
+        epoch := tree.RecordEpoch()
+        // The last epoch is recorded but not logged
+        if e < 3 {
+            if err := store.LogEpoch(epoch); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    if err := store.Checkpoint(3); err == nil {
+        t.Fatal("checkpointed an epoch that was not logged")
+    }
+    if err := store.Checkpoint(1); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.LogEpoch(3); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.Checkpoint(3); err != nil {
+        t.Fatal(err)
+    }
+    store.Close()
+
+    // Everything is in the checkpoint now, so the reopened WAL is empty
+    store, err = OpenStore(dir, 257, TreeOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer store.Close()
+    if store.CheckpointEpoch() != 3 || store.NumReplayed() != 0 {
+        t.Fatalf("reopened store has checkpoint %d and replayed %d epochs, expected 3 and 0", store.CheckpointEpoch(), store.NumReplayed())
+    }
+    if store.Tree().GetRootHash() != tree.GetRootHash() {
+        t.Fatal("reopened store has another root")
+    }
+    if err := store.Checkpoint(3); err != nil {
+        t.Fatalf("checkpointing the last checkpoint's epoch again failed: %v", err)
+    }
+}
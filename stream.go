@@ -0,0 +1,268 @@
+package aomt
+
+import (
+    "bufio"
+    "crypto/sha256"
+    "encoding/binary"
+    "hash"
+    "io"
+)
+
+/**
+ * VerifyAppendOnlyProof() needs the whole proof tree in memory, which constrained clients (e.g.,
+ * phones or HSMs) may not have room for when proofs are several megabytes. A streamed proof has
+ * the same encoding as AppendOnlyProof::MarshalBinary(), except that the nodes are sorted from
+ * left to right (i.e., in post-order, see AppendOnlyProof::_preOrderNodes()) rather than by level,
+ * so the verifier can hash them as they come and only keep a stack with the hashes of the left
+ * siblings along the current node's path, which has at most one node per level. Appended hashes
+ * are also folded in as they are read, so memory use does not depend on the proof's size.
+ *
+ * Only compressed proofs (see Tree::_compressProofTree()), like the ones returned by
+ * Epoch::Commit(), can be streamed.
+ */
+
+/**
+ * Writes the proof in the streamed encoding.
+ */
+func (proof *AppendOnlyProof) WriteStream(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+    binary.Write(bw, binary.BigEndian, uint32(len(proof.Nodes)))
+    for _, pn := range proof._preOrderNodes() {
+        var flags byte
+        if pn.IsNew {
+            flags |= proofFlagNew
+        }
+        if len(pn.Appended) > 0 {
+            flags |= proofFlagAppended
+        }
+
+        binary.Write(bw, binary.BigEndian, uint16(pn.Level))
+        bw.Write(pn.NodeNo[32-_lnNumBytes(pn.Level):])
+        bw.WriteByte(flags)
+        bw.Write(pn.Hash[:])
+
+        if len(pn.Appended) > 0 {
+            binary.Write(bw, binary.BigEndian, uint32(len(pn.Appended)))
+            for _, h := range pn.Appended {
+                bw.Write(h[:])
+            }
+        }
+    }
+
+    // bufio.Writer remembers the first write error, so we only need to check it here
+    return bw.Flush()
+}
+
+/**
+ * Incrementally checks an append-only proof whose nodes are added from left to right, via Add().
+ */
+type AppendOnlyVerifier struct {
+    oldRoot [32]byte
+    newRoot [32]byte
+    newHash func() hash.Hash
+    rfc6962 bool
+    scheme  HashingScheme
+    // The level of the leaves, below which there can be no proof nodes
+    leafLevel int
+
+    // The left siblings along the path of the last added node, waiting for their right siblings
+    stack []verifierNode
+    // The root's hashes, once all of its subtrees were added
+    root *verifierNode
+}
+
+type verifierNode struct {
+    level  int
+    nodeNo [32]byte
+    hashes ProofHashes
+}
+
+/**
+ * Returns a verifier for an append-only proof from the tree with root 'oldRoot' to the tree with
+ * root 'newRoot', for trees that use SHA256.
+ */
+func NewAppendOnlyVerifier(oldRoot [32]byte, newRoot [32]byte) *AppendOnlyVerifier {
+    verifier, _ := NewAppendOnlyVerifierWithOptions(oldRoot, newRoot, TreeOptions{})
+    return verifier
+}
+
+/**
+ * Like NewAppendOnlyVerifier(), for trees created via NewTreeWithOptions() with the specified options.
+ */
+func NewAppendOnlyVerifierWithOptions(oldRoot [32]byte, newRoot [32]byte, opts TreeOptions) (*AppendOnlyVerifier, error) {
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+    if newHash().Size() != 32 {
+        return nil, _verifyErrorf(ErrInvalidParams, "hash function must output 32-byte digests, got %d bytes", newHash().Size())
+    }
+    numLevels, err := opts._numLevels()
+    if err != nil {
+        return nil, _verifyErrorf(ErrInvalidParams, "%v", err)
+    }
+    if err := opts.HashingScheme._check(); err != nil {
+        return nil, _verifyErrorf(ErrInvalidParams, "%v", err)
+    }
+
+    return &AppendOnlyVerifier{oldRoot: oldRoot, newRoot: newRoot, newHash: newHash, rfc6962: opts.RFC6962, scheme: opts.HashingScheme, leafLevel: numLevels - 1}, nil
+}
+
+/**
+ * Adds the next proof node. Nodes must be added from left to right.
+ */
+func (verifier *AppendOnlyVerifier) Add(node *ProofNode) error {
+    hashes := ProofHashes{Old: node.Hash, New: node.Hash}
+    if node.IsNew {
+        hashes.Old = [32]byte{}
+    }
+    for _, dataHash := range node.Appended {
+        hashes.New = _nodeHash(verifier.newHash, verifier.rfc6962, hashes.New, dataHash)
+    }
+    return verifier._push(verifierNode{node.Level, node.NodeNo, hashes})
+}
+
+/**
+ * Checks that the added nodes hash to the old and the new root. An empty proof is only valid if
+ * the two roots are the same.
+ */
+func (verifier *AppendOnlyVerifier) Finish() error {
+    if verifier.root == nil {
+        if len(verifier.stack) > 0 {
+            top := verifier.stack[len(verifier.stack)-1]
+            return _verifyErrorf(ErrMalformedProof, "proof is missing the right sibling of node %s on level %d", hashStr(top.nodeNo), top.level)
+        }
+        if !hashEqual(verifier.oldRoot, verifier.newRoot) {
+            return _verifyErrorf(ErrNewRootMismatch, "empty append-only proof, but the roots differ")
+        }
+        return nil
+    }
+
+    if !hashEqual(verifier.root.hashes.Old, verifier.oldRoot) {
+        return _verifyErrorf(ErrOldRootMismatch, "old root hash check failed: got %s", hashStr(verifier.root.hashes.Old))
+    }
+    if !hashEqual(verifier.root.hashes.New, verifier.newRoot) {
+        return _verifyErrorf(ErrNewRootMismatch, "new root hash check failed: got %s", hashStr(verifier.root.hashes.New))
+    }
+    return nil
+}
+
+/**
+ * Adds a node with known hashes, hashing it with its left siblings on the stack for as long as it
+ * is a right child.
+ */
+func (verifier *AppendOnlyVerifier) _push(node verifierNode) error {
+    if verifier.root != nil {
+        return _verifyErrorf(ErrMalformedProof, "proof has node %s on level %d after the root's subtrees", hashStr(node.nodeNo), node.level)
+    }
+    if node.level > verifier.leafLevel {
+        return _verifyErrorf(ErrMalformedProof, "proof node %s is on level %d, below the leaves", hashStr(node.nodeNo), node.level)
+    }
+    if !_fitsInLevel(node.nodeNo, node.level) {
+        return _verifyErrorf(ErrMalformedProof, "proof node %s has an LN that is too large for level %d", hashStr(node.nodeNo), node.level)
+    }
+
+    // The node must be in the subtree of the right sibling of the node on top of the stack, or
+    // else the proof is not sorted (or has overlapping nodes)
+    if n := len(verifier.stack); n > 0 {
+        top := verifier.stack[n-1]
+        if node.level < top.level {
+            return _verifyErrorf(ErrMalformedProof, "proof node %s on level %d is not to the right of node %s on level %d", hashStr(node.nodeNo), node.level, hashStr(top.nodeNo), top.level)
+        }
+        topSibling, _ := siblingOf(top.nodeNo)
+        if rshBytes(node.nodeNo, uint(node.level-top.level)) != topSibling {
+            return _verifyErrorf(ErrMalformedProof, "proof node %s on level %d is not to the right of node %s on level %d", hashStr(node.nodeNo), node.level, hashStr(top.nodeNo), top.level)
+        }
+    }
+
+    for node.level > 0 {
+        _, isLeft := siblingOf(node.nodeNo)
+        if isLeft {
+            verifier.stack = append(verifier.stack, node)
+            return nil
+        }
+
+        // By the check above, the left sibling is on top of the stack if this is a right child
+        n := len(verifier.stack)
+        if n == 0 || verifier.stack[n-1].level != node.level {
+            return _verifyErrorf(ErrMalformedProof, "proof is missing the left sibling of node %s on level %d", hashStr(node.nodeNo), node.level)
+        }
+        left := verifier.stack[n-1]
+        verifier.stack = verifier.stack[:n-1]
+
+        level, parentNo := node.level-1, parentOf(node.nodeNo)
+        node = verifierNode{
+            level:  level,
+            nodeNo: parentNo,
+            hashes: ProofHashes{
+                Old: _schemeNodeHash(verifier.newHash, verifier.rfc6962, verifier.scheme, level, parentNo, left.hashes.Old, node.hashes.Old),
+                New: _schemeNodeHash(verifier.newHash, verifier.rfc6962, verifier.scheme, level, parentNo, left.hashes.New, node.hashes.New),
+            },
+        }
+    }
+
+    verifier.root = &node
+    return nil
+}
+
+/**
+ * Checks a streamed append-only proof (see AppendOnlyProof::WriteStream()) from the tree with
+ * root 'oldRoot' to the tree with root 'newRoot', for trees that use SHA256.
+ */
+func VerifyAppendOnlyStream(r io.Reader, oldRoot [32]byte, newRoot [32]byte) error {
+    return VerifyAppendOnlyStreamWithOptions(r, oldRoot, newRoot, TreeOptions{})
+}
+
+/**
+ * Like VerifyAppendOnlyStream(), for trees created via NewTreeWithOptions() with the specified options.
+ */
+func VerifyAppendOnlyStreamWithOptions(r io.Reader, oldRoot [32]byte, newRoot [32]byte, opts TreeOptions) error {
+    verifier, err := NewAppendOnlyVerifierWithOptions(oldRoot, newRoot, opts)
+    if err != nil {
+        return err
+    }
+    br := bufio.NewReader(r)
+
+    var numNodes uint32
+    if err := binary.Read(br, binary.BigEndian, &numNodes); err != nil {
+        return _verifyErrorf(ErrMalformedProof, "reading # of proof nodes: %v", err)
+    }
+
+    for i := 0; i < int(numNodes); i++ {
+        var pn ProofNode
+        flags, err := _readProofNode(br, i, &pn)
+        if err != nil {
+            return _malformed(err)
+        }
+
+        hashes := ProofHashes{Old: pn.Hash, New: pn.Hash}
+        if pn.IsNew {
+            hashes.Old = [32]byte{}
+        }
+        if flags&proofFlagAppended != 0 {
+            var numAppended uint32
+            if err := binary.Read(br, binary.BigEndian, &numAppended); err != nil {
+                return _verifyErrorf(ErrMalformedProof, "reading # of appended hashes of proof node %d: %v", i, err)
+            }
+            if numAppended == 0 {
+                return _verifyErrorf(ErrMalformedProof, "proof node %d is flagged as appended to but has no appended hashes", i)
+            }
+            for j := uint32(0); j < numAppended; j++ {
+                var dataHash [32]byte
+                if _, err := io.ReadFull(br, dataHash[:]); err != nil {
+                    return _verifyErrorf(ErrMalformedProof, "reading appended hash of proof node %d: %v", i, err)
+                }
+                hashes.New = _nodeHash(verifier.newHash, verifier.rfc6962, hashes.New, dataHash)
+            }
+        }
+
+        if err := verifier._push(verifierNode{pn.Level, pn.NodeNo, hashes}); err != nil {
+            return err
+        }
+    }
+
+    if _, err := br.ReadByte(); err != io.EOF {
+        return _verifyErrorf(ErrMalformedProof, "trailing bytes after proof")
+    }
+    return verifier.Finish()
+}
@@ -0,0 +1,77 @@
+package aomt
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+/**
+ * Returns the streamed encoding of the proof.
+ */
+func _testStream(t *testing.T, proof *AppendOnlyProof) []byte {
+    var buf bytes.Buffer
+    if err := proof.WriteStream(&buf); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+/**
+ * Streamed append-only proofs must verify for every hashing option, and neither they nor the
+ * proofs they were streamed from may verify once tampered with.
+ */
+func TestAppendOnlyStreamRejectsTampering(t *testing.T) {
+    for _, opts := range []TreeOptions{{}, {RFC6962: true}, {HashingScheme: HashingLevel}, {HashingScheme: HashingLevelLN}} {
+        tree, proof := _testAppendOnlyProof(t, opts, 50, 30)
+        oldRoot, newRoot := tree.GetEpochRoot(0), tree.GetEpochRoot(1)
+        stream := _testStream(t, proof)
+        if err := VerifyAppendOnlyStreamWithOptions(bytes.NewReader(stream), oldRoot, newRoot, opts); err != nil {
+            t.Fatalf("%+v: streamed proof does not verify: %v", opts, err)
+        }
+        if err := VerifyAppendOnlyStreamWithOptions(bytes.NewReader(stream), newRoot, oldRoot, opts); err == nil {
+            t.Errorf("%+v: streamed proof verifies with the roots swapped", opts)
+        }
+
+        // The tampered nodes, as in TestAppendOnlyProofTamperedInPlace()
+        tampers := map[string]func(pn *ProofNode){
+            "hash":     func(pn *ProofNode) { pn.Hash[31] ^= 1 },
+            "new flag": func(pn *ProofNode) { pn.IsNew = !pn.IsNew },
+            "appended": func(pn *ProofNode) { pn.Appended = append(pn.Appended, [32]byte{1}) },
+        }
+        for name, tamper := range tampers {
+            for i := 0; i < len(proof.Nodes); i += 7 {
+                // Flipping the new flag of an empty node changes neither root
+                if proof.Nodes[i].Hash == tree.EmptyHash || name == "appended" && proof.Nodes[i].IsNew {
+                    continue
+                }
+                tampered := &AppendOnlyProof{Nodes: append([]ProofNode{}, proof.Nodes...)}
+                tamper(&tampered.Nodes[i])
+                if err := tampered.VerifyWithOptions(oldRoot, newRoot, opts); err == nil {
+                    t.Errorf("%+v: proof with node %d's %s tampered with verifies", opts, i, name)
+                }
+                err := VerifyAppendOnlyStreamWithOptions(bytes.NewReader(_testStream(t, tampered)), oldRoot, newRoot, opts)
+                if err == nil {
+                    t.Errorf("%+v: streamed proof with node %d's %s tampered with verifies", opts, i, name)
+                }
+            }
+        }
+
+        dropped := &AppendOnlyProof{Nodes: proof.Nodes[1:]}
+        if err := VerifyAppendOnlyStreamWithOptions(bytes.NewReader(_testStream(t, dropped)), oldRoot, newRoot, opts); err == nil {
+            t.Errorf("%+v: streamed proof with a node dropped verifies", opts)
+        }
+
+        // Truncated and padded streams are malformed, wherever they are cut
+        for n := 0; n < len(stream); n += len(stream)/16 + 1 {
+            err := VerifyAppendOnlyStreamWithOptions(bytes.NewReader(stream[:n]), oldRoot, newRoot, opts)
+            if !errors.Is(err, ErrMalformedProof) {
+                t.Errorf("%+v: stream truncated to %d of %d bytes failed with %v", opts, n, len(stream), err)
+            }
+        }
+        padded := append(append([]byte{}, stream...), 0)
+        if err := VerifyAppendOnlyStreamWithOptions(bytes.NewReader(padded), oldRoot, newRoot, opts); !errors.Is(err, ErrMalformedProof) {
+            t.Errorf("%+v: stream with a trailing byte failed with %v", opts, err)
+        }
+    }
+}
@@ -0,0 +1,72 @@
+package aomt
+
+/**
+ * Monitors can subscribe to the tree's epoch roots rather than poll for them: every recorded epoch
+ * (see RecordEpoch()) pushes its head to all subscribers. Monitors that compare the heads they were
+ * pushed with those seen by others (e.g., via gossip) can detect a server showing them a split view.
+ *
+ * Sending never blocks RecordEpoch(): a subscriber whose buffer is full misses the head, which it
+ * can notice from the gap in epoch numbers and fetch via GetTreeHead() (or the /v1/root endpoint).
+ */
+
+// The # of heads buffered per subscriber
+const rootSubscriptionBuffer = 64
+
+/**
+ * Returns a channel that receives the head of every epoch recorded from now on, signed via the
+ * signer set by SetTreeHeadSigner(), if any. The channel is closed by UnsubscribeRoots().
+ */
+func (tree *Tree) SubscribeRoots() <-chan SignedTreeHead {
+    sub := make(chan SignedTreeHead, rootSubscriptionBuffer)
+    tree.subscribers = append(tree.subscribers, sub)
+    return sub
+}
+
+/**
+ * Stops sending heads to a channel returned by SubscribeRoots() and closes it.
+ */
+func (tree *Tree) UnsubscribeRoots(ch <-chan SignedTreeHead) {
+    for i, sub := range tree.subscribers {
+        if (<-chan SignedTreeHead)(sub) == ch {
+            tree.subscribers = append(tree.subscribers[:i], tree.subscribers[i+1:]...)
+            close(sub)
+            return
+        }
+    }
+}
+
+/**
+ * Sets the function that signs the heads pushed to subscribers, e.g., over TreeHead::MarshalProto().
+ * If not set, the heads are pushed without a signature.
+ */
+func (tree *Tree) SetTreeHeadSigner(sign func(head TreeHead) []byte) {
+    tree.signHead = sign
+}
+
+/**
+ * Returns the head of the specified recorded epoch, signed as the heads pushed to subscribers.
+ */
+func (tree *Tree) GetSignedTreeHead(epoch int) SignedTreeHead {
+    sth := SignedTreeHead{Head: tree.GetTreeHead(epoch)}
+    if tree.signHead != nil {
+        sth.Signature = tree.signHead(sth.Head)
+    }
+    return sth
+}
+
+/**
+ * Pushes the head of the specified epoch to all subscribers.
+ */
+func (tree *Tree) _notifyRoots(epoch int) {
+    if len(tree.subscribers) == 0 {
+        return
+    }
+
+    sth := tree.GetSignedTreeHead(epoch)
+    for _, sub := range tree.subscribers {
+        select {
+        case sub <- sth:
+        default:
+        }
+    }
+}
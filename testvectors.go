@@ -0,0 +1,243 @@
+package aomt
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "hash"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+)
+
+/**
+ * Test vectors for other implementations of the verifiers (e.g., in Rust or C++), generated from
+ * fixed seeds, so they are the same on every run: for each tree configuration below, the leaves
+ * inserted in each epoch, the epochs' roots, membership and non-membership proofs against the
+ * last epoch's root and append-only proofs between epochs. Proofs are in the JSON encoding (see
+ * json.go) and, hex-encoded, in the binary encodings: membership proofs in the protobuf encoding
+ * (see proto.go) and append-only proofs as is (see AppendOnlyProof::MarshalBinary()) and in a
+ * ProofEnvelope. Every proof is checked before it is included, so vectors never contain proofs
+ * this code rejects.
+ */
+
+// The configurations vectors are generated for
+var testVectorConfigs = []struct {
+    name      string
+    numLevels int
+    hashName  string
+    newHash   func() hash.Hash
+    rfc6962   bool
+    scheme    HashingScheme
+}{
+    {"sha256-257", maxNumLevels, "sha256", nil, false, HashingPlain},
+    {"sha256-16", 16, "sha256", nil, false, HashingPlain},
+    {"sha256-rfc6962-257", maxNumLevels, "sha256", nil, true, HashingPlain},
+    {"sha256-level-ln-257", maxNumLevels, "sha256", nil, false, HashingLevelLN},
+    {"keccak256-257", maxNumLevels, "keccak256", NewKeccak256, false, HashingPlain},
+}
+
+// The # of leaves inserted in each epoch of a vector's tree
+var testVectorEpochSizes = []int{1, 4, 16}
+
+// The # of leaves of the last epoch whose membership proofs are included in a vector
+const testVectorNumMemberships = 3
+
+type TestVector struct {
+    Name       string                 `json:"name"`
+    NumLevels  int                    `json:"numLevels"`
+    Hash       string                 `json:"hash"`
+    RFC6962    bool                   `json:"rfc6962"`
+    Scheme     string                 `json:"hashingScheme,omitempty"` // omitted if plain
+    Seed       int64                  `json:"seed"`
+    EmptyHash  string                 `json:"emptyHash"`
+    Params     string                 `json:"paramsDigest"`
+    Epochs     []TestVectorEpoch      `json:"epochs"`
+    Membership []TestVectorMembership `json:"membership"`
+    AppendOnly []TestVectorAppendOnly `json:"appendOnly"`
+}
+
+type TestVectorLeaf struct {
+    LeafNo   string `json:"leafNo"`
+    DataHash string `json:"dataHash"`
+}
+
+type TestVectorEpoch struct {
+    Epoch  int              `json:"epoch"`
+    Leaves []TestVectorLeaf `json:"leaves"`
+    Root   string           `json:"root"`
+}
+
+type TestVectorMembership struct {
+    Epoch  int              `json:"epoch"`
+    Exists bool             `json:"exists"`
+    Proof  *MembershipProof `json:"proof"`
+    Proto  string           `json:"proto"`
+}
+
+type TestVectorAppendOnly struct {
+    FromEpoch int              `json:"fromEpoch"`
+    ToEpoch   int              `json:"toEpoch"`
+    OldRoot   string           `json:"oldRoot"`
+    NewRoot   string           `json:"newRoot"`
+    Proof     *AppendOnlyProof `json:"proof"`
+    Binary    string           `json:"binary"`
+    Envelope  string           `json:"envelope"`
+}
+
+/**
+ * Returns the test vectors of all configurations, generated from the specified seed.
+ */
+func GenerateTestVectors(seed int64) ([]*TestVector, error) {
+    var vectors []*TestVector
+    for _, config := range testVectorConfigs {
+        opts := TreeOptions{NewHash: config.newHash, RFC6962: config.rfc6962, HashingScheme: config.scheme}
+        vector, err := _generateTestVector(config.name, config.numLevels, config.hashName, opts, seed)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", config.name, err)
+        }
+        vectors = append(vectors, vector)
+    }
+    return vectors, nil
+}
+
+func _vectorScheme(scheme HashingScheme) string {
+    if scheme == HashingPlain {
+        return ""
+    }
+    return scheme.String()
+}
+
+func _generateTestVector(name string, numLevels int, hashName string, opts TreeOptions, seed int64) (*TestVector, error) {
+    tree, err := NewTreeWithOptions(numLevels, opts)
+    if err != nil {
+        return nil, err
+    }
+    opts = tree.Options()
+
+    vector := &TestVector{
+        Name:      name,
+        NumLevels: numLevels,
+        Hash:      hashName,
+        RFC6962:   opts.RFC6962,
+        Scheme:    _vectorScheme(opts.HashingScheme),
+        Seed:      seed,
+        EmptyHash: hashStr(tree.EmptyHash),
+        Params:    hashStr(tree.ParamsDigest()),
+    }
+
+    gen := newLeafGenerator(seed)
+    for epoch, size := range testVectorEpochSizes {
+        var leaves []TestVectorLeaf
+        for len(leaves) < size {
+            leafNo, dataHash := gen.next()
+            leafNo = rshBytes(leafNo, uint(maxNumLevels-numLevels))
+            // Small trees get the same leaf no twice now and then
+            if _, ok := tree.Get(leafNo); ok {
+                continue
+            }
+            tree.Insert(leafNo, dataHash, nil)
+            leaves = append(leaves, TestVectorLeaf{LeafNo: hashStr(leafNo), DataHash: hashStr(dataHash)})
+        }
+        tree.RecordEpoch()
+        vector.Epochs = append(vector.Epochs, TestVectorEpoch{Epoch: epoch, Leaves: leaves, Root: hashStr(tree.GetEpochRoot(epoch))})
+    }
+    last := len(testVectorEpochSizes) - 1
+
+    // The first few leaves of the last epoch, and one that was never inserted
+    var leafNos [][32]byte
+    for _, leaf := range vector.Epochs[last].Leaves[:testVectorNumMemberships] {
+        leafNo, _ := _parseHashHex(leaf.LeafNo)
+        leafNos = append(leafNos, leafNo)
+    }
+    absentNo, _ := gen.next()
+    leafNos = append(leafNos, rshBytes(absentNo, uint(maxNumLevels-numLevels)))
+
+    for _, leafNo := range leafNos {
+        proof := tree.ProveMembership(leafNo)
+        if !VerifyMembershipProofWithOptions(proof, tree.GetRootHash(), opts) {
+            return nil, fmt.Errorf("the membership proof of leaf %s does not verify", hashStr(leafNo))
+        }
+        protoBytes, err := proof.MarshalProto()
+        if err != nil {
+            return nil, err
+        }
+        vector.Membership = append(vector.Membership, TestVectorMembership{
+            Epoch:  last,
+            Exists: proof.LeafHash != tree.EmptyHash,
+            Proof:  proof,
+            Proto:  hex.EncodeToString(protoBytes),
+        })
+    }
+
+    // The proofs between consecutive epochs, and from the first epoch to the last one
+    epochPairs := [][2]int{}
+    for epoch := 0; epoch < last; epoch++ {
+        epochPairs = append(epochPairs, [2]int{epoch, epoch + 1})
+    }
+    epochPairs = append(epochPairs, [2]int{0, last})
+
+    for _, pair := range epochPairs {
+        from, to := pair[0], pair[1]
+        proof := NewAppendOnlyProof(tree.ProveAppendOnly(from, to))
+        if err := proof.VerifyWithOptions(tree.GetEpochRoot(from), tree.GetEpochRoot(to), opts); err != nil {
+            return nil, fmt.Errorf("the append-only proof from epoch %d to epoch %d does not verify: %v", from, to, err)
+        }
+        binaryBytes, err := proof.MarshalBinary()
+        if err != nil {
+            return nil, err
+        }
+        envelopeBytes, err := NewProofEnvelope(tree, from, to, proof).MarshalBinary()
+        if err != nil {
+            return nil, err
+        }
+        vector.AppendOnly = append(vector.AppendOnly, TestVectorAppendOnly{
+            FromEpoch: from,
+            ToEpoch:   to,
+            OldRoot:   hashStr(tree.GetEpochRoot(from)),
+            NewRoot:   hashStr(tree.GetEpochRoot(to)),
+            Proof:     proof,
+            Binary:    hex.EncodeToString(binaryBytes),
+            Envelope:  hex.EncodeToString(envelopeBytes),
+        })
+    }
+
+    return vector, nil
+}
+
+/**
+ * Writes each vector to '<dir>/<name>.json', along with the binary encodings of its proofs as
+ * '<dir>/<name>/membership-<i>.pb', '<dir>/<name>/append-only-<from>-<to>.bin' and
+ * '<dir>/<name>/envelope-<from>-<to>.bin', for verifiers that read raw files.
+ */
+func WriteTestVectors(dir string, vectors []*TestVector) error {
+    for _, vector := range vectors {
+        data, err := json.MarshalIndent(vector, "", "  ")
+        if err != nil {
+            return err
+        }
+        if err := ioutil.WriteFile(filepath.Join(dir, vector.Name+".json"), append(data, '\n'), 0644); err != nil {
+            return err
+        }
+
+        files := make(map[string]string)
+        for i, m := range vector.Membership {
+            files[fmt.Sprintf("membership-%d.pb", i)] = m.Proto
+        }
+        for _, ao := range vector.AppendOnly {
+            files[fmt.Sprintf("append-only-%d-%d.bin", ao.FromEpoch, ao.ToEpoch)] = ao.Binary
+            files[fmt.Sprintf("envelope-%d-%d.bin", ao.FromEpoch, ao.ToEpoch)] = ao.Envelope
+        }
+
+        if err := os.MkdirAll(filepath.Join(dir, vector.Name), 0755); err != nil {
+            return err
+        }
+        for file, hexData := range files {
+            data, _ := hex.DecodeString(hexData)
+            if err := ioutil.WriteFile(filepath.Join(dir, vector.Name, file), data, 0644); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
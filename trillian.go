@@ -0,0 +1,126 @@
+package aomt
+
+import (
+    "bytes"
+    "fmt"
+)
+
+/**
+ * A membership proof in the shape of Trillian's MapLeafInclusion message (from
+ * trillian_map_api.proto), so it can be handed to tools built around Trillian's verifiable maps:
+ *
+ *   message MapLeaf          { bytes index = 1; bytes leaf_hash = 2; bytes leaf_value = 3; bytes extra_data = 4; }
+ *   message MapLeafInclusion { MapLeaf leaf = 1; repeated bytes inclusion = 2; }
+ *
+ * Like MembershipProof::Siblings, Inclusion[0] is the leaf's sibling and the last one (i.e.,
+ * Inclusion[255] in 257-level trees) is the root's child. As in Trillian, empty siblings are sent
+ * as empty byte strings.
+ *
+ * NOTE: Trillian's map verifier replaces empty siblings with the empty subtree hashes of its map
+ * hasher and hashes leaves from their values, while we hash empty subtrees to all zeros and do not
+ * store leaf values (LeafValue is always empty). So these proofs must still be checked via
+ * VerifyMembershipProof() (see FromTrillian()) rather than via Trillian's merkle package.
+ */
+type TrillianMapLeafInclusion struct {
+    Index     []byte
+    LeafHash  []byte // empty for non-membership proofs
+    LeafValue []byte
+    ExtraData []byte
+    Inclusion [][]byte
+}
+
+/**
+ * Converts the proof to Trillian's MapLeafInclusion shape.
+ */
+func (proof *MembershipProof) ToTrillian() *TrillianMapLeafInclusion {
+    var emptyHash [32]byte
+
+    inc := &TrillianMapLeafInclusion{Index: append([]byte(nil), proof.LeafNo[:]...)}
+    if proof.LeafHash != emptyHash {
+        inc.LeafHash = append([]byte(nil), proof.LeafHash[:]...)
+    }
+    for _, sibling := range proof.Siblings {
+        if sibling == emptyHash {
+            inc.Inclusion = append(inc.Inclusion, []byte{})
+        } else {
+            inc.Inclusion = append(inc.Inclusion, append([]byte(nil), sibling[:]...))
+        }
+    }
+    return inc
+}
+
+/**
+ * Converts a MapLeafInclusion produced by ToTrillian() back to a membership proof.
+ */
+func (inc *TrillianMapLeafInclusion) FromTrillian() (*MembershipProof, error) {
+    proof := &MembershipProof{}
+    if err := _copyProtoHash(proof.LeafNo[:], inc.Index, false); err != nil {
+        return nil, fmt.Errorf("index: %v", err)
+    }
+    if err := _copyProtoHash(proof.LeafHash[:], inc.LeafHash, true); err != nil {
+        return nil, fmt.Errorf("leaf hash: %v", err)
+    }
+
+    proof.Siblings = make([][32]byte, len(inc.Inclusion))
+    for i, sibling := range inc.Inclusion {
+        if err := _copyProtoHash(proof.Siblings[i][:], sibling, true); err != nil {
+            return nil, fmt.Errorf("inclusion[%d]: %v", i, err)
+        }
+    }
+    return proof, nil
+}
+
+/**
+ * Encodes the MapLeafInclusion in the protobuf wire format.
+ */
+func (inc *TrillianMapLeafInclusion) MarshalBinary() ([]byte, error) {
+    var leaf bytes.Buffer
+    for i, field := range [][]byte{inc.Index, inc.LeafHash, inc.LeafValue, inc.ExtraData} {
+        // proto3 does not encode empty scalar fields
+        if len(field) > 0 {
+            _writeProtoBytes(&leaf, i+1, field)
+        }
+    }
+
+    var buf bytes.Buffer
+    _writeProtoBytes(&buf, 1, leaf.Bytes())
+    for _, sibling := range inc.Inclusion {
+        // ...but it does encode empty elements of repeated fields
+        _writeProtoBytes(&buf, 2, sibling)
+    }
+    return buf.Bytes(), nil
+}
+
+/**
+ * Decodes a MapLeafInclusion from the protobuf wire format. Unknown fields are rejected, since
+ * both messages only have bytes fields.
+ */
+func (inc *TrillianMapLeafInclusion) UnmarshalBinary(data []byte) error {
+    *inc = TrillianMapLeafInclusion{}
+    return _readProtoFields(data, func(fieldNo int, value []byte) error {
+        switch fieldNo {
+        case 1:
+            return _readProtoFields(value, func(leafFieldNo int, leafValue []byte) error {
+                leafValue = append([]byte(nil), leafValue...)
+                switch leafFieldNo {
+                case 1:
+                    inc.Index = leafValue
+                case 2:
+                    inc.LeafHash = leafValue
+                case 3:
+                    inc.LeafValue = leafValue
+                case 4:
+                    inc.ExtraData = leafValue
+                default:
+                    return fmt.Errorf("unknown MapLeaf field %d", leafFieldNo)
+                }
+                return nil
+            })
+        case 2:
+            inc.Inclusion = append(inc.Inclusion, append([]byte{}, value...))
+            return nil
+        default:
+            return fmt.Errorf("unknown MapLeafInclusion field %d", fieldNo)
+        }
+    })
+}
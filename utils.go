@@ -1,12 +1,15 @@
-package main
+package aomt
 
 import (
     "fmt"
     "runtime"
     "crypto/sha256"
     "crypto/rand"
+    "crypto/subtle"
     "math/big"
     "encoding/hex"
+    "math/bits"
+    "syscall"
 )
 
 func minInt(a, b int) int {
@@ -91,3 +94,131 @@ func memUsage() uint64 {
     runtime.ReadMemStats(&m1)
     return m1.Alloc / (1024*1024)
 }
+
+/**
+ * Returns the LN of the parent of the node with LN 'nodeNo', i.e., nodeNo / 2.
+ */
+func parentOf(nodeNo [32]byte) [32]byte {
+    return rshBytes(nodeNo, 1)
+}
+
+/**
+ * Returns the LN of the sibling of the node with LN 'nodeNo', and true if 'nodeNo' is a left child.
+ */
+func siblingOf(nodeNo [32]byte) ([32]byte, bool) {
+    isLeft := nodeNo[31]&1 == 0
+    nodeNo[31] ^= 1
+    return nodeNo, isLeft
+}
+
+/**
+ * Returns the LNs of the left and right children of the node with LN 'nodeNo', i.e., 2 * nodeNo
+ * and 2 * nodeNo + 1. The node must not be on the last level.
+ */
+func childrenOf(nodeNo [32]byte) ([32]byte, [32]byte) {
+    if nodeNo[0]&0x80 != 0 {
+        panic("Children's LNs do not fit in 32 bytes")
+    }
+
+    var left [32]byte
+    for i := 0; i < 31; i++ {
+        left[i] = nodeNo[i]<<1 | nodeNo[i+1]>>7
+    }
+    left[31] = nodeNo[31] << 1
+
+    right := left
+    right[31] |= 1
+    return left, right
+}
+
+/**
+ * Shifts 'num', a 256-bit big-endian number, to the right by 'bits' bits.
+ * Used to get the LN of an ancestor 'bits' levels up.
+ */
+func rshBytes(num [32]byte, bits uint) [32]byte {
+    var shifted [32]byte
+    byteShift := int(bits / 8)
+    bitShift := bits % 8
+
+    for i := 31; i >= byteShift; i-- {
+        shifted[i] = num[i-byteShift] >> bitShift
+        if bitShift > 0 && i-byteShift > 0 {
+            shifted[i] |= num[i-byteShift-1] << (8 - bitShift)
+        }
+    }
+    return shifted
+}
+
+/**
+ * Returns bit number 'i' of 'num', a 256-bit big-endian number, where bit 0 is the least significant one.
+ */
+func bitOf(num [32]byte, i uint) byte {
+    return (num[31-i/8] >> (i % 8)) & 1
+}
+
+/**
+ * Shifts 'num', a 256-bit big-endian number, to the left by 'bits' bits, dropping the top bits.
+ */
+func lshBytes(num [32]byte, bits uint) [32]byte {
+    var shifted [32]byte
+    byteShift := int(bits / 8)
+    bitShift := bits % 8
+
+    for i := 0; i < 32-byteShift; i++ {
+        shifted[i] = num[i+byteShift] << bitShift
+        if bitShift > 0 && i+byteShift < 31 {
+            shifted[i] |= num[i+byteShift+1] >> (8 - bitShift)
+        }
+    }
+    return shifted
+}
+
+/**
+ * Returns true if the two hashes are equal, in time that does not depend on the first byte where
+ * they differ, so that verifiers do not leak how much of a forged hash matches the expected one.
+ * Verifiers check computed hashes (e.g., roots) against expected ones via this function.
+ *
+ * NOTE: Comparisons against the empty hash (e.g., to skip empty subtrees) do not use it, since
+ * which siblings are empty is public: it is in the proof. Neither does the VRF, whose big.Int
+ * arithmetic is not constant time in the first place.
+ */
+func hashEqual(a [32]byte, b [32]byte) bool {
+    return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+/**
+ * Returns the # of leading bits that 'a' and 'b' have in common.
+ */
+func commonPrefixLen(a [32]byte, b [32]byte) int {
+    for i := 0; i < 32; i++ {
+        if x := a[i] ^ b[i]; x != 0 {
+            return i*8 + bits.LeadingZeros8(x)
+        }
+    }
+    return 256
+}
+
+/**
+ * Garbage collects and returns the heap memory in use in MB.
+ */
+func heapUsageAfterGc() uint64 {
+    runtime.GC()
+    return memUsage()
+}
+
+/**
+ * Returns the peak resident set size of the process so far in MB, or 0 if it cannot be measured.
+ */
+func peakRssMB() uint64 {
+    var usage syscall.Rusage
+    if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+        return 0
+    }
+
+    // Linux reports it in KB, but macOS in bytes
+    maxRss := uint64(usage.Maxrss)
+    if runtime.GOOS == "darwin" {
+        return maxRss / (1024 * 1024)
+    }
+    return maxRss / 1024
+}
@@ -0,0 +1,117 @@
+package aomt
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/binary"
+    "hash"
+)
+
+/**
+ * Leaves only store a 32-byte hash, but applications usually need the values themselves (e.g.,
+ * a user's public key, or a 64-byte signature), so the tree can also store the value of a leaf,
+ * of any length: the leaf's data hash is then H(tag || leafNo || len(value) || value), where H is
+ * the tree's hash function and the length is 8 bytes, big-endian, and the value is kept next to
+ * the leaf, in a map from leaf no to value. Binding the leaf no into the data hash means a value
+ * cannot be replayed as the value of another leaf, and the tag means a value's data hash cannot be
+ * passed off as some other kind of data hash (e.g., a commitment, see Opening).
+ *
+ * Unlike openings (see Opening), values are not hidden: equal values in the same leaf always have
+ * the same data hash, so anyone can check guesses of a leaf's value against its hash. Membership
+ * proofs include the value only if asked to (see Tree::ProveMembershipWithValue()).
+ *
+ * NOTE: Updating a leaf via Tree::Update() drops its value, since the leaf hash then commits to a
+ * version chain (see Tree::Update()) rather than to a single value. Values are not stored in
+ * snapshots either.
+ */
+
+// The domain separation tag of value data hashes, see ValueHash()
+var valueHashTag = []byte("aomt-leaf-value")
+
+/**
+ * Returns the data hash of a leaf with the specified value, i.e., H(tag || leafNo || len(value) ||
+ * value).
+ */
+func ValueHash(newHash func() hash.Hash, leafNo [32]byte, value []byte) [32]byte {
+    if newHash == nil {
+        newHash = sha256.New
+    }
+
+    var length [8]byte
+    binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+    return _hashWith(newHash, valueHashTag, leafNo[:], length[:], value)
+}
+
+/**
+ * Inserts a new leaf with the specified value, whose data hash is ValueHash(leafNo, value) (see
+ * Tree::Insert()). The tree keeps a copy of the value.
+ */
+func (tree *Tree) InsertValue(leafNo [32]byte, value []byte, proofTree *Tree) {
+    tree.Insert(leafNo, ValueHash(tree.newHash, leafNo, value), proofTree)
+    tree.values[leafNo] = append([]byte{}, value...)
+}
+
+/**
+ * Inserts a new leaf with the specified value in the epoch (see Tree::InsertValue()).
+ */
+func (epoch *Epoch) InsertValue(leafNo [32]byte, value []byte) error {
+    if err := epoch.Insert(leafNo, ValueHash(epoch.tree.newHash, leafNo, value)); err != nil {
+        return err
+    }
+    epoch.tree.values[leafNo] = append([]byte{}, value...)
+    return nil
+}
+
+/**
+ * Returns the value of the specified leaf and true, or nil and false if the leaf was not
+ * inserted via InsertValue() (or was updated since).
+ */
+func (tree *Tree) GetValue(leafNo [32]byte) ([]byte, bool) {
+    value, ok := tree.values[leafNo]
+    return value, ok
+}
+
+/**
+ * Like ProveMembership(), but also includes the leaf's value in the proof, if it has one.
+ */
+func (tree *Tree) ProveMembershipWithValue(leafNo [32]byte) *MembershipProof {
+    proof := tree.ProveMembership(leafNo)
+    proof.Value = tree.values[leafNo]
+    return proof
+}
+
+/**
+ * Returns true if the proof's value (if any) is the value of the proof's leaf hash.
+ */
+func _checkValue(proof *MembershipProof, opts TreeOptions) bool {
+    if proof.Value == nil {
+        return true
+    }
+
+    newHash := opts.NewHash
+    if newHash == nil {
+        newHash = sha256.New
+    }
+
+    leafHash := _leafHashWith(newHash, opts.RFC6962, opts._leafHashKey(), ValueHash(newHash, proof.LeafNo, proof.Value))
+    return hashEqual(leafHash, proof.LeafHash)
+}
+
+/**
+ * Checks that the proof shows the proof's leaf has the specified value as of the specified root
+ * hash, for a client that already has the raw value (so the proof need not include it, see
+ * Tree::ProveMembershipWithValue()). See VerifyMembershipProofWithOptions().
+ */
+func VerifyValueProof(proof *MembershipProof, rootHash [32]byte, value []byte, opts TreeOptions) bool {
+    if proof.Value != nil && subtle.ConstantTimeCompare(proof.Value, value) != 1 {
+        return false
+    }
+    // The value must be non-nil, or else _checkValue() would not check it
+    if value == nil {
+        value = []byte{}
+    }
+
+    withValue := *proof
+    withValue.Value = value
+    return VerifyMembershipProofWithOptions(&withValue, rootHash, opts)
+}
@@ -0,0 +1,66 @@
+package aomt
+
+import (
+    "errors"
+    "fmt"
+)
+
+/**
+ * The classes of verification failures, so that callers (e.g., servers and monitors) can tell a
+ * proof for other roots (which may be a fork, or just a stale head) from a malformed one (which is
+ * a buggy or malicious prover) without parsing messages. Verifiers return them wrapped in a
+ * VerifyError, which keeps the detailed message, so callers branch via errors.Is():
+ *
+ *   if errors.Is(err, ErrOldRootMismatch) { ... }
+ *
+ * and errors.As() gets the VerifyError itself. Errors from decoding proofs (e.g., via
+ * AppendOnlyProof::UnmarshalBinary()) are not classified, except when verifying streamed proofs,
+ * whose decoding is part of verifying them (see VerifyAppendOnlyStream()).
+ */
+var (
+    // The proof does not hash to the old root (append-only proofs)
+    ErrOldRootMismatch = errors.New("old root mismatch")
+    // The proof hashes to the old root, but not to the new root (append-only proofs)
+    ErrNewRootMismatch = errors.New("new root mismatch")
+    // The proof does not hash to the root (membership proofs)
+    ErrRootMismatch = errors.New("root mismatch")
+    // The proof is not well-formed, e.g., nodes are missing, out of order or on invalid levels
+    ErrMalformedProof = errors.New("malformed proof")
+    // The leaf's opening, value or extensions do not match its hash, or are missing (see
+    // TreeOptions::LeafExtensions)
+    ErrLeafMismatch = errors.New("leaf mismatch")
+    // The verifier's parameters are invalid (e.g., the hash function or # of levels), or are not
+    // those of the proof's tree
+    ErrInvalidParams = errors.New("invalid parameters")
+)
+
+/**
+ * A verification failure: one of the Err* classes above, along with what exactly failed.
+ */
+type VerifyError struct {
+    Kind error
+    Msg  string
+}
+
+func (e *VerifyError) Error() string {
+    return e.Msg
+}
+
+func (e *VerifyError) Unwrap() error {
+    return e.Kind
+}
+
+func _verifyErrorf(kind error, format string, args ...interface{}) error {
+    return &VerifyError{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+/**
+ * Classifies 'err' as ErrMalformedProof, unless it was already classified.
+ */
+func _malformed(err error) error {
+    var verifyErr *VerifyError
+    if err == nil || errors.As(err, &verifyErr) {
+        return err
+    }
+    return &VerifyError{Kind: ErrMalformedProof, Msg: err.Error()}
+}
@@ -0,0 +1,138 @@
+package aomt
+
+import (
+    "fmt"
+    "sort"
+)
+
+/**
+ * A version of a node: its hash as of the end of 'epoch', and until the node's next version.
+ * Versioned trees keep the versions of every node, so they can answer queries about past epochs
+ * (see Tree::Version()) without keeping full copies of the tree: only nodes that changed during
+ * an epoch get a new version.
+ */
+type nodeVersion struct {
+    epoch int
+    hash  [32]byte
+}
+
+/**
+ * Records the new hash of a node, if the tree is versioned. Changes are attributed to the epoch
+ * that will be recorded next, and several changes during the same epoch only keep the last one.
+ */
+func (tree *Tree) _recordVersion(level int, nodeNo [32]byte, nodeHash [32]byte) {
+    if tree.versions == nil {
+        return
+    }
+
+    epoch := len(tree.epochs)
+    versions := tree.versions[level][nodeNo]
+    if n := len(versions); n > 0 && versions[n-1].epoch == epoch {
+        versions[n-1].hash = nodeHash
+        return
+    }
+    tree.versions[level][nodeNo] = append(versions, nodeVersion{epoch: epoch, hash: nodeHash})
+}
+
+/**
+ * Makes every node of the tree its own first version, for trees that become versioned after
+ * they were built (i.e., loaded from a snapshot). Past epochs cannot be queried then.
+ */
+func (tree *Tree) _seedVersions() {
+    // If there are pending changes, the nodes are not as of the last epoch
+    tree.versionsFrom = len(tree.epochs)
+    if len(tree.pending) == 0 && len(tree.epochs) > 0 {
+        tree.versionsFrom = len(tree.epochs) - 1
+    }
+
+    for level := 0; level < tree.numLevels; level++ {
+        for nodeNo, node := range tree.lvl[level].node {
+            tree.versions[level][nodeNo] = []nodeVersion{{epoch: tree.versionsFrom, hash: node.Hash}}
+        }
+    }
+}
+
+/**
+ * An immutable view of a versioned tree as of the end of a recorded epoch.
+ */
+type TreeVersion struct {
+    tree  *Tree
+    epoch int
+}
+
+/**
+ * Returns a view of the tree as of the end of the specified epoch. The tree must have been created
+ * with TreeOptions::Versioned.
+ */
+func (tree *Tree) Version(epoch int) *TreeVersion {
+    if tree.versions == nil {
+        panic("Cannot get past versions of a tree that is not versioned")
+    }
+    if epoch < tree.versionsFrom || epoch >= len(tree.epochs) {
+        panic(fmt.Sprintf("Cannot get version of epoch %d (have versions of epochs %d to %d)",
+            epoch, tree.versionsFrom, len(tree.epochs)-1))
+    }
+
+    v := &TreeVersion{tree: tree, epoch: epoch}
+    if v.GetRootHash() != tree.epochs[epoch].root {
+        panic(fmt.Sprintf("Something's off: versioned root does not match epoch %d root", epoch))
+    }
+    return v
+}
+
+func (v *TreeVersion) Epoch() int {
+    return v.epoch
+}
+
+/**
+ * Returns the hash of the specified node as of the view's epoch, and whether the node existed.
+ */
+func (v *TreeVersion) _getNodeHash(level int, nodeNo [32]byte) ([32]byte, bool) {
+    versions := v.tree.versions[level][nodeNo]
+
+    // Find the last version from the view's epoch or before
+    i := sort.Search(len(versions), func(i int) bool {
+        return versions[i].epoch > v.epoch
+    })
+    if i == 0 {
+        return v.tree.EmptyHash, false
+    }
+    return versions[i-1].hash, true
+}
+
+func (v *TreeVersion) GetRootHash() [32]byte {
+    rootHash, _ := v._getNodeHash(0, v.tree.RootNo)
+    return rootHash
+}
+
+/**
+ * Like Tree::Get(), but as of the view's epoch.
+ */
+func (v *TreeVersion) Get(leafNo [32]byte) ([32]byte, bool) {
+    return v._getNodeHash(v.tree.numLevels-1, leafNo)
+}
+
+/**
+ * Like Tree::ProveMembership(), but as of the view's epoch, so the proof can be checked against
+ * the epoch's root hash.
+ */
+func (v *TreeVersion) ProveMembership(leafNo [32]byte) *MembershipProof {
+    proof := &MembershipProof{LeafNo: leafNo}
+    proof.LeafHash, _ = v.Get(leafNo)
+    // Committed leaves never change, unless they are updated, which drops their opening
+    if proof.LeafHash != v.tree.EmptyHash {
+        proof.Opening = v.tree.openings[leafNo]
+        proof.Extended = v.tree.extended[leafNo]
+    }
+    proof.Siblings = make([][32]byte, 0, v.tree.numLevels-1)
+
+    nodeNo := leafNo
+    for level := v.tree.numLevels - 1; level > 0; level-- {
+        siblingNo, _ := siblingOf(nodeNo)
+        siblingHash, _ := v._getNodeHash(level, siblingNo)
+        proof.Siblings = append(proof.Siblings, siblingHash)
+        nodeNo = parentOf(nodeNo)
+    }
+
+    return proof
+}
@@ -0,0 +1,55 @@
+package aomt
+
+import (
+    "reflect"
+    "testing"
+)
+
+/**
+ * The versions of a versioned tree must be the tree as of their epochs: the same roots, leaves and
+ * proofs as the tree replayed up to the epoch, even for leaves updated or inserted later on.
+ */
+func TestVersionsMatchPastEpochs(t *testing.T) {
+    tree, err := NewTreeWithOptions(257, TreeOptions{Versioned: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    next := 0
+    for e := 0; e < 5; e++ {
+        for i := 0; i < 20; i++ {
+            leaf := _testLeaf(next)
+            tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+            next++
+        }
+        for i := 0; i < next; i += 7 {
+            tree.Update(_testLeaf(i).LeafNo, _testLeaf(i+e).DataHash, nil)
+        }
+        tree.RecordEpoch()
+    }
+    // Pending changes are not part of any version
+    leaf := _testLeaf(next)
+    tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+
+    for e := 0; e < tree.NumEpochs(); e++ {
+        version := tree.Version(e)
+        past := tree.TreeAtEpoch(e)
+        if version.GetRootHash() != tree.GetEpochRoot(e) {
+            t.Fatalf("version %d has root %s, expected %s", e, hashStr(version.GetRootHash()), hashStr(tree.GetEpochRoot(e)))
+        }
+        for i := 0; i <= next; i += 3 {
+            leafNo := _testLeaf(i).LeafNo
+            hash, ok := version.Get(leafNo)
+            pastHash, pastOk := past.Get(leafNo)
+            if hash != pastHash || ok != pastOk {
+                t.Errorf("version %d has leaf %d as %s (%v), expected %s (%v)", e, i, hashStr(hash), ok, hashStr(pastHash), pastOk)
+            }
+            proof := version.ProveMembership(leafNo)
+            if !reflect.DeepEqual(proof, past.ProveMembership(leafNo)) {
+                t.Errorf("version %d proves leaf %d unlike the tree as of the epoch", e, i)
+            }
+            if !VerifyMembershipProofWithOptions(proof, tree.GetEpochRoot(e), tree.Options()) {
+                t.Errorf("version %d's proof of leaf %d does not verify", e, i)
+            }
+        }
+    }
+}
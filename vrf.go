@@ -0,0 +1,294 @@
+package aomt
+
+import (
+    "bytes"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "io"
+    "math/big"
+)
+
+/**
+ * A verifiable random function (VRF), so that leaf numbers can be VRF(label) rather than
+ * SHA256(label), as in CONIKS and key transparency: only the holder of the VRF key can compute the
+ * leaf no of a label, so the tree's leaves cannot be enumerated by hashing user IDs, while anyone
+ * with the VRF public key can check that a leaf no was derived from a label via the VRF proof.
+ *
+ * This implements ECVRF-P256-SHA256-TAI from RFC 9381 (suite 0x01), since the standard library has
+ * P-256 arithmetic but no Edwards25519 arithmetic. Points are encoded in SEC1 compressed form (33
+ * bytes), so proofs are 81 bytes: Gamma (33 bytes) || c (16 bytes) || s (32 bytes). The VRF output
+ * (i.e., 'beta') is a SHA256 hash, which we use as the leaf no as is.
+ */
+const (
+    vrfSuite        = 0x01
+    vrfPointLen     = 33
+    vrfChallengeLen = 16
+    vrfScalarLen    = 32
+    VRFProofLen     = vrfPointLen + vrfChallengeLen + vrfScalarLen
+)
+
+var vrfCurve = elliptic.P256()
+
+type VRFPublicKey struct {
+    x, y *big.Int
+}
+
+type VRFPrivateKey struct {
+    VRFPublicKey
+    sk *big.Int
+}
+
+/**
+ * Generates a new VRF key using the randomness from 'rand' (e.g., crypto/rand.Reader).
+ */
+func GenerateVRFKey(rand io.Reader) (*VRFPrivateKey, error) {
+    var skBytes [vrfScalarLen]byte
+    for {
+        if _, err := io.ReadFull(rand, skBytes[:]); err != nil {
+            return nil, err
+        }
+        if key, err := NewVRFPrivateKey(skBytes[:]); err == nil {
+            return key, nil
+        }
+    }
+}
+
+/**
+ * Returns the VRF key with the specified 32-byte big-endian secret scalar, which must be in [1, q).
+ */
+func NewVRFPrivateKey(skBytes []byte) (*VRFPrivateKey, error) {
+    if len(skBytes) != vrfScalarLen {
+        return nil, fmt.Errorf("VRF secret key must be %d bytes, got %d", vrfScalarLen, len(skBytes))
+    }
+
+    sk := new(big.Int).SetBytes(skBytes)
+    if sk.Sign() == 0 || sk.Cmp(vrfCurve.Params().N) >= 0 {
+        return nil, errors.New("VRF secret key is out of range")
+    }
+
+    x, y := vrfCurve.ScalarBaseMult(_vrfScalarBytes(sk))
+    return &VRFPrivateKey{VRFPublicKey: VRFPublicKey{x, y}, sk: sk}, nil
+}
+
+/**
+ * Returns the 32-byte big-endian secret scalar of the key.
+ */
+func (key *VRFPrivateKey) Bytes() []byte {
+    return _vrfScalarBytes(key.sk)
+}
+
+func (key *VRFPrivateKey) Public() *VRFPublicKey {
+    return &key.VRFPublicKey
+}
+
+/**
+ * Parses a VRF public key in SEC1 compressed form.
+ */
+func ParseVRFPublicKey(data []byte) (*VRFPublicKey, error) {
+    x, y := _vrfStringToPoint(data)
+    if x == nil {
+        return nil, errors.New("invalid VRF public key")
+    }
+    return &VRFPublicKey{x, y}, nil
+}
+
+/**
+ * Returns the public key in SEC1 compressed form.
+ */
+func (pk *VRFPublicKey) Bytes() []byte {
+    return elliptic.MarshalCompressed(vrfCurve, pk.x, pk.y)
+}
+
+/**
+ * Computes the VRF output of 'alpha' and the proof that it is correct.
+ */
+func (key *VRFPrivateKey) Prove(alpha []byte) ([32]byte, []byte) {
+    pkString := key.VRFPublicKey.Bytes()
+    hx, hy := _vrfEncodeToCurve(pkString, alpha)
+    hString := elliptic.MarshalCompressed(vrfCurve, hx, hy)
+
+    gammaX, gammaY := vrfCurve.ScalarMult(hx, hy, _vrfScalarBytes(key.sk))
+
+    k := _vrfNonce(key.sk, hString)
+    ux, uy := vrfCurve.ScalarBaseMult(_vrfScalarBytes(k))
+    vx, vy := vrfCurve.ScalarMult(hx, hy, _vrfScalarBytes(k))
+
+    c := _vrfChallenge(key.x, key.y, hx, hy, gammaX, gammaY, ux, uy, vx, vy)
+
+    // s = (k + c * sk) mod q
+    s := new(big.Int).Mul(c, key.sk)
+    s.Add(s, k)
+    s.Mod(s, vrfCurve.Params().N)
+
+    pi := make([]byte, 0, VRFProofLen)
+    pi = append(pi, elliptic.MarshalCompressed(vrfCurve, gammaX, gammaY)...)
+    pi = append(pi, _vrfIntToString(c, vrfChallengeLen)...)
+    pi = append(pi, _vrfIntToString(s, vrfScalarLen)...)
+
+    return _vrfProofToHash(gammaX, gammaY), pi
+}
+
+/**
+ * Checks that 'pi' is a valid proof for 'alpha' under this public key, and returns the VRF output.
+ */
+func (pk *VRFPublicKey) Verify(alpha []byte, pi []byte) ([32]byte, error) {
+    if len(pi) != VRFProofLen {
+        return [32]byte{}, fmt.Errorf("VRF proof must be %d bytes, got %d", VRFProofLen, len(pi))
+    }
+
+    gammaX, gammaY := _vrfStringToPoint(pi[:vrfPointLen])
+    if gammaX == nil {
+        return [32]byte{}, errors.New("invalid VRF proof: Gamma is not a valid point")
+    }
+    c := new(big.Int).SetBytes(pi[vrfPointLen : vrfPointLen+vrfChallengeLen])
+    if c.Sign() == 0 {
+        return [32]byte{}, errors.New("invalid VRF proof: c is zero")
+    }
+    s := new(big.Int).SetBytes(pi[vrfPointLen+vrfChallengeLen:])
+    if s.Cmp(vrfCurve.Params().N) >= 0 {
+        return [32]byte{}, errors.New("invalid VRF proof: s is out of range")
+    }
+    if pk.x == nil || !vrfCurve.IsOnCurve(pk.x, pk.y) {
+        return [32]byte{}, errors.New("invalid VRF public key")
+    }
+
+    hx, hy := _vrfEncodeToCurve(pk.Bytes(), alpha)
+
+    // U = s * B - c * Y and V = s * H - c * Gamma
+    ux, uy := _vrfSubMult(s, nil, nil, c, pk.x, pk.y)
+    vx, vy := _vrfSubMult(s, hx, hy, c, gammaX, gammaY)
+
+    if _vrfChallenge(pk.x, pk.y, hx, hy, gammaX, gammaY, ux, uy, vx, vy).Cmp(c) != 0 {
+        return [32]byte{}, errors.New("invalid VRF proof")
+    }
+    return _vrfProofToHash(gammaX, gammaY), nil
+}
+
+/**
+ * Returns s * P - c * Q, where P is the base point if 'px' is nil. The point at infinity is (0, 0),
+ * as returned by ScalarMult(), so it must not be negated like other points.
+ */
+func _vrfSubMult(s *big.Int, px, py *big.Int, c *big.Int, qx, qy *big.Int) (*big.Int, *big.Int) {
+    var sx, sy *big.Int
+    if px == nil {
+        sx, sy = vrfCurve.ScalarBaseMult(_vrfScalarBytes(s))
+    } else {
+        sx, sy = vrfCurve.ScalarMult(px, py, _vrfScalarBytes(s))
+    }
+
+    cx, cy := vrfCurve.ScalarMult(qx, qy, _vrfScalarBytes(c))
+    if cx.Sign() == 0 && cy.Sign() == 0 {
+        return sx, sy
+    }
+    cy.Sub(vrfCurve.Params().P, cy)
+    if sx.Sign() == 0 && sy.Sign() == 0 {
+        return cx, cy
+    }
+    return vrfCurve.Add(sx, sy, cx, cy)
+}
+
+/**
+ * ECVRF_encode_to_curve_try_and_increment from RFC 9381, with the public key as the salt.
+ */
+func _vrfEncodeToCurve(salt []byte, alpha []byte) (*big.Int, *big.Int) {
+    for ctr := 0; ctr < 256; ctr++ {
+        h := sha256.New()
+        h.Write([]byte{vrfSuite, 0x01})
+        h.Write(salt)
+        h.Write(alpha)
+        h.Write([]byte{byte(ctr), 0x00})
+
+        // About half of the x coordinates are on the curve
+        if x, y := _vrfStringToPoint(append([]byte{0x02}, h.Sum(nil)...)); x != nil {
+            return x, y
+        }
+    }
+    panic("Something's off: could not hash to the curve after 256 tries")
+}
+
+/**
+ * ECVRF_challenge_generation from RFC 9381.
+ */
+func _vrfChallenge(coords ...*big.Int) *big.Int {
+    h := sha256.New()
+    h.Write([]byte{vrfSuite, 0x02})
+    for i := 0; i < len(coords); i += 2 {
+        h.Write(elliptic.MarshalCompressed(vrfCurve, coords[i], coords[i+1]))
+    }
+    h.Write([]byte{0x00})
+    return new(big.Int).SetBytes(h.Sum(nil)[:vrfChallengeLen])
+}
+
+/**
+ * ECVRF_proof_to_hash from RFC 9381 (the cofactor of P-256 is 1).
+ */
+func _vrfProofToHash(gammaX, gammaY *big.Int) [32]byte {
+    h := sha256.New()
+    h.Write([]byte{vrfSuite, 0x03})
+    h.Write(elliptic.MarshalCompressed(vrfCurve, gammaX, gammaY))
+    h.Write([]byte{0x00})
+
+    var beta [32]byte
+    copy(beta[:], h.Sum(nil))
+    return beta
+}
+
+/**
+ * Deterministically generates the nonce from the secret key and the encoded H, as in RFC 6979
+ * section 3.2 with SHA256 (see ECVRF_nonce_generation_RFC6979 in RFC 9381).
+ */
+func _vrfNonce(sk *big.Int, hString []byte) *big.Int {
+    q := vrfCurve.Params().N
+    h1 := sha256.Sum256(hString)
+    // bits2octets(h1): q has 256 bits, like SHA256, so there's nothing to truncate
+    z := new(big.Int).SetBytes(h1[:])
+    z.Mod(z, q)
+
+    mac := func(key []byte, parts ...[]byte) []byte {
+        m := hmac.New(sha256.New, key)
+        for _, part := range parts {
+            m.Write(part)
+        }
+        return m.Sum(nil)
+    }
+
+    x := _vrfScalarBytes(sk)
+    zBytes := _vrfIntToString(z, vrfScalarLen)
+    k := make([]byte, 32)
+    v := bytes.Repeat([]byte{0x01}, 32)
+    k = mac(k, v, []byte{0x00}, x, zBytes)
+    v = mac(k, v)
+    k = mac(k, v, []byte{0x01}, x, zBytes)
+    v = mac(k, v)
+
+    for {
+        v = mac(k, v)
+        nonce := new(big.Int).SetBytes(v)
+        if nonce.Sign() > 0 && nonce.Cmp(q) < 0 {
+            return nonce
+        }
+        k = mac(k, v, []byte{0x00})
+        v = mac(k, v)
+    }
+}
+
+/**
+ * Decodes a point in SEC1 compressed form, or returns nil if it is not a valid point.
+ */
+func _vrfStringToPoint(data []byte) (*big.Int, *big.Int) {
+    if len(data) != vrfPointLen {
+        return nil, nil
+    }
+    return elliptic.UnmarshalCompressed(vrfCurve, data)
+}
+
+func _vrfIntToString(n *big.Int, length int) []byte {
+    return n.FillBytes(make([]byte, length))
+}
+
+func _vrfScalarBytes(n *big.Int) []byte {
+    return _vrfIntToString(n, vrfScalarLen)
+}
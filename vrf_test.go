@@ -0,0 +1,100 @@
+package aomt
+
+import (
+    "encoding/hex"
+    "math/big"
+    "testing"
+)
+
+func _testHex(t *testing.T, s string) []byte {
+    data, err := hex.DecodeString(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return data
+}
+
+/**
+ * The ECVRF-P256-SHA256-TAI test vector from RFC 9381, appendix B.1 (example 10).
+ */
+func TestVRFVectors(t *testing.T) {
+    key, err := NewVRFPrivateKey(_testHex(t, "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pk := hex.EncodeToString(key.Public().Bytes()); pk != "0360fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6" {
+        t.Fatalf("public key is %s", pk)
+    }
+
+    alpha := []byte("sample")
+    beta, pi := key.Prove(alpha)
+    if hex.EncodeToString(pi) != "035b5c726e8c0e2c488a107c600578ee75cb702343c153cb1eb8dec77f4b5071b4a53f0a46f018bc2c56e58d383f2305e0975972c26feea0eb122fe7893c15af376b33edf7de17c6ea056d4d82de6bc02f" {
+        t.Fatalf("proof is %x", pi)
+    }
+    if hex.EncodeToString(beta[:]) != "a3ad7b0ef73d8fc6655053ea22f9bede8c743f08bbed3d38821f0e16474b505e" {
+        t.Fatalf("output is %x", beta)
+    }
+
+    output, err := key.Public().Verify(alpha, pi)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if output != beta {
+        t.Fatalf("verified output is %x, expected %x", output, beta)
+    }
+}
+
+/**
+ * Malformed proofs must be rejected with an error, never with a panic.
+ */
+func TestVRFRejectsMalformedProofs(t *testing.T) {
+    key, err := NewVRFPrivateKey(_testHex(t, "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    alpha := []byte("sample")
+    _, pi := key.Prove(alpha)
+
+    gamma := pi[:vrfPointLen]
+    c := pi[vrfPointLen : vrfPointLen+vrfChallengeLen]
+    s := pi[vrfPointLen+vrfChallengeLen:]
+    proof := func(gamma, c, s []byte) []byte {
+        return append(append(append([]byte{}, gamma...), c...), s...)
+    }
+    zeroC := make([]byte, vrfChallengeLen)
+    zeroS := make([]byte, vrfScalarLen)
+    orderS := _vrfScalarBytes(vrfCurve.Params().N)
+    maxS := _vrfScalarBytes(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)))
+    zeroGamma := make([]byte, vrfPointLen)
+    notOnCurve := append([]byte{0x02}, make([]byte, vrfPointLen-1)...)
+    notOnCurve[vrfPointLen-1] = 0x05
+    flippedC := append([]byte{}, c...)
+    flippedC[0] ^= 1
+    flippedS := append([]byte{}, s...)
+    flippedS[31] ^= 1
+
+    cases := map[string][]byte{
+        "zero c":             proof(gamma, zeroC, s),
+        "zero s":             proof(gamma, c, zeroS),
+        "zero c and s":       proof(gamma, zeroC, zeroS),
+        "s = n":              proof(gamma, c, orderS),
+        "s = 2^256 - 1":      proof(gamma, c, maxS),
+        "zero Gamma":         proof(zeroGamma, c, s),
+        "Gamma off curve":    proof(notOnCurve, c, s),
+        "tampered c":         proof(gamma, flippedC, s),
+        "tampered s":         proof(gamma, c, flippedS),
+        "all zeros":          make([]byte, VRFProofLen),
+        "truncated":          pi[:VRFProofLen-1],
+        "empty":              nil,
+        "Gamma of other key": proof(key.Public().Bytes(), c, s),
+    }
+    for name, pi := range cases {
+        if _, err := key.Public().Verify(alpha, pi); err == nil {
+            t.Errorf("%s: proof verified", name)
+        }
+    }
+
+    if _, err := key.Public().Verify([]byte("other"), pi); err == nil {
+        t.Errorf("proof verified for another input")
+    }
+}
@@ -0,0 +1,391 @@
+package aomt
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+/**
+ * A write-ahead log (WAL) of the leaf changes of each epoch, for trees kept in a snapshot file
+ * (see Tree::Snapshot()). An epoch is logged once it is recorded and before the snapshot is
+ * rewritten, so a crash while writing the snapshot loses nothing: when the tree is reopened, the
+ * epochs in the WAL that are missing from the snapshot are replayed and each replayed root hash is
+ * checked against the one that was logged. Once the snapshot is saved, the WAL is checkpointed
+ * (i.e., truncated). The WAL is a sequence of records, each starting with a kind (1 byte):
+ *
+ *   change       (kind 'C')
+ *     flags      (1 byte: bit 0 is set for updates)
+ *     leafNo     (32 bytes)
+ *     dataHash   (32 bytes)
+ *   commit       (kind 'T'), ends the epoch made of the change records since the previous commit
+ *     epoch      (8 bytes, big-endian)
+ *     root       (32 bytes)
+ *     timestamp  (8 bytes, big-endian, in Unix nanoseconds, see EpochHeader)
+ *
+ * so that replayed epochs get the same headers as the logged ones. Older WALs have commit
+ * records of kind 'E', without a timestamp, whose replayed epochs are timestamped when replayed.
+ *
+ * Change records after the last commit record (e.g., from a crash while logging an epoch) belong
+ * to an epoch that was never committed, so they are dropped, as is a torn record (or a tail of
+ * zeros) at the end.
+ *
+ * NOTE: Change records only have the leaves' data hashes, so the values, openings and extensions of
+ * leaves inserted via InsertValue(), InsertCommitted() or InsertWithExtensions() could not be
+ * replayed (as with snapshots, see ValueHash()). Rather than losing them, LogEpoch() refuses
+ * epochs with such leaves, so trees with them cannot be kept in a WAL (nor in a Store).
+ */
+type WAL struct {
+    file      *os.File
+    path      string
+    lastEpoch int // the last logged epoch, or -1 if none was logged since the WAL was opened empty
+}
+
+const (
+    walRecordChange     = 'C'
+    walRecordCommit     = 'E' // without a timestamp, only read
+    walRecordCommitTime = 'T'
+)
+
+/**
+ * A logged epoch, read back from the WAL.
+ */
+type walEpoch struct {
+    epoch  int
+    record epochRecord
+}
+
+/**
+ * Opens the WAL at 'path', creating it if it does not exist. Anything after the last commit
+ * record is truncated, so that new epochs are appended after the last committed one.
+ */
+func OpenWAL(path string) (*WAL, error) {
+    f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+
+    epochs, end, err := _readWAL(f)
+    if err == nil {
+        err = f.Truncate(end)
+    }
+    if err == nil {
+        _, err = f.Seek(end, io.SeekStart)
+    }
+    if err != nil {
+        f.Close()
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+
+    wal := &WAL{file: f, path: path, lastEpoch: -1}
+    if len(epochs) > 0 {
+        wal.lastEpoch = epochs[len(epochs)-1].epoch
+    }
+    return wal, nil
+}
+
+/**
+ * Returns the last logged epoch, even if it was dropped since (see TruncateThrough()), or -1 if
+ * none was logged since the WAL was opened empty.
+ */
+func (wal *WAL) LastEpoch() int {
+    return wal.lastEpoch
+}
+
+/**
+ * Durably logs the leaf changes and root hash of the specified recorded epoch of the tree. If it
+ * fails (e.g., the disk is full), the records written so far are truncated, so that they do not
+ * end up in the next logged epoch. Fails without writing anything if the epoch has leaves with
+ * values, openings or extensions, which the WAL cannot log (see WAL).
+ */
+func (wal *WAL) LogEpoch(tree *Tree, epoch int) error {
+    if epoch < 0 || epoch >= len(tree.epochs) {
+        return fmt.Errorf("epoch %d was never recorded (have %d epochs)", epoch, len(tree.epochs))
+    }
+    if err := tree._checkLoggable(tree.epochs[epoch]); err != nil {
+        return fmt.Errorf("epoch %d cannot be logged: %v", epoch, err)
+    }
+
+    start, err := wal.file.Seek(0, io.SeekCurrent)
+    if err != nil {
+        return err
+    }
+    bw := bufio.NewWriter(wal.file)
+    _writeWALEpoch(bw, epoch, tree.epochs[epoch])
+    err = bw.Flush()
+    if err == nil {
+        err = wal.file.Sync()
+    }
+    if err != nil {
+        if truncErr := wal._truncateTo(start); truncErr != nil {
+            return fmt.Errorf("%v (and truncating the partially logged epoch failed: %v)", err, truncErr)
+        }
+        return err
+    }
+    wal.lastEpoch = epoch
+    return nil
+}
+
+/**
+ * Drops everything after 'offset' in the WAL, which is where the next records are appended.
+ */
+func (wal *WAL) _truncateTo(offset int64) error {
+    if err := wal.file.Truncate(offset); err != nil {
+        return err
+    }
+    if _, err := wal.file.Seek(offset, io.SeekStart); err != nil {
+        return err
+    }
+    return wal.file.Sync()
+}
+
+/**
+ * Returns an error if one of the epoch's leaves has a value, an opening or extensions, which change
+ * records do not have.
+ */
+func (tree *Tree) _checkLoggable(record *epochRecord) error {
+    for _, change := range record.changes {
+        if _, ok := tree.values[change.leafNo]; ok {
+            return fmt.Errorf("leaf %s has a value", hashStr(change.leafNo))
+        }
+        if _, ok := tree.openings[change.leafNo]; ok {
+            return fmt.Errorf("leaf %s has an opening", hashStr(change.leafNo))
+        }
+        if _, ok := tree.extended[change.leafNo]; ok {
+            return fmt.Errorf("leaf %s has extensions", hashStr(change.leafNo))
+        }
+    }
+    return nil
+}
+
+func _writeWALEpoch(bw *bufio.Writer, epoch int, record *epochRecord) {
+    for _, change := range record.changes {
+        flags := byte(0)
+        if change.isUpdate {
+            flags |= snapshotFlagUpdate
+        }
+        bw.Write([]byte{walRecordChange, flags})
+        bw.Write(change.leafNo[:])
+        bw.Write(change.dataHash[:])
+    }
+    bw.WriteByte(walRecordCommitTime)
+    binary.Write(bw, binary.BigEndian, uint64(epoch))
+    bw.Write(record.root[:])
+    binary.Write(bw, binary.BigEndian, record.timestamp)
+}
+
+/**
+ * Re-applies to the tree the logged epochs that come after its last recorded epoch, returning the
+ * number of replayed epochs. Fails if the logged epochs skip one of the tree's missing epochs or if
+ * a replayed root hash differs from the logged one, in which case the tree must be discarded.
+ */
+func (wal *WAL) Replay(tree *Tree) (int, error) {
+    if tree.open != nil || len(tree.pending) > 0 {
+        return 0, errors.New("cannot replay the WAL on a tree with changes that are not in an epoch")
+    }
+
+    if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+        return 0, err
+    }
+    epochs, end, err := _readWAL(wal.file)
+    if err == nil {
+        _, err = wal.file.Seek(end, io.SeekStart)
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    numReplayed := 0
+    for _, logged := range epochs {
+        if logged.epoch < tree.NumEpochs() {
+            continue
+        }
+        if logged.epoch > tree.NumEpochs() {
+            return numReplayed, fmt.Errorf("the WAL skips from epoch %d to epoch %d", tree.NumEpochs()-1, logged.epoch)
+        }
+
+        tree._replayEpoch(&logged.record, nil)
+        if logged.record.timestamp != 0 {
+            tree._recordEpochAt(time.Unix(0, logged.record.timestamp))
+        } else {
+            tree.RecordEpoch()
+        }
+        if tree.GetRootHash() != logged.record.root {
+            return numReplayed, fmt.Errorf("the replayed root hash of epoch %d does not match the logged one", logged.epoch)
+        }
+        numReplayed++
+    }
+    return numReplayed, nil
+}
+
+/**
+ * Drops all logged epochs. Must only be called once they are all durably stored elsewhere (e.g.,
+ * after the tree's snapshot is saved).
+ */
+func (wal *WAL) Checkpoint() error {
+    if err := wal.file.Truncate(0); err != nil {
+        return err
+    }
+    if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    return wal.file.Sync()
+}
+
+/**
+ * Drops the logged epochs up to and including 'epoch', keeping the later ones, e.g., once a
+ * checkpoint of the tree as of 'epoch' is durably stored (see Store::Checkpoint()). The kept epochs
+ * are written to a new WAL that is renamed over this one, and the rename is fsync'ed, so a crash
+ * leaves either WAL intact, and the old one cannot come back once this returns.
+ */
+func (wal *WAL) TruncateThrough(epoch int) error {
+    if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    epochs, _, err := _readWAL(wal.file)
+    if err != nil {
+        return err
+    }
+
+    tmpPath := wal.path + ".tmp"
+    f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    bw := bufio.NewWriter(f)
+    for _, logged := range epochs {
+        if logged.epoch > epoch {
+            _writeWALEpoch(bw, logged.epoch, &logged.record)
+        }
+    }
+    err = bw.Flush()
+    if err == nil {
+        err = f.Sync()
+    }
+    if err == nil {
+        err = os.Rename(tmpPath, wal.path)
+    }
+    if err != nil {
+        f.Close()
+        os.Remove(tmpPath)
+        return err
+    }
+
+    // New epochs are appended to the new WAL, at its end
+    wal.file.Close()
+    wal.file = f
+    if _, err := f.Seek(0, io.SeekEnd); err != nil {
+        return err
+    }
+    // Otherwise, the old WAL could come back after a crash
+    return _syncDir(filepath.Dir(wal.path))
+}
+
+func (wal *WAL) Close() error {
+    return wal.file.Close()
+}
+
+/**
+ * Reads the committed epochs in the WAL, returning them and the offset right after the last
+ * commit record.
+ */
+func _readWAL(r io.Reader) ([]walEpoch, int64, error) {
+    br := bufio.NewReader(r)
+
+    var epochs []walEpoch
+    var changes []epochLeaf
+    var offset, end int64
+    for {
+        kind, err := br.ReadByte()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, 0, err
+        }
+
+        var size int64
+        switch kind {
+        case walRecordChange:
+            buf := make([]byte, 1+32+32)
+            if _, err := io.ReadFull(br, buf); err != nil {
+                return epochs, end, _walTornRecord(err)
+            }
+            change := epochLeaf{isUpdate: buf[0]&snapshotFlagUpdate != 0}
+            copy(change.leafNo[:], buf[1:33])
+            copy(change.dataHash[:], buf[33:])
+            changes = append(changes, change)
+            size = int64(len(buf))
+
+        case walRecordCommit, walRecordCommitTime:
+            buf := make([]byte, 8+32)
+            if kind == walRecordCommitTime {
+                buf = make([]byte, 8+32+8)
+            }
+            if _, err := io.ReadFull(br, buf); err != nil {
+                return epochs, end, _walTornRecord(err)
+            }
+            logged := walEpoch{epoch: int(binary.BigEndian.Uint64(buf[:8])), record: epochRecord{changes: changes}}
+            copy(logged.record.root[:], buf[8:40])
+            if kind == walRecordCommitTime {
+                logged.record.timestamp = int64(binary.BigEndian.Uint64(buf[40:]))
+            }
+            epochs = append(epochs, logged)
+            changes = nil
+            size = int64(len(buf))
+
+        default:
+            // Some file systems extend the file before writing its data, so a crash can leave
+            // zeros where the last records were being written
+            if kind == 0 {
+                if isZero, err := _walZeroTail(br); err != nil || isZero {
+                    return epochs, end, err
+                }
+            }
+            return nil, 0, fmt.Errorf("unknown WAL record kind 0x%02x at offset %d", kind, offset)
+        }
+
+        offset += 1 + size
+        if kind == walRecordCommit || kind == walRecordCommitTime {
+            end = offset
+        }
+    }
+    return epochs, end, nil
+}
+
+/**
+ * Returns true if the rest of the WAL is zeros, which are then dropped like a torn record.
+ */
+func _walZeroTail(br *bufio.Reader) (bool, error) {
+    buf := make([]byte, 4096)
+    for {
+        n, err := br.Read(buf)
+        for _, b := range buf[:n] {
+            if b != 0 {
+                return false, nil
+            }
+        }
+        if err == io.EOF {
+            return true, nil
+        }
+        if err != nil {
+            return false, err
+        }
+    }
+}
+
+/**
+ * A record cut short by the end of the WAL was being written when the process crashed, so it is
+ * dropped rather than reported.
+ */
+func _walTornRecord(err error) error {
+    if err == io.ErrUnexpectedEOF || err == io.EOF {
+        return nil
+    }
+    return err
+}
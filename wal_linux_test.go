@@ -0,0 +1,62 @@
+package aomt
+
+import (
+    "os"
+    "os/signal"
+    "path/filepath"
+    "syscall"
+    "testing"
+)
+
+/**
+ * An epoch that fails to be logged partway (e.g., because the disk is full) must not leave change
+ * records behind, or they would be replayed as part of the next logged epoch. Writes past the
+ * process's file size limit fail like writes to a full disk.
+ */
+func TestWALTruncatesFailedEpoch(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "wal")
+    wal, err := OpenWAL(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer wal.Close()
+    tree := NewTree(257)
+    if err := _testLogEpoch(t, tree, wal, 0, 10); err != nil {
+        t.Fatal(err)
+    }
+    info, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Without ignoring SIGXFSZ, exceeding the limit kills the process
+    signal.Ignore(syscall.SIGXFSZ)
+    defer signal.Reset(syscall.SIGXFSZ)
+    var limit syscall.Rlimit
+    if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+        t.Fatal(err)
+    }
+    lowered := limit
+    lowered.Cur = uint64(info.Size()) + 1000
+    if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &lowered); err != nil {
+        t.Skipf("cannot lower the file size limit: %v", err)
+    }
+    // 1000 leaves take 65 KB, so only some of their change records fit
+    err = _testLogEpoch(t, tree, wal, 10, 1000)
+    syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit)
+    if err == nil {
+        t.Fatal("logging an epoch past the file size limit succeeded")
+    }
+    if info2, err := os.Stat(path); err != nil || info2.Size() != info.Size() {
+        t.Fatalf("the WAL was not truncated back to %d bytes after the failed epoch: %v", info.Size(), err)
+    }
+
+    // The epoch that failed to be logged is logged again, with the next one
+    if err := wal.LogEpoch(tree, 1); err != nil {
+        t.Fatal(err)
+    }
+    if err := _testLogEpoch(t, tree, wal, 1010, 10); err != nil {
+        t.Fatal(err)
+    }
+    _testReplayWAL(t, path, tree)
+}
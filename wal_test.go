@@ -0,0 +1,134 @@
+package aomt
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+/**
+ * Records an epoch of 'numLeaves' new leaves, starting with leaf 'first', and logs it.
+ */
+func _testLogEpoch(t *testing.T, tree *Tree, wal *WAL, first int, numLeaves int) error {
+    for i := first; i < first+numLeaves; i++ {
+        leaf := _testLeaf(i)
+        tree.Insert(leaf.LeafNo, leaf.DataHash, nil)
+    }
+    return wal.LogEpoch(tree, tree.RecordEpoch())
+}
+
+/**
+ * Replays the WAL at 'path' on a new tree, checking that it has the same epochs as 'tree'.
+ */
+func _testReplayWAL(t *testing.T, path string, tree *Tree) {
+    wal, err := OpenWAL(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer wal.Close()
+
+    replayed := NewTree(257)
+    if _, err := wal.Replay(replayed); err != nil {
+        t.Fatal(err)
+    }
+    if replayed.NumEpochs() != tree.NumEpochs() {
+        t.Fatalf("replayed %d epochs, expected %d", replayed.NumEpochs(), tree.NumEpochs())
+    }
+    for e := 0; e < tree.NumEpochs(); e++ {
+        if replayed.GetEpochRoot(e) != tree.GetEpochRoot(e) {
+            t.Fatalf("the replayed root of epoch %d differs", e)
+        }
+    }
+}
+
+/**
+ * A crash can leave zeros at the end of the WAL, which must be dropped like a torn record.
+ */
+func TestWALDropsZeroTail(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "wal")
+    wal, err := OpenWAL(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tree := NewTree(257)
+    for e := 0; e < 3; e++ {
+        if err := _testLogEpoch(t, tree, wal, 10*e, 10); err != nil {
+            t.Fatal(err)
+        }
+    }
+    wal.Close()
+
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.Write(make([]byte, 10000))
+    f.Close()
+    _testReplayWAL(t, path, tree)
+
+    // Epochs logged after reopening the WAL come right after the last committed one
+    wal, err = OpenWAL(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := _testLogEpoch(t, tree, wal, 30, 10); err != nil {
+        t.Fatal(err)
+    }
+    wal.Close()
+    _testReplayWAL(t, path, tree)
+
+    // Non-zero garbage is not a torn record, though
+    f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.Write([]byte{0, 0, 0, 1})
+    f.Close()
+    if wal, err := OpenWAL(path); err == nil {
+        wal.Close()
+        t.Fatal("WAL with a tail of garbage was opened")
+    }
+}
+
+/**
+ * Epochs with leaves whose values, openings or extensions the WAL cannot log must be refused
+ * without writing anything.
+ */
+func TestWALRefusesUnloggableLeaves(t *testing.T) {
+    cases := map[string]struct {
+        opts   TreeOptions
+        insert func(tree *Tree, leaf LeafData)
+    }{
+        "value":      {TreeOptions{}, func(tree *Tree, leaf LeafData) { tree.InsertValue(leaf.LeafNo, []byte("value"), nil) }},
+        "opening":    {TreeOptions{}, func(tree *Tree, leaf LeafData) { tree.InsertCommitted(leaf.LeafNo, []byte("value"), nil) }},
+        "extensions": {TreeOptions{LeafExtensions: true}, func(tree *Tree, leaf LeafData) {
+            tree.InsertWithExtensions(leaf.LeafNo, leaf.DataHash, LeafExtensions{Timestamp: 1}, nil)
+        }},
+    }
+    for name, c := range cases {
+        path := filepath.Join(t.TempDir(), "wal")
+        wal, err := OpenWAL(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        tree, err := NewTreeWithOptions(257, c.opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for i := 0; i < 10; i++ {
+            c.insert(tree, _testLeaf(i))
+        }
+        if err := wal.LogEpoch(tree, tree.RecordEpoch()); err == nil {
+            t.Errorf("epoch with leaves with %s was logged", name)
+        }
+        wal.Close()
+
+        info, err := os.Stat(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if info.Size() != 0 {
+            t.Errorf("refusing leaves with %s left a WAL of %d bytes", name, info.Size())
+        }
+    }
+}
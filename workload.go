@@ -0,0 +1,191 @@
+package aomt
+
+import (
+    "bufio"
+    "crypto/sha256"
+    "fmt"
+    "math/rand"
+    "os"
+    "strings"
+)
+
+/**
+ * The workloads the benchmarks can insert, given as -workload <spec>:
+ *
+ *   uniform        leaf no's are a chain of SHA256 hashes of the seed (see leafGenerator), the default
+ *   usernames      usernames whose first names are Zipf-distributed, so popular names get numeric
+ *                  suffixes (e.g., 'alice', 'alice1', 'alice2', ...), as on real sign-up forms
+ *   emails         '<username>@<domain>', with usernames as above and Zipf-distributed domains
+ *   trace:<file>   the keys in a trace file, one per line, in order (empty lines and lines
+ *                  starting with '#' are skipped, as are keys seen before)
+ *
+ * The key of a leaf is mapped to its leaf no by hashing it, as the CLI does, or, with -raw-keys,
+ * by using its first 32 bytes (zero-padded) as the leaf no, so keys with common prefixes end up in
+ * the same subtrees and proof sizes reflect how real keys cluster. With raw keys, keys that map to
+ * an existing leaf no are skipped. Workloads are deterministic given the seed.
+ */
+type leafSource interface {
+    // Returns the next leaf no and its data hash
+    next() ([32]byte, [32]byte)
+}
+
+const (
+    workloadUniform   = "uniform"
+    workloadUsernames = "usernames"
+    workloadEmails    = "emails"
+    workloadTrace     = "trace:"
+)
+
+// The Zipf distribution's 's' parameter, i.e., how much more popular the popular names are
+const workloadZipfS = 1.1
+
+var workloadFirstNames = []string{
+    "james", "mary", "john", "patricia", "robert", "jennifer", "michael", "linda", "william", "elizabeth",
+    "david", "barbara", "richard", "susan", "joseph", "jessica", "thomas", "sarah", "charles", "karen",
+    "christopher", "nancy", "daniel", "lisa", "matthew", "betty", "anthony", "margaret", "mark", "sandra",
+    "wei", "fatima", "mohammed", "aisha", "juan", "maria", "hiroshi", "yuki", "olga", "ivan",
+    "alice", "bob", "carol", "dave", "eve", "mallory", "peggy", "trent", "victor", "walter",
+}
+
+var workloadDomains = []string{
+    "gmail.com", "yahoo.com", "outlook.com", "hotmail.com", "icloud.com", "protonmail.com",
+    "aol.com", "gmx.de", "mail.ru", "qq.com", "163.com", "yandex.ru", "web.de", "zoho.com",
+}
+
+/**
+ * Returns an error if 'spec' is not a valid workload, without opening its trace file.
+ */
+func checkWorkload(spec string, rawKeys bool) error {
+    if rawKeys && (spec == "" || spec == workloadUniform) {
+        return fmt.Errorf("the %s workload has no keys", workloadUniform)
+    }
+    if spec == "" || spec == workloadUniform || spec == workloadUsernames || spec == workloadEmails {
+        return nil
+    }
+    if strings.HasPrefix(spec, workloadTrace) && len(spec) > len(workloadTrace) {
+        return nil
+    }
+    return fmt.Errorf("unknown workload '%s'", spec)
+}
+
+/**
+ * Returns the source of the leaves of the workload 'spec' (the uniform one if empty).
+ */
+func newLeafSource(spec string, rawKeys bool, seed int64) (leafSource, error) {
+    if err := checkWorkload(spec, rawKeys); err != nil {
+        return nil, err
+    }
+
+    var keys func() string
+    switch {
+    case spec == "" || spec == workloadUniform:
+        return newLeafGenerator(seed), nil
+
+    case spec == workloadUsernames:
+        keys = _newUsernameGenerator(rand.New(rand.NewSource(seed)))
+
+    case spec == workloadEmails:
+        rng := rand.New(rand.NewSource(seed))
+        usernames := _newUsernameGenerator(rng)
+        domains := rand.NewZipf(rng, workloadZipfS, 1, uint64(len(workloadDomains)-1))
+        keys = func() string {
+            return usernames() + "@" + workloadDomains[domains.Uint64()]
+        }
+
+    default:
+        trace, err := _readTrace(strings.TrimPrefix(spec, workloadTrace))
+        if err != nil {
+            return nil, err
+        }
+        keys = func() string {
+            if len(trace) == 0 {
+                panic(fmt.Sprintf("Ran out of keys in trace file of workload '%s'", spec))
+            }
+            key := trace[0]
+            trace = trace[1:]
+            return key
+        }
+    }
+
+    var mapper KeyMapper = HashKeyMapper{}
+    if rawKeys {
+        mapper = PrefixKeyMapper{Truncate: true}
+    }
+    return &keyedLeafSource{keys: keys, mapper: mapper, rawKeys: rawKeys, seen: make(map[[32]byte]bool)}, nil
+}
+
+/**
+ * Returns a function that generates distinct usernames: a Zipf-distributed first name, followed
+ * by the # of times it was generated before (if any).
+ */
+func _newUsernameGenerator(rng *rand.Rand) func() string {
+    names := rand.NewZipf(rng, workloadZipfS, 1, uint64(len(workloadFirstNames)-1))
+    counts := make(map[string]int)
+    return func() string {
+        name := workloadFirstNames[names.Uint64()]
+        count := counts[name]
+        counts[name]++
+        if count == 0 {
+            return name
+        }
+        return fmt.Sprintf("%s%d", name, count)
+    }
+}
+
+/**
+ * Reads the distinct keys of a trace file, in order.
+ */
+func _readTrace(path string) ([]string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var keys []string
+    seen := make(map[string]bool)
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        key := strings.TrimSpace(scanner.Text())
+        if key == "" || strings.HasPrefix(key, "#") || seen[key] {
+            continue
+        }
+        seen[key] = true
+        keys = append(keys, key)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(keys) == 0 {
+        return nil, fmt.Errorf("trace file %s has no keys", path)
+    }
+    return keys, nil
+}
+
+/**
+ * The leaves of a workload of keys, whose data hashes are derived from their leaf no's as in
+ * leafGenerator.
+ */
+type keyedLeafSource struct {
+    keys    func() string
+    mapper  KeyMapper // hashes keys, or uses them as leaf no's if they are raw
+    rawKeys bool
+    seen    map[[32]byte]bool // the leaf no's returned so far, only with raw keys
+}
+
+func (src *keyedLeafSource) next() ([32]byte, [32]byte) {
+    for {
+        key := src.keys()
+
+        leafNo, _ := src.mapper.MapKey([]byte(key), maxNumLevels)
+        if src.rawKeys {
+            if src.seen[leafNo] {
+                continue
+            }
+            src.seen[leafNo] = true
+        }
+
+        dataHash := sha256.Sum256([]byte(fmt.Sprintf("Data for leaf %v", hashStr(leafNo))))
+        return leafNo, dataHash
+    }
+}